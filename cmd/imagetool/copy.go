@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/copy"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/transports/alltransports"
+)
+
+// copyCmd implements imagetool copy.
+func copyCmd(ctx context.Context, global *globalOptions, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	signBy := flags.String("sign-by", "", "Sign the image using a GPG key with the specified `FINGERPRINT`")
+	removeSignatures := flags.Bool("remove-signatures", false, "Do not copy signatures from SOURCE")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("Usage: imagetool copy %s", commands["copy"].usage)
+	}
+	srcRef, err := alltransports.ParseImageName(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Invalid source name %q: %v", flags.Arg(0), err)
+	}
+	destRef, err := alltransports.ParseImageName(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("Invalid destination name %q: %v", flags.Arg(1), err)
+	}
+	sys := global.systemContext()
+
+	policyContext, err := global.policyContext()
+	if err != nil {
+		return err
+	}
+	defer policyContext.Destroy()
+
+	return copy.Image(ctx, policyContext, destRef, srcRef, &copy.Options{
+		RemoveSignatures: *removeSignatures,
+		SignBy:           *signBy,
+		ReportWriter:     stdout,
+		SourceCtx:        sys,
+		DestinationCtx:   sys,
+	})
+}
+
+// policyContext returns a *signature.PolicyContext to use for verifying images, as configured by opts.
+func (opts *globalOptions) policyContext() (*signature.PolicyContext, error) {
+	var policy *signature.Policy
+	if opts.insecurePolicy {
+		policy = &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}
+	} else {
+		p, err := signature.DefaultPolicy(opts.systemContext())
+		if err != nil {
+			return nil, fmt.Errorf("Error loading the signature verification policy: %v", err)
+		}
+		policy = p
+	}
+	return signature.NewPolicyContext(policy)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/transports/alltransports"
+	"github.com/opencontainers/go-digest"
+)
+
+// inspectOutput is the output format of imagetool inspect.
+type inspectOutput struct {
+	Name          string
+	Tag           string `json:",omitempty"`
+	Digest        digest.Digest
+	MediaType     string
+	Created       time.Time
+	DockerVersion string
+	Labels        map[string]string
+	Architecture  string
+	Os            string
+	Layers        []digest.Digest
+}
+
+// inspectCmd implements imagetool inspect.
+func inspectCmd(ctx context.Context, global *globalOptions, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	raw := flags.Bool("raw", false, "Print the raw manifest instead of a summary")
+	config := flags.Bool("config", false, "Print the raw image configuration instead of a summary")
+	manifestOnly := flags.Bool("manifest-only", false, "Print only the information available in the manifest, without reading the image configuration")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Usage: imagetool inspect %s", commands["inspect"].usage)
+	}
+	ref, err := alltransports.ParseImageName(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Invalid image name %q: %v", flags.Arg(0), err)
+	}
+	sys := global.systemContext()
+
+	if *raw {
+		src, err := ref.NewImageSource(ctx, sys)
+		if err != nil {
+			return fmt.Errorf("Error opening %s: %v", transports.ImageName(ref), err)
+		}
+		defer src.Close()
+		manifestBlob, _, err := src.GetManifest(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("Error reading manifest of %s: %v", transports.ImageName(ref), err)
+		}
+		_, err = stdout.Write(manifestBlob)
+		return err
+	}
+
+	img, err := ref.NewImage(ctx, sys)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %v", transports.ImageName(ref), err)
+	}
+	defer img.Close()
+
+	if *config {
+		configBlob, err := img.ConfigBlob(ctx)
+		if err != nil {
+			return fmt.Errorf("Error reading configuration of %s: %v", transports.ImageName(ref), err)
+		}
+		_, err = stdout.Write(configBlob)
+		return err
+	}
+
+	if *manifestOnly {
+		info, err := image.InspectManifest(ctx, img)
+		if err != nil {
+			return fmt.Errorf("Error inspecting %s: %v", transports.ImageName(ref), err)
+		}
+		return printJSON(stdout, info)
+	}
+
+	manifestBlob, mimeType, err := img.Manifest(ctx)
+	if err != nil {
+		return fmt.Errorf("Error reading manifest of %s: %v", transports.ImageName(ref), err)
+	}
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	if err != nil {
+		return fmt.Errorf("Error computing manifest digest of %s: %v", transports.ImageName(ref), err)
+	}
+	info, err := img.Inspect(ctx)
+	if err != nil {
+		return fmt.Errorf("Error inspecting %s: %v", transports.ImageName(ref), err)
+	}
+	out := inspectOutput{
+		Name:          transports.ImageName(ref),
+		Tag:           info.Tag,
+		Digest:        manifestDigest,
+		MediaType:     mimeType,
+		Created:       info.Created,
+		DockerVersion: info.DockerVersion,
+		Labels:        info.Labels,
+		Architecture:  info.Architecture,
+		Os:            info.Os,
+		Layers:        []digest.Digest{},
+	}
+	for _, layer := range img.LayerInfos() {
+		out.Layers = append(out.Layers, layer.Digest)
+	}
+	return printJSON(stdout, out)
+}
+
+// printJSON writes an indented JSON representation of v to stdout.
+func printJSON(stdout io.Writer, v interface{}) error {
+	outBlob, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s\n", outBlob)
+	return err
+}
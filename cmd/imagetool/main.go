@@ -0,0 +1,110 @@
+// imagetool is a small command-line tool using the library to inspect and copy images:
+//
+//	imagetool inspect docker://busybox:latest
+//	imagetool copy docker://busybox:latest dir:/tmp/busybox
+//
+// Images are specified as TRANSPORT:DETAILS, as accepted by alltransports.ParseImageName.
+// It is primarily an example of using the library, and a harness for integration tests.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/image/types"
+)
+
+// globalOptions are the options accepted before the command name.
+type globalOptions struct {
+	policyPath      string // If not "", the signature verification policy to use instead of the system default
+	insecurePolicy  bool   // Accept any image, ignoring the signature verification policy
+	registriesDir   string // If not "", the registries.d directory to use instead of the system default
+	authFile        string // If not "", the registry credentials file to use instead of the default
+	tlsVerify       bool   // Require HTTPS and verify certificates when talking to registries
+	certDir         string // If not "", a directory containing certificates and keys used when talking to registries
+	overrideArch    string // If not "", the architecture to choose from manifest lists instead of the current one
+	overrideOS      string // If not "", the OS to choose from manifest lists instead of the current one
+	overrideVariant string // If not "", the architecture variant to choose from manifest lists instead of the current one
+}
+
+// systemContext returns a *types.SystemContext corresponding to opts.
+func (opts *globalOptions) systemContext() *types.SystemContext {
+	return &types.SystemContext{
+		SignaturePolicyPath:         opts.policyPath,
+		RegistriesDirPath:           opts.registriesDir,
+		AuthFilePath:                opts.authFile,
+		DockerInsecureSkipTLSVerify: types.NewOptionalBool(!opts.tlsVerify),
+		DockerCertPath:              opts.certDir,
+		ArchitectureChoice:          opts.overrideArch,
+		OSChoice:                    opts.overrideOS,
+		VariantChoice:               opts.overrideVariant,
+	}
+}
+
+// command is a subcommand of imagetool.
+type command struct {
+	usage       string // Arguments following the command name, e.g. "SOURCE DESTINATION"
+	description string
+	// run executes the command with args following the command name, writing output to stdout and usage information to stderr.
+	run func(ctx context.Context, global *globalOptions, args []string, stdout, stderr io.Writer) error
+}
+
+// commands are the supported commands, indexed by name.
+var commands map[string]command
+
+func init() {
+	// This is not a plain initializer, because the commands refer to commands for their usage.
+	commands = map[string]command{
+		"inspect": {"[--raw] [--config] [--manifest-only] IMAGE", "Print information about IMAGE", inspectCmd},
+		"copy":    {"[--sign-by KEY] [--remove-signatures] SOURCE DESTINATION", "Copy an image from SOURCE to DESTINATION", copyCmd},
+	}
+}
+
+// run executes imagetool with args (not including the program name), writing output to stdout and usage information to stderr.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	global := globalOptions{}
+	flags := flag.NewFlagSet("imagetool", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&global.policyPath, "policy", "", "Path to a signature verification policy file")
+	flags.BoolVar(&global.insecurePolicy, "insecure-policy", false, "Accept any image, ignoring the signature verification policy")
+	flags.StringVar(&global.registriesDir, "registries.d", "", "Use registry configuration files in `DIR` (e.g. for signature storage)")
+	flags.StringVar(&global.authFile, "authfile", "", "Path to the registry credentials file")
+	flags.BoolVar(&global.tlsVerify, "tls-verify", true, "Require HTTPS and verify certificates when talking to registries")
+	flags.StringVar(&global.certDir, "cert-dir", "", "Use certificates at `DIR` (*.crt, *.cert, *.key) to connect to registries")
+	flags.StringVar(&global.overrideArch, "override-arch", "", "Use `ARCH` instead of the architecture of the machine for choosing images")
+	flags.StringVar(&global.overrideOS, "override-os", "", "Use `OS` instead of the running OS for choosing images")
+	flags.StringVar(&global.overrideVariant, "override-variant", "", "Use `VARIANT` instead of the running architecture variant for choosing images")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: imagetool [OPTIONS] COMMAND [ARGS]\n\nCommands:\n")
+		for _, name := range []string{"inspect", "copy"} {
+			fmt.Fprintf(stderr, "  %s %s\n    \t%s\n", name, commands[name].usage, commands[name].description)
+		}
+		fmt.Fprintf(stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("No command specified")
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		return fmt.Errorf("Unknown command %q", flags.Arg(0))
+	}
+	return cmd.run(ctx, &global, flags.Args()[1:], stdout, stderr)
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "imagetool: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
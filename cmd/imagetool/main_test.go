@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestDirImage creates a single-layer image in dir, using the dir: transport, and returns its manifest.
+func writeTestDirImage(t *testing.T, dir string) []byte {
+	config := []byte(`{"architecture":"amd64","os":"linux","config":{"Labels":{"name":"test"}},"rootfs":{"type":"layers","diff_ids":[]}}`)
+	layer := []byte("This is not really a layer")
+	manifestBlob := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s",`+
+		`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":%d,"digest":"%s"},`+
+		`"layers":[{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","size":%d,"digest":"%s"}]}`,
+		manifest.DockerV2Schema2MediaType, len(config), digest.FromBytes(config), len(layer), digest.FromBytes(layer)))
+	for name, contents := range map[string][]byte{
+		"version":                      []byte("Directory Transport Version: 1.0\n"),
+		"manifest.json":                manifestBlob,
+		digest.FromBytes(config).Hex(): config,
+		digest.FromBytes(layer).Hex():  layer,
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), contents, 0644)
+		require.NoError(t, err)
+	}
+	return manifestBlob
+}
+
+func TestRun(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "imagetool")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	srcDir := filepath.Join(tmpDir, "src")
+	destDir := filepath.Join(tmpDir, "dest")
+	for _, dir := range []string{srcDir, destDir} {
+		err := os.Mkdir(dir, 0755)
+		require.NoError(t, err)
+	}
+	manifestBlob := writeTestDirImage(t, srcDir)
+
+	run := func(args ...string) (string, error) {
+		stdout := bytes.Buffer{}
+		err := run(context.Background(), args, &stdout, ioutil.Discard)
+		return stdout.String(), err
+	}
+
+	// Copying requires a policy accepting the image.
+	_, err = run("--policy", filepath.Join(tmpDir, "this-does-not-exist"), "copy", "dir:"+srcDir, "dir:"+destDir)
+	assert.Error(t, err)
+	_, err = run("--insecure-policy", "copy", "dir:"+srcDir, "dir:"+destDir)
+	require.NoError(t, err)
+
+	out, err := run("inspect", "--raw", "dir:"+destDir)
+	require.NoError(t, err)
+	assert.Equal(t, string(manifestBlob), out)
+
+	out, err = run("inspect", "dir:"+destDir)
+	require.NoError(t, err)
+	var parsed inspectOutput
+	err = json.Unmarshal([]byte(out), &parsed)
+	require.NoError(t, err)
+	assert.Equal(t, "dir:"+destDir, parsed.Name)
+	assert.Equal(t, digest.FromBytes(manifestBlob), parsed.Digest)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, parsed.MediaType)
+	assert.Equal(t, map[string]string{"name": "test"}, parsed.Labels)
+	assert.Equal(t, "amd64", parsed.Architecture)
+	assert.Equal(t, []digest.Digest{digest.FromBytes([]byte("This is not really a layer"))}, parsed.Layers)
+
+	out, err = run("inspect", "--manifest-only", "dir:"+destDir)
+	require.NoError(t, err)
+	var manifestInfo image.ManifestInspectInfo
+	err = json.Unmarshal([]byte(out), &manifestInfo)
+	require.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(manifestBlob), manifestInfo.Digest)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, manifestInfo.MIMEType)
+	require.Len(t, manifestInfo.Layers, 1)
+	assert.Equal(t, digest.FromBytes([]byte("This is not really a layer")), manifestInfo.Layers[0].Digest)
+
+	out, err = run("inspect", "--config", "dir:"+destDir)
+	require.NoError(t, err)
+	assert.Contains(t, out, `"Labels":{"name":"test"}`)
+
+	// Invalid usage is rejected.
+	for _, args := range [][]string{
+		{},
+		{"unknown-command"},
+		{"--unknown-option", "inspect", "dir:" + srcDir},
+		{"inspect"},
+		{"inspect", "dir:" + srcDir, "dir:" + destDir},
+		{"inspect", "this is not a valid image name"},
+		{"copy", "dir:" + srcDir},
+		{"copy", "this is not a valid image name", "dir:" + destDir},
+		{"--insecure-policy", "copy", "dir:" + srcDir, "this is not a valid image name"},
+	} {
+		_, err := run(args...)
+		assert.Error(t, err, "%#v", args)
+	}
+}
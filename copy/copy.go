@@ -3,25 +3,32 @@ package copy
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/sha256"
+	"context"
 	"crypto/subtle"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"io/ioutil"
 	"reflect"
 	"strings"
+	"sync"
 
 	pb "gopkg.in/cheggaaa/pb.v1"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/image"
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/pkg/logging"
+	"github.com/containers/image/pkg/metrics"
+	"github.com/containers/image/pkg/tracing"
+	"github.com/containers/image/pkg/zstdchunked"
 	"github.com/containers/image/signature"
 	"github.com/containers/image/transports"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // preferredManifestMIMETypes lists manifest MIME types in order of our preference, if we can't use the original manifest and need to convert.
@@ -29,40 +36,22 @@ import (
 // Include v2s1 signed but not v2s1 unsigned, because docker/distribution requires a signature even if the unsigned MIME type is used.
 var preferredManifestMIMETypes = []string{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType}
 
-// supportedDigests lists the supported blob digest types.
-var supportedDigests = map[string]func() hash.Hash{
-	"sha256": sha256.New,
-}
-
 type digestingReader struct {
 	source           io.Reader
-	digest           hash.Hash
-	expectedDigest   []byte
+	digester         digest.Digester
+	expectedDigest   digest.Digest
 	validationFailed bool
 }
 
 // newDigestingReader returns an io.Reader implementation with contents of source, which will eventually return a non-EOF error
-// and set validationFailed to true if the source stream does not match expectedDigestString.
-func newDigestingReader(source io.Reader, expectedDigestString string) (*digestingReader, error) {
-	fields := strings.SplitN(expectedDigestString, ":", 2)
-	if len(fields) != 2 {
-		return nil, fmt.Errorf("Invalid digest specification %s", expectedDigestString)
-	}
-	fn, ok := supportedDigests[fields[0]]
-	if !ok {
-		return nil, fmt.Errorf("Invalid digest specification %s: unknown digest type %s", expectedDigestString, fields[0])
-	}
-	digest := fn()
-	expectedDigest, err := hex.DecodeString(fields[1])
-	if err != nil {
-		return nil, fmt.Errorf("Invalid digest value %s: %v", expectedDigestString, err)
-	}
-	if len(expectedDigest) != digest.Size() {
-		return nil, fmt.Errorf("Invalid digest specification %s: length %d does not match %d", expectedDigestString, len(expectedDigest), digest.Size())
+// and set validationFailed to true if the source stream does not match expectedDigest.
+func newDigestingReader(source io.Reader, expectedDigest digest.Digest) (*digestingReader, error) {
+	if err := expectedDigest.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid digest specification %s: %v", expectedDigest, err)
 	}
 	return &digestingReader{
 		source:           source,
-		digest:           digest,
+		digester:         expectedDigest.Algorithm().Digester(),
 		expectedDigest:   expectedDigest,
 		validationFailed: false,
 	}, nil
@@ -71,191 +60,499 @@ func newDigestingReader(source io.Reader, expectedDigestString string) (*digesti
 func (d *digestingReader) Read(p []byte) (int, error) {
 	n, err := d.source.Read(p)
 	if n > 0 {
-		if n2, err := d.digest.Write(p[:n]); n2 != n || err != nil {
+		if n2, err := d.digester.Hash().Write(p[:n]); n2 != n || err != nil {
 			// Coverage: This should not happen, the hash.Hash interface requires
-			// d.digest.Write to never return an error, and the io.Writer interface
+			// d.digester.Hash().Write to never return an error, and the io.Writer interface
 			// requires n2 == len(input) if no error is returned.
 			return 0, fmt.Errorf("Error updating digest during verification: %d vs. %d, %v", n2, n, err)
 		}
 	}
 	if err == io.EOF {
-		actualDigest := d.digest.Sum(nil)
-		if subtle.ConstantTimeCompare(actualDigest, d.expectedDigest) != 1 {
+		actualDigest := d.digester.Digest()
+		if subtle.ConstantTimeCompare([]byte(actualDigest), []byte(d.expectedDigest)) != 1 {
 			d.validationFailed = true
-			return 0, fmt.Errorf("Digest did not match, expected %s, got %s", hex.EncodeToString(d.expectedDigest), hex.EncodeToString(actualDigest))
+			return 0, fmt.Errorf("Digest did not match, expected %s, got %s", d.expectedDigest, actualDigest)
 		}
 	}
 	return n, err
 }
 
+// countingReader is an io.Reader counting the bytes read from reader.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+// Read implements io.Reader.
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
 // Options allows supplying non-default configuration modifying the behavior of CopyImage.
 type Options struct {
 	RemoveSignatures bool   // Remove any pre-existing signatures. SignBy will still add a new signature.
 	SignBy           string // If non-empty, asks for a signature to be added during the copy, and specifies a key ID, as accepted by signature.NewGPGSigningMechanism().SignDockerManifest(),
-	ReportWriter     io.Writer
+	// Signatures to be added to the destination, e.g. created offline from the source manifest (as returned by types.Image.Manifest) and its signatures
+	// exported using types.Image.Signatures.  The manifest will not be modified during the copy, so that the signatures stay valid.
+	AdditionalSignatures [][]byte
+	// SignPassphraseCallback, if not nil, provides the passphrase of the SignBy private key, without interacting with the user.
+	SignPassphraseCallback signature.PassphraseCallback
+	ReportWriter           io.Writer
+	SourceCtx              *types.SystemContext // Configuration for reading the source image, e.g. credentials or an OS/architecture choice; may be nil.
+	DestinationCtx         *types.SystemContext // Configuration for writing the destination image; may be nil.
+	// CompressionFormat is the algorithm used when compressing layers: types.GzipCompression (the default if empty),
+	// or types.ZstdChunkedCompression, which requires the destination to use OCI manifests.
+	CompressionFormat string
+	// Logger, if not nil, receives diagnostic messages about the copy.  If nil, the Logger of DestinationCtx or SourceCtx is used, if any.
+	Logger types.Logger
+	// Tracer, if not nil, records spans for the copy and its steps.  If nil, the Tracer of DestinationCtx or SourceCtx is used, if any.
+	Tracer types.Tracer
+	// Metrics, if not nil, receives statistics about the copied and reused blobs.  If nil, the Metrics of DestinationCtx or SourceCtx is used, if any.
+	Metrics types.Metrics
+	// Signer, if not nil, asks for a sigstore signature to be added during the copy, created using Signer;
+	// the destination must implement types.SigstoreSignaturesDestination.
+	Signer signature.Signer
+	// SignIdentity, if not nil, is the identity recorded in signatures added using SignBy or Signer, instead of the Docker reference
+	// of the destination; it is required for destinations without a Docker reference, e.g. dir: or oci:.
+	SignIdentity reference.Named
+}
+
+// logger returns the types.Logger to use for diagnostic messages about a copy using options.
+func (options *Options) logger() types.Logger {
+	if options.Logger != nil {
+		return options.Logger
+	}
+	if options.DestinationCtx != nil && options.DestinationCtx.Logger != nil {
+		return options.DestinationCtx.Logger
+	}
+	return logging.ForSystemContext(options.SourceCtx)
+}
+
+// tracer returns the types.Tracer to use for spans of a copy using options.
+func (options *Options) tracer() types.Tracer {
+	if options.Tracer != nil {
+		return options.Tracer
+	}
+	if options.DestinationCtx != nil && options.DestinationCtx.Tracer != nil {
+		return options.DestinationCtx.Tracer
+	}
+	return tracing.ForSystemContext(options.SourceCtx)
+}
+
+// metrics returns the types.Metrics to use for statistics about a copy using options.
+func (options *Options) metrics() types.Metrics {
+	if options.Metrics != nil {
+		return options.Metrics
+	}
+	if options.DestinationCtx != nil && options.DestinationCtx.Metrics != nil {
+		return options.DestinationCtx.Metrics
+	}
+	return metrics.ForSystemContext(options.SourceCtx)
 }
 
 // Image copies image from srcRef to destRef, using policyContext to validate source image admissibility.
-func Image(ctx *types.SystemContext, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) error {
+func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) (retErr error) {
+	if options == nil {
+		options = &Options{}
+	}
+	tracer := options.tracer()
+	ctx, span := tracer.Start(ctx, "copy.Image", map[string]string{"source": transports.ImageName(srcRef), "destination": transports.ImageName(destRef)})
+	defer func() { span.End(retErr) }()
 	reportWriter := ioutil.Discard
-	if options != nil && options.ReportWriter != nil {
+	if options.ReportWriter != nil {
 		reportWriter = options.ReportWriter
 	}
 	writeReport := func(f string, a ...interface{}) {
 		fmt.Fprintf(reportWriter, f, a...)
 	}
+	logger := options.logger()
+	transferMetrics := options.metrics()
 
-	dest, err := destRef.NewImageDestination(ctx)
+	compressionFormat := options.CompressionFormat
+	switch compressionFormat {
+	case "":
+		compressionFormat = types.GzipCompression
+	case types.GzipCompression, types.ZstdChunkedCompression:
+	default:
+		return fmt.Errorf("Unsupported layer compression format %q", compressionFormat)
+	}
+
+	dest, err := destRef.NewImageDestination(ctx, options.DestinationCtx)
 	if err != nil {
 		return fmt.Errorf("Error initializing destination %s: %v", transports.ImageName(destRef), err)
 	}
 	defer dest.Close()
 	destSupportedManifestMIMETypes := dest.SupportedManifestMIMETypes()
 
-	rawSource, err := srcRef.NewImageSource(ctx, destSupportedManifestMIMETypes)
+	// The source provides the manifest in whatever format it has; any necessary conversion is done by determineManifestConversion below.
+	rawSource, err := srcRef.NewImageSource(ctx, options.SourceCtx)
 	if err != nil {
 		return fmt.Errorf("Error initializing source %s: %v", transports.ImageName(srcRef), err)
 	}
 	unparsedImage := image.UnparsedFromSource(rawSource)
-	defer func() {
-		if unparsedImage != nil {
-			unparsedImage.Close()
-		}
-	}()
+	defer unparsedImage.Close()
 
 	// Please keep this policy check BEFORE reading any other information about the image.
-	if allowed, err := policyContext.IsRunningImageAllowed(unparsedImage); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
+	if allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsedImage); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
 		return fmt.Errorf("Source image rejected: %v", err)
 	}
-	src, err := image.FromUnparsedImage(unparsedImage)
+	src, err := image.FromUnparsedImage(ctx, options.SourceCtx, unparsedImage)
 	if err != nil {
 		return fmt.Errorf("Error initializing image from source %s: %v", transports.ImageName(srcRef), err)
 	}
-	unparsedImage = nil
-	defer src.Close()
 
 	if src.IsMultiImage() {
 		return fmt.Errorf("can not copy %s: manifest contains multiple images", transports.ImageName(srcRef))
 	}
 
 	var sigs [][]byte
-	if options != nil && options.RemoveSignatures {
+	if options.RemoveSignatures {
 		sigs = [][]byte{}
 	} else {
 		writeReport("Getting image source signatures\n")
-		s, err := src.Signatures()
+		s, err := src.Signatures(ctx)
 		if err != nil {
 			return fmt.Errorf("Error reading signatures: %v", err)
 		}
 		sigs = s
 	}
+	if len(options.AdditionalSignatures) != 0 {
+		sigs = append(append([][]byte{}, sigs...), options.AdditionalSignatures...)
+	}
 	if len(sigs) != 0 {
 		writeReport("Checking if image destination supports signatures\n")
-		if err := dest.SupportsSignatures(); err != nil {
+		if err := dest.SupportsSignatures(ctx); err != nil {
 			return fmt.Errorf("Can not copy signatures: %v", err)
 		}
 	}
+	sigstoreDest, supportsSigstore := dest.(types.SigstoreSignaturesDestination)
+	if options.Signer != nil && !supportsSigstore {
+		return fmt.Errorf("Can not add a sigstore signature: destination %s does not support sigstore signatures", transports.ImageName(destRef))
+	}
 
 	canModifyManifest := len(sigs) == 0
-	manifestUpdates := types.ManifestUpdateOptions{}
+	manifestUpdates := types.ManifestUpdateOptions{InformationOnly: types.ManifestUpdateInformation{Logger: logger}}
 
-	if err := determineManifestConversion(&manifestUpdates, src, destSupportedManifestMIMETypes, canModifyManifest); err != nil {
+	manifestMIMETypes, err := manifestMIMETypesSupportingCompression(destSupportedManifestMIMETypes, compressionFormat)
+	if err != nil {
+		return fmt.Errorf("Error copying to %s: %v", transports.ImageName(destRef), err)
+	}
+	preferredManifestMIMEType, otherManifestMIMETypeCandidates, err := determineManifestConversion(ctx, &manifestUpdates, src, manifestMIMETypes, canModifyManifest, logger)
+	if err != nil {
 		return err
 	}
 
-	if err := copyLayers(&manifestUpdates, dest, src, rawSource, canModifyManifest, reportWriter); err != nil {
+	// A short-lived cache, so that sources can find blobs which have already been located during this copy.
+	cache := blobinfocache.NewMemoryCache()
+	layersDest := dest
+	pendingImage, manifestUpdates, err := copyLayersAndUpdateImage(ctx, manifestUpdates, layersDest, src, rawSource, cache, canModifyManifest, compressionFormat, reportWriter, logger, tracer, transferMetrics)
+	if _, ok := err.(manifest.ManifestLayerCompressionIncompatibilityError); ok && dest.DesiredLayerCompression() != types.Compress {
+		logger.Debugf("Updating the manifest failed: %v", err)
+		writeReport("Layers can not be used as stored in the chosen manifest format, compressing them\n")
+		layersDest = compressingDestination{dest}
+		pendingImage, manifestUpdates, err = copyLayersAndUpdateImage(ctx, manifestUpdates, layersDest, src, rawSource, cache, canModifyManifest, compressionFormat, reportWriter, logger, tracer, transferMetrics)
+	}
+	if err != nil {
+		if _, ok := err.(manifest.ManifestLayerCompressionIncompatibilityError); ok {
+			return fmt.Errorf("Error creating an updated image manifest: %v", err)
+		}
 		return err
 	}
 
-	pendingImage := src
-	if !reflect.DeepEqual(manifestUpdates, types.ManifestUpdateOptions{InformationOnly: manifestUpdates.InformationOnly}) {
-		if !canModifyManifest {
-			return fmt.Errorf("Internal error: copy needs an updated manifest but that was known to be forbidden")
+	finalSigs, sigstoreSigs, err := writeConfigAndManifest(ctx, dest, pendingImage, sigs, options, reportWriter, logger, tracer, transferMetrics)
+	if err != nil {
+		// If the destination has rejected the manifest type, we may be able to convert the manifest to another one.
+		var rejected types.ManifestTypeRejectedError
+		if !errors.As(err, &rejected) || len(otherManifestMIMETypeCandidates) == 0 {
+			return err
 		}
-		manifestUpdates.InformationOnly.Destination = dest
-		pendingImage, err = src.UpdatedImage(manifestUpdates)
-		if err != nil {
-			return fmt.Errorf("Error creating an updated image manifest: %v", err)
+		logger.Debugf("Writing manifest using preferred type %s failed: %v", preferredManifestMIMEType, err)
+		// attempts lists the failures with the various manifest types; it is set to nil once an upload succeeds.
+		attempts := []string{fmt.Sprintf("%s(%v)", preferredManifestMIMEType, err)}
+		for _, manifestMIMEType := range otherManifestMIMETypeCandidates {
+			writeReport("Manifest type rejected by the destination, trying %s\n", manifestMIMEType)
+			manifestUpdates.ManifestMIMEType = manifestMIMEType
+			attemptedImage, err := updatedImage(ctx, manifestUpdates, layersDest, src, canModifyManifest, tracer)
+			if err == nil {
+				finalSigs, sigstoreSigs, err = writeConfigAndManifest(ctx, dest, attemptedImage, sigs, options, reportWriter, logger, tracer, transferMetrics)
+			}
+			if err != nil {
+				logger.Debugf("Writing manifest using type %s failed: %v", manifestMIMEType, err)
+				attempts = append(attempts, fmt.Sprintf("%s(%v)", manifestMIMEType, err))
+				continue
+			}
+			attempts = nil
+			break
+		}
+		if attempts != nil {
+			return fmt.Errorf("Error writing manifest, attempted the following formats: %s", strings.Join(attempts, ", "))
+		}
+	}
+
+	writeReport("Storing signatures\n")
+	if err := dest.PutSignatures(ctx, finalSigs, nil); err != nil {
+		return fmt.Errorf("Error writing signatures: %v", err)
+	}
+	if len(sigstoreSigs) != 0 {
+		if err := sigstoreDest.PutSigstoreSignatures(ctx, sigstoreSigs); err != nil {
+			return fmt.Errorf("Error writing sigstore signatures: %v", err)
 		}
 	}
-	manifest, _, err := pendingImage.Manifest()
+
+	if err := dest.Commit(ctx); err != nil {
+		return fmt.Errorf("Error committing the finished image: %v", err)
+	}
+
+	return nil
+}
+
+// copyLayersAndUpdateImage copies layers from src/rawSource to dest, and returns src updated according to manifestUpdates
+// and the copied layers, if necessary and canModifyManifest, together with manifestUpdates amended to reflect the copied layers.
+// Layers are compressed, if necessary, using compressionFormat.
+// A manifest.ManifestLayerCompressionIncompatibilityError from types.Image.UpdatedImage is returned unmodified.
+func copyLayersAndUpdateImage(ctx context.Context, manifestUpdates types.ManifestUpdateOptions, dest types.ImageDestination, src types.Image, rawSource types.ImageSource,
+	cache types.BlobInfoCache, canModifyManifest bool, compressionFormat string, reportWriter io.Writer, logger types.Logger, tracer types.Tracer,
+	transferMetrics types.Metrics) (types.Image, types.ManifestUpdateOptions, error) {
+	originalUpdates := manifestUpdates
+	if err := copyLayers(ctx, &manifestUpdates, dest, src, rawSource, cache, canModifyManifest, compressionFormat, reportWriter, logger, tracer, transferMetrics); err != nil {
+		return nil, originalUpdates, err
+	}
+	updated, err := updatedImage(ctx, manifestUpdates, dest, src, canModifyManifest, tracer)
 	if err != nil {
-		return fmt.Errorf("Error reading manifest: %v", err)
+		return nil, originalUpdates, err
 	}
+	return updated, manifestUpdates, nil
+}
 
-	if err := copyConfig(dest, pendingImage, reportWriter); err != nil {
-		return err
+// updatedImage returns src updated according to manifestUpdates, which must already describe the layers copied to dest,
+// if necessary and canModifyManifest.
+// A manifest.ManifestLayerCompressionIncompatibilityError from types.Image.UpdatedImage is returned unmodified.
+func updatedImage(ctx context.Context, manifestUpdates types.ManifestUpdateOptions, dest types.ImageDestination, src types.Image, canModifyManifest bool,
+	tracer types.Tracer) (types.Image, error) {
+	if reflect.DeepEqual(manifestUpdates, types.ManifestUpdateOptions{InformationOnly: manifestUpdates.InformationOnly}) {
+		return src, nil
 	}
+	if !canModifyManifest {
+		return nil, fmt.Errorf("Internal error: copy needs an updated manifest but that was known to be forbidden")
+	}
+	manifestUpdates.InformationOnly.Destination = dest
+	updateCtx, span := tracer.Start(ctx, "copy.UpdateManifest", map[string]string{"mimeType": manifestUpdates.ManifestMIMEType})
+	updated, err := src.UpdatedImage(updateCtx, manifestUpdates)
+	span.End(err)
+	if err != nil {
+		if _, ok := err.(manifest.ManifestLayerCompressionIncompatibilityError); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Error creating an updated image manifest: %v", err)
+	}
+	return updated, nil
+}
 
-	if options != nil && options.SignBy != "" {
-		mech, err := signature.NewGPGSigningMechanism()
-		if err != nil {
-			return fmt.Errorf("Error initializing GPG: %v", err)
+// writeConfigAndManifest copies the config of img to dest, signs the manifest of img if requested by options, and writes the manifest to dest.
+// It returns sigs, together with the new signature if any, to be stored with the manifest, and the new sigstore signature, if any.
+// A types.ManifestTypeRejectedError from dest.PutManifest is preserved, so that the caller can retry using a different manifest type.
+func writeConfigAndManifest(ctx context.Context, dest types.ImageDestination, img types.Image, sigs [][]byte, options *Options, reportWriter io.Writer,
+	logger types.Logger, tracer types.Tracer, transferMetrics types.Metrics) ([][]byte, []types.SigstoreSignature, error) {
+	manifest, _, err := img.Manifest(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading manifest: %v", err)
+	}
+
+	if err := copyConfig(ctx, dest, img, reportWriter, logger, tracer, transferMetrics); err != nil {
+		return nil, nil, err
+	}
+
+	var sigstoreSigs []types.SigstoreSignature
+	if options.SignBy != "" || options.Signer != nil {
+		dockerReference := options.SignIdentity
+		if dockerReference == nil {
+			dockerReference = dest.Reference().DockerReference()
 		}
-		dockerReference := dest.Reference().DockerReference()
 		if dockerReference == nil {
-			return fmt.Errorf("Cannot determine canonical Docker reference for destination %s", transports.ImageName(dest.Reference()))
+			return nil, nil, fmt.Errorf("Cannot determine canonical Docker reference for destination %s", transports.ImageName(dest.Reference()))
 		}
 
-		writeReport("Signing manifest\n")
-		newSig, err := signature.SignDockerManifest(manifest, dockerReference.String(), mech, options.SignBy)
-		if err != nil {
-			return fmt.Errorf("Error creating signature: %v", err)
+		if options.SignBy != "" {
+			mech, err := signature.NewGPGSigningMechanism()
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error initializing GPG: %v", err)
+			}
+			if options.SignPassphraseCallback != nil {
+				mech, err = signature.NewSigningMechanismWithPassphrase(mech, options.SignPassphraseCallback)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			fmt.Fprintf(reportWriter, "Signing manifest\n")
+			newSig, err := signature.SignDockerManifest(manifest, dockerReference.String(), mech, options.SignBy)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating signature: %v", err)
+			}
+			sigs = append(append([][]byte{}, sigs...), newSig)
+		}
+		if options.Signer != nil {
+			fmt.Fprintf(reportWriter, "Creating a sigstore signature\n")
+			newSig, err := signature.SignDockerManifestWithSigner(manifest, dockerReference.String(), options.Signer)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error creating sigstore signature: %v", err)
+			}
+			sigstoreSigs = []types.SigstoreSignature{newSig}
 		}
-		sigs = append(sigs, newSig)
 	}
 
-	writeReport("Writing manifest to image destination\n")
-	if err := dest.PutManifest(manifest); err != nil {
-		return fmt.Errorf("Error writing manifest: %v", err)
+	fmt.Fprintf(reportWriter, "Writing manifest to image destination\n")
+	if err := dest.PutManifest(ctx, manifest, nil); err != nil {
+		return nil, nil, fmt.Errorf("Error writing manifest: %w", err)
 	}
+	return sigs, sigstoreSigs, nil
+}
 
-	writeReport("Storing signatures\n")
-	if err := dest.PutSignatures(sigs); err != nil {
-		return fmt.Errorf("Error writing signatures: %v", err)
-	}
+// compressingDestination is a types.ImageDestination which asks for all layers to be compressed, regardless of the preferences
+// of the wrapped destination; it is used when the layers, as stored by the wrapped destination, can not be represented in the manifest.
+type compressingDestination struct {
+	types.ImageDestination
+}
 
-	if err := dest.Commit(); err != nil {
-		return fmt.Errorf("Error committing the finished image: %v", err)
-	}
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d compressingDestination) DesiredLayerCompression() types.LayerCompression {
+	return types.Compress
+}
 
-	return nil
+// TryReusingBlob never reuses blobs, because a blob already present at the destination may be the uncompressed version
+// which is not usable.
+func (d compressingDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	return false, types.BlobInfo{}, nil
+}
+
+// maxParallelLayerCopies is the maximum number of layers copied concurrently, if both the source and the destination allow it.
+const maxParallelLayerCopies = 6
+
+// syncWriter is an io.Writer which serializes writes to the underlying writer, so that it can be shared by concurrent layer copies.
+type syncWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Write(p)
 }
 
 // copyLayers copies layers from src/rawSource to dest, using and updating manifestUpdates if necessary and canModifyManifest.
+// Layers are compressed, if necessary, using compressionFormat.
 // If src.UpdatedImageNeedsLayerDiffIDs(manifestUpdates) will be true, it needs to be true by the time this function is called.
-func copyLayers(manifestUpdates *types.ManifestUpdateOptions, dest types.ImageDestination, src types.Image, rawSource types.ImageSource,
-	canModifyManifest bool, reportWriter io.Writer) error {
+// Layers are copied concurrently if both rawSource.HasThreadSafeGetBlob() and dest.HasThreadSafePutBlob();
+// if only rawSource.HasThreadSafeGetBlob(), layers are copied one at a time, but the following layers are prefetched.
+func copyLayers(ctx context.Context, manifestUpdates *types.ManifestUpdateOptions, dest types.ImageDestination, src types.Image, rawSource types.ImageSource,
+	cache types.BlobInfoCache, canModifyManifest bool, compressionFormat string, reportWriter io.Writer, logger types.Logger, tracer types.Tracer,
+	transferMetrics types.Metrics) error {
 	type copiedLayer struct {
 		blobInfo types.BlobInfo
-		diffID   string
+		diffID   digest.Digest
 	}
 
 	diffIDsAreNeeded := src.UpdatedImageNeedsLayerDiffIDs(*manifestUpdates)
 
-	srcInfos := src.LayerInfos()
-	destInfos := []types.BlobInfo{}
-	diffIDs := []string{}
-	copiedLayers := map[string]copiedLayer{}
+	manifestInfos := src.LayerInfos()
+	srcInfos := manifestInfos
+	if s, ok := rawSource.(types.LayerInfosForCopySource); ok {
+		infos, err := s.LayerInfosForCopy(ctx)
+		if err != nil {
+			return fmt.Errorf("Error reading the layers of the source image: %v", err)
+		}
+		if infos != nil {
+			if len(infos) != len(manifestInfos) {
+				return fmt.Errorf("Internal error: the source provides %d layers, but the manifest refers to %d", len(infos), len(manifestInfos))
+			}
+			if layerDigestsDiffer(manifestInfos, infos) && !canModifyManifest {
+				return fmt.Errorf("Can not copy signatures: the source does not store the layers referenced by the manifest, so the manifest would have to be modified")
+			}
+			srcInfos = infos
+		}
+	}
+	if d, ok := dest.(types.LayerOrderDestination); ok {
+		d.NoteLayerOrder(srcInfos)
+	}
+	// Each distinct layer is only copied once.
+	copiedLayers := map[digest.Digest]*copiedLayer{}
+	layersToCopy := []types.BlobInfo{}
 	for _, srcLayer := range srcInfos {
-		cl, ok := copiedLayers[srcLayer.Digest]
-		if !ok {
-			fmt.Fprintf(reportWriter, "Copying blob %s\n", srcLayer.Digest)
-			destInfo, diffID, err := copyLayer(dest, rawSource, srcLayer, diffIDsAreNeeded, canModifyManifest, reportWriter)
+		if _, ok := copiedLayers[srcLayer.Digest]; !ok {
+			copiedLayers[srcLayer.Digest] = &copiedLayer{}
+			layersToCopy = append(layersToCopy, srcLayer)
+		}
+	}
+
+	parallelCopies := 1
+	if rawSource.HasThreadSafeGetBlob() && dest.HasThreadSafePutBlob() {
+		parallelCopies = maxParallelLayerCopies
+		reportWriter = &syncWriter{writer: reportWriter}
+	}
+	// After the first failure, no new copies are started, and the ones in progress are cancelled.
+	copyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	blobSource := rawSource
+	if parallelCopies == 1 && rawSource.HasThreadSafeGetBlob() {
+		prefetcher := newPrefetchingImageSource(copyCtx, rawSource, layersToCopy, cache)
+		defer prefetcher.discardUnused()
+		blobSource = prefetcher
+	}
+	var copyErr error // The first failure
+	var copyErrOnce sync.Once
+	semaphore := make(chan struct{}, parallelCopies)
+	var wg sync.WaitGroup
+	for _, srcLayer := range layersToCopy {
+		semaphore <- struct{}{}
+		if copyCtx.Err() != nil {
+			<-semaphore
+			break
+		}
+		wg.Add(1)
+		go func(srcLayer types.BlobInfo, cl *copiedLayer) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			blobInfo, diffID, err := copyLayer(copyCtx, dest, blobSource, srcLayer, cache, diffIDsAreNeeded, canModifyManifest, compressionFormat, reportWriter, logger, tracer, transferMetrics)
 			if err != nil {
-				return err
+				copyErrOnce.Do(func() { copyErr = err })
+				cancel()
+				return
+			}
+			*cl = copiedLayer{blobInfo: blobInfo, diffID: diffID}
+		}(srcLayer, copiedLayers[srcLayer.Digest])
+	}
+	wg.Wait()
+	if copyErr != nil {
+		return copyErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	destInfos := []types.BlobInfo{}
+	diffIDs := []digest.Digest{}
+	for _, srcLayer := range srcInfos {
+		cl := copiedLayers[srcLayer.Digest]
+		blobInfo := cl.blobInfo
+		if blobInfo.Digest == srcLayer.Digest && blobInfo.CompressionOperation == types.PreserveOriginal {
+			// The layer was stored as provided by the source, which may differ from the layer referenced by the manifest (see LayerInfosForCopySource).
+			blobInfo.CompressionOperation = srcLayer.CompressionOperation
+			if srcLayer.CompressionOperation != types.PreserveOriginal {
+				blobInfo.CompressionAlgorithm = srcLayer.CompressionAlgorithm
 			}
-			cl = copiedLayer{blobInfo: destInfo, diffID: diffID}
-			copiedLayers[srcLayer.Digest] = cl
 		}
-		destInfos = append(destInfos, cl.blobInfo)
+		destInfos = append(destInfos, blobInfo)
 		diffIDs = append(diffIDs, cl.diffID)
 	}
 	manifestUpdates.InformationOnly.LayerInfos = destInfos
 	if diffIDsAreNeeded {
 		manifestUpdates.InformationOnly.LayerDiffIDs = diffIDs
 	}
-	if layerDigestsDiffer(srcInfos, destInfos) {
+	if layerDigestsDiffer(manifestInfos, destInfos) {
 		manifestUpdates.LayerInfos = destInfos
 	}
 	return nil
@@ -275,15 +572,18 @@ func layerDigestsDiffer(a, b []types.BlobInfo) bool {
 }
 
 // copyConfig copies config.json, if any, from src to dest.
-func copyConfig(dest types.ImageDestination, src types.Image, reportWriter io.Writer) error {
+func copyConfig(ctx context.Context, dest types.ImageDestination, src types.Image, reportWriter io.Writer, logger types.Logger, tracer types.Tracer,
+	transferMetrics types.Metrics) (retErr error) {
 	srcInfo := src.ConfigInfo()
 	if srcInfo.Digest != "" {
+		ctx, span := tracer.Start(ctx, "copy.Config", map[string]string{"digest": srcInfo.Digest.String()})
+		defer func() { span.End(retErr) }()
 		fmt.Fprintf(reportWriter, "Copying config %s\n", srcInfo.Digest)
-		configBlob, err := src.ConfigBlob()
+		configBlob, err := src.ConfigBlob(ctx)
 		if err != nil {
 			return fmt.Errorf("Error reading config blob %s: %v", srcInfo.Digest, err)
 		}
-		destInfo, err := copyBlobFromStream(dest, bytes.NewReader(configBlob), srcInfo, nil, false, reportWriter)
+		destInfo, err := copyBlobFromStream(ctx, dest, bytes.NewReader(configBlob), srcInfo, nil, false, "", true, reportWriter, logger, transferMetrics)
 		if err != nil {
 			return err
 		}
@@ -297,22 +597,40 @@ func copyConfig(dest types.ImageDestination, src types.Image, reportWriter io.Wr
 // diffIDResult contains both a digest value and an error from diffIDComputationGoroutine.
 // We could also send the error through the pipeReader, but this more cleanly separates the copying of the layer and the DiffID computation.
 type diffIDResult struct {
-	digest string
+	digest digest.Digest
 	err    error
 }
 
-// copyLayer copies a layer with srcInfo (with known Digest and possibly known Size) in src to dest, perhaps compressing it if canCompress,
-// and returns a complete blobInfo of the copied layer, and a value for LayerDiffIDs if diffIDIsNeeded
-func copyLayer(dest types.ImageDestination, src types.ImageSource, srcInfo types.BlobInfo,
-	diffIDIsNeeded bool, canCompress bool, reportWriter io.Writer) (types.BlobInfo, string, error) {
-	srcStream, srcBlobSize, err := src.GetBlob(srcInfo.Digest) // We currently completely ignore srcInfo.Size throughout.
+// copyLayer copies a layer with srcInfo (with known Digest and possibly known Size) in src to dest, perhaps compressing it using compressionFormat if canCompress,
+// and returns a complete blobInfo of the copied layer, and a value for LayerDiffIDs if diffIDIsNeeded.
+// If the destination already contains the layer, or an equivalent one if canCompress, it is reused instead of being copied again.
+func copyLayer(ctx context.Context, dest types.ImageDestination, src types.ImageSource, srcInfo types.BlobInfo, cache types.BlobInfoCache,
+	diffIDIsNeeded bool, canCompress bool, compressionFormat string, reportWriter io.Writer, logger types.Logger, tracer types.Tracer,
+	transferMetrics types.Metrics) (_ types.BlobInfo, _ digest.Digest, retErr error) {
+	ctx, span := tracer.Start(ctx, "copy.Layer", map[string]string{"digest": srcInfo.Digest.String()})
+	defer func() { span.End(retErr) }()
+	// If we need the DiffID and don't know it, we must read the blob anyway, so don't bother checking for reuse.
+	if !diffIDIsNeeded || cache.UncompressedDigest(srcInfo.Digest) != "" {
+		reused, blobInfo, err := dest.TryReusingBlob(ctx, srcInfo, cache, canCompress)
+		if err != nil {
+			return types.BlobInfo{}, "", fmt.Errorf("Error trying to reuse blob %s at destination: %v", srcInfo.Digest, err)
+		}
+		if reused {
+			fmt.Fprintf(reportWriter, "Skipping fetch of repeat blob %s\n", srcInfo.Digest)
+			transferMetrics.BlobReused(srcInfo.Digest)
+			return blobInfo, cache.UncompressedDigest(srcInfo.Digest), nil
+		}
+	}
+
+	fmt.Fprintf(reportWriter, "Copying blob %s\n", srcInfo.Digest)
+	srcStream, srcBlobSize, err := src.GetBlob(ctx, srcInfo, cache) // We currently completely ignore srcInfo.Size throughout.
 	if err != nil {
 		return types.BlobInfo{}, "", fmt.Errorf("Error reading blob %s: %v", srcInfo.Digest, err)
 	}
 	defer srcStream.Close()
 
-	blobInfo, diffIDChan, err := copyLayerFromStream(dest, srcStream, types.BlobInfo{Digest: srcInfo.Digest, Size: srcBlobSize},
-		diffIDIsNeeded, canCompress, reportWriter)
+	blobInfo, diffIDChan, err := copyLayerFromStream(ctx, dest, srcStream, types.BlobInfo{Digest: srcInfo.Digest, Size: srcBlobSize, MediaType: srcInfo.MediaType},
+		diffIDIsNeeded, canCompress, compressionFormat, reportWriter, logger, transferMetrics)
 	if err != nil {
 		return types.BlobInfo{}, "", err
 	}
@@ -322,18 +640,21 @@ func copyLayer(dest types.ImageDestination, src types.ImageSource, srcInfo types
 		if diffIDResult.err != nil {
 			return types.BlobInfo{}, "", fmt.Errorf("Error computing layer DiffID: %v", diffIDResult.err)
 		}
-		logrus.Debugf("Computed DiffID %s for layer %s", diffIDResult.digest, srcInfo.Digest)
+		logger.Debugf("Computed DiffID %s for layer %s", diffIDResult.digest, srcInfo.Digest)
+		cache.RecordDigestUncompressedPair(srcInfo.Digest, diffIDResult.digest)
+		cache.RecordDigestUncompressedPair(blobInfo.Digest, diffIDResult.digest)
 	}
 	return blobInfo, diffIDResult.digest, nil
 }
 
 // copyLayerFromStream is an implementation detail of copyLayer; mostly providing a separate “defer” scope.
 // it copies a blob with srcInfo (with known Digest and possibly known Size) from srcStream to dest,
-// perhaps compressing the stream if canCompress,
+// perhaps compressing the stream using compressionFormat if canCompress,
 // and returns a complete blobInfo of the copied blob and perhaps a <-chan diffIDResult if diffIDIsNeeded, to be read by the caller.
-func copyLayerFromStream(dest types.ImageDestination, srcStream io.Reader, srcInfo types.BlobInfo,
-	diffIDIsNeeded bool, canCompress bool, reportWriter io.Writer) (types.BlobInfo, <-chan diffIDResult, error) {
-	var getDiffIDRecorder func(decompressorFunc) io.Writer // = nil
+func copyLayerFromStream(ctx context.Context, dest types.ImageDestination, srcStream io.Reader, srcInfo types.BlobInfo,
+	diffIDIsNeeded bool, canCompress bool, compressionFormat string, reportWriter io.Writer, logger types.Logger,
+	transferMetrics types.Metrics) (types.BlobInfo, <-chan diffIDResult, error) {
+	var getDiffIDRecorder func(compression.DecompressorFunc) io.Writer // = nil
 	var diffIDChan chan diffIDResult
 
 	err := errors.New("Internal error: unexpected panic in copyLayer") // For pipeWriter.CloseWithError below
@@ -344,7 +665,7 @@ func copyLayerFromStream(dest types.ImageDestination, srcStream io.Reader, srcIn
 			pipeWriter.CloseWithError(err) // CloseWithError(nil) is equivalent to Close()
 		}()
 
-		getDiffIDRecorder = func(decompressor decompressorFunc) io.Writer {
+		getDiffIDRecorder = func(decompressor compression.DecompressorFunc) io.Writer {
 			// If this fails, e.g. because we have exited and due to pipeWriter.CloseWithError() above further
 			// reading from the pipe has failed, we don’t really care.
 			// We only read from diffIDChan if the rest of the flow has succeeded, and when we do read from it,
@@ -356,14 +677,14 @@ func copyLayerFromStream(dest types.ImageDestination, srcStream io.Reader, srcIn
 			return pipeWriter
 		}
 	}
-	blobInfo, err := copyBlobFromStream(dest, srcStream, srcInfo,
-		getDiffIDRecorder, canCompress, reportWriter) // Sets err to nil on success
+	blobInfo, err := copyBlobFromStream(ctx, dest, srcStream, srcInfo,
+		getDiffIDRecorder, canCompress, compressionFormat, false, reportWriter, logger, transferMetrics) // Sets err to nil on success
 	return blobInfo, diffIDChan, err
 	// We need the defer … pipeWriter.CloseWithError() to happen HERE so that the caller can block on reading from diffIDChan
 }
 
 // diffIDComputationGoroutine reads all input from layerStream, uncompresses using decompressor if necessary, and sends its digest, and status, if any, to dest.
-func diffIDComputationGoroutine(dest chan<- diffIDResult, layerStream io.ReadCloser, decompressor decompressorFunc) {
+func diffIDComputationGoroutine(dest chan<- diffIDResult, layerStream io.ReadCloser, decompressor compression.DecompressorFunc) {
 	result := diffIDResult{
 		digest: "",
 		err:    errors.New("Internal error: unexpected panic in diffIDComputationGoroutine"),
@@ -375,33 +696,31 @@ func diffIDComputationGoroutine(dest chan<- diffIDResult, layerStream io.ReadClo
 }
 
 // computeDiffID reads all input from layerStream, uncompresses it using decompressor if necessary, and returns its digest.
-func computeDiffID(stream io.Reader, decompressor decompressorFunc) (string, error) {
+func computeDiffID(stream io.Reader, decompressor compression.DecompressorFunc) (digest.Digest, error) {
 	if decompressor != nil {
 		s, err := decompressor(stream)
 		if err != nil {
 			return "", err
 		}
+		defer s.Close()
 		stream = s
 	}
 
-	h := sha256.New()
-	_, err := io.Copy(h, stream)
-	if err != nil {
-		return "", err
-	}
-	hash := h.Sum(nil)
-	return "sha256:" + hex.EncodeToString(hash[:]), nil
+	return digest.Canonical.FromReader(stream)
 }
 
 // copyBlobFromStream copies a blob with srcInfo (with known Digest and possibly known Size) from srcStream to dest,
 // perhaps sending a copy to an io.Writer if getOriginalLayerCopyWriter != nil,
-// perhaps compressing it if canCompress,
-// and returns a complete blobInfo of the copied blob.
-func copyBlobFromStream(dest types.ImageDestination, srcStream io.Reader, srcInfo types.BlobInfo,
-	getOriginalLayerCopyWriter func(decompressor decompressorFunc) io.Writer, canCompress bool,
-	reportWriter io.Writer) (types.BlobInfo, error) {
+// perhaps compressing it using compressionFormat if canCompress,
+// and returns a complete blobInfo of the copied blob, including the media type and compression operation actually used.
+// isConfig must be true for the image config, which is never compressed.
+func copyBlobFromStream(ctx context.Context, dest types.ImageDestination, srcStream io.Reader, srcInfo types.BlobInfo,
+	getOriginalLayerCopyWriter func(decompressor compression.DecompressorFunc) io.Writer, canCompress bool, compressionFormat string, isConfig bool,
+	reportWriter io.Writer, logger types.Logger, transferMetrics types.Metrics) (types.BlobInfo, error) {
 	// The copying happens through a pipeline of connected io.Readers.
 	// === Input: srcStream
+	srcCounter := &countingReader{reader: srcStream}
+	srcStream = srcCounter
 
 	// === Process input through digestingReader to validate against the expected digest.
 	// Be paranoid; in case PutBlob somehow managed to ignore an error from digestingReader,
@@ -416,12 +735,12 @@ func copyBlobFromStream(dest types.ImageDestination, srcStream io.Reader, srcInf
 	var destStream io.Reader = digestingReader
 
 	// === Detect compression of the input stream.
-	// This requires us to “peek ahead” into the stream to read the initial part, which requires us to chain through another io.Reader returned by detectCompression.
-	decompressor, destStream, err := detectCompression(destStream) // We could skip this in some cases, but let's keep the code path uniform
+	// This requires us to “peek ahead” into the stream to read the initial part, which requires us to chain through another io.Reader returned by DetectCompressionFormat.
+	compressionAlgorithm, decompressor, destStream, err := compression.DetectCompressionFormat(destStream) // We could skip this in some cases, but let's keep the code path uniform
 	if err != nil {
 		return types.BlobInfo{}, fmt.Errorf("Error reading blob %s: %v", srcInfo.Digest, err)
 	}
-	isCompressed := decompressor != nil
+	isCompressed := compressionAlgorithm.IsCompressed()
 
 	// === Report progress using a pb.Reader.
 	bar := pb.New(int(srcInfo.Size)).SetUnits(pb.U_BYTES)
@@ -440,27 +759,54 @@ func copyBlobFromStream(dest types.ImageDestination, srcStream io.Reader, srcInf
 		originalLayerReader = destStream
 	}
 
-	// === Compress the layer if it is uncompressed and compression is desired
+	// === Compress the layer if it is uncompressed and compression is desired,
+	// or decompress it if it is compressed and decompression is desired.
 	var inputInfo types.BlobInfo
-	if !canCompress || isCompressed || !dest.ShouldCompressLayers() {
-		logrus.Debugf("Using original blob without modification")
-		inputInfo = srcInfo
-	} else {
-		logrus.Debugf("Compressing blob on the fly")
+	var compressionAnnotations <-chan map[string]string // Set if compressing the layer
+	switch {
+	case canCompress && !isCompressed && dest.DesiredLayerCompression() == types.Compress:
+		logger.Debugf("Compressing blob on the fly")
 		pipeReader, pipeWriter := io.Pipe()
 		defer pipeReader.Close()
 
 		// If this fails while writing data, it will do pipeWriter.CloseWithError(); if it fails otherwise,
 		// e.g. because we have exited and due to pipeReader.Close() above further writing to the pipe has failed,
 		// we don’t care.
-		go compressGoroutine(pipeWriter, destStream) // Closes pipeWriter
+		if compressionFormat == "" {
+			compressionFormat = types.GzipCompression
+		}
+		annotations := make(chan map[string]string, 1)
+		go compressGoroutine(pipeWriter, destStream, compressionFormat, annotations) // Closes pipeWriter
+		compressionAnnotations = annotations
 		destStream = pipeReader
 		inputInfo.Digest = ""
 		inputInfo.Size = -1
+		inputInfo.CompressionOperation = types.Compress
+		inputInfo.CompressionAlgorithm = compressionFormat
+	case canCompress && isCompressed && dest.DesiredLayerCompression() == types.Decompress:
+		logger.Debugf("Decompressing blob on the fly")
+		s, err := decompressor(destStream)
+		if err != nil {
+			return types.BlobInfo{}, fmt.Errorf("Error decompressing blob %s: %v", srcInfo.Digest, err)
+		}
+		defer s.Close()
+		destStream = s
+		inputInfo.Digest = ""
+		inputInfo.Size = -1
+		inputInfo.CompressionOperation = types.Decompress
+		inputInfo.CompressionAlgorithm = compressionAlgorithm.Name()
+	default:
+		logger.Debugf("Using original blob without modification")
+		inputInfo = srcInfo
+		// The unmodified data is read through digestingReader, so dest.PutBlob does not need to compute the digest again.
+		inputInfo.DigestVerified = true
+		// Record the detected algorithm, so that the manifest can be updated to use a matching MIME type without reading the blob again.
+		inputInfo.CompressionAlgorithm = compressionAlgorithm.Name()
 	}
 
 	// === Finally, send the layer stream to dest.
-	uploadedInfo, err := dest.PutBlob(destStream, inputInfo)
+	destCounter := &countingReader{reader: destStream}
+	uploadedInfo, err := dest.PutBlob(ctx, destCounter, inputInfo, isConfig)
 	if err != nil {
 		return types.BlobInfo{}, fmt.Errorf("Error writing blob: %v", err)
 	}
@@ -470,7 +816,7 @@ func copyBlobFromStream(dest types.ImageDestination, srcStream io.Reader, srcInf
 	// So, read everything from originalLayerReader, which will cause the rest to be
 	// sent there if we are not already at EOF.
 	if getOriginalLayerCopyWriter != nil {
-		logrus.Debugf("Consuming rest of the original blob to satisfy getOriginalLayerCopyWriter")
+		logger.Debugf("Consuming rest of the original blob to satisfy getOriginalLayerCopyWriter")
 		_, err := io.Copy(ioutil.Discard, originalLayerReader)
 		if err != nil {
 			return types.BlobInfo{}, fmt.Errorf("Error reading input blob %s: %v", srcInfo.Digest, err)
@@ -483,56 +829,125 @@ func copyBlobFromStream(dest types.ImageDestination, srcStream io.Reader, srcInf
 	if inputInfo.Digest != "" && uploadedInfo.Digest != inputInfo.Digest {
 		return types.BlobInfo{}, fmt.Errorf("Internal error writing blob %s, blob with digest %s saved with digest %s", srcInfo.Digest, inputInfo.Digest, uploadedInfo.Digest)
 	}
+	if uploadedInfo.MediaType == "" {
+		uploadedInfo.MediaType = inputInfo.MediaType
+	}
+	if uploadedInfo.CompressionOperation == types.PreserveOriginal {
+		uploadedInfo.CompressionOperation = inputInfo.CompressionOperation
+		uploadedInfo.CompressionAlgorithm = inputInfo.CompressionAlgorithm
+		if compressionAnnotations != nil {
+			// compressGoroutine has sent the annotations before closing the pipe, and dest.PutBlob has read all of it.
+			select {
+			case uploadedInfo.Annotations = <-compressionAnnotations:
+			default:
+				return types.BlobInfo{}, fmt.Errorf("Internal error writing blob %s, compression has not finished", srcInfo.Digest)
+			}
+		}
+	}
+	transferMetrics.BlobCopied(srcInfo.Digest, srcCounter.count, destCounter.count)
 	return uploadedInfo, nil
 }
 
-// compressGoroutine reads all input from src and writes its compressed equivalent to dest.
-func compressGoroutine(dest *io.PipeWriter, src io.Reader) {
+// compressGoroutine reads all input from src and writes its equivalent compressed using compressionFormat to dest.
+// On success, it sends the annotations to record for the compressed blob, if any, to annotations before closing dest.
+func compressGoroutine(dest *io.PipeWriter, src io.Reader, compressionFormat string, annotations chan<- map[string]string) {
 	err := errors.New("Internal error: unexpected panic in compressGoroutine")
 	defer func() { // Note that this is not the same as {defer dest.CloseWithError(err)}; we need err to be evaluated lazily.
 		dest.CloseWithError(err) // CloseWithError(nil) is equivalent to Close()
 	}()
 
-	zipper := gzip.NewWriter(dest)
-	defer zipper.Close()
+	switch compressionFormat {
+	case types.GzipCompression:
+		zipper := gzip.NewWriter(dest)
+		if _, err = io.Copy(zipper, src); err != nil {
+			return
+		}
+		err = zipper.Close() // Sets err to nil on success, i.e. causes dest.Close()
+		annotations <- nil
+	case types.ZstdChunkedCompression:
+		var res map[string]string
+		res, err = zstdchunked.Compress(dest, src) // Sets err to nil on success, i.e. causes dest.Close()
+		annotations <- res
+	default:
+		err = fmt.Errorf("Internal error: unsupported compression format %q", compressionFormat)
+	}
+}
 
-	_, err = io.Copy(zipper, src) // Sets err to nil, i.e. causes dest.Close()
+// manifestMIMETypesSupportingCompression returns the subset of destSupportedManifestMIMETypes (or of all manifest MIME types
+// which can be created by converting manifests, if destSupportedManifestMIMETypes is empty) which can refer to layers compressed using compressionFormat.
+// It returns destSupportedManifestMIMETypes unmodified if no restriction is necessary, and an error if none of the MIME types is usable.
+func manifestMIMETypesSupportingCompression(destSupportedManifestMIMETypes []string, compressionFormat string) ([]string, error) {
+	candidates := destSupportedManifestMIMETypes
+	if len(candidates) == 0 {
+		candidates = []string{imgspecv1.MediaTypeImageManifest, manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType}
+	}
+	res := []string{}
+	for _, t := range candidates {
+		if manifest.MIMETypeSupportsCompressionAlgorithm(t, compressionFormat) {
+			res = append(res, t)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%s compression is not supported by any of the manifest formats %s", compressionFormat, strings.Join(candidates, ", "))
+	}
+	if len(res) == len(candidates) {
+		return destSupportedManifestMIMETypes, nil
+	}
+	return res, nil
 }
 
 // determineManifestConversion updates manifestUpdates to convert manifest to a supported MIME type, if necessary and canModifyManifest.
+// It returns the MIME type the manifest will have after the conversion, if any, and an ordered list of other MIME types to try,
+// if canModifyManifest and the destination rejects the preferred one.
 // Note that the conversion will only happen later, through src.UpdatedImage
-func determineManifestConversion(manifestUpdates *types.ManifestUpdateOptions, src types.Image, destSupportedManifestMIMETypes []string, canModifyManifest bool) error {
+func determineManifestConversion(ctx context.Context, manifestUpdates *types.ManifestUpdateOptions, src types.Image, destSupportedManifestMIMETypes []string,
+	canModifyManifest bool, logger types.Logger) (string, []string, error) {
+	_, srcType, err := src.Manifest(ctx)
+	if err != nil { // This should have been cached?!
+		return "", nil, fmt.Errorf("Error reading manifest: %v", err)
+	}
 	if len(destSupportedManifestMIMETypes) == 0 {
-		return nil // Anything goes
+		return srcType, []string{}, nil // Anything goes
 	}
 	supportedByDest := map[string]struct{}{}
 	for _, t := range destSupportedManifestMIMETypes {
 		supportedByDest[t] = struct{}{}
 	}
 
-	_, srcType, err := src.Manifest()
-	if err != nil { // This should have been cached?!
-		return fmt.Errorf("Error reading manifest: %v", err)
-	}
-	if _, ok := supportedByDest[srcType]; ok {
-		logrus.Debugf("Manifest MIME type %s is declared supported by the destination", srcType)
-		return nil
+	candidates := []string{}
+	seen := map[string]struct{}{}
+	addCandidate := func(t string) {
+		if _, ok := supportedByDest[t]; !ok {
+			return
+		}
+		if _, ok := seen[t]; ok {
+			return
+		}
+		seen[t] = struct{}{}
+		candidates = append(candidates, t)
 	}
-
-	// OK, we should convert the manifest.
+	// First of all, prefer to keep the original manifest unmodified.
+	addCandidate(srcType)
 	if !canModifyManifest {
-		logrus.Debugf("Manifest MIME type %s is not supported by the destination, but we can't modify the manifest, hoping for the best...")
-		return nil // Take our chances - FIXME? Or should we fail without trying?
+		if len(candidates) == 0 {
+			logger.Debugf("Manifest MIME type %s is not supported by the destination, but we can't modify the manifest, hoping for the best...", srcType)
+		}
+		return srcType, []string{}, nil // Take our chances - FIXME? Or should we fail without trying?
 	}
-
-	var chosenType = destSupportedManifestMIMETypes[0] // This one is known to be supported.
+	// Then use our list of preferred types, and finally anything else the destination supports.
 	for _, t := range preferredManifestMIMETypes {
-		if _, ok := supportedByDest[t]; ok {
-			chosenType = t
-			break
-		}
+		addCandidate(t)
 	}
-	logrus.Debugf("Will convert manifest from MIME type %s to %s", srcType, chosenType)
-	manifestUpdates.ManifestMIMEType = chosenType
-	return nil
+	for _, t := range destSupportedManifestMIMETypes {
+		addCandidate(t)
+	}
+
+	chosenType := candidates[0]
+	if chosenType == srcType {
+		logger.Debugf("Manifest MIME type %s is declared supported by the destination", srcType)
+	} else {
+		logger.Debugf("Will convert manifest from MIME type %s to %s", srcType, chosenType)
+		manifestUpdates.ManifestMIMEType = chosenType
+	}
+	return chosenType, candidates[1:], nil
 }
@@ -2,12 +2,36 @@ package copy
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/pkg/logging"
+	"github.com/containers/image/pkg/metrics"
+	"github.com/containers/image/pkg/tracing"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -15,7 +39,7 @@ import (
 func TestNewDigestingReader(t *testing.T) {
 	// Only the failure cases, success is tested in TestDigestingReaderRead below.
 	source := bytes.NewReader([]byte("abc"))
-	for _, input := range []string{
+	for _, input := range []digest.Digest{
 		"abc",             // Not algo:hexvalue
 		"crc32:",          // Unknown algorithm, empty value
 		"crc32:012345678", // Unknown algorithm
@@ -31,11 +55,12 @@ func TestNewDigestingReader(t *testing.T) {
 func TestDigestingReaderRead(t *testing.T) {
 	cases := []struct {
 		input  []byte
-		digest string
+		digest digest.Digest
 	}{
 		{[]byte(""), "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
 		{[]byte("abc"), "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
 		{make([]byte, 65537, 65537), "sha256:3266304f31be278d06c3bd3eb9aa3e00c59bedec0a890de466568b0b90b0e01f"},
+		{[]byte("abc"), "sha512:ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
 	}
 	// Valid input
 	for _, c := range cases {
@@ -61,7 +86,7 @@ func TestDigestingReaderRead(t *testing.T) {
 	}
 }
 
-func goDiffIDComputationGoroutineWithTimeout(layerStream io.ReadCloser, decompressor decompressorFunc) *diffIDResult {
+func goDiffIDComputationGoroutineWithTimeout(layerStream io.ReadCloser, decompressor compression.DecompressorFunc) *diffIDResult {
 	ch := make(chan diffIDResult)
 	go diffIDComputationGoroutine(ch, layerStream, nil)
 	timeout := time.After(time.Second)
@@ -79,7 +104,7 @@ func TestDiffIDComputationGoroutine(t *testing.T) {
 	res := goDiffIDComputationGoroutineWithTimeout(stream, nil)
 	require.NotNil(t, res)
 	assert.NoError(t, res.err)
-	assert.Equal(t, "sha256:185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", res.digest)
+	assert.Equal(t, digest.Digest("sha256:185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969"), res.digest)
 
 	// Error reading input
 	reader, writer := io.Pipe()
@@ -92,12 +117,12 @@ func TestDiffIDComputationGoroutine(t *testing.T) {
 func TestComputeDiffID(t *testing.T) {
 	for _, c := range []struct {
 		filename     string
-		decompressor decompressorFunc
-		result       string
+		decompressor compression.DecompressorFunc
+		result       digest.Digest
 	}{
 		{"fixtures/Hello.uncompressed", nil, "sha256:185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969"},
 		{"fixtures/Hello.gz", nil, "sha256:0bd4409dcd76476a263b8f3221b4ce04eb4686dec40bfdcc2e86a7403de13609"},
-		{"fixtures/Hello.gz", gzipDecompressor, "sha256:185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969"},
+		{"fixtures/Hello.gz", compression.GzipDecompressor, "sha256:185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969"},
 	} {
 		stream, err := os.Open(c.filename)
 		require.NoError(t, err, c.filename)
@@ -109,7 +134,7 @@ func TestComputeDiffID(t *testing.T) {
 	}
 
 	// Error initializing decompression
-	_, err := computeDiffID(bytes.NewReader([]byte{}), gzipDecompressor)
+	_, err := computeDiffID(bytes.NewReader([]byte{}), compression.GzipDecompressor)
 	assert.Error(t, err)
 
 	// Error reading input
@@ -119,3 +144,641 @@ func TestComputeDiffID(t *testing.T) {
 	_, err = computeDiffID(reader, nil)
 	assert.Error(t, err)
 }
+
+func TestCopyBlobFromStreamLayerCompression(t *testing.T) {
+	uncompressed := []byte("This is an uncompressed layer")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(uncompressed)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	compressed := buf.Bytes()
+
+	for _, c := range []struct {
+		input       []byte
+		compression types.LayerCompression
+		canCompress bool
+		operation   types.LayerCompression
+		compressed  bool
+	}{
+		{uncompressed, types.PreserveOriginal, true, types.PreserveOriginal, false},
+		{compressed, types.PreserveOriginal, true, types.PreserveOriginal, true},
+		{uncompressed, types.Compress, true, types.Compress, true},
+		{compressed, types.Compress, true, types.PreserveOriginal, true},
+		{uncompressed, types.Decompress, true, types.PreserveOriginal, false},
+		{compressed, types.Decompress, true, types.Decompress, false},
+		{compressed, types.Decompress, false, types.PreserveOriginal, true},
+		{uncompressed, types.Compress, false, types.PreserveOriginal, false},
+	} {
+		tmpDir, err := ioutil.TempDir("", "copy-compression")
+		require.NoError(t, err)
+		ref, err := directory.NewReference(tmpDir)
+		require.NoError(t, err)
+		dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{DirLayerCompression: c.compression})
+		require.NoError(t, err)
+
+		srcInfo := types.BlobInfo{Digest: digest.FromBytes(c.input), Size: int64(len(c.input)), MediaType: "application/x-test-layer"}
+		info, err := copyBlobFromStream(context.Background(), dest, bytes.NewReader(c.input), srcInfo, nil, c.canCompress, types.GzipCompression, false, ioutil.Discard, logging.Default, metrics.Default)
+		require.NoError(t, err, "%#v", c)
+		assert.Equal(t, c.operation, info.CompressionOperation, "%#v", c)
+		// The algorithm is recorded for compressed, decompressed and unmodified compressed blobs.
+		if c.operation == types.PreserveOriginal && !c.compressed {
+			assert.Equal(t, "", info.CompressionAlgorithm, "%#v", c)
+		} else {
+			assert.Equal(t, types.GzipCompression, info.CompressionAlgorithm, "%#v", c)
+		}
+		// The original media type is only known to be valid if the blob was not modified.
+		if c.operation == types.PreserveOriginal {
+			assert.Equal(t, srcInfo.MediaType, info.MediaType, "%#v", c)
+		} else {
+			assert.Equal(t, "", info.MediaType, "%#v", c)
+		}
+
+		src, err := ref.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		stream, _, err := src.GetBlob(context.Background(), info, blobinfocache.NoCache)
+		require.NoError(t, err, "%#v", c)
+		stored, err := ioutil.ReadAll(stream)
+		stream.Close()
+		require.NoError(t, err)
+		algorithm, _, _, err := compression.DetectCompressionFormat(bytes.NewReader(stored))
+		require.NoError(t, err)
+		assert.Equal(t, c.compressed, algorithm.IsCompressed(), "%#v", c)
+		if !c.compressed {
+			assert.Equal(t, uncompressed, stored, "%#v", c)
+		}
+
+		src.Close()
+		dest.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestCopyBlobFromStreamDigestMismatch(t *testing.T) {
+	// Destinations may trust srcInfo.Digest for unmodified blobs; a mismatch must still be detected, and the blob not stored.
+	tmpDir, err := ioutil.TempDir("", "copy-digest-mismatch")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	ref, err := directory.NewReference(tmpDir)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{DirLayerCompression: types.PreserveOriginal})
+	require.NoError(t, err)
+	defer dest.Close()
+
+	input := []byte("This is a layer")
+	srcInfo := types.BlobInfo{Digest: digest.FromBytes([]byte("This is another layer")), Size: int64(len(input))}
+	_, err = copyBlobFromStream(context.Background(), dest, bytes.NewReader(input), srcInfo, nil, false, types.GzipCompression, false, ioutil.Discard, logging.Default, metrics.Default)
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(tmpDir, srcInfo.Digest.Hex()))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// recordingMetrics is a types.Metrics recording the reported blob statistics.
+type recordingMetrics struct {
+	copied []string
+	reused []digest.Digest
+}
+
+func (m *recordingMetrics) RegistryRequest(registry, method string, statusCode int, duration time.Duration) {
+}
+func (m *recordingMetrics) RegistryRetry(registry string) {}
+func (m *recordingMetrics) BlobCopied(blobDigest digest.Digest, downloaded, uploaded int64) {
+	m.copied = append(m.copied, fmt.Sprintf("%s %d %d", blobDigest, downloaded, uploaded))
+}
+func (m *recordingMetrics) BlobReused(blobDigest digest.Digest) {
+	m.reused = append(m.reused, blobDigest)
+}
+
+func TestCopyBlobFromStreamMetrics(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-metrics")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	ref, err := directory.NewReference(tmpDir)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{DirLayerCompression: types.Compress})
+	require.NoError(t, err)
+	defer dest.Close()
+
+	input := bytes.Repeat([]byte("This is a layer"), 1000)
+	srcInfo := types.BlobInfo{Digest: digest.FromBytes(input), Size: int64(len(input))}
+	m := &recordingMetrics{}
+	info, err := copyBlobFromStream(context.Background(), dest, bytes.NewReader(input), srcInfo, nil, true, types.GzipCompression, false, ioutil.Discard, logging.Default, m)
+	require.NoError(t, err)
+	assert.Equal(t, []string{fmt.Sprintf("%s %d %d", srcInfo.Digest, len(input), info.Size)}, m.copied)
+	assert.True(t, info.Size < int64(len(input)))
+	assert.Empty(t, m.reused)
+}
+
+func TestManifestMIMETypesSupportingCompression(t *testing.T) {
+	for _, c := range []struct {
+		destSupported     []string
+		compressionFormat string
+		expected          []string // nil if an error is expected
+	}{
+		// gzip is supported by all formats; the input is not modified.
+		{[]string{}, types.GzipCompression, []string{}},
+		{[]string{manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest}, types.GzipCompression,
+			[]string{manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest}},
+		// zstd:chunked requires OCI.
+		{[]string{}, types.ZstdChunkedCompression, []string{imgspecv1.MediaTypeImageManifest}},
+		{[]string{manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest}, types.ZstdChunkedCompression,
+			[]string{imgspecv1.MediaTypeImageManifest}},
+		{[]string{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType}, types.ZstdChunkedCompression, nil},
+	} {
+		res, err := manifestMIMETypesSupportingCompression(c.destSupported, c.compressionFormat)
+		if c.expected == nil {
+			assert.Error(t, err, "%#v", c)
+		} else {
+			require.NoError(t, err, "%#v", c)
+			assert.Equal(t, c.expected, res, "%#v", c)
+		}
+	}
+}
+
+func TestCompressingDestination(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-compressing-destination")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	ref, err := directory.NewReference(tmpDir)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	uncompressed := []byte("This is an uncompressed layer")
+	srcInfo := types.BlobInfo{Digest: digest.FromBytes(uncompressed), Size: int64(len(uncompressed))}
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(uncompressed), srcInfo, false)
+	require.NoError(t, err)
+
+	cd := compressingDestination{dest}
+	assert.Equal(t, types.Compress, cd.DesiredLayerCompression())
+	// The uncompressed blob exists, but must not be reused.
+	reused, _, err := cd.TryReusingBlob(context.Background(), srcInfo, blobinfocache.NoCache, true)
+	require.NoError(t, err)
+	assert.False(t, reused)
+
+	info, err := copyBlobFromStream(context.Background(), cd, bytes.NewReader(uncompressed), srcInfo, nil, true, types.GzipCompression, false, ioutil.Discard, logging.Default, metrics.Default)
+	require.NoError(t, err)
+	assert.Equal(t, types.Compress, info.CompressionOperation)
+	assert.NotEqual(t, srcInfo.Digest, info.Digest)
+}
+
+// layersImageMock is a types.Image which only provides the specified layers.
+type layersImageMock struct {
+	types.Image
+	layers []types.BlobInfo
+}
+
+func (i layersImageMock) LayerInfos() []types.BlobInfo {
+	return i.layers
+}
+func (i layersImageMock) UpdatedImageNeedsLayerDiffIDs(options types.ManifestUpdateOptions) bool {
+	return false
+}
+
+// threadSafetySource is a types.ImageSource with a configurable HasThreadSafeGetBlob.
+type threadSafetySource struct {
+	types.ImageSource
+	threadSafe bool
+}
+
+func (s threadSafetySource) HasThreadSafeGetBlob() bool {
+	return s.threadSafe
+}
+
+// concurrencyCountingDest is a types.ImageDestination with a configurable HasThreadSafePutBlob, which records the maximum number
+// of concurrent PutBlob calls.
+type concurrencyCountingDest struct {
+	types.ImageDestination
+	threadSafe    bool
+	mutex         sync.Mutex
+	inProgress    int
+	maxInProgress int
+}
+
+func (d *concurrencyCountingDest) HasThreadSafePutBlob() bool {
+	return d.threadSafe
+}
+func (d *concurrencyCountingDest) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	d.mutex.Lock()
+	d.inProgress++
+	if d.inProgress > d.maxInProgress {
+		d.maxInProgress = d.inProgress
+	}
+	d.mutex.Unlock()
+	defer func() {
+		d.mutex.Lock()
+		d.inProgress--
+		d.mutex.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond) // Give other copies a chance to start.
+	return d.ImageDestination.PutBlob(ctx, stream, inputInfo, isConfig)
+}
+
+func TestCopyLayersParallelism(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "copy-layers-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(srcDir)
+	srcRef, err := directory.NewReference(srcDir)
+	require.NoError(t, err)
+	srcDest, err := srcRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	layers := []types.BlobInfo{}
+	for i := 0; i < 4; i++ {
+		blob := []byte(fmt.Sprintf("layer %d", i))
+		info, err := srcDest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Size: -1}, false)
+		require.NoError(t, err)
+		layers = append(layers, info)
+	}
+	srcDest.Close()
+	layers = append(layers, layers[1]) // Duplicate layers are only copied once.
+
+	for _, threadSafe := range []struct{ src, dest, parallel bool }{
+		{true, true, true},
+		{true, false, false},
+		{false, true, false},
+	} {
+		destDir, err := ioutil.TempDir("", "copy-layers-dest")
+		require.NoError(t, err)
+		destRef, err := directory.NewReference(destDir)
+		require.NoError(t, err)
+		rawDest, err := destRef.NewImageDestination(context.Background(), nil)
+		require.NoError(t, err)
+		dest := &concurrencyCountingDest{ImageDestination: rawDest, threadSafe: threadSafe.dest}
+		rawSrc, err := srcRef.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		src := threadSafetySource{ImageSource: rawSrc, threadSafe: threadSafe.src}
+
+		updates := types.ManifestUpdateOptions{}
+		err = copyLayers(context.Background(), &updates, dest, layersImageMock{layers: layers}, src, blobinfocache.NewMemoryCache(), false, types.GzipCompression, ioutil.Discard, logging.Default, tracing.Default, metrics.Default)
+		require.NoError(t, err, "%#v", threadSafe)
+		require.Len(t, updates.InformationOnly.LayerInfos, len(layers))
+		for i, info := range updates.InformationOnly.LayerInfos {
+			assert.Equal(t, layers[i].Digest, info.Digest, "%#v", threadSafe)
+		}
+		if threadSafe.parallel {
+			assert.True(t, dest.maxInProgress > 1, "%#v", threadSafe)
+			assert.True(t, dest.maxInProgress <= maxParallelLayerCopies, "%#v", threadSafe)
+		} else {
+			assert.Equal(t, 1, dest.maxInProgress, "%#v", threadSafe)
+		}
+
+		rawSrc.Close()
+		rawDest.Close()
+		os.RemoveAll(destDir)
+	}
+}
+
+// layerInfosForCopySource is a types.ImageSource which implements types.LayerInfosForCopySource.
+type layerInfosForCopySource struct {
+	types.ImageSource
+	layers []types.BlobInfo
+}
+
+func (s layerInfosForCopySource) LayerInfosForCopy(ctx context.Context) ([]types.BlobInfo, error) {
+	return s.layers, nil
+}
+
+func TestCopyLayersLayerInfosForCopy(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "copy-layers-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(srcDir)
+	srcRef, err := directory.NewReference(srcDir)
+	require.NoError(t, err)
+	srcDest, err := srcRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	// The manifest refers to compressed layers, but the source only provides uncompressed ones.
+	manifestLayers := []types.BlobInfo{}
+	uncompressedLayers := []types.BlobInfo{}
+	for i := 0; i < 2; i++ {
+		blob := []byte(fmt.Sprintf("layer %d", i))
+		info, err := srcDest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Size: -1}, false)
+		require.NoError(t, err)
+		manifestLayers = append(manifestLayers, types.BlobInfo{Digest: digest.FromString(fmt.Sprintf("compressed layer %d", i)), Size: 100})
+		uncompressedLayers = append(uncompressedLayers, types.BlobInfo{Digest: info.Digest, Size: -1, CompressionOperation: types.Decompress})
+	}
+	srcDest.Close()
+	rawSrc, err := srcRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer rawSrc.Close()
+	src := layerInfosForCopySource{ImageSource: rawSrc, layers: uncompressedLayers}
+
+	destDir, err := ioutil.TempDir("", "copy-layers-dest")
+	require.NoError(t, err)
+	defer os.RemoveAll(destDir)
+	destRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+	dest, err := destRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	updates := types.ManifestUpdateOptions{}
+	err = copyLayers(context.Background(), &updates, dest, layersImageMock{layers: manifestLayers}, src, blobinfocache.NewMemoryCache(), true, types.GzipCompression, ioutil.Discard, logging.Default, tracing.Default, metrics.Default)
+	require.NoError(t, err)
+	require.Len(t, updates.LayerInfos, 2)
+	for i, info := range updates.LayerInfos {
+		assert.Equal(t, uncompressedLayers[i].Digest, info.Digest)
+		assert.Equal(t, types.Decompress, info.CompressionOperation) // So that the MIME types in the manifest are updated.
+	}
+
+	// The manifest must be updated, which is not possible if it is signed.
+	updates = types.ManifestUpdateOptions{}
+	err = copyLayers(context.Background(), &updates, dest, layersImageMock{layers: manifestLayers}, src, blobinfocache.NewMemoryCache(), false, types.GzipCompression, ioutil.Discard, logging.Default, tracing.Default, metrics.Default)
+	assert.Error(t, err)
+}
+
+// namedLogger is a types.Logger which discards all messages, distinguishable by name.
+type namedLogger struct {
+	name string
+}
+
+func (namedLogger) Debugf(format string, args ...interface{}) {}
+func (namedLogger) Warnf(format string, args ...interface{})  {}
+
+func TestOptionsLogger(t *testing.T) {
+	optionsLogger := namedLogger{name: "options"}
+	destLogger := namedLogger{name: "destination"}
+	srcLogger := namedLogger{name: "source"}
+
+	for _, c := range []struct {
+		options  Options
+		expected types.Logger
+	}{
+		{Options{}, logging.Default},
+		{Options{SourceCtx: &types.SystemContext{}, DestinationCtx: &types.SystemContext{}}, logging.Default},
+		{Options{SourceCtx: &types.SystemContext{Logger: srcLogger}}, srcLogger},
+		{Options{SourceCtx: &types.SystemContext{Logger: srcLogger}, DestinationCtx: &types.SystemContext{Logger: destLogger}}, destLogger},
+		{Options{Logger: optionsLogger, SourceCtx: &types.SystemContext{Logger: srcLogger}, DestinationCtx: &types.SystemContext{Logger: destLogger}}, optionsLogger},
+	} {
+		options := c.options
+		assert.Equal(t, c.expected, options.logger(), "%#v", c.options)
+	}
+}
+
+// namedTracer is a types.Tracer which does not record anything, distinguishable by name.
+type namedTracer struct {
+	name string
+}
+
+func (namedTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, types.Span) {
+	return tracing.Default.Start(ctx, name, attributes)
+}
+
+func TestOptionsTracer(t *testing.T) {
+	optionsTracer := namedTracer{name: "options"}
+	destTracer := namedTracer{name: "destination"}
+	srcTracer := namedTracer{name: "source"}
+
+	for _, c := range []struct {
+		options  Options
+		expected types.Tracer
+	}{
+		{Options{}, tracing.Default},
+		{Options{SourceCtx: &types.SystemContext{}, DestinationCtx: &types.SystemContext{}}, tracing.Default},
+		{Options{SourceCtx: &types.SystemContext{Tracer: srcTracer}}, srcTracer},
+		{Options{SourceCtx: &types.SystemContext{Tracer: srcTracer}, DestinationCtx: &types.SystemContext{Tracer: destTracer}}, destTracer},
+		{Options{Tracer: optionsTracer, SourceCtx: &types.SystemContext{Tracer: srcTracer}, DestinationCtx: &types.SystemContext{Tracer: destTracer}}, optionsTracer},
+	} {
+		options := c.options
+		assert.Equal(t, c.expected, options.tracer(), "%#v", c.options)
+	}
+}
+
+// mimeTypeImageMock is a types.Image which only provides a manifest MIME type.
+type mimeTypeImageMock struct {
+	types.Image
+	mimeType string
+}
+
+func (i mimeTypeImageMock) Manifest(ctx context.Context) ([]byte, string, error) {
+	return []byte{}, i.mimeType, nil
+}
+
+func TestDetermineManifestConversion(t *testing.T) {
+	supportS1S2 := []string{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType}
+	supportS1S2OCI := []string{imgspecv1.MediaTypeImageManifest, manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType}
+	for _, c := range []struct {
+		srcType           string
+		destSupported     []string
+		canModifyManifest bool
+		updatedType       string // "" if no conversion is expected
+		otherCandidates   []string
+	}{
+		// Anything goes
+		{manifest.DockerV2Schema2MediaType, []string{}, true, "", []string{}},
+		// The original type is supported; the other supported types are fallbacks, in order of our preference
+		{manifest.DockerV2Schema2MediaType, supportS1S2, true, "", []string{manifest.DockerV2Schema1SignedMediaType}},
+		{imgspecv1.MediaTypeImageManifest, supportS1S2OCI, true, "", supportS1S2},
+		{manifest.DockerV2Schema1SignedMediaType, supportS1S2OCI, true, "",
+			[]string{manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest}},
+		// The original type is not supported; convert to the preferred type
+		{imgspecv1.MediaTypeImageManifest, supportS1S2, true, manifest.DockerV2Schema2MediaType, []string{manifest.DockerV2Schema1SignedMediaType}},
+		{imgspecv1.MediaTypeImageManifest, []string{manifest.DockerV2Schema1MediaType, manifest.DockerV2Schema1SignedMediaType}, true,
+			manifest.DockerV2Schema1SignedMediaType, []string{manifest.DockerV2Schema1MediaType}},
+		// Conversion is not allowed, there are no fallbacks
+		{manifest.DockerV2Schema2MediaType, supportS1S2, false, "", []string{}},
+		{imgspecv1.MediaTypeImageManifest, supportS1S2, false, "", []string{}},
+	} {
+		updates := types.ManifestUpdateOptions{}
+		preferred, others, err := determineManifestConversion(context.Background(), &updates, mimeTypeImageMock{mimeType: c.srcType},
+			c.destSupported, c.canModifyManifest, logging.Default)
+		require.NoError(t, err, "%#v", c)
+		assert.Equal(t, c.updatedType, updates.ManifestMIMEType, "%#v", c)
+		if c.updatedType == "" {
+			assert.Equal(t, c.srcType, preferred, "%#v", c)
+		} else {
+			assert.Equal(t, c.updatedType, preferred, "%#v", c)
+		}
+		assert.Equal(t, c.otherCandidates, others, "%#v", c)
+	}
+}
+
+// rejectingDestinationReference is a types.ImageReference for a directory, which rejects manifests of the specified type.
+type rejectingDestinationReference struct {
+	types.ImageReference
+	rejectedType string
+}
+
+func (ref rejectingDestinationReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := ref.ImageReference.NewImageDestination(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return rejectingDestination{ImageDestination: dest, rejectedType: ref.rejectedType}, nil
+}
+
+// rejectingDestination is a types.ImageDestination which rejects manifests of the specified type.
+type rejectingDestination struct {
+	types.ImageDestination
+	rejectedType string
+}
+
+func (d rejectingDestination) SupportedManifestMIMETypes() []string {
+	return []string{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType}
+}
+
+func (d rejectingDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	if manifest.GuessMIMEType(m) == d.rejectedType {
+		return types.ManifestTypeRejectedError{Err: fmt.Errorf("manifest type %s rejected", d.rejectedType)}
+	}
+	return d.ImageDestination.PutManifest(ctx, m, instanceDigest)
+}
+
+// writeSchema2Image creates a schema2 image with a single layer in a directory in tmpDir, and returns a reference to it.
+func writeSchema2Image(t *testing.T, tmpDir string) types.ImageReference {
+	layer, err := ioutil.ReadFile("fixtures/Hello.gz")
+	require.NoError(t, err)
+	uncompressed, err := ioutil.ReadFile("fixtures/Hello.uncompressed")
+	require.NoError(t, err)
+	config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["%s"]},`+
+		`"history":[{"created":"2016-09-23T23:20:45.789764590Z","created_by":"ADD Hello /"}]}`, digest.FromBytes(uncompressed)))
+	configInfo := types.BlobInfo{Digest: digest.FromBytes(config), Size: int64(len(config))}
+	layerInfo := types.BlobInfo{Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+	m, err := manifest.Schema2FromComponents(
+		manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2ConfigMediaType, Size: configInfo.Size, Digest: configInfo.Digest},
+		[]manifest.Schema2Descriptor{{MediaType: manifest.DockerV2Schema2LayerMediaType, Size: layerInfo.Size, Digest: layerInfo.Digest}},
+	).Serialize()
+	require.NoError(t, err)
+
+	ref, err := directory.NewReference(filepath.Join(tmpDir, "src"))
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(config), configInfo, true)
+	require.NoError(t, err)
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(layer), layerInfo, false)
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), m, nil))
+	require.NoError(t, dest.Commit(context.Background()))
+	return ref
+}
+
+func TestImageManifestTypeFallback(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-manifest-type-fallback")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	srcRef := writeSchema2Image(t, tmpDir)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+
+	for i, c := range []struct {
+		rejectedType string
+		signatures   [][]byte
+		expectedType string // "" if the copy should fail
+	}{
+		{manifest.DockerV2Schema1SignedMediaType, nil, manifest.DockerV2Schema2MediaType},
+		// The schema2 manifest is rejected, the copy falls back to schema1.
+		{manifest.DockerV2Schema2MediaType, nil, manifest.DockerV2Schema1SignedMediaType},
+		// With signatures, the manifest can not be converted.
+		{manifest.DockerV2Schema2MediaType, [][]byte{[]byte("signature")}, ""},
+	} {
+		destDir := filepath.Join(tmpDir, fmt.Sprintf("dest%d", i))
+		dirRef, err := directory.NewReference(destDir)
+		require.NoError(t, err)
+		destRef := rejectingDestinationReference{ImageReference: dirRef, rejectedType: c.rejectedType}
+		err = Image(context.Background(), policyContext, destRef, srcRef, &Options{AdditionalSignatures: c.signatures})
+		if c.expectedType == "" {
+			assert.Error(t, err, c.rejectedType)
+			continue
+		}
+		require.NoError(t, err, c.rejectedType)
+		m, err := ioutil.ReadFile(filepath.Join(destDir, "manifest.json"))
+		require.NoError(t, err)
+		assert.Equal(t, c.expectedType, manifest.GuessMIMEType(m), c.rejectedType)
+	}
+}
+
+func TestImageAdditionalSignatures(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-additional-signatures")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	srcRef := writeSchema2Image(t, tmpDir)
+	srcManifest, err := ioutil.ReadFile(filepath.Join(tmpDir, "src", "manifest.json"))
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+
+	destDir := filepath.Join(tmpDir, "dest")
+	destRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+	signatures := [][]byte{[]byte("signature 1"), []byte("signature 2")}
+	err = Image(context.Background(), policyContext, destRef, srcRef, &Options{AdditionalSignatures: signatures})
+	require.NoError(t, err)
+
+	destManifest, err := ioutil.ReadFile(filepath.Join(destDir, "manifest.json"))
+	require.NoError(t, err)
+	assert.Equal(t, srcManifest, destManifest)
+	for i, sig := range signatures {
+		contents, err := ioutil.ReadFile(filepath.Join(destDir, fmt.Sprintf("signature-%d", i+1)))
+		require.NoError(t, err)
+		assert.Equal(t, sig, contents)
+	}
+	_, err = os.Stat(filepath.Join(destDir, "signature-3"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestImageSigner(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "copy-signer")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	srcRef := writeSchema2Image(t, tmpDir)
+	acceptAnything, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer acceptAnything.Destroy()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := signature.NewCryptoSigner(key)
+	require.NoError(t, err)
+	identity, err := reference.ParseNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+
+	destRef, err := directory.NewReference(filepath.Join(tmpDir, "dest"))
+	require.NoError(t, err)
+	err = Image(context.Background(), acceptAnything, destRef, srcRef, &Options{Signer: signer, SignIdentity: identity})
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	for _, c := range []struct {
+		identity string
+		allowed  bool
+	}{
+		{"example.com/ns/repo:tag", true},
+		{"example.com/ns/repo:other", false},
+	} {
+		signedIdentity, err := signature.NewPRMExactReference(c.identity)
+		require.NoError(t, err)
+		requirement, err := signature.NewPRSigstoreSignedKeyData(publicKey, signedIdentity)
+		require.NoError(t, err)
+		policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{requirement}})
+		require.NoError(t, err)
+		src, err := destRef.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		img := image.UnparsedFromSource(src)
+		allowed, err := policyContext.IsRunningImageAllowed(context.Background(), img)
+		assert.Equal(t, c.allowed, allowed, c.identity)
+		if c.allowed {
+			assert.NoError(t, err, c.identity)
+		} else {
+			assert.Error(t, err, c.identity)
+		}
+		img.Close()
+		policyContext.Destroy()
+	}
+	// The simple signing signatures are not affected.
+	_, err = os.Stat(filepath.Join(tmpDir, "dest", "signature-1"))
+	assert.True(t, os.IsNotExist(err))
+
+	// A destination which does not support sigstore signatures is rejected.
+	rejectingRef := rejectingDestinationReference{ImageReference: destRef, rejectedType: manifest.DockerV2Schema1SignedMediaType}
+	err = Image(context.Background(), acceptAnything, rejectingRef, srcRef, &Options{Signer: signer, SignIdentity: identity})
+	assert.Error(t, err)
+	// The signature needs an identity.
+	err = Image(context.Background(), acceptAnything, destRef, srcRef, &Options{Signer: signer})
+	assert.Error(t, err)
+}
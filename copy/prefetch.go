@@ -0,0 +1,195 @@
+package copy
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// maxPrefetchedLayers is the number of layers, following the one currently being copied, whose download is started in advance.
+	maxPrefetchedLayers = 2
+	// prefetchChunkSize is the size of a single read from a prefetched blob.
+	prefetchChunkSize = 32 * 1024
+	// maxPrefetchedChunks limits the amount of data buffered in memory for each prefetched blob (prefetchChunkSize * maxPrefetchedChunks bytes).
+	maxPrefetchedChunks = 512
+)
+
+// prefetchingImageSource is a types.ImageSource which, whenever GetBlob is called for one of layers, starts downloading
+// the following maxPrefetchedLayers layers in the background, buffering a limited amount of their data in memory.
+// This allows overlapping the latency of the source and of the destination even if layers are copied one at a time.
+// It must only be used with sources for which HasThreadSafeGetBlob() is true.
+type prefetchingImageSource struct {
+	types.ImageSource
+	ctx    context.Context // Used for all prefetches, so that they can outlive the GetBlob call which started them.
+	cache  types.BlobInfoCache
+	layers []types.BlobInfo
+
+	mutex   sync.Mutex                        // Protects the fields below
+	started int                               // Number of items of layers for which a prefetch was started
+	pending map[digest.Digest]*prefetchedBlob // Prefetched blobs which have not been returned by GetBlob yet
+	closed  bool
+	wg      sync.WaitGroup // Tracks prefetch goroutines
+}
+
+// newPrefetchingImageSource returns a prefetchingImageSource for src, prefetching layers (in the order they are going to be copied).
+// The caller must call discardUnused when done.
+func newPrefetchingImageSource(ctx context.Context, src types.ImageSource, layers []types.BlobInfo, cache types.BlobInfoCache) *prefetchingImageSource {
+	return &prefetchingImageSource{
+		ImageSource: src,
+		ctx:         ctx,
+		cache:       cache,
+		layers:      layers,
+		pending:     map[digest.Digest]*prefetchedBlob{},
+	}
+}
+
+// GetBlob returns a stream for the specified blob, and the stream’s size (or -1 if unknown).
+// The caller must close the returned ReadCloser.
+func (s *prefetchingImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	s.mutex.Lock()
+	s.prefetchAfterLocked(info.Digest)
+	blob, ok := s.pending[info.Digest]
+	if ok {
+		delete(s.pending, info.Digest)
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		return s.ImageSource.GetBlob(ctx, info, cache)
+	}
+	select {
+	case <-blob.ready:
+	case <-ctx.Done():
+		blob.Close()
+		return nil, -1, ctx.Err()
+	}
+	if blob.err != nil {
+		blob.Close()
+		return nil, -1, blob.err
+	}
+	return blob, blob.size, nil
+}
+
+// prefetchAfterLocked starts prefetching the maxPrefetchedLayers layers following the one with layerDigest, if not already started.
+// The caller must hold s.mutex.
+func (s *prefetchingImageSource) prefetchAfterLocked(layerDigest digest.Digest) {
+	if s.closed {
+		return
+	}
+	for i, layer := range s.layers {
+		if layer.Digest != layerDigest {
+			continue
+		}
+		if s.started < i+1 {
+			s.started = i + 1 // The requested layer itself is fetched directly by GetBlob.
+		}
+		for ; s.started < len(s.layers) && s.started <= i+maxPrefetchedLayers; s.started++ {
+			layer := s.layers[s.started]
+			if _, ok := s.pending[layer.Digest]; !ok {
+				s.pending[layer.Digest] = s.startPrefetch(layer)
+			}
+		}
+		return
+	}
+}
+
+// startPrefetch starts downloading the blob described by info in the background.
+func (s *prefetchingImageSource) startPrefetch(info types.BlobInfo) *prefetchedBlob {
+	ctx, cancel := context.WithCancel(s.ctx)
+	blob := &prefetchedBlob{
+		ready:  make(chan struct{}),
+		chunks: make(chan []byte, maxPrefetchedChunks),
+		cancel: cancel,
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		blob.fetch(ctx, s.ImageSource, info, s.cache)
+	}()
+	return blob
+}
+
+// discardUnused cancels, and waits for, all prefetches which have not been consumed by GetBlob.
+// It does not close the underlying types.ImageSource.
+func (s *prefetchingImageSource) discardUnused() {
+	s.mutex.Lock()
+	s.closed = true
+	for _, blob := range s.pending {
+		blob.Close()
+	}
+	s.pending = map[digest.Digest]*prefetchedBlob{}
+	s.mutex.Unlock()
+	s.wg.Wait()
+}
+
+// prefetchedBlob is an io.ReadCloser for a blob being downloaded in the background.
+type prefetchedBlob struct {
+	ready  chan struct{} // Closed when size and err are set
+	size   int64
+	err    error       // Error from GetBlob
+	chunks chan []byte // Data read so far; closed at the end of the stream, after readErr is set
+	// readErr is the error which terminated the stream, if any.
+	readErr error
+	cancel  context.CancelFunc
+	current []byte // Data from chunks not yet returned by Read
+}
+
+// fetch reads the blob described by info from src into blob.chunks; it is run in a separate goroutine.
+func (blob *prefetchedBlob) fetch(ctx context.Context, src types.ImageSource, info types.BlobInfo, cache types.BlobInfoCache) {
+	stream, size, err := src.GetBlob(ctx, info, cache)
+	blob.size = size
+	blob.err = err
+	close(blob.ready)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	defer close(blob.chunks)
+
+	for {
+		buf := make([]byte, prefetchChunkSize)
+		n, err := stream.Read(buf)
+		if n > 0 {
+			select {
+			case blob.chunks <- buf[:n]:
+			case <-ctx.Done():
+				blob.readErr = ctx.Err()
+				return
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			blob.readErr = err
+			return
+		}
+	}
+}
+
+// Read implements io.Reader.
+func (blob *prefetchedBlob) Read(p []byte) (int, error) {
+	if len(blob.current) == 0 {
+		chunk, ok := <-blob.chunks
+		if !ok {
+			if blob.readErr != nil {
+				return 0, blob.readErr
+			}
+			return 0, io.EOF
+		}
+		blob.current = chunk
+	}
+	n := copy(p, blob.current)
+	blob.current = blob.current[n:]
+	return n, nil
+}
+
+// Close implements io.Closer; it cancels the download if it is still in progress.
+func (blob *prefetchedBlob) Close() error {
+	blob.cancel()
+	return nil
+}
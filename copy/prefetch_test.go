@@ -0,0 +1,146 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/logging"
+	"github.com/containers/image/pkg/metrics"
+	"github.com/containers/image/pkg/tracing"
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getBlobCountingSource is a types.ImageSource with a thread-safe GetBlob, which counts GetBlob calls.
+type getBlobCountingSource struct {
+	types.ImageSource
+	mutex sync.Mutex
+	calls int
+}
+
+func (s *getBlobCountingSource) HasThreadSafeGetBlob() bool {
+	return true
+}
+func (s *getBlobCountingSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	s.mutex.Lock()
+	s.calls++
+	s.mutex.Unlock()
+	return s.ImageSource.GetBlob(ctx, info, cache)
+}
+
+// slowSerialDest is a types.ImageDestination with a slow, not thread-safe, PutBlob, which records the number
+// of GetBlob calls made on src by the time each PutBlob finishes.
+type slowSerialDest struct {
+	types.ImageDestination
+	src      *getBlobCountingSource
+	getBlobs []int
+}
+
+func (d *slowSerialDest) HasThreadSafePutBlob() bool {
+	return false
+}
+func (d *slowSerialDest) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	res, err := d.ImageDestination.PutBlob(ctx, stream, inputInfo, isConfig)
+	time.Sleep(20 * time.Millisecond) // Give prefetches a chance to start.
+	d.src.mutex.Lock()
+	d.getBlobs = append(d.getBlobs, d.src.calls)
+	d.src.mutex.Unlock()
+	return res, err
+}
+
+func TestCopyLayersPrefetch(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "copy-prefetch-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(srcDir)
+	srcRef, err := directory.NewReference(srcDir)
+	require.NoError(t, err)
+	srcDest, err := srcRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	layers := []types.BlobInfo{}
+	for i := 0; i < 5; i++ {
+		// Larger than prefetchChunkSize, to exercise reading multiple chunks.
+		blob := bytes.Repeat([]byte(fmt.Sprintf("layer %d", i)), 10000)
+		info, err := srcDest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Size: -1}, false)
+		require.NoError(t, err)
+		layers = append(layers, info)
+	}
+	srcDest.Close()
+
+	destDir, err := ioutil.TempDir("", "copy-prefetch-dest")
+	require.NoError(t, err)
+	defer os.RemoveAll(destDir)
+	destRef, err := directory.NewReference(destDir)
+	require.NoError(t, err)
+	rawDest, err := destRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer rawDest.Close()
+	rawSrc, err := srcRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer rawSrc.Close()
+	src := &getBlobCountingSource{ImageSource: rawSrc}
+	dest := &slowSerialDest{ImageDestination: rawDest, src: src}
+
+	updates := types.ManifestUpdateOptions{}
+	err = copyLayers(context.Background(), &updates, dest, layersImageMock{layers: layers}, src, blobinfocache.NewMemoryCache(), false, types.GzipCompression, ioutil.Discard, logging.Default, tracing.Default, metrics.Default)
+	require.NoError(t, err)
+	require.Len(t, updates.InformationOnly.LayerInfos, len(layers))
+	for i, info := range updates.InformationOnly.LayerInfos {
+		assert.Equal(t, layers[i].Digest, info.Digest)
+	}
+	// While layer i is being written, layers up to i+maxPrefetchedLayers are already being read, but no further.
+	require.Len(t, dest.getBlobs, len(layers))
+	for i, calls := range dest.getBlobs {
+		expected := i + 1 + maxPrefetchedLayers
+		if expected > len(layers) {
+			expected = len(layers)
+		}
+		assert.Equal(t, expected, calls, "layer %d", i)
+	}
+	assert.Equal(t, len(layers), src.calls)
+}
+
+func TestPrefetchingImageSourceDiscardUnused(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "copy-prefetch-src")
+	require.NoError(t, err)
+	defer os.RemoveAll(srcDir)
+	srcRef, err := directory.NewReference(srcDir)
+	require.NoError(t, err)
+	srcDest, err := srcRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	layers := []types.BlobInfo{}
+	for i := 0; i < 3; i++ {
+		// Larger than the prefetch buffer, so that the prefetch blocks until it is consumed or cancelled.
+		blob := bytes.Repeat([]byte{byte(i)}, 2*prefetchChunkSize*maxPrefetchedChunks)
+		info, err := srcDest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Size: -1}, false)
+		require.NoError(t, err)
+		layers = append(layers, info)
+	}
+	srcDest.Close()
+	rawSrc, err := srcRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer rawSrc.Close()
+
+	prefetcher := newPrefetchingImageSource(context.Background(), rawSrc, layers, blobinfocache.NoCache)
+	stream, _, err := prefetcher.GetBlob(context.Background(), layers[0], blobinfocache.NoCache)
+	require.NoError(t, err)
+	stream.Close()
+	// Layers 1 and 2 are being prefetched; discardUnused must not hang.
+	prefetcher.discardUnused()
+	// Further GetBlob calls work, without prefetching.
+	stream, size, err := prefetcher.GetBlob(context.Background(), layers[1], blobinfocache.NoCache)
+	require.NoError(t, err)
+	defer stream.Close()
+	n, err := io.Copy(ioutil.Discard, stream)
+	require.NoError(t, err)
+	assert.Equal(t, size, n)
+}
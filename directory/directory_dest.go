@@ -1,23 +1,63 @@
 package directory
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/pkg/putblobdigest"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 )
 
+// versionPrefix is the prefix of the contents of the version file; it is followed by the layout version.
+const versionPrefix = "Directory Transport Version: "
+
+// version is the contents of the version file written by this implementation.
+const version = versionPrefix + "1.0\n"
+
 type dirImageDestination struct {
-	ref dirReference
+	ref         dirReference
+	compression types.LayerCompression
 }
 
-// newImageDestination returns an ImageDestination for writing to an existing directory.
-func newImageDestination(ref dirReference) types.ImageDestination {
-	return &dirImageDestination{ref}
+// newImageDestination returns an ImageDestination for writing to a directory.
+// The directory is created if necessary.  If it already contains an image written by this transport,
+// the previous contents are removed, so that e.g. stale signatures are not mixed with the new image;
+// any other non-empty directory is rejected.
+// Layers are stored compressed or decompressed according to ctx.DirLayerCompression.
+func newImageDestination(ctx *types.SystemContext, ref dirReference) (types.ImageDestination, error) {
+	compression := types.PreserveOriginal
+	if ctx != nil {
+		compression = ctx.DirLayerCompression
+	}
+	if err := os.MkdirAll(ref.path, 0755); err != nil {
+		return nil, err
+	}
+	isEmpty, err := isDirEmpty(ref.path)
+	if err != nil {
+		return nil, err
+	}
+	if !isEmpty {
+		if _, err := readVersion(ref); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("Non-empty directory %s does not contain an image written by the dir: transport, refusing to overwrite it", ref.path)
+			}
+			return nil, err
+		}
+		if err := removeDirContents(ref.path); err != nil {
+			return nil, fmt.Errorf("Error removing the previous image in %s: %v", ref.path, err)
+		}
+	}
+	if err := ioutil.WriteFile(ref.versionPath(), []byte(version), 0644); err != nil {
+		return nil, err
+	}
+	return &dirImageDestination{ref: ref, compression: compression}, nil
 }
 
 // Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
@@ -36,22 +76,32 @@ func (d *dirImageDestination) SupportedManifestMIMETypes() []string {
 
 // SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
 // Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
-func (d *dirImageDestination) SupportsSignatures() error {
+func (d *dirImageDestination) SupportsSignatures(ctx context.Context) error {
 	return nil
 }
 
-// ShouldCompressLayers returns true iff it is desirable to compress layer blobs written to this destination.
-func (d *dirImageDestination) ShouldCompressLayers() bool {
-	return false
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d *dirImageDestination) DesiredLayerCompression() types.LayerCompression {
+	return d.compression
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *dirImageDestination) HasThreadSafePutBlob() bool {
+	return true
 }
 
 // PutBlob writes contents of stream and returns data representing the result (with all data filled in).
 // inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
 // inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
 // WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
 // to any other readers for download using the supplied digest.
 // If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
-func (d *dirImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobInfo) (types.BlobInfo, error) {
+func (d *dirImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
 	blobFile, err := ioutil.TempFile(d.ref.path, "dir-put-blob")
 	if err != nil {
 		return types.BlobInfo{}, err
@@ -64,14 +114,12 @@ func (d *dirImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobInfo
 		}
 	}()
 
-	h := sha256.New()
-	tee := io.TeeReader(stream, h)
-
-	size, err := io.Copy(blobFile, tee)
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	size, err := io.Copy(blobFile, stream)
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
-	computedDigest := hex.EncodeToString(h.Sum(nil))
+	computedDigest := digester.Digest()
 	if inputInfo.Size != -1 && size != inputInfo.Size {
 		return types.BlobInfo{}, fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", computedDigest, inputInfo.Size, size)
 	}
@@ -86,16 +134,155 @@ func (d *dirImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobInfo
 		return types.BlobInfo{}, err
 	}
 	succeeded = true
-	return types.BlobInfo{Digest: "sha256:" + computedDigest, Size: size}, nil
+	return types.BlobInfo{Digest: computedDigest, Size: size}, nil
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *dirImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	if err := info.Digest.Validate(); err != nil {
+		return false, types.BlobInfo{}, fmt.Errorf("Can not check for a blob with invalid digest %q: %v", info.Digest, err)
+	}
+	scope := types.BICTransportScope{Opaque: d.ref.resolvedPath}
+
+	fi, err := os.Stat(d.ref.layerPath(info.Digest))
+	if err == nil {
+		cache.RecordKnownLocation(d.ref.Transport(), scope, info.Digest, types.BICLocationReference{})
+		return true, types.BlobInfo{Digest: info.Digest, Size: fi.Size()}, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, types.BlobInfo{}, err
+	}
+
+	// Then try the equivalent blobs, e.g. the uncompressed version of the blob, stored if d.compression == types.Decompress,
+	// or a blob with the same uncompressed contents which is known to have been present in this directory.
+	if canSubstitute {
+		candidates := []digest.Digest{}
+		if uncompressed := cache.UncompressedDigest(info.Digest); uncompressed != "" && uncompressed != info.Digest {
+			candidates = append(candidates, uncompressed)
+		}
+		for _, candidate := range cache.CandidateLocations(d.ref.Transport(), scope, info.Digest, true) {
+			if candidate.Digest != info.Digest {
+				candidates = append(candidates, candidate.Digest)
+			}
+		}
+		for _, candidate := range candidates {
+			if candidate.Validate() != nil {
+				continue
+			}
+			found, substitute, err := substituteBlobInfo(d.ref.layerPath(candidate), candidate)
+			if err != nil {
+				return false, types.BlobInfo{}, err
+			}
+			if !found ||
+				(d.compression == types.Decompress && substitute.CompressionOperation == types.Compress) ||
+				(d.compression == types.Compress && substitute.CompressionOperation == types.Decompress) {
+				continue
+			}
+			cache.RecordKnownLocation(d.ref.Transport(), scope, candidate, types.BICLocationReference{})
+			return true, substitute, nil
+		}
+	}
+	return false, types.BlobInfo{}, nil
+}
+
+// substituteBlobInfo returns a BlobInfo for using the blob with candidateDigest, stored at path, instead of a blob with the same
+// uncompressed contents, or false if path does not exist.  The compression of the returned BlobInfo describes the stored blob,
+// so that the manifest can be updated accordingly.
+func substituteBlobInfo(path string, candidateDigest digest.Digest) (bool, types.BlobInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, types.BlobInfo{}, nil
+		}
+		return false, types.BlobInfo{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, types.BlobInfo{}, err
+	}
+	algorithm, _, _, err := compression.DetectCompressionFormat(f)
+	if err != nil {
+		return false, types.BlobInfo{}, err
+	}
+	res := types.BlobInfo{Digest: candidateDigest, Size: fi.Size(), CompressionOperation: types.Decompress}
+	if algorithm.IsCompressed() {
+		res.CompressionOperation = types.Compress
+		res.CompressionAlgorithm = algorithm.Name()
+	}
+	return true, res, nil
 }
 
-func (d *dirImageDestination) PutManifest(manifest []byte) error {
-	return ioutil.WriteFile(d.ref.manifestPath(), manifest, 0644)
+// PutManifest writes manifest to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for (when the primary manifest is a manifest list);
+// instances are written to separate files next to the primary manifest.
+func (d *dirImageDestination) PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		if err := instanceDigest.Validate(); err != nil {
+			return fmt.Errorf("Invalid instance digest %q: %v", *instanceDigest, err)
+		}
+	}
+	return ioutil.WriteFile(d.ref.manifestPath(instanceDigest), manifest, 0644)
 }
 
-func (d *dirImageDestination) PutSignatures(signatures [][]byte) error {
+// PutSignatures writes signatures to signature-N files next to the manifest, replacing any previously written by this destination.
+// If instanceDigest is not nil, the signatures of that instance of a manifest list are written to separate DIGEST.signature-N files.
+func (d *dirImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		if err := instanceDigest.Validate(); err != nil {
+			return fmt.Errorf("Invalid instance digest %q: %v", *instanceDigest, err)
+		}
+	}
 	for i, sig := range signatures {
-		if err := ioutil.WriteFile(d.ref.signaturePath(i), sig, 0644); err != nil {
+		if err := ioutil.WriteFile(d.ref.signaturePath(i, instanceDigest), sig, 0644); err != nil {
+			return err
+		}
+	}
+	// GetSignatures reads signature-N files until the first one missing, so remove any left over from an earlier call.
+	for i := len(signatures); ; i++ {
+		if err := os.Remove(d.ref.signaturePath(i, instanceDigest)); err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Compile-time check that dirImageDestination implements types.SigstoreSignaturesDestination
+var _ types.SigstoreSignaturesDestination = (*dirImageDestination)(nil)
+
+// PutSigstoreSignatures writes sigstore signatures to sigstore-signature-N files next to the manifest,
+// replacing any previously written by this destination.
+func (d *dirImageDestination) PutSigstoreSignatures(ctx context.Context, signatures []types.SigstoreSignature) error {
+	for i, sig := range signatures {
+		contents, err := json.Marshal(sigstoreSignatureFile{
+			Payload:          sig.Payload,
+			Signature:        sig.Signature,
+			Certificate:      sig.Certificate,
+			CertificateChain: sig.CertificateChain,
+			RekorBundle:      sig.RekorBundle,
+		})
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(d.ref.sigstoreSignaturePath(i), contents, 0644); err != nil {
+			return err
+		}
+	}
+	// GetSigstoreSignatures reads sigstore-signature-N files until the first one missing, so remove any left over from an earlier call.
+	for i := len(signatures); ; i++ {
+		if err := os.Remove(d.ref.sigstoreSignaturePath(i)); err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
 			return err
 		}
 	}
@@ -106,6 +293,29 @@ func (d *dirImageDestination) PutSignatures(signatures [][]byte) error {
 // WARNING: This does not have any transactional semantics:
 // - Uploaded data MAY be visible to others before Commit() is called
 // - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
-func (d *dirImageDestination) Commit() error {
+func (d *dirImageDestination) Commit(ctx context.Context) error {
+	return nil
+}
+
+// isDirEmpty returns true iff path is an empty directory.
+func isDirEmpty(path string) (bool, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	return len(files) == 0, nil
+}
+
+// removeDirContents removes everything within path, but not path itself.
+func removeDirContents(path string) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.RemoveAll(filepath.Join(path, file.Name())); err != nil {
+			return err
+		}
+	}
 	return nil
 }
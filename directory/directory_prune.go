@@ -0,0 +1,139 @@
+package directory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Prune removes the files in the directory at path which are not referenced by the image it contains, e.g. blobs and instance manifests
+// left behind when a different image was written to the same directory.  The digests of the removed blobs and instance manifests are
+// reported; signatures of removed instance manifests are removed as well.  If dryRun, nothing is removed, and the result only reports
+// what would be removed.
+// Files which were not written by this transport are left alone.  Prune must not be used while an image is being written to the directory.
+func Prune(path string, dryRun bool) (*types.PruneResult, error) {
+	ref := dirReference{path: path}
+	v, err := readVersion(ref)
+	if err != nil && !os.IsNotExist(err) { // A missing version file means a directory written before the file was introduced.
+		return nil, err
+	}
+	if err == nil && !strings.HasPrefix(v, "1.") {
+		return nil, fmt.Errorf("Unsupported dir: layout version %q in %s", v, path)
+	}
+	reachableBlobs, reachableInstances, err := ref.reachableFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	res := &types.PruneResult{Blobs: []digest.Digest{}}
+	reported := map[digest.Digest]struct{}{}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		name := entry.Name()
+		var d digest.Digest
+		reachable := reachableBlobs
+		report := true
+		switch {
+		case strings.HasSuffix(name, ".manifest.json"):
+			d = digestFromHex(strings.TrimSuffix(name, ".manifest.json"))
+			reachable = reachableInstances
+		case strings.Contains(name, ".signature-"):
+			d = digestFromHex(name[:strings.Index(name, ".signature-")])
+			reachable = reachableInstances
+			report = false // The signature is removed together with the manifest it signs.
+		default:
+			d = digestFromHex(strings.TrimSuffix(name, ".tar"))
+		}
+		if d == "" {
+			continue // Not a file written by this transport (or e.g. manifest.json, version); leave it alone.
+		}
+		if _, ok := reachable[d.Hex()]; ok {
+			continue
+		}
+
+		if _, ok := reported[d]; report && !ok {
+			res.Blobs = append(res.Blobs, d)
+			reported[d] = struct{}{}
+		}
+		res.Size += entry.Size()
+		if !dryRun {
+			if err := os.Remove(filepath.Join(path, name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Slice(res.Blobs, func(i, j int) bool { return res.Blobs[i] < res.Blobs[j] })
+	return res, nil
+}
+
+// reachableFiles returns the hex values of the digests of all blobs and of all instance manifests referenced, directly or indirectly,
+// by the manifest of the image in the directory of ref.  (The directory layout does not record the digest algorithm.)
+func (ref dirReference) reachableFiles() (map[string]struct{}, map[string]struct{}, error) {
+	blobs := map[string]struct{}{}
+	instances := map[string]struct{}{}
+	var addReferences func(path string) error
+	addReferences = func(path string) error {
+		m, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		blobDigests, manifestDigests, err := manifest.References(m)
+		if err != nil {
+			return fmt.Errorf("Error parsing manifest %s: %v", path, err)
+		}
+		for _, d := range blobDigests {
+			blobs[d.Hex()] = struct{}{}
+		}
+		for _, d := range manifestDigests {
+			if _, ok := instances[d.Hex()]; ok {
+				continue
+			}
+			instances[d.Hex()] = struct{}{}
+			d := d
+			if err := addReferences(ref.manifestPath(&d)); err != nil {
+				if !os.IsNotExist(err) {
+					return err
+				}
+				// The instance was not copied; nothing is reachable through it.
+				logrus.Debugf("Manifest %s referenced in %s does not exist", d, ref.path)
+			}
+		}
+		return nil
+	}
+
+	if err := addReferences(ref.manifestPath(nil)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("%s does not contain an image written by the dir: transport", ref.path)
+		}
+		return nil, nil, err
+	}
+	return blobs, instances, nil
+}
+
+// digestFromHex returns the digest which may be stored in a file named hex by this transport, or "" if hex is not a valid digest value.
+func digestFromHex(hex string) digest.Digest {
+	for _, algorithm := range []digest.Algorithm{digest.SHA256, digest.SHA384, digest.SHA512} {
+		if len(hex) != algorithm.Size()*2 {
+			continue
+		}
+		d := digest.NewDigestFromEncoded(algorithm, hex)
+		if d.Validate() == nil {
+			return d
+		}
+	}
+	return ""
+}
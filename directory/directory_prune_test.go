@@ -0,0 +1,128 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	putBlob := func(blob string) map[string]interface{} {
+		info, err := dest.PutBlob(context.Background(), bytes.NewReader([]byte(blob)), types.BlobInfo{Size: int64(len(blob))}, false)
+		require.NoError(t, err)
+		return map[string]interface{}{"digest": info.Digest, "size": info.Size, "mediaType": imgspecv1.MediaTypeImageLayer}
+	}
+	putManifest := func(config string, layers ...string) digest.Digest {
+		layerDescriptors := []map[string]interface{}{}
+		for _, l := range layers {
+			layerDescriptors = append(layerDescriptors, putBlob(l))
+		}
+		m, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     imgspecv1.MediaTypeImageManifest,
+			"config":        putBlob(config),
+			"layers":        layerDescriptors,
+		})
+		require.NoError(t, err)
+		d := digest.FromBytes(m)
+		require.NoError(t, dest.PutManifest(context.Background(), m, &d))
+		require.NoError(t, dest.PutSignatures(context.Background(), [][]byte{[]byte("sig-" + config)}, &d))
+		return d
+	}
+
+	instance1 := putManifest("config-1", "shared", "layer-1")
+	instance2 := putManifest("config-2", "shared", "layer-2")
+	staleInstance := putManifest("config-stale", "shared", "layer-stale")
+	list, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     imgspecv1.MediaTypeImageManifestList,
+		"manifests": []map[string]interface{}{
+			{"digest": instance1, "size": 1, "mediaType": imgspecv1.MediaTypeImageManifest},
+			{"digest": instance2, "size": 1, "mediaType": imgspecv1.MediaTypeImageManifest},
+			// Not copied, e.g. because only some instances were selected.
+			{"digest": digest.FromString("missing"), "size": 1, "mediaType": imgspecv1.MediaTypeImageManifest},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), list, nil))
+	require.NoError(t, dest.PutSignatures(context.Background(), [][]byte{[]byte("sig")}, nil))
+	require.NoError(t, dest.Commit(context.Background()))
+
+	// A blob using the naming of directories written before the version file was introduced.
+	legacyBlob := digest.FromString("legacy")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, legacyBlob.Hex()+".tar"), []byte("legacy"), 0644))
+	// Files not written by this transport are ignored.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "unrelated"), []byte("unrelated"), 0644))
+
+	staleManifestFile := filepath.Join(tmpDir, staleInstance.Hex()+".manifest.json")
+	staleManifest, err := ioutil.ReadFile(staleManifestFile)
+	require.NoError(t, err)
+	staleFiles := []string{
+		staleManifestFile,
+		filepath.Join(tmpDir, staleInstance.Hex()+".signature-1"),
+		filepath.Join(tmpDir, digest.FromString("config-stale").Hex()),
+		filepath.Join(tmpDir, digest.FromString("layer-stale").Hex()),
+		filepath.Join(tmpDir, legacyBlob.Hex()+".tar"),
+	}
+	expected := &types.PruneResult{
+		Blobs: []digest.Digest{staleInstance, digest.FromString("config-stale"), digest.FromString("layer-stale"), legacyBlob},
+		Size:  int64(len(staleManifest) + len("sig-config-stale") + len("config-stale") + len("layer-stale") + len("legacy")),
+	}
+	sort.Slice(expected.Blobs, func(i, j int) bool { return expected.Blobs[i] < expected.Blobs[j] })
+
+	res, err := Prune(tmpDir, true)
+	require.NoError(t, err)
+	assert.Equal(t, expected, res)
+	for _, path := range staleFiles {
+		_, err := os.Stat(path)
+		assert.NoError(t, err, path)
+	}
+
+	res, err = Prune(tmpDir, false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, res)
+	for _, path := range staleFiles {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), path)
+	}
+	for _, name := range []string{"version", "manifest.json", "signature-1", "unrelated",
+		instance1.Hex() + ".manifest.json", instance1.Hex() + ".signature-1", instance2.Hex() + ".manifest.json",
+		digest.FromString("shared").Hex(), digest.FromString("config-1").Hex(), digest.FromString("layer-2").Hex()} {
+		_, err := os.Stat(filepath.Join(tmpDir, name))
+		assert.NoError(t, err, name)
+	}
+
+	// Nothing more to remove
+	res, err = Prune(tmpDir, false)
+	require.NoError(t, err)
+	assert.Equal(t, &types.PruneResult{Blobs: []digest.Digest{}}, res)
+
+	// A directory without an image
+	emptyDir, err := ioutil.TempDir("", "dir-prune")
+	require.NoError(t, err)
+	defer os.RemoveAll(emptyDir)
+	_, err = Prune(emptyDir, false)
+	assert.Error(t, err)
+
+	// An invalid manifest
+	require.NoError(t, ioutil.WriteFile(filepath.Join(emptyDir, "manifest.json"), []byte(`{"config":{"digest":"invalid"}}`), 0644))
+	_, err = Prune(emptyDir, false)
+	assert.Error(t, err)
+}
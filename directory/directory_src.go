@@ -1,13 +1,19 @@
 package directory
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 )
 
 type dirImageSource struct {
@@ -16,8 +22,29 @@ type dirImageSource struct {
 
 // newImageSource returns an ImageSource reading from an existing directory.
 // The caller must call .Close() on the returned ImageSource.
-func newImageSource(ref dirReference) types.ImageSource {
-	return &dirImageSource{ref}
+func newImageSource(ref dirReference) (types.ImageSource, error) {
+	v, err := readVersion(ref)
+	if err != nil && !os.IsNotExist(err) { // A missing version file means a directory written before the file was introduced.
+		return nil, err
+	}
+	if err == nil && !strings.HasPrefix(v, "1.") {
+		return nil, fmt.Errorf("Unsupported dir: layout version %q in %s", v, ref.path)
+	}
+	return &dirImageSource{ref}, nil
+}
+
+// readVersion returns the layout version recorded in the directory of ref.
+// If the directory does not contain a version file, the returned error satisfies os.IsNotExist.
+func readVersion(ref dirReference) (string, error) {
+	contents, err := ioutil.ReadFile(ref.versionPath())
+	if err != nil {
+		return "", err
+	}
+	s := string(contents)
+	if !strings.HasPrefix(s, versionPrefix) {
+		return "", fmt.Errorf("Invalid version file %s", ref.versionPath())
+	}
+	return strings.TrimSpace(strings.TrimPrefix(s, versionPrefix)), nil
 }
 
 // Reference returns the reference used to set up this source, _as specified by the user_
@@ -32,35 +59,98 @@ func (s *dirImageSource) Close() {
 
 // GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
 // It may use a remote (= slow) service.
-func (s *dirImageSource) GetManifest() ([]byte, string, error) {
-	m, err := ioutil.ReadFile(s.ref.manifestPath())
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *dirImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		if err := instanceDigest.Validate(); err != nil {
+			return nil, "", fmt.Errorf("Invalid instance digest %q: %v", *instanceDigest, err)
+		}
+	}
+	m, err := ioutil.ReadFile(s.ref.manifestPath(instanceDigest))
 	if err != nil {
 		return nil, "", err
 	}
 	return m, manifest.GuessMIMEType(m), err
 }
 
-func (s *dirImageSource) GetTargetManifest(digest string) ([]byte, string, error) {
-	return nil, "", fmt.Errorf("Getting target manifest not supported by dir:")
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *dirImageSource) HasThreadSafeGetBlob() bool {
+	return true
 }
 
 // GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
-func (s *dirImageSource) GetBlob(digest string) (io.ReadCloser, int64, error) {
-	r, err := os.Open(s.ref.layerPath(digest))
+func (s *dirImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if err := info.Digest.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("Invalid blob digest %q: %v", info.Digest, err)
+	}
+	r, err := os.Open(s.ref.layerPath(info.Digest))
+	if err != nil && os.IsNotExist(err) {
+		r, err = os.Open(s.ref.legacyLayerPath(info.Digest))
+	}
 	if err != nil {
-		return nil, 0, nil
+		return nil, 0, err
 	}
 	fi, err := r.Stat()
 	if err != nil {
-		return nil, 0, nil
+		r.Close()
+		return nil, 0, err
 	}
 	return r, fi.Size(), nil
 }
 
-func (s *dirImageSource) GetSignatures() ([][]byte, error) {
+// GetBlobAt returns streams for the specified chunks of the blob.
+func (s *dirImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo, chunks []types.ImageSourceChunk) (chan io.ReadCloser, chan error, error) {
+	r, _, err := s.GetBlob(ctx, info, blobinfocache.NoCache)
+	if err != nil {
+		return nil, nil, err
+	}
+	f := r.(*os.File)
+	streams := make(chan io.ReadCloser)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		defer close(streams)
+		defer f.Close()
+		for _, chunk := range chunks {
+			closed := make(chan struct{})
+			select {
+			case streams <- &chunkReader{SectionReader: io.NewSectionReader(f, int64(chunk.Offset), int64(chunk.Length)), closed: closed}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-closed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return streams, errs, nil
+}
+
+// chunkReader is a stream returned by dirImageSource.GetBlobAt; closing it closes the closed channel.
+type chunkReader struct {
+	*io.SectionReader
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (r *chunkReader) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
+
+// GetSignatures returns the image's signatures, or, if instanceDigest is not nil, the signatures of that instance of a manifest list.
+func (s *dirImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	if instanceDigest != nil {
+		if err := instanceDigest.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid instance digest %q: %v", *instanceDigest, err)
+		}
+	}
 	signatures := [][]byte{}
 	for i := 0; ; i++ {
-		signature, err := ioutil.ReadFile(s.ref.signaturePath(i))
+		signature, err := ioutil.ReadFile(s.ref.signaturePath(i, instanceDigest))
 		if err != nil {
 			if os.IsNotExist(err) {
 				break
@@ -71,3 +161,33 @@ func (s *dirImageSource) GetSignatures() ([][]byte, error) {
 	}
 	return signatures, nil
 }
+
+// Compile-time check that dirImageSource implements types.SigstoreSignaturesSource
+var _ types.SigstoreSignaturesSource = (*dirImageSource)(nil)
+
+// GetSigstoreSignatures returns the image's sigstore signatures, stored in sigstore-signature-N files.
+func (s *dirImageSource) GetSigstoreSignatures(ctx context.Context) ([]types.SigstoreSignature, error) {
+	signatures := []types.SigstoreSignature{}
+	for i := 0; ; i++ {
+		path := s.ref.sigstoreSignaturePath(i)
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return nil, err
+		}
+		var sig sigstoreSignatureFile
+		if err := json.Unmarshal(contents, &sig); err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %v", path, err)
+		}
+		signatures = append(signatures, types.SigstoreSignature{
+			Payload:          sig.Payload,
+			Signature:        sig.Signature,
+			Certificate:      sig.Certificate,
+			CertificateChain: sig.CertificateChain,
+			RekorBundle:      sig.RekorBundle,
+		})
+	}
+	return signatures, nil
+}
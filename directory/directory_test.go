@@ -2,14 +2,17 @@ package directory
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/containers/image/pkg/blobinfocache"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,7 +21,7 @@ func TestDestinationReference(t *testing.T) {
 	ref, tmpDir := refToTempDir(t)
 	defer os.RemoveAll(tmpDir)
 
-	dest, err := ref.NewImageDestination(nil)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 	defer dest.Close()
 	ref2 := dest.Reference()
@@ -30,46 +33,59 @@ func TestGetPutManifest(t *testing.T) {
 	defer os.RemoveAll(tmpDir)
 
 	man := []byte("test-manifest")
-	dest, err := ref.NewImageDestination(nil)
+	instance := []byte("test-instance-manifest")
+	instanceDigest := digest.FromBytes(instance)
+	invalidDigest := digest.Digest("sha256:../../this-is-invalid")
+	dest, err := ref.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 	defer dest.Close()
-	err = dest.PutManifest(man)
+	err = dest.PutManifest(context.Background(), instance, &instanceDigest)
 	assert.NoError(t, err)
-	err = dest.Commit()
+	err = dest.PutManifest(context.Background(), instance, &invalidDigest)
+	assert.Error(t, err)
+	err = dest.PutManifest(context.Background(), man, nil)
+	assert.NoError(t, err)
+	err = dest.Commit(context.Background())
 	assert.NoError(t, err)
 
-	src, err := ref.NewImageSource(nil, nil)
+	src, err := ref.NewImageSource(context.Background(), nil)
 	require.NoError(t, err)
 	defer src.Close()
-	m, mt, err := src.GetManifest()
+	m, mt, err := src.GetManifest(context.Background(), nil)
 	assert.NoError(t, err)
 	assert.Equal(t, man, m)
 	assert.Equal(t, "", mt)
+	m, _, err = src.GetManifest(context.Background(), &instanceDigest)
+	assert.NoError(t, err)
+	assert.Equal(t, instance, m)
+	missingDigest := digest.FromBytes([]byte("missing"))
+	_, _, err = src.GetManifest(context.Background(), &missingDigest)
+	assert.Error(t, err)
+	_, _, err = src.GetManifest(context.Background(), &invalidDigest)
+	assert.Error(t, err)
 }
 
 func TestGetPutBlob(t *testing.T) {
 	ref, tmpDir := refToTempDir(t)
 	defer os.RemoveAll(tmpDir)
 
-	digest := "digest-test"
 	blob := []byte("test-blob")
-	dest, err := ref.NewImageDestination(nil)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 	defer dest.Close()
-	compress := dest.ShouldCompressLayers()
-	assert.False(t, compress)
-	info, err := dest.PutBlob(bytes.NewReader(blob), types.BlobInfo{Digest: digest, Size: int64(9)})
+	compression := dest.DesiredLayerCompression()
+	assert.Equal(t, types.PreserveOriginal, compression)
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: "", Size: int64(9)}, false)
 	assert.NoError(t, err)
-	err = dest.Commit()
+	err = dest.Commit(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, int64(9), info.Size)
-	hash := sha256.Sum256(blob)
-	assert.Equal(t, "sha256:"+hex.EncodeToString(hash[:]), info.Digest)
+	assert.Equal(t, digest.FromBytes(blob), info.Digest)
 
-	src, err := ref.NewImageSource(nil, nil)
+	src, err := ref.NewImageSource(context.Background(), nil)
 	require.NoError(t, err)
 	defer src.Close()
-	rc, size, err := src.GetBlob(info.Digest)
+	rc, size, err := src.GetBlob(context.Background(), info, blobinfocache.NoCache)
 	assert.NoError(t, err)
 	defer rc.Close()
 	b, err := ioutil.ReadAll(rc)
@@ -78,6 +94,98 @@ func TestGetPutBlob(t *testing.T) {
 	assert.Equal(t, int64(len(blob)), size)
 }
 
+func TestTryReusingBlob(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	blob := []byte("test-blob")
+	blobDigest := digest.FromBytes(blob)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	reused, _, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, false)
+	require.NoError(t, err)
+	reused, info, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache, false)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, info)
+
+	_, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: "", Size: -1}, blobinfocache.NoCache, false)
+	assert.Error(t, err)
+}
+
+// gzipBlob returns data compressed using gzip, with the specified header name so that different blobs with the same contents can be created.
+func gzipBlob(t *testing.T, data []byte, name string) []byte {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	w.Name = name
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestTryReusingBlobSubstitution(t *testing.T) {
+	uncompressed := []byte("uncompressed layer")
+	uncompressedDigest := digest.FromBytes(uncompressed)
+	compressed1 := gzipBlob(t, uncompressed, "1")
+	compressed1Digest := digest.FromBytes(compressed1)
+	compressed2 := gzipBlob(t, uncompressed, "2")
+	compressed2Digest := digest.FromBytes(compressed2)
+
+	for _, c := range []struct {
+		compression  types.LayerCompression
+		uncompressed bool // Whether the uncompressed blob can substitute compressed1
+		compressed   bool // Whether compressed2 can substitute compressed1
+	}{
+		{types.PreserveOriginal, true, true},
+		{types.Decompress, true, false},
+		{types.Compress, false, true},
+	} {
+		ref, tmpDir := refToTempDir(t)
+		defer os.RemoveAll(tmpDir)
+		dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{DirLayerCompression: c.compression})
+		require.NoError(t, err)
+		defer dest.Close()
+		cache := blobinfocache.NewMemoryCache()
+		cache.RecordDigestUncompressedPair(compressed1Digest, uncompressedDigest)
+		cache.RecordDigestUncompressedPair(compressed2Digest, uncompressedDigest)
+
+		// The uncompressed version, found using cache.UncompressedDigest.
+		_, err = dest.PutBlob(context.Background(), bytes.NewReader(uncompressed), types.BlobInfo{Digest: uncompressedDigest, Size: int64(len(uncompressed))}, false)
+		require.NoError(t, err)
+		reused, _, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: compressed1Digest, Size: -1}, cache, false)
+		require.NoError(t, err)
+		assert.False(t, reused)
+		reused, info, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: compressed1Digest, Size: -1}, cache, true)
+		require.NoError(t, err)
+		assert.Equal(t, c.uncompressed, reused)
+		if c.uncompressed {
+			assert.Equal(t, types.BlobInfo{Digest: uncompressedDigest, Size: int64(len(uncompressed)), CompressionOperation: types.Decompress}, info)
+		}
+		require.NoError(t, os.Remove(filepath.Join(tmpDir, uncompressedDigest.Hex())))
+
+		// A different compressed version, known to be present using cache.CandidateLocations.
+		_, err = dest.PutBlob(context.Background(), bytes.NewReader(compressed2), types.BlobInfo{Digest: compressed2Digest, Size: int64(len(compressed2))}, false)
+		require.NoError(t, err)
+		reused, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: compressed2Digest, Size: -1}, cache, false)
+		require.NoError(t, err)
+		require.True(t, reused)
+		reused, info, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: compressed1Digest, Size: -1}, cache, true)
+		require.NoError(t, err)
+		assert.Equal(t, c.compressed, reused)
+		if c.compressed {
+			assert.Equal(t, types.BlobInfo{Digest: compressed2Digest, Size: int64(len(compressed2)), CompressionOperation: types.Compress,
+				CompressionAlgorithm: types.GzipCompression}, info)
+		}
+	}
+}
+
 // readerFromFunc allows implementing Reader by any function, e.g. a closure.
 type readerFromFunc func([]byte) (int, error)
 
@@ -88,7 +196,7 @@ func (fn readerFromFunc) Read(p []byte) (int, error) {
 // TestPutBlobDigestFailure simulates behavior on digest verification failure.
 func TestPutBlobDigestFailure(t *testing.T) {
 	const digestErrorString = "Simulated digest error"
-	const blobDigest = "test-digest"
+	const blobDigest = "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"
 
 	ref, tmpDir := refToTempDir(t)
 	defer os.RemoveAll(tmpDir)
@@ -113,13 +221,13 @@ func TestPutBlobDigestFailure(t *testing.T) {
 		return 0, fmt.Errorf(digestErrorString)
 	})
 
-	dest, err := ref.NewImageDestination(nil)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 	defer dest.Close()
-	_, err = dest.PutBlob(reader, types.BlobInfo{Digest: blobDigest, Size: -1})
+	_, err = dest.PutBlob(context.Background(), reader, types.BlobInfo{Digest: blobDigest, Size: -1}, false)
 	assert.Error(t, err)
 	assert.Contains(t, digestErrorString, err.Error())
-	err = dest.Commit()
+	err = dest.Commit(context.Background())
 	assert.NoError(t, err)
 
 	_, err = os.Lstat(blobPath)
@@ -131,35 +239,270 @@ func TestGetPutSignatures(t *testing.T) {
 	ref, tmpDir := refToTempDir(t)
 	defer os.RemoveAll(tmpDir)
 
-	dest, err := ref.NewImageDestination(nil)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 	defer dest.Close()
 	signatures := [][]byte{
 		[]byte("sig1"),
 		[]byte("sig2"),
 	}
-	err = dest.SupportsSignatures()
+	err = dest.SupportsSignatures(context.Background())
 	assert.NoError(t, err)
-	err = dest.PutSignatures(signatures)
+	err = dest.PutSignatures(context.Background(), signatures, nil)
 	assert.NoError(t, err)
-	err = dest.Commit()
+	err = dest.Commit(context.Background())
 	assert.NoError(t, err)
 
-	src, err := ref.NewImageSource(nil, nil)
+	src, err := ref.NewImageSource(context.Background(), nil)
 	require.NoError(t, err)
 	defer src.Close()
-	sigs, err := src.GetSignatures()
+	sigs, err := src.GetSignatures(context.Background(), nil)
 	assert.NoError(t, err)
 	assert.Equal(t, signatures, sigs)
 }
 
+func TestGetPutSignaturesInstance(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	instanceDigest := digest.FromBytes([]byte("test-instance-manifest"))
+	invalidDigest := digest.Digest("sha256:../../this-is-invalid")
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig1")}, nil)
+	require.NoError(t, err)
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("instance-sig1"), []byte("instance-sig2")}, &instanceDigest)
+	require.NoError(t, err)
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig")}, &invalidDigest)
+	assert.Error(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	sigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig1")}, sigs)
+	sigs, err = src.GetSignatures(context.Background(), &instanceDigest)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("instance-sig1"), []byte("instance-sig2")}, sigs)
+	otherDigest := digest.FromBytes([]byte("other"))
+	sigs, err = src.GetSignatures(context.Background(), &otherDigest)
+	require.NoError(t, err)
+	assert.Empty(t, sigs)
+	_, err = src.GetSignatures(context.Background(), &invalidDigest)
+	assert.Error(t, err)
+}
+
 func TestSourceReference(t *testing.T) {
 	ref, tmpDir := refToTempDir(t)
 	defer os.RemoveAll(tmpDir)
 
-	src, err := ref.NewImageSource(nil, nil)
+	src, err := ref.NewImageSource(context.Background(), nil)
 	require.NoError(t, err)
 	defer src.Close()
 	ref2 := src.Reference()
 	assert.Equal(t, tmpDir, ref2.StringWithinTransport())
 }
+
+func TestDestinationVersion(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	contents, err := ioutil.ReadFile(tmpDir + "/version")
+	require.NoError(t, err)
+	assert.Equal(t, version, string(contents))
+}
+
+func TestDestinationRemovesStaleImage(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig1"), []byte("sig2")}, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+	dest.Close()
+
+	dest, err = ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig3")}, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	sigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig3")}, sigs)
+}
+
+func TestPutSignaturesReplacesSignatures(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig1"), []byte("sig2"), []byte("sig3")}, nil)
+	require.NoError(t, err)
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig4")}, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	sigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig4")}, sigs)
+	_, err = os.Lstat(tmpDir + "/signature-2")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestGetPutSigstoreSignatures(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	sigstoreDest, ok := dest.(types.SigstoreSignaturesDestination)
+	require.True(t, ok)
+	signatures := []types.SigstoreSignature{
+		{Payload: []byte("payload1"), Signature: []byte("sig1")},
+		{Payload: []byte("payload2"), Signature: []byte("sig2"), Certificate: []byte("cert"), CertificateChain: []byte("chain"), RekorBundle: []byte("{}")},
+	}
+	err = sigstoreDest.PutSigstoreSignatures(context.Background(), append(signatures, types.SigstoreSignature{Payload: []byte("stale")}))
+	require.NoError(t, err)
+	err = sigstoreDest.PutSigstoreSignatures(context.Background(), signatures)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	sigstoreSrc, ok := src.(types.SigstoreSignaturesSource)
+	require.True(t, ok)
+	sigs, err := sigstoreSrc.GetSigstoreSignatures(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, signatures, sigs)
+	// Simple signing signatures are stored separately.
+	simpleSigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, simpleSigs)
+
+	err = ioutil.WriteFile(tmpDir+"/sigstore-signature-1", []byte("invalid"), 0644)
+	require.NoError(t, err)
+	_, err = sigstoreSrc.GetSigstoreSignatures(context.Background())
+	assert.Error(t, err)
+}
+
+func TestDestinationRefusesNonImageDirectory(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	err := ioutil.WriteFile(tmpDir+"/unrelated", []byte("unrelated"), 0644)
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(context.Background(), nil)
+	assert.Error(t, err)
+	_, err = os.Lstat(tmpDir + "/unrelated")
+	assert.NoError(t, err)
+}
+
+func TestSourceVersion(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+
+	for _, c := range []struct {
+		contents string
+		ok       bool
+	}{
+		{version, true},
+		{"Directory Transport Version: 1.99\n", true},
+		{"Directory Transport Version: 2.0\n", false},
+		{"this is invalid", false},
+	} {
+		err := ioutil.WriteFile(tmpDir+"/version", []byte(c.contents), 0644)
+		require.NoError(t, err)
+		src, err := ref.NewImageSource(context.Background(), nil)
+		if c.ok {
+			require.NoError(t, err, c.contents)
+			src.Close()
+		} else {
+			assert.Error(t, err, c.contents)
+		}
+	}
+}
+
+func TestGetBlobLegacyLayerPath(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+	dirRef, ok := ref.(dirReference)
+	require.True(t, ok)
+
+	blob := []byte("test-blob")
+	blobDigest := digest.FromBytes(blob)
+	err := ioutil.WriteFile(dirRef.legacyLayerPath(blobDigest), blob, 0644)
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	rc, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache)
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, blob, b)
+	assert.Equal(t, int64(len(blob)), size)
+
+	_, _, err = src.GetBlob(context.Background(), types.BlobInfo{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000", Size: -1}, blobinfocache.NoCache)
+	assert.Error(t, err)
+}
+
+func TestGetBlobAt(t *testing.T) {
+	ref, tmpDir := refToTempDir(t)
+	defer os.RemoveAll(tmpDir)
+	dirRef, ok := ref.(dirReference)
+	require.True(t, ok)
+
+	blob := []byte("0123456789abcdefghij")
+	blobDigest := digest.FromBytes(blob)
+	err := ioutil.WriteFile(dirRef.layerPath(blobDigest), blob, 0644)
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	seekable, ok := src.(types.ImageSourceSeekable)
+	require.True(t, ok)
+	streams, errs, err := seekable.GetBlobAt(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1},
+		[]types.ImageSourceChunk{{Offset: 2, Length: 3}, {Offset: 15, Length: 5}})
+	require.NoError(t, err)
+	chunks := []string{}
+	for stream := range streams {
+		b, err := ioutil.ReadAll(stream)
+		require.NoError(t, err)
+		stream.Close()
+		chunks = append(chunks, string(b))
+	}
+	assert.NoError(t, <-errs)
+	assert.Equal(t, []string{"234", "fghij"}, chunks)
+
+	_, _, err = seekable.GetBlobAt(context.Background(), types.BlobInfo{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000", Size: -1},
+		[]types.ImageSourceChunk{{Offset: 0, Length: 1}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containers/image/docker/tarfile"
+	"github.com/containers/image/types"
+)
+
+type archiveImageDestination struct {
+	*tarfile.Destination // Implements most of types.ImageDestination
+	ref                  archiveReference
+	writer               *os.File
+	committed            bool
+}
+
+// newImageDestination returns a types.ImageDestination for the specified image reference.
+// The archive is created at ref.path, which must not exist yet.
+func newImageDestination(sys *types.SystemContext, ref archiveReference) (types.ImageDestination, error) {
+	if ref.sourceIndex != -1 {
+		return nil, fmt.Errorf("Destination reference must not contain a manifest index @%d", ref.sourceIndex)
+	}
+	// Refuse to overwrite an existing file; it may contain other images, and we can only write a whole new archive.
+	fh, err := os.OpenFile(ref.path, os.O_WRONLY|os.O_EXCL|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating docker-archive file %s: %v", ref.path, err)
+	}
+	repoTags := []string{}
+	if ref.ref != nil {
+		repoTags = append(repoTags, ref.ref.String())
+	}
+	legacyLayout := sys != nil && sys.DockerArchiveLegacyLayout
+	return &archiveImageDestination{
+		Destination: tarfile.NewDestination(sys, fh, repoTags, legacyLayout),
+		ref:         ref,
+		writer:      fh,
+	}, nil
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (d *archiveImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+// An archive which was not committed is incomplete, so it is removed.
+func (d *archiveImageDestination) Close() {
+	d.writer.Close()
+	if !d.committed {
+		_ = os.Remove(d.ref.path)
+	}
+}
+
+// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+// WARNING: This does not have any transactional semantics:
+// - Uploaded data MAY be visible to others before Commit() is called
+// - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
+func (d *archiveImageDestination) Commit(ctx context.Context) error {
+	if err := d.Destination.Commit(ctx); err != nil {
+		return err
+	}
+	if err := d.writer.Sync(); err != nil {
+		return err
+	}
+	d.committed = true
+	return nil
+}
@@ -0,0 +1,49 @@
+package archive
+
+import (
+	"github.com/containers/image/docker/tarfile"
+	"github.com/containers/image/types"
+)
+
+type archiveImageSource struct {
+	*tarfile.Source // Implements most of types.ImageSource
+	ref             archiveReference
+}
+
+// newImageSource returns a types.ImageSource for the specified image reference.
+// The caller must call .Close() on the returned ImageSource.
+func newImageSource(ctx *types.SystemContext, ref archiveReference) (types.ImageSource, error) {
+	src, err := tarfile.NewSourceFromFile(ctx, ref.path, ref.ref, ref.sourceIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveImageSource{
+		Source: src,
+		ref:    ref,
+	}, nil
+}
+
+// Reference returns the reference used to set up this source, _as specified by the user_
+// (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
+func (s *archiveImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+// ListImages returns the images stored in the archive, in the order of the manifest.json file (i.e. usable as source indexes),
+// along with the tags recorded for each of them.
+func ListImages(ctx *types.SystemContext, path string) ([][]string, error) {
+	src, err := tarfile.NewSourceFromFile(ctx, path, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	items, err := src.LoadTarManifest()
+	if err != nil {
+		return nil, err
+	}
+	res := [][]string{}
+	for _, item := range items {
+		res = append(res, item.RepoTags)
+	}
+	return res, nil
+}
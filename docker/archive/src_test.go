@@ -0,0 +1,37 @@
+package archive
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListImages(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "archive.tar")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	manifest := []byte(`[{"Config":"1.json","RepoTags":["busybox:latest","busybox:1"],"Layers":[]},{"Config":"2.json","RepoTags":null,"Layers":[]}]`)
+	tw := tar.NewWriter(f)
+	err = tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest)), Typeflag: tar.TypeReg})
+	require.NoError(t, err)
+	_, err = tw.Write(manifest)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, f.Close())
+
+	images, err := ListImages(nil, path)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"busybox:latest", "busybox:1"}, nil}, images)
+
+	_, err = ListImages(nil, filepath.Join(tmpDir, "this-does-not-exist.tar"))
+	assert.Error(t, err)
+}
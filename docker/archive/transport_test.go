@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "docker-archive", Transport.Name())
+}
+
+func TestTransportParseReference(t *testing.T) {
+	testParseReference(t, Transport.ParseReference)
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	for _, scope := range []string{ // A semi-representative assortment of values; everything is rejected.
+		"docker.io/library/busybox:notlatest",
+		"docker.io/library/busybox",
+		"docker.io/library",
+		"docker.io",
+		"/var/lib/archive.tar",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.Error(t, err, scope)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	testParseReference(t, ParseReference)
+}
+
+// testParseReference is a test shared for Transport.ParseReference and ParseReference.
+func testParseReference(t *testing.T, fn func(string) (types.ImageReference, error)) {
+	for _, c := range []struct {
+		input, expectedPath, expectedRef string
+		expectedSourceIndex              int
+	}{
+		{"", "", "", -1}, // Empty input is explicitly rejected
+		{"/path", "/path", "", -1},
+		{"/path:busybox:notlatest", "/path", "busybox:notlatest", -1},                                              // Explicit tag
+		{"/path:busybox" + "@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "", "", -1}, // Digest references are forbidden
+		{"/path:busybox", "/path", "busybox:latest", -1},                                                           // Default tag
+		{"/path:example.com/ns/foo:bar", "/path", "example.com/ns/foo:bar", -1},
+		{"/path:UPPERCASEISINVALID", "", "", -1}, // Invalid reference format
+		{"/path:@0", "/path", "", 0},
+		{"/path:@42", "/path", "", 42},
+		{"/path:@-1", "", "", -1}, // Negative index
+		{"/path:@", "", "", -1},   // Missing index
+		{"/path:@abc", "", "", -1},
+	} {
+		ref, err := fn(c.input)
+		if c.expectedPath == "" {
+			assert.Error(t, err, c.input)
+		} else {
+			require.NoError(t, err, c.input)
+			archiveRef, ok := ref.(archiveReference)
+			require.True(t, ok, c.input)
+			assert.Equal(t, c.expectedPath, archiveRef.path, c.input)
+			if c.expectedRef == "" {
+				assert.Nil(t, archiveRef.ref, c.input)
+			} else {
+				require.NotNil(t, archiveRef.ref, c.input)
+				assert.Equal(t, c.expectedRef, archiveRef.ref.String(), c.input)
+			}
+			assert.Equal(t, c.expectedSourceIndex, archiveRef.sourceIndex, c.input)
+		}
+	}
+}
+
+func TestNewReference(t *testing.T) {
+	named, err := reference.ParseNamed("busybox:latest")
+	require.NoError(t, err)
+	tagged := named.(reference.NamedTagged)
+
+	_, err = NewReference("/path", tagged, -1)
+	assert.NoError(t, err)
+	_, err = NewReference("/path", nil, 3)
+	assert.NoError(t, err)
+	_, err = NewReference("/path", tagged, 3) // Both a reference and an index
+	assert.Error(t, err)
+	_, err = NewReference("/path", nil, -2)
+	assert.Error(t, err)
+	_, err = NewReference("/path:with:colons", nil, -1)
+	assert.Error(t, err)
+}
+
+func TestReferenceStringWithinTransport(t *testing.T) {
+	for _, c := range []struct{ input, result string }{
+		{"/path", "/path"},
+		{"/path:busybox", "/path:busybox:latest"},
+		{"/path:example.com/ns/foo:bar", "/path:example.com/ns/foo:bar"},
+		{"/path:@2", "/path:@2"},
+	} {
+		ref, err := ParseReference(c.input)
+		require.NoError(t, err, c.input)
+		stringRef := ref.StringWithinTransport()
+		assert.Equal(t, c.result, stringRef, c.input)
+		// Do one more round to verify that the output can be parsed, to an equal value.
+		ref2, err := Transport.ParseReference(stringRef)
+		require.NoError(t, err, c.input)
+		stringRef2 := ref2.StringWithinTransport()
+		assert.Equal(t, stringRef, stringRef2, c.input)
+	}
+}
+
+func TestReferenceDockerReference(t *testing.T) {
+	ref, err := ParseReference("/path:busybox")
+	require.NoError(t, err)
+	dockerRef := ref.DockerReference()
+	require.NotNil(t, dockerRef)
+	assert.Equal(t, "busybox:latest", dockerRef.String())
+
+	for _, input := range []string{"/path", "/path:@1"} {
+		ref, err := ParseReference(input)
+		require.NoError(t, err, input)
+		assert.Nil(t, ref.DockerReference(), input)
+	}
+}
+
+func TestReferencePolicyConfiguration(t *testing.T) {
+	ref, err := ParseReference("/path:busybox")
+	require.NoError(t, err)
+	assert.Equal(t, "", ref.PolicyConfigurationIdentity())
+	assert.Empty(t, ref.PolicyConfigurationNamespaces())
+}
+
+func TestReferenceNewImageDestination(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "archive.tar")
+
+	ref, err := ParseReference(path + ":busybox")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	// An existing file is not overwritten.
+	_, err = ref.NewImageDestination(context.Background(), nil)
+	assert.Error(t, err)
+	// An archive which is not committed is removed.
+	dest.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	ref, err = ParseReference(path + ":@0")
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestReferenceDeleteImage(t *testing.T) {
+	ref, err := ParseReference("/path:busybox")
+	require.NoError(t, err)
+	err = ref.DeleteImage(context.Background(), nil)
+	assert.Error(t, err)
+}
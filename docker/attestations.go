@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// attestationArtifactType is the artifact type of manifests containing cosign attestations, as used by the referrers API.
+	attestationArtifactType = "application/vnd.dev.cosign.artifact.att.v1+json"
+	// attestationLayerMediaType is the media type of layers containing a DSSE envelope.
+	attestationLayerMediaType = "application/vnd.dsse.envelope.v1+json"
+	// attestationTagSuffix is appended to the manifest digest (with ':' replaced by '-') to form the tag of the attestation manifest.
+	attestationTagSuffix = ".att"
+)
+
+// attestationTag returns the tag used by cosign to store attestations of the manifest with manifestDigest.
+func attestationTag(manifestDigest digest.Digest) string {
+	return strings.Replace(manifestDigest.String(), ":", "-", 1) + attestationTagSuffix
+}
+
+// Compile-time check that dockerImageSource implements types.AttestationsSource
+var _ types.AttestationsSource = (*dockerImageSource)(nil)
+
+// GetAttestations returns the image's attestations, stored in the registry as OCI artifacts
+// referring to the image's manifest (see GetReferrers) or tagged using the cosign tag naming scheme.
+func (s *dockerImageSource) GetAttestations(ctx context.Context) ([]types.Attestation, error) {
+	manifestDigest, err := s.manifestDigest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := s.fetchSigstoreReferrers(ctx, manifestDigest, attestationArtifactType)
+	if err != nil {
+		return nil, err
+	}
+	tagged, err := s.fetchSigstoreManifest(ctx, attestationTag(manifestDigest))
+	if err != nil {
+		return nil, err
+	}
+	if tagged != nil {
+		manifests = append(manifests, tagged)
+	}
+
+	atts := []types.Attestation{}
+	for _, m := range manifests {
+		var parsed sigstoreSignatureManifest
+		if err := json.Unmarshal(m, &parsed); err != nil {
+			return nil, fmt.Errorf("Error parsing attestation manifest: %v", err)
+		}
+		for _, layer := range parsed.Layers {
+			if layer.MediaType != attestationLayerMediaType {
+				continue
+			}
+			envelope, err := s.getSigstorePayload(ctx, layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			atts = append(atts, types.Attestation{Envelope: envelope})
+		}
+	}
+	return atts, nil
+}
@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttestationTag(t *testing.T) {
+	assert.Equal(t, "sha256-0123456789abcdef.att", attestationTag("sha256:0123456789abcdef"))
+}
+
+// attestationTestManifest returns an attestation manifest with a layer for each of envelopes, and an unrelated layer.
+func attestationTestManifest(t *testing.T, envelopes []string) []byte {
+	m := sigstoreSignatureManifest{Layers: []sigstoreDescriptor{
+		{MediaType: "application/x-unrelated", Digest: digest.FromString("unrelated")},
+	}}
+	for _, envelope := range envelopes {
+		m.Layers = append(m.Layers, sigstoreDescriptor{
+			MediaType: attestationLayerMediaType,
+			Digest:    digest.FromString(envelope),
+			Size:      int64(len(envelope)),
+		})
+	}
+	res, err := json.Marshal(m)
+	require.NoError(t, err)
+	return res
+}
+
+func TestDockerImageSourceGetAttestations(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+
+	taggedManifest := attestationTestManifest(t, []string{"envelope1"})
+	referrerManifest := attestationTestManifest(t, []string{"envelope2", "envelope3"})
+	referrerDigest, err := manifest.Digest(referrerManifest)
+	require.NoError(t, err)
+	referrers, err := json.Marshal(referrersIndex{SchemaVersion: 2, Manifests: []referrersDescriptor{
+		{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: referrerDigest, ArtifactType: attestationArtifactType},
+		{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: "sha256:unrelated", ArtifactType: sigstoreSignatureArtifactType},
+	}})
+	require.NoError(t, err)
+	blobs := map[string]string{}
+	for _, envelope := range []string{"envelope1", "envelope2", "envelope3"} {
+		blobs["/v2/library/busybox/blobs/"+digest.FromString(envelope).String()] = envelope
+	}
+
+	supportsReferrers, hasTag := false, false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/busybox/manifests/latest":
+			w.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			w.Write(manifestBody)
+		case "/v2/library/busybox/manifests/" + attestationTag(manifestDigest):
+			if !hasTag {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(taggedManifest)
+		case "/v2/library/busybox/referrers/" + manifestDigest.String():
+			if !supportsReferrers {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(referrers)
+		case "/v2/library/busybox/manifests/" + referrerDigest.String():
+			w.Write(referrerManifest)
+		default:
+			blob, ok := blobs[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(blob))
+		}
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		referrers, tag bool
+		expected       []string
+	}{
+		{false, false, []string{}},
+		{false, true, []string{"envelope1"}},
+		{true, false, []string{"envelope2", "envelope3"}},
+		{true, true, []string{"envelope2", "envelope3", "envelope1"}},
+	} {
+		supportsReferrers, hasTag = c.referrers, c.tag
+		src := &dockerImageSource{
+			ref:                        dockerRefFromString(t, "//busybox:latest"),
+			requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+			c:                          newTestDockerClient(t, server),
+			blobEndpoints:              map[digest.Digest]string{},
+		}
+		atts, err := src.GetAttestations(context.Background())
+		require.NoError(t, err)
+		expected := []types.Attestation{}
+		for _, e := range c.expected {
+			expected = append(expected, types.Attestation{Envelope: []byte(e)})
+		}
+		assert.Equal(t, expected, atts)
+	}
+
+	// An envelope not matching its digest is rejected.
+	hasTag = true
+	blobs["/v2/library/busybox/blobs/"+digest.FromString("").String()] = "unexpected"
+	taggedManifest = attestationTestManifest(t, []string{""})
+	src := &dockerImageSource{
+		ref:                        dockerRefFromString(t, "//busybox:latest"),
+		requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+		c:                          newTestDockerClient(t, server),
+		blobEndpoints:              map[digest.Digest]string{},
+	}
+	_, err = src.GetAttestations(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// maxBlobResumeAttempts is the maximum number of times a single blob download is resumed after reading it fails.
+const maxBlobResumeAttempts = 5
+
+// resumableBlobReader is an io.ReadCloser for a blob downloaded from a registry.  If reading the blob fails mid-stream
+// (e.g. because the connection was reset), the download is resumed from the last received offset using an HTTP Range request,
+// so that the consumer (typically verifying the blob's digest) sees a single uninterrupted stream.
+type resumableBlobReader struct {
+	ctx     context.Context
+	c       *dockerClient
+	url     string // The blob URL, relative to the registry
+	digest  digest.Digest
+	body    io.ReadCloser // The body of the current response
+	offset  int64         // The number of bytes returned so far
+	readErr error         // An error reading body, to be handled by the next Read call, or nil
+	// attempts is the number of times the download has been resumed.
+	attempts int
+}
+
+// Read implements io.Reader.
+func (r *resumableBlobReader) Read(p []byte) (int, error) {
+	for {
+		if r.readErr != nil {
+			if err := r.resume(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err != nil && err != io.EOF {
+			r.readErr = err
+			if n > 0 {
+				return n, nil // Return the data we have, and resume on the next call.
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// resume replaces r.body with a response starting at r.offset, or returns an error if the download can not be resumed.
+func (r *resumableBlobReader) resume() error {
+	readErr := r.readErr
+	for {
+		if r.attempts >= maxBlobResumeAttempts || r.ctx.Err() != nil {
+			return readErr
+		}
+		r.attempts++
+		r.c.metrics().RegistryRetry(r.c.registry)
+		r.c.logger().Debugf("Error reading blob %s from %s after %d bytes, resuming (attempt %d): %v", r.digest, r.c.registry, r.offset, r.attempts, readErr)
+		r.body.Close()
+		r.body = http.NoBody
+		headers := map[string][]string{"Range": {fmt.Sprintf("bytes=%d-", r.offset)}}
+		res, err := r.c.makeRequest(r.ctx, "GET", r.url, headers, nil)
+		if err != nil {
+			readErr = err
+			continue
+		}
+		r.body = res.Body
+		if res.StatusCode != http.StatusPartialContent {
+			// The registry ignores range requests, or the blob is no longer available; we can't continue.
+			r.c.logger().Debugf("Can not resume reading blob %s from %s: status %d", r.digest, r.c.registry, res.StatusCode)
+			return readErr
+		}
+		var start int64
+		if _, err := fmt.Sscanf(res.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != r.offset {
+			r.c.logger().Debugf("Can not resume reading blob %s from %s: unexpected Content-Range %q", r.digest, r.c.registry, res.Header.Get("Content-Range"))
+			return readErr
+		}
+		r.readErr = nil
+		return nil
+	}
+}
+
+// Close implements io.Closer.
+func (r *resumableBlobReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBlobResume(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 10000)
+	blobDigest := digest.FromBytes(blob)
+	blobPath := "/v2/library/busybox/blobs/" + blobDigest.String()
+
+	for _, c := range []struct {
+		name            string
+		failures        int  // Number of responses which are cut off
+		supportsRange   bool // Whether the server honors range requests
+		expectedSuccess bool
+	}{
+		{"no failures", 0, true, true},
+		{"one failure", 1, true, true},
+		{"several failures", maxBlobResumeAttempts, true, true},
+		{"too many failures", maxBlobResumeAttempts + 1, true, false},
+		{"range not supported", 1, false, false},
+	} {
+		ranges := []string{}
+		failures := c.failures
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != blobPath {
+				http.NotFound(w, r)
+				return
+			}
+			start := 0
+			if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+				ranges = append(ranges, rangeHeader)
+				if c.supportsRange {
+					_, err := fmt.Sscanf(rangeHeader, "bytes=%d-", &start)
+					require.NoError(t, err)
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(blob)-1, len(blob)))
+					w.Header().Set("Content-Length", strconv.Itoa(len(blob)-start))
+					w.WriteHeader(http.StatusPartialContent)
+				}
+			}
+			if start == 0 {
+				w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+			}
+			if failures == 0 {
+				w.Write(blob[start:])
+				return
+			}
+			failures--
+			w.Write(blob[start : start+1000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler) // Cut off the connection
+		}))
+		src := &dockerImageSource{
+			ref:           dockerRefFromString(t, "//busybox:latest"),
+			c:             newTestDockerClient(t, server),
+			blobEndpoints: map[digest.Digest]string{},
+		}
+
+		stream, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, int64(len(blob)), size, c.name)
+		contents, err := ioutil.ReadAll(stream)
+		stream.Close()
+		if c.expectedSuccess {
+			require.NoError(t, err, c.name)
+			assert.Equal(t, blob, contents, c.name)
+			expectedRanges := []string{}
+			for i := 1; i <= c.failures; i++ {
+				expectedRanges = append(expectedRanges, fmt.Sprintf("bytes=%d-", i*1000))
+			}
+			assert.Equal(t, expectedRanges, ranges, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,148 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Client is a low-level client for a single repository in a Docker registry, offering raw registry API operations
+// for tools which need registry access beyond copying whole images.
+// It uses the same configuration, credentials, authentication and TLS settings as the docker: transport,
+// but it does not use any mirrors.
+// A Client may be used concurrently.
+type Client struct {
+	ref dockerReference
+	c   *dockerClient
+}
+
+// NewClient returns a Client for the repository of ref, which must be a docker: reference; any tag or digest in ref is ignored.
+// If write, the client requests authorization to push to the repository, in addition to pulling from it.
+func NewClient(sys *types.SystemContext, ref types.ImageReference, write bool) (*Client, error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return nil, errors.New("ref must be a dockerReference")
+	}
+	c, err := newDockerClient(sys, dr, write)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating a Docker client: %v", err)
+	}
+	return &Client{ref: dr, c: c}, nil
+}
+
+// source returns a dockerImageSource accessing the client's repository, requesting manifests with mimeTypes.
+func (c *Client) source(mimeTypes []string) *dockerImageSource {
+	if mimeTypes == nil {
+		mimeTypes = manifest.DefaultRequestedManifestMIMETypes
+	}
+	return &dockerImageSource{ref: c.ref, requestedManifestMIMETypes: mimeTypes, c: c.c, blobEndpoints: map[digest.Digest]string{}}
+}
+
+// destination returns a dockerImageDestination accessing the client's repository.
+func (c *Client) destination() *dockerImageDestination {
+	return &dockerImageDestination{ref: c.ref, c: c.c}
+}
+
+// Ping contacts the registry, if it has not been contacted yet, to check that it is available,
+// and to detect its scheme and authentication requirements.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.c.detectProperties(ctx)
+}
+
+// ListTags lists all tags available in the repository.
+func (c *Client) ListTags(ctx context.Context) ([]string, error) {
+	return c.c.getRepositoryTags(ctx, c.ref)
+}
+
+// GetManifest returns the manifest referenced by tagOrDigest, and its MIME type, if known.
+// mimeTypes lists the manifest MIME types the caller accepts; nil means manifest.DefaultRequestedManifestMIMETypes.
+// If tagOrDigest is a digest, the manifest is verified to match it.
+func (c *Client) GetManifest(ctx context.Context, tagOrDigest string, mimeTypes []string) ([]byte, string, error) {
+	if d, err := digest.Parse(tagOrDigest); err == nil {
+		return c.source(mimeTypes).fetchManifestByDigest(ctx, d)
+	}
+	return c.source(mimeTypes).fetchManifest(ctx, tagOrDigest)
+}
+
+// PutManifest uploads m, with mimeType ("" to guess it from m), to tagOrDigest, and returns the digest of m.
+func (c *Client) PutManifest(ctx context.Context, tagOrDigest string, m []byte, mimeType string) (digest.Digest, error) {
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return "", err
+	}
+	if d, err := digest.Parse(tagOrDigest); err == nil {
+		matches, err := manifest.MatchesDigest(m, d)
+		if err != nil {
+			return "", err
+		}
+		if !matches {
+			return "", fmt.Errorf("Manifest digest %s does not match the digest %s", manifestDigest, d)
+		}
+		manifestDigest = d
+	}
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(m)
+	}
+	if _, err := c.destination().uploadManifest(ctx, tagOrDigest, m, mimeType); err != nil {
+		return "", err
+	}
+	return manifestDigest, nil
+}
+
+// HasBlob returns true iff the repository contains a blob with blobDigest, and if so, also its size.
+// If the repository does not contain the blob, HasBlob returns (false, -1, nil);
+// it returns a non-nil error only on an unexpected failure.
+func (c *Client) HasBlob(ctx context.Context, blobDigest digest.Digest) (bool, int64, error) {
+	return c.destination().blobExists(ctx, blobDigest)
+}
+
+// GetBlob returns a stream for the blob with blobDigest, and the blob’s size (or -1 if unknown).
+// The caller must close the returned stream.  The contents of the stream are not verified against blobDigest.
+func (c *Client) GetBlob(ctx context.Context, blobDigest digest.Digest) (io.ReadCloser, int64, error) {
+	return c.source(nil).getBlobFromEndpoint(ctx, c.c, blobDigest)
+}
+
+// MountBlob asks the registry to make the blob with blobDigest in fromRepository (a repository name within the same registry,
+// e.g. "library/busybox") available in the client's repository without uploading it.
+// It returns true if the blob was mounted, and false if the registry does not support mounting, or can not mount this blob
+// (e.g. because it does not exist in fromRepository, or the client is not authorized to read it).
+func (c *Client) MountBlob(ctx context.Context, blobDigest digest.Digest, fromRepository string) (bool, error) {
+	params := url.Values{}
+	params.Set("mount", blobDigest.String())
+	params.Set("from", fromRepository)
+	mountURL := fmt.Sprintf(blobUploadURL, c.ref.ref.RemoteName()) + "?" + params.Encode()
+	c.c.logger().Debugf("Mounting %s from %s", blobDigest, fromRepository)
+	// The registry only mounts the blob if the token authorizes pulling from fromRepository, in addition to pushing to our repository.
+	c.c.addScope(fmt.Sprintf("repository:%s:pull", fromRepository))
+	res, err := c.c.makeRequest(ctx, "POST", mountURL, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		// The registry has started an ordinary upload instead; cancel it.
+		uploadLocation, err := res.Location()
+		if err != nil {
+			return false, fmt.Errorf("Error determining upload URL: %v", err)
+		}
+		cancel, err := c.c.makeRequestToResolvedURL(ctx, "DELETE", uploadLocation.String(), nil, nil, -1)
+		if err != nil {
+			c.c.logger().Debugf("Error canceling upload %s: %v", uploadLocation, err)
+		} else {
+			cancel.Body.Close()
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("Error mounting blob %s from %s: %w", blobDigest, fromRepository, registryHTTPResponseToError(res, nil))
+	}
+}
@@ -0,0 +1,194 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(nil, nonDockerReference{}, false)
+	assert.Error(t, err)
+}
+
+// nonDockerReference is a types.ImageReference which is not a dockerReference.
+type nonDockerReference struct {
+	types.ImageReference
+}
+
+func TestClient(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+	mountableDigest := digest.FromString("mountable")
+
+	manifests := map[string][]byte{"latest": manifestBody}
+	canceledUploads := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const manifestsPrefix = "/v2/library/busybox/manifests/"
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/library/busybox/tags/list":
+			w.Write([]byte(`{"name":"library/busybox","tags":["latest"]}`))
+		case strings.HasPrefix(r.URL.Path, manifestsPrefix) && r.Method == "GET":
+			m, ok := manifests[strings.TrimPrefix(r.URL.Path, manifestsPrefix)]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", manifest.GuessMIMEType(m))
+			w.Write(m)
+		case strings.HasPrefix(r.URL.Path, manifestsPrefix) && r.Method == "PUT":
+			m, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				panic(err)
+			}
+			manifests[strings.TrimPrefix(r.URL.Path, manifestsPrefix)] = m
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Path == "/v2/library/busybox/blobs/"+blobDigest.String():
+			w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+			if r.Method == "GET" {
+				w.Write(blob)
+			}
+		case r.URL.Path == "/v2/library/busybox/blobs/uploads/" && r.Method == "POST":
+			if r.URL.Query().Get("mount") == mountableDigest.String() && r.URL.Query().Get("from") == "library/other" {
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			w.Header().Set("Location", "/v2/library/busybox/blobs/uploads/upload-1")
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/v2/library/busybox/blobs/uploads/upload-1" && r.Method == "DELETE":
+			canceledUploads = append(canceledUploads, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := &Client{ref: dockerRefFromString(t, "//busybox:latest"), c: newTestDockerClient(t, server)}
+	ctx := context.Background()
+
+	err = client.Ping(ctx)
+	require.NoError(t, err)
+
+	tags, err := client.ListTags(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"latest"}, tags)
+
+	// Manifests
+	m, mimeType, err := client.GetManifest(ctx, "latest", nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBody, m)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, mimeType)
+	_, _, err = client.GetManifest(ctx, "missing", nil)
+	assert.Error(t, err)
+
+	d, err := client.PutManifest(ctx, manifestDigest.String(), manifestBody, "")
+	require.NoError(t, err)
+	assert.Equal(t, manifestDigest, d)
+	m, _, err = client.GetManifest(ctx, manifestDigest.String(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBody, m)
+	_, err = client.PutManifest(ctx, digest.FromString("other").String(), manifestBody, "")
+	assert.Error(t, err)
+	manifests[blobDigest.String()] = manifestBody // A manifest not matching the requested digest is rejected.
+	_, _, err = client.GetManifest(ctx, blobDigest.String(), nil)
+	assert.Error(t, err)
+
+	// Blobs
+	exists, size, err := client.HasBlob(ctx, blobDigest)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(len(blob)), size)
+	exists, _, err = client.HasBlob(ctx, mountableDigest)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	stream, size, err := client.GetBlob(ctx, blobDigest)
+	require.NoError(t, err)
+	contents, err := ioutil.ReadAll(stream)
+	stream.Close()
+	require.NoError(t, err)
+	assert.Equal(t, blob, contents)
+	assert.Equal(t, int64(len(blob)), size)
+	_, _, err = client.GetBlob(ctx, mountableDigest)
+	assert.Error(t, err)
+
+	mounted, err := client.MountBlob(ctx, mountableDigest, "library/other")
+	require.NoError(t, err)
+	assert.True(t, mounted)
+	assert.Empty(t, canceledUploads)
+	mounted, err = client.MountBlob(ctx, mountableDigest, "library/unrelated")
+	require.NoError(t, err)
+	assert.False(t, mounted)
+	assert.Equal(t, []string{"/v2/library/busybox/blobs/uploads/upload-1"}, canceledUploads)
+}
+
+func TestClientMountBlobScope(t *testing.T) {
+	mountableDigest := digest.FromString("mountable")
+	tokenRequests := []string{}
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := r.URL.Query().Get("scope")
+		tokenRequests = append(tokenRequests, scope)
+		fmt.Fprintf(w, `{"token":%q,"expires_in":300}`, scope)
+	}))
+	defer tokenServer.Close()
+	challenge := fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, tokenServer.URL)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/library/busybox/blobs/uploads/" && r.Method == "POST":
+			// Mounting requires a token for both repositories; the registry does not include the source in its challenge.
+			if r.Header.Get("Authorization") != "Bearer repository:library/busybox:pull,push repository:library/other:pull" {
+				w.Header().Set("WWW-Authenticate", challenge+`,scope="repository:library/busybox:pull,push"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			if r.Header.Get("Authorization") != "Bearer repository:library/busybox:pull,push" &&
+				r.Header.Get("Authorization") != "Bearer repository:library/busybox:pull,push repository:library/other:pull" {
+				w.Header().Set("WWW-Authenticate", challenge+`,scope="repository:library/busybox:pull,push"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	c := newTestDockerClient(t, server)
+	c.wwwAuthenticate = challenge
+	c.scope = "repository:library/busybox:pull,push"
+	client := &Client{ref: dockerRefFromString(t, "//busybox:latest"), c: c}
+	ctx := context.Background()
+
+	exists, _, err := client.HasBlob(ctx, mountableDigest)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, []string{"repository:library/busybox:pull,push"}, tokenRequests)
+
+	// A new token, covering both repositories, is obtained for the mount, and used for later requests.
+	mounted, err := client.MountBlob(ctx, mountableDigest, "library/other")
+	require.NoError(t, err)
+	assert.True(t, mounted)
+	mounted, err = client.MountBlob(ctx, mountableDigest, "library/other")
+	require.NoError(t, err)
+	assert.True(t, mounted)
+	_, _, err = client.HasBlob(ctx, mountableDigest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"repository:library/busybox:pull,push", "repository:library/busybox:pull,push repository:library/other:pull"}, tokenRequests)
+}
@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/types"
+	"github.com/docker/engine-api/client"
+)
+
+// defaultAPIVersion is the Docker engine API version used unless overridden by types.SystemContext.DockerDaemonAPIVersion
+// or $DOCKER_API_VERSION.
+const defaultAPIVersion = "1.22"
+
+// newDockerClient returns a client for the Docker daemon configured in sys (which may be nil).
+func newDockerClient(sys *types.SystemContext) (*client.Client, error) {
+	host := daemonHost(sys)
+	var httpClient *http.Client
+	// Local sockets are configured by the client itself, and must not use TLS.
+	if !strings.HasPrefix(host, "unix://") && !strings.HasPrefix(host, "npipe://") {
+		tlsc, err := daemonTLSConfig(sys)
+		if err != nil {
+			return nil, err
+		}
+		if tlsc != nil {
+			httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsc}}
+		}
+	}
+	c, err := client.NewClient(host, daemonAPIVersion(sys), httpClient, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error initializing docker engine client: %v", err)
+	}
+	return c, nil
+}
+
+// daemonHost returns the address of the Docker daemon configured in sys (which may be nil).
+func daemonHost(sys *types.SystemContext) string {
+	if sys != nil && sys.DockerDaemonHost != "" {
+		return sys.DockerDaemonHost
+	}
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return host
+	}
+	return client.DefaultDockerHost
+}
+
+// daemonAPIVersion returns the Docker engine API version configured in sys (which may be nil).
+func daemonAPIVersion(sys *types.SystemContext) string {
+	if sys != nil && sys.DockerDaemonAPIVersion != "" {
+		return sys.DockerDaemonAPIVersion
+	}
+	if version := os.Getenv("DOCKER_API_VERSION"); version != "" {
+		return version
+	}
+	return defaultAPIVersion
+}
+
+// daemonTLSConfig returns the TLS configuration for talking to the Docker daemon over TCP, as configured in sys (which may be nil),
+// or nil if TLS should not be used.
+// As with the docker CLI, a certificate directory set in $DOCKER_CERT_PATH is only used to verify the daemon if $DOCKER_TLS_VERIFY is set.
+func daemonTLSConfig(sys *types.SystemContext) (*tls.Config, error) {
+	certPath, insecure := "", false
+	switch {
+	case sys != nil && sys.DockerDaemonCertPath != "":
+		certPath, insecure = sys.DockerDaemonCertPath, sys.DockerDaemonInsecureSkipTLSVerify
+	case os.Getenv("DOCKER_CERT_PATH") != "":
+		certPath, insecure = os.Getenv("DOCKER_CERT_PATH"), os.Getenv("DOCKER_TLS_VERIFY") == ""
+	default:
+		return nil, nil
+	}
+
+	tlsc := &tls.Config{InsecureSkipVerify: insecure}
+	caPath := filepath.Join(certPath, "ca.pem")
+	ca, err := ioutil.ReadFile(caPath)
+	switch {
+	case err == nil:
+		tlsc.RootCAs = x509.NewCertPool()
+		if !tlsc.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("Error loading CA certificates from %s: no certificates found", caPath)
+		}
+	case os.IsNotExist(err):
+		// Use the system's CA certificates.
+	default:
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("Error loading x509 key pair: %v", err)
+	}
+	tlsc.Certificates = []tls.Certificate{cert}
+	return tlsc, nil
+}
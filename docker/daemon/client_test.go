@@ -0,0 +1,138 @@
+package daemon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/types"
+	"github.com/docker/engine-api/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setenvForTest sets the environment variable name to value (unsetting it if value is ""), and returns a function restoring the original value.
+func setenvForTest(name, value string) func() {
+	orig, wasSet := os.LookupEnv(name)
+	if value == "" {
+		os.Unsetenv(name)
+	} else {
+		os.Setenv(name, value)
+	}
+	return func() {
+		if wasSet {
+			os.Setenv(name, orig)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestDaemonHost(t *testing.T) {
+	defer setenvForTest("DOCKER_HOST", "")()
+	assert.Equal(t, client.DefaultDockerHost, daemonHost(nil))
+	assert.Equal(t, client.DefaultDockerHost, daemonHost(&types.SystemContext{}))
+
+	defer setenvForTest("DOCKER_HOST", "tcp://env.example.com:2376")()
+	assert.Equal(t, "tcp://env.example.com:2376", daemonHost(nil))
+	assert.Equal(t, "tcp://env.example.com:2376", daemonHost(&types.SystemContext{}))
+	assert.Equal(t, "unix:///run/other.sock", daemonHost(&types.SystemContext{DockerDaemonHost: "unix:///run/other.sock"}))
+}
+
+func TestDaemonAPIVersion(t *testing.T) {
+	defer setenvForTest("DOCKER_API_VERSION", "")()
+	assert.Equal(t, defaultAPIVersion, daemonAPIVersion(nil))
+	assert.Equal(t, defaultAPIVersion, daemonAPIVersion(&types.SystemContext{}))
+
+	defer setenvForTest("DOCKER_API_VERSION", "1.30")()
+	assert.Equal(t, "1.30", daemonAPIVersion(nil))
+	assert.Equal(t, "1.24", daemonAPIVersion(&types.SystemContext{DockerDaemonAPIVersion: "1.24"}))
+}
+
+// writeTestCertificates writes a self-signed ca.pem, and cert.pem and key.pem using the same key pair, to dir.
+func writeTestCertificates(t *testing.T, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "docker-daemon-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for name, contents := range map[string][]byte{"ca.pem": certPEM, "cert.pem": certPEM, "key.pem": keyPEM} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), contents, 0600)
+		require.NoError(t, err)
+	}
+}
+
+func TestDaemonTLSConfig(t *testing.T) {
+	defer setenvForTest("DOCKER_CERT_PATH", "")()
+	defer setenvForTest("DOCKER_TLS_VERIFY", "")()
+
+	// No TLS unless configured
+	tlsc, err := daemonTLSConfig(nil)
+	require.NoError(t, err)
+	assert.Nil(t, tlsc)
+	tlsc, err = daemonTLSConfig(&types.SystemContext{})
+	require.NoError(t, err)
+	assert.Nil(t, tlsc)
+
+	certDir, err := ioutil.TempDir("", "daemon-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(certDir)
+	writeTestCertificates(t, certDir)
+
+	// SystemContext
+	tlsc, err = daemonTLSConfig(&types.SystemContext{DockerDaemonCertPath: certDir})
+	require.NoError(t, err)
+	require.NotNil(t, tlsc)
+	assert.False(t, tlsc.InsecureSkipVerify)
+	assert.NotNil(t, tlsc.RootCAs)
+	assert.Len(t, tlsc.Certificates, 1)
+	tlsc, err = daemonTLSConfig(&types.SystemContext{DockerDaemonCertPath: certDir, DockerDaemonInsecureSkipTLSVerify: true})
+	require.NoError(t, err)
+	assert.True(t, tlsc.InsecureSkipVerify)
+
+	// Environment
+	defer setenvForTest("DOCKER_CERT_PATH", certDir)()
+	tlsc, err = daemonTLSConfig(nil)
+	require.NoError(t, err)
+	require.NotNil(t, tlsc)
+	assert.True(t, tlsc.InsecureSkipVerify)
+	defer setenvForTest("DOCKER_TLS_VERIFY", "1")()
+	tlsc, err = daemonTLSConfig(nil)
+	require.NoError(t, err)
+	assert.False(t, tlsc.InsecureSkipVerify)
+
+	// The system's CA certificates are used if ca.pem is missing.
+	err = os.Remove(filepath.Join(certDir, "ca.pem"))
+	require.NoError(t, err)
+	tlsc, err = daemonTLSConfig(nil)
+	require.NoError(t, err)
+	assert.Nil(t, tlsc.RootCAs)
+
+	// Errors
+	err = ioutil.WriteFile(filepath.Join(certDir, "ca.pem"), []byte("not a certificate"), 0600)
+	require.NoError(t, err)
+	_, err = daemonTLSConfig(nil)
+	assert.Error(t, err)
+	_, err = daemonTLSConfig(&types.SystemContext{DockerDaemonCertPath: "/this/does/not/exist"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageLoadResponseError(t *testing.T) {
+	for _, c := range []struct{ input, expected string }{ // expected is "" if no error is expected
+		{"", ""},
+		{`{"stream":"Loaded image: busybox:latest\n"}`, ""},
+		{`{"stream":"Loading layer"}` + "\n" + `{"errorDetail":{"message":"detailed failure"},"error":"failure"}`, "detailed failure"},
+		{`{"error":"failure"}`, "failure"},
+		{`{"stream":`, "Error parsing image load response"},
+	} {
+		err := imageLoadResponseError(strings.NewReader(c.input))
+		if c.expected == "" {
+			assert.NoError(t, err, c.input)
+		} else {
+			require.Error(t, err, c.input)
+			assert.Contains(t, err.Error(), c.expected, c.input)
+		}
+	}
+}
@@ -1,121 +1,385 @@
 package docker
 
 import (
-	"crypto/tls"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"net/url"
 	"path/filepath"
 	"strings"
-
-	"github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+
+	"github.com/containers/image/pkg/docker/config"
+	"github.com/containers/image/pkg/logging"
+	"github.com/containers/image/pkg/metrics"
+	"github.com/containers/image/pkg/sysregistries"
+	"github.com/containers/image/pkg/tracing"
 	"github.com/containers/image/types"
-	"github.com/docker/docker/pkg/homedir"
+	"github.com/containers/image/version"
+	"github.com/opencontainers/go-digest"
 )
 
 const (
-	dockerHostname     = "docker.io"
-	dockerRegistry     = "registry-1.docker.io"
-	dockerAuthRegistry = "https://index.docker.io/v1/"
-
-	dockerCfg         = ".docker"
-	dockerCfgFileName = "config.json"
-	dockerCfgObsolete = ".dockercfg"
+	dockerHostname = "docker.io"
+	dockerRegistry = "registry-1.docker.io"
 
 	baseURL       = "%s://%s/v2/"
 	tagsURL       = "%s/tags/list"
 	manifestURL   = "%s/manifests/%s"
 	blobsURL      = "%s/blobs/%s"
 	blobUploadURL = "%s/blobs/uploads/"
+	// extensionsSignatureURL is the URL of signatures of a manifest (repository, digest) in the X-Registry-Supports-Signatures API extension.
+	extensionsSignatureURL = "%s://%s/extensions/v2/%s/signatures/%s"
+
+	extensionSignatureSchemaVersion = 2        // extensionSignature.Version
+	extensionSignatureTypeAtomic    = "atomic" // extensionSignature.Type
+
+	// oauth2ClientID is the client ID used when exchanging identity tokens for bearer tokens.
+	oauth2ClientID = "containers/image"
+
+	// minimumTokenLifetimeSeconds is the lifetime assumed for bearer tokens which do not specify a (long enough) expires_in value,
+	// per the Docker token authentication specification.
+	minimumTokenLifetimeSeconds = 60
+	// bearerTokenRefreshMargin is how long before its expiration a cached bearer token is replaced by a new one,
+	// so that the token does not expire while a request using it is being processed.
+	bearerTokenRefreshMargin = 10 * time.Second
 )
 
+// systemPerHostCertDirPath is the path to the certs.d-style directory containing per-registry TLS configuration.
+// You can override this at build time with
+// -ldflags '-X github.com/containers/image/docker.systemPerHostCertDirPath=$your_path'
+var systemPerHostCertDirPath = builtinPerHostCertDirPath
+
+// builtinPerHostCertDirPath is the path to the per-registry TLS configuration directory.
+// DO NOT change this, instead see systemPerHostCertDirPath above.
+const builtinPerHostCertDirPath = "/etc/docker/certs.d"
+
+// defaultUserAgent is the User-Agent header sent to registries unless overridden by types.SystemContext.DockerRegistryUserAgent.
+var defaultUserAgent = "containers/image/" + version.Version + " (github.com/containers/image)"
+
+// userAgent returns the User-Agent header to send to registries, as configured by sys (which may be nil).
+func userAgent(sys *types.SystemContext) string {
+	if sys != nil && sys.DockerRegistryUserAgent != "" {
+		return sys.DockerRegistryUserAgent
+	}
+	return defaultUserAgent
+}
+
+// extensionSignature is a signature in the X-Registry-Supports-Signatures API extension.
+type extensionSignature struct {
+	Version int    `json:"schemaVersion"` // Version specifies the schema version
+	Name    string `json:"name"`          // Name must be in "sha256:<digest>@signatureName" format
+	Type    string `json:"type"`          // Type is optional, if not set it will be defaulted to "AtomicImageV1"
+	Content []byte `json:"content"`       // Content contains the signature
+}
+
+// extensionSignatureList is a list of signatures in the X-Registry-Supports-Signatures API extension.
+type extensionSignatureList struct {
+	Signatures []extensionSignature `json:"signatures"`
+}
+
+// getExtensionSignatures returns the signatures of the manifest with manifestDigest in ref's repository, using the X-Registry-Supports-Signatures API extension.
+func (c *dockerClient) getExtensionSignatures(ctx context.Context, ref dockerReference, manifestDigest digest.Digest) (*extensionSignatureList, error) {
+	if err := c.detectProperties(ctx); err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf(extensionsSignatureURL, c.scheme, c.registry, ref.ref.RemoteName(), manifestDigest)
+	res, err := c.makeRequestToResolvedURL(ctx, "GET", url, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error downloading signatures for %s in %s: %s", manifestDigest, ref.ref.FullName(), http.StatusText(res.StatusCode))
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var parsedBody extensionSignatureList
+	if err := json.Unmarshal(body, &parsedBody); err != nil {
+		return nil, fmt.Errorf("Error decoding signature list: %v", err)
+	}
+	return &parsedBody, nil
+}
+
+// errBearerTokenUnauthorized is returned by requestBearerToken if the token server rejects the request.
+var errBearerTokenUnauthorized = errors.New("unable to retrieve auth token: 401 unauthorized")
+
+// httpFallbackRegistries records the insecure registries which were found, during this process, to only be available over HTTP,
+// so that later clients do not need to probe HTTPS again.
+var httpFallbackRegistries = struct {
+	sync.Mutex
+	registries map[string]bool
+}{registries: map[string]bool{}}
+
 // dockerClient is configuration for dealing with a single Docker registry.
 type dockerClient struct {
-	ctx             *types.SystemContext
-	registry        string
-	username        string
-	password        string
-	wwwAuthenticate string // Cache of a value set by ping() if scheme is not empty
-	scheme          string // Cache of a value returned by a successful ping() if not empty
-	client          *http.Client
-	signatureBase   signatureStorageBase
+	sys                *types.SystemContext
+	registry           string
+	authHostname       string // The registry host name used for looking up credentials, which may differ from registry (e.g. docker.io)
+	repository         string // The repository (remote name) accessed by this client, e.g. library/busybox
+	username           string
+	password           string
+	identityToken      string // If not empty, an OAuth2 refresh token used to obtain bearer tokens instead of username and password
+	insecure           bool   // Allow contacting the registry over HTTP, or HTTPS with failed TLS verification
+	wwwAuthenticate    string // Cache of a value set by ping() if scheme is not empty
+	supportsSignatures bool   // Cache of a value set by ping() if scheme is not empty
+	scheme             string // Cache of a value returned by a successful ping() if not empty
+	client             *http.Client
+	signatureBase      signatureStorageBase
+	scope              string                 // Bearer token scope expected to be required for accessing the repository, e.g. "repository:library/busybox:pull"; may contain several space-separated scopes, see addScope
+	tokenCache         map[string]bearerToken // Bearer tokens, indexed by bearerTokenCacheKey()
+	// The client may be used concurrently, e.g. by parallel GetBlob/PutBlob calls.
+	propertiesMutex sync.Mutex // Serializes detectProperties, which sets wwwAuthenticate, supportsSignatures and scheme
+	authMutex       sync.Mutex // Protects the credentials and tokenCache
+}
+
+// logger returns the types.Logger receiving diagnostic messages about operations of c.
+func (c *dockerClient) logger() types.Logger {
+	return logging.ForSystemContext(c.sys)
+}
+
+// tracer returns the types.Tracer recording spans for operations of c.
+func (c *dockerClient) tracer() types.Tracer {
+	return tracing.ForSystemContext(c.sys)
+}
+
+// metrics returns the types.Metrics receiving statistics about requests of c.
+func (c *dockerClient) metrics() types.Metrics {
+	return metrics.ForSystemContext(c.sys)
+}
+
+// bearerToken is a token obtained from a token server, as defined by the Docker token authentication specification.
+type bearerToken struct {
+	Token          string    `json:"token"`
+	AccessToken    string    `json:"access_token"` // An OAuth2-compatible alias for Token
+	ExpiresIn      int       `json:"expires_in"`
+	IssuedAt       time.Time `json:"issued_at"`
+	RefreshToken   string    `json:"refresh_token"` // A new identity token, only returned by the OAuth2 refresh_token grant
+	expirationTime time.Time
+}
+
+// dockerCertDir returns the certs.d-style directory containing CA certificates and client certificate/key pairs
+// used when contacting the registry at hostPort (host[:port]).
+func dockerCertDir(ctx *types.SystemContext, hostPort string) string {
+	if ctx != nil {
+		if ctx.DockerPerHostCertDirPath != "" {
+			return filepath.Join(ctx.DockerPerHostCertDirPath, hostPort)
+		}
+		if ctx.RootForImplicitAbsolutePaths != "" {
+			return filepath.Join(ctx.RootForImplicitAbsolutePaths, systemPerHostCertDirPath, hostPort)
+		}
+	}
+	return filepath.Join(systemPerHostCertDirPath, hostPort)
 }
 
 // newDockerClient returns a new dockerClient instance for refHostname (a host a specified in the Docker image reference, not canonicalized to dockerRegistry)
 // “write” specifies whether the client will be used for "write" access (in particular passed to lookaside.go:toplevelFromSection)
 func newDockerClient(ctx *types.SystemContext, ref dockerReference, write bool) (*dockerClient, error) {
-	registry := ref.ref.Hostname()
-	if registry == dockerHostname {
-		registry = dockerRegistry
-	}
-	username, password, err := getAuth(ctx, ref.ref.Hostname())
+	config, err := sysregistries.LoadConfig(ctx)
 	if err != nil {
 		return nil, err
 	}
-	var tr *http.Transport
-	if ctx != nil && (ctx.DockerCertPath != "" || ctx.DockerInsecureSkipTLSVerify) {
-		tlsc := &tls.Config{}
+	if reg := config.FindRegistry(ref.ref.FullName()); reg != nil && reg.Blocked {
+		return nil, BlockedRegistryError{Location: reg.Location, ConfigPath: sysregistries.ConfigPath(ctx)}
+	}
+	hostname := ref.ref.Hostname()
+	insecure := isInsecure(ctx, config, hostname, false)
+	return newDockerClientForEndpoint(ctx, ref, hostname, insecure, write)
+}
 
-		if ctx.DockerCertPath != "" {
-			cert, err := tls.LoadX509KeyPair(filepath.Join(ctx.DockerCertPath, "cert.pem"), filepath.Join(ctx.DockerCertPath, "key.pem"))
-			if err != nil {
-				return nil, fmt.Errorf("Error loading x509 key pair: %s", err)
-			}
-			tlsc.Certificates = append(tlsc.Certificates, cert)
+// dockerProxy returns the http.Transport.Proxy function to use for contacting registries with ctx:
+// ctx.DockerProxyURL if set, no proxy if ctx.DockerDisableProxy, and the proxy configured in the environment otherwise.
+func dockerProxy(ctx *types.SystemContext) func(*http.Request) (*url.URL, error) {
+	if ctx != nil {
+		if ctx.DockerProxyURL != nil {
+			return http.ProxyURL(ctx.DockerProxyURL)
 		}
-		tlsc.InsecureSkipVerify = ctx.DockerInsecureSkipTLSVerify
-		tr = &http.Transport{
-			TLSClientConfig: tlsc,
+		if ctx.DockerDisableProxy {
+			return nil
 		}
 	}
-	client := &http.Client{}
-	if tr != nil {
-		client.Transport = tr
+	return http.ProxyFromEnvironment
+}
+
+// isInsecure returns true if the registry at hostPort (host[:port]) may be contacted over HTTP, or HTTPS with failed TLS verification.
+// ctx.DockerInsecureSkipTLSVerify, if defined, takes precedence over configInsecure (the "insecure" setting of the endpoint, if any) and config.
+func isInsecure(ctx *types.SystemContext, config *sysregistries.Config, hostPort string, configInsecure bool) bool {
+	if ctx != nil && ctx.DockerInsecureSkipTLSVerify != types.OptionalBoolUndefined {
+		return ctx.DockerInsecureSkipTLSVerify == types.OptionalBoolTrue
 	}
+	return configInsecure || config.IsInsecure(hostPort)
+}
+
+// newDockerClientForEndpoint returns a new dockerClient instance for accessing ref on the registry at hostname, which may be different from the
+// host specified in ref (e.g. a mirror).  Credentials are looked up for hostname, but signature storage is always configured for ref.
+// If insecure, the registry may be contacted over HTTP, or HTTPS with failed TLS verification.
+func newDockerClientForEndpoint(ctx *types.SystemContext, ref dockerReference, hostname string, insecure bool, write bool) (*dockerClient, error) {
+	registry := hostname
+	if registry == dockerHostname {
+		registry = dockerRegistry
+	}
+	username, password := "", ""
+	if ctx == nil || ctx.DockerCredentialsCallback == nil { // Otherwise credentials are obtained by updateCredentials when needed.
+		u, p, err := config.GetAuthentication(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+		username, password = u, p
+	}
+	tr, err := registryRoundTripper(ctx, hostname, insecure)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: tr}
 
 	sigBase, err := configuredSignatureStorageBase(ctx, ref, write)
 	if err != nil {
 		return nil, err
 	}
 
-	return &dockerClient{
-		ctx:           ctx,
+	actions := "pull"
+	if write {
+		actions = "pull,push"
+	}
+
+	c := &dockerClient{
+		sys:           ctx,
 		registry:      registry,
-		username:      username,
-		password:      password,
+		authHostname:  hostname,
+		repository:    ref.ref.RemoteName(),
+		insecure:      insecure,
 		client:        client,
 		signatureBase: sigBase,
-	}, nil
+		scope:         fmt.Sprintf("repository:%s:%s", ref.ref.RemoteName(), actions),
+	}
+	c.setCredentials(username, password)
+	return c, nil
+}
+
+// setCredentials sets the credentials used by c to username and password, as returned by config.GetAuthentication.
+func (c *dockerClient) setCredentials(username, password string) {
+	if username == config.IdentityTokenUsername {
+		c.username, c.password, c.identityToken = "", "", password
+	} else {
+		c.username, c.password, c.identityToken = username, password, ""
+	}
+}
+
+// updateCredentials obtains fresh credentials from c.sys.DockerCredentialsCallback, if set.
+func (c *dockerClient) updateCredentials() error {
+	if c.sys == nil || c.sys.DockerCredentialsCallback == nil {
+		return nil
+	}
+	username, password, err := c.sys.DockerCredentialsCallback(c.authHostname, c.repository)
+	if err != nil {
+		return fmt.Errorf("Error getting credentials for %s/%s: %v", c.authHostname, c.repository, err)
+	}
+	c.setCredentials(username, password)
+	return nil
 }
 
 // makeRequest creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
 // url is NOT an absolute URL, but a path relative to the /v2/ top-level API path.  The host name and schema is taken from the client or autodetected.
-func (c *dockerClient) makeRequest(method, url string, headers map[string][]string, stream io.Reader) (*http.Response, error) {
-	if c.scheme == "" {
-		pr, err := c.ping()
-		if err != nil {
-			return nil, err
-		}
-		c.wwwAuthenticate = pr.WWWAuthenticate
-		c.scheme = pr.scheme
+func (c *dockerClient) makeRequest(ctx context.Context, method, url string, headers map[string][]string, stream io.Reader) (*http.Response, error) {
+	if err := c.detectProperties(ctx); err != nil {
+		return nil, err
 	}
 
 	url = fmt.Sprintf(baseURL, c.scheme, c.registry) + url
-	return c.makeRequestToResolvedURL(method, url, headers, stream, -1)
+	return c.makeRequestToResolvedURL(ctx, method, url, headers, stream, -1)
+}
+
+// detectProperties pings the registry, if it has not been pinged yet, to detect its scheme, authentication and supported extensions.
+func (c *dockerClient) detectProperties(ctx context.Context) error {
+	c.propertiesMutex.Lock()
+	defer c.propertiesMutex.Unlock()
+	if c.scheme != "" {
+		return nil
+	}
+	pr, err := c.ping(ctx)
+	if err != nil {
+		return err
+	}
+	c.wwwAuthenticate = pr.WWWAuthenticate
+	c.supportsSignatures = pr.supportsSignatures
+	c.scheme = pr.scheme
+	return nil
 }
 
 // makeRequestToResolvedURL creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
 // streamLen, if not -1, specifies the length of the data expected on stream.
+// If the registry rejects the request because of rate limiting, and there is no single-use body stream, the request is retried
+// after the delay requested by the registry (or an increasing delay, if the registry does not specify one), within the limits
+// configured in c.sys.
 // makeRequest should generally be preferred.
-func (c *dockerClient) makeRequestToResolvedURL(method, url string, headers map[string][]string, stream io.Reader, streamLen int64) (*http.Response, error) {
+func (c *dockerClient) makeRequestToResolvedURL(ctx context.Context, method, url string, headers map[string][]string, stream io.Reader, streamLen int64) (*http.Response, error) {
+	maxWait := maxRateLimitWait(c.sys)
+	var totalWait time.Duration
+	for attempt := 0; ; attempt++ {
+		res, err := c.makeRequestToResolvedURLWithTokenRefresh(ctx, method, url, headers, stream, streamLen)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusTooManyRequests || stream != nil || attempt >= maxRateLimitRetries {
+			return res, nil
+		}
+		delay := rateLimitDelay(res, attempt, time.Now())
+		if totalWait+delay > maxWait {
+			return res, nil
+		}
+		res.Body.Close()
+		c.logger().Debugf("%s %s was rate-limited, retrying in %v", method, url, delay)
+		c.metrics().RegistryRetry(c.registry)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		totalWait += delay
+	}
+}
+
+// makeRequestToResolvedURLWithTokenRefresh creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
+// If the registry rejects a bearer token, it obtains a new one and retries, if possible.
+// This is an implementation detail of makeRequestToResolvedURL.
+func (c *dockerClient) makeRequestToResolvedURLWithTokenRefresh(ctx context.Context, method, url string, headers map[string][]string, stream io.Reader, streamLen int64) (*http.Response, error) {
+	res, err := c.makeRequestToResolvedURLOnce(ctx, method, url, headers, stream, streamLen, nil)
+	if err != nil {
+		return nil, err
+	}
+	// If the registry asks for a token for a different scope than we have guessed, or our token has been
+	// rejected despite not being expired yet, get a new one and retry.  This is only possible if
+	// there is no single-use body stream; requests with a body must rely on the proactive choice of scope
+	// and refresh in setupRequestAuth.
+	if res.StatusCode == http.StatusUnauthorized && stream == nil && c.usesBearerAuth() {
+		chs := parseAuthHeader(res.Header)
+		if len(chs) != 0 && chs[0].Scheme == "bearer" {
+			// Arbitrarily use the first challenge, there is no reason to expect more than one.
+			res.Body.Close()
+			c.forgetRejectedBearerToken(chs[0], res.Request)
+			c.metrics().RegistryRetry(c.registry)
+			return c.makeRequestToResolvedURLOnce(ctx, method, url, headers, stream, streamLen, &chs[0])
+		}
+	}
+	return res, nil
+}
+
+// makeRequestToResolvedURLOnce creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
+// If ch is not nil, it is the bearer authentication challenge to use instead of the one guessed from c.
+// This is an implementation detail of makeRequestToResolvedURLWithTokenRefresh.
+func (c *dockerClient) makeRequestToResolvedURLOnce(ctx context.Context, method, url string, headers map[string][]string, stream io.Reader, streamLen int64, ch *challenge) (*http.Response, error) {
 	req, err := http.NewRequest(method, url, stream)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	if streamLen != -1 { // Do not blindly overwrite if streamLen == -1, http.NewRequest above can figure out the length of bytes.Reader and similar objects without us having to compute it.
 		req.ContentLength = streamLen
 	}
@@ -125,65 +389,68 @@ func (c *dockerClient) makeRequestToResolvedURL(method, url string, headers map[
 			req.Header.Add(n, hh)
 		}
 	}
-	if c.ctx != nil && c.ctx.DockerRegistryUserAgent != "" {
-		req.Header.Add("User-Agent", c.ctx.DockerRegistryUserAgent)
-	}
+	req.Header.Set("User-Agent", userAgent(c.sys))
 	if c.wwwAuthenticate != "" {
-		if err := c.setupRequestAuth(req); err != nil {
+		if err := c.setupRequestAuth(ctx, req, ch); err != nil {
 			return nil, err
 		}
 	}
-	logrus.Debugf("%s %s", method, url)
+	c.logger().Debugf("%s %s", method, url)
+	start := time.Now()
 	res, err := c.client.Do(req)
 	if err != nil {
+		c.metrics().RegistryRequest(c.registry, method, 0, time.Since(start))
 		return nil, err
 	}
+	c.metrics().RegistryRequest(c.registry, method, res.StatusCode, time.Since(start))
+	if c.sys != nil && c.sys.DockerRateLimitCallback != nil {
+		if limit, ok := parseRateLimit(res.Header); ok {
+			c.sys.DockerRateLimitCallback(c.registry, limit)
+		}
+	}
 	return res, nil
 }
 
-func (c *dockerClient) setupRequestAuth(req *http.Request) error {
+// usesBearerAuth returns true if the registry, as determined by ping(), requires bearer token authentication.
+func (c *dockerClient) usesBearerAuth() bool {
+	tokens := strings.SplitN(strings.TrimSpace(c.wwwAuthenticate), " ", 2)
+	return len(tokens) == 2 && tokens[0] == "Bearer"
+}
+
+// setupRequestAuth adds authentication to req.
+// If ch is not nil, it is the bearer authentication challenge to use instead of the one guessed from c.
+func (c *dockerClient) setupRequestAuth(ctx context.Context, req *http.Request, ch *challenge) error {
+	c.authMutex.Lock()
+	defer c.authMutex.Unlock()
 	tokens := strings.SplitN(strings.TrimSpace(c.wwwAuthenticate), " ", 2)
 	if len(tokens) != 2 {
 		return fmt.Errorf("expected 2 tokens in WWW-Authenticate: %d, %s", len(tokens), c.wwwAuthenticate)
 	}
 	switch tokens[0] {
 	case "Basic":
+		if err := c.updateCredentials(); err != nil {
+			return err
+		}
 		req.SetBasicAuth(c.username, c.password)
 		return nil
 	case "Bearer":
-		// FIXME? This gets a new token for every API request;
-		// we may be easily able to reuse a previous token, e.g.
-		// for OpenShift the token only identifies the user and does not vary
-		// across operations.  Should we just try the request first, and
-		// only get a new token on failure?
-		// OTOH what to do with the single-use body stream in that case?
-
-		// Try performing the request, expecting it to fail.
-		testReq := *req
-		// Do not use the body stream, or we couldn't reuse it for the "real" call later.
-		testReq.Body = nil
-		testReq.ContentLength = 0
-		res, err := c.client.Do(&testReq)
-		if err != nil {
-			return err
-		}
-		chs := parseAuthHeader(res.Header)
-		if res.StatusCode != http.StatusUnauthorized || chs == nil || len(chs) == 0 {
-			// no need for bearer? wtf?
-			return nil
-		}
-		// Arbitrarily use the first challenge, there is no reason to expect more than one.
-		challenge := chs[0]
-		if challenge.Scheme != "bearer" { // Another artifact of trying to handle WWW-Authenticate before it actually happens.
-			return fmt.Errorf("Unimplemented: WWW-Authenticate Bearer replaced by %#v", challenge.Scheme)
-		}
-		realm, ok := challenge.Parameters["realm"]
-		if !ok {
-			return fmt.Errorf("missing realm in bearer auth challenge")
+		if ch == nil {
+			// Use the challenge returned by ping(), asking for the scope this client is expected to need.
+			// If the guess is wrong, makeRequestToResolvedURL will retry with the challenge returned for the request.
+			chs := parseAuthHeader(http.Header{"Www-Authenticate": []string{c.wwwAuthenticate}})
+			if len(chs) == 0 {
+				return fmt.Errorf("Error parsing WWW-Authenticate: %s", c.wwwAuthenticate)
+			}
+			params := map[string]string{}
+			for k, v := range chs[0].Parameters {
+				params[k] = v
+			}
+			if c.scope != "" {
+				params["scope"] = c.scope
+			}
+			ch = &challenge{Scheme: chs[0].Scheme, Parameters: params}
 		}
-		service, _ := challenge.Parameters["service"] // Will be "" if not present
-		scope, _ := challenge.Parameters["scope"]     // Will be "" if not present
-		token, err := c.getBearerToken(realm, service, scope)
+		token, err := c.getBearerToken(ctx, *ch)
 		if err != nil {
 			return err
 		}
@@ -194,139 +461,212 @@ func (c *dockerClient) setupRequestAuth(req *http.Request) error {
 	// support docker bearer with authconfig's Auth string? see docker2aci
 }
 
-func (c *dockerClient) getBearerToken(realm, service, scope string) (string, error) {
-	authReq, err := http.NewRequest("GET", realm, nil)
+// addScope extends the bearer token scope requested by c with scope, e.g. "repository:library/busybox:pull", if it is not included yet.
+// Tokens are cached per scope, so the next authenticated request obtains a new token authorizing both the original and the added scopes.
+func (c *dockerClient) addScope(scope string) {
+	c.authMutex.Lock()
+	defer c.authMutex.Unlock()
+	for _, s := range strings.Fields(c.scope) {
+		if s == scope {
+			return
+		}
+	}
+	if c.scope == "" {
+		c.scope = scope
+	} else {
+		c.scope = c.scope + " " + scope
+	}
+}
+
+// bearerTokenCacheKey returns the key of c.tokenCache used for tokens satisfying ch.
+func bearerTokenCacheKey(ch challenge) string {
+	return fmt.Sprintf("%s\x00%s\x00%s", ch.Parameters["realm"], ch.Parameters["service"], ch.Parameters["scope"])
+}
+
+// getBearerToken returns a token satisfying ch, either a cached one which is not about to expire, or a newly obtained one.
+func (c *dockerClient) getBearerToken(ctx context.Context, ch challenge) (string, error) {
+	key := bearerTokenCacheKey(ch)
+	if token, ok := c.tokenCache[key]; ok && time.Now().Add(bearerTokenRefreshMargin).Before(token.expirationTime) {
+		return token.Token, nil
+	}
+	realm, ok := ch.Parameters["realm"]
+	if !ok {
+		return "", fmt.Errorf("missing realm in bearer auth challenge")
+	}
+	service, _ := ch.Parameters["service"] // Will be "" if not present
+	scope, _ := ch.Parameters["scope"]     // Will be "" if not present
+	token, err := c.getNewBearerToken(ctx, realm, service, scope)
 	if err != nil {
 		return "", err
 	}
-	getParams := authReq.URL.Query()
-	if service != "" {
-		getParams.Add("service", service)
+	if c.tokenCache == nil {
+		c.tokenCache = map[string]bearerToken{}
 	}
-	if scope != "" {
-		getParams.Add("scope", scope)
+	c.tokenCache[key] = *token
+	return token.Token, nil
+}
+
+// forgetRejectedBearerToken removes a cached token satisfying ch, if req has used it and has been rejected.
+func (c *dockerClient) forgetRejectedBearerToken(ch challenge, req *http.Request) {
+	c.authMutex.Lock()
+	defer c.authMutex.Unlock()
+	key := bearerTokenCacheKey(ch)
+	if token, ok := c.tokenCache[key]; ok && req.Header.Get("Authorization") == fmt.Sprintf("Bearer %s", token.Token) {
+		delete(c.tokenCache, key)
+	}
+}
+
+// getNewBearerToken obtains a new token for service and scope from the token server at realm.
+// If the token server rejects our credentials, and scope only asks for pulls, an anonymous token is used instead,
+// so that public images can be pulled even if the stored credentials are stale.
+func (c *dockerClient) getNewBearerToken(ctx context.Context, realm, service, scope string) (*bearerToken, error) {
+	if err := c.updateCredentials(); err != nil {
+		return nil, err
+	}
+	token, err := c.requestBearerToken(ctx, realm, service, scope, true)
+	if err == errBearerTokenUnauthorized && c.hasCredentials() && isPullOnlyScope(scope) {
+		c.logger().Debugf("Credentials for %s were rejected, trying to get an anonymous token for scope %q", c.registry, scope)
+		anonToken, anonErr := c.requestBearerToken(ctx, realm, service, scope, false)
+		if anonErr == nil {
+			return anonToken, nil
+		}
+		c.logger().Debugf("Error getting an anonymous token: %v", anonErr)
+	}
+	return token, err
+}
+
+// hasCredentials returns true if c has credentials to use when requesting bearer tokens.
+func (c *dockerClient) hasCredentials() bool {
+	return c.identityToken != "" || (c.username != "" && c.password != "")
+}
+
+// isPullOnlyScope returns true if scope, a space-separated list of resource:name:actions scopes, only asks for "pull" actions.
+func isPullOnlyScope(scope string) bool {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return false
 	}
-	authReq.URL.RawQuery = getParams.Encode()
-	if c.username != "" && c.password != "" {
-		authReq.SetBasicAuth(c.username, c.password)
+	for _, s := range scopes {
+		i := strings.LastIndex(s, ":")
+		if i == -1 || s[i+1:] != "pull" {
+			return false
+		}
+	}
+	return true
+}
+
+// requestBearerToken obtains a new token for service and scope from the token server at realm, using c's credentials if useCredentials.
+// This is an implementation detail of getNewBearerToken.
+func (c *dockerClient) requestBearerToken(ctx context.Context, realm, service, scope string, useCredentials bool) (*bearerToken, error) {
+	var authReq *http.Request
+	var err error
+	if useCredentials && c.identityToken != "" {
+		// Exchange the identity token using the OAuth2 refresh_token grant.
+		params := url.Values{}
+		params.Add("grant_type", "refresh_token")
+		params.Add("refresh_token", c.identityToken)
+		params.Add("client_id", oauth2ClientID)
+		if service != "" {
+			params.Add("service", service)
+		}
+		if scope != "" {
+			params.Add("scope", scope)
+		}
+		authReq, err = http.NewRequest("POST", realm, strings.NewReader(params.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		authReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		authReq, err = http.NewRequest("GET", realm, nil)
+		if err != nil {
+			return nil, err
+		}
+		getParams := authReq.URL.Query()
+		if service != "" {
+			getParams.Add("service", service)
+		}
+		if scope != "" {
+			getParams.Add("scope", scope)
+		}
+		authReq.URL.RawQuery = getParams.Encode()
+		if useCredentials && c.username != "" && c.password != "" {
+			authReq.SetBasicAuth(c.username, c.password)
+		}
+	}
+	authReq = authReq.WithContext(ctx)
+	authReq.Header.Set("User-Agent", userAgent(c.sys))
+	tr, err := tokenServerHTTPTransport(c.sys, c.authHostname, c.insecure)
+	if err != nil {
+		return nil, err
 	}
-	// insecure for now to contact the external token service
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
 	client := &http.Client{Transport: tr}
+	c.logger().Debugf("Requesting a bearer token for service %q, scope %q", service, scope)
 	res, err := client.Do(authReq)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer res.Body.Close()
 	switch res.StatusCode {
 	case http.StatusUnauthorized:
-		return "", fmt.Errorf("unable to retrieve auth token: 401 unauthorized")
+		return nil, errBearerTokenUnauthorized
 	case http.StatusOK:
 		break
 	default:
-		return "", fmt.Errorf("unexpected http code: %d, URL: %s", res.StatusCode, authReq.URL)
+		return nil, fmt.Errorf("unexpected http code: %d, URL: %s", res.StatusCode, authReq.URL)
 	}
 	tokenBlob, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	tokenStruct := struct {
-		Token string `json:"token"`
-	}{}
-	if err := json.Unmarshal(tokenBlob, &tokenStruct); err != nil {
-		return "", err
+	token, err := newBearerTokenFromJSONBlob(tokenBlob)
+	if err != nil {
+		return nil, err
 	}
-	// TODO(runcom): reuse tokens?
-	//hostAuthTokens, ok = rb.hostsV2AuthTokens[req.URL.Host]
-	//if !ok {
-	//hostAuthTokens = make(map[string]string)
-	//rb.hostsV2AuthTokens[req.URL.Host] = hostAuthTokens
-	//}
-	//hostAuthTokens[repo] = tokenStruct.Token
-	return tokenStruct.Token, nil
-}
-
-func getAuth(ctx *types.SystemContext, registry string) (string, string, error) {
-	if ctx != nil && ctx.DockerAuthConfig != nil {
-		return ctx.DockerAuthConfig.Username, ctx.DockerAuthConfig.Password, nil
-	}
-	// TODO(runcom): get this from *cli.Context somehow
-	//if username != "" && password != "" {
-	//return username, password, nil
-	//}
-	var dockerAuth dockerConfigFile
-	dockerCfgPath := filepath.Join(getDefaultConfigDir(".docker"), dockerCfgFileName)
-	if _, err := os.Stat(dockerCfgPath); err == nil {
-		j, err := ioutil.ReadFile(dockerCfgPath)
-		if err != nil {
-			return "", "", err
-		}
-		if err := json.Unmarshal(j, &dockerAuth); err != nil {
-			return "", "", err
-		}
-
-	} else if os.IsNotExist(err) {
-		// try old config path
-		oldDockerCfgPath := filepath.Join(getDefaultConfigDir(dockerCfgObsolete))
-		if _, err := os.Stat(oldDockerCfgPath); err != nil {
-			if os.IsNotExist(err) {
-				return "", "", nil
-			}
-			return "", "", fmt.Errorf("%s - %v", oldDockerCfgPath, err)
-		}
-
-		j, err := ioutil.ReadFile(oldDockerCfgPath)
-		if err != nil {
-			return "", "", err
-		}
-		if err := json.Unmarshal(j, &dockerAuth.AuthConfigs); err != nil {
-			return "", "", err
-		}
-
-	} else if err != nil {
-		return "", "", fmt.Errorf("%s - %v", dockerCfgPath, err)
+	if useCredentials && c.identityToken != "" && token.RefreshToken != "" {
+		// The token server may rotate the identity token; use the new one from now on.
+		c.identityToken = token.RefreshToken
 	}
+	return token, nil
+}
 
-	// I'm feeling lucky
-	if c, exists := dockerAuth.AuthConfigs[registry]; exists {
-		return decodeDockerAuth(c.Auth)
+// newBearerTokenFromJSONBlob parses a token server response, and computes the token expiration time.
+func newBearerTokenFromJSONBlob(blob []byte) (*bearerToken, error) {
+	token := bearerToken{}
+	if err := json.Unmarshal(blob, &token); err != nil {
+		return nil, err
 	}
-
-	// bad luck; let's normalize the entries first
-	registry = normalizeRegistry(registry)
-	normalizedAuths := map[string]dockerAuthConfig{}
-	for k, v := range dockerAuth.AuthConfigs {
-		normalizedAuths[normalizeRegistry(k)] = v
+	if token.Token == "" {
+		token.Token = token.AccessToken
 	}
-	if c, exists := normalizedAuths[registry]; exists {
-		return decodeDockerAuth(c.Auth)
+	if token.ExpiresIn < minimumTokenLifetimeSeconds {
+		token.ExpiresIn = minimumTokenLifetimeSeconds
 	}
-	return "", "", nil
-}
-
-type apiErr struct {
-	Code    string
-	Message string
-	Detail  interface{}
+	if token.IssuedAt.IsZero() {
+		token.IssuedAt = time.Now().UTC()
+	}
+	token.expirationTime = token.IssuedAt.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return &token, nil
 }
 
 type pingResponse struct {
-	WWWAuthenticate string
-	APIVersion      string
-	scheme          string
-	errors          []apiErr
+	WWWAuthenticate    string
+	APIVersion         string
+	scheme             string
+	supportsSignatures bool // The registry supports the X-Registry-Supports-Signatures API extension
+	errors             []RegistryErrorDetail
 }
 
-func (c *dockerClient) ping() (*pingResponse, error) {
+func (c *dockerClient) ping(ctx context.Context) (*pingResponse, error) {
 	ping := func(scheme string) (*pingResponse, error) {
 		url := fmt.Sprintf(baseURL, scheme, c.registry)
-		resp, err := c.makeRequestToResolvedURL("GET", url, nil, nil, -1)
-		logrus.Debugf("Ping %s err %#v", url, err)
+		resp, err := c.makeRequestToResolvedURL(ctx, "GET", url, nil, nil, -1)
+		c.logger().Debugf("Ping %s err %#v", url, err)
 		if err != nil {
 			return nil, err
 		}
 		defer resp.Body.Close()
-		logrus.Debugf("Ping %s status %d", scheme+"://"+c.registry+"/v2/", resp.StatusCode)
+		c.logger().Debugf("Ping %s status %d", scheme+"://"+c.registry+"/v2/", resp.StatusCode)
 		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
 			return nil, fmt.Errorf("error pinging repository, response code %d", resp.StatusCode)
 		}
@@ -334,9 +674,10 @@ func (c *dockerClient) ping() (*pingResponse, error) {
 		pr.WWWAuthenticate = resp.Header.Get("WWW-Authenticate")
 		pr.APIVersion = resp.Header.Get("Docker-Distribution-Api-Version")
 		pr.scheme = scheme
+		pr.supportsSignatures = resp.Header.Get("X-Registry-Supports-Signatures") == "1"
 		if resp.StatusCode == http.StatusUnauthorized {
 			type APIErrors struct {
-				Errors []apiErr
+				Errors []RegistryErrorDetail
 			}
 			errs := &APIErrors{}
 			if err := json.NewDecoder(resp.Body).Decode(errs); err != nil {
@@ -346,61 +687,25 @@ func (c *dockerClient) ping() (*pingResponse, error) {
 		}
 		return pr, nil
 	}
+	if c.insecure {
+		httpFallbackRegistries.Lock()
+		httpOnly := httpFallbackRegistries.registries[c.registry]
+		httpFallbackRegistries.Unlock()
+		if httpOnly {
+			return ping("http")
+		}
+	}
 	pr, err := ping("https")
-	if err != nil && c.ctx != nil && c.ctx.DockerInsecureSkipTLSVerify {
-		pr, err = ping("http")
+	if err != nil && c.insecure {
+		c.logger().Debugf("Error pinging %s using HTTPS, trying HTTP: %v", c.registry, err)
+		pr, httpErr := ping("http")
+		if httpErr != nil {
+			return nil, fmt.Errorf("Error pinging registry %s, HTTPS: %v, HTTP: %v", c.registry, err, httpErr)
+		}
+		httpFallbackRegistries.Lock()
+		httpFallbackRegistries.registries[c.registry] = true
+		httpFallbackRegistries.Unlock()
+		return pr, nil
 	}
 	return pr, err
 }
-
-func getDefaultConfigDir(confPath string) string {
-	return filepath.Join(homedir.Get(), confPath)
-}
-
-type dockerAuthConfig struct {
-	Auth string `json:"auth,omitempty"`
-}
-
-type dockerConfigFile struct {
-	AuthConfigs map[string]dockerAuthConfig `json:"auths"`
-}
-
-func decodeDockerAuth(s string) (string, string, error) {
-	decoded, err := base64.StdEncoding.DecodeString(s)
-	if err != nil {
-		return "", "", err
-	}
-	parts := strings.SplitN(string(decoded), ":", 2)
-	if len(parts) != 2 {
-		// if it's invalid just skip, as docker does
-		return "", "", nil
-	}
-	user := parts[0]
-	password := strings.Trim(parts[1], "\x00")
-	return user, password, nil
-}
-
-// convertToHostname converts a registry url which has http|https prepended
-// to just an hostname.
-// Copied from github.com/docker/docker/registry/auth.go
-func convertToHostname(url string) string {
-	stripped := url
-	if strings.HasPrefix(url, "http://") {
-		stripped = strings.TrimPrefix(url, "http://")
-	} else if strings.HasPrefix(url, "https://") {
-		stripped = strings.TrimPrefix(url, "https://")
-	}
-
-	nameParts := strings.SplitN(stripped, "/", 2)
-
-	return nameParts[0]
-}
-
-func normalizeRegistry(registry string) string {
-	normalized := convertToHostname(registry)
-	switch normalized {
-	case "registry-1.docker.io", "docker.io":
-		return "index.docker.io"
-	}
-	return normalized
-}
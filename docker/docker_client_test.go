@@ -1,432 +1,528 @@
 package docker
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	//"fmt"
-	"io/ioutil"
-	"os"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"path/filepath"
-	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/containers/image/pkg/docker/config"
+	"github.com/containers/image/pkg/sysregistries"
 	"github.com/containers/image/types"
-	"github.com/docker/docker/pkg/homedir"
+	"github.com/containers/image/version"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestGetAuth(t *testing.T) {
-	origHomeDir := homedir.Get()
-	tmpDir, err := ioutil.TempDir("", "test_docker_client_get_auth")
-	if err != nil {
-		t.Fatal(err)
-	}
-	t.Logf("using temporary home directory: %q", tmpDir)
-	// override homedir
-	os.Setenv(homedir.Key(), tmpDir)
-	defer func() {
-		err := os.RemoveAll(tmpDir)
-		if err != nil {
-			t.Logf("failed to cleanup temporary home directory %q: %v", tmpDir, err)
-		}
-		os.Setenv(homedir.Key(), origHomeDir)
-	}()
+func TestDockerCertDir(t *testing.T) {
+	const nondefaultPath = "/this/is/not/the/default/certs.d"
+	const rootPrefix = "/root/prefix"
+	const hostPort = "registry.example.com:5000"
 
-	configDir := filepath.Join(tmpDir, ".docker")
-	if err := os.Mkdir(configDir, 0750); err != nil {
-		t.Fatal(err)
-	}
-	configPath := filepath.Join(configDir, "config.json")
-
-	for _, tc := range []struct {
-		name             string
-		hostname         string
-		authConfig       testAuthConfig
-		expectedUsername string
-		expectedPassword string
-		expectedError    error
-		ctx              *types.SystemContext
+	for _, c := range []struct {
+		ctx      *types.SystemContext
+		expected string
 	}{
+		// The common case
+		{nil, filepath.Join(systemPerHostCertDirPath, hostPort)},
+		// There is a context, but it does not override the path.
+		{&types.SystemContext{}, filepath.Join(systemPerHostCertDirPath, hostPort)},
+		// Path overridden
+		{&types.SystemContext{DockerPerHostCertDirPath: nondefaultPath}, filepath.Join(nondefaultPath, hostPort)},
+		// Root overridden
 		{
-			name:       "empty hostname",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{"localhost:5000": testAuthConfigData{"bob", "password"}}),
-		},
-		{
-			name:     "no auth config",
-			hostname: "index.docker.io",
-		},
-		{
-			name:             "match one",
-			hostname:         "example.org",
-			authConfig:       makeTestAuthConfig(testAuthConfigDataMap{"example.org": testAuthConfigData{"joe", "mypass"}}),
-			expectedUsername: "joe",
-			expectedPassword: "mypass",
-		},
-		{
-			name:       "match none",
-			hostname:   "registry.example.org",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{"example.org": testAuthConfigData{"joe", "mypass"}}),
+			&types.SystemContext{RootForImplicitAbsolutePaths: rootPrefix},
+			filepath.Join(rootPrefix, systemPerHostCertDirPath, hostPort),
 		},
+		// Root and path overrides present simultaneously,
 		{
-			name:     "match docker.io",
-			hostname: "docker.io",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"example.org":     testAuthConfigData{"example", "org"},
-				"index.docker.io": testAuthConfigData{"index", "docker.io"},
-				"docker.io":       testAuthConfigData{"docker", "io"},
-			}),
-			expectedUsername: "docker",
-			expectedPassword: "io",
-		},
-		{
-			name:     "match docker.io normalized",
-			hostname: "docker.io",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"example.org":                testAuthConfigData{"bob", "pw"},
-				"https://index.docker.io/v1": testAuthConfigData{"alice", "wp"},
-			}),
-			expectedUsername: "alice",
-			expectedPassword: "wp",
-		},
-		{
-			name:     "normalize registry",
-			hostname: "https://docker.io/v1",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"docker.io":      testAuthConfigData{"user", "pw"},
-				"localhost:5000": testAuthConfigData{"joe", "pass"},
-			}),
-			expectedUsername: "user",
-			expectedPassword: "pw",
-		},
-		{
-			name:     "match localhost",
-			hostname: "http://localhost",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"docker.io":   testAuthConfigData{"user", "pw"},
-				"localhost":   testAuthConfigData{"joe", "pass"},
-				"example.com": testAuthConfigData{"alice", "pwd"},
-			}),
-			expectedUsername: "joe",
-			expectedPassword: "pass",
-		},
-		{
-			name:     "match ip",
-			hostname: "10.10.3.56:5000",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"10.10.30.45":     testAuthConfigData{"user", "pw"},
-				"localhost":       testAuthConfigData{"joe", "pass"},
-				"10.10.3.56":      testAuthConfigData{"alice", "pwd"},
-				"10.10.3.56:5000": testAuthConfigData{"me", "mine"},
-			}),
-			expectedUsername: "me",
-			expectedPassword: "mine",
-		},
-		{
-			name:     "match port",
-			hostname: "https://localhost:5000",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"https://127.0.0.1:5000": testAuthConfigData{"user", "pw"},
-				"http://localhost":       testAuthConfigData{"joe", "pass"},
-				"https://localhost:5001": testAuthConfigData{"alice", "pwd"},
-				"localhost:5000":         testAuthConfigData{"me", "mine"},
-			}),
-			expectedUsername: "me",
-			expectedPassword: "mine",
-		},
-		{
-			name:     "use system context",
-			hostname: "example.org",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"example.org": testAuthConfigData{"user", "pw"},
-			}),
-			expectedUsername: "foo",
-			expectedPassword: "bar",
-			ctx: &types.SystemContext{
-				DockerAuthConfig: &types.DockerAuthConfig{
-					Username: "foo",
-					Password: "bar",
-				},
+			&types.SystemContext{
+				RootForImplicitAbsolutePaths: rootPrefix,
+				DockerPerHostCertDirPath:     nondefaultPath,
 			},
+			filepath.Join(nondefaultPath, hostPort),
 		},
 	} {
-		contents, err := json.MarshalIndent(&tc.authConfig, "", "  ")
-		if err != nil {
-			t.Errorf("[%s] failed to marshal authConfig: %v", tc.name, err)
-			continue
-		}
-		if err := ioutil.WriteFile(configPath, contents, 0640); err != nil {
-			t.Errorf("[%s] failed to write file %q: %v", tc.name, configPath, err)
-			continue
-		}
-
-		var ctx *types.SystemContext
-		if tc.ctx != nil {
-			ctx = tc.ctx
-		}
-		username, password, err := getAuth(ctx, tc.hostname)
-		if err == nil && tc.expectedError != nil {
-			t.Errorf("[%s] got unexpected non error and username=%q, password=%q", tc.name, username, password)
-			continue
-		}
-		if err != nil && tc.expectedError == nil {
-			t.Errorf("[%s] got unexpected error: %#+v", tc.name, err)
-			continue
-		}
-		if !reflect.DeepEqual(err, tc.expectedError) {
-			t.Errorf("[%s] got unexpected error: %#+v != %#+v", tc.name, err, tc.expectedError)
-			continue
-		}
-
-		if username != tc.expectedUsername {
-			t.Errorf("[%s] got unexpected user name: %q != %q", tc.name, username, tc.expectedUsername)
-		}
-		if password != tc.expectedPassword {
-			t.Errorf("[%s] got unexpected user name: %q != %q", tc.name, password, tc.expectedPassword)
-		}
+		path := dockerCertDir(c.ctx, hostPort)
+		assert.Equal(t, c.expected, path)
 	}
 }
 
-func TestGetAuthFromLegacyFile(t *testing.T) {
-	origHomeDir := homedir.Get()
-	tmpDir, err := ioutil.TempDir("", "test_docker_client_get_auth")
-	if err != nil {
-		t.Fatal(err)
+func TestIsInsecure(t *testing.T) {
+	config := &sysregistries.Config{
+		Registries: []sysregistries.Registry{
+			{Endpoint: sysregistries.Endpoint{Location: "insecure.example.com", Insecure: true}},
+		},
 	}
-	t.Logf("using temporary home directory: %q", tmpDir)
-	// override homedir
-	os.Setenv(homedir.Key(), tmpDir)
-	defer func() {
-		err := os.RemoveAll(tmpDir)
-		if err != nil {
-			t.Logf("failed to cleanup temporary home directory %q: %v", tmpDir, err)
-		}
-		os.Setenv(homedir.Key(), origHomeDir)
-	}()
-
-	configPath := filepath.Join(tmpDir, ".dockercfg")
-
-	for _, tc := range []struct {
-		name             string
-		hostname         string
-		authConfig       testAuthConfig
-		expectedUsername string
-		expectedPassword string
-		expectedError    error
+	for _, c := range []struct {
+		ctx            *types.SystemContext
+		hostPort       string
+		configInsecure bool
+		expected       bool
 	}{
-		{
-			name:     "normalize registry",
-			hostname: "https://docker.io/v1",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"docker.io":      testAuthConfigData{"user", "pw"},
-				"localhost:5000": testAuthConfigData{"joe", "pass"},
-			}),
-			expectedUsername: "user",
-			expectedPassword: "pw",
-		},
-		{
-			name:     "ignore schema and path",
-			hostname: "http://index.docker.io/v1",
-			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
-				"docker.io/v2":         testAuthConfigData{"user", "pw"},
-				"https://localhost/v1": testAuthConfigData{"joe", "pwd"},
-			}),
-			expectedUsername: "user",
-			expectedPassword: "pw",
-		},
+		{nil, "secure.example.com", false, false},
+		{nil, "insecure.example.com", false, true},
+		{nil, "secure.example.com", true, true},
+		{&types.SystemContext{}, "insecure.example.com", false, true},
+		{&types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}, "secure.example.com", false, true},
+		{&types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolFalse}, "insecure.example.com", false, false},
+		{&types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolFalse}, "secure.example.com", true, false},
 	} {
-		contents, err := json.MarshalIndent(&tc.authConfig.Auths, "", "  ")
-		if err != nil {
-			t.Errorf("[%s] failed to marshal authConfig: %v", tc.name, err)
-			continue
-		}
-		if err := ioutil.WriteFile(configPath, contents, 0640); err != nil {
-			t.Errorf("[%s] failed to write file %q: %v", tc.name, configPath, err)
-			continue
-		}
+		res := isInsecure(c.ctx, config, c.hostPort, c.configInsecure)
+		assert.Equal(t, c.expected, res, "%#v", c)
+	}
+}
 
-		username, password, err := getAuth(nil, tc.hostname)
-		if err == nil && tc.expectedError != nil {
-			t.Errorf("[%s] got unexpected non error and username=%q, password=%q", tc.name, username, password)
-			continue
-		}
-		if err != nil && tc.expectedError == nil {
-			t.Errorf("[%s] got unexpected error: %#+v", tc.name, err)
-			continue
-		}
-		if !reflect.DeepEqual(err, tc.expectedError) {
-			t.Errorf("[%s] got unexpected error: %#+v != %#+v", tc.name, err, tc.expectedError)
+func TestNewDockerClientBlockedRegistry(t *testing.T) {
+	ctx, cleanup := writeRegistriesConf(t, "[[registry]]\nlocation = \"blocked.example.com\"\nblocked = true\n\n[[registry]]\nlocation = \"docker.io/blocked\"\nblocked = true\n")
+	defer cleanup()
+
+	for _, c := range []struct{ ref, blockedLocation string }{ // blockedLocation is "" if the reference is not blocked
+		{"//blocked.example.com/ns/image:latest", "blocked.example.com"},
+		{"//blocked/image:latest", "docker.io/blocked"},
+		{"//busybox:latest", ""},
+		{"//notblocked.example.com/image:latest", ""},
+	} {
+		ref, err := ParseReference(c.ref)
+		require.NoError(t, err, c.ref)
+		_, err = newDockerClient(ctx, ref.(dockerReference), false)
+		if c.blockedLocation == "" {
+			assert.NoError(t, err, c.ref)
 			continue
 		}
+		var blockedErr BlockedRegistryError
+		require.True(t, errors.As(err, &blockedErr), c.ref)
+		assert.Equal(t, BlockedRegistryError{Location: c.blockedLocation, ConfigPath: ctx.SystemRegistriesConfPath}, blockedErr, c.ref)
 
-		if username != tc.expectedUsername {
-			t.Errorf("[%s] got unexpected user name: %q != %q", tc.name, username, tc.expectedUsername)
-		}
-		if password != tc.expectedPassword {
-			t.Errorf("[%s] got unexpected user name: %q != %q", tc.name, password, tc.expectedPassword)
-		}
+		// Sources and destinations are refused as well.
+		_, err = ref.NewImageSource(context.Background(), ctx)
+		assert.True(t, errors.As(err, &blockedErr), c.ref)
+		_, err = ref.NewImageDestination(context.Background(), ctx)
+		assert.True(t, errors.As(err, &blockedErr), c.ref)
 	}
 }
 
-func TestGetAuthPreferNewConfig(t *testing.T) {
-	origHomeDir := homedir.Get()
-	tmpDir, err := ioutil.TempDir("", "test_docker_client_get_auth")
-	if err != nil {
-		t.Fatal(err)
-	}
-	t.Logf("using temporary home directory: %q", tmpDir)
-	// override homedir
-	os.Setenv(homedir.Key(), tmpDir)
-	defer func() {
-		err := os.RemoveAll(tmpDir)
-		if err != nil {
-			t.Logf("failed to cleanup temporary home directory %q: %v", tmpDir, err)
+func TestDockerClientPingHTTPFallback(t *testing.T) {
+	pings := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			pings++
 		}
-		os.Setenv(homedir.Key(), origHomeDir)
-	}()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
 
-	configDir := filepath.Join(tmpDir, ".docker")
-	if err := os.Mkdir(configDir, 0750); err != nil {
-		t.Fatal(err)
-	}
+	// Without insecure, only HTTPS is used.
+	c := &dockerClient{registry: u.Host, client: &http.Client{}}
+	_, err = c.makeRequest(context.Background(), "GET", "_catalog", nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 0, pings)
+
+	// With insecure, the client falls back to HTTP.
+	c = &dockerClient{registry: u.Host, insecure: true, client: &http.Client{}}
+	res, err := c.makeRequest(context.Background(), "GET", "_catalog", nil, nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "http", c.scheme)
+	assert.Equal(t, 1, pings)
+
+	// … and later clients remember the fallback.
+	c = &dockerClient{registry: u.Host, insecure: true, client: &http.Client{}}
+	pr, err := c.ping(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "http", pr.scheme)
+	assert.Equal(t, 2, pings)
+
+	httpFallbackRegistries.Lock()
+	delete(httpFallbackRegistries.registries, u.Host)
+	httpFallbackRegistries.Unlock()
+}
 
-	for _, data := range []struct {
-		path string
-		ac   interface{}
+// recordingLogger is a types.Logger recording all messages.
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.Debugf(format, args...)
+}
+
+func TestDockerClientLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	logger := &recordingLogger{}
+	c := &dockerClient{sys: &types.SystemContext{Logger: logger}, registry: u.Host, scheme: "http", client: &http.Client{}}
+	res, err := c.makeRequest(context.Background(), "GET", "_catalog", nil, nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, []string{fmt.Sprintf("GET http://%s/v2/_catalog", u.Host)}, logger.messages)
+}
+
+// recordingMetrics is a types.Metrics recording the reported registry statistics.
+type recordingMetrics struct {
+	mutex    sync.Mutex
+	requests []string
+	retries  []string
+}
+
+func (m *recordingMetrics) RegistryRequest(registry, method string, statusCode int, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requests = append(m.requests, fmt.Sprintf("%s %s %d", registry, method, statusCode))
+}
+
+func (m *recordingMetrics) RegistryRetry(registry string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.retries = append(m.retries, registry)
+}
+
+func (m *recordingMetrics) BlobCopied(blobDigest digest.Digest, downloaded, uploaded int64) {}
+func (m *recordingMetrics) BlobReused(blobDigest digest.Digest)                             {}
+
+func TestDockerClientMetrics(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	m := &recordingMetrics{}
+	c := &dockerClient{sys: &types.SystemContext{Metrics: m}, registry: u.Host, scheme: "http", client: &http.Client{}}
+	res, err := c.makeRequest(context.Background(), "GET", "_catalog", nil, nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, []string{u.Host + " GET 429", u.Host + " GET 200"}, m.requests)
+	assert.Equal(t, []string{u.Host}, m.retries)
+}
+
+func TestDockerClientPingSupportsSignatures(t *testing.T) {
+	for _, c := range []struct {
+		header   string
+		expected bool
 	}{
-		{
-			filepath.Join(configDir, "config.json"),
-			makeTestAuthConfig(testAuthConfigDataMap{
-				"https://index.docker.io/v1/": testAuthConfigData{"alice", "pass"},
-			}),
-		},
-		{
-			filepath.Join(tmpDir, ".dockercfg"),
-			makeTestAuthConfig(testAuthConfigDataMap{
-				"https://index.docker.io/v1/": testAuthConfigData{"bob", "pw"},
-			}).Auths,
-		},
+		{"1", true},
+		{"0", false},
+		{"", false},
 	} {
-		contents, err := json.MarshalIndent(&data.ac, "", "  ")
-		if err != nil {
-			t.Fatalf("failed to marshal authConfig: %v", err)
-		}
-		if err := ioutil.WriteFile(data.path, contents, 0640); err != nil {
-			t.Fatalf("failed to write file %q: %v", data.path, err)
-		}
-	}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.header != "" {
+				w.Header().Set("X-Registry-Supports-Signatures", c.header)
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
 
-	username, password, err := getAuth(nil, "index.docker.io")
-	if err != nil {
-		t.Fatalf("got unexpected error: %#+v", err)
-	}
+		client := &dockerClient{registry: u.Host, insecure: true, client: &http.Client{}}
+		err = client.detectProperties(context.Background())
+		require.NoError(t, err, c.header)
+		assert.Equal(t, "http", client.scheme, c.header)
+		assert.Equal(t, c.expected, client.supportsSignatures, c.header)
+		server.Close()
 
-	if username != "alice" {
-		t.Fatalf("got unexpected user name: %q != %q", username, "alice")
-	}
-	if password != "pass" {
-		t.Fatalf("got unexpected user name: %q != %q", password, "pass")
+		httpFallbackRegistries.Lock()
+		delete(httpFallbackRegistries.registries, u.Host)
+		httpFallbackRegistries.Unlock()
 	}
 }
 
-func TestGetAuthFailsOnBadInput(t *testing.T) {
-	origHomeDir := homedir.Get()
-	tmpDir, err := ioutil.TempDir("", "test_docker_client_get_auth")
-	if err != nil {
-		t.Fatal(err)
+func TestDockerProxy(t *testing.T) {
+	proxyURL, err := url.Parse("http://proxy.example.com:3128")
+	require.NoError(t, err)
+	req, err := http.NewRequest("GET", "https://registry.example.com/v2/", nil)
+	require.NoError(t, err)
+
+	// The explicit proxy is used, even if DockerDisableProxy is set.
+	for _, ctx := range []*types.SystemContext{
+		{DockerProxyURL: proxyURL},
+		{DockerProxyURL: proxyURL, DockerDisableProxy: true},
+	} {
+		res, err := dockerProxy(ctx)(req)
+		require.NoError(t, err)
+		assert.Equal(t, proxyURL, res)
 	}
-	t.Logf("using temporary home directory: %q", tmpDir)
-	// override homedir
-	os.Setenv(homedir.Key(), tmpDir)
-	defer func() {
-		err := os.RemoveAll(tmpDir)
-		if err != nil {
-			t.Logf("failed to cleanup temporary home directory %q: %v", tmpDir, err)
+
+	assert.Nil(t, dockerProxy(&types.SystemContext{DockerDisableProxy: true}))
+	assert.NotNil(t, dockerProxy(&types.SystemContext{}))
+	assert.NotNil(t, dockerProxy(nil))
+}
+
+func TestDockerClientBearerTokenCache(t *testing.T) {
+	tokenRequests := []string{}
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := r.URL.Query().Get("scope")
+		tokenRequests = append(tokenRequests, scope)
+		fmt.Fprintf(w, `{"token":"%s-%d","expires_in":300}`, scope, len(tokenRequests))
+	}))
+	defer tokenServer.Close()
+	challenge := fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, tokenServer.URL)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := "repository:busybox:pull"
+		if r.Method == "DELETE" {
+			scope = "repository:busybox:*"
 		}
-		os.Setenv(homedir.Key(), origHomeDir)
-	}()
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "+scope+"-") {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s,scope="%s"`, challenge, scope))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
 
-	configDir := filepath.Join(tmpDir, ".docker")
-	if err := os.Mkdir(configDir, 0750); err != nil {
-		t.Fatal(err)
+	c := &dockerClient{registry: u.Host, scheme: "http", wwwAuthenticate: challenge, client: &http.Client{}, scope: "repository:busybox:pull"}
+	request := func(method string) {
+		res, err := c.makeRequest(context.Background(), method, "busybox/manifests/latest", nil, nil)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
 	}
-	configPath := filepath.Join(configDir, "config.json")
 
-	// no config file present
-	username, password, err := getAuth(nil, "index.docker.io")
-	if err != nil {
-		t.Fatalf("got unexpected error: %#+v", err)
-	}
-	if len(username) > 0 || len(password) > 0 {
-		t.Fatalf("got unexpected not empty username/password: %q/%q", username, password)
-	}
+	// The token is obtained once and reused.
+	request("GET")
+	request("GET")
+	assert.Equal(t, []string{"repository:busybox:pull"}, tokenRequests)
 
-	if err := ioutil.WriteFile(configPath, []byte("Json rocks! Unless it doesn't."), 0640); err != nil {
-		t.Fatalf("failed to write file %q: %v", configPath, err)
-	}
-	username, password, err = getAuth(nil, "index.docker.io")
-	if err == nil {
-		t.Fatalf("got unexpected non-error: username=%q, password=%q", username, password)
-	}
-	if _, ok := err.(*json.SyntaxError); !ok {
-		t.Fatalf("expected os.PathError, not: %#+v", err)
-	}
+	// A token about to expire is refreshed.
+	key := bearerTokenCacheKey(challengeFromParams(tokenServer.URL+"/token", "registry", "repository:busybox:pull"))
+	token := c.tokenCache[key]
+	token.expirationTime = time.Now().Add(bearerTokenRefreshMargin / 2)
+	c.tokenCache[key] = token
+	request("GET")
+	assert.Equal(t, []string{"repository:busybox:pull", "repository:busybox:pull"}, tokenRequests)
 
-	// remove the invalid config file
-	os.RemoveAll(configPath)
-	// no config file present
-	username, password, err = getAuth(nil, "index.docker.io")
-	if err != nil {
-		t.Fatalf("got unexpected error: %#+v", err)
-	}
-	if len(username) > 0 || len(password) > 0 {
-		t.Fatalf("got unexpected not empty username/password: %q/%q", username, password)
-	}
+	// A token for a scope requested by the registry is obtained, cached, and used for a retry.
+	request("DELETE")
+	request("DELETE")
+	assert.Equal(t, []string{"repository:busybox:pull", "repository:busybox:pull", "repository:busybox:*"}, tokenRequests)
+	request("GET")
+	assert.Len(t, tokenRequests, 3)
 
-	configPath = filepath.Join(tmpDir, ".dockercfg")
-	if err := ioutil.WriteFile(configPath, []byte("I'm certainly not a json string."), 0640); err != nil {
-		t.Fatalf("failed to write file %q: %v", configPath, err)
-	}
-	username, password, err = getAuth(nil, "index.docker.io")
-	if err == nil {
-		t.Fatalf("got unexpected non-error: username=%q, password=%q", username, password)
-	}
-	if _, ok := err.(*json.SyntaxError); !ok {
-		t.Fatalf("expected os.PathError, not: %#+v", err)
-	}
+	// A rejected token is replaced.
+	token = c.tokenCache[key]
+	token.Token = "revoked"
+	c.tokenCache[key] = token
+	request("GET")
+	assert.Len(t, tokenRequests, 4)
 }
 
-type testAuthConfigData struct {
-	username string
-	password string
+// challengeFromParams returns a bearer challenge with the specified parameters.
+func challengeFromParams(realm, service, scope string) challenge {
+	return challenge{Scheme: "bearer", Parameters: map[string]string{"realm": realm, "service": service, "scope": scope}}
 }
 
-type testAuthConfigDataMap map[string]testAuthConfigData
+func TestNewBearerTokenFromJSONBlob(t *testing.T) {
+	issuedAt := time.Date(2016, 11, 1, 10, 0, 0, 0, time.UTC)
+	token, err := newBearerTokenFromJSONBlob([]byte(`{"token":"t","expires_in":300,"issued_at":"2016-11-01T10:00:00Z"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "t", token.Token)
+	assert.Equal(t, issuedAt.Add(300*time.Second), token.expirationTime)
 
-type testAuthConfigEntry struct {
-	Auth string `json:"auth,omitempty"`
+	// access_token is accepted, and a missing or too short lifetime is extended to the minimum.
+	for _, blob := range []string{
+		`{"access_token":"t","issued_at":"2016-11-01T10:00:00Z"}`,
+		`{"access_token":"t","expires_in":10,"issued_at":"2016-11-01T10:00:00Z"}`,
+	} {
+		token, err := newBearerTokenFromJSONBlob([]byte(blob))
+		require.NoError(t, err, blob)
+		assert.Equal(t, "t", token.Token, blob)
+		assert.Equal(t, issuedAt.Add(minimumTokenLifetimeSeconds*time.Second), token.expirationTime, blob)
+	}
+
+	// A missing issued_at means the token has been issued now.
+	before := time.Now()
+	token, err = newBearerTokenFromJSONBlob([]byte(`{"token":"t","expires_in":300}`))
+	require.NoError(t, err)
+	assert.False(t, token.expirationTime.Before(before.Add(300*time.Second)))
+
+	_, err = newBearerTokenFromJSONBlob([]byte("invalid"))
+	assert.Error(t, err)
 }
 
-type testAuthConfig struct {
-	Auths map[string]testAuthConfigEntry `json:"auths"`
+func TestDockerClientIdentityToken(t *testing.T) {
+	refreshTokens := []string{}
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.FormValue("grant_type") != "refresh_token" || r.FormValue("client_id") != oauth2ClientID ||
+			r.FormValue("service") != "registry" || r.FormValue("scope") != "repository:busybox:pull" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		refreshTokens = append(refreshTokens, r.FormValue("refresh_token"))
+		fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":"rotated-%d"}`, len(refreshTokens), len(refreshTokens))
+	}))
+	defer tokenServer.Close()
+
+	c := &dockerClient{identityToken: "identity"}
+	token, err := c.getNewBearerToken(context.Background(), tokenServer.URL, "registry", "repository:busybox:pull")
+	require.NoError(t, err)
+	assert.Equal(t, "access-1", token.Token)
+	token, err = c.getNewBearerToken(context.Background(), tokenServer.URL, "registry", "repository:busybox:pull")
+	require.NoError(t, err)
+	assert.Equal(t, "access-2", token.Token)
+	assert.Equal(t, []string{"identity", "rotated-1"}, refreshTokens)
+
+	_, err = c.getNewBearerToken(context.Background(), tokenServer.URL, "registry", "repository:other:pull")
+	assert.Error(t, err)
 }
 
-// encodeAuth creates an auth value from given authConfig data to be stored in auth config file.
-// Inspired by github.com/docker/docker/cliconfig/config.go v1.10.3.
-func encodeAuth(authConfig *testAuthConfigData) string {
-	authStr := authConfig.username + ":" + authConfig.password
-	msg := []byte(authStr)
-	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(msg)))
-	base64.StdEncoding.Encode(encoded, msg)
-	return string(encoded)
+func TestGetNewBearerTokenAnonymousFallback(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			w.WriteHeader(http.StatusUnauthorized) // Stale credentials
+			return
+		}
+		if strings.Contains(r.URL.Query().Get("scope"), "push") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"anonymous"}`)
+	}))
+	defer tokenServer.Close()
+
+	c := &dockerClient{username: "user", password: "stale"}
+	token, err := c.getNewBearerToken(context.Background(), tokenServer.URL, "registry", "repository:busybox:pull")
+	require.NoError(t, err)
+	assert.Equal(t, "anonymous", token.Token)
+
+	_, err = c.getNewBearerToken(context.Background(), tokenServer.URL, "registry", "repository:busybox:pull,push")
+	assert.Equal(t, errBearerTokenUnauthorized, err)
 }
 
-func makeTestAuthConfig(authConfigData map[string]testAuthConfigData) testAuthConfig {
-	ac := testAuthConfig{
-		Auths: make(map[string]testAuthConfigEntry),
+func TestIsPullOnlyScope(t *testing.T) {
+	for _, c := range []struct {
+		scope    string
+		expected bool
+	}{
+		{"", false},
+		{"repository:busybox:pull", true},
+		{"repository:busybox:pull repository:library/alpine:pull", true},
+		{"repository:busybox:pull,push", false},
+		{"repository:busybox:pull repository:library/alpine:*", false},
+		{"registry:catalog:*", false},
+		{"invalid", false},
+	} {
+		assert.Equal(t, c.expected, isPullOnlyScope(c.scope), c.scope)
 	}
-	for host, data := range authConfigData {
-		ac.Auths[host] = testAuthConfigEntry{
-			Auth: encodeAuth(&data),
+}
+
+func TestDockerClientCredentialsCallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != fmt.Sprintf("pass-%s", r.URL.Query().Get("n")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
 		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	calls := []string{}
+	sys := &types.SystemContext{
+		DockerCredentialsCallback: func(registry, repository string) (string, string, error) {
+			calls = append(calls, registry+"/"+repository)
+			return "user", fmt.Sprintf("pass-%d", len(calls)), nil
+		},
 	}
-	return ac
+	c := &dockerClient{sys: sys, registry: u.Host, authHostname: "docker.io", repository: "library/busybox",
+		scheme: "http", wwwAuthenticate: `Basic realm="registry"`, client: &http.Client{}}
+	for _, n := range []string{"1", "2"} {
+		res, err := c.makeRequest(context.Background(), "GET", "_catalog?n="+n, nil, nil)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	assert.Equal(t, []string{"docker.io/library/busybox", "docker.io/library/busybox"}, calls)
+
+	sys.DockerCredentialsCallback = func(registry, repository string) (string, string, error) {
+		return "", "", fmt.Errorf("no credentials available")
+	}
+	_, err = c.makeRequest(context.Background(), "GET", "_catalog", nil, nil)
+	assert.Error(t, err)
+
+	// Identity tokens are recognized.
+	sys.DockerCredentialsCallback = func(registry, repository string) (string, string, error) {
+		return config.IdentityTokenUsername, "identity", nil
+	}
+	err = c.updateCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "", c.username)
+	assert.Equal(t, "identity", c.identityToken)
+}
+
+func TestDockerClientUserAgent(t *testing.T) {
+	userAgents := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		sys      *types.SystemContext
+		expected string
+	}{
+		{nil, defaultUserAgent},
+		{&types.SystemContext{}, defaultUserAgent},
+		{&types.SystemContext{DockerRegistryUserAgent: "my-tool/1.0"}, "my-tool/1.0"},
+	} {
+		userAgents = []string{}
+		client := &dockerClient{sys: c.sys, registry: u.Host, scheme: "http", client: &http.Client{}}
+		res, err := client.makeRequest(context.Background(), "GET", "busybox/manifests/latest", map[string][]string{"User-Agent": {"ignored"}}, nil)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, []string{c.expected}, userAgents)
+	}
+	assert.Contains(t, defaultUserAgent, version.Version)
+}
+
+func TestDockerClientAddScope(t *testing.T) {
+	c := &dockerClient{scope: "repository:busybox:pull,push"}
+	c.addScope("repository:library/other:pull")
+	assert.Equal(t, "repository:busybox:pull,push repository:library/other:pull", c.scope)
+	c.addScope("repository:library/other:pull") // Already included
+	assert.Equal(t, "repository:busybox:pull,push repository:library/other:pull", c.scope)
+	c.addScope("repository:busybox:pull,push")
+	assert.Equal(t, "repository:busybox:pull,push repository:library/other:pull", c.scope)
+
+	c = &dockerClient{}
+	c.addScope("repository:library/other:pull")
+	assert.Equal(t, "repository:library/other:pull", c.scope)
 }
@@ -1,34 +1,42 @@
 package docker
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/iolimits"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 )
 
-// Image is a Docker-specific implementation of types.Image with a few extra methods
+// Image is a Docker-specific implementation of types.ImageCloser with a few extra methods
 // which are specific to Docker.
 type Image struct {
-	types.Image
+	types.ImageCloser
 	src *dockerImageSource
 }
 
 // newImage returns a new Image interface type after setting up
 // a client to the registry hosting the given image.
 // The caller must call .Close() on the returned Image.
-func newImage(ctx *types.SystemContext, ref dockerReference) (types.Image, error) {
-	s, err := newImageSource(ctx, ref, nil)
+func newImage(ctx context.Context, sys *types.SystemContext, ref dockerReference) (types.ImageCloser, error) {
+	s, err := newImageSource(sys, ref)
 	if err != nil {
 		return nil, err
 	}
-	img, err := image.FromSource(s)
+	img, err := image.FromSource(ctx, sys, s)
 	if err != nil {
 		return nil, err
 	}
-	return &Image{Image: img, src: s}, nil
+	return &Image{ImageCloser: img, src: s}, nil
 }
 
 // SourceRefFullName returns a fully expanded name for the repository this image is in.
@@ -37,23 +45,163 @@ func (i *Image) SourceRefFullName() string {
 }
 
 // GetRepositoryTags list all tags available in the repository. Note that this has no connection with the tag(s) used for this specific image, if any.
-func (i *Image) GetRepositoryTags() ([]string, error) {
-	url := fmt.Sprintf(tagsURL, i.src.ref.ref.RemoteName())
-	res, err := i.src.c.makeRequest("GET", url, nil, nil)
+func (i *Image) GetRepositoryTags(ctx context.Context) ([]string, error) {
+	return i.src.c.getRepositoryTags(ctx, i.src.ref)
+}
+
+// GetRepositoryTags list all tags available in the repository of ref, which must be a docker: reference.
+// Any tag or digest in ref is ignored.
+func GetRepositoryTags(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) ([]string, error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return nil, errors.New("ref must be a dockerReference")
+	}
+	c, err := newDockerClient(sys, dr, false)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Error creating a Docker client: %v", err)
+	}
+	return c.getRepositoryTags(ctx, dr)
+}
+
+// GetDigest returns the digest of the manifest referenced by ref, which must be a docker: reference,
+// without downloading the image; this is useful e.g. to cheaply check whether a tag has been updated.
+// The digest is determined using a HEAD request, falling back to downloading the manifest if the registry
+// does not return a Docker-Content-Digest header.
+func GetDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (digest.Digest, error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return "", errors.New("ref must be a dockerReference")
+	}
+	if digested, ok := dr.ref.(reference.Canonical); ok {
+		return digested.Digest(), nil
+	}
+	c, err := newDockerClient(sys, dr, false)
+	if err != nil {
+		return "", fmt.Errorf("Error creating a Docker client: %v", err)
+	}
+	return c.getDigest(ctx, dr, manifest.DefaultRequestedManifestMIMETypes)
+}
+
+// getDigest returns the digest of the manifest referenced by ref, when requesting manifests with mimeTypes.
+func (c *dockerClient) getDigest(ctx context.Context, ref dockerReference, mimeTypes []string) (digest.Digest, error) {
+	tagOrDigest, err := ref.tagOrDigest()
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf(manifestURL, ref.ref.RemoteName(), tagOrDigest)
+	headers := map[string][]string{
+		"Accept": mimeTypes,
+	}
+	res, err := c.makeRequest(ctx, "HEAD", url, headers, nil)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error reading manifest digest of %s: %w", ref.ref.String(), registryHTTPResponseToError(res, ErrManifestUnknown))
+	}
+	if header := res.Header.Get("Docker-Content-Digest"); header != "" {
+		d, err := digest.Parse(header)
+		if err != nil {
+			return "", fmt.Errorf("Invalid Docker-Content-Digest %q returned for %s: %v", header, ref.ref.String(), err)
+		}
+		return d, nil
+	}
+
+	c.logger().Debugf("No Docker-Content-Digest returned for %s, downloading the manifest", ref.ref.String())
+	res, err = c.makeRequest(ctx, "GET", url, headers, nil)
+	if err != nil {
+		return "", err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		// print url also
-		return nil, fmt.Errorf("Invalid status code returned when fetching tags list %d", res.StatusCode)
+		return "", registryHTTPResponseToError(res, ErrManifestUnknown)
+	}
+	manblob, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestSize(c.sys))
+	if err != nil {
+		return "", fmt.Errorf("Error reading manifest of %s: %w", ref.ref.String(), err)
 	}
-	type tagsRes struct {
-		Tags []string
+	return manifest.Digest(manblob)
+}
+
+// getRepositoryTags lists all tags available in the repository of ref.
+// Registries may return the list in several pages; the next page is linked from the previous one
+// using a RFC 5988 Link header, as described in the Docker Registry HTTP API V2 specification.
+func (c *dockerClient) getRepositoryTags(ctx context.Context, ref dockerReference) ([]string, error) {
+	path := fmt.Sprintf(tagsURL, ref.ref.RemoteName())
+	res, err := c.makeRequest(ctx, "GET", path, nil, nil)
+	tags := []string{}
+	for {
+		if err != nil {
+			return nil, err
+		}
+		pageTags, next, err := c.parseTagsResponse(res)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, pageTags...)
+		if next == nil {
+			return tags, nil
+		}
+		res, err = c.makeRequestToResolvedURL(ctx, "GET", next.String(), nil, nil, -1)
 	}
-	tags := &tagsRes{}
-	if err := json.NewDecoder(res.Body).Decode(tags); err != nil {
-		return nil, err
+}
+
+// parseTagsResponse returns the tags listed in res, and the URL of the next page of results, or nil if this is the last page.
+// It closes res.Body.
+func (c *dockerClient) parseTagsResponse(res *http.Response) ([]string, *url.URL, error) {
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("Error fetching tags list %s: %w", res.Request.URL, registryHTTPResponseToError(res, nil))
+	}
+	tags := struct {
+		Tags []string `json:"tags"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&tags); err != nil {
+		return nil, nil, err
+	}
+	next, err := nextPageURL(res)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tags.Tags, next, nil
+}
+
+// nextPageURL returns the absolute URL of the next page of results linked from res using a Link header with rel="next",
+// or nil if there is no such link.
+func nextPageURL(res *http.Response) (*url.URL, error) {
+	for _, header := range res.Header[http.CanonicalHeaderKey("Link")] {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				return nil, fmt.Errorf("Invalid Link header %q", header)
+			}
+			isNext := false
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "rel" {
+					for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(kv[1]), `"`)) {
+						if strings.ToLower(rel) == "next" {
+							isNext = true
+						}
+					}
+				}
+			}
+			if !isNext {
+				continue
+			}
+			u, err := url.Parse(target[1 : len(target)-1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid Link header %q: %v", header, err)
+			}
+			u = res.Request.URL.ResolveReference(u)
+			// Do not send our credentials to an unexpected server.
+			if u.Scheme != res.Request.URL.Scheme || u.Host != res.Request.URL.Host {
+				return nil, fmt.Errorf("Link header %q refers to a different server", header)
+			}
+			return u, nil
+		}
 	}
-	return tags.Tags, nil
+	return nil, nil
 }
@@ -2,8 +2,10 @@ package docker
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,16 +15,18 @@ import (
 	"path/filepath"
 	"strconv"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/putblobdigest"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 )
 
 type dockerImageDestination struct {
 	ref dockerReference
 	c   *dockerClient
 	// State
-	manifestDigest string // or "" if not yet known.
+	manifestDigest digest.Digest // or "" if not yet known.
 }
 
 // newImageDestination creates a new ImageDestination for the specified image reference.
@@ -58,13 +62,23 @@ func (d *dockerImageDestination) SupportedManifestMIMETypes() []string {
 
 // SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
 // Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
-func (d *dockerImageDestination) SupportsSignatures() error {
-	return fmt.Errorf("Pushing signatures to a Docker Registry is not supported")
+func (d *dockerImageDestination) SupportsSignatures(ctx context.Context) error {
+	if err := d.c.detectProperties(ctx); err != nil {
+		return err
+	}
+	switch {
+	case d.c.signatureBase != nil:
+		return nil
+	case d.c.supportsSignatures:
+		return nil
+	default:
+		return fmt.Errorf("X-Registry-Supports-Signatures extension not supported, and lookaside is not configured")
+	}
 }
 
-// ShouldCompressLayers returns true iff it is desirable to compress layer blobs written to this destination.
-func (d *dockerImageDestination) ShouldCompressLayers() bool {
-	return true
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d *dockerImageDestination) DesiredLayerCompression() types.LayerCompression {
+	return types.Compress
 }
 
 // sizeCounter is an io.Writer which only counts the total size of its input.
@@ -75,69 +89,52 @@ func (c *sizeCounter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *dockerImageDestination) HasThreadSafePutBlob() bool {
+	return true
+}
+
 // PutBlob writes contents of stream and returns data representing the result (with all data filled in).
 // inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
 // inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
 // WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
 // to any other readers for download using the supplied digest.
 // If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
-func (d *dockerImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobInfo) (types.BlobInfo, error) {
-	if inputInfo.Digest != "" {
-		checkURL := fmt.Sprintf(blobsURL, d.ref.ref.RemoteName(), inputInfo.Digest)
-
-		logrus.Debugf("Checking %s", checkURL)
-		res, err := d.c.makeRequest("HEAD", checkURL, nil, nil)
-		if err != nil {
-			return types.BlobInfo{}, err
-		}
-		defer res.Body.Close()
-		switch res.StatusCode {
-		case http.StatusOK:
-			logrus.Debugf("... already exists, not uploading")
-			blobLength, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
-			if err != nil {
-				return types.BlobInfo{}, err
-			}
-			return types.BlobInfo{Digest: inputInfo.Digest, Size: blobLength}, nil
-		case http.StatusUnauthorized:
-			logrus.Debugf("... not authorized")
-			return types.BlobInfo{}, fmt.Errorf("not authorized to read from destination repository %s", d.ref.ref.RemoteName())
-		case http.StatusNotFound:
-			// noop
-		default:
-			return types.BlobInfo{}, fmt.Errorf("failed to read from destination repository %s: %v", d.ref.ref.RemoteName(), http.StatusText(res.StatusCode))
-		}
-		logrus.Debugf("... failed, status %d", res.StatusCode)
-	}
-
+func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (_ types.BlobInfo, retErr error) {
+	ctx, span := d.c.tracer().Start(ctx, "docker.PutBlob", map[string]string{"repository": d.ref.ref.FullName(), "digest": inputInfo.Digest.String()})
+	defer func() { span.End(retErr) }()
 	// FIXME? Chunked upload, progress reporting, etc.
 	uploadURL := fmt.Sprintf(blobUploadURL, d.ref.ref.RemoteName())
-	logrus.Debugf("Uploading %s", uploadURL)
-	res, err := d.c.makeRequest("POST", uploadURL, nil, nil)
+	d.c.logger().Debugf("Uploading %s", uploadURL)
+	res, err := d.c.makeRequest(ctx, "POST", uploadURL, nil, nil)
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusAccepted {
-		logrus.Debugf("Error initiating layer upload, response %#v", *res)
-		return types.BlobInfo{}, fmt.Errorf("Error initiating layer upload to %s, status %d", uploadURL, res.StatusCode)
+		d.c.logger().Debugf("Error initiating layer upload, response %#v", *res)
+		return types.BlobInfo{}, fmt.Errorf("Error initiating layer upload to %s: %w", uploadURL, registryHTTPResponseToError(res, nil))
 	}
 	uploadLocation, err := res.Location()
 	if err != nil {
 		return types.BlobInfo{}, fmt.Errorf("Error determining upload URL: %s", err.Error())
 	}
 
-	h := sha256.New()
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
 	sizeCounter := &sizeCounter{}
-	tee := io.TeeReader(stream, io.MultiWriter(h, sizeCounter))
-	res, err = d.c.makeRequestToResolvedURL("PATCH", uploadLocation.String(), map[string][]string{"Content-Type": {"application/octet-stream"}}, tee, inputInfo.Size)
+	stream = io.TeeReader(stream, sizeCounter)
+	res, err = d.c.makeRequestToResolvedURL(ctx, "PATCH", uploadLocation.String(), map[string][]string{"Content-Type": {"application/octet-stream"}}, stream, inputInfo.Size)
 	if err != nil {
-		logrus.Debugf("Error uploading layer chunked, response %#v", *res)
+		d.c.logger().Debugf("Error uploading layer chunked, response %#v", *res)
 		return types.BlobInfo{}, err
 	}
 	defer res.Body.Close()
-	hash := h.Sum(nil)
-	computedDigest := "sha256:" + hex.EncodeToString(hash[:])
+	computedDigest := digester.Digest()
 
 	uploadLocation, err = res.Location()
 	if err != nil {
@@ -148,79 +145,205 @@ func (d *dockerImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobI
 
 	locationQuery := uploadLocation.Query()
 	// TODO: check inputInfo.Digest == computedDigest https://github.com/containers/image/pull/70#discussion_r77646717
-	locationQuery.Set("digest", computedDigest)
+	locationQuery.Set("digest", computedDigest.String())
 	uploadLocation.RawQuery = locationQuery.Encode()
-	res, err = d.c.makeRequestToResolvedURL("PUT", uploadLocation.String(), map[string][]string{"Content-Type": {"application/octet-stream"}}, nil, -1)
+	res, err = d.c.makeRequestToResolvedURL(ctx, "PUT", uploadLocation.String(), map[string][]string{"Content-Type": {"application/octet-stream"}}, nil, -1)
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusCreated {
-		logrus.Debugf("Error uploading layer, response %#v", *res)
-		return types.BlobInfo{}, fmt.Errorf("Error uploading layer to %s, status %d", uploadLocation, res.StatusCode)
+		d.c.logger().Debugf("Error uploading layer, response %#v", *res)
+		return types.BlobInfo{}, fmt.Errorf("Error uploading layer to %s: %w", uploadLocation, registryHTTPResponseToError(res, nil))
 	}
 
-	logrus.Debugf("Upload of layer %s complete", computedDigest)
+	d.c.logger().Debugf("Upload of layer %s complete", computedDigest)
 	return types.BlobInfo{Digest: computedDigest, Size: sizeCounter.size}, nil
 }
 
-func (d *dockerImageDestination) PutManifest(m []byte) error {
-	digest, err := manifest.Digest(m)
+// blobExists returns true iff the destination repository contains a blob with digest, and if so, also its size.
+// If the repository does not contain the blob, blobExists returns (false, -1, nil);
+// it returns a non-nil error only on an unexpected failure.
+func (d *dockerImageDestination) blobExists(ctx context.Context, digest digest.Digest) (bool, int64, error) {
+	checkURL := fmt.Sprintf(blobsURL, d.ref.ref.RemoteName(), digest)
+	d.c.logger().Debugf("Checking %s", checkURL)
+	res, err := d.c.makeRequest(ctx, "HEAD", checkURL, nil, nil)
+	if err != nil {
+		return false, -1, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		d.c.logger().Debugf("... already exists")
+		blobLength, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return false, -1, err
+		}
+		return true, blobLength, nil
+	case http.StatusUnauthorized:
+		d.c.logger().Debugf("... not authorized")
+		return false, -1, fmt.Errorf("not authorized to read from destination repository %s: %w", d.ref.ref.RemoteName(), registryHTTPResponseToError(res, nil))
+	case http.StatusNotFound:
+		d.c.logger().Debugf("... not present")
+		return false, -1, nil
+	default:
+		return false, -1, fmt.Errorf("failed to read from destination repository %s: %w", d.ref.ref.RemoteName(), registryHTTPResponseToError(res, nil))
+	}
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *dockerImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	if info.Digest == "" {
+		return false, types.BlobInfo{}, errors.New("Can not check for a blob with unknown digest")
+	}
+	scope := bicTransportScope(d.ref)
+	location := types.BICLocationReference{Opaque: d.c.registry}
+
+	// First, check whether the blob happens to already exist at the destination.
+	exists, size, err := d.blobExists(ctx, info.Digest)
+	if err != nil {
+		return false, types.BlobInfo{}, err
+	}
+	if exists {
+		cache.RecordKnownLocation(d.ref.Transport(), scope, info.Digest, location)
+		return true, types.BlobInfo{Digest: info.Digest, Size: size}, nil
+	}
+
+	// Then try the equivalent blobs which are known to have been present in this repository.
+	if canSubstitute {
+		for _, candidate := range cache.CandidateLocations(d.ref.Transport(), scope, info.Digest, true) {
+			if candidate.Digest == info.Digest || candidate.Location != location {
+				continue
+			}
+			exists, size, err := d.blobExists(ctx, candidate.Digest)
+			if err != nil {
+				d.c.logger().Debugf("... Failed: %v", err)
+				continue
+			}
+			if exists {
+				cache.RecordKnownLocation(d.ref.Transport(), scope, candidate.Digest, location)
+				return true, types.BlobInfo{Digest: candidate.Digest, Size: size}, nil
+			}
+		}
+	}
+	return false, types.BlobInfo{}, nil
+}
+
+// PutManifest writes manifest to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for (when the primary manifest is a manifest list);
+// the instance is uploaded by digest, and only the primary manifest is written to the tag in the destination reference.
+func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		if err := instanceDigest.Validate(); err != nil {
+			return fmt.Errorf("Invalid instance digest %q: %v", *instanceDigest, err)
+		}
+		_, err := d.uploadManifest(ctx, instanceDigest.String(), m, manifest.GuessMIMEType(m))
+		return err
+	}
+
+	manifestDigest, err := manifest.Digest(m)
 	if err != nil {
 		return err
 	}
-	d.manifestDigest = digest
+	d.manifestDigest = manifestDigest
+
+	// A digested reference refers to a specific manifest; writing any other manifest to it (which would be equivalent to tagging) makes no sense.
+	if digested, ok := d.ref.ref.(reference.Canonical); ok {
+		matches, err := manifest.MatchesDigest(m, digested.Digest())
+		if err != nil {
+			return err
+		}
+		if !matches {
+			return fmt.Errorf("Manifest digest %s does not match the digest %s in destination reference %s", manifestDigest, digested.Digest().String(), d.ref.ref.String())
+		}
+		// Use the digest in the reference, which may use a different algorithm, to refer to the manifest.
+		d.manifestDigest = digested.Digest()
+	}
 
-	reference, err := d.ref.tagOrDigest()
+	tagOrDigest, err := d.ref.tagOrDigest()
 	if err != nil {
 		return err
 	}
-	url := fmt.Sprintf(manifestURL, d.ref.ref.RemoteName(), reference)
+	_, err = d.uploadManifest(ctx, tagOrDigest, m, manifest.GuessMIMEType(m))
+	return err
+}
+
+// uploadManifest uploads m, with mimeType (or "" if unknown), to tagOrDigest in d.ref's repository,
+// and returns the headers of the registry's response.
+func (d *dockerImageDestination) uploadManifest(ctx context.Context, tagOrDigest string, m []byte, mimeType string) (_ http.Header, retErr error) {
+	ctx, span := d.c.tracer().Start(ctx, "docker.PutManifest", map[string]string{"repository": d.ref.ref.FullName(), "manifest": tagOrDigest})
+	defer func() { span.End(retErr) }()
+	url := fmt.Sprintf(manifestURL, d.ref.ref.RemoteName(), tagOrDigest)
 
 	headers := map[string][]string{}
-	mimeType := manifest.GuessMIMEType(m)
 	if mimeType != "" {
 		headers["Content-Type"] = []string{mimeType}
 	}
-	res, err := d.c.makeRequest("PUT", url, headers, bytes.NewReader(m))
+	res, err := d.c.makeRequest(ctx, "PUT", url, headers, bytes.NewReader(m))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusCreated {
-		body, err := ioutil.ReadAll(res.Body)
-		if err == nil {
-			logrus.Debugf("Error body %s", string(body))
+		d.c.logger().Debugf("Error uploading manifest, status %d, %#v", res.StatusCode, res)
+		err := fmt.Errorf("Error uploading manifest to %s: %w", url, registryHTTPResponseToError(res, nil))
+		if isManifestTypeRejectedError(err) {
+			err = types.ManifestTypeRejectedError{Err: err}
 		}
-		logrus.Debugf("Error uploading manifest, status %d, %#v", res.StatusCode, res)
-		return fmt.Errorf("Error uploading manifest to %s, status %d", url, res.StatusCode)
+		return nil, err
 	}
-	return nil
+	return res.Header, nil
 }
 
-func (d *dockerImageDestination) PutSignatures(signatures [][]byte) error {
-	// FIXME? This overwrites files one at a time, definitely not atomic.
-	// A failure when updating signatures with a reordered copy could lose some of them.
-
+// PutSignatures writes signatures for the manifest written by PutManifest,
+// or, if instanceDigest is not nil, for that instance of the manifest list written by PutManifest.
+func (d *dockerImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
 	// Skip dealing with the manifest digest if not necessary.
 	if len(signatures) == 0 {
 		return nil
 	}
-	if d.c.signatureBase == nil {
-		return fmt.Errorf("Pushing signatures to a Docker Registry is not supported, and there is no applicable signature storage configured")
+	manifestDigest := d.manifestDigest
+	if instanceDigest != nil {
+		if err := instanceDigest.Validate(); err != nil {
+			return fmt.Errorf("Invalid instance digest %q: %v", *instanceDigest, err)
+		}
+		manifestDigest = *instanceDigest
 	}
-
 	// FIXME: This assumption that signatures are stored after the manifest rather breaks the model.
-	if d.manifestDigest == "" {
+	if manifestDigest == "" {
 		return fmt.Errorf("Unknown manifest digest, can't add signatures")
 	}
+	if err := d.c.detectProperties(ctx); err != nil {
+		return err
+	}
+	switch {
+	case d.c.signatureBase != nil:
+		return d.putSignaturesToLookaside(ctx, signatures, manifestDigest)
+	case d.c.supportsSignatures:
+		return d.putSignaturesToAPIExtension(ctx, signatures, manifestDigest)
+	default:
+		return fmt.Errorf("X-Registry-Supports-Signatures extension not supported, and lookaside is not configured")
+	}
+}
+
+// putSignaturesToLookaside implements PutSignatures() to the lookaside location configured in d.c.signatureBase,
+// which is not nil, for the manifest with manifestDigest.
+func (d *dockerImageDestination) putSignaturesToLookaside(ctx context.Context, signatures [][]byte, manifestDigest digest.Digest) error {
+	// FIXME? This overwrites files one at a time, definitely not atomic.
+	// A failure when updating signatures with a reordered copy could lose some of them.
 
 	for i, signature := range signatures {
-		url := signatureStorageURL(d.c.signatureBase, d.manifestDigest, i)
+		url := signatureStorageURL(d.c.signatureBase, manifestDigest, i)
 		if url == nil {
 			return fmt.Errorf("Internal error: signatureStorageURL with non-nil base returned nil")
 		}
-		err := d.putOneSignature(url, signature)
+		err := d.putOneSignature(ctx, url, signature)
 		if err != nil {
 			return err
 		}
@@ -231,11 +354,11 @@ func (d *dockerImageDestination) PutSignatures(signatures [][]byte) error {
 	// is enough for dockerImageSource to stop looking for other signatures, so that
 	// is sufficient.
 	for i := len(signatures); ; i++ {
-		url := signatureStorageURL(d.c.signatureBase, d.manifestDigest, i)
+		url := signatureStorageURL(d.c.signatureBase, manifestDigest, i)
 		if url == nil {
 			return fmt.Errorf("Internal error: signatureStorageURL with non-nil base returned nil")
 		}
-		missing, err := d.c.deleteOneSignature(url)
+		missing, err := d.c.deleteOneSignature(ctx, url)
 		if err != nil {
 			return err
 		}
@@ -248,10 +371,10 @@ func (d *dockerImageDestination) PutSignatures(signatures [][]byte) error {
 }
 
 // putOneSignature stores one signature to url.
-func (d *dockerImageDestination) putOneSignature(url *url.URL, signature []byte) error {
+func (d *dockerImageDestination) putOneSignature(ctx context.Context, url *url.URL, signature []byte) error {
 	switch url.Scheme {
 	case "file":
-		logrus.Debugf("Writing to %s", url.Path)
+		d.c.logger().Debugf("Writing to %s", url.Path)
 		err := os.MkdirAll(filepath.Dir(url.Path), 0755)
 		if err != nil {
 			return err
@@ -269,12 +392,76 @@ func (d *dockerImageDestination) putOneSignature(url *url.URL, signature []byte)
 	}
 }
 
+// putSignaturesToAPIExtension implements PutSignatures() using the X-Registry-Supports-Signatures API extension,
+// for the manifest with manifestDigest.
+func (d *dockerImageDestination) putSignaturesToAPIExtension(ctx context.Context, signatures [][]byte, manifestDigest digest.Digest) error {
+	// Because image signatures are a shared resource in Atomic Registry, the default upload
+	// always adds signatures.  Eventually we should also allow removing signatures,
+	// but the X-Registry-Supports-Signatures API extension does not support that yet.
+
+	existingSignatures, err := d.c.getExtensionSignatures(ctx, d.ref, manifestDigest)
+	if err != nil {
+		return err
+	}
+	existingSigNames := map[string]struct{}{}
+	for _, sig := range existingSignatures.Signatures {
+		existingSigNames[sig.Name] = struct{}{}
+	}
+
+sigExists:
+	for _, newSig := range signatures {
+		for _, existingSig := range existingSignatures.Signatures {
+			if existingSig.Version == extensionSignatureSchemaVersion && existingSig.Type == extensionSignatureTypeAtomic && bytes.Equal(existingSig.Content, newSig) {
+				continue sigExists
+			}
+		}
+
+		// The API expect us to invent a new unique name. This is racy, but hopefully good enough.
+		var signatureName string
+		for {
+			randBytes := make([]byte, 16)
+			n, err := rand.Read(randBytes)
+			if err != nil || n != 16 {
+				return fmt.Errorf("Error generating random signature len %d: %v", n, err)
+			}
+			signatureName = fmt.Sprintf("%s@%032x", manifestDigest, randBytes)
+			if _, ok := existingSigNames[signatureName]; !ok {
+				break
+			}
+		}
+		sig := extensionSignature{
+			Version: extensionSignatureSchemaVersion,
+			Name:    signatureName,
+			Type:    extensionSignatureTypeAtomic,
+			Content: newSig,
+		}
+		body, err := json.Marshal(sig)
+		if err != nil {
+			return err
+		}
+
+		url := fmt.Sprintf(extensionsSignatureURL, d.c.scheme, d.c.registry, d.ref.ref.RemoteName(), manifestDigest)
+		res, err := d.c.makeRequestToResolvedURL(ctx, "PUT", url, map[string][]string{"Content-Type": {"application/json"}}, bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusCreated {
+			d.c.logger().Debugf("Error uploading signature, status %d, %#v", res.StatusCode, res)
+			return fmt.Errorf("Error uploading signature to %s, status %d", url, res.StatusCode)
+		}
+		existingSigNames[signatureName] = struct{}{}
+	}
+
+	return nil
+}
+
 // deleteOneSignature deletes a signature from url, if it exists.
 // If it successfully determines that the signature does not exist, returns (true, nil)
-func (c *dockerClient) deleteOneSignature(url *url.URL) (missing bool, err error) {
+func (c *dockerClient) deleteOneSignature(ctx context.Context, url *url.URL) (missing bool, err error) {
 	switch url.Scheme {
 	case "file":
-		logrus.Debugf("Deleting %s", url.Path)
+		c.logger().Debugf("Deleting %s", url.Path)
 		err := os.Remove(url.Path)
 		if err != nil && os.IsNotExist(err) {
 			return true, nil
@@ -292,6 +479,6 @@ func (c *dockerClient) deleteOneSignature(url *url.URL) (missing bool, err error
 // WARNING: This does not have any transactional semantics:
 // - Uploaded data MAY be visible to others before Commit() is called
 // - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
-func (d *dockerImageDestination) Commit() error {
+func (d *dockerImageDestination) Commit(ctx context.Context) error {
 	return nil
 }
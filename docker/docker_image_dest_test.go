@@ -0,0 +1,227 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerImageDestinationPutManifestDigested(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+	otherDigest := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	uploaded := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.NotFound(w, r)
+			return
+		}
+		uploaded = append(uploaded, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		ref, uploadedPath string // uploadedPath is "" if PutManifest should fail
+	}{
+		{"//busybox:latest", "/v2/library/busybox/manifests/latest"},
+		{"//busybox@" + manifestDigest.String(), "/v2/library/busybox/manifests/" + manifestDigest.String()},
+		{"//busybox@" + otherDigest, ""},
+	} {
+		uploaded = []string{}
+		dest := &dockerImageDestination{ref: dockerRefFromString(t, c.ref), c: newTestDockerClient(t, server)}
+		err := dest.PutManifest(context.Background(), manifestBody, nil)
+		if c.uploadedPath == "" {
+			assert.Error(t, err, c.ref)
+			assert.Empty(t, uploaded, c.ref)
+		} else {
+			require.NoError(t, err, c.ref)
+			assert.Equal(t, []string{c.uploadedPath}, uploaded, c.ref)
+		}
+	}
+}
+
+func TestDockerImageDestinationPutManifestInstance(t *testing.T) {
+	instanceBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	instanceDigest, err := manifest.Digest(instanceBody)
+	require.NoError(t, err)
+
+	uploaded := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.NotFound(w, r)
+			return
+		}
+		uploaded = append(uploaded, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	// Instances are uploaded by digest, not to the tag, and don't affect the digest used for signatures.
+	dest := &dockerImageDestination{ref: dockerRefFromString(t, "//busybox:latest"), c: newTestDockerClient(t, server)}
+	err = dest.PutManifest(context.Background(), instanceBody, &instanceDigest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/v2/library/busybox/manifests/" + instanceDigest.String()}, uploaded)
+	assert.Equal(t, digest.Digest(""), dest.manifestDigest)
+
+	invalidDigest := digest.Digest("sha256:../../this-is-invalid")
+	err = dest.PutManifest(context.Background(), instanceBody, &invalidDigest)
+	assert.Error(t, err)
+	assert.Len(t, uploaded, 1)
+}
+
+func TestDockerImageDestinationPutManifestRejected(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+
+	for _, c := range []struct {
+		status   int
+		body     string
+		rejected bool
+	}{
+		{http.StatusUnsupportedMediaType, "", true},
+		{http.StatusBadRequest, "", true},
+		{http.StatusBadRequest, `{"errors":[{"code":"MANIFEST_INVALID","message":"manifest invalid"}]}`, true},
+		{http.StatusMethodNotAllowed, `{"errors":[{"code":"UNSUPPORTED","message":"Invalid JSON syntax"}]}`, true},
+		{http.StatusBadRequest, `{"errors":[{"code":"BLOB_UNKNOWN","message":"blob unknown to registry"}]}`, false},
+		{http.StatusUnauthorized, "", false},
+		{http.StatusInternalServerError, "", false},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "PUT" {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(c.status)
+			w.Write([]byte(c.body))
+		}))
+		dest := &dockerImageDestination{ref: dockerRefFromString(t, "//busybox:latest"), c: newTestDockerClient(t, server)}
+		err := dest.PutManifest(context.Background(), manifestBody, nil)
+		require.Error(t, err, "%d %s", c.status, c.body)
+		var rejected types.ManifestTypeRejectedError
+		assert.Equal(t, c.rejected, errors.As(err, &rejected), "%d %s", c.status, c.body)
+		var regErr *RegistryError
+		assert.True(t, errors.As(err, &regErr), "%d %s", c.status, c.body)
+		server.Close()
+	}
+}
+
+func TestDockerImageDestinationTryReusingBlob(t *testing.T) {
+	const (
+		existingDigest   = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+		equivalentDigest = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+		missingDigest    = digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" || r.URL.Path != "/v2/library/busybox/blobs/"+existingDigest.String() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", "42")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	dest := &dockerImageDestination{ref: dockerRefFromString(t, "//busybox:latest"), c: newTestDockerClient(t, server)}
+	scope := bicTransportScope(dest.ref)
+	location := types.BICLocationReference{Opaque: dest.c.registry}
+
+	// A blob which exists is reused, and its location is recorded.
+	cache := blobinfocache.NewMemoryCache()
+	reused, info, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: existingDigest, Size: -1}, cache, false)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, types.BlobInfo{Digest: existingDigest, Size: 42}, info)
+	assert.Equal(t, []types.BICReplacementCandidate{{Digest: existingDigest, Location: location}},
+		cache.CandidateLocations(Transport, scope, existingDigest, false))
+
+	// A missing blob is not reused.
+	reused, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: missingDigest, Size: -1}, cache, true)
+	require.NoError(t, err)
+	assert.False(t, reused)
+
+	// An equivalent blob is only used if substitution is allowed.
+	cache.RecordDigestUncompressedPair(existingDigest, equivalentDigest)
+	reused, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: equivalentDigest, Size: -1}, cache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+	reused, info, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: equivalentDigest, Size: -1}, cache, true)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, types.BlobInfo{Digest: existingDigest, Size: 42}, info)
+
+	// An empty digest is rejected.
+	_, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: "", Size: -1}, cache, false)
+	assert.Error(t, err)
+}
+
+func TestDockerImageDestinationPutSignaturesToAPIExtension(t *testing.T) {
+	manifestDigest := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	signaturesPath := "/extensions/v2/library/busybox/signatures/" + manifestDigest
+
+	stored := []extensionSignature{
+		{Version: extensionSignatureSchemaVersion, Name: manifestDigest + "@existing", Type: extensionSignatureTypeAtomic, Content: []byte("sig1")},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != signaturesPath {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			body, err := json.Marshal(extensionSignatureList{Signatures: stored})
+			require.NoError(t, err)
+			w.Write(body)
+		case "PUT":
+			var sig extensionSignature
+			err := json.NewDecoder(r.Body).Decode(&sig)
+			require.NoError(t, err)
+			stored = append(stored, sig)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := newTestDockerClient(t, server)
+	c.supportsSignatures = true
+	dest := &dockerImageDestination{ref: dockerRefFromString(t, "//busybox:latest"), c: c, manifestDigest: digest.Digest(manifestDigest)}
+	assert.NoError(t, dest.SupportsSignatures(context.Background()))
+	err := dest.PutSignatures(context.Background(), [][]byte{[]byte("sig1"), []byte("sig2")}, nil)
+	require.NoError(t, err)
+	require.Len(t, stored, 2) // "sig1" already exists and is not uploaded again.
+	assert.Equal(t, extensionSignatureSchemaVersion, stored[1].Version)
+	assert.Equal(t, extensionSignatureTypeAtomic, stored[1].Type)
+	assert.Equal(t, []byte("sig2"), stored[1].Content)
+	assert.True(t, strings.HasPrefix(stored[1].Name, manifestDigest+"@"))
+	assert.NotEqual(t, stored[0].Name, stored[1].Name)
+
+	// Signatures of an instance of a manifest list are stored for the instance digest, even if the primary manifest digest is unknown.
+	instanceDigest := digest.Digest(manifestDigest)
+	dest = &dockerImageDestination{ref: dockerRefFromString(t, "//busybox:latest"), c: c}
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig3")}, &instanceDigest)
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	assert.Equal(t, []byte("sig3"), stored[2].Content)
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig4")}, nil)
+	assert.Error(t, err)
+
+	// Without the extension or a lookaside, signatures can't be stored.
+	c = newTestDockerClient(t, server)
+	dest = &dockerImageDestination{ref: dockerRefFromString(t, "//busybox:latest"), c: c, manifestDigest: digest.Digest(manifestDigest)}
+	assert.Error(t, dest.SupportsSignatures(context.Background()))
+	assert.NoError(t, dest.PutSignatures(context.Background(), [][]byte{}, nil))
+	assert.Error(t, dest.PutSignatures(context.Background(), [][]byte{[]byte("sig3")}, nil))
+}
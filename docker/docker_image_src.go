@@ -1,49 +1,96 @@
 package docker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 
-	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/iolimits"
+	"github.com/containers/image/pkg/sysregistries"
 	"github.com/containers/image/types"
-	"github.com/docker/distribution/registry/client"
+	"github.com/opencontainers/go-digest"
 )
 
 type dockerImageSource struct {
 	ref                        dockerReference
 	requestedManifestMIMETypes []string
-	c                          *dockerClient
+	c                          *dockerClient   // The registry specified in ref
+	mirrors                    []*dockerClient // Mirrors of the registry, tried in order before c
 	// State
-	cachedManifest         []byte // nil if not loaded yet
-	cachedManifestMIMEType string // Only valid if cachedManifest != nil
+	cachedManifest         []byte                   // nil if not loaded yet
+	cachedManifestMIMEType string                   // Only valid if cachedManifest != nil
+	blobEndpointsMutex     sync.Mutex               // Protects blobEndpoints, GetBlob may be called concurrently
+	blobEndpoints          map[digest.Digest]string // Blob digest -> the registry host which served it
 }
 
-// newImageSource creates a new ImageSource for the specified image reference,
-// asking the backend to use a manifest from requestedManifestMIMETypes if possible.
-// nil requestedManifestMIMETypes means manifest.DefaultRequestedManifestMIMETypes.
+// newImageSource creates a new ImageSource for the specified image reference.
+// It requests all manifest types understood by this library; conversions are left to the users of the source.
 // The caller must call .Close() on the returned ImageSource.
-func newImageSource(ctx *types.SystemContext, ref dockerReference, requestedManifestMIMETypes []string) (*dockerImageSource, error) {
+func newImageSource(ctx *types.SystemContext, ref dockerReference) (*dockerImageSource, error) {
 	c, err := newDockerClient(ctx, ref, false)
 	if err != nil {
 		return nil, err
 	}
-	if requestedManifestMIMETypes == nil {
-		requestedManifestMIMETypes = manifest.DefaultRequestedManifestMIMETypes
+	mirrors, err := newMirrorClients(ctx, ref)
+	if err != nil {
+		return nil, err
 	}
 	return &dockerImageSource{
-		ref: ref,
-		requestedManifestMIMETypes: requestedManifestMIMETypes,
-		c: c,
+		ref:                        ref,
+		requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+		c:                          c,
+		mirrors:                    mirrors,
+		blobEndpoints:              map[digest.Digest]string{},
 	}, nil
 }
 
+// newMirrorClients returns clients for the mirrors configured for the registry of ref, in order:
+// first the mirrors specified in ctx, then the mirrors configured in registries.conf.
+func newMirrorClients(ctx *types.SystemContext, ref dockerReference) ([]*dockerClient, error) {
+	mirrors := []sysregistries.Endpoint{}
+	if ctx != nil {
+		for _, mirror := range ctx.DockerRegistryMirrors[ref.ref.Hostname()] {
+			mirrors = append(mirrors, sysregistries.Endpoint{Location: mirror})
+		}
+	}
+	config, err := sysregistries.LoadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if reg := config.FindRegistry(ref.ref.FullName()); reg != nil {
+		mirrors = append(mirrors, reg.Mirrors...)
+	}
+
+	clients := []*dockerClient{}
+	for _, mirror := range mirrors {
+		c, err := newDockerClientForEndpoint(ctx, ref, mirror.Location, isInsecure(ctx, config, mirror.Location, mirror.Insecure), false)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// endpoints returns the clients to use for reading manifests and blobs, in order of preference:
+// the configured mirrors, if any, and then the registry specified in the reference.
+func (s *dockerImageSource) endpoints() []*dockerClient {
+	return append(append([]*dockerClient{}, s.mirrors...), s.c)
+}
+
 // Reference returns the reference used to set up this source, _as specified by the user_
 // (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
 func (s *dockerImageSource) Reference() types.ImageReference {
@@ -69,37 +116,72 @@ func simplifyContentType(contentType string) string {
 
 // GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
 // It may use a remote (= slow) service.
-func (s *dockerImageSource) GetManifest() ([]byte, string, error) {
-	err := s.ensureManifestIsLoaded()
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *dockerImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return s.fetchManifestByDigest(ctx, *instanceDigest)
+	}
+	err := s.ensureManifestIsLoaded(ctx)
 	if err != nil {
 		return nil, "", err
 	}
 	return s.cachedManifest, s.cachedManifestMIMEType, nil
 }
 
-func (s *dockerImageSource) fetchManifest(tagOrDigest string) ([]byte, string, error) {
+// fetchManifest returns the manifest for tagOrDigest, trying the mirrors (if any) before the registry specified in the reference.
+func (s *dockerImageSource) fetchManifest(ctx context.Context, tagOrDigest string) (_ []byte, _ string, retErr error) {
+	ctx, span := s.c.tracer().Start(ctx, "docker.GetManifest", map[string]string{"repository": s.ref.ref.FullName(), "manifest": tagOrDigest})
+	defer func() { span.End(retErr) }()
+	var lastErr error
+	for _, c := range s.endpoints() {
+		manblob, mt, err := s.fetchManifestFromEndpoint(ctx, c, tagOrDigest)
+		if err == nil {
+			return manblob, mt, nil
+		}
+		c.logger().Debugf("Error fetching manifest %s from %s: %v", tagOrDigest, c.registry, err)
+		lastErr = err
+	}
+	return nil, "", lastErr
+}
+
+// fetchManifestFromEndpoint returns the manifest for tagOrDigest from the registry accessed using c.
+func (s *dockerImageSource) fetchManifestFromEndpoint(ctx context.Context, c *dockerClient, tagOrDigest string) ([]byte, string, error) {
 	url := fmt.Sprintf(manifestURL, s.ref.ref.RemoteName(), tagOrDigest)
 	headers := make(map[string][]string)
 	headers["Accept"] = s.requestedManifestMIMETypes
-	res, err := s.c.makeRequest("GET", url, headers, nil)
+	res, err := c.makeRequest(ctx, "GET", url, headers, nil)
 	if err != nil {
 		return nil, "", err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, "", client.HandleErrorResponse(res)
+		return nil, "", registryHTTPResponseToError(res, ErrManifestUnknown)
 	}
-	manblob, err := ioutil.ReadAll(res.Body)
+	manblob, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestSize(c.sys))
 	if err != nil {
-		return nil, "", err
+		return nil, "", fmt.Errorf("Error reading manifest %s: %w", tagOrDigest, err)
 	}
 	return manblob, simplifyContentType(res.Header.Get("Content-Type")), nil
 }
 
-// GetTargetManifest returns an image's manifest given a digest.
-// This is mainly used to retrieve a single image's manifest out of a manifest list.
-func (s *dockerImageSource) GetTargetManifest(digest string) ([]byte, string, error) {
-	return s.fetchManifest(digest)
+// fetchManifestByDigest is like fetchManifest, but it also verifies that the returned manifest matches digest.
+func (s *dockerImageSource) fetchManifestByDigest(ctx context.Context, digest digest.Digest) ([]byte, string, error) {
+	if err := digest.Validate(); err != nil {
+		return nil, "", fmt.Errorf("Invalid manifest digest %q: %v", digest, err)
+	}
+	manblob, mt, err := s.fetchManifest(ctx, digest.String())
+	if err != nil {
+		return nil, "", err
+	}
+	matches, err := manifest.MatchesDigest(manblob, digest)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error computing manifest digest: %v", err)
+	}
+	if !matches {
+		return nil, "", fmt.Errorf("Manifest does not match expected digest %s", digest)
+	}
+	return manblob, mt, nil
 }
 
 // ensureManifestIsLoaded sets s.cachedManifest and s.cachedManifestMIMEType
@@ -109,54 +191,376 @@ func (s *dockerImageSource) GetTargetManifest(digest string) ([]byte, string, er
 // we need to ensure that the digest of the manifest returned by GetManifest
 // and used by GetSignatures are consistent, otherwise we would get spurious
 // signature verification failures when pulling while a tag is being updated.
-func (s *dockerImageSource) ensureManifestIsLoaded() error {
+func (s *dockerImageSource) ensureManifestIsLoaded(ctx context.Context) error {
 	if s.cachedManifest != nil {
 		return nil
 	}
 
-	reference, err := s.ref.tagOrDigest()
+	tagOrDigest, err := s.ref.tagOrDigest()
 	if err != nil {
 		return err
 	}
 
-	manblob, mt, err := s.fetchManifest(reference)
+	var manblob []byte
+	var mt string
+	if digested, isDigested := s.ref.ref.(reference.Canonical); isDigested {
+		// Do not trust the registry to return the manifest we asked for; only accept the one matching the digest specified by the user.
+		manblob, mt, err = s.fetchManifestByDigest(ctx, digested.Digest())
+	} else {
+		// We might validate manblob against the Docker-Content-Digest header here to protect against transport errors.
+		manblob, mt, err = s.fetchManifest(ctx, tagOrDigest)
+	}
 	if err != nil {
 		return err
 	}
-	// We might validate manblob against the Docker-Content-Digest header here to protect against transport errors.
 	s.cachedManifest = manblob
 	s.cachedManifestMIMEType = mt
 	return nil
 }
 
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *dockerImageSource) HasThreadSafeGetBlob() bool {
+	return true
+}
+
 // GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
-func (s *dockerImageSource) GetBlob(digest string) (io.ReadCloser, int64, error) {
+// Blobs with info.URLs (e.g. foreign layers) are first fetched from those URLs; the registry endpoints are
+// then tried in order, preferring the endpoints recorded in cache as having served the blob before.
+// The types.Span recorded for the blob only covers locating it and starting the download, not reading the returned stream.
+func (s *dockerImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (_ io.ReadCloser, _ int64, retErr error) {
+	ctx, span := s.c.tracer().Start(ctx, "docker.GetBlob", map[string]string{"repository": s.ref.ref.FullName(), "digest": info.Digest.String()})
+	defer func() { span.End(retErr) }()
+	if len(info.URLs) != 0 {
+		stream, size, err := s.getExternalBlob(ctx, info.URLs)
+		if err == nil {
+			return stream, size, nil
+		}
+		s.c.logger().Debugf("Error fetching blob %s from its URLs, trying the registry: %v", info.Digest, err)
+	}
+
+	var lastErr error
+	for _, c := range s.endpointsForBlob(info.Digest, cache) {
+		stream, size, err := s.getBlobFromEndpoint(ctx, c, info.Digest)
+		if err == nil {
+			s.blobEndpointsMutex.Lock()
+			s.blobEndpoints[info.Digest] = c.registry
+			s.blobEndpointsMutex.Unlock()
+			cache.RecordKnownLocation(s.ref.Transport(), bicTransportScope(s.ref), info.Digest, types.BICLocationReference{Opaque: c.registry})
+			return stream, size, nil
+		}
+		c.logger().Debugf("Error fetching blob %s from %s: %v", info.Digest, c.registry, err)
+		lastErr = err
+	}
+	return nil, 0, lastErr
+}
+
+// bicTransportScope returns a BICTransportScope appropriate for ref.
+func bicTransportScope(ref dockerReference) types.BICTransportScope {
+	// Blobs are recorded per repository; the locations are the registry hosts (including mirrors) which served them.
+	return types.BICTransportScope{Opaque: ref.ref.FullName()}
+}
+
+// endpointsForBlob returns the clients to use for reading the blob with the specified digest, in order of preference:
+// the endpoints recorded in cache as having served the blob, and then the rest of s.endpoints().
+func (s *dockerImageSource) endpointsForBlob(digest digest.Digest, cache types.BlobInfoCache) []*dockerClient {
+	endpoints := s.endpoints()
+	res := []*dockerClient{}
+	used := map[*dockerClient]bool{}
+	for _, candidate := range cache.CandidateLocations(s.ref.Transport(), bicTransportScope(s.ref), digest, false) {
+		for _, c := range endpoints {
+			if c.registry == candidate.Location.Opaque && !used[c] {
+				res = append(res, c)
+				used[c] = true
+			}
+		}
+	}
+	for _, c := range endpoints {
+		if !used[c] {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// getExternalBlob returns a stream for a blob stored outside of the registry, trying urls in order, and the blob’s size (or -1 if unknown).
+func (s *dockerImageSource) getExternalBlob(ctx context.Context, urls []string) (io.ReadCloser, int64, error) {
+	var lastErr error
+	for _, url := range urls {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("User-Agent", userAgent(s.c.sys))
+		s.c.logger().Debugf("Downloading %s", url)
+		res, err := s.c.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			lastErr = fmt.Errorf("Invalid status code returned when fetching blob from %s: %d", url, res.StatusCode)
+			continue
+		}
+		size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			size = -1
+		}
+		return res.Body, size, nil
+	}
+	return nil, 0, lastErr
+}
+
+// getBlobFromEndpoint returns a stream for the specified blob from the registry accessed using c, and the blob’s size (or -1 if unknown).
+// If reading the stream fails mid-way, the download is transparently resumed from the last received offset, if the registry supports it.
+func (s *dockerImageSource) getBlobFromEndpoint(ctx context.Context, c *dockerClient, digest digest.Digest) (io.ReadCloser, int64, error) {
 	url := fmt.Sprintf(blobsURL, s.ref.ref.RemoteName(), digest)
-	logrus.Debugf("Downloading %s", url)
-	res, err := s.c.makeRequest("GET", url, nil, nil)
+	c.logger().Debugf("Downloading %s from %s", url, c.registry)
+	res, err := c.makeRequest(ctx, "GET", url, nil, nil)
 	if err != nil {
 		return nil, 0, err
 	}
 	if res.StatusCode != http.StatusOK {
-		// print url also
-		return nil, 0, fmt.Errorf("Invalid status code returned when fetching blob %d", res.StatusCode)
+		defer res.Body.Close()
+		return nil, 0, registryHTTPResponseToError(res, ErrBlobUnknown)
 	}
 	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
 		size = -1
 	}
-	return res.Body, size, nil
+	return &resumableBlobReader{ctx: ctx, c: c, url: url, digest: digest, body: res.Body}, size, nil
 }
 
-func (s *dockerImageSource) GetSignatures() ([][]byte, error) {
-	if s.c.signatureBase == nil { // Skip dealing with the manifest digest if not necessary.
+// GetBlobAt returns streams for the specified chunks of the blob, using HTTP range requests.
+// The registry endpoint which served the blob before, if any, is tried first.
+// If the registry ignores the range request, GetBlobAt fails with ErrRangeRequestsNotSupported.
+func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo, chunks []types.ImageSourceChunk) (chan io.ReadCloser, chan error, error) {
+	if len(chunks) == 0 {
+		return nil, nil, fmt.Errorf("Internal error: GetBlobAt called without any chunks")
+	}
+	ranges := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		if chunk.Length == 0 {
+			return nil, nil, fmt.Errorf("Invalid empty chunk at offset %d of blob %s", chunk.Offset, info.Digest)
+		}
+		ranges[i] = fmt.Sprintf("%d-%d", chunk.Offset, chunk.Offset+chunk.Length-1)
+	}
+	headers := map[string][]string{"Range": {"bytes=" + strings.Join(ranges, ",")}}
+
+	s.blobEndpointsMutex.Lock()
+	preferredEndpoint := s.blobEndpoints[info.Digest]
+	s.blobEndpointsMutex.Unlock()
+	endpoints := []*dockerClient{}
+	for _, c := range s.endpoints() {
+		if c.registry == preferredEndpoint {
+			endpoints = append([]*dockerClient{c}, endpoints...)
+		} else {
+			endpoints = append(endpoints, c)
+		}
+	}
+
+	url := fmt.Sprintf(blobsURL, s.ref.ref.RemoteName(), info.Digest)
+	var lastErr error
+	for _, c := range endpoints {
+		c.logger().Debugf("Downloading %d chunks of %s from %s", len(chunks), url, c.registry)
+		res, err := c.makeRequest(ctx, "GET", url, headers, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch res.StatusCode {
+		case http.StatusPartialContent:
+			streams := make(chan io.ReadCloser)
+			errs := make(chan error)
+			go sendBlobChunks(ctx, res, chunks, streams, errs)
+			return streams, errs, nil
+		case http.StatusOK:
+			res.Body.Close()
+			return nil, nil, fmt.Errorf("Error reading chunks of blob %s from %s: %w", info.Digest, c.registry, ErrRangeRequestsNotSupported)
+		default:
+			lastErr = registryHTTPResponseToError(res, ErrBlobUnknown)
+			res.Body.Close()
+		}
+		c.logger().Debugf("Error fetching chunks of blob %s from %s: %v", info.Digest, c.registry, lastErr)
+	}
+	return nil, nil, lastErr
+}
+
+// sendBlobChunks sends streams for chunks, read from a 206 Partial Content res, to streams, waiting for each of them to be closed
+// before sending the next one.  Errors are sent to errs.  It closes res.Body, streams and errs when done.
+func sendBlobChunks(ctx context.Context, res *http.Response, chunks []types.ImageSourceChunk, streams chan<- io.ReadCloser, errs chan<- error) {
+	defer close(errs)
+	defer close(streams)
+	defer res.Body.Close()
+
+	// send sends stream to the caller and waits until it is closed; it returns false if ctx was cancelled.
+	send := func(stream io.Reader) bool {
+		closed := make(chan struct{})
+		select {
+		case streams <- &signalCloser{Reader: stream, closed: closed}:
+		case <-ctx.Done():
+			return false
+		}
+		select {
+		case <-closed:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	sendError := func(err error) {
+		select {
+		case errs <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		// A single range; the server may only return it if a single chunk was requested.
+		if err := verifyContentRange(res.Header.Get("Content-Range"), chunks, 0); err != nil {
+			sendError(err)
+			return
+		}
+		if len(chunks) != 1 {
+			sendError(fmt.Errorf("Registry returned a single range, %d were requested", len(chunks)))
+			return
+		}
+		send(res.Body)
+		return
+	}
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	for i := range chunks {
+		part, err := mr.NextPart()
+		if err != nil {
+			sendError(fmt.Errorf("Error reading chunk %d of a multipart response: %v", i, err))
+			return
+		}
+		if err := verifyContentRange(part.Header.Get("Content-Range"), chunks, i); err != nil {
+			sendError(err)
+			return
+		}
+		if !send(part) {
+			return
+		}
+	}
+}
+
+// verifyContentRange returns an error if contentRange (a Content-Range header value) does not describe chunks[i].
+func verifyContentRange(contentRange string, chunks []types.ImageSourceChunk, i int) error {
+	var start, end uint64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/", &start, &end); err != nil {
+		return fmt.Errorf("Invalid Content-Range %q: %v", contentRange, err)
+	}
+	if start != chunks[i].Offset || end != chunks[i].Offset+chunks[i].Length-1 {
+		return fmt.Errorf("Registry returned range %d-%d, expected %d-%d", start, end, chunks[i].Offset, chunks[i].Offset+chunks[i].Length-1)
+	}
+	return nil
+}
+
+// signalCloser is an io.ReadCloser which closes the closed channel when it is closed.
+type signalCloser struct {
+	io.Reader
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *signalCloser) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// GetSignatures returns the image's signatures, from the lookaside signature storage if configured,
+// or using the X-Registry-Supports-Signatures API extension if the registry supports it.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve signatures for
+// (when the primary manifest is a manifest list); this never happens if the primary manifest is not a manifest list.
+func (s *dockerImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	if err := s.c.detectProperties(ctx); err != nil {
+		return nil, err
+	}
+	switch {
+	case s.c.signatureBase != nil:
+		return s.getSignaturesFromLookaside(ctx, instanceDigest)
+	case s.c.supportsSignatures:
+		return s.getSignaturesFromAPIExtension(ctx, instanceDigest)
+	default:
 		return [][]byte{}, nil
 	}
+}
+
+// Compile-time check that dockerImageSource implements types.SignaturesWithFormatSource
+var _ types.SignaturesWithFormatSource = (*dockerImageSource)(nil)
 
-	if err := s.ensureManifestIsLoaded(); err != nil {
+// GetSignaturesWithFormat returns the image's signatures in all supported formats: the simple signing signatures
+// returned by GetSignatures, followed by the sigstore signatures returned by GetSigstoreSignatures.
+func (s *dockerImageSource) GetSignaturesWithFormat(ctx context.Context) ([]types.Signature, error) {
+	simpleSigning, err := s.GetSignatures(ctx, nil)
+	if err != nil {
 		return nil, err
 	}
-	manifestDigest, err := manifest.Digest(s.cachedManifest)
+	sigstore, err := s.GetSigstoreSignatures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return image.SignaturesWithFormat(simpleSigning, sigstore), nil
+}
+
+// Compile-time check that dockerImageSource implements types.ManifestDigestSource
+var _ types.ManifestDigestSource = (*dockerImageSource)(nil)
+
+// GetManifestDigest returns the digest of the image's manifest, using a HEAD request instead of downloading the manifest
+// if the registry supports it.  If the manifest has already been loaded by GetManifest, its digest is returned
+// without contacting the registry.
+func (s *dockerImageSource) GetManifestDigest(ctx context.Context) (digest.Digest, error) {
+	if s.cachedManifest != nil {
+		return manifest.Digest(s.cachedManifest)
+	}
+	var lastErr error
+	for _, c := range s.endpoints() {
+		d, err := c.getDigest(ctx, s.ref, s.requestedManifestMIMETypes)
+		if err == nil {
+			return d, nil
+		}
+		c.logger().Debugf("Error reading manifest digest of %s from %s: %v", s.ref.ref.String(), c.registry, err)
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// Exists returns true if the image's manifest exists, using a HEAD request if the registry supports it.
+// It returns (false, nil) if the manifest does not exist; it returns a non-nil error only on an unexpected failure.
+func (s *dockerImageSource) Exists(ctx context.Context) (bool, error) {
+	_, err := s.GetManifestDigest(ctx)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrManifestUnknown):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// manifestDigest returns the digest of the image's manifest, or instanceDigest if it is not nil.
+func (s *dockerImageSource) manifestDigest(ctx context.Context, instanceDigest *digest.Digest) (digest.Digest, error) {
+	if instanceDigest != nil {
+		if err := instanceDigest.Validate(); err != nil {
+			return "", fmt.Errorf("Invalid instance digest %q: %v", *instanceDigest, err)
+		}
+		return *instanceDigest, nil
+	}
+	if err := s.ensureManifestIsLoaded(ctx); err != nil {
+		return "", err
+	}
+	return manifest.Digest(s.cachedManifest)
+}
+
+// getSignaturesFromLookaside implements GetSignatures() from the lookaside location configured in s.c.signatureBase,
+// which is not nil.
+func (s *dockerImageSource) getSignaturesFromLookaside(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	manifestDigest, err := s.manifestDigest(ctx, instanceDigest)
 	if err != nil {
 		return nil, err
 	}
@@ -167,7 +571,7 @@ func (s *dockerImageSource) GetSignatures() ([][]byte, error) {
 		if url == nil {
 			return nil, fmt.Errorf("Internal error: signatureStorageURL with non-nil base returned nil")
 		}
-		signature, missing, err := s.getOneSignature(url)
+		signature, missing, err := s.getOneSignature(ctx, url)
 		if err != nil {
 			return nil, err
 		}
@@ -181,10 +585,10 @@ func (s *dockerImageSource) GetSignatures() ([][]byte, error) {
 
 // getOneSignature downloads one signature from url.
 // If it successfully determines that the signature does not exist, returns with missing set to true and error set to nil.
-func (s *dockerImageSource) getOneSignature(url *url.URL) (signature []byte, missing bool, err error) {
+func (s *dockerImageSource) getOneSignature(ctx context.Context, url *url.URL) (signature []byte, missing bool, err error) {
 	switch url.Scheme {
 	case "file":
-		logrus.Debugf("Reading %s", url.Path)
+		s.c.logger().Debugf("Reading %s", url.Path)
 		sig, err := ioutil.ReadFile(url.Path)
 		if err != nil {
 			if os.IsNotExist(err) {
@@ -195,7 +599,7 @@ func (s *dockerImageSource) getOneSignature(url *url.URL) (signature []byte, mis
 		return sig, false, nil
 
 	case "http", "https":
-		logrus.Debugf("GET %s", url)
+		s.c.logger().Debugf("GET %s", url)
 		res, err := s.c.client.Get(url.String())
 		if err != nil {
 			return nil, false, err
@@ -217,46 +621,82 @@ func (s *dockerImageSource) getOneSignature(url *url.URL) (signature []byte, mis
 	}
 }
 
+// getSignaturesFromAPIExtension implements GetSignatures() using the X-Registry-Supports-Signatures API extension.
+func (s *dockerImageSource) getSignaturesFromAPIExtension(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	manifestDigest, err := s.manifestDigest(ctx, instanceDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedBody, err := s.c.getExtensionSignatures(ctx, s.ref, manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	sigs := [][]byte{}
+	for _, sig := range parsedBody.Signatures {
+		if sig.Version == extensionSignatureSchemaVersion && sig.Type == extensionSignatureTypeAtomic {
+			sigs = append(sigs, sig.Content)
+		}
+	}
+	return sigs, nil
+}
+
 // deleteImage deletes the named image from the registry, if supported.
-func deleteImage(ctx *types.SystemContext, ref dockerReference) error {
-	c, err := newDockerClient(ctx, ref, true)
+func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerReference) error {
+	c, err := newDockerClient(sys, ref, true)
 	if err != nil {
 		return err
 	}
+	return c.deleteImage(ctx, ref)
+}
 
-	// When retrieving the digest from a registry >= 2.3 use the following header:
-	//   "Accept": "application/vnd.docker.distribution.manifest.v2+json"
-	headers := make(map[string][]string)
-	headers["Accept"] = []string{manifest.DockerV2Schema2MediaType}
+// deleteImage deletes the manifest referenced by ref from the registry, along with its signatures.
+// A tag is first resolved to a digest, because the registry API only allows deleting manifests by digest;
+// note that this removes all tags referring to the same manifest.
+func (c *dockerClient) deleteImage(ctx context.Context, ref dockerReference) error {
+	headers := map[string][]string{
+		"Accept": manifest.DefaultRequestedManifestMIMETypes,
+	}
 
-	reference, err := ref.tagOrDigest()
+	tagOrDigest, err := ref.tagOrDigest()
 	if err != nil {
 		return err
 	}
-	getURL := fmt.Sprintf(manifestURL, ref.ref.RemoteName(), reference)
-	get, err := c.makeRequest("GET", getURL, headers, nil)
+	getURL := fmt.Sprintf(manifestURL, ref.ref.RemoteName(), tagOrDigest)
+	get, err := c.makeRequest(ctx, "GET", getURL, headers, nil)
 	if err != nil {
 		return err
 	}
 	defer get.Body.Close()
-	manifestBody, err := ioutil.ReadAll(get.Body)
+	manifestBody, err := iolimits.ReadAtMost(get.Body, iolimits.MaxManifestSize(c.sys))
 	if err != nil {
-		return err
+		return fmt.Errorf("Error reading manifest %s: %w", tagOrDigest, err)
 	}
 	switch get.StatusCode {
 	case http.StatusOK:
 	case http.StatusNotFound:
-		return fmt.Errorf("Unable to delete %v. Image may not exist or is not stored with a v2 Schema in a v2 registry.", ref.ref)
+		return ImageNotFoundError{Ref: ref.ref.String()}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return UnauthorizedError{Ref: ref.ref.String()}
 	default:
 		return fmt.Errorf("Failed to delete %v: %s (%v)", ref.ref, manifestBody, get.Status)
 	}
 
-	digest := get.Header.Get("Docker-Content-Digest")
-	deleteURL := fmt.Sprintf(manifestURL, ref.ref.RemoteName(), digest)
-
-	// When retrieving the digest from a registry >= 2.3 use the following header:
-	//   "Accept": "application/vnd.docker.distribution.manifest.v2+json"
-	delete, err := c.makeRequest("DELETE", deleteURL, headers, nil)
+	var manifestDigest digest.Digest
+	if header := get.Header.Get("Docker-Content-Digest"); header != "" {
+		manifestDigest, err = digest.Parse(header)
+		if err != nil {
+			return fmt.Errorf("Invalid Docker-Content-Digest %q returned for %s: %v", header, ref.ref.String(), err)
+		}
+	} else {
+		manifestDigest, err = manifest.Digest(manifestBody)
+		if err != nil {
+			return err
+		}
+	}
+	deleteURL := fmt.Sprintf(manifestURL, ref.ref.RemoteName(), manifestDigest)
+	delete, err := c.makeRequest(ctx, "DELETE", deleteURL, headers, nil)
 	if err != nil {
 		return err
 	}
@@ -266,7 +706,16 @@ func deleteImage(ctx *types.SystemContext, ref dockerReference) error {
 	if err != nil {
 		return err
 	}
-	if delete.StatusCode != http.StatusAccepted {
+	switch delete.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+	case http.StatusNotFound:
+		return ImageNotFoundError{Ref: ref.ref.String()}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return UnauthorizedError{Ref: ref.ref.String()}
+	case http.StatusMethodNotAllowed:
+		// The Docker registry responds with 405 and an UNSUPPORTED error if deletion is disabled.
+		return DeletionDisabledError{Ref: ref.ref.String()}
+	default:
 		return fmt.Errorf("Failed to delete %v: %s (%v)", deleteURL, string(body), delete.Status)
 	}
 
@@ -281,7 +730,7 @@ func deleteImage(ctx *types.SystemContext, ref dockerReference) error {
 			if url == nil {
 				return fmt.Errorf("Internal error: signatureStorageURL with non-nil base returned nil")
 			}
-			missing, err := c.deleteOneSignature(url)
+			missing, err := c.deleteOneSignature(ctx, url)
 			if err != nil {
 				return err
 			}
@@ -1,9 +1,23 @@
 package docker
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/iolimits"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSimplifyContentType(t *testing.T) {
@@ -22,3 +36,510 @@ func TestSimplifyContentType(t *testing.T) {
 		assert.Equal(t, c.expected, out, c.input)
 	}
 }
+
+func TestDockerClientDeleteImage(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		getStatus, deleteStatus int
+		sendDigest              bool
+		check                   func(error) bool
+	}{
+		{http.StatusOK, http.StatusAccepted, true, func(err error) bool { return err == nil }},
+		{http.StatusOK, http.StatusAccepted, false, func(err error) bool { return err == nil }},
+		{http.StatusNotFound, http.StatusAccepted, true, func(err error) bool { _, ok := err.(ImageNotFoundError); return ok }},
+		{http.StatusUnauthorized, http.StatusAccepted, true, func(err error) bool { _, ok := err.(UnauthorizedError); return ok }},
+		{http.StatusOK, http.StatusMethodNotAllowed, true, func(err error) bool { _, ok := err.(DeletionDisabledError); return ok }},
+		{http.StatusOK, http.StatusNotFound, true, func(err error) bool { _, ok := err.(ImageNotFoundError); return ok }},
+		{http.StatusOK, http.StatusInternalServerError, true, func(err error) bool { return err != nil }},
+	} {
+		deleted := ""
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/v2/library/busybox/manifests/latest":
+				if c.sendDigest {
+					w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+				}
+				w.WriteHeader(c.getStatus)
+				w.Write(manifestBody)
+			case r.Method == "DELETE" && r.URL.Path == "/v2/library/busybox/manifests/"+manifestDigest.String():
+				deleted = manifestDigest.String()
+				w.WriteHeader(c.deleteStatus)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		err := newTestDockerClient(t, server).deleteImage(context.Background(), dockerRefFromString(t, "//busybox"))
+		server.Close()
+		assert.True(t, c.check(err), "%#v: %v", c, err)
+		if c.getStatus == http.StatusOK {
+			assert.Equal(t, manifestDigest.String(), deleted)
+		}
+	}
+}
+
+func TestDockerImageSourceGetManifestByDigest(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+	otherBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","config":{}}`)
+	otherDigest, err := manifest.Digest(otherBody)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/busybox/manifests/" + manifestDigest.String(), "/v2/library/busybox/manifests/latest":
+			w.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			w.Write(manifestBody)
+		case "/v2/library/busybox/manifests/" + otherDigest.String(): // A misbehaving registry
+			w.Write(manifestBody)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	newSource := func(ref string) *dockerImageSource {
+		return &dockerImageSource{
+			ref:                        dockerRefFromString(t, ref),
+			requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+			c:                          newTestDockerClient(t, server),
+		}
+	}
+
+	for _, ref := range []string{"//busybox@" + manifestDigest.String(), "//busybox:latest"} {
+		m, mt, err := newSource(ref).GetManifest(context.Background(), nil)
+		require.NoError(t, err, ref)
+		assert.Equal(t, manifestBody, m, ref)
+		assert.Equal(t, manifest.DockerV2Schema2MediaType, mt, ref)
+	}
+	_, _, err = newSource("//busybox@"+otherDigest.String()).GetManifest(context.Background(), nil)
+	assert.Error(t, err)
+
+	src := newSource("//busybox:latest")
+	m, _, err := src.GetManifest(context.Background(), &manifestDigest)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBody, m)
+	_, _, err = src.GetManifest(context.Background(), &otherDigest)
+	assert.Error(t, err)
+
+	// Manifests larger than the configured limit are rejected.
+	src = newSource("//busybox:latest")
+	src.c.sys = &types.SystemContext{MaxManifestSize: int64(len(manifestBody) - 1)}
+	_, _, err = src.GetManifest(context.Background(), nil)
+	assert.True(t, errors.Is(err, iolimits.ErrTooLarge), "%v", err)
+}
+
+// recordedSpan is a types.Span recorded by recordingTracer.
+type recordedSpan struct {
+	name       string
+	attributes map[string]string
+	ended      bool
+	err        error
+}
+
+func (s *recordedSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+// recordingTracer is a types.Tracer recording all spans.
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, types.Span) {
+	span := &recordedSpan{name: name, attributes: attributes}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestDockerImageSourceTracing(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/library/busybox/manifests/latest" {
+			w.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			w.Write(manifestBody)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	tracer := &recordingTracer{}
+	src := &dockerImageSource{
+		ref:                        dockerRefFromString(t, "//busybox:latest"),
+		requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+		c:                          newTestDockerClient(t, server),
+		blobEndpoints:              map[digest.Digest]string{},
+	}
+	src.c.sys = &types.SystemContext{Tracer: tracer}
+	_, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	_, _, err = src.GetBlob(context.Background(), types.BlobInfo{Digest: digest.FromString("missing")}, blobinfocache.NoCache)
+	assert.Error(t, err)
+
+	require.Len(t, tracer.spans, 2)
+	assert.Equal(t, &recordedSpan{
+		name:       "docker.GetManifest",
+		attributes: map[string]string{"repository": "docker.io/library/busybox", "manifest": "latest"},
+		ended:      true,
+	}, tracer.spans[0])
+	assert.Equal(t, "docker.GetBlob", tracer.spans[1].name)
+	assert.Equal(t, digest.FromString("missing").String(), tracer.spans[1].attributes["digest"])
+	assert.True(t, tracer.spans[1].ended)
+	assert.Error(t, tracer.spans[1].err)
+}
+
+func TestDockerImageSourceGetSignaturesFromAPIExtension(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+	instanceDigest := digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/busybox/manifests/latest":
+			w.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			w.Write(manifestBody)
+		case "/extensions/v2/library/busybox/signatures/" + instanceDigest.String():
+			w.Write([]byte(`{"signatures":[{"schemaVersion":2,"name":"` + instanceDigest.String() + `@1","type":"atomic","content":"c2lnNA=="}]}`))
+		case "/extensions/v2/library/busybox/signatures/" + manifestDigest.String():
+			w.Write([]byte(`{"signatures":[` +
+				`{"schemaVersion":2,"name":"` + manifestDigest.String() + `@1","type":"atomic","content":"c2lnMQ=="},` +
+				`{"schemaVersion":2,"name":"` + manifestDigest.String() + `@2","type":"unknown","content":"c2lnMg=="},` +
+				`{"schemaVersion":3,"name":"` + manifestDigest.String() + `@3","type":"atomic","content":"c2lnMw=="}` +
+				`]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := newTestDockerClient(t, server)
+	c.supportsSignatures = true
+	src := &dockerImageSource{
+		ref:                        dockerRefFromString(t, "//busybox:latest"),
+		requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+		c:                          c,
+	}
+	sigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig1")}, sigs)
+
+	// Signatures of an instance of a manifest list are stored for the instance digest.
+	sigs, err = src.GetSignatures(context.Background(), &instanceDigest)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig4")}, sigs)
+	invalidDigest := digest.Digest("sha256:../../this-is-invalid")
+	_, err = src.GetSignatures(context.Background(), &invalidDigest)
+	assert.Error(t, err)
+
+	// Without the extension or a lookaside, there are no signatures.
+	src.c = newTestDockerClient(t, server)
+	sigs, err = src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, sigs)
+}
+
+func TestDockerImageSourceMirrors(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	const mirroredBlob, primaryBlob = "sha256:1111111111111111111111111111111111111111111111111111111111111111", "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+
+	newServer := func(paths map[string]string, requests *[]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*requests = append(*requests, r.URL.Path)
+			contents, ok := paths[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(contents))
+		}))
+	}
+	brokenMirrorRequests, mirrorRequests, primaryRequests := []string{}, []string{}, []string{}
+	brokenMirror := newServer(map[string]string{}, &brokenMirrorRequests)
+	defer brokenMirror.Close()
+	mirror := newServer(map[string]string{
+		"/v2/library/busybox/manifests/latest":      string(manifestBody),
+		"/v2/library/busybox/blobs/" + mirroredBlob: "mirrored",
+	}, &mirrorRequests)
+	defer mirror.Close()
+	primary := newServer(map[string]string{
+		"/v2/library/busybox/manifests/latest":      "not used",
+		"/v2/library/busybox/blobs/" + mirroredBlob: "not used",
+		"/v2/library/busybox/blobs/" + primaryBlob:  "primary",
+	}, &primaryRequests)
+	defer primary.Close()
+
+	src := &dockerImageSource{
+		ref:                        dockerRefFromString(t, "//busybox:latest"),
+		requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+		c:                          newTestDockerClient(t, primary),
+		mirrors:                    []*dockerClient{newTestDockerClient(t, brokenMirror), newTestDockerClient(t, mirror)},
+		blobEndpoints:              map[digest.Digest]string{},
+	}
+	m, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBody, m)
+	assert.Empty(t, primaryRequests)
+
+	for _, c := range []struct {
+		digest             digest.Digest
+		contents, endpoint string
+	}{
+		{mirroredBlob, "mirrored", src.mirrors[1].registry},
+		{primaryBlob, "primary", src.c.registry},
+	} {
+		stream, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: c.digest, Size: -1}, blobinfocache.NoCache)
+		require.NoError(t, err, c.digest)
+		contents, err := ioutil.ReadAll(stream)
+		stream.Close()
+		require.NoError(t, err, c.digest)
+		assert.Equal(t, c.contents, string(contents), c.digest)
+		assert.Equal(t, c.endpoint, src.blobEndpoints[c.digest], c.digest)
+	}
+	assert.Equal(t, []string{"/v2/library/busybox/blobs/" + primaryBlob}, primaryRequests)
+	assert.Len(t, brokenMirrorRequests, 3)
+
+	_, _, err = src.GetBlob(context.Background(), types.BlobInfo{Digest: "sha256:3333333333333333333333333333333333333333333333333333333333333333", Size: -1}, blobinfocache.NoCache)
+	assert.Error(t, err)
+}
+
+func TestDockerImageSourceGetBlobURLsAndCache(t *testing.T) {
+	const blob = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+	newServer := func(paths map[string]string, requests *[]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*requests = append(*requests, r.URL.Path)
+			contents, ok := paths[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(contents))
+		}))
+	}
+	externalRequests, mirrorRequests, primaryRequests := []string{}, []string{}, []string{}
+	external := newServer(map[string]string{"/foreign": "external"}, &externalRequests)
+	defer external.Close()
+	mirror := newServer(map[string]string{"/v2/library/busybox/blobs/" + blob: "mirrored"}, &mirrorRequests)
+	defer mirror.Close()
+	primary := newServer(map[string]string{"/v2/library/busybox/blobs/" + blob: "primary"}, &primaryRequests)
+	defer primary.Close()
+
+	src := &dockerImageSource{
+		ref:                        dockerRefFromString(t, "//busybox:latest"),
+		requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+		c:                          newTestDockerClient(t, primary),
+		mirrors:                    []*dockerClient{newTestDockerClient(t, mirror)},
+		blobEndpoints:              map[digest.Digest]string{},
+	}
+	getBlob := func(info types.BlobInfo, cache types.BlobInfoCache) string {
+		stream, _, err := src.GetBlob(context.Background(), info, cache)
+		require.NoError(t, err)
+		defer stream.Close()
+		contents, err := ioutil.ReadAll(stream)
+		require.NoError(t, err)
+		return string(contents)
+	}
+
+	// Blobs with URLs are read from the URLs, without contacting the registry.
+	contents := getBlob(types.BlobInfo{Digest: blob, Size: -1, URLs: []string{external.URL + "/missing", external.URL + "/foreign"}}, blobinfocache.NoCache)
+	assert.Equal(t, "external", contents)
+	assert.Equal(t, []string{"/missing", "/foreign"}, externalRequests)
+	assert.Empty(t, mirrorRequests)
+	assert.Empty(t, primaryRequests)
+
+	// If the URLs fail, the registry is used.
+	contents = getBlob(types.BlobInfo{Digest: blob, Size: -1, URLs: []string{external.URL + "/missing"}}, blobinfocache.NoCache)
+	assert.Equal(t, "mirrored", contents)
+
+	// Locations recorded in the cache are preferred, and successful reads are recorded.
+	cache := blobinfocache.NewMemoryCache()
+	cache.RecordKnownLocation(src.ref.Transport(), bicTransportScope(src.ref), blob, types.BICLocationReference{Opaque: src.c.registry})
+	mirrorRequests = []string{}
+	contents = getBlob(types.BlobInfo{Digest: blob, Size: -1}, cache)
+	assert.Equal(t, "primary", contents)
+	assert.Empty(t, mirrorRequests)
+
+	cache = blobinfocache.NewMemoryCache()
+	contents = getBlob(types.BlobInfo{Digest: blob, Size: -1}, cache)
+	assert.Equal(t, "mirrored", contents)
+	assert.Equal(t, []types.BICReplacementCandidate{{Digest: blob, Location: types.BICLocationReference{Opaque: src.mirrors[0].registry}}},
+		cache.CandidateLocations(src.ref.Transport(), bicTransportScope(src.ref), blob, false))
+}
+
+// readBlobChunks reads all streams returned by GetBlobAt.
+func readBlobChunks(streams chan io.ReadCloser, errs chan error) ([]string, error) {
+	res := []string{}
+	for stream := range streams {
+		contents, err := ioutil.ReadAll(stream)
+		stream.Close()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, string(contents))
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func TestDockerImageSourceGetBlobAt(t *testing.T) {
+	const blob = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	const contents = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+	ranges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/busybox/blobs/"+blob {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(contents))
+	}))
+	defer ranges.Close()
+	noRanges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(contents))
+	}))
+	defer noRanges.Close()
+
+	src := &dockerImageSource{
+		ref:                        dockerRefFromString(t, "//busybox:latest"),
+		requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+		c:                          newTestDockerClient(t, ranges),
+		blobEndpoints:              map[digest.Digest]string{},
+	}
+	for _, chunks := range [][]types.ImageSourceChunk{
+		{{Offset: 0, Length: 5}},
+		{{Offset: 10, Length: 1}},
+		{{Offset: 2, Length: 3}, {Offset: 20, Length: 6}, {Offset: 30, Length: 6}},
+	} {
+		streams, errs, err := src.GetBlobAt(context.Background(), types.BlobInfo{Digest: blob, Size: -1}, chunks)
+		require.NoError(t, err, "%#v", chunks)
+		res, err := readBlobChunks(streams, errs)
+		require.NoError(t, err, "%#v", chunks)
+		expected := []string{}
+		for _, c := range chunks {
+			expected = append(expected, contents[c.Offset:c.Offset+c.Length])
+		}
+		assert.Equal(t, expected, res, "%#v", chunks)
+	}
+
+	_, _, err := src.GetBlobAt(context.Background(), types.BlobInfo{Digest: blob, Size: -1}, []types.ImageSourceChunk{})
+	assert.Error(t, err)
+	_, _, err = src.GetBlobAt(context.Background(), types.BlobInfo{Digest: blob, Size: -1}, []types.ImageSourceChunk{{Offset: 1, Length: 0}})
+	assert.Error(t, err)
+	_, _, err = src.GetBlobAt(context.Background(), types.BlobInfo{Digest: "sha256:2222222222222222222222222222222222222222222222222222222222222222", Size: -1},
+		[]types.ImageSourceChunk{{Offset: 0, Length: 1}})
+	assert.True(t, errors.Is(err, ErrBlobUnknown))
+
+	// A registry which ignores the Range header is detected.
+	src.c = newTestDockerClient(t, noRanges)
+	_, _, err = src.GetBlobAt(context.Background(), types.BlobInfo{Digest: blob, Size: -1}, []types.ImageSourceChunk{{Offset: 0, Length: 1}})
+	assert.True(t, errors.Is(err, ErrRangeRequestsNotSupported))
+}
+
+func TestNewMirrorClients(t *testing.T) {
+	ctx, cleanup := writeRegistriesConf(t, `
+[[registry]]
+location = "docker.io"
+
+[[registry.mirror]]
+location = "mirror.example.com"
+
+[[registry.mirror]]
+location = "insecure-mirror.example.com"
+insecure = true
+
+[[registry]]
+location = "docker.io/library/busybox"
+blocked = true
+`)
+	defer cleanup()
+	ctx.DockerRegistryMirrors = map[string][]string{"docker.io": {"ctx-mirror.example.com"}}
+
+	clients, err := newMirrorClients(ctx, dockerRefFromString(t, "//alpine:latest"))
+	require.NoError(t, err)
+	res := []string{}
+	insecure := []bool{}
+	for _, c := range clients {
+		res = append(res, c.registry)
+		insecure = append(insecure, c.insecure)
+	}
+	assert.Equal(t, []string{"ctx-mirror.example.com", "mirror.example.com", "insecure-mirror.example.com"}, res)
+	assert.Equal(t, []bool{false, false, true}, insecure)
+
+	clients, err = newMirrorClients(ctx, dockerRefFromString(t, "//example.com/alpine:latest"))
+	require.NoError(t, err)
+	assert.Empty(t, clients)
+
+	_, err = newDockerClient(ctx, dockerRefFromString(t, "//busybox:latest"), false)
+	assert.Error(t, err)
+	c, err := newDockerClient(ctx, dockerRefFromString(t, "//alpine:latest"), false)
+	require.NoError(t, err)
+	assert.False(t, c.insecure)
+}
+
+func TestDockerImageSourceGetManifestDigest(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/v2/library/busybox/manifests/latest":
+			w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+			w.Write(manifestBody)
+		case "/v2/library/busybox/manifests/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	newSource := func(ref string) *dockerImageSource {
+		return &dockerImageSource{
+			ref:                        dockerRefFromString(t, ref),
+			requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+			c:                          newTestDockerClient(t, server),
+			blobEndpoints:              map[digest.Digest]string{},
+		}
+	}
+
+	// Only a HEAD request is used.
+	src := newSource("//busybox:latest")
+	d, err := src.GetManifestDigest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, manifestDigest, d)
+	exists, err := src.Exists(context.Background())
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []string{"HEAD /v2/library/busybox/manifests/latest", "HEAD /v2/library/busybox/manifests/latest"}, requests)
+
+	// A loaded manifest is used without contacting the registry.
+	_, _, err = src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	requests = []string{}
+	d, err = src.GetManifestDigest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, manifestDigest, d)
+	assert.Empty(t, requests)
+
+	// A missing manifest is not an error for Exists.
+	src = newSource("//busybox:missing")
+	_, err = src.GetManifestDigest(context.Background())
+	assert.True(t, errors.Is(err, ErrManifestUnknown))
+	exists, err = src.Exists(context.Background())
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Other failures are reported.
+	src = newSource("//busybox:forbidden")
+	_, err = src.Exists(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDockerClient returns a dockerClient talking to server using plain HTTP, without pinging it first.
+func newTestDockerClient(t *testing.T, server *httptest.Server) *dockerClient {
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return &dockerClient{
+		registry: u.Host,
+		scheme:   "http",
+		client:   &http.Client{},
+	}
+}
+
+func TestGetRepositoryTags(t *testing.T) {
+	allTags := []string{"1", "2", "3", "4", "5"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/busybox/tags/list" {
+			http.NotFound(w, r)
+			return
+		}
+		// Return at most two tags per page, starting after the "last" parameter.
+		start := 0
+		if last := r.URL.Query().Get("last"); last != "" {
+			for i, tag := range allTags {
+				if tag == last {
+					start = i + 1
+				}
+			}
+		}
+		end := start + 2
+		if end >= len(allTags) {
+			end = len(allTags)
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/library/busybox/tags/list?n=2&last=%s>; rel="next"`, allTags[end-1]))
+		}
+		fmt.Fprintf(w, `{"name":"library/busybox","tags":["%s"]}`, strings.Join(allTags[start:end], `","`))
+	}))
+	defer server.Close()
+
+	ref := dockerRefFromString(t, "//busybox")
+	c := newTestDockerClient(t, server)
+	tags, err := c.getRepositoryTags(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Equal(t, allTags, tags)
+
+	_, err = c.getRepositoryTags(context.Background(), dockerRefFromString(t, "//notfound"))
+	assert.Error(t, err)
+}
+
+func TestNextPageURL(t *testing.T) {
+	base, err := url.Parse("https://registry.example.com/v2/repo/tags/list")
+	require.NoError(t, err)
+	for _, c := range []struct {
+		headers  []string
+		expected string // "" if no next page is expected
+	}{
+		{nil, ""},
+		{[]string{`</v2/repo/tags/list?last=b&n=2>; rel="next"`}, "https://registry.example.com/v2/repo/tags/list?last=b&n=2"},
+		{[]string{`<https://registry.example.com/v2/repo/tags/list?last=b>; rel=next`}, "https://registry.example.com/v2/repo/tags/list?last=b"},
+		{[]string{`</v2/repo/tags/list?last=a>; rel="prev", </v2/repo/tags/list?last=c>; rel="next"`}, "https://registry.example.com/v2/repo/tags/list?last=c"},
+		{[]string{`</v2/repo/tags/list?last=a>; rel="prev"`, `</v2/repo/tags/list?last=c>; title="x"; REL="Next"`}, "https://registry.example.com/v2/repo/tags/list?last=c"},
+		{[]string{`</v2/repo/tags/list?last=a>; rel="prev"`}, ""},
+	} {
+		res := &http.Response{Header: http.Header{}, Request: &http.Request{URL: base}}
+		for _, h := range c.headers {
+			res.Header.Add("Link", h)
+		}
+		u, err := nextPageURL(res)
+		require.NoError(t, err, fmt.Sprintf("%#v", c.headers))
+		if c.expected == "" {
+			assert.Nil(t, u, fmt.Sprintf("%#v", c.headers))
+		} else {
+			require.NotNil(t, u, fmt.Sprintf("%#v", c.headers))
+			assert.Equal(t, c.expected, u.String())
+		}
+	}
+
+	for _, header := range []string{
+		`/v2/repo/tags/list?last=b; rel="next"`,                              // Missing <>
+		`<https://other.example.com/v2/repo/tags/list?last=b>; rel="next"`,   // Different host
+		`<http://registry.example.com/v2/repo/tags/list?last=b>; rel="next"`, // Different scheme
+	} {
+		res := &http.Response{Header: http.Header{"Link": []string{header}}, Request: &http.Request{URL: base}}
+		_, err := nextPageURL(res)
+		assert.Error(t, err, header)
+	}
+}
+
+func TestGetDigest(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+
+	for _, sendDigest := range []bool{true, false} {
+		requests := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method)
+			if r.URL.Path != "/v2/library/busybox/manifests/latest" {
+				http.NotFound(w, r)
+				return
+			}
+			if sendDigest {
+				w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+			}
+			w.Write(manifestBody)
+		}))
+		c := newTestDockerClient(t, server)
+
+		digest, err := c.getDigest(context.Background(), dockerRefFromString(t, "//busybox:latest"), manifest.DefaultRequestedManifestMIMETypes)
+		require.NoError(t, err)
+		assert.Equal(t, manifestDigest, digest)
+		if sendDigest {
+			assert.Equal(t, []string{"HEAD"}, requests)
+		} else {
+			assert.Equal(t, []string{"HEAD", "GET"}, requests)
+		}
+
+		_, err = c.getDigest(context.Background(), dockerRefFromString(t, "//notfound:latest"), manifest.DefaultRequestedManifestMIMETypes)
+		assert.True(t, errors.Is(err, ErrManifestUnknown))
+		server.Close()
+	}
+
+	// Digested references do not need to contact the registry at all.
+	digest, err := GetDigest(context.Background(), nil, dockerRefFromString(t, "//busybox@"+manifestDigest.String()))
+	require.NoError(t, err)
+	assert.Equal(t, manifestDigest, digest)
+}
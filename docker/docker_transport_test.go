@@ -1,6 +1,10 @@
 package docker
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/containers/image/docker/reference"
@@ -42,7 +46,7 @@ func TestParseReference(t *testing.T) {
 // testParseReference is a test shared for Transport.ParseReference and ParseReference.
 func testParseReference(t *testing.T, fn func(string) (types.ImageReference, error)) {
 	for _, c := range []struct{ input, expected string }{
-		{"busybox", ""},                                        // Missing // prefix
+		{"busybox", ""}, // Missing // prefix
 		{"//busybox:notlatest", "busybox:notlatest"},           // Explicit tag
 		{"//busybox" + sha256digest, "busybox" + sha256digest}, // Explicit digest
 		{"//busybox", "busybox:latest"},                        // Default tag
@@ -160,7 +164,7 @@ func TestReferencePolicyConfigurationNamespaces(t *testing.T) {
 func TestReferenceNewImage(t *testing.T) {
 	ref, err := ParseReference("//busybox")
 	require.NoError(t, err)
-	img, err := ref.NewImage(&types.SystemContext{RegistriesDirPath: "/this/doesnt/exist"})
+	img, err := ref.NewImage(context.Background(), &types.SystemContext{RegistriesDirPath: "/this/doesnt/exist"})
 	assert.NoError(t, err)
 	defer img.Close()
 }
@@ -168,7 +172,7 @@ func TestReferenceNewImage(t *testing.T) {
 func TestReferenceNewImageSource(t *testing.T) {
 	ref, err := ParseReference("//busybox")
 	require.NoError(t, err)
-	src, err := ref.NewImageSource(&types.SystemContext{RegistriesDirPath: "/this/doesnt/exist"}, nil)
+	src, err := ref.NewImageSource(context.Background(), &types.SystemContext{RegistriesDirPath: "/this/doesnt/exist"})
 	assert.NoError(t, err)
 	defer src.Close()
 }
@@ -176,7 +180,7 @@ func TestReferenceNewImageSource(t *testing.T) {
 func TestReferenceNewImageDestination(t *testing.T) {
 	ref, err := ParseReference("//busybox")
 	require.NoError(t, err)
-	dest, err := ref.NewImageDestination(&types.SystemContext{RegistriesDirPath: "/this/doesnt/exist"})
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{RegistriesDirPath: "/this/doesnt/exist"})
 	assert.NoError(t, err)
 	defer dest.Close()
 }
@@ -202,3 +206,50 @@ func TestReferenceTagOrDigest(t *testing.T) {
 	_, err = dockerRef.tagOrDigest()
 	assert.Error(t, err)
 }
+
+// writeRegistriesConf writes contents to a temporary registries.conf, and returns a SystemContext using it
+// and a cleanup function.
+func writeRegistriesConf(t *testing.T, contents string) (*types.SystemContext, func()) {
+	tmpDir, err := ioutil.TempDir("", "registries-conf")
+	require.NoError(t, err)
+	path := filepath.Join(tmpDir, "registries.conf")
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	require.NoError(t, err)
+	return &types.SystemContext{SystemRegistriesConfPath: path}, func() { os.RemoveAll(tmpDir) }
+}
+
+func TestExpandUnqualifiedName(t *testing.T) {
+	noSearch, cleanup := writeRegistriesConf(t, "")
+	defer cleanup()
+	withSearch, cleanup := writeRegistriesConf(t, `unqualified-search-registries = ["registry.example.com", "docker.io"]`)
+	defer cleanup()
+	withAlias, cleanup := writeRegistriesConf(t, "unqualified-search-registries = [\"registry.example.com\", \"docker.io\"]\nshort-name-mode = \"enforcing\"\n[aliases]\n\"ubuntu\" = \"docker.io/library/ubuntu\"\n")
+	defer cleanup()
+
+	for _, c := range []struct {
+		ctx      *types.SystemContext
+		name     string
+		expected []string
+	}{
+		{noSearch, "busybox", []string{"//busybox:latest"}},
+		{noSearch, "example.com/ns/busybox:notlatest", []string{"//example.com/ns/busybox:notlatest"}},
+		{withSearch, "busybox", []string{"//registry.example.com/busybox:latest", "//busybox:latest"}},
+		{withSearch, "ns/busybox" + sha256digest, []string{"//registry.example.com/ns/busybox" + sha256digest, "//ns/busybox" + sha256digest}},
+		{withSearch, "localhost/busybox", []string{"//localhost/busybox:latest"}},
+		{withSearch, "example.com:5000/busybox", []string{"//example.com:5000/busybox:latest"}},
+		{withAlias, "ubuntu:20.04", []string{"//ubuntu:20.04"}},
+	} {
+		refs, err := ExpandUnqualifiedName(c.ctx, c.name)
+		require.NoError(t, err, c.name)
+		res := []string{}
+		for _, ref := range refs {
+			res = append(res, ref.StringWithinTransport())
+		}
+		assert.Equal(t, c.expected, res, c.name)
+	}
+
+	_, err := ExpandUnqualifiedName(withSearch, "UPPERCASE")
+	assert.Error(t, err)
+	_, err = ExpandUnqualifiedName(withAlias, "busybox") // Ambiguous
+	assert.Error(t, err)
+}
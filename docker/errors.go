@@ -0,0 +1,169 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Errors returned by the docker transport for common registry failures, so that callers can act on the kind of the failure.
+// The errors may be wrapped; use errors.Is to check for them.
+var (
+	// ErrManifestUnknown is returned if the registry does not contain the requested manifest.
+	ErrManifestUnknown = errors.New("manifest unknown")
+	// ErrBlobUnknown is returned if the registry does not contain the requested blob.
+	ErrBlobUnknown = errors.New("blob unknown to registry")
+	// ErrUnauthorized is returned if the registry rejects the request because of missing or insufficient credentials.
+	ErrUnauthorized = errors.New("authentication required")
+	// ErrTooManyRequests is returned if the registry rejects the request because of rate limiting.
+	ErrTooManyRequests = errors.New("too many requests to registry")
+	// ErrRangeRequestsNotSupported is returned by GetBlobAt if the registry does not support HTTP range requests for the blob.
+	ErrRangeRequestsNotSupported = errors.New("registry does not support range requests")
+)
+
+// maxErrorBodySize is the maximum size of an error response body we are willing to read and parse.
+const maxErrorBodySize = 64 * 1024
+
+// RegistryErrorDetail is a single entry of the "errors" array returned by a registry in an unsuccessful API response.
+type RegistryErrorDetail struct {
+	Code    string      `json:"code"`             // e.g. "DENIED", "NAME_UNKNOWN", "TOOMANYREQUESTS"
+	Message string      `json:"message"`          // A human-readable description of the error
+	Detail  interface{} `json:"detail,omitempty"` // Unstructured, code-specific additional data
+}
+
+// RegistryError is returned by the docker transport for unsuccessful registry API responses.
+// It preserves the HTTP status code and the structured errors returned by the registry, if any;
+// use errors.As to access it.  errors.Is can be used to check for the corresponding ErrManifestUnknown,
+// ErrBlobUnknown, ErrUnauthorized or ErrTooManyRequests.
+type RegistryError struct {
+	StatusCode int                   // The HTTP status code of the response
+	Errors     []RegistryErrorDetail // The errors returned by the registry; may be empty if the response did not contain a valid errors array
+	kind       error                 // One of the Err* values above, or nil
+}
+
+func (e *RegistryError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("registry returned status %d (%s)", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, d := range e.Errors {
+		msgs[i] = fmt.Sprintf("%s: %s", d.Code, d.Message)
+	}
+	return fmt.Sprintf("registry returned status %d: %s", e.StatusCode, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the ErrManifestUnknown, ErrBlobUnknown, ErrUnauthorized or ErrTooManyRequests value corresponding to e, if any.
+func (e *RegistryError) Unwrap() error {
+	return e.kind
+}
+
+// registryErrorKinds maps registry error codes to the corresponding Err* values.
+var registryErrorKinds = map[string]error{
+	"MANIFEST_UNKNOWN": ErrManifestUnknown,
+	"BLOB_UNKNOWN":     ErrBlobUnknown,
+	"UNAUTHORIZED":     ErrUnauthorized,
+	"TOOMANYREQUESTS":  ErrTooManyRequests,
+}
+
+// registryHTTPResponseToError returns a *RegistryError for an unsuccessful res from a registry API call.
+// notFoundErr, if not nil, is the Err* value to use if the requested object does not exist.
+// The caller is responsible for closing res.Body.
+func registryHTTPResponseToError(res *http.Response, notFoundErr error) error {
+	e := &RegistryError{StatusCode: res.StatusCode}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	if err == nil {
+		var payload struct {
+			Errors []RegistryErrorDetail `json:"errors"`
+		}
+		if json.Unmarshal(body, &payload) == nil {
+			e.Errors = payload.Errors
+		}
+	}
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		e.kind = ErrUnauthorized
+	case http.StatusTooManyRequests:
+		e.kind = ErrTooManyRequests
+	case http.StatusNotFound:
+		e.kind = notFoundErr
+	}
+	if e.kind == nil {
+		for _, d := range e.Errors {
+			if kind, ok := registryErrorKinds[d.Code]; ok {
+				e.kind = kind
+				break
+			}
+		}
+	}
+	return e
+}
+
+// manifestTypeRejectedErrorCodes are the registry error codes which, in response to a manifest upload, indicate that the registry
+// does not accept the type of the manifest, but may accept a different one.
+var manifestTypeRejectedErrorCodes = map[string]struct{}{
+	"MANIFEST_INVALID": {}, // e.g. OpenShift with acceptschema2=false, or registries which do not know OCI manifests
+	"UNSUPPORTED":      {}, // e.g. AWS ECR when uploading an OCI manifest
+}
+
+// isManifestTypeRejectedError returns true if err, returned by a manifest upload, indicates that the registry has rejected
+// the type of the manifest: either an Unsupported Media Type response, a MANIFEST_INVALID or UNSUPPORTED error code,
+// or a Bad Request response from an older registry which does not report the reason.
+func isManifestTypeRejectedError(err error) bool {
+	var e *RegistryError
+	if !errors.As(err, &e) {
+		return false
+	}
+	if e.StatusCode == http.StatusUnsupportedMediaType {
+		return true
+	}
+	for _, d := range e.Errors {
+		if _, ok := manifestTypeRejectedErrorCodes[d.Code]; ok {
+			return true
+		}
+	}
+	return e.StatusCode == http.StatusBadRequest && len(e.Errors) == 0
+}
+
+// ImageNotFoundError is returned by DeleteImage if the image does not exist in the registry
+// (or is not accessible using the provided credentials).
+type ImageNotFoundError struct {
+	Ref string // The image, in the docker/reference format
+}
+
+func (e ImageNotFoundError) Error() string {
+	return fmt.Sprintf("Image %s not found; it may not exist or may not be stored with a v2 Schema in a v2 registry", e.Ref)
+}
+
+// DeletionDisabledError is returned by DeleteImage if the registry does not allow deleting images,
+// e.g. because deletion is disabled in its configuration (which is the default for the Docker registry).
+type DeletionDisabledError struct {
+	Ref string // The image, in the docker/reference format
+}
+
+func (e DeletionDisabledError) Error() string {
+	return fmt.Sprintf("Unable to delete %s: deleting images is disabled in the registry", e.Ref)
+}
+
+// BlockedRegistryError is returned when accessing an image in a registry, or a namespace within a registry,
+// which is blocked in registries.conf.
+type BlockedRegistryError struct {
+	Location   string // The blocked location, host[:port] optionally followed by a namespace
+	ConfigPath string // The registries.conf file blocking the location
+}
+
+func (e BlockedRegistryError) Error() string {
+	return fmt.Sprintf("Registry %s is blocked in %s", e.Location, e.ConfigPath)
+}
+
+// UnauthorizedError is returned by DeleteImage if the registry refuses the deletion because of missing or insufficient credentials.
+type UnauthorizedError struct {
+	Ref string // The image, in the docker/reference format
+}
+
+func (e UnauthorizedError) Error() string {
+	return fmt.Sprintf("Unable to delete %s: not authorized", e.Ref)
+}
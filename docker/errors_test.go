@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryHTTPResponseToError(t *testing.T) {
+	for _, c := range []struct {
+		status       int
+		body         string
+		notFoundErr  error
+		expectedKind error // nil if the error should not match any of the Err* values
+		expectedErrs []RegistryErrorDetail
+	}{
+		{http.StatusUnauthorized, "", nil, ErrUnauthorized, nil},
+		{http.StatusTooManyRequests, "", ErrBlobUnknown, ErrTooManyRequests, nil},
+		{http.StatusNotFound, "", ErrManifestUnknown, ErrManifestUnknown, nil},
+		{http.StatusNotFound, "", ErrBlobUnknown, ErrBlobUnknown, nil},
+		{http.StatusNotFound, "", nil, nil, nil},
+		{http.StatusInternalServerError, "not JSON", ErrManifestUnknown, nil, nil},
+		{ // The error kind is determined by the error code if the status is not specific enough
+			http.StatusNotFound, `{"errors":[{"code":"NAME_UNKNOWN","message":"repository name not known to registry"},{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`, nil, ErrManifestUnknown,
+			[]RegistryErrorDetail{
+				{Code: "NAME_UNKNOWN", Message: "repository name not known to registry"},
+				{Code: "MANIFEST_UNKNOWN", Message: "manifest unknown"},
+			},
+		},
+		{
+			http.StatusForbidden, `{"errors":[{"code":"DENIED","message":"requested access to the resource is denied","detail":{"repository":"busybox"}}]}`, nil, nil,
+			[]RegistryErrorDetail{{Code: "DENIED", Message: "requested access to the resource is denied", Detail: map[string]interface{}{"repository": "busybox"}}},
+		},
+	} {
+		res := &http.Response{
+			StatusCode: c.status,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(c.body))),
+		}
+		err := fmt.Errorf("wrapped: %w", registryHTTPResponseToError(res, c.notFoundErr))
+
+		var regErr *RegistryError
+		require.True(t, errors.As(err, &regErr), "%d %s", c.status, c.body)
+		assert.Equal(t, c.status, regErr.StatusCode, "%d %s", c.status, c.body)
+		assert.Equal(t, c.expectedErrs, regErr.Errors, "%d %s", c.status, c.body)
+		for _, kind := range []error{ErrManifestUnknown, ErrBlobUnknown, ErrUnauthorized, ErrTooManyRequests} {
+			assert.Equal(t, kind == c.expectedKind, errors.Is(err, kind), "%d %s %v", c.status, c.body, kind)
+		}
+	}
+}
+
+func TestRegistryErrorError(t *testing.T) {
+	err := &RegistryError{StatusCode: http.StatusNotFound}
+	assert.Equal(t, "registry returned status 404 (Not Found)", err.Error())
+	err.Errors = []RegistryErrorDetail{{Code: "DENIED", Message: "access denied"}, {Code: "NAME_UNKNOWN", Message: "unknown"}}
+	assert.Equal(t, "registry returned status 404: DENIED: access denied; NAME_UNKNOWN: unknown", err.Error())
+}
@@ -0,0 +1,240 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/pkg/logging"
+	"github.com/containers/image/pkg/tlsclientconfig"
+	"github.com/containers/image/types"
+)
+
+const (
+	// maxIdleConnsPerHost is the number of idle connections kept open to a single registry; it should be at least
+	// the number of blobs copied in parallel, so that parallel pulls and pushes do not need to reconnect for every blob.
+	maxIdleConnsPerHost = 16
+	// dialTimeout, keepAliveInterval, tlsHandshakeTimeout and idleConnTimeout configure connections to registries.
+	dialTimeout         = 30 * time.Second
+	keepAliveInterval   = 30 * time.Second
+	tlsHandshakeTimeout = 10 * time.Second
+	idleConnTimeout     = 90 * time.Second
+)
+
+// httpTransportKey identifies the configuration of a shared http.Transport.
+type httpTransportKey struct {
+	hostname     string
+	insecure     bool
+	certPath     string // types.SystemContext.DockerCertPath
+	certDir      string // The certs.d-style directory, if certPath is not set
+	proxyURL     string // types.SystemContext.DockerProxyURL
+	disableProxy bool   // types.SystemContext.DockerDisableProxy
+	disableHTTP2 bool   // types.SystemContext.DockerDisableHTTP2, or set for the HTTP/1.1 fallback of a HTTP/2 transport
+	// customDialer is set if types.SystemContext.DockerDialContext is used; such transports are not shared,
+	// because functions can not be compared to determine whether two SystemContexts use the same one.
+	customDialer bool
+}
+
+// httpTransports contains the http.Transport objects shared by all dockerClient objects in this process,
+// so that connections to a registry are reused across images, and across manifest and blob requests.
+var httpTransports = struct {
+	sync.Mutex
+	transports map[httpTransportKey]*http.Transport
+	// fallbackStates contains the http2FallbackState objects for HTTP/2-enabled transports, indexed by the same keys.
+	fallbackStates map[httpTransportKey]*http2FallbackState
+}{
+	transports:     map[httpTransportKey]*http.Transport{},
+	fallbackStates: map[httpTransportKey]*http2FallbackState{},
+}
+
+// newHTTPTransportKey returns the httpTransportKey for contacting the registry at hostname with sys.
+func newHTTPTransportKey(sys *types.SystemContext, hostname string, insecure bool) httpTransportKey {
+	key := httpTransportKey{hostname: hostname, insecure: insecure}
+	if sys != nil && sys.DockerCertPath != "" {
+		key.certPath = sys.DockerCertPath
+	} else {
+		key.certDir = dockerCertDir(sys, hostname)
+	}
+	if sys != nil {
+		if sys.DockerProxyURL != nil {
+			key.proxyURL = sys.DockerProxyURL.String()
+		}
+		key.disableProxy = sys.DockerDisableProxy
+		key.disableHTTP2 = sys.DockerDisableHTTP2
+		key.customDialer = sys.DockerDialContext != nil
+	}
+	return key
+}
+
+// registryRoundTripper returns a shared http.RoundTripper for contacting the registry at hostname with sys.
+// Unless sys.DockerDisableHTTP2, it uses HTTP/2 if the registry offers it, falling back to HTTP/1.1 if the registry misbehaves.
+// If insecure, TLS verification failures are ignored.
+func registryRoundTripper(sys *types.SystemContext, hostname string, insecure bool) (http.RoundTripper, error) {
+	key := newHTTPTransportKey(sys, hostname, insecure)
+	tr, err := registryHTTPTransport(sys, key)
+	if err != nil {
+		return nil, err
+	}
+	if key.disableHTTP2 {
+		return tr, nil
+	}
+	http1Key := key
+	http1Key.disableHTTP2 = true
+	http1, err := registryHTTPTransport(sys, http1Key)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback := &http2FallbackTransport{hostname: hostname, http2: tr, http1: http1, logger: logging.ForSystemContext(sys)}
+	if key.customDialer {
+		fallback.state = &http2FallbackState{}
+		return fallback, nil
+	}
+	httpTransports.Lock()
+	defer httpTransports.Unlock()
+	state, ok := httpTransports.fallbackStates[key]
+	if !ok {
+		state = &http2FallbackState{}
+		httpTransports.fallbackStates[key] = state
+	}
+	fallback.state = state
+	return fallback, nil
+}
+
+// registryHTTPTransport returns a shared http.Transport for contacting the registry at key.hostname with sys.
+// If key.insecure, TLS verification failures are ignored.
+// The TLS configuration is read only when the transport is first created.
+func registryHTTPTransport(sys *types.SystemContext, key httpTransportKey) (*http.Transport, error) {
+	hostname, insecure := key.hostname, key.insecure
+	return sharedHTTPTransport(key, func() (*tls.Config, error) {
+		tlsc := &tls.Config{}
+		if sys != nil && sys.DockerCertPath != "" {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(sys.DockerCertPath, "cert.pem"), filepath.Join(sys.DockerCertPath, "key.pem"))
+			if err != nil {
+				return nil, fmt.Errorf("Error loading x509 key pair: %s", err)
+			}
+			tlsc.Certificates = append(tlsc.Certificates, cert)
+		} else if err := tlsclientconfig.SetupCertificates(dockerCertDir(sys, hostname), tlsc); err != nil {
+			return nil, err
+		}
+		tlsc.InsecureSkipVerify = insecure
+		return tlsc, nil
+	}, dockerProxy(sys), dockerDialContext(sys))
+}
+
+// tokenServerHTTPTransport returns a shared http.Transport for contacting the bearer token server of the registry at hostname with sys.
+// The TLS configuration of the registry is used, i.e. sys.DockerCertPath or the registry's certs.d directory;
+// TLS verification failures are only ignored if insecure, i.e. if they are ignored for the registry as well.
+func tokenServerHTTPTransport(sys *types.SystemContext, hostname string, insecure bool) (*http.Transport, error) {
+	return registryHTTPTransport(sys, newHTTPTransportKey(sys, hostname, insecure))
+}
+
+// dockerDialContext returns the function used to open network connections to registries (or proxies and token servers) with sys.
+func dockerDialContext(sys *types.SystemContext) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if sys != nil && sys.DockerDialContext != nil {
+		return sys.DockerDialContext
+	}
+	return (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: keepAliveInterval,
+	}).DialContext
+}
+
+// sharedHTTPTransport returns the http.Transport for key, creating it using newTLSConfig, proxy and dial if it does not exist yet.
+// If key.customDialer, a new http.Transport is always created, and it is not shared.
+func sharedHTTPTransport(key httpTransportKey, newTLSConfig func() (*tls.Config, error), proxy func(*http.Request) (*url.URL, error),
+	dial func(ctx context.Context, network, address string) (net.Conn, error)) (*http.Transport, error) {
+	httpTransports.Lock()
+	defer httpTransports.Unlock()
+	if tr, ok := httpTransports.transports[key]; ok && !key.customDialer {
+		return tr, nil
+	}
+	tlsc, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	tr := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		TLSClientConfig:       tlsc,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if key.disableHTTP2 {
+		// A non-nil, empty TLSNextProto prevents net/http from negotiating HTTP/2.
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		// net/http does not enable HTTP/2 on its own for transports with a custom DialContext or TLSClientConfig.
+		tr.ForceAttemptHTTP2 = true
+	}
+	if !key.customDialer {
+		httpTransports.transports[key] = tr
+	}
+	return tr, nil
+}
+
+// http2FallbackTransport is a http.RoundTripper which uses HTTP/2 when a registry offers it, and permanently switches to HTTP/1.1
+// after the first HTTP/2 protocol failure, so that registries (or proxies in front of them) which misbehave over HTTP/2 remain usable.
+type http2FallbackTransport struct {
+	hostname string
+	http2    http.RoundTripper // Used until state.useHTTP1 is set
+	http1    http.RoundTripper
+	logger   types.Logger
+	state    *http2FallbackState // Shared by all http2FallbackTransports using the same transports
+}
+
+// http2FallbackState records whether a registry has been found to misbehave over HTTP/2.
+type http2FallbackState struct {
+	mutex    sync.Mutex // Protects useHTTP1
+	useHTTP1 bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *http2FallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.state.mutex.Lock()
+	useHTTP1 := t.state.useHTTP1
+	t.state.mutex.Unlock()
+	if useHTTP1 {
+		return t.http1.RoundTrip(req)
+	}
+
+	res, err := t.http2.RoundTrip(req)
+	if err == nil || !isHTTP2ProtocolError(err) {
+		return res, err
+	}
+	t.logger.Debugf("Error contacting %s over HTTP/2, falling back to HTTP/1.1: %v", t.hostname, err)
+	t.state.mutex.Lock()
+	t.state.useHTTP1 = true
+	t.state.mutex.Unlock()
+
+	// Retry the request if we can; a body stream which has already been (partially) consumed can not be sent again.
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.http1.RoundTrip(req)
+	}
+	if req.GetBody == nil {
+		return nil, err
+	}
+	body, bodyErr := req.GetBody()
+	if bodyErr != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	return t.http1.RoundTrip(retry)
+}
+
+// isHTTP2ProtocolError returns true if err was caused by a failure of the HTTP/2 protocol, as opposed to e.g. a network failure.
+// The HTTP/2 implementation in net/http does not export its error types, so this recognizes them by their messages.
+func isHTTP2ProtocolError(err error) bool {
+	return strings.Contains(err.Error(), "http2: ")
+}
@@ -0,0 +1,294 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryHTTPTransport(t *testing.T) {
+	certDir, err := ioutil.TempDir("", "http-transport")
+	require.NoError(t, err)
+	defer os.RemoveAll(certDir)
+	sys := &types.SystemContext{DockerPerHostCertDirPath: certDir}
+
+	tr, err := registryHTTPTransport(sys, newHTTPTransportKey(sys, "transport.example.com", false))
+	require.NoError(t, err)
+	assert.Equal(t, maxIdleConnsPerHost, tr.MaxIdleConnsPerHost)
+	assert.Equal(t, tlsHandshakeTimeout, tr.TLSHandshakeTimeout)
+	assert.False(t, tr.TLSClientConfig.InsecureSkipVerify)
+
+	// The same configuration returns the same transport, even with a different SystemContext object.
+	sys2 := &types.SystemContext{DockerPerHostCertDirPath: certDir}
+	tr2, err := registryHTTPTransport(sys2, newHTTPTransportKey(sys2, "transport.example.com", false))
+	require.NoError(t, err)
+	assert.True(t, tr == tr2)
+
+	// Any difference in the configuration results in a different transport.
+	proxyURL, err := url.Parse("http://proxy.example.com")
+	require.NoError(t, err)
+	for _, c := range []struct {
+		sys      *types.SystemContext
+		hostname string
+		insecure bool
+	}{
+		{sys, "other.example.com", false},
+		{sys, "transport.example.com", true},
+		{&types.SystemContext{DockerPerHostCertDirPath: certDir, DockerProxyURL: proxyURL}, "transport.example.com", false},
+		{&types.SystemContext{DockerPerHostCertDirPath: certDir, DockerDisableProxy: true}, "transport.example.com", false},
+		{&types.SystemContext{DockerPerHostCertDirPath: certDir, DockerDisableHTTP2: true}, "transport.example.com", false},
+	} {
+		other, err := registryHTTPTransport(c.sys, newHTTPTransportKey(c.sys, c.hostname, c.insecure))
+		require.NoError(t, err)
+		assert.False(t, tr == other, "%#v", c)
+		assert.Equal(t, c.insecure, other.TLSClientConfig.InsecureSkipVerify)
+	}
+
+	// Failures to read the TLS configuration are reported.
+	badSys := &types.SystemContext{DockerCertPath: "/this/does/not/exist"}
+	_, err = registryHTTPTransport(badSys, newHTTPTransportKey(badSys, "transport.example.com", false))
+	assert.Error(t, err)
+}
+
+func TestRegistryRoundTripper(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		sys      *types.SystemContext
+		expected string
+	}{
+		{&types.SystemContext{DockerPerHostCertDirPath: "/this/does/not/exist"}, "HTTP/2.0"},
+		{&types.SystemContext{DockerPerHostCertDirPath: "/this/does/not/exist", DockerDisableHTTP2: true}, "HTTP/1.1"},
+	} {
+		rt, err := registryRoundTripper(c.sys, u.Host, true)
+		require.NoError(t, err)
+		rt2, err := registryRoundTripper(c.sys, u.Host, true)
+		require.NoError(t, err)
+		if fallback, ok := rt.(*http2FallbackTransport); ok {
+			// Each caller logs through its own logger, but the transports and the fallback decision are shared.
+			fallback2, ok := rt2.(*http2FallbackTransport)
+			require.True(t, ok)
+			assert.True(t, fallback.http2 == fallback2.http2)
+			assert.True(t, fallback.state == fallback2.state)
+		} else {
+			assert.True(t, rt == rt2)
+		}
+
+		client := &http.Client{Transport: rt}
+		res, err := client.Get(server.URL)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, string(body))
+	}
+}
+
+// roundTripperFunc is a http.RoundTripper implemented by a function.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHTTP2FallbackTransport(t *testing.T) {
+	var http2Calls, http1Calls int
+	var http1Bodies []string
+	var logger *recordingLogger
+	newTransport := func(http2Err error) *http2FallbackTransport {
+		http2Calls, http1Calls, http1Bodies = 0, 0, nil
+		logger = &recordingLogger{}
+		return &http2FallbackTransport{
+			hostname: "transport.example.com",
+			logger:   logger,
+			state:    &http2FallbackState{},
+			http2: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				http2Calls++
+				if req.Body != nil {
+					if _, err := ioutil.ReadAll(req.Body); err != nil {
+						return nil, err
+					}
+				}
+				if http2Err != nil {
+					return nil, http2Err
+				}
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}),
+			http1: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				http1Calls++
+				if req.Body != nil {
+					body, err := ioutil.ReadAll(req.Body)
+					if err != nil {
+						return nil, err
+					}
+					http1Bodies = append(http1Bodies, string(body))
+				}
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}),
+		}
+	}
+
+	// HTTP/2 is used while it works.
+	tr := newTransport(nil)
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "https://transport.example.com/v2/", nil)
+		require.NoError(t, err)
+		_, err = tr.RoundTrip(req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, http2Calls)
+	assert.Equal(t, 0, http1Calls)
+
+	// Other errors are returned without falling back.
+	tr = newTransport(errors.New("connection refused"))
+	req, err := http.NewRequest("GET", "https://transport.example.com/v2/", nil)
+	require.NoError(t, err)
+	_, err = tr.RoundTrip(req)
+	assert.Error(t, err)
+	assert.Equal(t, 0, http1Calls)
+
+	// After a HTTP/2 protocol error, the request is retried, and all following requests use HTTP/1.1.
+	tr = newTransport(errors.New("http2: server sent GOAWAY and closed the connection"))
+	req, err = http.NewRequest("PUT", "https://transport.example.com/v2/", bytes.NewReader([]byte("rewindable")))
+	require.NoError(t, err)
+	_, err = tr.RoundTrip(req)
+	require.NoError(t, err)
+	req, err = http.NewRequest("GET", "https://transport.example.com/v2/", nil)
+	require.NoError(t, err)
+	_, err = tr.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, http2Calls)
+	assert.Equal(t, 2, http1Calls)
+	assert.Equal(t, []string{"rewindable"}, http1Bodies)
+	assert.Equal(t, []string{"Error contacting transport.example.com over HTTP/2, falling back to HTTP/1.1: http2: server sent GOAWAY and closed the connection"}, logger.messages)
+
+	// A request with a body which can not be sent again fails, but following requests use HTTP/1.1.
+	tr = newTransport(errors.New("http2: stream closed"))
+	req, err = http.NewRequest("PUT", "https://transport.example.com/v2/", ioutil.NopCloser(bytes.NewReader([]byte("stream"))))
+	require.NoError(t, err)
+	_, err = tr.RoundTrip(req)
+	assert.Error(t, err)
+	assert.Equal(t, 0, http1Calls)
+	req, err = http.NewRequest("GET", "https://transport.example.com/v2/", nil)
+	require.NoError(t, err)
+	_, err = tr.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, http2Calls)
+	assert.Equal(t, 1, http1Calls)
+}
+
+// writeTestCertDir writes a self-signed CA certificate, and a client certificate using the same key pair, to a certs.d-style dir.
+func writeTestCertDir(t *testing.T, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "http-transport-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	for name, contents := range map[string][]byte{"ca.crt": certPEM, "client.cert": certPEM, "client.key": keyPEM} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), contents, 0600)
+		require.NoError(t, err)
+	}
+}
+
+func TestTokenServerHTTPTransport(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "token-server-transport")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	writeTestCertDir(t, filepath.Join(tmpDir, "token.example.com"))
+	sys := &types.SystemContext{DockerPerHostCertDirPath: tmpDir}
+
+	// The TLS configuration of the registry is used.
+	tr, err := tokenServerHTTPTransport(sys, "token.example.com", false)
+	require.NoError(t, err)
+	assert.False(t, tr.TLSClientConfig.InsecureSkipVerify)
+	assert.Len(t, tr.TLSClientConfig.Certificates, 1)
+	assert.NotNil(t, tr.TLSClientConfig.RootCAs)
+	registryTr, err := registryHTTPTransport(sys, newHTTPTransportKey(sys, "token.example.com", false))
+	require.NoError(t, err)
+	assert.True(t, tr == registryTr)
+
+	// Verification is only skipped for insecure registries.
+	tr, err = tokenServerHTTPTransport(nil, "token.example.com", true)
+	require.NoError(t, err)
+	assert.True(t, tr.TLSClientConfig.InsecureSkipVerify)
+	tr, err = tokenServerHTTPTransport(&types.SystemContext{}, "token.example.com", false)
+	require.NoError(t, err)
+	assert.False(t, tr.TLSClientConfig.InsecureSkipVerify)
+}
+
+func TestRegistryRoundTripperCustomDialer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// The registry is contacted using a name which does not resolve; the dialer connects to the test server instead.
+	dialed := []string{}
+	var mutex sync.Mutex
+	sys := &types.SystemContext{
+		DockerDialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			mutex.Lock()
+			dialed = append(dialed, address)
+			mutex.Unlock()
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}
+	rt, err := registryRoundTripper(sys, "registry.invalid", false)
+	require.NoError(t, err)
+	res, err := (&http.Client{Transport: rt}).Get("http://registry.invalid:5000/v2/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"registry.invalid:5000"}, dialed)
+
+	// Transports using a custom dialer are not shared.
+	rt2, err := registryRoundTripper(sys, "registry.invalid", false)
+	require.NoError(t, err)
+	assert.False(t, rt.(*http2FallbackTransport).state == rt2.(*http2FallbackTransport).state)
+	tr1, err := tokenServerHTTPTransport(sys, "registry.invalid", false)
+	require.NoError(t, err)
+	tr2, err := tokenServerHTTPTransport(sys, "registry.invalid", false)
+	require.NoError(t, err)
+	assert.False(t, tr1 == tr2)
+}
@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containers/image/types"
+)
+
+const (
+	// defaultMaxRateLimitWait is the maximum total time spent waiting to retry a rate-limited request,
+	// unless overridden by types.SystemContext.DockerMaxRateLimitWait.
+	defaultMaxRateLimitWait = 1 * time.Minute
+	// maxRateLimitRetries is the maximum number of times a rate-limited request is retried.
+	maxRateLimitRetries = 5
+	// initialRateLimitBackoff is the delay before the first retry of a rate-limited request which does not include Retry-After;
+	// it is doubled for each further retry.
+	initialRateLimitBackoff = 2 * time.Second
+)
+
+// maxRateLimitWait returns the maximum total time to wait to retry a rate-limited request, as configured by sys (which may be nil).
+func maxRateLimitWait(sys *types.SystemContext) time.Duration {
+	if sys != nil && sys.DockerMaxRateLimitWait != 0 {
+		return sys.DockerMaxRateLimitWait
+	}
+	return defaultMaxRateLimitWait
+}
+
+// rateLimitDelay returns the time to wait before retrying a request rejected with res, after attempt previous retries.
+func rateLimitDelay(res *http.Response, attempt int, now time.Time) time.Duration {
+	if delay, ok := parseRetryAfter(res.Header.Get("Retry-After"), now); ok {
+		return delay
+	}
+	return initialRateLimitBackoff << uint(attempt)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which may be either a number of seconds, or a HTTP date,
+// and returns the time to wait, relative to now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := t.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// parseRateLimit returns the rate limit described by the RateLimit-Limit and RateLimit-Remaining headers in header, if any.
+// The header values have the form "100;w=21600", where the optional w parameter is the length of the window in seconds.
+func parseRateLimit(header http.Header) (types.DockerRateLimit, bool) {
+	limit, window, ok := parseRateLimitHeader(header.Get("RateLimit-Limit"))
+	if !ok {
+		return types.DockerRateLimit{}, false
+	}
+	remaining, _, ok := parseRateLimitHeader(header.Get("RateLimit-Remaining"))
+	if !ok {
+		return types.DockerRateLimit{}, false
+	}
+	return types.DockerRateLimit{Limit: limit, Remaining: remaining, Window: window}, true
+}
+
+// parseRateLimitHeader parses a single RateLimit-* header value, returning the number and the window, if any.
+func parseRateLimitHeader(value string) (int64, time.Duration, bool) {
+	parts := strings.Split(value, ";")
+	n, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil || n < 0 {
+		return 0, 0, false
+	}
+	var window time.Duration
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && kv[0] == "w" {
+			if seconds, err := strconv.ParseInt(kv[1], 10, 64); err == nil && seconds > 0 {
+				window = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return n, window, true
+}
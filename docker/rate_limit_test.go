@@ -0,0 +1,131 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, time.November, 2, 10, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"Mon, 02 Nov 2020 10:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 02 Nov 2020 09:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	} {
+		res, ok := parseRetryAfter(c.value, now)
+		assert.Equal(t, c.ok, ok, c.value)
+		assert.Equal(t, c.expected, res, c.value)
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	now := time.Now()
+	res := &http.Response{Header: http.Header{}}
+	assert.Equal(t, initialRateLimitBackoff, rateLimitDelay(res, 0, now))
+	assert.Equal(t, 4*initialRateLimitBackoff, rateLimitDelay(res, 2, now))
+	res.Header.Set("Retry-After", "7")
+	assert.Equal(t, 7*time.Second, rateLimitDelay(res, 2, now))
+}
+
+func TestParseRateLimit(t *testing.T) {
+	for _, c := range []struct {
+		limit, remaining string
+		expected         *types.DockerRateLimit
+	}{
+		{"", "", nil},
+		{"100", "", nil},
+		{"", "76", nil},
+		{"100", "76", &types.DockerRateLimit{Limit: 100, Remaining: 76}},
+		{"100;w=21600", "76;w=21600", &types.DockerRateLimit{Limit: 100, Remaining: 76, Window: 6 * time.Hour}},
+		{"100;w=invalid", "0", &types.DockerRateLimit{Limit: 100, Remaining: 0}},
+		{"many", "76", nil},
+		{"100", "-1", nil},
+	} {
+		header := http.Header{}
+		if c.limit != "" {
+			header.Set("RateLimit-Limit", c.limit)
+		}
+		if c.remaining != "" {
+			header.Set("RateLimit-Remaining", c.remaining)
+		}
+		res, ok := parseRateLimit(header)
+		if c.expected == nil {
+			assert.False(t, ok, "%#v", c)
+		} else {
+			assert.True(t, ok, "%#v", c)
+			assert.Equal(t, *c.expected, res, "%#v", c)
+		}
+	}
+}
+
+func TestDockerClientRateLimit(t *testing.T) {
+	requests := 0
+	rejections := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("RateLimit-Limit", "100;w=21600")
+		w.Header().Set("RateLimit-Remaining", "0;w=21600")
+		if requests <= rejections {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	reported := []string{}
+	sys := &types.SystemContext{
+		DockerRateLimitCallback: func(registry string, limit types.DockerRateLimit) {
+			assert.Equal(t, types.DockerRateLimit{Limit: 100, Remaining: 0, Window: 6 * time.Hour}, limit)
+			reported = append(reported, registry)
+		},
+	}
+	c := &dockerClient{sys: sys, registry: u.Host, scheme: "http", client: &http.Client{}}
+	request := func() *http.Response {
+		res, err := c.makeRequest(context.Background(), "GET", "busybox/manifests/latest", nil, nil)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	// Rate-limited requests are retried.
+	requests, rejections = 0, 2
+	reported = []string{}
+	res := request()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, []string{u.Host, u.Host, u.Host}, reported)
+
+	// The number of retries is limited.
+	requests, rejections = 0, maxRateLimitRetries+10
+	res = request()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, maxRateLimitRetries+1, requests)
+
+	// Retries can be disabled.
+	sys.DockerMaxRateLimitWait = -1
+	requests, rejections = 0, 1
+	res = request()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,218 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/iolimits"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// referrersURL is the URL of the OCI referrers API for a manifest (repository, digest), relative to the /v2/ top-level API path.
+	referrersURL = "%s/referrers/%s"
+	// referrersSubjectHeader is the response header a registry supporting the referrers API includes when a manifest
+	// with a subject is uploaded.
+	referrersSubjectHeader = "OCI-Subject"
+)
+
+// referrersDescriptor is the subset of an OCI descriptor we need for listing and publishing referrers.
+type referrersDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       digest.Digest     `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// referrersIndex is an OCI index listing referrers, as returned by the referrers API, or stored using the referrers tag schema.
+type referrersIndex struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	MediaType     string                `json:"mediaType,omitempty"`
+	Manifests     []referrersDescriptor `json:"manifests"`
+}
+
+// referrersArtifactManifest is the subset of an OCI manifest we need for publishing it as a referrer.
+type referrersArtifactManifest struct {
+	MediaType    string `json:"mediaType"`
+	ArtifactType string `json:"artifactType,omitempty"`
+	Config       struct {
+		MediaType string `json:"mediaType"`
+	} `json:"config"`
+	Subject     *referrersDescriptor `json:"subject,omitempty"`
+	Annotations map[string]string    `json:"annotations,omitempty"`
+}
+
+// referrersTag returns the tag used by the referrers tag schema for listing the referrers of the manifest with manifestDigest,
+// for registries which do not support the referrers API.
+func referrersTag(manifestDigest digest.Digest) string {
+	algorithm := manifestDigest.Algorithm().String()
+	if len(algorithm) > 32 {
+		algorithm = algorithm[:32]
+	}
+	encoded := manifestDigest.Hex()
+	if len(encoded) > 64 {
+		encoded = encoded[:64]
+	}
+	return algorithm + "-" + encoded
+}
+
+// Compile-time check that dockerImageSource implements types.ReferrersSource
+var _ types.ReferrersSource = (*dockerImageSource)(nil)
+
+// GetReferrers returns the artifacts referring to the manifest with manifestDigest; if artifactType is not "",
+// only artifacts of that type are returned.
+// The referrers API is used if the registry supports it, otherwise the index tagged using the referrers tag schema.
+func (s *dockerImageSource) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]types.ImageReferrer, error) {
+	if err := manifestDigest.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid manifest digest %q: %v", manifestDigest, err)
+	}
+	index, err := s.c.fetchReferrersFromAPI(ctx, s.ref, manifestDigest, artifactType)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		index, err = s.c.fetchReferrersFromTag(ctx, s.ref, manifestDigest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := []types.ImageReferrer{}
+	for _, desc := range index.Manifests {
+		// Registries may ignore the artifactType filter, and the referrers tag schema does not support filtering at all.
+		if artifactType != "" && desc.ArtifactType != artifactType {
+			continue
+		}
+		res = append(res, types.ImageReferrer{
+			MediaType:    desc.MediaType,
+			Digest:       desc.Digest,
+			Size:         desc.Size,
+			ArtifactType: desc.ArtifactType,
+			Annotations:  desc.Annotations,
+		})
+	}
+	return res, nil
+}
+
+// fetchReferrersFromAPI returns the referrers of the manifest with manifestDigest in ref's repository, using the referrers API,
+// optionally filtered by artifactType.
+// It returns nil if the registry does not support the referrers API.
+func (c *dockerClient) fetchReferrersFromAPI(ctx context.Context, ref dockerReference, manifestDigest digest.Digest, artifactType string) (*referrersIndex, error) {
+	path := fmt.Sprintf(referrersURL, ref.ref.RemoteName(), manifestDigest)
+	if artifactType != "" {
+		path += "?artifactType=" + url.QueryEscape(artifactType)
+	}
+	res, err := c.makeRequest(ctx, "GET", path, map[string][]string{"Accept": {imgspecv1.MediaTypeImageManifestList}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		c.logger().Debugf("Referrers API not available for %s, status %d", manifestDigest, res.StatusCode)
+		return nil, nil
+	}
+	return parseReferrersIndex(res, c.sys, manifestDigest)
+}
+
+// fetchReferrersFromTag returns the referrers of the manifest with manifestDigest in ref's repository,
+// as recorded using the referrers tag schema; the returned index is empty if the tag does not exist.
+func (c *dockerClient) fetchReferrersFromTag(ctx context.Context, ref dockerReference, manifestDigest digest.Digest) (*referrersIndex, error) {
+	path := fmt.Sprintf(manifestURL, ref.ref.RemoteName(), referrersTag(manifestDigest))
+	res, err := c.makeRequest(ctx, "GET", path, map[string][]string{"Accept": {imgspecv1.MediaTypeImageManifestList}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return parseReferrersIndex(res, c.sys, manifestDigest)
+	case http.StatusNotFound:
+		return &referrersIndex{SchemaVersion: 2, MediaType: imgspecv1.MediaTypeImageManifestList, Manifests: []referrersDescriptor{}}, nil
+	default:
+		return nil, fmt.Errorf("Error reading referrers of %s: %w", manifestDigest, registryHTTPResponseToError(res, nil))
+	}
+}
+
+// parseReferrersIndex parses the referrers index of manifestDigest in the body of res.
+func parseReferrersIndex(res *http.Response, sys *types.SystemContext, manifestDigest digest.Digest) (*referrersIndex, error) {
+	body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestSize(sys))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading referrers of %s: %w", manifestDigest, err)
+	}
+	var index referrersIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("Error parsing referrers of %s: %v", manifestDigest, err)
+	}
+	return &index, nil
+}
+
+// Compile-time check that dockerImageDestination implements types.ReferrersDestination
+var _ types.ReferrersDestination = (*dockerImageDestination)(nil)
+
+// PutReferrer stores artifactManifest, an OCI image manifest with a "subject" field, so that it is returned by
+// GetReferrers for the subject manifest.  The blobs referenced by artifactManifest must already have been stored using PutBlob.
+// If the registry does not support the referrers API, the artifact is added to the index tagged using the referrers tag schema.
+func (d *dockerImageDestination) PutReferrer(ctx context.Context, artifactManifest []byte) error {
+	var parsed referrersArtifactManifest
+	if err := json.Unmarshal(artifactManifest, &parsed); err != nil {
+		return fmt.Errorf("Error parsing artifact manifest: %v", err)
+	}
+	if parsed.MediaType != imgspecv1.MediaTypeImageManifest {
+		return fmt.Errorf("Unsupported artifact manifest MIME type %q, expected %s", parsed.MediaType, imgspecv1.MediaTypeImageManifest)
+	}
+	if parsed.Subject == nil {
+		return fmt.Errorf("Artifact manifest does not refer to a subject")
+	}
+	if err := parsed.Subject.Digest.Validate(); err != nil {
+		return fmt.Errorf("Invalid artifact subject digest %q: %v", parsed.Subject.Digest, err)
+	}
+	artifactDigest, err := manifest.Digest(artifactManifest)
+	if err != nil {
+		return err
+	}
+
+	responseHeaders, err := d.uploadManifest(ctx, artifactDigest.String(), artifactManifest, imgspecv1.MediaTypeImageManifest)
+	if err != nil {
+		return err
+	}
+	if responseHeaders.Get(referrersSubjectHeader) != "" {
+		return nil // The registry supports the referrers API and has recorded the referrer.
+	}
+
+	d.c.logger().Debugf("Registry does not support the referrers API, updating tag %s", referrersTag(parsed.Subject.Digest))
+	index, err := d.c.fetchReferrersFromTag(ctx, d.ref, parsed.Subject.Digest)
+	if err != nil {
+		return err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Digest == artifactDigest {
+			return nil
+		}
+	}
+	artifactType := parsed.ArtifactType
+	if artifactType == "" {
+		artifactType = parsed.Config.MediaType
+	}
+	index.SchemaVersion = 2
+	index.MediaType = imgspecv1.MediaTypeImageManifestList
+	index.Manifests = append(index.Manifests, referrersDescriptor{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		Digest:       artifactDigest,
+		Size:         int64(len(artifactManifest)),
+		ArtifactType: artifactType,
+		Annotations:  parsed.Annotations,
+	})
+	indexBlob, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	_, err = d.uploadManifest(ctx, referrersTag(parsed.Subject.Digest), indexBlob, imgspecv1.MediaTypeImageManifestList)
+	return err
+}
@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferrersTag(t *testing.T) {
+	assert.Equal(t, "sha256-0123456789abcdef", referrersTag("sha256:0123456789abcdef"))
+	assert.Equal(t, strings.Repeat("a", 32)+"-"+strings.Repeat("0", 64),
+		referrersTag(digest.Digest(strings.Repeat("a", 40)+":"+strings.Repeat("0", 80))))
+}
+
+// referrersTestRegistry is a minimal registry storing manifests in memory, optionally supporting the referrers API.
+type referrersTestRegistry struct {
+	supportsReferrers bool
+	manifests         map[string][]byte                       // Indexed by tag or digest
+	referrers         map[digest.Digest][]referrersDescriptor // Indexed by subject digest
+}
+
+func (r *referrersTestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	const manifestsPrefix = "/v2/library/busybox/manifests/"
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/v2/library/busybox/referrers/"):
+		if !r.supportsReferrers {
+			http.NotFound(w, req)
+			return
+		}
+		subject := digest.Digest(strings.TrimPrefix(req.URL.Path, "/v2/library/busybox/referrers/"))
+		index := referrersIndex{SchemaVersion: 2, Manifests: r.referrers[subject]}
+		if index.Manifests == nil {
+			index.Manifests = []referrersDescriptor{}
+		}
+		if err := json.NewEncoder(w).Encode(index); err != nil {
+			panic(err)
+		}
+	case strings.HasPrefix(req.URL.Path, manifestsPrefix) && req.Method == "GET":
+		m, ok := r.manifests[strings.TrimPrefix(req.URL.Path, manifestsPrefix)]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(m)
+	case strings.HasPrefix(req.URL.Path, manifestsPrefix) && req.Method == "PUT":
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			panic(err)
+		}
+		r.manifests[strings.TrimPrefix(req.URL.Path, manifestsPrefix)] = body
+		if r.supportsReferrers {
+			var parsed referrersArtifactManifest
+			if err := json.Unmarshal(body, &parsed); err == nil && parsed.Subject != nil {
+				desc := referrersDescriptor{MediaType: parsed.MediaType, Digest: digest.FromBytes(body), Size: int64(len(body)), ArtifactType: parsed.ArtifactType}
+				known := false
+				for _, d := range r.referrers[parsed.Subject.Digest] {
+					known = known || d.Digest == desc.Digest
+				}
+				if !known {
+					r.referrers[parsed.Subject.Digest] = append(r.referrers[parsed.Subject.Digest], desc)
+				}
+				w.Header().Set(referrersSubjectHeader, parsed.Subject.Digest.String())
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// referrersTestArtifact returns an artifact manifest of artifactType referring to subject.
+func referrersTestArtifact(subject digest.Digest, artifactType string) []byte {
+	return []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","artifactType":"%s",`+
+		`"config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"%s","size":2},"layers":[],`+
+		`"subject":{"mediaType":"%s","digest":"%s","size":42},"annotations":{"org.example.name":"%s"}}`,
+		imgspecv1.MediaTypeImageManifest, artifactType, digest.FromString("{}"), imgspecv1.MediaTypeImageManifest, subject, artifactType))
+}
+
+func TestDockerReferrers(t *testing.T) {
+	subject := digest.FromString("subject")
+	const sbomType = "application/spdx+json"
+	const attestationType = "application/vnd.in-toto+json"
+	sbom := referrersTestArtifact(subject, sbomType)
+	attestation := referrersTestArtifact(subject, attestationType)
+
+	for _, supportsReferrers := range []bool{false, true} {
+		registry := &referrersTestRegistry{supportsReferrers: supportsReferrers, manifests: map[string][]byte{}, referrers: map[digest.Digest][]referrersDescriptor{}}
+		server := httptest.NewServer(registry)
+
+		dest := &dockerImageDestination{ref: dockerRefFromString(t, "//busybox:latest"), c: newTestDockerClient(t, server)}
+		for _, m := range [][]byte{sbom, attestation, sbom} { // Publishing an artifact again does not add a duplicate.
+			err := dest.PutReferrer(context.Background(), m)
+			require.NoError(t, err, "%v", supportsReferrers)
+		}
+		_, hasTag := registry.manifests[referrersTag(subject)]
+		assert.Equal(t, !supportsReferrers, hasTag)
+
+		src := &dockerImageSource{
+			ref:                        dockerRefFromString(t, "//busybox:latest"),
+			requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+			c:                          newTestDockerClient(t, server),
+			blobEndpoints:              map[digest.Digest]string{},
+		}
+		referrers, err := src.GetReferrers(context.Background(), subject, "")
+		require.NoError(t, err)
+		require.Len(t, referrers, 2)
+		assert.Equal(t, digest.FromBytes(sbom), referrers[0].Digest)
+		assert.Equal(t, sbomType, referrers[0].ArtifactType)
+		assert.Equal(t, imgspecv1.MediaTypeImageManifest, referrers[0].MediaType)
+		assert.Equal(t, int64(len(sbom)), referrers[0].Size)
+		assert.Equal(t, digest.FromBytes(attestation), referrers[1].Digest)
+
+		referrers, err = src.GetReferrers(context.Background(), subject, attestationType)
+		require.NoError(t, err)
+		require.Len(t, referrers, 1)
+		assert.Equal(t, digest.FromBytes(attestation), referrers[0].Digest)
+		if !supportsReferrers {
+			assert.Equal(t, map[string]string{"org.example.name": attestationType}, referrers[0].Annotations)
+		}
+
+		referrers, err = src.GetReferrers(context.Background(), digest.FromString("unrelated"), "")
+		require.NoError(t, err)
+		assert.Equal(t, []types.ImageReferrer{}, referrers)
+
+		server.Close()
+	}
+
+	// Invalid artifact manifests are rejected.
+	dest := &dockerImageDestination{ref: dockerRefFromString(t, "//busybox:latest"), c: &dockerClient{}}
+	for _, m := range []string{
+		"not JSON",
+		`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","subject":{"digest":"` + subject.String() + `"}}`,
+		`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`,
+		`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","subject":{"digest":"sha256:invalid"}}`,
+	} {
+		err := dest.PutReferrer(context.Background(), []byte(m))
+		assert.Error(t, err, m)
+	}
+}
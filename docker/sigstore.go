@@ -0,0 +1,170 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// sigstoreSignatureArtifactType is the artifact type of manifests containing sigstore signatures, as used by the referrers API.
+	sigstoreSignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// sigstoreSignatureLayerMediaType is the media type of layers containing a sigstore signature payload.
+	sigstoreSignatureLayerMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// sigstoreSignatureAnnotation is the layer annotation containing the base64-encoded signature of the layer payload.
+	sigstoreSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// sigstoreCertificateAnnotation is the layer annotation containing the PEM-encoded certificate of the signing key, if any.
+	sigstoreCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	// sigstoreChainAnnotation is the layer annotation containing the PEM-encoded intermediate certificates, if any.
+	sigstoreChainAnnotation = "dev.sigstore.cosign/chain"
+	// sigstoreBundleAnnotation is the layer annotation containing the JSON-encoded Rekor bundle, if any.
+	sigstoreBundleAnnotation = "dev.sigstore.cosign/bundle"
+	// sigstoreSignatureTagSuffix is appended to the manifest digest (with ':' replaced by '-') to form the tag of the signature manifest.
+	sigstoreSignatureTagSuffix = ".sig"
+)
+
+// sigstoreDescriptor is the subset of an OCI descriptor we need for reading sigstore signatures.
+type sigstoreDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       digest.Digest     `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// sigstoreSignatureManifest is the subset of an OCI manifest we need for reading sigstore signatures.
+type sigstoreSignatureManifest struct {
+	Layers []sigstoreDescriptor `json:"layers"`
+}
+
+// sigstoreSignatureTag returns the tag used by cosign to store signatures of the manifest with manifestDigest.
+func sigstoreSignatureTag(manifestDigest digest.Digest) string {
+	return strings.Replace(manifestDigest.String(), ":", "-", 1) + sigstoreSignatureTagSuffix
+}
+
+// Compile-time check that dockerImageSource implements types.SigstoreSignaturesSource
+var _ types.SigstoreSignaturesSource = (*dockerImageSource)(nil)
+
+// GetSigstoreSignatures returns the image's sigstore signatures, stored in the registry as OCI artifacts
+// referring to the image's manifest (see GetReferrers) or tagged using the cosign tag naming scheme.
+func (s *dockerImageSource) GetSigstoreSignatures(ctx context.Context) ([]types.SigstoreSignature, error) {
+	manifestDigest, err := s.manifestDigest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := s.fetchSigstoreReferrers(ctx, manifestDigest, sigstoreSignatureArtifactType)
+	if err != nil {
+		return nil, err
+	}
+	tagged, err := s.fetchSigstoreManifest(ctx, sigstoreSignatureTag(manifestDigest))
+	if err != nil {
+		return nil, err
+	}
+	if tagged != nil {
+		manifests = append(manifests, tagged)
+	}
+
+	sigs := []types.SigstoreSignature{}
+	for _, m := range manifests {
+		var parsed sigstoreSignatureManifest
+		if err := json.Unmarshal(m, &parsed); err != nil {
+			return nil, fmt.Errorf("Error parsing sigstore signature manifest: %v", err)
+		}
+		for _, layer := range parsed.Layers {
+			if layer.MediaType != sigstoreSignatureLayerMediaType {
+				continue
+			}
+			b64Sig, ok := layer.Annotations[sigstoreSignatureAnnotation]
+			if !ok {
+				s.c.logger().Debugf("Ignoring sigstore signature layer %s without a signature annotation", layer.Digest)
+				continue
+			}
+			signature, err := base64.StdEncoding.DecodeString(b64Sig)
+			if err != nil {
+				return nil, fmt.Errorf("Error decoding sigstore signature in layer %s: %v", layer.Digest, err)
+			}
+			payload, err := s.getSigstorePayload(ctx, layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			sig := types.SigstoreSignature{Payload: payload, Signature: signature}
+			if v, ok := layer.Annotations[sigstoreCertificateAnnotation]; ok {
+				sig.Certificate = []byte(v)
+			}
+			if v, ok := layer.Annotations[sigstoreChainAnnotation]; ok {
+				sig.CertificateChain = []byte(v)
+			}
+			if v, ok := layer.Annotations[sigstoreBundleAnnotation]; ok {
+				sig.RekorBundle = []byte(v)
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs, nil
+}
+
+// fetchSigstoreReferrers returns the manifests of artifacts of artifactType (e.g. signatures) referring to manifestDigest.
+func (s *dockerImageSource) fetchSigstoreReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([][]byte, error) {
+	referrers, err := s.GetReferrers(ctx, manifestDigest, artifactType)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := [][]byte{}
+	for _, referrer := range referrers {
+		m, _, err := s.fetchManifestByDigest(ctx, referrer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+// fetchSigstoreManifest returns the signature manifest tagged with tag, or nil if it does not exist.
+func (s *dockerImageSource) fetchSigstoreManifest(ctx context.Context, tag string) ([]byte, error) {
+	url := fmt.Sprintf(manifestURL, s.ref.ref.RemoteName(), tag)
+	res, err := s.c.makeRequest(ctx, "GET", url, map[string][]string{"Accept": {imgspecv1.MediaTypeImageManifest}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(res.Body)
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Error reading sigstore signatures from %s: status %d (%s)", tag, res.StatusCode, http.StatusText(res.StatusCode))
+	}
+}
+
+// getSigstorePayload returns the contents of the sigstore payload blob with payloadDigest, verifying that they match the digest.
+func (s *dockerImageSource) getSigstorePayload(ctx context.Context, payloadDigest digest.Digest) ([]byte, error) {
+	if err := payloadDigest.Validate(); err != nil {
+		return nil, fmt.Errorf("Unsupported sigstore payload digest %s: %v", payloadDigest, err)
+	}
+	stream, _, err := s.GetBlob(ctx, types.BlobInfo{Digest: payloadDigest, Size: -1}, blobinfocache.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	payload, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+	if payloadDigest.Algorithm().FromBytes(payload) != payloadDigest {
+		return nil, fmt.Errorf("Sigstore payload does not match digest %s", payloadDigest)
+	}
+	return payload, nil
+}
@@ -0,0 +1,145 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigstoreSignatureTag(t *testing.T) {
+	assert.Equal(t, "sha256-0123456789abcdef.sig", sigstoreSignatureTag("sha256:0123456789abcdef"))
+}
+
+// sigstoreTestManifest returns a sigstore signature manifest with a layer for each of payloads, signed by signatures.
+func sigstoreTestManifest(t *testing.T, payloads, signatures []string) []byte {
+	m := sigstoreSignatureManifest{Layers: []sigstoreDescriptor{}}
+	for i, payload := range payloads {
+		m.Layers = append(m.Layers, sigstoreDescriptor{
+			MediaType: sigstoreSignatureLayerMediaType,
+			Digest:    digest.FromString(payload),
+			Size:      int64(len(payload)),
+			Annotations: map[string]string{
+				sigstoreSignatureAnnotation:   base64.StdEncoding.EncodeToString([]byte(signatures[i])),
+				sigstoreCertificateAnnotation: "cert-" + signatures[i],
+				sigstoreChainAnnotation:       "chain-" + signatures[i],
+				sigstoreBundleAnnotation:      "bundle-" + signatures[i],
+			},
+		})
+	}
+	res, err := json.Marshal(m)
+	require.NoError(t, err)
+	return res
+}
+
+// sigstoreTestExpected returns the types.SigstoreSignature corresponding to a layer created by sigstoreTestManifest.
+func sigstoreTestExpected(payload, signature string) types.SigstoreSignature {
+	return types.SigstoreSignature{
+		Payload:          []byte(payload),
+		Signature:        []byte(signature),
+		Certificate:      []byte("cert-" + signature),
+		CertificateChain: []byte("chain-" + signature),
+		RekorBundle:      []byte("bundle-" + signature),
+	}
+}
+
+func TestDockerImageSourceGetSigstoreSignatures(t *testing.T) {
+	manifestBody := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	manifestDigest, err := manifest.Digest(manifestBody)
+	require.NoError(t, err)
+
+	taggedManifest := sigstoreTestManifest(t, []string{"payload1"}, []string{"sig1"})
+	referrerManifest := sigstoreTestManifest(t, []string{"payload2"}, []string{"sig2"})
+	referrerDigest, err := manifest.Digest(referrerManifest)
+	require.NoError(t, err)
+	referrers, err := json.Marshal(referrersIndex{SchemaVersion: 2, Manifests: []referrersDescriptor{
+		{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: referrerDigest, ArtifactType: sigstoreSignatureArtifactType},
+		{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: "sha256:unrelated", ArtifactType: "application/x-unrelated"},
+	}})
+	require.NoError(t, err)
+	blobs := map[string]string{}
+	for _, payload := range []string{"payload1", "payload2"} {
+		blobs["/v2/library/busybox/blobs/"+digest.FromString(payload).String()] = payload
+	}
+
+	supportsReferrers, hasTag := false, false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/busybox/manifests/latest":
+			w.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+			w.Write(manifestBody)
+		case "/v2/library/busybox/manifests/" + sigstoreSignatureTag(manifestDigest):
+			if !hasTag {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(taggedManifest)
+		case "/v2/library/busybox/referrers/" + manifestDigest.String():
+			if !supportsReferrers {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(referrers)
+		case "/v2/library/busybox/manifests/" + referrerDigest.String():
+			w.Write(referrerManifest)
+		default:
+			blob, ok := blobs[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(blob))
+		}
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		referrers, tag bool
+		expected       []types.SigstoreSignature
+	}{
+		{false, false, []types.SigstoreSignature{}},
+		{false, true, []types.SigstoreSignature{sigstoreTestExpected("payload1", "sig1")}},
+		{true, false, []types.SigstoreSignature{sigstoreTestExpected("payload2", "sig2")}},
+		{true, true, []types.SigstoreSignature{
+			sigstoreTestExpected("payload2", "sig2"),
+			sigstoreTestExpected("payload1", "sig1"),
+		}},
+	} {
+		supportsReferrers, hasTag = c.referrers, c.tag
+		src := &dockerImageSource{
+			ref:                        dockerRefFromString(t, "//busybox:latest"),
+			requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+			c:                          newTestDockerClient(t, server),
+			blobEndpoints:              map[digest.Digest]string{},
+		}
+		sigs, err := src.GetSigstoreSignatures(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, sigs)
+	}
+
+	// A payload not matching its digest is rejected.
+	hasTag = true
+	blobs["/v2/library/busybox/blobs/"+digest.FromString("").String()] = "unexpected"
+	taggedManifest, err = json.Marshal(sigstoreSignatureManifest{Layers: []sigstoreDescriptor{{
+		MediaType:   sigstoreSignatureLayerMediaType,
+		Digest:      digest.FromString(""),
+		Annotations: map[string]string{sigstoreSignatureAnnotation: "c2ln"},
+	}}})
+	require.NoError(t, err)
+	src := &dockerImageSource{
+		ref:                        dockerRefFromString(t, "//busybox:latest"),
+		requestedManifestMIMETypes: manifest.DefaultRequestedManifestMIMETypes,
+		c:                          newTestDockerClient(t, server),
+		blobEndpoints:              map[digest.Digest]string{},
+	}
+	_, err = src.GetSigstoreSignatures(context.Background())
+	assert.Error(t, err)
+}
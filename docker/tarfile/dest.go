@@ -0,0 +1,355 @@
+package tarfile
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/putblobdigest"
+	"github.com/containers/image/pkg/tmpdir"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// legacyLayerConfigFields are the fields of the image config copied into the legacy per-layer json of the topmost layer,
+// following github.com/docker/docker/image/tarexport/save.go.
+var legacyLayerConfigFields = []string{"architecture", "config", "container", "container_config", "created", "docker_version", "os"}
+
+// Destination is a partial implementation of types.ImageDestination for writing a docker save-formatted tarball to an io.Writer.
+// The blobs are included in the tarball in the order they are received, which, when used by copy.Image,
+// is the layers followed by the config; the manifest.json file (and the legacy metadata, if requested) is written last, by PutManifest.
+type Destination struct {
+	tar                  *tar.Writer
+	repoTags             []string
+	legacyLayout         bool // Also write the legacy "repositories" file and the per-layer <id>/{json,VERSION,layer.tar} entries.
+	bigFilesTemporaryDir string
+	// Other state
+	blobsSent map[digest.Digest]int64 // Digests of blobs already sent into the tar stream -> their sizes
+	config    []byte                  // The image config, if already sent; nil otherwise
+}
+
+// NewDestination returns a Destination writing to dest, recording repoTags (which may be empty) as the tags of the image.
+// If legacyLayout, the tarball also contains the legacy "repositories" file and v1-style layer directories,
+// as expected by old Docker daemons and some third-party loaders.
+// The caller must call .Commit() to finish the tarball; dest itself is not closed.
+func NewDestination(sys *types.SystemContext, dest io.Writer, repoTags []string, legacyLayout bool) *Destination {
+	return &Destination{
+		tar:                  tar.NewWriter(dest),
+		repoTags:             repoTags,
+		legacyLayout:         legacyLayout,
+		bigFilesTemporaryDir: tmpdir.TemporaryDirectoryForBigFiles(sys),
+		blobsSent:            map[digest.Digest]int64{},
+	}
+}
+
+// SupportedManifestMIMETypes tells which manifest mime types the destination supports
+// If an empty slice or nil it's returned, then any mime type can be tried to upload
+func (d *Destination) SupportedManifestMIMETypes() []string {
+	return []string{
+		manifest.DockerV2Schema2MediaType, // We rely on the types.Image.UpdatedImage schema conversion capabilities.
+	}
+}
+
+// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
+// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
+func (d *Destination) SupportsSignatures(ctx context.Context) error {
+	return errors.New("Storing signatures for docker tar files is not supported")
+}
+
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+// Layers are stored uncompressed, as by docker save, so that the layer digests match the DiffIDs in the config.
+func (d *Destination) DesiredLayerCompression() types.LayerCompression {
+	return types.Decompress
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *Destination) HasThreadSafePutBlob() bool {
+	return false // The blobs are written sequentially into a single tar stream
+}
+
+// PutBlob writes contents of stream and returns data representing the result (with all data filled in).
+// inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
+// inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (d *Destination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	if inputInfo.Digest == "" {
+		return types.BlobInfo{}, errors.New("Can not stream a blob with unknown digest to a docker tar file")
+	}
+
+	if isConfig {
+		// The config is small, and needed for the legacy per-layer metadata; keep it in memory.
+		config, err := ioutil.ReadAll(stream)
+		if err != nil {
+			return types.BlobInfo{}, err
+		}
+		inputInfo.Size = int64(len(config)) // inputInfo is a struct, so we are only modifying our copy.
+		stream = bytes.NewReader(config)
+		d.config = config
+	} else if inputInfo.Size == -1 { // Ouch, we need to stream the blob into a temporary file just to determine the size.
+		logrus.Debugf("docker tarfile: input with unknown size, streaming to disk first…")
+		streamCopy, err := ioutil.TempFile(d.bigFilesTemporaryDir, "docker-tarfile-blob")
+		if err != nil {
+			return types.BlobInfo{}, err
+		}
+		defer os.Remove(streamCopy.Name())
+		defer streamCopy.Close()
+
+		size, err := io.Copy(streamCopy, stream)
+		if err != nil {
+			return types.BlobInfo{}, err
+		}
+		_, err = streamCopy.Seek(0, os.SEEK_SET)
+		if err != nil {
+			return types.BlobInfo{}, err
+		}
+		inputInfo.Size = size
+		stream = streamCopy
+		logrus.Debugf("… streaming done")
+	}
+
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	if err := d.sendFile(blobPath(inputInfo.Digest, isConfig), inputInfo.Size, stream); err != nil {
+		return types.BlobInfo{}, err
+	}
+	d.blobsSent[inputInfo.Digest] = inputInfo.Size
+	return types.BlobInfo{Digest: digester.Digest(), Size: inputInfo.Size}, nil
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *Destination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	// A blob already sent into the tar stream (e.g. a layer used more than once) does not need to be sent again.
+	if size, ok := d.blobsSent[info.Digest]; ok {
+		return true, types.BlobInfo{Digest: info.Digest, Size: size}, nil
+	}
+	return false, types.BlobInfo{}, nil
+}
+
+// blobPath returns the path of a blob within the tarball, following the naming used by docker save.
+func blobPath(d digest.Digest, isConfig bool) string {
+	if isConfig {
+		return d.Hex() + ".json"
+	}
+	return d.Hex() + ".tar"
+}
+
+// PutManifest writes manifest to the destination, as manifest.json (preceded by the legacy metadata, if requested).
+func (d *Destination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by docker tar files")
+	}
+	man, err := manifest.Schema2FromManifest(m)
+	if err != nil {
+		return fmt.Errorf("Error parsing manifest: %v", err)
+	}
+	if man.SchemaVersion != 2 || man.MediaType != manifest.DockerV2Schema2MediaType {
+		// FIXME FIXME: Teach copy.go about this.
+		return fmt.Errorf("Unsupported manifest type, need a Docker schema 2 manifest")
+	}
+
+	layerPaths := []string{}
+	for _, l := range man.LayersDescriptors {
+		layerPaths = append(layerPaths, blobPath(l.Digest, false))
+	}
+
+	if d.legacyLayout {
+		lastLayerID, err := d.writeLegacyLayerMetadata(man.LayersDescriptors)
+		if err != nil {
+			return err
+		}
+		if err := d.writeLegacyRepositories(lastLayerID); err != nil {
+			return err
+		}
+	}
+
+	items := []ManifestItem{{
+		Config:       blobPath(man.ConfigDescriptor.Digest, true),
+		RepoTags:     d.repoTags,
+		Layers:       layerPaths,
+		Parent:       "",
+		LayerSources: nil,
+	}}
+	itemsBytes, err := json.Marshal(&items)
+	if err != nil {
+		return err
+	}
+	return d.sendFile(ManifestFileName, int64(len(itemsBytes)), bytes.NewReader(itemsBytes))
+}
+
+// writeLegacyLayerMetadata writes the <id>/{VERSION,json,layer.tar} entries for layers, and returns the ID of the topmost layer.
+// The layer.tar entries are symbolic links to the layers already in the tarball.
+func (d *Destination) writeLegacyLayerMetadata(layers []manifest.Schema2Descriptor) (string, error) {
+	if d.config == nil {
+		return "", errors.New("Internal error: the image config must be written before the manifest")
+	}
+	var config map[string]*json.RawMessage
+	if err := json.Unmarshal(d.config, &config); err != nil {
+		return "", fmt.Errorf("Error decoding image config: %v", err)
+	}
+
+	var chainID digest.Digest
+	lastLayerID := ""
+	for i, l := range layers {
+		// The IDs only need to be unique within the tarball, and must not create loops in the "parent" links
+		// if a layer appears more than once; a ChainID (as computed by docker/docker/layer.CreateChainID)
+		// depends on all of the layers below, so it satisfies both.  (Docker itself mixes in the full config,
+		// see docker/docker/image/v1.CreateID, but loaders allocate their own IDs anyway.)
+		if chainID == "" {
+			chainID = l.Digest
+		} else {
+			chainID = digest.FromString(chainID.String() + " " + l.Digest.String())
+		}
+		layerID := chainID.Hex()
+
+		if err := d.sendSymlink(path.Join(layerID, legacyLayerFileName), path.Join("..", blobPath(l.Digest, false))); err != nil {
+			return "", err
+		}
+		if err := d.sendBytes(path.Join(layerID, legacyVersionFileName), []byte("1.0")); err != nil {
+			return "", err
+		}
+		layerConfig := map[string]interface{}{"id": layerID}
+		if lastLayerID != "" {
+			layerConfig["parent"] = lastLayerID
+		}
+		if i == len(layers)-1 {
+			for _, field := range legacyLayerConfigFields {
+				if value, ok := config[field]; ok {
+					layerConfig[field] = value
+				}
+			}
+		}
+		layerConfigBytes, err := json.Marshal(layerConfig)
+		if err != nil {
+			return "", err
+		}
+		if err := d.sendBytes(path.Join(layerID, legacyConfigFileName), layerConfigBytes); err != nil {
+			return "", err
+		}
+		lastLayerID = layerID
+	}
+	return lastLayerID, nil
+}
+
+// writeLegacyRepositories writes the legacy "repositories" file, mapping each of d.repoTags to lastLayerID.
+func (d *Destination) writeLegacyRepositories(lastLayerID string) error {
+	repositories := map[string]map[string]string{}
+	for _, tag := range d.repoTags {
+		named, err := reference.ParseNamed(tag)
+		if err != nil {
+			return fmt.Errorf("Invalid tag %#v: %v", tag, err)
+		}
+		tagged, ok := named.(reference.NamedTagged)
+		if !ok {
+			return fmt.Errorf("Invalid tag %#v: not a tagged reference", tag)
+		}
+		if repositories[tagged.Name()] == nil {
+			repositories[tagged.Name()] = map[string]string{}
+		}
+		repositories[tagged.Name()][tagged.Tag()] = lastLayerID
+	}
+	repositoriesBytes, err := json.Marshal(repositories)
+	if err != nil {
+		return err
+	}
+	return d.sendBytes(legacyRepositoriesFileName, repositoriesBytes)
+}
+
+type tarFI struct {
+	path string
+	size int64
+}
+
+func (t *tarFI) Name() string {
+	return t.path
+}
+func (t *tarFI) Size() int64 {
+	return t.size
+}
+func (t *tarFI) Mode() os.FileMode {
+	return 0444
+}
+func (t *tarFI) ModTime() time.Time {
+	return time.Unix(0, 0)
+}
+func (t *tarFI) IsDir() bool {
+	return false
+}
+func (t *tarFI) Sys() interface{} {
+	return nil
+}
+
+// sendSymlink sends a symbolic link to target into the tar stream.
+func (d *Destination) sendSymlink(path string, target string) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     path,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  time.Unix(0, 0),
+	}
+	logrus.Debugf("Sending as tar link %s -> %s", path, target)
+	return d.tar.WriteHeader(hdr)
+}
+
+// sendBytes sends contents as a file into the tar stream.
+func (d *Destination) sendBytes(path string, contents []byte) error {
+	return d.sendFile(path, int64(len(contents)), bytes.NewReader(contents))
+}
+
+// sendFile sends a file into the tar stream.
+func (d *Destination) sendFile(path string, expectedSize int64, stream io.Reader) error {
+	hdr, err := tar.FileInfoHeader(&tarFI{path: path, size: expectedSize}, "")
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Sending as tar file %s", path)
+	if err := d.tar.WriteHeader(hdr); err != nil {
+		return err
+	}
+	size, err := io.Copy(d.tar, stream)
+	if err != nil {
+		return err
+	}
+	if size != expectedSize {
+		return fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", path, expectedSize, size)
+	}
+	return nil
+}
+
+// PutSignatures adds the given signatures to the docker tarfile (currently not
+// supported). MUST be called after PutManifest (signatures reference manifest
+// contents)
+func (d *Destination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	if len(signatures) != 0 {
+		return errors.New("Storing signatures for docker tar files is not supported")
+	}
+	return nil
+}
+
+// Commit finishes writing the tarball.  It does not close the underlying io.Writer.
+func (d *Destination) Commit(ctx context.Context) error {
+	return d.tar.Close()
+}
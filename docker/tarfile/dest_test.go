@@ -0,0 +1,169 @@
+package tarfile
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDestinationPutBlob(t *testing.T) {
+	var buf bytes.Buffer
+	dest := NewDestination(nil, &buf, nil, false)
+	blob := []byte("layer contents")
+	blobDigest := digest.FromBytes(blob)
+
+	reused, _, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: blobDigest, Size: -1}, false)
+	require.NoError(t, err)
+	assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, info)
+
+	// A blob already in the stream is not sent again.
+	reused, info, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache, false)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, info)
+
+	require.NoError(t, dest.Commit(context.Background()))
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, blobDigest.Hex()+".tar", hdr.Name)
+	contents, err := ioutil.ReadAll(tr)
+	require.NoError(t, err)
+	assert.Equal(t, blob, contents)
+	_, err = tr.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+// writeTestImage writes an image with config and layers to a new archive at path, using a Destination.
+// It returns the manifest of the image.
+func writeTestImage(t *testing.T, path string, repoTags []string, legacyLayout bool, config []byte, layers ...[]byte) *manifest.Schema2 {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	dest := NewDestination(nil, f, repoTags, legacyLayout)
+
+	layerDescriptors := []manifest.Schema2Descriptor{}
+	for _, layer := range layers {
+		inputInfo := types.BlobInfo{Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+		reused, info, err := dest.TryReusingBlob(context.Background(), inputInfo, blobinfocache.NoCache, false)
+		require.NoError(t, err)
+		if !reused {
+			info, err = dest.PutBlob(context.Background(), bytes.NewReader(layer), inputInfo, false)
+			require.NoError(t, err)
+		}
+		layerDescriptors = append(layerDescriptors, manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2LayerMediaType, Size: info.Size, Digest: info.Digest})
+	}
+	configInfo, err := dest.PutBlob(context.Background(), bytes.NewReader(config), types.BlobInfo{Digest: digest.FromBytes(config), Size: -1}, true)
+	require.NoError(t, err)
+	m := manifest.Schema2FromComponents(manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2ConfigMediaType, Size: configInfo.Size, Digest: configInfo.Digest}, layerDescriptors)
+	manifestBlob, err := m.Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), manifestBlob, nil))
+	require.NoError(t, dest.PutSignatures(context.Background(), [][]byte{}, nil))
+	require.NoError(t, dest.Commit(context.Background()))
+	return m
+}
+
+func TestDestinationRoundTrip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-tarfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	layers := [][]byte{[]byte("base layer"), []byte("top layer"), []byte("base layer")}
+	diffIDs := []digest.Digest{}
+	for _, l := range layers {
+		diffIDs = append(diffIDs, digest.FromBytes(l))
+	}
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       map[string]interface{}{"Cmd": []string{"/bin/sh"}},
+		"rootfs":       rootFS{Type: "layers", DiffIDs: diffIDs},
+	})
+	require.NoError(t, err)
+
+	for _, legacyLayout := range []bool{false, true} {
+		path := filepath.Join(tmpDir, "archive.tar")
+		m := writeTestImage(t, path, []string{"busybox:latest"}, legacyLayout, config, layers...)
+
+		src, err := NewSourceFromFile(nil, path, nil, -1)
+		require.NoError(t, err)
+		items, err := src.LoadTarManifest()
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		assert.Equal(t, []string{"busybox:latest"}, items[0].RepoTags)
+		manifestBlob, _, err := src.GetManifest(context.Background(), nil)
+		require.NoError(t, err)
+		readManifest, err := manifest.Schema2FromManifest(manifestBlob)
+		require.NoError(t, err)
+		assert.Equal(t, m.ConfigDescriptor.Digest, readManifest.ConfigDescriptor.Digest)
+		assert.Equal(t, m.LayersDescriptors, readManifest.LayersDescriptors)
+		for _, info := range []types.BlobInfo{{Digest: digest.FromBytes(config)}, {Digest: diffIDs[0]}, {Digest: diffIDs[1]}} {
+			blob, _, err := src.GetBlob(context.Background(), info, blobinfocache.NoCache)
+			require.NoError(t, err)
+			contents, err := ioutil.ReadAll(blob)
+			blob.Close()
+			require.NoError(t, err)
+			assert.Equal(t, info.Digest, digest.FromBytes(contents))
+		}
+
+		repositoriesBytes, err := src.readTarComponent(legacyRepositoriesFileName)
+		if !legacyLayout {
+			assert.Error(t, err)
+			src.Close()
+			require.NoError(t, os.Remove(path))
+			continue
+		}
+		require.NoError(t, err)
+		// Walk the parent links from the layer in "repositories" down to the base layer.
+		var repositories map[string]map[string]string
+		require.NoError(t, json.Unmarshal(repositoriesBytes, &repositories))
+		require.Contains(t, repositories, "busybox")
+		layerID := repositories["busybox"]["latest"]
+		seenIDs := map[string]struct{}{}
+		for i := len(layers) - 1; i >= 0; i-- {
+			require.NotEmpty(t, layerID)
+			assert.NotContains(t, seenIDs, layerID)
+			seenIDs[layerID] = struct{}{}
+			version, err := src.readTarComponent(layerID + "/" + legacyVersionFileName)
+			require.NoError(t, err)
+			assert.Equal(t, "1.0", string(version))
+			layer, err := src.readTarComponent(layerID + "/" + legacyLayerFileName)
+			require.NoError(t, err)
+			assert.Equal(t, layers[i], layer)
+			layerConfigBytes, err := src.readTarComponent(layerID + "/" + legacyConfigFileName)
+			require.NoError(t, err)
+			var layerConfig map[string]interface{}
+			require.NoError(t, json.Unmarshal(layerConfigBytes, &layerConfig))
+			assert.Equal(t, layerID, layerConfig["id"])
+			if i == len(layers)-1 {
+				assert.Equal(t, "amd64", layerConfig["architecture"])
+				assert.Equal(t, "linux", layerConfig["os"])
+				assert.NotContains(t, layerConfig, "created")
+			} else {
+				assert.NotContains(t, layerConfig, "os")
+			}
+			parent, _ := layerConfig["parent"].(string)
+			layerID = parent
+		}
+		assert.Empty(t, layerID) // The base layer has no parent
+		src.Close()
+	}
+}
@@ -0,0 +1,368 @@
+package tarfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/pkg/tarindex"
+	"github.com/containers/image/pkg/tmpdir"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Source is a partial implementation of types.ImageSource for reading from tarPath,
+// a tar file in the docker save format, which may contain more than one image.
+type Source struct {
+	tarPath              string
+	removeTarPathOnClose bool // Remove temp file on close if true
+	// Which image within the file to use; see NewSourceFromFile.
+	ref         reference.NamedTagged
+	sourceIndex int
+	// archive is tarPath, opened for reading; files within it are located using index.
+	archive *os.File
+	index   *tarindex.Index
+	// cacheMutex serializes ensureCachedDataIsPresent, so that GetBlob can be called concurrently.
+	cacheMutex sync.Mutex
+	// The following data is only available after ensureCachedDataIsPresent() succeeds
+	tarManifest       *ManifestItem // nil if not available yet.
+	configBytes       []byte
+	configDigest      digest.Digest
+	orderedDiffIDList []digest.Digest
+	knownLayers       map[digest.Digest]*layerInfo
+	// Other state
+	generatedManifest []byte // Private cache for GetManifest(), nil if not set yet.
+}
+
+type layerInfo struct {
+	path string
+	size int64
+}
+
+// newSourceFromTarPath returns a tarfile.Source for the image selected by ref and sourceIndex (see NewSourceFromFile)
+// in the uncompressed tar file at tarPath.  The file is read once to locate its contents, which are then read directly from tarPath.
+func newSourceFromTarPath(tarPath string, removeTarPathOnClose bool, ref reference.NamedTagged, sourceIndex int) (*Source, error) {
+	archive, err := os.Open(tarPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening file %s: %v", tarPath, err)
+	}
+	index, err := tarindex.New(archive)
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	return &Source{
+		tarPath:              tarPath,
+		removeTarPathOnClose: removeTarPathOnClose,
+		ref:                  ref,
+		sourceIndex:          sourceIndex,
+		archive:              archive,
+		index:                index,
+	}, nil
+}
+
+// NewSourceFromFile returns a tarfile.Source for the specified path, which may be compressed;
+// a decompressed copy of a compressed file is stored in a temporary directory, as configured by sys.
+// If ref is not nil, the image tagged with ref is used; otherwise, if sourceIndex is not -1,
+// the image at that (0-based) index in the manifest.json file is used;
+// otherwise, the file must contain exactly one image.
+// The caller must call .Close() on the returned Source.
+//
+// It would be great if we were able to stream the input tar as it is being
+// sent; but Docker sends the top-level manifest, which determines which paths
+// to look for, at the end, so we need random access to the file.
+// (We could, perhaps, expect an exact sequence, assume that the first plaintext file
+// is the config, and that the following len(RootFS) files are the layers, but that feels
+// way too brittle.)  An uncompressed file is read only once, to locate the files within it,
+// and is not copied.
+func NewSourceFromFile(sys *types.SystemContext, path string, ref reference.NamedTagged, sourceIndex int) (*Source, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	// If the file is not compressed, use it directly; a decompressed copy is needed otherwise.
+	stream, algorithm, err := compression.DetectCompression(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error detecting compression for file %q: %v", path, err)
+	}
+	defer stream.Close()
+	if !algorithm.IsCompressed() {
+		return newSourceFromTarPath(path, false, ref, sourceIndex)
+	}
+	return newSourceFromStream(sys, stream, ref, sourceIndex)
+}
+
+// NewSourceFromStream returns a tarfile.Source for the only image in the (uncompressed) tar stream inputStream.
+// The stream is copied into a temporary file, in a directory configured by sys.
+// The caller must call .Close() on the returned Source.
+func NewSourceFromStream(sys *types.SystemContext, inputStream io.Reader) (*Source, error) {
+	return newSourceFromStream(sys, inputStream, nil, -1)
+}
+
+// newSourceFromStream returns a tarfile.Source for the image selected by ref and sourceIndex (see NewSourceFromFile)
+// in the (uncompressed) tar stream inputStream.
+func newSourceFromStream(sys *types.SystemContext, inputStream io.Reader, ref reference.NamedTagged, sourceIndex int) (*Source, error) {
+	tarCopyFile, err := ioutil.TempFile(tmpdir.TemporaryDirectoryForBigFiles(sys), "docker-tar")
+	if err != nil {
+		return nil, err
+	}
+	defer tarCopyFile.Close()
+
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			os.Remove(tarCopyFile.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tarCopyFile, inputStream); err != nil {
+		return nil, err
+	}
+
+	src, err := newSourceFromTarPath(tarCopyFile.Name(), true, ref, sourceIndex)
+	if err != nil {
+		return nil, err
+	}
+	succeeded = true
+	return src, nil
+}
+
+// Close removes resources associated with an initialized Source, if any.
+func (s *Source) Close() {
+	s.archive.Close()
+	if s.removeTarPathOnClose {
+		_ = os.Remove(s.tarPath)
+	}
+}
+
+// openTarComponent returns a reader for the specific file within the archive, and its size.
+// Symbolic links (which docker save uses for repeated layers) are followed.
+func (s *Source) openTarComponent(componentPath string) (*io.SectionReader, int64, error) {
+	r, header, err := s.index.Open(componentPath)
+	if err != nil {
+		return nil, -1, err
+	}
+	return r, header.Size, nil
+}
+
+// readTarComponent returns full contents of componentPath.
+func (s *Source) readTarComponent(path string) ([]byte, error) {
+	file, _, err := s.openTarComponent(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading tar component %s: %v", path, err)
+	}
+	bytes, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return bytes, nil
+}
+
+// ensureCachedDataIsPresent loads data necessary for any of the public accessors.
+func (s *Source) ensureCachedDataIsPresent() error {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	if s.tarManifest != nil {
+		return nil
+	}
+
+	// Read and parse manifest.json
+	items, err := s.LoadTarManifest()
+	if err != nil {
+		return err
+	}
+	tarManifest, err := s.selectManifestItem(items)
+	if err != nil {
+		return err
+	}
+
+	// Read and parse config.
+	configBytes, err := s.readTarComponent(tarManifest.Config)
+	if err != nil {
+		return err
+	}
+	var parsedConfig image // Most fields ommitted, we only care about layer DiffIDs.
+	if err := json.Unmarshal(configBytes, &parsedConfig); err != nil {
+		return fmt.Errorf("Error decoding tar config %s: %v", tarManifest.Config, err)
+	}
+	if parsedConfig.RootFS == nil {
+		return fmt.Errorf("Invalid image config %s: rootfs is missing", tarManifest.Config)
+	}
+
+	knownLayers, err := s.prepareLayerData(tarManifest, &parsedConfig)
+	if err != nil {
+		return err
+	}
+
+	// Success; commit.
+	s.tarManifest = tarManifest
+	s.configBytes = configBytes
+	s.configDigest = digest.FromBytes(configBytes)
+	s.orderedDiffIDList = parsedConfig.RootFS.DiffIDs
+	s.knownLayers = knownLayers
+	return nil
+}
+
+// LoadTarManifest loads and decodes the manifest.json, which describes all images in the file.
+func (s *Source) LoadTarManifest() ([]ManifestItem, error) {
+	// FIXME? Do we need to deal with the legacy format?
+	bytes, err := s.readTarComponent(ManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+	var items []ManifestItem
+	if err := json.Unmarshal(bytes, &items); err != nil {
+		return nil, fmt.Errorf("Error decoding tar manifest.json: %v", err)
+	}
+	return items, nil
+}
+
+// selectManifestItem returns the element of items selected by s.ref and s.sourceIndex.
+func (s *Source) selectManifestItem(items []ManifestItem) (*ManifestItem, error) {
+	switch {
+	case s.ref != nil:
+		for i := range items {
+			for _, tag := range items[i].RepoTags {
+				named, err := reference.ParseNamed(tag)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid tag %#v in manifest.json item @%d: %v", tag, i, err)
+				}
+				tagged, ok := named.(reference.NamedTagged)
+				if !ok {
+					return nil, fmt.Errorf("Invalid tag %#v in manifest.json item @%d: not a tagged reference", tag, i)
+				}
+				if tagged.FullName() == s.ref.FullName() && tagged.Tag() == s.ref.Tag() {
+					return &items[i], nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("Tag %s not found", s.ref.String())
+	case s.sourceIndex != -1:
+		if s.sourceIndex < 0 || s.sourceIndex >= len(items) {
+			return nil, fmt.Errorf("Invalid source index @%d, only %d manifest items available", s.sourceIndex, len(items))
+		}
+		return &items[s.sourceIndex], nil
+	default:
+		if len(items) != 1 {
+			return nil, fmt.Errorf("Unexpected tar manifest.json: expected 1 item, got %d", len(items))
+		}
+		return &items[0], nil
+	}
+}
+
+func (s *Source) prepareLayerData(tarManifest *ManifestItem, parsedConfig *image) (map[digest.Digest]*layerInfo, error) {
+	// Collect layer data available in manifest and config.
+	if len(tarManifest.Layers) != len(parsedConfig.RootFS.DiffIDs) {
+		return nil, fmt.Errorf("Inconsistent layer count: %d in manifest, %d in config", len(tarManifest.Layers), len(parsedConfig.RootFS.DiffIDs))
+	}
+	knownLayers := map[digest.Digest]*layerInfo{}
+	layerPaths := map[string]struct{}{}
+	for i, diffID := range parsedConfig.RootFS.DiffIDs {
+		if err := diffID.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid layer DiffID %q: %v", diffID, err)
+		}
+		if _, ok := knownLayers[diffID]; ok {
+			// Apparently it really can happen that a single image contains the same layer diff more than once.
+			// In that case, the diffID validation ensures that both layers truly are the same, and it should not matter
+			// which of the tarManifest.Layers paths is used; (docker save) actually makes the duplicates symlinks to the original.
+			continue
+		}
+		layerPath := tarManifest.Layers[i]
+		if _, ok := layerPaths[layerPath]; ok {
+			return nil, fmt.Errorf("Layer tarfile %s used for two different DiffID values", layerPath)
+		}
+		layerPaths[layerPath] = struct{}{}
+		_, size, err := s.openTarComponent(layerPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("Layer tarfile %s is missing in the tarball", layerPath)
+			}
+			return nil, err
+		}
+		knownLayers[diffID] = &layerInfo{
+			path: layerPath,
+			size: size,
+		}
+	}
+	return knownLayers, nil
+}
+
+// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
+// It may use a remote (= slow) service.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *Source) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		// How did we even get here? GetManifest(ctx, nil) has returned a manifest.DockerV2Schema2MediaType.
+		return nil, "", fmt.Errorf("Manifests list are not supported by this transport")
+	}
+	if s.generatedManifest == nil {
+		if err := s.ensureCachedDataIsPresent(); err != nil {
+			return nil, "", err
+		}
+		layers := []manifest.Schema2Descriptor{}
+		for _, diffID := range s.orderedDiffIDList {
+			li, ok := s.knownLayers[diffID]
+			if !ok {
+				return nil, "", fmt.Errorf("Internal inconsistency: Information about layer %s missing", diffID)
+			}
+			layers = append(layers, manifest.Schema2Descriptor{
+				Digest:    diffID, // diffID is a digest of the uncompressed tarball
+				MediaType: manifest.DockerV2Schema2LayerMediaType,
+				Size:      li.size,
+			})
+		}
+		m := manifest.Schema2FromComponents(manifest.Schema2Descriptor{
+			MediaType: manifest.DockerV2Schema2ConfigMediaType,
+			Size:      int64(len(s.configBytes)),
+			Digest:    s.configDigest,
+		}, layers)
+		manifestBytes, err := m.Serialize()
+		if err != nil {
+			return nil, "", err
+		}
+		s.generatedManifest = manifestBytes
+	}
+	return s.generatedManifest, manifest.DockerV2Schema2MediaType, nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *Source) HasThreadSafeGetBlob() bool {
+	return true
+}
+
+// GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
+func (s *Source) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if err := s.ensureCachedDataIsPresent(); err != nil {
+		return nil, 0, err
+	}
+
+	if info.Digest == s.configDigest { // FIXME? Implement a more general algorithm matching instead of assuming sha256.
+		return ioutil.NopCloser(bytes.NewReader(s.configBytes)), int64(len(s.configBytes)), nil
+	}
+
+	if li, ok := s.knownLayers[info.Digest]; ok { // diffID is a digest of the uncompressed tarball,
+		stream, _, err := s.openTarComponent(li.path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return ioutil.NopCloser(stream), li.size, nil
+	}
+
+	return nil, 0, fmt.Errorf("Unknown blob %s", info.Digest)
+}
+
+// GetSignatures returns the image's signatures.  It may use a remote (= slow) service.
+func (s *Source) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	return [][]byte{}, nil
+}
@@ -0,0 +1,201 @@
+package tarfile
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage describes an image written by writeTestArchive.
+type testImage struct {
+	repoTags []string
+	layer    []byte
+}
+
+// writeTestArchive writes a docker save-formatted archive containing images to path, gzip-compressed if compress.
+// It returns the digests of the layers of the images.
+func writeTestArchive(t *testing.T, path string, images []testImage, compress bool) []digest.Digest {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	addFile := func(name string, contents []byte) {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+		require.NoError(t, err)
+		_, err = tw.Write(contents)
+		require.NoError(t, err)
+	}
+
+	items := []ManifestItem{}
+	digests := []digest.Digest{}
+	for _, img := range images {
+		layerDigest := digest.FromBytes(img.layer)
+		layerPath := layerDigest.Hex() + "/layer.tar"
+		addFile(layerPath, img.layer)
+		config, err := json.Marshal(image{RootFS: &rootFS{Type: "layers", DiffIDs: []digest.Digest{layerDigest}}})
+		require.NoError(t, err)
+		configPath := digest.FromBytes(config).Hex() + ".json"
+		addFile(configPath, config)
+		items = append(items, ManifestItem{Config: configPath, RepoTags: img.repoTags, Layers: []string{layerPath}})
+		digests = append(digests, layerDigest)
+	}
+	manifestBytes, err := json.Marshal(items)
+	require.NoError(t, err)
+	addFile(ManifestFileName, manifestBytes)
+	require.NoError(t, tw.Close())
+
+	data := buf.Bytes()
+	if compress {
+		var compressed bytes.Buffer
+		gw := gzip.NewWriter(&compressed)
+		_, err := gw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		data = compressed.Bytes()
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	require.NoError(t, err)
+	return digests
+}
+
+func TestSourceSelection(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-tarfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	images := []testImage{
+		{[]string{"busybox:latest", "busybox:1"}, []byte("busybox layer")},
+		{[]string{"example.com/ns/alpine:3"}, []byte("alpine layer")},
+		{nil, []byte("untagged layer")},
+	}
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(tmpDir, "archive.tar")
+		digests := writeTestArchive(t, path, images, compress)
+
+		for _, c := range []struct {
+			ref         string
+			sourceIndex int
+			expected    int // Index into images, or -1 if an error is expected
+		}{
+			{"busybox:latest", -1, 0},
+			{"docker.io/library/busybox:1", -1, 0},
+			{"example.com/ns/alpine:3", -1, 1},
+			{"busybox:2", -1, -1},
+			{"alpine:3", -1, -1},
+			{"", 0, 0},
+			{"", 1, 1},
+			{"", 2, 2},
+			{"", 3, -1},
+			{"", -1, -1}, // More than one image
+		} {
+			var ref reference.NamedTagged
+			if c.ref != "" {
+				named, err := reference.ParseNamed(c.ref)
+				require.NoError(t, err, c.ref)
+				ref = named.(reference.NamedTagged)
+			}
+			src, err := NewSourceFromFile(nil, path, ref, c.sourceIndex)
+			require.NoError(t, err)
+			_, _, err = src.GetManifest(context.Background(), nil)
+			if c.expected == -1 {
+				assert.Error(t, err, "%#v", c)
+				src.Close()
+				continue
+			}
+			require.NoError(t, err, "%#v", c)
+			blob, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: digests[c.expected], Size: -1}, blobinfocache.NoCache)
+			require.NoError(t, err, "%#v", c)
+			contents, err := ioutil.ReadAll(blob)
+			blob.Close()
+			require.NoError(t, err, "%#v", c)
+			assert.Equal(t, images[c.expected].layer, contents, "%#v", c)
+			assert.Equal(t, int64(len(contents)), size, "%#v", c)
+			src.Close()
+		}
+
+		src, err := NewSourceFromFile(nil, path, nil, -1)
+		require.NoError(t, err)
+		items, err := src.LoadTarManifest()
+		require.NoError(t, err)
+		assert.Len(t, items, len(images))
+		src.Close()
+		_, err = os.Stat(path)
+		assert.NoError(t, err) // The user-provided file is not removed by Close()
+	}
+
+	// A single-image archive does not need a selection.
+	path := filepath.Join(tmpDir, "single.tar")
+	writeTestArchive(t, path, images[:1], false)
+	src, err := NewSourceFromFile(nil, path, nil, -1)
+	require.NoError(t, err)
+	defer src.Close()
+	_, _, err = src.GetManifest(context.Background(), nil)
+	assert.NoError(t, err)
+}
+
+func TestSourceSymlinkedLayer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "docker-tarfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// docker save may store a layer as a symlink to a file in another directory.
+	layer := []byte("symlinked layer")
+	layerDigest := digest.FromBytes(layer)
+	config, err := json.Marshal(image{RootFS: &rootFS{Type: "layers", DiffIDs: []digest.Digest{layerDigest}}})
+	require.NoError(t, err)
+	configPath := digest.FromBytes(config).Hex() + ".json"
+	manifestBytes, err := json.Marshal([]ManifestItem{{Config: configPath, Layers: []string{"a/layer.tar"}}})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		hdr      tar.Header
+		contents []byte
+	}{
+		{tar.Header{Name: "a/layer.tar", Typeflag: tar.TypeSymlink, Linkname: "../b/layer.tar"}, nil},
+		{tar.Header{Name: "b/layer.tar", Typeflag: tar.TypeReg, Mode: 0644}, layer},
+		{tar.Header{Name: configPath, Typeflag: tar.TypeReg, Mode: 0644}, config},
+		{tar.Header{Name: ManifestFileName, Typeflag: tar.TypeReg, Mode: 0644}, manifestBytes},
+	} {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.contents))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write(e.contents)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	path := filepath.Join(tmpDir, "archive.tar")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+	src, err := NewSourceFromFile(nil, path, nil, -1)
+	require.NoError(t, err)
+	defer src.Close()
+	assert.True(t, src.HasThreadSafeGetBlob())
+	manifestBlob, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	m, err := manifest.Schema2FromManifest(manifestBlob)
+	require.NoError(t, err)
+	require.Len(t, m.LayersDescriptors, 1)
+	assert.Equal(t, int64(len(layer)), m.LayersDescriptors[0].Size)
+
+	blob, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: layerDigest, Size: -1}, blobinfocache.NoCache)
+	require.NoError(t, err)
+	defer blob.Close()
+	contents, err := ioutil.ReadAll(blob)
+	require.NoError(t, err)
+	assert.Equal(t, layer, contents)
+	assert.Equal(t, int64(len(layer)), size)
+}
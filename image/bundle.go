@@ -0,0 +1,300 @@
+package image
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/pkg/rootfs"
+	"github.com/containers/image/types"
+	"github.com/docker/engine-api/types/strslice"
+)
+
+// BundleOptions controls the OCI runtime bundles created by UnpackBundle.
+type BundleOptions struct {
+	// UIDMappings and GIDMappings, if not empty, map the user and group IDs used in the image to host IDs: the files
+	// in the root filesystem are owned by the mapped IDs, and the container runs in a user namespace using the mappings.
+	// For rootless use, map the IDs used in the image to the current user, e.g. {ContainerID: 0, HostID: os.Getuid(), Size: 1}.
+	UIDMappings []rootfs.IDMapping
+	GIDMappings []rootfs.IDMapping
+}
+
+// bundleImageConfig is the subset of the image configuration used by UnpackBundle.
+type bundleImageConfig struct {
+	User       string            `json:"User,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	Entrypoint strslice.StrSlice `json:"Entrypoint,omitempty"`
+	Cmd        strslice.StrSlice `json:"Cmd,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+// Based on github.com/opencontainers/runtime-spec/specs-go/config.go
+// MOST CONTENT OMITTED AS UNNECESSARY
+type runtimeSpec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *runtimeProcess   `json:"process,omitempty"`
+	Root        *runtimeRoot      `json:"root,omitempty"`
+	Mounts      []runtimeMount    `json:"mounts,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *runtimeLinux     `json:"linux,omitempty"`
+}
+
+type runtimeProcess struct {
+	User            runtimeUser `json:"user"`
+	Args            []string    `json:"args,omitempty"`
+	Env             []string    `json:"env,omitempty"`
+	Cwd             string      `json:"cwd"`
+	NoNewPrivileges bool        `json:"noNewPrivileges,omitempty"`
+}
+
+type runtimeUser struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+type runtimeRoot struct {
+	Path string `json:"path"`
+}
+
+type runtimeMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type runtimeLinux struct {
+	UIDMappings []rootfs.IDMapping `json:"uidMappings,omitempty"`
+	GIDMappings []rootfs.IDMapping `json:"gidMappings,omitempty"`
+	Namespaces  []runtimeNamespace `json:"namespaces,omitempty"`
+}
+
+type runtimeNamespace struct {
+	Type string `json:"type"`
+}
+
+const (
+	// runtimeSpecVersion is the version of the OCI runtime specification used for the generated configuration.
+	runtimeSpecVersion = "1.0.0"
+	// defaultPathEnv is the PATH used if the image does not set one.
+	defaultPathEnv = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// UnpackBundle unpacks img, with layers read from src, into an OCI runtime bundle in dir: the flattened root filesystem
+// in dir/rootfs, and a config.json generated from the image configuration.  dir/rootfs must not exist yet.
+// options may be nil.
+func UnpackBundle(ctx context.Context, src types.ImageSource, img types.Image, dir string, options *BundleOptions) error {
+	if options == nil {
+		options = &BundleOptions{}
+	}
+	var config bundleImageConfig
+	if err := parseContainerConfig(ctx, img, &config); err != nil {
+		return fmt.Errorf("Error reading image configuration: %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	rootfsDir := filepath.Join(dir, "rootfs")
+	if err := os.Mkdir(rootfsDir, 0755); err != nil {
+		return err
+	}
+	if err := unpackRootfs(ctx, src, img, rootfsDir, options); err != nil {
+		return err
+	}
+
+	spec, err := bundleRuntimeSpec(rootfsDir, &config, options)
+	if err != nil {
+		return err
+	}
+	specBytes, err := json.MarshalIndent(spec, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "config.json"), specBytes, 0644)
+}
+
+// unpackRootfs extracts the flattened root filesystem of img, with layers read from src, into rootfsDir.
+func unpackRootfs(ctx context.Context, src types.ImageSource, img types.Image, rootfsDir string, options *BundleOptions) error {
+	builder := rootfs.NewBuilder(rootfsDir, options.UIDMappings, options.GIDMappings)
+	reader, writer := io.Pipe()
+	flattenErr := make(chan error, 1)
+	go func() {
+		err := FlattenedRootfs(ctx, src, img, writer)
+		writer.CloseWithError(err)
+		flattenErr <- err
+	}()
+	err := builder.ApplyLayer(reader)
+	if err == nil { // Consume the end of the archive, so that FlattenedRootfs can finish.
+		_, err = io.Copy(ioutil.Discard, reader)
+	}
+	reader.CloseWithError(err)
+	if fErr := <-flattenErr; err == nil && fErr != nil {
+		return fErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error extracting the root filesystem: %v", err)
+	}
+	return builder.Finish()
+}
+
+// bundleRuntimeSpec returns the runtime configuration for a bundle of an image with config, unpacked in rootfsDir.
+func bundleRuntimeSpec(rootfsDir string, config *bundleImageConfig, options *BundleOptions) (*runtimeSpec, error) {
+	user, err := resolveImageUser(rootfsDir, config.User)
+	if err != nil {
+		return nil, err
+	}
+	env := config.Env
+	hasPath := false
+	for _, e := range env {
+		if strings.HasPrefix(e, "PATH=") {
+			hasPath = true
+		}
+	}
+	if !hasPath {
+		env = append([]string{defaultPathEnv}, env...)
+	}
+	cwd := config.WorkingDir
+	if cwd == "" {
+		cwd = "/"
+	}
+	userNamespace := len(options.UIDMappings) != 0 || len(options.GIDMappings) != 0
+
+	spec := &runtimeSpec{
+		Version: runtimeSpecVersion,
+		Process: &runtimeProcess{
+			User:            user,
+			Args:            append(append([]string{}, config.Entrypoint...), config.Cmd...),
+			Env:             env,
+			Cwd:             cwd,
+			NoNewPrivileges: true,
+		},
+		Root:        &runtimeRoot{Path: "rootfs"},
+		Annotations: config.Labels,
+		Linux:       &runtimeLinux{},
+	}
+	devptsOptions := []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}
+	if !userNamespace {
+		devptsOptions = append(devptsOptions, "gid=5")
+	}
+	spec.Mounts = []runtimeMount{
+		{Destination: "/proc", Type: "proc", Source: "proc"},
+		{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+		{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: devptsOptions},
+		{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+		{Destination: "/dev/mqueue", Type: "mqueue", Source: "mqueue", Options: []string{"nosuid", "noexec", "nodev"}},
+	}
+	namespaces := []string{"pid", "ipc", "uts", "mount"}
+	if userNamespace {
+		// Without a network namespace owned by the user namespace, sysfs can not be mounted; use the host's one instead.
+		spec.Mounts = append(spec.Mounts, runtimeMount{Destination: "/sys", Type: "none", Source: "/sys", Options: []string{"rbind", "nosuid", "noexec", "nodev", "ro"}})
+		namespaces = append(namespaces, "user")
+		spec.Linux.UIDMappings = options.UIDMappings
+		spec.Linux.GIDMappings = options.GIDMappings
+	} else {
+		spec.Mounts = append(spec.Mounts, runtimeMount{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}})
+		namespaces = append(namespaces, "network")
+	}
+	for _, ns := range namespaces {
+		spec.Linux.Namespaces = append(spec.Linux.Namespaces, runtimeNamespace{Type: ns})
+	}
+	return spec, nil
+}
+
+// resolveImageUser returns the user and group IDs for user, the User field of an image configuration
+// (user, uid, user:group, uid:gid, …), looking up names in the root filesystem in rootfsDir.
+func resolveImageUser(rootfsDir, user string) (runtimeUser, error) {
+	res := runtimeUser{}
+	if user == "" {
+		return res, nil
+	}
+	userPart, groupPart := user, ""
+	if i := strings.IndexByte(user, ':'); i != -1 {
+		userPart, groupPart = user[:i], user[i+1:]
+	}
+
+	if uid, err := strconv.Atoi(userPart); err == nil {
+		res.UID = uid
+		// Use the primary group of the user, if it is known.
+		if entry, err := findIDFileEntry(filepath.Join(rootfsDir, "etc/passwd"), func(fields []string) bool {
+			return len(fields) > 3 && fields[2] == userPart
+		}); err == nil && entry != nil {
+			res.GID, _ = strconv.Atoi(entry[3])
+		}
+	} else {
+		entry, err := findIDFileEntry(filepath.Join(rootfsDir, "etc/passwd"), func(fields []string) bool {
+			return len(fields) > 3 && fields[0] == userPart
+		})
+		if err != nil {
+			return res, err
+		}
+		if entry == nil {
+			return res, fmt.Errorf("Unknown user %q in the image configuration", userPart)
+		}
+		if res.UID, err = strconv.Atoi(entry[2]); err != nil {
+			return res, fmt.Errorf("Invalid UID of user %q: %v", userPart, err)
+		}
+		if res.GID, err = strconv.Atoi(entry[3]); err != nil {
+			return res, fmt.Errorf("Invalid GID of user %q: %v", userPart, err)
+		}
+	}
+
+	if groupPart != "" {
+		if gid, err := strconv.Atoi(groupPart); err == nil {
+			res.GID = gid
+		} else {
+			entry, err := findIDFileEntry(filepath.Join(rootfsDir, "etc/group"), func(fields []string) bool {
+				return len(fields) > 2 && fields[0] == groupPart
+			})
+			if err != nil {
+				return res, err
+			}
+			if entry == nil {
+				return res, fmt.Errorf("Unknown group %q in the image configuration", groupPart)
+			}
+			if res.GID, err = strconv.Atoi(entry[2]); err != nil {
+				return res, fmt.Errorf("Invalid GID of group %q: %v", groupPart, err)
+			}
+		}
+	}
+	return res, nil
+}
+
+// findIDFileEntry returns the colon-separated fields of the first line of path, a passwd(5) or group(5) file, matching match,
+// or nil if there is no such line or the file does not exist.
+// Only regular files in a directory are read, so that a symbolic link in the image can not refer to a file outside of the root filesystem.
+func findIDFileEntry(path string, match func(fields []string) bool) ([]string, error) {
+	for i, p := range []string{filepath.Dir(path), path} {
+		fi, err := os.Lstat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if (i == 0 && !fi.IsDir()) || (i == 1 && !fi.Mode().IsRegular()) {
+			return nil, nil
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if match(fields) {
+			return fields, nil
+		}
+	}
+	return nil, scanner.Err()
+}
@@ -0,0 +1,132 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/pkg/rootfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readBundleSpec returns the runtime configuration in the bundle in dir.
+func readBundleSpec(t *testing.T, dir string) runtimeSpec {
+	specBytes, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+	var spec runtimeSpec
+	require.NoError(t, json.Unmarshal(specBytes, &spec))
+	return spec
+}
+
+func TestUnpackBundle(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "unpack-bundle")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	layers := [][]byte{
+		rootfsTestLayer(t,
+			rootfsTestEntry{name: "etc/", typeflag: tar.TypeDir},
+			rootfsTestEntry{name: "etc/passwd", typeflag: tar.TypeReg, contents: "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1001::/app:/bin/sh\n"},
+			rootfsTestEntry{name: "etc/group", typeflag: tar.TypeReg, contents: "root:x:0:\napp:x:1001:\nstaff:x:50:app\n"},
+			rootfsTestEntry{name: "app/", typeflag: tar.TypeDir},
+			rootfsTestEntry{name: "app/old", typeflag: tar.TypeReg, contents: "old"},
+		),
+		rootfsTestLayer(t,
+			rootfsTestEntry{name: "app/.wh.old", typeflag: tar.TypeReg},
+			rootfsTestEntry{name: "app/server", typeflag: tar.TypeReg, contents: "server"},
+		),
+	}
+	src, img := flattenTestImage(`{"config":{"User":"app","Env":["A=1"],"Entrypoint":["/app/server"],"Cmd":["--port","80"],`+
+		`"WorkingDir":"/app","Labels":{"version":"1"}}}`, layers...)
+
+	dir := filepath.Join(tmpDir, "bundle")
+	err = UnpackBundle(context.Background(), src, img, dir, nil)
+	require.NoError(t, err)
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "rootfs/app/server"))
+	require.NoError(t, err)
+	assert.Equal(t, "server", string(contents))
+	_, err = os.Lstat(filepath.Join(dir, "rootfs/app/old"))
+	assert.True(t, os.IsNotExist(err))
+
+	spec := readBundleSpec(t, dir)
+	assert.Equal(t, runtimeSpecVersion, spec.Version)
+	assert.Equal(t, &runtimeRoot{Path: "rootfs"}, spec.Root)
+	assert.Equal(t, &runtimeProcess{
+		User:            runtimeUser{UID: 1000, GID: 1001},
+		Args:            []string{"/app/server", "--port", "80"},
+		Env:             []string{defaultPathEnv, "A=1"},
+		Cwd:             "/app",
+		NoNewPrivileges: true,
+	}, spec.Process)
+	assert.Equal(t, map[string]string{"version": "1"}, spec.Annotations)
+	assert.Empty(t, spec.Linux.UIDMappings)
+	assert.Contains(t, spec.Linux.Namespaces, runtimeNamespace{Type: "network"})
+	assert.NotContains(t, spec.Linux.Namespaces, runtimeNamespace{Type: "user"})
+
+	// An existing root filesystem is not overwritten.
+	err = UnpackBundle(context.Background(), src, img, dir, nil)
+	assert.Error(t, err)
+
+	// With ID mappings, the files are owned by the mapped IDs, and the container uses a user namespace.
+	uidMappings := []rootfs.IDMapping{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	gidMappings := []rootfs.IDMapping{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	dir = filepath.Join(tmpDir, "mapped")
+	err = UnpackBundle(context.Background(), src, img, dir, &BundleOptions{UIDMappings: uidMappings, GIDMappings: gidMappings})
+	require.NoError(t, err)
+	spec = readBundleSpec(t, dir)
+	assert.Equal(t, uidMappings, spec.Linux.UIDMappings)
+	assert.Equal(t, gidMappings, spec.Linux.GIDMappings)
+	assert.Contains(t, spec.Linux.Namespaces, runtimeNamespace{Type: "user"})
+	assert.NotContains(t, spec.Linux.Namespaces, runtimeNamespace{Type: "network"})
+
+	// Files owned by unmapped IDs are rejected.
+	dir = filepath.Join(tmpDir, "unmapped")
+	err = UnpackBundle(context.Background(), src, img, dir,
+		&BundleOptions{UIDMappings: []rootfs.IDMapping{{ContainerID: 1000, HostID: os.Getuid(), Size: 1}}})
+	assert.Error(t, err)
+}
+
+func TestResolveImageUser(t *testing.T) {
+	rootfsDir, err := ioutil.TempDir("", "resolve-image-user")
+	require.NoError(t, err)
+	defer os.RemoveAll(rootfsDir)
+	require.NoError(t, os.Mkdir(filepath.Join(rootfsDir, "etc"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rootfsDir, "etc/passwd"), []byte("root:x:0:0::/root:/bin/sh\napp:x:1000:1001::/app:/bin/sh\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rootfsDir, "etc/group"), []byte("root:x:0:\nstaff:x:50:app\n"), 0644))
+
+	for _, c := range []struct {
+		user     string
+		expected runtimeUser
+	}{
+		{"", runtimeUser{UID: 0, GID: 0}},
+		{"app", runtimeUser{UID: 1000, GID: 1001}},
+		{"1000", runtimeUser{UID: 1000, GID: 1001}},
+		{"2000", runtimeUser{UID: 2000, GID: 0}},
+		{"app:staff", runtimeUser{UID: 1000, GID: 50}},
+		{"app:60", runtimeUser{UID: 1000, GID: 60}},
+		{"3:4", runtimeUser{UID: 3, GID: 4}},
+	} {
+		res, err := resolveImageUser(rootfsDir, c.user)
+		require.NoError(t, err, c.user)
+		assert.Equal(t, c.expected, res, c.user)
+	}
+	for _, user := range []string{"unknown", "app:unknown"} {
+		_, err := resolveImageUser(rootfsDir, user)
+		assert.Error(t, err, user)
+	}
+
+	// Symbolic links, which could refer to files outside of the root filesystem, are not followed.
+	require.NoError(t, os.RemoveAll(filepath.Join(rootfsDir, "etc")))
+	outsideDir, err := ioutil.TempDir("", "resolve-image-user-outside")
+	require.NoError(t, err)
+	defer os.RemoveAll(outsideDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(outsideDir, "passwd"), []byte("app:x:1000:1001::/app:/bin/sh\n"), 0644))
+	require.NoError(t, os.Symlink(outsideDir, filepath.Join(rootfsDir, "etc")))
+	_, err = resolveImageUser(rootfsDir, "app")
+	assert.Error(t, err)
+}
@@ -0,0 +1,231 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/pkg/rootfs"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// CommitOptions describes the layer added by AppendLayer and CommitRootfs.
+type CommitOptions struct {
+	Created   time.Time // The creation time of the layer and of the new image; the current time if zero
+	CreatedBy string    // The command which created the layer, as shown by docker history
+	Author    string
+	Comment   string
+	// UIDMappings and GIDMappings are the ID mappings used to unpack the directory passed to CommitRootfs, if any
+	// (see BundleOptions); the owners of the files in the directory are mapped back to IDs in the image using them.
+	UIDMappings []rootfs.IDMapping
+	GIDMappings []rootfs.IDMapping
+}
+
+// CommitRootfs creates a layer containing the changes in rootfsDir relative to the root filesystem of parent, with layers read from src;
+// rootfsDir is typically the rootfs directory of a bundle created from parent by UnpackBundle, modified since.
+// The layer is added to parent using AppendLayer; see it for details.  options may be nil.
+func CommitRootfs(ctx context.Context, src types.ImageSource, parent types.Image, rootfsDir string, dest types.ImageDestination, options *CommitOptions) (types.Image, error) {
+	if options == nil {
+		options = &CommitOptions{}
+	}
+	reader, writer := io.Pipe()
+	diffErr := make(chan error, 1)
+	go func() {
+		err := writeRootfsDiff(ctx, src, parent, rootfsDir, options, writer)
+		writer.CloseWithError(err)
+		diffErr <- err
+	}()
+	img, err := AppendLayer(ctx, dest, parent, reader, options)
+	reader.CloseWithError(err)
+	if dErr := <-diffErr; err == nil && dErr != nil {
+		return nil, fmt.Errorf("Error computing the changes in %s: %v", rootfsDir, dErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// writeRootfsDiff writes a layer tarball containing the changes in rootfsDir relative to the root filesystem of parent,
+// with layers read from src, to dest.
+func writeRootfsDiff(ctx context.Context, src types.ImageSource, parent types.Image, rootfsDir string, options *CommitOptions, dest io.Writer) error {
+	reader, writer := io.Pipe()
+	flattenErr := make(chan error, 1)
+	go func() {
+		err := FlattenedRootfs(ctx, src, parent, writer)
+		writer.CloseWithError(err)
+		flattenErr <- err
+	}()
+	err := rootfs.WriteDiff(rootfsDir, reader, options.UIDMappings, options.GIDMappings, dest)
+	if err == nil { // Consume the end of the archive, so that FlattenedRootfs can finish.
+		_, err = io.Copy(ioutil.Discard, reader)
+	}
+	reader.CloseWithError(err)
+	if fErr := <-flattenErr; err == nil && fErr != nil {
+		return fErr
+	}
+	return err
+}
+
+// AppendLayer stores layer, a possibly compressed layer tarball (e.g. created by rootfs.WriteDiff) containing changes to the root filesystem
+// of parent, gzip-compressed in dest, and returns an image consisting of parent with the layer on top.
+// The config of the returned image records the new layer in its diff IDs and history, and is also stored in dest.
+// The layers of parent must already be available in dest (e.g. because dest is the repository parent was read from, or parent has
+// been copied there); writing the manifest of the returned image using dest.PutManifest, and calling dest.Commit, completes the image.
+// Only images with a separate config (docker schema2 and OCI) are supported; the returned image uses the manifest type of parent.
+// options may be nil.
+func AppendLayer(ctx context.Context, dest types.ImageDestination, parent types.Image, layer io.Reader, options *CommitOptions) (types.Image, error) {
+	if options == nil {
+		options = &CommitOptions{}
+	}
+	manifestBlob, mt, err := parent.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var configDescriptor descriptor
+	var layers []descriptor
+	var layerMediaType string
+	switch mt {
+	case manifest.DockerV2Schema2MediaType:
+		m, err := manifestSchema2FromManifest(nil, nil, manifestBlob)
+		if err != nil {
+			return nil, err
+		}
+		configDescriptor, layers = m.(*manifestSchema2).ConfigDescriptor, m.(*manifestSchema2).LayersDescriptors
+		layerMediaType = manifest.DockerV2Schema2LayerMediaType
+	case imgspecv1.MediaTypeImageManifest:
+		m, err := manifestOCI1FromManifest(nil, nil, manifestBlob)
+		if err != nil {
+			return nil, err
+		}
+		configDescriptor, layers = m.(*manifestOCI1).ConfigDescriptor, m.(*manifestOCI1).LayersDescriptors
+		layerMediaType = imgspecv1.MediaTypeImageLayer
+	default:
+		return nil, fmt.Errorf("Adding layers to images with manifest type %s is not supported", mt)
+	}
+	configBlob, err := parent.ConfigBlob(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading image config: %v", err)
+	}
+
+	layerInfo, diffID, err := putGzippedLayer(ctx, dest, layer)
+	if err != nil {
+		return nil, fmt.Errorf("Error writing layer: %v", err)
+	}
+	created := options.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	configBlob, err = configWithAppendedLayer(configBlob, len(layers), diffID, imageHistory{
+		Created:   created.UTC(),
+		Author:    options.Author,
+		CreatedBy: options.CreatedBy,
+		Comment:   options.Comment,
+	})
+	if err != nil {
+		return nil, err
+	}
+	configInfo, err := dest.PutBlob(ctx, bytes.NewReader(configBlob), types.BlobInfo{Digest: digest.FromBytes(configBlob), Size: int64(len(configBlob))}, true)
+	if err != nil {
+		return nil, fmt.Errorf("Error writing config: %v", err)
+	}
+
+	configDescriptor.Digest = configInfo.Digest
+	configDescriptor.Size = configInfo.Size
+	layers = append(append([]descriptor{}, layers...), descriptor{MediaType: layerMediaType, Digest: layerInfo.Digest, Size: layerInfo.Size})
+	if mt == imgspecv1.MediaTypeImageManifest {
+		return memoryImageFromManifest(manifestOCI1FromComponents(configDescriptor, configBlob, layers)), nil
+	}
+	return memoryImageFromManifest(manifestSchema2FromComponents(configDescriptor, configBlob, layers)), nil
+}
+
+// putGzippedLayer stores layer, a possibly compressed layer tarball, gzip-compressed in dest, and returns the stored blob and its diff ID.
+func putGzippedLayer(ctx context.Context, dest types.ImageDestination, layer io.Reader) (types.BlobInfo, digest.Digest, error) {
+	uncompressed, _, err := compression.DetectCompression(layer)
+	if err != nil {
+		return types.BlobInfo{}, "", err
+	}
+	defer uncompressed.Close()
+
+	diffIDDigester := digest.Canonical.Digester()
+	reader, writer := io.Pipe()
+	compressErr := make(chan error, 1)
+	go func() {
+		zipper := gzip.NewWriter(writer)
+		_, err := io.Copy(zipper, io.TeeReader(uncompressed, diffIDDigester.Hash()))
+		if err == nil {
+			err = zipper.Close()
+		}
+		writer.CloseWithError(err)
+		compressErr <- err
+	}()
+	info, err := dest.PutBlob(ctx, reader, types.BlobInfo{Size: -1}, false)
+	reader.CloseWithError(err)
+	if cErr := <-compressErr; err == nil && cErr != nil {
+		return types.BlobInfo{}, "", cErr
+	}
+	if err != nil {
+		return types.BlobInfo{}, "", err
+	}
+	return info, diffIDDigester.Digest(), nil
+}
+
+// configWithAppendedLayer returns configBlob, the config of an image with layerCount layers, updated to record a new layer
+// with diffID, created by history.  Fields of the config which are not affected are preserved as is.
+func configWithAppendedLayer(configBlob []byte, layerCount int, diffID digest.Digest, history imageHistory) ([]byte, error) {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return nil, fmt.Errorf("Error parsing image config: %v", err)
+	}
+
+	rootFS := rootFS{Type: "layers"}
+	if raw, ok := config["rootfs"]; ok {
+		if err := json.Unmarshal(raw, &rootFS); err != nil {
+			return nil, fmt.Errorf("Error parsing image config: %v", err)
+		}
+	}
+	if len(rootFS.DiffIDs) != layerCount {
+		return nil, fmt.Errorf("Inconsistent image: %d layers, but %d diff IDs in the config", layerCount, len(rootFS.DiffIDs))
+	}
+	rootFS.DiffIDs = append(rootFS.DiffIDs, diffID)
+
+	// Keep the existing history entries unmodified.
+	var historyEntries []json.RawMessage
+	if raw, ok := config["history"]; ok {
+		if err := json.Unmarshal(raw, &historyEntries); err != nil {
+			return nil, fmt.Errorf("Error parsing image config: %v", err)
+		}
+	}
+	if len(historyEntries) == 0 { // Images built without recording history; add placeholders so that the new entry corresponds to the new layer.
+		for i := 0; i < layerCount; i++ {
+			historyEntries = append(historyEntries, json.RawMessage("{}"))
+		}
+	}
+	newHistoryEntry, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+	historyEntries = append(historyEntries, newHistoryEntry)
+
+	for key, value := range map[string]interface{}{
+		"rootfs":  rootFS,
+		"history": historyEntries,
+		"created": history.Created,
+	} {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		config[key] = raw
+	}
+	return json.Marshal(config)
+}
@@ -0,0 +1,178 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// digestingImageDest is a memoryImageDest which accepts blobs with unknown digests.
+type digestingImageDest struct {
+	memoryImageDest
+}
+
+func (d *digestingImageDest) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	contents, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	return d.memoryImageDest.PutBlob(ctx, bytes.NewReader(contents), types.BlobInfo{Digest: digest.FromBytes(contents), Size: -1}, isConfig)
+}
+
+// gunzipped returns the decompressed contents of blob.
+func gunzipped(t *testing.T, blob []byte) []byte {
+	r, err := gzip.NewReader(bytes.NewReader(blob))
+	require.NoError(t, err)
+	res, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return res
+}
+
+// commitTestImage returns a schema2 image with layers, whose config records them, and a source providing them.
+func commitTestImage(t *testing.T, layers ...[]byte) (types.ImageSource, types.Image) {
+	diffIDs := []digest.Digest{}
+	for _, l := range layers {
+		diffIDs = append(diffIDs, digest.FromBytes(gunzipped(t, l)))
+	}
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"config":       map[string]interface{}{"Cmd": []string{"sh"}},
+		"rootfs":       rootFS{Type: "layers", DiffIDs: diffIDs},
+	})
+	require.NoError(t, err)
+	return flattenTestImage(string(config), layers...)
+}
+
+func TestAppendLayer(t *testing.T) {
+	baseLayer := rootfsTestLayer(t, rootfsTestEntry{name: "base", typeflag: tar.TypeReg, contents: "base"})
+	_, parent := commitTestImage(t, baseLayer)
+	newLayer := rootfsTestLayer(t, rootfsTestEntry{name: "new", typeflag: tar.TypeReg, contents: "new"})
+	uncompressedNewLayer := gunzipped(t, newLayer)
+	created := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, c := range []struct {
+		mt             string
+		layerMediaType string
+	}{
+		{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema2LayerMediaType},
+		{imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageLayer},
+	} {
+		img := parent
+		if c.mt != manifest.DockerV2Schema2MediaType {
+			converted, err := parent.UpdatedImage(context.Background(), types.ManifestUpdateOptions{ManifestMIMEType: c.mt})
+			require.NoError(t, err)
+			img = converted
+		}
+		// Both compressed and uncompressed layers are accepted.
+		for _, layer := range [][]byte{newLayer, uncompressedNewLayer} {
+			dest := &digestingImageDest{}
+			res, err := AppendLayer(context.Background(), dest, img, bytes.NewReader(layer), &CommitOptions{
+				Created:   created,
+				CreatedBy: "/bin/sh -c touch /new",
+				Author:    "author",
+			})
+			require.NoError(t, err, c.mt)
+
+			_, mt, err := res.Manifest(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, c.mt, mt)
+			layerInfos := res.LayerInfos()
+			require.Len(t, layerInfos, 2)
+			assert.Equal(t, digest.FromBytes(baseLayer), layerInfos[0].Digest)
+			assert.NotContains(t, dest.storedBlobs, layerInfos[0].Digest) // The layers of the parent are not copied.
+			assert.Equal(t, c.layerMediaType, layerInfos[1].MediaType)
+			require.Contains(t, dest.storedBlobs, layerInfos[1].Digest)
+			assert.Equal(t, uncompressedNewLayer, gunzipped(t, dest.storedBlobs[layerInfos[1].Digest]))
+
+			configBlob, err := res.ConfigBlob(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, configBlob, dest.storedBlobs[res.ConfigInfo().Digest])
+			var config image
+			require.NoError(t, json.Unmarshal(configBlob, &config))
+			assert.Equal(t, "amd64", config.Architecture)
+			assert.Equal(t, []string{"sh"}, []string(config.Config.Cmd))
+			assert.True(t, created.Equal(config.Created))
+			assert.Equal(t, []digest.Digest{digest.FromBytes(gunzipped(t, baseLayer)), digest.FromBytes(uncompressedNewLayer)}, config.RootFS.DiffIDs)
+			// A placeholder entry is added for the layer of the parent, which has no history.
+			require.Len(t, config.History, 2)
+			assert.Equal(t, imageHistory{}, config.History[0])
+			assert.Equal(t, imageHistory{Created: created, CreatedBy: "/bin/sh -c touch /new", Author: "author"}, config.History[1])
+
+			history, err := History(context.Background(), res)
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+			assert.Equal(t, layerInfos[1].Digest, history[0].Layer)
+		}
+	}
+
+	// The config must be consistent with the layers.
+	_, img := flattenTestImage(`{}`, baseLayer)
+	_, err := AppendLayer(context.Background(), &digestingImageDest{}, img, bytes.NewReader(newLayer), nil)
+	assert.Error(t, err)
+}
+
+func TestCommitRootfs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "commit-rootfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	layers := [][]byte{
+		rootfsTestLayer(t,
+			rootfsTestEntry{name: "etc/", typeflag: tar.TypeDir},
+			rootfsTestEntry{name: "etc/hostname", typeflag: tar.TypeReg, contents: "base"},
+			rootfsTestEntry{name: "etc/removed", typeflag: tar.TypeReg, contents: "removed"},
+		),
+		rootfsTestLayer(t,
+			rootfsTestEntry{name: "etc/unchanged", typeflag: tar.TypeReg, contents: "unchanged"},
+		),
+	}
+	src, parent := commitTestImage(t, layers...)
+	require.NoError(t, UnpackBundle(context.Background(), src, parent, tmpDir, nil))
+	rootfsDir := filepath.Join(tmpDir, "rootfs")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rootfsDir, "etc/hostname"), []byte("updated"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(rootfsDir, "etc/removed")))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rootfsDir, "added"), []byte("added"), 0644))
+
+	dest := &digestingImageDest{}
+	img, err := CommitRootfs(context.Background(), src, parent, rootfsDir, dest, &CommitOptions{CreatedBy: "edit"})
+	require.NoError(t, err)
+	layerInfos := img.LayerInfos()
+	require.Len(t, layerInfos, 3)
+	assert.Equal(t, map[string]string{
+		"etc/hostname":    "updated",
+		"etc/.wh.removed": "",
+		"added":           "added",
+	}, flattenedContents(t, gunzipped(t, dest.storedBlobs[layerInfos[2].Digest])))
+
+	// The committed image contains the modified root filesystem.
+	for d, blob := range dest.storedBlobs {
+		src.(*blobsImageSource).blobs[d] = blob
+	}
+	var buf bytes.Buffer
+	require.NoError(t, FlattenedRootfs(context.Background(), src, img, &buf))
+	assert.Equal(t, map[string]string{
+		"etc/":          "dir",
+		"etc/hostname":  "updated",
+		"etc/unchanged": "unchanged",
+		"added":         "added",
+	}, flattenedContents(t, buf.Bytes()))
+
+	// Errors computing the changes are reported.
+	_, err = CommitRootfs(context.Background(), src, parent, filepath.Join(tmpDir, "missing"), &digestingImageDest{}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/docker/engine-api/types/strslice"
+	"github.com/opencontainers/go-digest"
+)
+
+// ImageDiff describes the differences between an old and a new image, as computed by Diff.
+type ImageDiff struct {
+	AddedLayers   []types.BlobInfo // Layers of the new image which are not in the old image, in the order of the new image
+	RemovedLayers []types.BlobInfo // Layers of the old image which are not in the new image, in the order of the old image
+	SharedLayers  []types.BlobInfo // Layers of the new image which are also in the old image, in the order of the new image
+
+	AddedLabels   map[string]string      // Labels only set in the new image
+	RemovedLabels map[string]string      // Labels only set in the old image, with their old values
+	ChangedLabels map[string]ValueChange // Labels set in both images, with different values
+	AddedEnv      []string               // Environment entries (VAR=value) only in the new image
+	RemovedEnv    []string               // Environment entries (VAR=value) only in the old image
+	Entrypoint    *StringsChange         // nil if the entrypoint has not changed
+	Cmd           *StringsChange         // nil if the command has not changed
+
+	OldSize   int64 // The total size of the distinct layers and the config of the old image, or -1 if unknown
+	NewSize   int64 // The total size of the distinct layers and the config of the new image, or -1 if unknown
+	SizeDelta int64 // NewSize - OldSize, or 0 if either of them is unknown
+}
+
+// ValueChange is a configuration value which differs between the old and the new image.
+type ValueChange struct {
+	Old string
+	New string
+}
+
+// StringsChange is a configuration value, a list of strings, which differs between the old and the new image.
+type StringsChange struct {
+	Old []string
+	New []string
+}
+
+// diffConfig is the subset of an image configuration compared by Diff.
+type diffConfig struct {
+	Entrypoint strslice.StrSlice `json:"Entrypoint,omitempty"`
+	Cmd        strslice.StrSlice `json:"Cmd,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+// Diff compares oldImage and newImage, e.g. two tags of a repository, and reports the added, removed and shared layers,
+// the differences in the labels, environment, entrypoint and command, and the change of the size.
+// Layers are identified by their digests; equal contents compressed differently are reported as different layers.
+func Diff(ctx context.Context, oldImage, newImage types.Image) (*ImageDiff, error) {
+	oldConfig, err := imageDiffConfig(ctx, oldImage)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading configuration of the old image: %v", err)
+	}
+	newConfig, err := imageDiffConfig(ctx, newImage)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading configuration of the new image: %v", err)
+	}
+
+	res := &ImageDiff{
+		AddedLabels:   map[string]string{},
+		RemovedLabels: map[string]string{},
+		ChangedLabels: map[string]ValueChange{},
+		OldSize:       imageSize(oldImage),
+		NewSize:       imageSize(newImage),
+	}
+	res.AddedLayers, res.RemovedLayers, res.SharedLayers = diffLayers(oldImage.LayerInfos(), newImage.LayerInfos())
+
+	for k, v := range newConfig.Labels {
+		oldValue, ok := oldConfig.Labels[k]
+		switch {
+		case !ok:
+			res.AddedLabels[k] = v
+		case oldValue != v:
+			res.ChangedLabels[k] = ValueChange{Old: oldValue, New: v}
+		}
+	}
+	for k, v := range oldConfig.Labels {
+		if _, ok := newConfig.Labels[k]; !ok {
+			res.RemovedLabels[k] = v
+		}
+	}
+	res.AddedEnv = stringsNotIn(newConfig.Env, oldConfig.Env)
+	res.RemovedEnv = stringsNotIn(oldConfig.Env, newConfig.Env)
+	if !reflect.DeepEqual([]string(oldConfig.Entrypoint), []string(newConfig.Entrypoint)) {
+		res.Entrypoint = &StringsChange{Old: oldConfig.Entrypoint, New: newConfig.Entrypoint}
+	}
+	if !reflect.DeepEqual([]string(oldConfig.Cmd), []string(newConfig.Cmd)) {
+		res.Cmd = &StringsChange{Old: oldConfig.Cmd, New: newConfig.Cmd}
+	}
+	if res.OldSize != -1 && res.NewSize != -1 {
+		res.SizeDelta = res.NewSize - res.OldSize
+	}
+	return res, nil
+}
+
+// imageDiffConfig returns the configuration of img relevant to Diff.
+func imageDiffConfig(ctx context.Context, img types.Image) (*diffConfig, error) {
+	res := &diffConfig{}
+	if err := parseContainerConfig(ctx, img, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// parseContainerConfig parses the container configuration (the "config" field of the image configuration) of img into dest,
+// which must be a pointer.  dest is not modified if img has no container configuration.
+func parseContainerConfig(ctx context.Context, img types.Image, dest interface{}) error {
+	configBlob, err := img.ConfigBlob(ctx)
+	if err != nil {
+		return err
+	}
+	if configBlob == nil {
+		// Schema1 images have no separate config; use the v1-compatible configuration of the top layer instead.
+		manifestBlob, mt, err := img.Manifest(ctx)
+		if err != nil {
+			return err
+		}
+		if mt != manifest.DockerV2Schema1MediaType && mt != manifest.DockerV2Schema1SignedMediaType {
+			return nil
+		}
+		m, err := manifestSchema1FromManifest(manifestBlob)
+		if err != nil {
+			return err
+		}
+		configBlob = []byte(m.(*manifestSchema1).History[0].V1Compatibility)
+	}
+	parsed := struct {
+		Config interface{} `json:"config,omitempty"`
+	}{Config: dest}
+	return json.Unmarshal(configBlob, &parsed)
+}
+
+// diffLayers compares the layers of two images, taking duplicate layers into account,
+// and returns the layers added in newLayers, removed from oldLayers, and shared by both.
+func diffLayers(oldLayers, newLayers []types.BlobInfo) (added, removed, shared []types.BlobInfo) {
+	unmatchedOld := map[digest.Digest][]int{} // Indices into oldLayers, in order
+	for i, l := range oldLayers {
+		unmatchedOld[l.Digest] = append(unmatchedOld[l.Digest], i)
+	}
+	matchedOld := make([]bool, len(oldLayers))
+	added, shared = []types.BlobInfo{}, []types.BlobInfo{}
+	for _, l := range newLayers {
+		if indices := unmatchedOld[l.Digest]; len(indices) != 0 {
+			matchedOld[indices[0]] = true
+			unmatchedOld[l.Digest] = indices[1:]
+			shared = append(shared, l)
+		} else {
+			added = append(added, l)
+		}
+	}
+	removed = []types.BlobInfo{}
+	for i, l := range oldLayers {
+		if !matchedOld[i] {
+			removed = append(removed, l)
+		}
+	}
+	return added, removed, shared
+}
+
+// imageSize returns the total size of the distinct layers and the config of img, or -1 if unknown.
+func imageSize(img types.Image) int64 {
+	size := int64(0)
+	seen := map[digest.Digest]struct{}{}
+	blobs := img.LayerInfos()
+	if config := img.ConfigInfo(); config.Digest != "" {
+		blobs = append([]types.BlobInfo{config}, blobs...)
+	}
+	for _, b := range blobs {
+		if _, ok := seen[b.Digest]; ok {
+			continue
+		}
+		seen[b.Digest] = struct{}{}
+		if b.Size == -1 {
+			return -1
+		}
+		size += b.Size
+	}
+	return size
+}
+
+// stringsNotIn returns the elements of a which are not in b.
+func stringsNotIn(a, b []string) []string {
+	inB := map[string]struct{}{}
+	for _, s := range b {
+		inB[s] = struct{}{}
+	}
+	res := []string{}
+	for _, s := range a {
+		if _, ok := inB[s]; !ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,94 @@
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// diffTestImage returns a schema2 types.Image with configBlob and layers with the specified sizes, identified by their names.
+func diffTestImage(configBlob string, layers ...layerForDiffTest) types.Image {
+	descriptors := make([]descriptor, len(layers))
+	for i, l := range layers {
+		descriptors[i] = descriptor{MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Size: l.size, Digest: digest.FromString(l.name)}
+	}
+	config := descriptor{MediaType: "application/vnd.docker.container.image.v1+json", Size: int64(len(configBlob)), Digest: digest.FromString(configBlob)}
+	return memoryImageFromManifest(manifestSchema2FromComponents(config, []byte(configBlob), descriptors))
+}
+
+type layerForDiffTest struct {
+	name string
+	size int64
+}
+
+func TestDiff(t *testing.T) {
+	oldImage := diffTestImage(`{"config":{"Entrypoint":["/bin/sh"],"Cmd":["-c","true"],"Env":["PATH=/bin","A=1"],`+
+		`"Labels":{"version":"1","removed":"x","same":"s"}}}`,
+		layerForDiffTest{"base", 100}, layerForDiffTest{"app-v1", 10}, layerForDiffTest{"dup", 1}, layerForDiffTest{"dup", 1})
+	newImage := diffTestImage(`{"config":{"Entrypoint":["/bin/bash"],"Cmd":["-c","true"],"Env":["PATH=/bin","A=2"],`+
+		`"Labels":{"version":"2","added":"y","same":"s"}}}`,
+		layerForDiffTest{"base", 100}, layerForDiffTest{"app-v2", 25}, layerForDiffTest{"dup", 1})
+
+	diff, err := Diff(context.Background(), oldImage, newImage)
+	require.NoError(t, err)
+
+	blob := func(name string, size int64) types.BlobInfo {
+		return types.BlobInfo{Digest: digest.FromString(name), Size: size, MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip"}
+	}
+	assert.Equal(t, []types.BlobInfo{blob("app-v2", 25)}, diff.AddedLayers)
+	assert.Equal(t, []types.BlobInfo{blob("app-v1", 10), blob("dup", 1)}, diff.RemovedLayers)
+	assert.Equal(t, []types.BlobInfo{blob("base", 100), blob("dup", 1)}, diff.SharedLayers)
+	assert.Equal(t, map[string]string{"added": "y"}, diff.AddedLabels)
+	assert.Equal(t, map[string]string{"removed": "x"}, diff.RemovedLabels)
+	assert.Equal(t, map[string]ValueChange{"version": {Old: "1", New: "2"}}, diff.ChangedLabels)
+	assert.Equal(t, []string{"A=2"}, diff.AddedEnv)
+	assert.Equal(t, []string{"A=1"}, diff.RemovedEnv)
+	assert.Equal(t, &StringsChange{Old: []string{"/bin/sh"}, New: []string{"/bin/bash"}}, diff.Entrypoint)
+	assert.Nil(t, diff.Cmd)
+	assert.Equal(t, diff.NewSize-diff.OldSize, diff.SizeDelta)
+	assert.Equal(t, int64(15), diff.SizeDelta-(newImage.ConfigInfo().Size-oldImage.ConfigInfo().Size))
+
+	// Comparing an image with itself reports no differences.
+	diff, err = Diff(context.Background(), oldImage, oldImage)
+	require.NoError(t, err)
+	assert.Empty(t, diff.AddedLayers)
+	assert.Empty(t, diff.RemovedLayers)
+	assert.Len(t, diff.SharedLayers, 4)
+	assert.Empty(t, diff.AddedLabels)
+	assert.Empty(t, diff.RemovedLabels)
+	assert.Empty(t, diff.ChangedLabels)
+	assert.Empty(t, diff.AddedEnv)
+	assert.Empty(t, diff.RemovedEnv)
+	assert.Nil(t, diff.Entrypoint)
+	assert.Nil(t, diff.Cmd)
+	assert.Equal(t, int64(0), diff.SizeDelta)
+
+	// Invalid configs are reported.
+	_, err = Diff(context.Background(), diffTestImage("invalid"), newImage)
+	assert.Error(t, err)
+	_, err = Diff(context.Background(), oldImage, diffTestImage("invalid"))
+	assert.Error(t, err)
+}
+
+func TestImageSize(t *testing.T) {
+	img := diffTestImage("{}", layerForDiffTest{"a", 10}, layerForDiffTest{"b", 20}, layerForDiffTest{"a", 10})
+	assert.Equal(t, int64(2+10+20), imageSize(img))
+	img = diffTestImage("{}", layerForDiffTest{"a", 10}, layerForDiffTest{"b", -1})
+	assert.Equal(t, int64(-1), imageSize(img))
+}
+
+func TestImageDiffConfigSchema1(t *testing.T) {
+	manifestBlob, err := ioutil.ReadFile("fixtures/schema2-to-schema1-by-docker.json")
+	require.NoError(t, err)
+	m, err := manifestSchema1FromManifest(manifestBlob)
+	require.NoError(t, err)
+	config, err := imageDiffConfig(context.Background(), memoryImageFromManifest(m))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"httpd-foreground"}, []string(config.Cmd))
+	assert.Contains(t, config.Env, "HTTPD_VERSION=2.4.23")
+}
@@ -0,0 +1,53 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// LayerDiffID returns the DiffID (the digest of the uncompressed contents) of the layer described by info in src,
+// streaming the blob through decompression and digesting without storing it anywhere.
+// The DiffID is looked up in cache first; a computed DiffID is recorded in cache.
+func LayerDiffID(ctx context.Context, src types.ImageSource, info types.BlobInfo, cache types.BlobInfoCache) (digest.Digest, error) {
+	if diffID := cache.UncompressedDigest(info.Digest); diffID != "" {
+		return diffID, nil
+	}
+	if err := info.Digest.Validate(); err != nil {
+		return "", fmt.Errorf("Invalid layer digest %q: %v", info.Digest, err)
+	}
+
+	stream, _, err := src.GetBlob(ctx, info, cache)
+	if err != nil {
+		return "", fmt.Errorf("Error reading layer %s: %v", info.Digest, err)
+	}
+	defer stream.Close()
+
+	// The blob is verified as well, so that a corrupted blob does not cause a wrong DiffID to be recorded in cache.
+	blobDigester := info.Digest.Algorithm().Digester()
+	verifiedStream := io.TeeReader(stream, blobDigester.Hash())
+	uncompressed, _, err := compression.DetectCompression(verifiedStream)
+	if err != nil {
+		return "", fmt.Errorf("Error reading layer %s: %v", info.Digest, err)
+	}
+	defer uncompressed.Close()
+	diffID, err := digest.Canonical.FromReader(uncompressed)
+	if err != nil {
+		return "", fmt.Errorf("Error computing DiffID of layer %s: %v", info.Digest, err)
+	}
+	// Decompressors may stop reading before the end of the blob.
+	if _, err := io.Copy(ioutil.Discard, verifiedStream); err != nil {
+		return "", fmt.Errorf("Error reading layer %s: %v", info.Digest, err)
+	}
+	if blobDigester.Digest() != info.Digest {
+		return "", fmt.Errorf("Digest mismatch reading layer %s, got %s", info.Digest, blobDigester.Digest())
+	}
+
+	cache.RecordDigestUncompressedPair(info.Digest, diffID)
+	return diffID, nil
+}
@@ -0,0 +1,75 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blobsImageSource is a types.ImageSource which serves blobs from memory, and counts GetBlob calls.
+type blobsImageSource struct {
+	unusedImageSource // We inherit almost all of the methods, which just panic()
+	blobs             map[digest.Digest][]byte
+	getBlobCalls      int
+}
+
+func (s *blobsImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	s.getBlobCalls++
+	blob, ok := s.blobs[info.Digest]
+	if !ok {
+		panic("Unexpected digest in GetBlob")
+	}
+	return ioutil.NopCloser(bytes.NewReader(blob)), int64(len(blob)), nil
+}
+
+func TestLayerDiffID(t *testing.T) {
+	uncompressed := []byte("This is an uncompressed layer")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(uncompressed)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	compressed := buf.Bytes()
+	uncompressedDigest := digest.FromBytes(uncompressed)
+	compressedDigest := digest.FromBytes(compressed)
+	corruptedDigest := digest.FromBytes([]byte("something else"))
+
+	src := &blobsImageSource{blobs: map[digest.Digest][]byte{
+		uncompressedDigest: uncompressed,
+		compressedDigest:   compressed,
+		corruptedDigest:    compressed,
+	}}
+	cache := blobinfocache.NewMemoryCache()
+
+	for _, d := range []digest.Digest{uncompressedDigest, compressedDigest} {
+		diffID, err := LayerDiffID(context.Background(), src, types.BlobInfo{Digest: d, Size: -1}, cache)
+		require.NoError(t, err, d.String())
+		assert.Equal(t, uncompressedDigest, diffID, d.String())
+		assert.Equal(t, uncompressedDigest, cache.UncompressedDigest(d), d.String())
+	}
+	assert.Equal(t, 2, src.getBlobCalls)
+
+	// A known DiffID is returned from the cache.
+	diffID, err := LayerDiffID(context.Background(), src, types.BlobInfo{Digest: compressedDigest, Size: -1}, cache)
+	require.NoError(t, err)
+	assert.Equal(t, uncompressedDigest, diffID)
+	assert.Equal(t, 2, src.getBlobCalls)
+
+	// Blobs not matching their digest are rejected, and nothing is recorded.
+	_, err = LayerDiffID(context.Background(), src, types.BlobInfo{Digest: corruptedDigest, Size: -1}, cache)
+	assert.Error(t, err)
+	assert.Equal(t, digest.Digest(""), cache.UncompressedDigest(corruptedDigest))
+
+	// Invalid digests are rejected.
+	_, err = LayerDiffID(context.Background(), src, types.BlobInfo{Digest: "sha256:invalid", Size: -1}, cache)
+	assert.Error(t, err)
+}
@@ -1,52 +1,35 @@
 package image
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"runtime"
+	"sync"
 
 	"github.com/containers/image/manifest"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 )
 
-type platformSpec struct {
-	Architecture string   `json:"architecture"`
-	OS           string   `json:"os"`
-	OSVersion    string   `json:"os.version,omitempty"`
-	OSFeatures   []string `json:"os.features,omitempty"`
-	Variant      string   `json:"variant,omitempty"`
-	Features     []string `json:"features,omitempty"`
-}
-
-// A manifestDescriptor references a platform-specific manifest.
-type manifestDescriptor struct {
-	descriptor
-	Platform platformSpec `json:"platform"`
-}
-
-type manifestList struct {
-	SchemaVersion int                  `json:"schemaVersion"`
-	MediaType     string               `json:"mediaType"`
-	Manifests     []manifestDescriptor `json:"manifests"`
-}
-
-func manifestSchema2FromManifestList(src types.ImageSource, manblob []byte) (genericManifest, error) {
-	list := manifestList{}
-	if err := json.Unmarshal(manblob, &list); err != nil {
+// manifestFromManifestList returns a genericManifest for the platform-specific manifest chosen according to sys (which may be nil)
+// from the manifest list manblob with MIME type mt, in src.
+func manifestFromManifestList(ctx context.Context, sys *types.SystemContext, src types.ImageSource, manblob []byte, mt string) (genericManifest, error) {
+	list, err := manifest.ListFromBlob(manblob, mt)
+	if err != nil {
 		return nil, err
 	}
-	var targetManifestDigest string
-	for _, d := range list.Manifests {
-		if d.Platform.Architecture == runtime.GOARCH && d.Platform.OS == runtime.GOOS {
-			targetManifestDigest = d.Digest
-			break
-		}
+	targetManifestDigest, err := list.ChooseInstance(sys)
+	if err != nil {
+		return nil, err
 	}
-	if targetManifestDigest == "" {
-		return nil, errors.New("no supported platform found in manifest list")
+	return manifestListInstanceFromSource(ctx, sys, src, targetManifestDigest)
+}
+
+// manifestListInstanceFromSource returns a genericManifest for the manifest with targetManifestDigest, referenced from a manifest list in src.
+func manifestListInstanceFromSource(ctx context.Context, sys *types.SystemContext, src types.ImageSource, targetManifestDigest digest.Digest) (genericManifest, error) {
+	if err := targetManifestDigest.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid manifest digest %q in manifest list: %v", targetManifestDigest, err)
 	}
-	manblob, mt, err := src.GetTargetManifest(targetManifestDigest)
+	manblob, mt, err := src.GetManifest(ctx, &targetManifestDigest)
 	if err != nil {
 		return nil, err
 	}
@@ -59,5 +42,75 @@ func manifestSchema2FromManifestList(src types.ImageSource, manblob []byte) (gen
 		return nil, fmt.Errorf("Manifest image does not match selected manifest digest %s", targetManifestDigest)
 	}
 
-	return manifestInstanceFromBlob(src, manblob, mt)
+	return manifestInstanceFromBlob(ctx, sys, src, manblob, mt)
+}
+
+// defaultManifestListParallelism is the number of instances inspected concurrently by InspectManifestList if its maxParallel parameter is not positive.
+const defaultManifestListParallelism = 6
+
+// ManifestListInstance describes a single platform-specific image in a manifest list, as returned by InspectManifestList.
+type ManifestListInstance struct {
+	Digest       digest.Digest
+	Architecture string
+	OS           string
+	Variant      string
+	// Inspect is the result of inspecting the image; nil if Err is set.
+	Inspect *types.ImageInspectInfo
+	// Err is the error fetching, parsing or inspecting the image, if any; it does not prevent inspecting the other images.
+	Err error
+}
+
+// InspectManifestList fetches and inspects all platform-specific images of the manifest list manblob in src,
+// returning them in the order they are listed in manblob.
+// If src.HasThreadSafeGetBlob(), at most maxParallel images (or a default number, if maxParallel <= 0) are inspected concurrently;
+// otherwise the images are inspected one at a time.
+// A failure to inspect an individual image is reported in ManifestListInstance.Err; an error is returned only if manblob can not be parsed
+// or ctx is cancelled.
+func InspectManifestList(ctx context.Context, sys *types.SystemContext, src types.ImageSource, manblob []byte, maxParallel int) ([]ManifestListInstance, error) {
+	list, err := manifest.ListFromBlob(manblob, "")
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing manifest list: %v", err)
+	}
+	instances := list.Instances()
+
+	if maxParallel <= 0 {
+		maxParallel = defaultManifestListParallelism
+	}
+	if !src.HasThreadSafeGetBlob() {
+		maxParallel = 1
+	}
+	res := make([]ManifestListInstance, len(instances))
+	semaphore := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for i, listInstance := range instances {
+		res[i] = ManifestListInstance{
+			Digest:       listInstance.Digest,
+			Architecture: listInstance.Architecture,
+			OS:           listInstance.OS,
+			Variant:      listInstance.Variant,
+		}
+		semaphore <- struct{}{}
+		if err := ctx.Err(); err != nil {
+			<-semaphore
+			break
+		}
+		wg.Add(1)
+		go func(instance *ManifestListInstance) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			m, err := manifestListInstanceFromSource(ctx, sys, src, instance.Digest)
+			if err != nil {
+				instance.Err = err
+				return
+			}
+			instance.Inspect, instance.Err = inspectManifest(ctx, m)
+		}(&res[i])
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
@@ -0,0 +1,130 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/manifest"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// manifestListImageSource is a mock of types.ImageSource which returns instance manifests from a map in GetManifest,
+// and records the maximum number of concurrent GetManifest calls.
+type manifestListImageSource struct {
+	configBlobImageSource
+	manifests map[digest.Digest][]byte
+
+	mutex      sync.Mutex
+	active     int
+	maxActive  int
+	threadSafe bool
+}
+
+func (s *manifestListImageSource) HasThreadSafeGetBlob() bool {
+	return s.threadSafe
+}
+
+func (s *manifestListImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest == nil {
+		panic("Unexpected call to a mock function")
+	}
+	s.mutex.Lock()
+	s.active++
+	if s.active > s.maxActive {
+		s.maxActive = s.active
+	}
+	s.mutex.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	s.mutex.Lock()
+	s.active--
+	s.mutex.Unlock()
+
+	m, ok := s.manifests[*instanceDigest]
+	if !ok {
+		return nil, "", errors.New("manifest not found")
+	}
+	return m, manifest.DockerV2Schema2MediaType, nil
+}
+
+func TestInspectManifestList(t *testing.T) {
+	realConfigJSON, err := ioutil.ReadFile("fixtures/schema2-config.json")
+	require.NoError(t, err)
+	schema2, err := ioutil.ReadFile("fixtures/schema2.json")
+	require.NoError(t, err)
+	schema2Digest, err := manifest.Digest(schema2)
+	require.NoError(t, err)
+	missingDigest := digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+
+	list := manifest.Schema2ListFromComponents(nil)
+	for i := 0; i < 10; i++ {
+		list.Manifests = append(list.Manifests, manifest.Schema2ManifestDescriptor{
+			Schema2Descriptor: manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Digest: schema2Digest},
+			Platform:          manifest.Schema2PlatformSpec{Architecture: fmt.Sprintf("arch%d", i), OS: "linux"},
+		})
+	}
+	list.Manifests = append(list.Manifests, manifest.Schema2ManifestDescriptor{
+		Schema2Descriptor: manifest.Schema2Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Digest: missingDigest},
+		Platform:          manifest.Schema2PlatformSpec{Architecture: "arm", OS: "linux", Variant: "v7"},
+	})
+	listBlob, err := list.Serialize()
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		threadSafe  bool
+		maxParallel int
+		expectedMax int
+	}{
+		{true, 3, 3},
+		{true, 0, defaultManifestListParallelism},
+		{false, 3, 1},
+	} {
+		src := &manifestListImageSource{
+			configBlobImageSource: configBlobImageSource{
+				f: func(digest digest.Digest) (io.ReadCloser, int64, error) {
+					return ioutil.NopCloser(bytes.NewReader(realConfigJSON)), int64(len(realConfigJSON)), nil
+				},
+			},
+			manifests:  map[digest.Digest][]byte{schema2Digest: schema2},
+			threadSafe: c.threadSafe,
+		}
+		res, err := InspectManifestList(context.Background(), nil, src, listBlob, c.maxParallel)
+		require.NoError(t, err)
+		require.Len(t, res, len(list.Manifests))
+		for i, instance := range res[:10] {
+			assert.Equal(t, schema2Digest, instance.Digest)
+			assert.Equal(t, fmt.Sprintf("arch%d", i), instance.Architecture)
+			assert.Equal(t, "linux", instance.OS)
+			require.NoError(t, instance.Err)
+			require.NotNil(t, instance.Inspect)
+			assert.Equal(t, "amd64", instance.Inspect.Architecture) // From the config
+		}
+		assert.Equal(t, missingDigest, res[10].Digest)
+		assert.Equal(t, "v7", res[10].Variant)
+		assert.Error(t, res[10].Err)
+		assert.Nil(t, res[10].Inspect)
+		assert.True(t, src.maxActive <= c.expectedMax, "%#v: %d", c, src.maxActive)
+		if !c.threadSafe {
+			assert.Equal(t, 1, src.maxActive)
+		}
+	}
+
+	// Other manifest types are rejected.
+	schema2Src := newSchema2ImageSource(t, "busybox:latest")
+	_, err = InspectManifestList(context.Background(), nil, schema2Src, schema2, 0)
+	assert.Error(t, err)
+
+	// A cancelled context is reported.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = InspectManifestList(ctx, nil, &manifestListImageSource{threadSafe: true}, listBlob, 0)
+	assert.Error(t, err)
+}
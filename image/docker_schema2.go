@@ -2,16 +2,20 @@ package image
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"strings"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/iolimits"
+	"github.com/containers/image/pkg/logging"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // gzippedEmptyLayer is a gzip-compressed version of an empty tar file (1024 NULL bytes)
@@ -24,16 +28,37 @@ var gzippedEmptyLayer = []byte{
 }
 
 // gzippedEmptyLayerDigest is a digest of gzippedEmptyLayer
-const gzippedEmptyLayerDigest = "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"
+const gzippedEmptyLayerDigest = digest.Digest("sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4")
 
 type descriptor struct {
-	MediaType string `json:"mediaType"`
-	Size      int64  `json:"size"`
-	Digest    string `json:"digest"`
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      digest.Digest     `json:"digest"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// validateDescriptors returns an error if the digest of config or of any of layers is not valid.
+func validateDescriptors(config descriptor, layers []descriptor) error {
+	if err := config.Digest.Validate(); err != nil {
+		return fmt.Errorf("Invalid config digest %q: %v", config.Digest, err)
+	}
+	for _, layer := range layers {
+		if err := layer.Digest.Validate(); err != nil {
+			return fmt.Errorf("Invalid layer digest %q: %v", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+// blobInfo returns a types.BlobInfo describing the blob referenced by d.
+func (d descriptor) blobInfo() types.BlobInfo {
+	return types.BlobInfo{Digest: d.Digest, Size: d.Size, MediaType: d.MediaType, URLs: d.URLs, Annotations: d.Annotations}
 }
 
 type manifestSchema2 struct {
 	src               types.ImageSource // May be nil if configBlob is not nil
+	maxConfigSize     int64             // The maximum size of the config blob read from src
 	configBlob        []byte            // If set, corresponds to contents of ConfigDescriptor.
 	SchemaVersion     int               `json:"schemaVersion"`
 	MediaType         string            `json:"mediaType"`
@@ -41,11 +66,15 @@ type manifestSchema2 struct {
 	LayersDescriptors []descriptor      `json:"layers"`
 }
 
-func manifestSchema2FromManifest(src types.ImageSource, manifest []byte) (genericManifest, error) {
-	v2s2 := manifestSchema2{src: src}
+// manifestSchema2FromManifest returns a genericManifest for manifest in src; sys (which may be nil) determines the limits used when reading the config blob.
+func manifestSchema2FromManifest(sys *types.SystemContext, src types.ImageSource, manifest []byte) (genericManifest, error) {
+	v2s2 := manifestSchema2{src: src, maxConfigSize: iolimits.MaxConfigBlobSize(sys)}
 	if err := json.Unmarshal(manifest, &v2s2); err != nil {
 		return nil, err
 	}
+	if err := validateDescriptors(v2s2.ConfigDescriptor, v2s2.LayersDescriptors); err != nil {
+		return nil, err
+	}
 	return &v2s2, nil
 }
 
@@ -72,30 +101,20 @@ func (m *manifestSchema2) manifestMIMEType() string {
 // ConfigInfo returns a complete BlobInfo for the separate config object, or a BlobInfo{Digest:""} if there isn't a separate object.
 // Note that the config object may not exist in the underlying storage in the return value of UpdatedImage! Use ConfigBlob() below.
 func (m *manifestSchema2) ConfigInfo() types.BlobInfo {
-	return types.BlobInfo{Digest: m.ConfigDescriptor.Digest, Size: m.ConfigDescriptor.Size}
+	return m.ConfigDescriptor.blobInfo()
 }
 
 // ConfigBlob returns the blob described by ConfigInfo, iff ConfigInfo().Digest != ""; nil otherwise.
 // The result is cached; it is OK to call this however often you need.
-func (m *manifestSchema2) ConfigBlob() ([]byte, error) {
+func (m *manifestSchema2) ConfigBlob(ctx context.Context) ([]byte, error) {
 	if m.configBlob == nil {
 		if m.src == nil {
 			return nil, fmt.Errorf("Internal error: neither src nor configBlob set in manifestSchema2")
 		}
-		stream, _, err := m.src.GetBlob(m.ConfigDescriptor.Digest)
-		if err != nil {
-			return nil, err
-		}
-		defer stream.Close()
-		blob, err := ioutil.ReadAll(stream)
+		blob, err := fetchConfigBlob(ctx, m.src, m.ConfigInfo(), m.maxConfigSize)
 		if err != nil {
 			return nil, err
 		}
-		hash := sha256.Sum256(blob)
-		computedDigest := "sha256:" + hex.EncodeToString(hash[:])
-		if computedDigest != m.ConfigDescriptor.Digest {
-			return nil, fmt.Errorf("Download config.json digest %s does not match expected %s", computedDigest, m.ConfigDescriptor.Digest)
-		}
 		m.configBlob = blob
 	}
 	return m.configBlob, nil
@@ -107,13 +126,13 @@ func (m *manifestSchema2) ConfigBlob() ([]byte, error) {
 func (m *manifestSchema2) LayerInfos() []types.BlobInfo {
 	blobs := []types.BlobInfo{}
 	for _, layer := range m.LayersDescriptors {
-		blobs = append(blobs, types.BlobInfo{Digest: layer.Digest, Size: layer.Size})
+		blobs = append(blobs, layer.blobInfo())
 	}
 	return blobs
 }
 
-func (m *manifestSchema2) imageInspectInfo() (*types.ImageInspectInfo, error) {
-	config, err := m.ConfigBlob()
+func (m *manifestSchema2) imageInspectInfo(ctx context.Context) (*types.ImageInspectInfo, error) {
+	config, err := m.ConfigBlob(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -139,7 +158,22 @@ func (m *manifestSchema2) UpdatedImageNeedsLayerDiffIDs(options types.ManifestUp
 
 // UpdatedImage returns a types.Image modified according to options.
 // This does not change the state of the original Image object.
-func (m *manifestSchema2) UpdatedImage(options types.ManifestUpdateOptions) (types.Image, error) {
+func (m *manifestSchema2) UpdatedImage(ctx context.Context, options types.ManifestUpdateOptions) (types.Image, error) {
+	if options.ManifestMIMEType != "" {
+		// Conversions need the config blob; read it via m, so that it is memoized for m and all of its updated copies
+		// instead of being fetched again for each copy.
+		if _, err := m.ConfigBlob(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if options.ManifestMIMEType == imgspecv1.MediaTypeImageManifest && options.LayerInfos != nil {
+		// The updated layers may not be representable in schema2 (e.g. if they use zstd compression), so update the converted manifest instead.
+		converted, err := m.convertToManifestOCI1(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return converted.UpdatedImage(ctx, types.ManifestUpdateOptions{LayerInfos: options.LayerInfos, InformationOnly: options.InformationOnly})
+	}
 	copy := *m // NOTE: This is not a deep copy, it still shares slices etc.
 	if options.LayerInfos != nil {
 		if len(copy.LayersDescriptors) != len(options.LayerInfos) {
@@ -147,15 +181,23 @@ func (m *manifestSchema2) UpdatedImage(options types.ManifestUpdateOptions) (typ
 		}
 		copy.LayersDescriptors = make([]descriptor, len(options.LayerInfos))
 		for i, info := range options.LayerInfos {
+			mediaType, err := manifest.Schema2LayerMediaType(m.LayersDescriptors[i].MediaType, info)
+			if err != nil {
+				return nil, fmt.Errorf("Error preparing updated manifest: %v", err)
+			}
+			copy.LayersDescriptors[i].MediaType = mediaType
 			copy.LayersDescriptors[i].Digest = info.Digest
 			copy.LayersDescriptors[i].Size = info.Size
+			copy.LayersDescriptors[i].URLs = info.URLs
 		}
 	}
 
 	switch options.ManifestMIMEType {
 	case "": // No conversion, OK
 	case manifest.DockerV2Schema1SignedMediaType, manifest.DockerV2Schema1MediaType:
-		return copy.convertToManifestSchema1(options.InformationOnly.Destination)
+		return copy.convertToManifestSchema1(ctx, options.InformationOnly.Destination, options.InformationOnly.Logger)
+	case imgspecv1.MediaTypeImageManifest:
+		return copy.convertToManifestOCI1(ctx)
 	default:
 		return nil, fmt.Errorf("Conversion of image manifest from %s to %s is not implemented", manifest.DockerV2Schema2MediaType, options.ManifestMIMEType)
 	}
@@ -163,9 +205,51 @@ func (m *manifestSchema2) UpdatedImage(options types.ManifestUpdateOptions) (typ
 	return memoryImageFromManifest(&copy), nil
 }
 
+func (m *manifestSchema2) convertToManifestOCI1(ctx context.Context) (types.Image, error) {
+	// The schema2 config format is a superset of the OCI one, so the config blob can be used unmodified.
+	config := m.ConfigDescriptor
+	config.MediaType = imgspecv1.MediaTypeImageConfig
+
+	layers := make([]descriptor, len(m.LayersDescriptors))
+	for idx := range layers {
+		layers[idx] = m.LayersDescriptors[idx]
+		layers[idx].MediaType = imgspecv1.MediaTypeImageLayer
+	}
+
+	configBlob, err := m.ConfigBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m1 := manifestOCI1FromComponents(config, configBlob, layers)
+	return memoryImageFromManifest(m1), nil
+}
+
+// storeGzippedEmptyLayer makes sure gzippedEmptyLayer is available in dest, uploading it only if dest does not already contain it.
+func storeGzippedEmptyLayer(ctx context.Context, dest types.ImageDestination, logger types.Logger) error {
+	emptyLayerInfo := types.BlobInfo{Digest: gzippedEmptyLayerDigest, Size: int64(len(gzippedEmptyLayer))}
+	reused, info, err := dest.TryReusingBlob(ctx, emptyLayerInfo, blobinfocache.NoCache, false)
+	if err != nil {
+		return fmt.Errorf("Error trying to reuse empty layer: %v", err)
+	}
+	if !reused {
+		logger.Debugf("Uploading empty layer during conversion to schema 1")
+		info, err = dest.PutBlob(ctx, bytes.NewReader(gzippedEmptyLayer), emptyLayerInfo, false)
+		if err != nil {
+			return fmt.Errorf("Error uploading empty layer: %v", err)
+		}
+	}
+	if info.Digest != gzippedEmptyLayerDigest {
+		return fmt.Errorf("Internal error: Uploaded empty layer has digest %#v instead of %s", info.Digest, gzippedEmptyLayerDigest)
+	}
+	return nil
+}
+
 // Based on docker/distribution/manifest/schema1/config_builder.go
-func (m *manifestSchema2) convertToManifestSchema1(dest types.ImageDestination) (types.Image, error) {
-	configBytes, err := m.ConfigBlob()
+func (m *manifestSchema2) convertToManifestSchema1(ctx context.Context, dest types.ImageDestination, logger types.Logger) (types.Image, error) {
+	if logger == nil {
+		logger = logging.Default
+	}
+	configBytes, err := m.ConfigBlob(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -189,16 +273,11 @@ func (m *manifestSchema2) convertToManifestSchema1(dest types.ImageDestination)
 		parentV1ID = v1ID
 		v1Index := len(imageConfig.History) - 1 - v2Index
 
-		var blobDigest string
+		var blobDigest digest.Digest
 		if historyEntry.EmptyLayer {
 			if !haveGzippedEmptyLayer {
-				logrus.Debugf("Uploading empty layer during conversion to schema 1")
-				info, err := dest.PutBlob(bytes.NewReader(gzippedEmptyLayer), types.BlobInfo{Digest: gzippedEmptyLayerDigest, Size: int64(len(gzippedEmptyLayer))})
-				if err != nil {
-					return nil, fmt.Errorf("Error uploading empty layer: %v", err)
-				}
-				if info.Digest != gzippedEmptyLayerDigest {
-					return nil, fmt.Errorf("Internal error: Uploaded empty layer has digest %#v instead of %s", info.Digest, gzippedEmptyLayerDigest)
+				if err := storeGzippedEmptyLayer(ctx, dest, logger); err != nil {
+					return nil, err
 				}
 				haveGzippedEmptyLayer = true
 			}
@@ -252,12 +331,11 @@ func (m *manifestSchema2) convertToManifestSchema1(dest types.ImageDestination)
 	return memoryImageFromManifest(m1), nil
 }
 
-func v1IDFromBlobDigestAndComponents(blobDigest string, others ...string) (string, error) {
-	blobDigestComponents := strings.SplitN(blobDigest, ":", 2)
-	if len(blobDigestComponents) != 2 {
-		return "", fmt.Errorf("Invalid layer digest %s: expecting algorithm:value", blobDigest)
+func v1IDFromBlobDigestAndComponents(blobDigest digest.Digest, others ...string) (string, error) {
+	if err := blobDigest.Validate(); err != nil {
+		return "", fmt.Errorf("Invalid layer digest %s: %v", blobDigest, err)
 	}
-	parts := append([]string{blobDigestComponents[1]}, others...)
+	parts := append([]string{blobDigest.Hex()}, others...)
 	v1IDHash := sha256.Sum256([]byte(strings.Join(parts, " ")))
 	return hex.EncodeToString(v1IDHash[:]), nil
 }
@@ -2,6 +2,7 @@ package image
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -13,6 +14,8 @@ import (
 	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/manifest"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,16 +29,16 @@ func (f unusedImageSource) Reference() types.ImageReference {
 func (f unusedImageSource) Close() {
 	panic("Unexpected call to a mock function")
 }
-func (f unusedImageSource) GetManifest() ([]byte, string, error) {
+func (f unusedImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
 	panic("Unexpected call to a mock function")
 }
-func (f unusedImageSource) GetTargetManifest(digest string) ([]byte, string, error) {
+func (f unusedImageSource) HasThreadSafeGetBlob() bool {
 	panic("Unexpected call to a mock function")
 }
-func (f unusedImageSource) GetBlob(digest string) (io.ReadCloser, int64, error) {
+func (f unusedImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
 	panic("Unexpected call to a mock function")
 }
-func (f unusedImageSource) GetSignatures() ([][]byte, error) {
+func (f unusedImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
 	panic("Unexpected call to a mock function")
 }
 
@@ -43,7 +46,7 @@ func manifestSchema2FromFixture(t *testing.T, src types.ImageSource, fixture str
 	manifest, err := ioutil.ReadFile(filepath.Join("fixtures", fixture))
 	require.NoError(t, err)
 
-	m, err := manifestSchema2FromManifest(src, manifest)
+	m, err := manifestSchema2FromManifest(nil, src, manifest)
 	require.NoError(t, err)
 	return m
 }
@@ -87,7 +90,7 @@ func TestManifestSchema2FromManifest(t *testing.T) {
 	// values are correctly returned in tests for the individual getter methods.
 	_ = manifestSchema2FromFixture(t, unusedImageSource{}, "schema2.json")
 
-	_, err := manifestSchema2FromManifest(nil, []byte{})
+	_, err := manifestSchema2FromManifest(nil, nil, []byte{})
 	assert.Error(t, err)
 }
 
@@ -136,23 +139,24 @@ func TestManifestSchema2ConfigInfo(t *testing.T) {
 		manifestSchema2FromComponentsLikeFixture(nil),
 	} {
 		assert.Equal(t, types.BlobInfo{
-			Size:   5940,
-			Digest: "sha256:9ca4bda0a6b3727a6ffcc43e981cad0f24e2ec79d338f6ba325b4dfd0756fb8f",
+			Size:      5940,
+			Digest:    "sha256:9ca4bda0a6b3727a6ffcc43e981cad0f24e2ec79d338f6ba325b4dfd0756fb8f",
+			MediaType: "application/octet-stream",
 		}, m.ConfigInfo())
 	}
 }
 
-// configBlobImageSource allows testing various GetBlob behaviors in .ConfigBlob()
+// configBlobImageSource allows testing various GetBlob behaviors in .ConfigBlob(context.Background())
 type configBlobImageSource struct {
 	unusedImageSource // We inherit almost all of the methods, which just panic()
-	f                 func(digest string) (io.ReadCloser, int64, error)
+	f                 func(digest digest.Digest) (io.ReadCloser, int64, error)
 }
 
-func (f configBlobImageSource) GetBlob(digest string) (io.ReadCloser, int64, error) {
-	if digest != "sha256:9ca4bda0a6b3727a6ffcc43e981cad0f24e2ec79d338f6ba325b4dfd0756fb8f" {
+func (f configBlobImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if info.Digest != "sha256:9ca4bda0a6b3727a6ffcc43e981cad0f24e2ec79d338f6ba325b4dfd0756fb8f" {
 		panic("Unexpected digest in GetBlob")
 	}
-	return f.f(digest)
+	return f.f(info.Digest)
 }
 
 func TestManifestSchema2ConfigBlob(t *testing.T) {
@@ -160,24 +164,24 @@ func TestManifestSchema2ConfigBlob(t *testing.T) {
 	require.NoError(t, err)
 
 	for _, c := range []struct {
-		cbISfn func(digest string) (io.ReadCloser, int64, error)
+		cbISfn func(digest digest.Digest) (io.ReadCloser, int64, error)
 		blob   []byte
 	}{
 		// Success
-		{func(digest string) (io.ReadCloser, int64, error) {
+		{func(digest digest.Digest) (io.ReadCloser, int64, error) {
 			return ioutil.NopCloser(bytes.NewReader(realConfigJSON)), int64(len(realConfigJSON)), nil
 		}, realConfigJSON},
 		// Various kinds of failures
 		{nil, nil},
-		{func(digest string) (io.ReadCloser, int64, error) {
+		{func(digest digest.Digest) (io.ReadCloser, int64, error) {
 			return nil, -1, errors.New("Error returned from GetBlob")
 		}, nil},
-		{func(digest string) (io.ReadCloser, int64, error) {
+		{func(digest digest.Digest) (io.ReadCloser, int64, error) {
 			reader, writer := io.Pipe()
 			writer.CloseWithError(errors.New("Expected error reading input in ConfigBlob"))
 			return reader, 1, nil
 		}, nil},
-		{func(digest string) (io.ReadCloser, int64, error) {
+		{func(digest digest.Digest) (io.ReadCloser, int64, error) {
 			nonmatchingJSON := []byte("This does not match ConfigDescriptor.Digest")
 			return ioutil.NopCloser(bytes.NewReader(nonmatchingJSON)), int64(len(nonmatchingJSON)), nil
 		}, nil},
@@ -189,7 +193,7 @@ func TestManifestSchema2ConfigBlob(t *testing.T) {
 			src = nil
 		}
 		m := manifestSchema2FromFixture(t, src, "schema2.json")
-		blob, err := m.ConfigBlob()
+		blob, err := m.ConfigBlob(context.Background())
 		if c.blob != nil {
 			assert.NoError(t, err)
 			assert.Equal(t, c.blob, blob)
@@ -205,11 +209,34 @@ func TestManifestSchema2ConfigBlob(t *testing.T) {
 	// This just tests that the manifest can be created; we test that the parsed
 	// values are correctly returned in tests for the individual getter methods.
 	m := manifestSchema2FromComponentsLikeFixture(configBlob)
-	cb, err := m.ConfigBlob()
+	cb, err := m.ConfigBlob(context.Background())
 	require.NoError(t, err)
 	assert.Equal(t, configBlob, cb)
 }
 
+func TestManifestSchema2ConfigBlobMemoized(t *testing.T) {
+	realConfigJSON, err := ioutil.ReadFile("fixtures/schema2-config.json")
+	require.NoError(t, err)
+
+	getBlobCalls := 0
+	src := configBlobImageSource{unusedImageSource{}, func(digest digest.Digest) (io.ReadCloser, int64, error) {
+		getBlobCalls++
+		return ioutil.NopCloser(bytes.NewReader(realConfigJSON)), int64(len(realConfigJSON)), nil
+	}}
+	m := manifestSchema2FromFixture(t, src, "schema2.json")
+	// Neither repeated calls nor conversions (which work on a copy of m) read the config blob again.
+	for i := 0; i < 2; i++ {
+		_, err := m.imageInspectInfo(context.Background())
+		require.NoError(t, err)
+		res, err := m.UpdatedImage(context.Background(), types.ManifestUpdateOptions{ManifestMIMEType: imgspecv1.MediaTypeImageManifest})
+		require.NoError(t, err)
+		configBlob, err := res.ConfigBlob(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, realConfigJSON, configBlob)
+	}
+	assert.Equal(t, 1, getBlobCalls)
+}
+
 func TestManifestSchema2LayerInfo(t *testing.T) {
 	for _, m := range []genericManifest{
 		manifestSchema2FromFixture(t, unusedImageSource{}, "schema2.json"),
@@ -217,35 +244,64 @@ func TestManifestSchema2LayerInfo(t *testing.T) {
 	} {
 		assert.Equal(t, []types.BlobInfo{
 			{
-				Digest: "sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb",
-				Size:   51354364,
+				Digest:    "sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb",
+				Size:      51354364,
+				MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
 			},
 			{
-				Digest: "sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c",
-				Size:   150,
+				Digest:    "sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c",
+				Size:      150,
+				MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
 			},
 			{
-				Digest: "sha256:8f5dc8a4b12c307ac84de90cdd9a7f3915d1be04c9388868ca118831099c67a9",
-				Size:   11739507,
+				Digest:    "sha256:8f5dc8a4b12c307ac84de90cdd9a7f3915d1be04c9388868ca118831099c67a9",
+				Size:      11739507,
+				MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
 			},
 			{
-				Digest: "sha256:bbd6b22eb11afce63cc76f6bc41042d99f10d6024c96b655dafba930b8d25909",
-				Size:   8841833,
+				Digest:    "sha256:bbd6b22eb11afce63cc76f6bc41042d99f10d6024c96b655dafba930b8d25909",
+				Size:      8841833,
+				MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
 			},
 			{
-				Digest: "sha256:960e52ecf8200cbd84e70eb2ad8678f4367e50d14357021872c10fa3fc5935fa",
-				Size:   291,
+				Digest:    "sha256:960e52ecf8200cbd84e70eb2ad8678f4367e50d14357021872c10fa3fc5935fa",
+				Size:      291,
+				MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
 			},
 		}, m.LayerInfos())
 	}
 }
 
+func TestManifestSchema2LayerInfoURLs(t *testing.T) {
+	urls := []string{"https://example.com/layer.tar.gz"}
+	m := manifestSchema2FromComponents(descriptor{
+		MediaType: "application/octet-stream",
+		Size:      5940,
+		Digest:    "sha256:9ca4bda0a6b3727a6ffcc43e981cad0f24e2ec79d338f6ba325b4dfd0756fb8f",
+	}, nil, []descriptor{
+		{
+			MediaType: "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip",
+			Digest:    "sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb",
+			Size:      51354364,
+			URLs:      urls,
+		},
+	})
+	assert.Equal(t, []types.BlobInfo{
+		{
+			Digest:    "sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb",
+			Size:      51354364,
+			MediaType: "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip",
+			URLs:      urls,
+		},
+	}, m.LayerInfos())
+}
+
 func TestManifestSchema2ImageInspectInfo(t *testing.T) {
 	configJSON, err := ioutil.ReadFile("fixtures/schema2-config.json")
 	require.NoError(t, err)
 
 	m := manifestSchema2FromComponentsLikeFixture(configJSON)
-	ii, err := m.imageInspectInfo()
+	ii, err := m.imageInspectInfo(context.Background())
 	require.NoError(t, err)
 	assert.Equal(t, types.ImageInspectInfo{
 		Tag:           "",
@@ -257,13 +313,13 @@ func TestManifestSchema2ImageInspectInfo(t *testing.T) {
 		Layers:        nil,
 	}, *ii)
 
-	// nil configBlob will trigger an error in m.ConfigBlob()
+	// nil configBlob will trigger an error in m.ConfigBlob(context.Background())
 	m = manifestSchema2FromComponentsLikeFixture(nil)
-	_, err = m.imageInspectInfo()
+	_, err = m.imageInspectInfo(context.Background())
 	assert.Error(t, err)
 
 	m = manifestSchema2FromComponentsLikeFixture([]byte("invalid JSON"))
-	_, err = m.imageInspectInfo()
+	_, err = m.imageInspectInfo(context.Background())
 	assert.Error(t, err)
 }
 
@@ -278,7 +334,7 @@ func TestManifestSchema2UpdatedImageNeedsLayerDiffIDs(t *testing.T) {
 	}
 }
 
-// schema2ImageSource is plausible enough for schema conversions in manifestSchema2.UpdatedImage() to work.
+// schema2ImageSource is plausible enough for schema conversions in manifestSchema2.UpdatedImage(context.Background()) to work.
 type schema2ImageSource struct {
 	configBlobImageSource
 	ref reference.Named
@@ -306,16 +362,16 @@ func (ref refImageReferenceMock) PolicyConfigurationIdentity() string {
 func (ref refImageReferenceMock) PolicyConfigurationNamespaces() []string {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageReferenceMock) NewImage(ctx *types.SystemContext) (types.Image, error) {
+func (ref refImageReferenceMock) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageReferenceMock) NewImageSource(ctx *types.SystemContext, requestedManifestMIMETypes []string) (types.ImageSource, error) {
+func (ref refImageReferenceMock) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageReferenceMock) NewImageDestination(ctx *types.SystemContext) (types.ImageDestination, error) {
+func (ref refImageReferenceMock) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageReferenceMock) DeleteImage(ctx *types.SystemContext) error {
+func (ref refImageReferenceMock) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	panic("unexpected call to a mock function")
 }
 
@@ -328,7 +384,7 @@ func newSchema2ImageSource(t *testing.T, dockerRef string) *schema2ImageSource {
 
 	return &schema2ImageSource{
 		configBlobImageSource: configBlobImageSource{
-			f: func(digest string) (io.ReadCloser, int64, error) {
+			f: func(digest digest.Digest) (io.ReadCloser, int64, error) {
 				return ioutil.NopCloser(bytes.NewReader(realConfigJSON)), int64(len(realConfigJSON)), nil
 			},
 		},
@@ -338,7 +394,7 @@ func newSchema2ImageSource(t *testing.T, dockerRef string) *schema2ImageSource {
 
 type memoryImageDest struct {
 	ref         reference.Named
-	storedBlobs map[string][]byte
+	storedBlobs map[digest.Digest][]byte
 }
 
 func (d *memoryImageDest) Reference() types.ImageReference {
@@ -350,15 +406,18 @@ func (d *memoryImageDest) Close() {
 func (d *memoryImageDest) SupportedManifestMIMETypes() []string {
 	panic("Unexpected call to a mock function")
 }
-func (d *memoryImageDest) SupportsSignatures() error {
+func (d *memoryImageDest) SupportsSignatures(ctx context.Context) error {
 	panic("Unexpected call to a mock function")
 }
-func (d *memoryImageDest) ShouldCompressLayers() bool {
+func (d *memoryImageDest) DesiredLayerCompression() types.LayerCompression {
 	panic("Unexpected call to a mock function")
 }
-func (d *memoryImageDest) PutBlob(stream io.Reader, inputInfo types.BlobInfo) (types.BlobInfo, error) {
+func (d *memoryImageDest) HasThreadSafePutBlob() bool {
+	panic("Unexpected call to a mock function")
+}
+func (d *memoryImageDest) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
 	if d.storedBlobs == nil {
-		d.storedBlobs = make(map[string][]byte)
+		d.storedBlobs = make(map[digest.Digest][]byte)
 	}
 	if inputInfo.Digest == "" {
 		panic("inputInfo.Digest unexpectedly empty")
@@ -370,13 +429,25 @@ func (d *memoryImageDest) PutBlob(stream io.Reader, inputInfo types.BlobInfo) (t
 	d.storedBlobs[inputInfo.Digest] = contents
 	return types.BlobInfo{Digest: inputInfo.Digest, Size: int64(len(contents))}, nil
 }
-func (d *memoryImageDest) PutManifest([]byte) error {
+func (d *memoryImageDest) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	if d.storedBlobs == nil {
+		d.storedBlobs = make(map[digest.Digest][]byte)
+	}
+	if info.Digest == "" {
+		panic("info.Digest unexpectedly empty")
+	}
+	if b, ok := d.storedBlobs[info.Digest]; ok {
+		return true, types.BlobInfo{Digest: info.Digest, Size: int64(len(b))}, nil
+	}
+	return false, types.BlobInfo{}, nil
+}
+func (d *memoryImageDest) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
 	panic("Unexpected call to a mock function")
 }
-func (d *memoryImageDest) PutSignatures(signatures [][]byte) error {
+func (d *memoryImageDest) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
 	panic("Unexpected call to a mock function")
 }
-func (d *memoryImageDest) Commit() error {
+func (d *memoryImageDest) Commit(ctx context.Context) error {
 	panic("Unexpected call to a mock function")
 }
 
@@ -386,12 +457,12 @@ func TestManifestSchema2UpdatedImage(t *testing.T) {
 
 	// LayerInfos:
 	layerInfos := append(original.LayerInfos()[1:], original.LayerInfos()[0])
-	res, err := original.UpdatedImage(types.ManifestUpdateOptions{
+	res, err := original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
 		LayerInfos: layerInfos,
 	})
 	require.NoError(t, err)
 	assert.Equal(t, layerInfos, res.LayerInfos())
-	_, err = original.UpdatedImage(types.ManifestUpdateOptions{
+	_, err = original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
 		LayerInfos: append(layerInfos, layerInfos[0]),
 	})
 	assert.Error(t, err)
@@ -401,8 +472,9 @@ func TestManifestSchema2UpdatedImage(t *testing.T) {
 	for _, mime := range []string{
 		manifest.DockerV2Schema1MediaType,
 		manifest.DockerV2Schema1SignedMediaType,
+		imgspecv1.MediaTypeImageManifest,
 	} {
-		_, err = original.UpdatedImage(types.ManifestUpdateOptions{
+		_, err = original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
 			ManifestMIMEType: mime,
 			InformationOnly: types.ManifestUpdateInformation{
 				Destination: &memoryImageDest{ref: originalSrc.ref},
@@ -414,14 +486,16 @@ func TestManifestSchema2UpdatedImage(t *testing.T) {
 		manifest.DockerV2Schema2MediaType, // This indicates a confused caller, not a no-op
 		"this is invalid",
 	} {
-		_, err = original.UpdatedImage(types.ManifestUpdateOptions{
+		_, err = original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
 			ManifestMIMEType: mime,
 		})
 		assert.Error(t, err, mime)
 	}
 
-	// m hasn’t been changed:
+	// m hasn’t been changed, apart from memoizing the config blob:
 	m2 := manifestSchema2FromFixture(t, originalSrc, "schema2.json")
+	_, err = m2.ConfigBlob(context.Background())
+	require.NoError(t, err)
 	typedOriginal, ok := original.(*manifestSchema2)
 	require.True(t, ok)
 	typedM2, ok := m2.(*manifestSchema2)
@@ -433,7 +507,7 @@ func TestConvertToManifestSchema1(t *testing.T) {
 	originalSrc := newSchema2ImageSource(t, "httpd-copy:latest")
 	original := manifestSchema2FromFixture(t, originalSrc, "schema2.json")
 	memoryDest := &memoryImageDest{ref: originalSrc.ref}
-	res, err := original.UpdatedImage(types.ManifestUpdateOptions{
+	res, err := original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
 		ManifestMIMEType: manifest.DockerV2Schema1SignedMediaType,
 		InformationOnly: types.ManifestUpdateInformation{
 			Destination: memoryDest,
@@ -441,7 +515,7 @@ func TestConvertToManifestSchema1(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	convertedJSON, mt, err := res.Manifest()
+	convertedJSON, mt, err := res.Manifest(context.Background())
 	require.NoError(t, err)
 	assert.Equal(t, manifest.DockerV2Schema1SignedMediaType, mt)
 
@@ -463,3 +537,44 @@ func TestConvertToManifestSchema1(t *testing.T) {
 
 	// FIXME? Test also the various failure cases, if only to see that we don't crash?
 }
+
+// countingImageDest is a memoryImageDest which counts the blob operations.
+type countingImageDest struct {
+	memoryImageDest
+	tryReusingCalls int
+	putBlobCalls    int
+}
+
+func (d *countingImageDest) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	d.putBlobCalls++
+	return d.memoryImageDest.PutBlob(ctx, stream, inputInfo, isConfig)
+}
+func (d *countingImageDest) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	d.tryReusingCalls++
+	return d.memoryImageDest.TryReusingBlob(ctx, info, cache, canSubstitute)
+}
+
+func TestConvertToManifestSchema1EmptyLayer(t *testing.T) {
+	originalSrc := newSchema2ImageSource(t, "httpd-copy:latest")
+	original := manifestSchema2FromFixture(t, originalSrc, "schema2.json")
+	convert := func(dest types.ImageDestination) {
+		_, err := original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+			ManifestMIMEType: manifest.DockerV2Schema1SignedMediaType,
+			InformationOnly: types.ManifestUpdateInformation{
+				Destination: dest,
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	// The empty layer is uploaded only once, although it is referenced by many history entries…
+	dest := &countingImageDest{memoryImageDest: memoryImageDest{ref: originalSrc.ref}}
+	convert(dest)
+	assert.Equal(t, 1, dest.tryReusingCalls)
+	assert.Equal(t, 1, dest.putBlobCalls)
+	assert.Equal(t, gzippedEmptyLayer, dest.storedBlobs[gzippedEmptyLayerDigest])
+	// … and not at all if the destination already contains it.
+	convert(dest)
+	assert.Equal(t, 2, dest.tryReusingCalls)
+	assert.Equal(t, 1, dest.putBlobCalls)
+}
@@ -0,0 +1,114 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// HistoryEntry is a single step of the build history of an image, as reported by History.
+type HistoryEntry struct {
+	Layer     digest.Digest // The digest of the layer created by this step, or "" if the step did not create a layer (shown as <missing> by docker history)
+	Size      int64         // The size of the layer, 0 if the step did not create a layer, or -1 if unknown
+	Created   time.Time
+	CreatedBy string
+	Author    string
+	Comment   string
+}
+
+// History returns the build history of img, equivalent to the output of docker history: the most recent step first,
+// with the steps which created a layer correlated with the layers of the image.
+// It supports all manifest types; for images using schema1 manifests, the layer sizes are unknown.
+func History(ctx context.Context, img types.Image) ([]HistoryEntry, error) {
+	var history []imageHistory  // The oldest step first
+	var layers []types.BlobInfo // The layers created by history, in the same order
+	configBlob, err := img.ConfigBlob(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading image config: %v", err)
+	}
+	if configBlob != nil {
+		var config image
+		if err := json.Unmarshal(configBlob, &config); err != nil {
+			return nil, fmt.Errorf("Error parsing image config: %v", err)
+		}
+		history = config.History
+		layers = img.LayerInfos()
+		if len(history) == 0 { // Images built without recording history; report only the layers.
+			history = make([]imageHistory, len(layers))
+		}
+	} else {
+		manifestBlob, mt, err := img.Manifest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if mt != manifest.DockerV2Schema1MediaType && mt != manifest.DockerV2Schema1SignedMediaType {
+			return nil, fmt.Errorf("Image with manifest type %s has no config", mt)
+		}
+		m, err := manifestSchema1FromManifest(manifestBlob)
+		if err != nil {
+			return nil, err
+		}
+		history, layers, err = m.(*manifestSchema1).history()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := make([]HistoryEntry, len(history))
+	layerIndex := 0
+	for i, h := range history {
+		entry := HistoryEntry{
+			Created:   h.Created,
+			CreatedBy: h.CreatedBy,
+			Author:    h.Author,
+			Comment:   h.Comment,
+		}
+		if !h.EmptyLayer {
+			if layerIndex >= len(layers) {
+				return nil, fmt.Errorf("Inconsistent image: history refers to more than %d layers", len(layers))
+			}
+			entry.Layer = layers[layerIndex].Digest
+			entry.Size = layers[layerIndex].Size
+			layerIndex++
+		}
+		res[len(history)-1-i] = entry
+	}
+	if layerIndex != len(layers) {
+		return nil, fmt.Errorf("Inconsistent image: history refers to %d layers, but the image has %d", layerIndex, len(layers))
+	}
+	return res, nil
+}
+
+// history returns the history entries of m, the oldest first, and the layers created by the entries, in the same order.
+func (m *manifestSchema1) history() ([]imageHistory, []types.BlobInfo, error) {
+	if len(m.History) != len(m.FSLayers) {
+		return nil, nil, fmt.Errorf("Inconsistent schema 1 manifest: %d history entries, %d fsLayers entries", len(m.History), len(m.FSLayers))
+	}
+	history := make([]imageHistory, len(m.History))
+	layers := []types.BlobInfo{}
+	for v1Index := len(m.History) - 1; v1Index >= 0; v1Index-- {
+		v2Index := (len(m.History) - 1) - v1Index
+
+		var v1compat v1Compatibility
+		if err := json.Unmarshal([]byte(m.History[v1Index].V1Compatibility), &v1compat); err != nil {
+			return nil, nil, fmt.Errorf("Error decoding history entry %d: %v", v1Index, err)
+		}
+		history[v2Index] = imageHistory{
+			Created:    v1compat.Created,
+			Author:     v1compat.Author,
+			CreatedBy:  strings.Join(v1compat.ContainerConfig.Cmd, " "),
+			Comment:    v1compat.Comment,
+			EmptyLayer: v1compat.ThrowAway,
+		}
+		if !v1compat.ThrowAway {
+			layers = append(layers, types.BlobInfo{Digest: m.FSLayers[v1Index].BlobSum, Size: -1})
+		}
+	}
+	return history, layers, nil
+}
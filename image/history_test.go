@@ -0,0 +1,81 @@
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistorySchema2(t *testing.T) {
+	configJSON, err := ioutil.ReadFile("fixtures/schema2-config.json")
+	require.NoError(t, err)
+	img := memoryImageFromManifest(manifestSchema2FromComponentsLikeFixture(configJSON))
+	layers := img.LayerInfos()
+
+	history, err := History(context.Background(), img)
+	require.NoError(t, err)
+	require.Len(t, history, 15)
+	assert.Equal(t, HistoryEntry{
+		Created:   time.Date(2016, 9, 23, 23, 20, 45, 789764590, time.UTC),
+		CreatedBy: `/bin/sh -c #(nop)  CMD ["httpd-foreground"]`,
+	}, history[0])
+	// The steps which created layers are correlated with the layers, the most recent one first.
+	withLayers := []HistoryEntry{}
+	for _, h := range history {
+		if h.Layer != "" {
+			withLayers = append(withLayers, h)
+		}
+	}
+	require.Len(t, withLayers, len(layers))
+	for i, h := range withLayers {
+		assert.Equal(t, layers[len(layers)-1-i].Digest, h.Layer)
+		assert.Equal(t, layers[len(layers)-1-i].Size, h.Size)
+	}
+
+	// Images without recorded history report only the layers.
+	img = memoryImageFromManifest(manifestSchema2FromComponentsLikeFixture([]byte(`{}`)))
+	history, err = History(context.Background(), img)
+	require.NoError(t, err)
+	require.Len(t, history, len(layers))
+	assert.Equal(t, layers[len(layers)-1].Digest, history[0].Layer)
+
+	// Inconsistent history is rejected.
+	img = memoryImageFromManifest(manifestSchema2FromComponentsLikeFixture([]byte(`{"history":[{"created_by":"a"}]}`)))
+	_, err = History(context.Background(), img)
+	assert.Error(t, err)
+	img = memoryImageFromManifest(manifestSchema2FromComponentsLikeFixture([]byte(`{"history":[{},{},{},{},{},{}]}`)))
+	_, err = History(context.Background(), img)
+	assert.Error(t, err)
+	img = memoryImageFromManifest(manifestSchema2FromComponentsLikeFixture([]byte(`invalid`)))
+	_, err = History(context.Background(), img)
+	assert.Error(t, err)
+}
+
+func TestHistorySchema1(t *testing.T) {
+	manifestBlob, err := ioutil.ReadFile("fixtures/schema2-to-schema1-by-docker.json")
+	require.NoError(t, err)
+	m, err := manifestSchema1FromManifest(manifestBlob)
+	require.NoError(t, err)
+
+	history, err := History(context.Background(), memoryImageFromManifest(m))
+	require.NoError(t, err)
+	require.Len(t, history, 15)
+	assert.Equal(t, HistoryEntry{
+		Created:   time.Date(2016, 9, 23, 23, 20, 45, 789764590, time.UTC),
+		CreatedBy: `/bin/sh -c #(nop)  CMD ["httpd-foreground"]`,
+	}, history[0])
+	layerCount := 0
+	for _, h := range history {
+		if h.Layer != "" {
+			layerCount++
+			assert.Equal(t, int64(-1), h.Size)
+		}
+	}
+	assert.Equal(t, 5, layerCount)
+	assert.Equal(t, digest.Digest("sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb"), history[len(history)-1].Layer)
+}
@@ -1,14 +1,26 @@
 package image
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"github.com/docker/engine-api/types/strslice"
 
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/iolimits"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// ErrUnsupportedManifestMIMEType is returned (possibly wrapped; use errors.Is) if an image manifest uses a MIME type
+// which is not supported by this package, and it can not be parsed as a docker schema1 manifest either.
+var ErrUnsupportedManifestMIMEType = errors.New("unsupported manifest MIME type")
+
 type config struct {
 	Cmd    strslice.StrSlice
 	Labels map[string]string
@@ -45,9 +57,9 @@ type imageHistory struct {
 }
 
 type rootFS struct {
-	Type      string   `json:"type"`
-	DiffIDs   []string `json:"diff_ids,omitempty"`
-	BaseLayer string   `json:"base_layer,omitempty"`
+	Type      string          `json:"type"`
+	DiffIDs   []digest.Digest `json:"diff_ids,omitempty"`
+	BaseLayer string          `json:"base_layer,omitempty"`
 }
 
 // genericManifest is an interface for parsing, modifying image manifests and related data.
@@ -62,22 +74,24 @@ type genericManifest interface {
 	ConfigInfo() types.BlobInfo
 	// ConfigBlob returns the blob described by ConfigInfo, iff ConfigInfo().Digest != ""; nil otherwise.
 	// The result is cached; it is OK to call this however often you need.
-	ConfigBlob() ([]byte, error)
+	ConfigBlob(ctx context.Context) ([]byte, error)
 	// LayerInfos returns a list of BlobInfos of layers referenced by this image, in order (the root layer first, and then successive layered layers).
 	// The Digest field is guaranteed to be provided; Size may be -1.
 	// WARNING: The list may contain duplicates, and they are semantically relevant.
 	LayerInfos() []types.BlobInfo
-	imageInspectInfo() (*types.ImageInspectInfo, error) // To be called by inspectManifest
+	imageInspectInfo(ctx context.Context) (*types.ImageInspectInfo, error) // To be called by inspectManifest
 	// UpdatedImageNeedsLayerDiffIDs returns true iff UpdatedImage(options) needs InformationOnly.LayerDiffIDs.
 	// This is a horribly specific interface, but computing InformationOnly.LayerDiffIDs can be very expensive to compute
 	// (most importantly it forces us to download the full layers even if they are already present at the destination).
 	UpdatedImageNeedsLayerDiffIDs(options types.ManifestUpdateOptions) bool
 	// UpdatedImage returns a types.Image modified according to options.
 	// This does not change the state of the original Image object.
-	UpdatedImage(options types.ManifestUpdateOptions) (types.Image, error)
+	UpdatedImage(ctx context.Context, options types.ManifestUpdateOptions) (types.Image, error)
 }
 
-func manifestInstanceFromBlob(src types.ImageSource, manblob []byte, mt string) (genericManifest, error) {
+// manifestInstanceFromBlob returns a genericManifest implementation for (manblob, mt) in src.
+// If manblob is a manifest list, the platform-specific manifest is chosen according to sys, which may be nil.
+func manifestInstanceFromBlob(ctx context.Context, sys *types.SystemContext, src types.ImageSource, manblob []byte, mt string) (genericManifest, error) {
 	switch mt {
 	// "application/json" is a valid v2s1 value per https://github.com/docker/distribution/blob/master/docs/spec/manifest-v2-1.md .
 	// This works for now, when nothing else seems to return "application/json"; if that were not true, the mapping/detection might
@@ -85,9 +99,11 @@ func manifestInstanceFromBlob(src types.ImageSource, manblob []byte, mt string)
 	case manifest.DockerV2Schema1MediaType, manifest.DockerV2Schema1SignedMediaType, "application/json":
 		return manifestSchema1FromManifest(manblob)
 	case manifest.DockerV2Schema2MediaType:
-		return manifestSchema2FromManifest(src, manblob)
-	case manifest.DockerV2ListMediaType:
-		return manifestSchema2FromManifestList(src, manblob)
+		return manifestSchema2FromManifest(sys, src, manblob)
+	case manifest.DockerV2ListMediaType, imgspecv1.MediaTypeImageManifestList:
+		return manifestFromManifestList(ctx, sys, src, manblob, mt)
+	case imgspecv1.MediaTypeImageManifest:
+		return manifestOCI1FromManifest(sys, src, manblob)
 	default:
 		// If it's not a recognized manifest media type, or we have failed determining the type, we'll try one last time
 		// to deserialize using v2s1 as per https://github.com/docker/distribution/blob/master/manifests.go#L108
@@ -98,20 +114,51 @@ func manifestInstanceFromBlob(src types.ImageSource, manblob []byte, mt string)
 		// because requests for manifests are
 		// redirected to a content distribution
 		// network which is configured that way. See https://bugzilla.redhat.com/show_bug.cgi?id=1389442
-		return manifestSchema1FromManifest(manblob)
+		m, err := manifestSchema1FromManifest(manblob)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrUnsupportedManifestMIMEType, mt, err)
+		}
+		return m, nil
+	}
+}
+
+// fetchConfigBlob reads the config blob described by info from src, and verifies that it matches info.Digest.
+// It does not read more than maxSize bytes, nor more than info.Size if that is known, so that a malicious or broken src
+// can not make us exhaust memory.
+func fetchConfigBlob(ctx context.Context, src types.ImageSource, info types.BlobInfo, maxSize int64) ([]byte, error) {
+	if info.Size > maxSize {
+		return nil, fmt.Errorf("Config blob %s is too large (%d bytes, the limit is %d): %w", info.Digest, info.Size, maxSize, iolimits.ErrTooLarge)
+	}
+	limit := maxSize
+	if info.Size > 0 {
+		limit = info.Size
+	}
+	stream, _, err := src.GetBlob(ctx, info, blobinfocache.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	digester := info.Digest.Algorithm().Digester()
+	blob, err := iolimits.ReadAtMost(io.TeeReader(stream, digester.Hash()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading config blob %s: %w", info.Digest, err)
+	}
+	if computedDigest := digester.Digest(); computedDigest != info.Digest {
+		return nil, fmt.Errorf("Download config.json digest %s does not match expected %s", computedDigest, info.Digest)
 	}
+	return blob, nil
 }
 
 // inspectManifest is an implementation of types.Image.Inspect
-func inspectManifest(m genericManifest) (*types.ImageInspectInfo, error) {
-	info, err := m.imageInspectInfo()
+func inspectManifest(ctx context.Context, m genericManifest) (*types.ImageInspectInfo, error) {
+	info, err := m.imageInspectInfo(ctx)
 	if err != nil {
 		return nil, err
 	}
 	layers := m.LayerInfos()
 	info.Layers = make([]string, len(layers))
 	for i, layer := range layers {
-		info.Layers[i] = layer.Digest
+		info.Layers[i] = layer.Digest.String()
 	}
 	return info, nil
 }
@@ -0,0 +1,39 @@
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// ManifestInspectInfo is the information about an image which can be determined from its manifest alone,
+// as returned by InspectManifest.
+type ManifestInspectInfo struct {
+	Digest   digest.Digest    // The digest of the manifest returned by types.Image.Manifest (of the manifest list, if the image was chosen from a list)
+	MIMEType string           // The MIME type of the manifest returned by types.Image.Manifest
+	Config   types.BlobInfo   // The config blob, or a BlobInfo{Digest:""} if there isn't a separate config (schema1)
+	Layers   []types.BlobInfo // The layers, with their digests, sizes (or -1 if unknown) and media types, the root layer first
+}
+
+// InspectManifest returns the information about img which can be determined from its manifest alone.
+// Unlike types.Image.Inspect, it does not read the config blob, so it is much faster when inspecting many images in a registry;
+// use img.Inspect for information stored in the config, like the creation time, labels, or platform.
+func InspectManifest(ctx context.Context, img types.Image) (*ManifestInspectInfo, error) {
+	manifestBlob, mimeType, err := img.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	if err != nil {
+		return nil, fmt.Errorf("Error computing manifest digest: %v", err)
+	}
+	return &ManifestInspectInfo{
+		Digest:   manifestDigest,
+		MIMEType: mimeType,
+		Config:   img.ConfigInfo(),
+		Layers:   img.LayerInfos(),
+	}, nil
+}
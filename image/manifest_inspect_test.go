@@ -0,0 +1,57 @@
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// manifestOnlyImageSource is a types.ImageSource which only provides a reference; reading any data from it panics.
+type manifestOnlyImageSource struct {
+	unusedImageSource // We inherit almost all of the methods, which just panic()
+	ref               reference.Named
+}
+
+func (s manifestOnlyImageSource) Reference() types.ImageReference {
+	return refImageReferenceMock{s.ref}
+}
+
+func TestInspectManifest(t *testing.T) {
+	ref, err := reference.ParseNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+	for _, c := range []struct {
+		fixture, mimeType string
+		config            types.BlobInfo
+		layers            int
+	}{
+		{"schema2.json", manifest.DockerV2Schema2MediaType, types.BlobInfo{
+			Digest:    "sha256:9ca4bda0a6b3727a6ffcc43e981cad0f24e2ec79d338f6ba325b4dfd0756fb8f",
+			Size:      5940,
+			MediaType: "application/octet-stream",
+		}, 5},
+		{"schema2-to-schema1-by-docker.json", manifest.DockerV2Schema1SignedMediaType, types.BlobInfo{}, 15},
+	} {
+		manifestBlob, err := ioutil.ReadFile(filepath.Join("fixtures", c.fixture))
+		require.NoError(t, err)
+		// manifestOnlyImageSource panics if the config (or any other blob) is read from the source.
+		img, err := FromUnparsedImage(context.Background(), nil, UnparsedFromSourceWithManifest(manifestOnlyImageSource{ref: ref}, manifestBlob, c.mimeType))
+		require.NoError(t, err, c.fixture)
+
+		info, err := InspectManifest(context.Background(), img)
+		require.NoError(t, err, c.fixture)
+		expectedDigest, err := manifest.Digest(manifestBlob)
+		require.NoError(t, err, c.fixture)
+		assert.Equal(t, expectedDigest, info.Digest, c.fixture)
+		assert.Equal(t, c.mimeType, info.MIMEType, c.fixture)
+		assert.Equal(t, c.config, info.Config, c.fixture)
+		assert.Equal(t, img.LayerInfos(), info.Layers, c.fixture)
+		assert.Len(t, info.Layers, c.layers, c.fixture)
+	}
+}
@@ -0,0 +1,45 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containers/image/pkg/iolimits"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchConfigBlob(t *testing.T) {
+	realConfigJSON, err := ioutil.ReadFile("fixtures/schema2-config.json")
+	require.NoError(t, err)
+	src := configBlobImageSource{unusedImageSource{}, func(digest digest.Digest) (io.ReadCloser, int64, error) {
+		return ioutil.NopCloser(bytes.NewReader(realConfigJSON)), int64(len(realConfigJSON)), nil
+	}}
+	configDigest := digest.Digest("sha256:9ca4bda0a6b3727a6ffcc43e981cad0f24e2ec79d338f6ba325b4dfd0756fb8f")
+	size := int64(len(realConfigJSON))
+
+	for _, c := range []struct {
+		size, maxSize int64
+		success       bool
+	}{
+		{size, size, true},          // Exact size
+		{-1, size, true},            // Unknown size, within the limit
+		{size, size - 1, false},     // Declared size over the limit
+		{-1, size - 1, false},       // Unknown size, stream over the limit
+		{size - 1, size * 2, false}, // Stream longer than the declared size
+	} {
+		blob, err := fetchConfigBlob(context.Background(), src, types.BlobInfo{Digest: configDigest, Size: c.size}, c.maxSize)
+		if c.success {
+			require.NoError(t, err, "%#v", c)
+			assert.Equal(t, realConfigJSON, blob, "%#v", c)
+		} else {
+			assert.True(t, errors.Is(err, iolimits.ErrTooLarge), "%#v: %v", c, err)
+		}
+	}
+}
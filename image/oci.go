@@ -0,0 +1,185 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/iolimits"
+	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type manifestOCI1 struct {
+	src               types.ImageSource // May be nil if configBlob is not nil
+	maxConfigSize     int64             // The maximum size of the config blob read from src
+	configBlob        []byte            // If set, corresponds to contents of ConfigDescriptor.
+	SchemaVersion     int               `json:"schemaVersion"`
+	MediaType         string            `json:"mediaType"`
+	ConfigDescriptor  descriptor        `json:"config"`
+	LayersDescriptors []descriptor      `json:"layers"`
+}
+
+// manifestOCI1FromManifest returns a genericManifest for manifest in src; sys (which may be nil) determines the limits used when reading the config blob.
+func manifestOCI1FromManifest(sys *types.SystemContext, src types.ImageSource, manifest []byte) (genericManifest, error) {
+	oci := manifestOCI1{src: src, maxConfigSize: iolimits.MaxConfigBlobSize(sys)}
+	if err := json.Unmarshal(manifest, &oci); err != nil {
+		return nil, err
+	}
+	if err := validateDescriptors(oci.ConfigDescriptor, oci.LayersDescriptors); err != nil {
+		return nil, err
+	}
+	return &oci, nil
+}
+
+// manifestOCI1FromComponents builds a new manifestOCI1 from the supplied data:
+func manifestOCI1FromComponents(config descriptor, configBlob []byte, layers []descriptor) genericManifest {
+	return &manifestOCI1{
+		src:               nil,
+		configBlob:        configBlob,
+		SchemaVersion:     2,
+		MediaType:         imgspecv1.MediaTypeImageManifest,
+		ConfigDescriptor:  config,
+		LayersDescriptors: layers,
+	}
+}
+
+func (m *manifestOCI1) serialize() ([]byte, error) {
+	return json.Marshal(*m)
+}
+
+func (m *manifestOCI1) manifestMIMEType() string {
+	return imgspecv1.MediaTypeImageManifest
+}
+
+// ConfigInfo returns a complete BlobInfo for the separate config object, or a BlobInfo{Digest:""} if there isn't a separate object.
+// Note that the config object may not exist in the underlying storage in the return value of UpdatedImage! Use ConfigBlob() below.
+func (m *manifestOCI1) ConfigInfo() types.BlobInfo {
+	return m.ConfigDescriptor.blobInfo()
+}
+
+// ConfigBlob returns the blob described by ConfigInfo, iff ConfigInfo().Digest != ""; nil otherwise.
+// The result is cached; it is OK to call this however often you need.
+func (m *manifestOCI1) ConfigBlob(ctx context.Context) ([]byte, error) {
+	if m.configBlob == nil {
+		if m.src == nil {
+			return nil, fmt.Errorf("Internal error: neither src nor configBlob set in manifestOCI1")
+		}
+		blob, err := fetchConfigBlob(ctx, m.src, m.ConfigInfo(), m.maxConfigSize)
+		if err != nil {
+			return nil, err
+		}
+		m.configBlob = blob
+	}
+	return m.configBlob, nil
+}
+
+// LayerInfos returns a list of BlobInfos of layers referenced by this image, in order (the root layer first, and then successive layered layers).
+// The Digest field is guaranteed to be provided; Size may be -1.
+// WARNING: The list may contain duplicates, and they are semantically relevant.
+func (m *manifestOCI1) LayerInfos() []types.BlobInfo {
+	blobs := []types.BlobInfo{}
+	for _, layer := range m.LayersDescriptors {
+		blobs = append(blobs, layer.blobInfo())
+	}
+	return blobs
+}
+
+func (m *manifestOCI1) imageInspectInfo(ctx context.Context) (*types.ImageInspectInfo, error) {
+	config, err := m.ConfigBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v1 := &v1Image{}
+	if err := json.Unmarshal(config, v1); err != nil {
+		return nil, err
+	}
+	i := &types.ImageInspectInfo{
+		DockerVersion: v1.DockerVersion,
+		Created:       v1.Created,
+		Architecture:  v1.Architecture,
+		Os:            v1.OS,
+	}
+	if v1.Config != nil {
+		i.Labels = v1.Config.Labels
+	}
+	return i, nil
+}
+
+// UpdatedImageNeedsLayerDiffIDs returns true iff UpdatedImage(options) needs InformationOnly.LayerDiffIDs.
+// This is a horribly specific interface, but computing InformationOnly.LayerDiffIDs can be very expensive to compute
+// (most importantly it forces us to download the full layers even if they are already present at the destination).
+func (m *manifestOCI1) UpdatedImageNeedsLayerDiffIDs(options types.ManifestUpdateOptions) bool {
+	return false
+}
+
+// UpdatedImage returns a types.Image modified according to options.
+// This does not change the state of the original Image object.
+func (m *manifestOCI1) UpdatedImage(ctx context.Context, options types.ManifestUpdateOptions) (types.Image, error) {
+	if options.ManifestMIMEType != "" {
+		// Conversions need the config blob; read it via m, so that it is memoized for m and all of its updated copies
+		// instead of being fetched again for each copy.
+		if _, err := m.ConfigBlob(ctx); err != nil {
+			return nil, err
+		}
+	}
+	copy := *m // NOTE: This is not a deep copy, it still shares slices etc.
+	if options.LayerInfos != nil {
+		if len(copy.LayersDescriptors) != len(options.LayerInfos) {
+			return nil, fmt.Errorf("Error preparing updated manifest: layer count changed from %d to %d", len(copy.LayersDescriptors), len(options.LayerInfos))
+		}
+		copy.LayersDescriptors = make([]descriptor, len(options.LayerInfos))
+		for i, info := range options.LayerInfos {
+			mediaType, err := manifest.OCI1LayerMediaType(m.LayersDescriptors[i].MediaType, info)
+			if err != nil {
+				return nil, fmt.Errorf("Error preparing updated manifest: %v", err)
+			}
+			copy.LayersDescriptors[i].MediaType = mediaType
+			copy.LayersDescriptors[i].Digest = info.Digest
+			copy.LayersDescriptors[i].Size = info.Size
+			copy.LayersDescriptors[i].URLs = info.URLs
+			switch {
+			case info.Annotations != nil:
+				copy.LayersDescriptors[i].Annotations = info.Annotations
+			case info.CompressionOperation == types.PreserveOriginal:
+				copy.LayersDescriptors[i].Annotations = m.LayersDescriptors[i].Annotations
+			default: // Annotations of the original blob may not apply to the recompressed one.
+				copy.LayersDescriptors[i].Annotations = nil
+			}
+		}
+	}
+
+	switch options.ManifestMIMEType {
+	case "": // No conversion, OK
+	case manifest.DockerV2Schema2MediaType:
+		return copy.convertToManifestSchema2(ctx)
+	default:
+		return nil, fmt.Errorf("Conversion of image manifest from %s to %s is not implemented", imgspecv1.MediaTypeImageManifest, options.ManifestMIMEType)
+	}
+
+	return memoryImageFromManifest(&copy), nil
+}
+
+func (m *manifestOCI1) convertToManifestSchema2(ctx context.Context) (types.Image, error) {
+	// Create a copy of the descriptor.
+	config := m.ConfigDescriptor
+	config.MediaType = manifest.DockerV2Schema2ConfigMediaType
+
+	layers := make([]descriptor, len(m.LayersDescriptors))
+	for idx := range layers {
+		mediaType, err := manifest.Schema2LayerMediaType(m.LayersDescriptors[idx].MediaType, types.BlobInfo{Digest: m.LayersDescriptors[idx].Digest})
+		if err != nil {
+			return nil, err
+		}
+		layers[idx] = m.LayersDescriptors[idx]
+		layers[idx].MediaType = mediaType
+	}
+
+	configBlob, err := m.ConfigBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m2 := manifestSchema2FromComponents(config, configBlob, layers)
+	return memoryImageFromManifest(m2), nil
+}
@@ -0,0 +1,245 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func manifestOCI1FromFixture(t *testing.T, src types.ImageSource, fixture string) genericManifest {
+	manifest, err := ioutil.ReadFile(filepath.Join("fixtures", fixture))
+	require.NoError(t, err)
+
+	m, err := manifestOCI1FromManifest(nil, src, manifest)
+	require.NoError(t, err)
+	return m
+}
+
+func TestManifestOCI1FromManifest(t *testing.T) {
+	// This just tests that the JSON can be loaded; we test that the parsed
+	// values are correctly returned in tests for the individual getter methods.
+	_ = manifestOCI1FromFixture(t, unusedImageSource{}, "oci1.json")
+
+	_, err := manifestOCI1FromManifest(nil, nil, []byte{})
+	assert.Error(t, err)
+}
+
+func TestManifestOCI1Serialize(t *testing.T) {
+	m := manifestOCI1FromFixture(t, unusedImageSource{}, "oci1.json")
+	serialized, err := m.serialize()
+	require.NoError(t, err)
+	var contents map[string]interface{}
+	err = json.Unmarshal(serialized, &contents)
+	require.NoError(t, err)
+
+	original, err := ioutil.ReadFile("fixtures/oci1.json")
+	require.NoError(t, err)
+	var originalContents map[string]interface{}
+	err = json.Unmarshal(original, &originalContents)
+	require.NoError(t, err)
+
+	assert.Equal(t, originalContents, contents)
+}
+
+func TestManifestOCI1ManifestMIMEType(t *testing.T) {
+	m := manifestOCI1FromFixture(t, unusedImageSource{}, "oci1.json")
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, m.manifestMIMEType())
+}
+
+func TestManifestOCI1ConfigInfoAndLayerInfos(t *testing.T) {
+	m1 := manifestOCI1FromFixture(t, unusedImageSource{}, "oci1.json")
+	m2 := manifestSchema2FromFixture(t, unusedImageSource{}, "schema2.json")
+	// The fixtures describe the same blobs, using different MIME types.
+	assert.Equal(t, m2.ConfigInfo().Digest, m1.ConfigInfo().Digest)
+	assert.Equal(t, m2.ConfigInfo().Size, m1.ConfigInfo().Size)
+	assert.Equal(t, imgspecv1.MediaTypeImageConfig, m1.ConfigInfo().MediaType)
+	l1, l2 := m1.LayerInfos(), m2.LayerInfos()
+	require.Len(t, l1, len(l2))
+	for i := range l1 {
+		assert.Equal(t, l2[i].Digest, l1[i].Digest)
+		assert.Equal(t, l2[i].Size, l1[i].Size)
+		assert.Equal(t, imgspecv1.MediaTypeImageLayer, l1[i].MediaType)
+	}
+}
+
+func TestManifestOCI1UpdatedImage(t *testing.T) {
+	originalSrc := newSchema2ImageSource(t, "httpd:latest")
+	original := manifestOCI1FromFixture(t, originalSrc, "oci1.json")
+
+	// LayerInfos:
+	layerInfos := append(original.LayerInfos()[1:], original.LayerInfos()[0])
+	res, err := original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: layerInfos,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, layerInfos, res.LayerInfos())
+	_, err = original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: append(layerInfos, layerInfos[0]),
+	})
+	assert.Error(t, err)
+
+	// Layer MIME types follow compression changes.
+	compressionInfos := original.LayerInfos()
+	compressionInfos[0].CompressionOperation = types.Decompress
+	res, err = original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: compressionInfos,
+	})
+	require.NoError(t, err)
+	ociRes, ok := res.(*memoryImage).genericManifest.(*manifestOCI1)
+	require.True(t, ok)
+	assert.Equal(t, manifest.OCI1LayerUncompressedMediaType, ociRes.LayersDescriptors[0].MediaType)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayer, ociRes.LayersDescriptors[1].MediaType)
+	compressionInfos = res.LayerInfos()
+	compressionInfos[0].CompressionOperation = types.Compress
+	res, err = ociRes.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: compressionInfos,
+	})
+	require.NoError(t, err)
+	ociRes2, ok := res.(*memoryImage).genericManifest.(*manifestOCI1)
+	require.True(t, ok)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayer, ociRes2.LayersDescriptors[0].MediaType)
+	compressionInfos[0].CompressionAlgorithm = types.GzipCompression
+	res, err = ociRes.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: compressionInfos,
+	})
+	require.NoError(t, err)
+	ociRes2, ok = res.(*memoryImage).genericManifest.(*manifestOCI1)
+	require.True(t, ok)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayer, ociRes2.LayersDescriptors[0].MediaType)
+	// zstd:chunked layers use the zstd MIME type, and record the annotations of the compressed layer.
+	compressionInfos[0].CompressionAlgorithm = types.ZstdChunkedCompression
+	compressionInfos[0].Annotations = map[string]string{"io.github.containers.zstd-chunked.manifest-position": "1:2:3:1"}
+	res, err = ociRes.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: compressionInfos,
+	})
+	require.NoError(t, err)
+	ociRes2, ok = res.(*memoryImage).genericManifest.(*manifestOCI1)
+	require.True(t, ok)
+	assert.Equal(t, manifest.OCI1LayerZstdMediaType, ociRes2.LayersDescriptors[0].MediaType)
+	assert.Equal(t, compressionInfos[0].Annotations, ociRes2.LayersDescriptors[0].Annotations)
+	assert.Equal(t, compressionInfos[0].Annotations, res.LayerInfos()[0].Annotations)
+	// Annotations are preserved if the layer is not modified, and dropped if it is recompressed.
+	preservedInfos := res.LayerInfos()
+	preservedInfos[0].Annotations = nil
+	res2, err := ociRes2.UpdatedImage(context.Background(), types.ManifestUpdateOptions{LayerInfos: preservedInfos})
+	require.NoError(t, err)
+	assert.Equal(t, compressionInfos[0].Annotations, res2.LayerInfos()[0].Annotations)
+	preservedInfos[0].CompressionOperation = types.Decompress
+	res2, err = ociRes2.UpdatedImage(context.Background(), types.ManifestUpdateOptions{LayerInfos: preservedInfos})
+	require.NoError(t, err)
+	assert.Nil(t, res2.LayerInfos()[0].Annotations)
+	assert.Equal(t, manifest.OCI1LayerUncompressedMediaType, res2.LayerInfos()[0].MediaType)
+	// Other compression algorithms can not be represented.
+	compressionInfos[0].CompressionAlgorithm = types.Bzip2Compression
+	_, err = ociRes.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: compressionInfos,
+	})
+	assert.Error(t, err)
+
+	for _, mime := range []string{
+		imgspecv1.MediaTypeImageManifest, // This indicates a confused caller, not a no-op
+		manifest.DockerV2Schema1SignedMediaType,
+		"this is invalid",
+	} {
+		_, err = original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+			ManifestMIMEType: mime,
+		})
+		assert.Error(t, err, mime)
+	}
+}
+
+func TestConvertOCI1AndSchema2(t *testing.T) {
+	originalSrc := newSchema2ImageSource(t, "httpd:latest")
+	schema2 := manifestSchema2FromFixture(t, originalSrc, "schema2.json")
+	oci1 := manifestOCI1FromFixture(t, originalSrc, "oci1.json")
+
+	res, err := schema2.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		ManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+	})
+	require.NoError(t, err)
+	convertedJSON, mt, err := res.Manifest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mt)
+	var converted, expected map[string]interface{}
+	err = json.Unmarshal(convertedJSON, &converted)
+	require.NoError(t, err)
+	expectedJSON, err := oci1.serialize()
+	require.NoError(t, err)
+	err = json.Unmarshal(expectedJSON, &expected)
+	require.NoError(t, err)
+	assert.Equal(t, expected, converted)
+
+	res, err = oci1.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+	})
+	require.NoError(t, err)
+	convertedJSON, mt, err = res.Manifest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, mt)
+	converted = nil
+	err = json.Unmarshal(convertedJSON, &converted)
+	require.NoError(t, err)
+	// The fixture uses application/octet-stream for the config, the conversion uses the official schema2 config type.
+	expected = nil
+	expectedJSON, err = ioutil.ReadFile("fixtures/schema2.json")
+	require.NoError(t, err)
+	err = json.Unmarshal(expectedJSON, &expected)
+	require.NoError(t, err)
+	expected["config"].(map[string]interface{})["mediaType"] = manifest.DockerV2Schema2ConfigMediaType
+	assert.Equal(t, expected, converted)
+
+	// Uncompressed layers can not be represented in schema2.
+	decompressedInfos := oci1.LayerInfos()
+	decompressedInfos[0].CompressionOperation = types.Decompress
+	_, err = oci1.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos:       decompressedInfos,
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+	})
+	require.Error(t, err)
+	assert.IsType(t, manifest.ManifestLayerCompressionIncompatibilityError{}, err)
+
+	// zstd-compressed layers can not be represented in schema2 either.
+	zstdInfos := oci1.LayerInfos()
+	zstdInfos[0].CompressionOperation = types.Compress
+	zstdInfos[0].CompressionAlgorithm = types.ZstdChunkedCompression
+	_, err = oci1.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos:       zstdInfos,
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+	})
+	require.Error(t, err)
+	assert.IsType(t, manifest.ManifestLayerCompressionIncompatibilityError{}, err)
+	// … but they can be used when converting from schema2 to OCI.
+	zstdInfos = schema2.LayerInfos()
+	zstdInfos[0].CompressionOperation = types.Compress
+	zstdInfos[0].CompressionAlgorithm = types.ZstdChunkedCompression
+	res, err = schema2.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos:       zstdInfos,
+		ManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+	})
+	require.NoError(t, err)
+	_, mt, err = res.Manifest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mt)
+	assert.Equal(t, manifest.OCI1LayerZstdMediaType, res.LayerInfos()[0].MediaType)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayer, res.LayerInfos()[1].MediaType)
+	// Unmodified layers get a MIME type matching their detected compression.
+	preservedInfos := schema2.LayerInfos()
+	preservedInfos[0].CompressionAlgorithm = types.ZstdCompression
+	preservedInfos[1].CompressionAlgorithm = types.GzipCompression
+	res, err = schema2.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos:       preservedInfos,
+		ManifestMIMEType: imgspecv1.MediaTypeImageManifest,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, manifest.OCI1LayerZstdMediaType, res.LayerInfos()[0].MediaType)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayer, res.LayerInfos()[1].MediaType)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayer, res.LayerInfos()[2].MediaType)
+}
@@ -0,0 +1,158 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/pkg/rootfs"
+	"github.com/containers/image/types"
+)
+
+// FlattenedRootfs applies the layers of img, read from src, in order, honoring whiteouts, and writes the resulting root filesystem
+// of the image to dest as a single uncompressed tar archive.
+// Each layer is read only once, the most recent one first, so the entries of the archive are not ordered as in the layers;
+// hard links are written at the end of the archive, after their targets.  Hard links to files which are not present
+// in the final filesystem (because they were deleted or replaced in a later layer) can not be represented, and cause an error.
+func FlattenedRootfs(ctx context.Context, src types.ImageSource, img types.Image, dest io.Writer) error {
+	f := rootfsFlattener{
+		tw:      tar.NewWriter(dest),
+		entries: map[string]bool{},
+		hidden:  map[string]struct{}{},
+		opaque:  map[string]struct{}{},
+	}
+	layers := img.LayerInfos()
+	for i := len(layers) - 1; i >= 0; i-- {
+		if err := f.applyLayer(ctx, src, layers[i]); err != nil {
+			return fmt.Errorf("Error flattening layer %s: %v", layers[i].Digest, err)
+		}
+	}
+	for _, hdr := range f.links {
+		if isDir, ok := f.entries[hdr.Linkname]; !ok || isDir {
+			return fmt.Errorf("Hard link %q refers to %q, which is not present in the flattened filesystem", hdr.Name, hdr.Linkname)
+		}
+		if err := f.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+	return f.tw.Close()
+}
+
+// rootfsFlattener collects the state of FlattenedRootfs, which processes the layers from the most recent one.
+// All paths are relative to the root, clean, and without a trailing slash.
+type rootfsFlattener struct {
+	tw      *tar.Writer
+	entries map[string]bool     // Paths already written by more recent layers; the value is true for directories.
+	hidden  map[string]struct{} // Paths deleted by whiteouts in more recent layers, including their contents.
+	opaque  map[string]struct{} // Directories whose contents in older layers are hidden by more recent layers.
+	links   []*tar.Header       // Hard links to write after all other entries
+}
+
+// applyLayer writes the entries of layer which are not hidden by more recent layers, and records its whiteouts
+// so that they apply to older layers.
+func (f *rootfsFlattener) applyLayer(ctx context.Context, src types.ImageSource, layer types.BlobInfo) error {
+	stream, _, err := src.GetBlob(ctx, layer, blobinfocache.NoCache)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	uncompressed, _, err := compression.DetectCompression(stream)
+	if err != nil {
+		return err
+	}
+	defer uncompressed.Close()
+
+	// Whiteouts only apply to older layers, not to the other entries of this one.
+	whiteouts := []string{}
+	opaqueDirs := []string{}
+	tr := tar.NewReader(uncompressed)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := cleanRootfsPath(hdr.Name)
+		if name == "" {
+			continue
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		if base == rootfs.WhiteoutOpaqueDir {
+			opaqueDirs = append(opaqueDirs, dir)
+			continue
+		}
+		if strings.HasPrefix(base, rootfs.WhiteoutPrefix) {
+			whiteouts = append(whiteouts, path.Join(dir, strings.TrimPrefix(base, rootfs.WhiteoutPrefix)))
+			continue
+		}
+		if !f.visible(name) {
+			continue
+		}
+		f.entries[name] = hdr.Typeflag == tar.TypeDir
+
+		out := *hdr
+		out.Name = name
+		if hdr.Typeflag == tar.TypeDir {
+			out.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			out.Linkname = cleanRootfsPath(hdr.Linkname)
+			f.links = append(f.links, &out)
+			continue
+		}
+		if err := f.tw.WriteHeader(&out); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			if _, err := io.Copy(f.tw, tr); err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range whiteouts {
+		f.hidden[p] = struct{}{}
+	}
+	for _, p := range opaqueDirs {
+		f.opaque[p] = struct{}{}
+	}
+	return nil
+}
+
+// visible returns true if name, an entry of the layer being processed, is not hidden by any more recent layer.
+func (f *rootfsFlattener) visible(name string) bool {
+	if _, ok := f.entries[name]; ok {
+		return false
+	}
+	if _, ok := f.hidden[name]; ok {
+		return false
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := f.hidden[dir]; ok {
+			return false
+		}
+		if _, ok := f.opaque[dir]; ok {
+			return false
+		}
+		if isDir, ok := f.entries[dir]; ok && !isDir {
+			return false
+		}
+	}
+	if _, ok := f.opaque[""]; ok { // An opaque root directory hides everything.
+		return false
+	}
+	return true
+}
+
+// cleanRootfsPath returns name, a path within a layer, relative to the root, clean and without a trailing slash;
+// it returns "" for the root itself.  This also prevents escaping the root via "..".
+func cleanRootfsPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
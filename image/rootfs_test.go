@@ -0,0 +1,139 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rootfsTestEntry struct {
+	name     string
+	typeflag byte
+	contents string
+	linkname string
+}
+
+// rootfsTestLayer returns a gzip-compressed tarball containing entries.
+func rootfsTestLayer(t *testing.T, entries ...rootfsTestEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		mode := int64(0644)
+		if e.typeflag == tar.TypeDir {
+			mode = 0755
+		}
+		err := tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: e.typeflag, Size: int64(len(e.contents)), Linkname: e.linkname, Mode: mode})
+		require.NoError(t, err)
+		_, err = tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// flattenTestImage returns a schema2 image with configBlob and the specified layers, the root layer first, and a source providing them.
+func flattenTestImage(configBlob string, layers ...[]byte) (types.ImageSource, types.Image) {
+	src := &blobsImageSource{blobs: map[digest.Digest][]byte{}}
+	descriptors := []descriptor{}
+	for _, l := range layers {
+		d := digest.FromBytes(l)
+		src.blobs[d] = l
+		descriptors = append(descriptors, descriptor{MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Size: int64(len(l)), Digest: d})
+	}
+	config := []byte(configBlob)
+	m := manifestSchema2FromComponents(descriptor{MediaType: "application/vnd.docker.container.image.v1+json", Size: int64(len(config)), Digest: digest.FromBytes(config)},
+		config, descriptors)
+	return src, memoryImageFromManifest(m)
+}
+
+// flattenedContents returns the entries of a flattened rootfs archive, mapping names to contents, link targets or "dir".
+func flattenedContents(t *testing.T, archive []byte) map[string]string {
+	res := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		_, dup := res[hdr.Name]
+		require.False(t, dup, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			res[hdr.Name] = "dir"
+		case tar.TypeSymlink, tar.TypeLink:
+			res[hdr.Name] = "-> " + hdr.Linkname
+		default:
+			contents, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+			res[hdr.Name] = string(contents)
+		}
+	}
+	return res
+}
+
+func TestFlattenedRootfs(t *testing.T) {
+	src, img := flattenTestImage(`{}`,
+		rootfsTestLayer(t,
+			rootfsTestEntry{name: "./", typeflag: tar.TypeDir},
+			rootfsTestEntry{name: "etc/", typeflag: tar.TypeDir},
+			rootfsTestEntry{name: "etc/passwd", typeflag: tar.TypeReg, contents: "root"},
+			rootfsTestEntry{name: "etc/removed", typeflag: tar.TypeReg, contents: "removed"},
+			rootfsTestEntry{name: "opaque/", typeflag: tar.TypeDir},
+			rootfsTestEntry{name: "opaque/old", typeflag: tar.TypeReg, contents: "old"},
+			rootfsTestEntry{name: "replaced/", typeflag: tar.TypeDir},
+			rootfsTestEntry{name: "replaced/child", typeflag: tar.TypeReg, contents: "child"},
+			rootfsTestEntry{name: "bin/", typeflag: tar.TypeDir},
+			rootfsTestEntry{name: "bin/sh", typeflag: tar.TypeReg, contents: "shell"},
+		),
+		rootfsTestLayer(t,
+			rootfsTestEntry{name: "etc/.wh.removed", typeflag: tar.TypeReg},
+			rootfsTestEntry{name: "etc/passwd", typeflag: tar.TypeReg, contents: "root,user"},
+			rootfsTestEntry{name: "opaque/.wh..wh..opq", typeflag: tar.TypeReg},
+			rootfsTestEntry{name: "opaque/new", typeflag: tar.TypeReg, contents: "new"},
+			rootfsTestEntry{name: "replaced", typeflag: tar.TypeReg, contents: "now a file"},
+			rootfsTestEntry{name: "bin/bash", typeflag: tar.TypeLink, linkname: "/bin/sh"},
+			rootfsTestEntry{name: "bin/ash", typeflag: tar.TypeSymlink, linkname: "sh"},
+		),
+		rootfsTestLayer(t,
+			rootfsTestEntry{name: "/etc/removed", typeflag: tar.TypeReg, contents: "re-added"},
+		),
+	)
+
+	var buf bytes.Buffer
+	err := FlattenedRootfs(context.Background(), src, img, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"etc/":       "dir",
+		"etc/passwd": "root,user",
+		// A whiteout only hides older layers
+		"etc/removed": "re-added",
+		"opaque/":     "dir",
+		"opaque/new":  "new",
+		"replaced":    "now a file",
+		"bin/":        "dir",
+		"bin/sh":      "shell",
+		"bin/bash":    "-> bin/sh",
+		"bin/ash":     "-> sh",
+	}, flattenedContents(t, buf.Bytes()))
+
+	// Hard links to files which do not exist in the final filesystem are rejected.
+	src, img = flattenTestImage(`{}`,
+		rootfsTestLayer(t, rootfsTestEntry{name: "a", typeflag: tar.TypeReg, contents: "a"}),
+		rootfsTestLayer(t, rootfsTestEntry{name: "b", typeflag: tar.TypeLink, linkname: "a"}),
+		rootfsTestLayer(t, rootfsTestEntry{name: ".wh.a", typeflag: tar.TypeReg}),
+	)
+	err = FlattenedRootfs(context.Background(), src, img, ioutil.Discard)
+	assert.Error(t, err)
+}
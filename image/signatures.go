@@ -0,0 +1,41 @@
+package image
+
+import (
+	"context"
+
+	"github.com/containers/image/types"
+)
+
+// GetSignaturesWithFormat returns the signatures of the image in src, in all formats supported by src,
+// using types.SignaturesWithFormatSource if src implements it, and GetSignatures and
+// types.SigstoreSignaturesSource.GetSigstoreSignatures otherwise.
+func GetSignaturesWithFormat(ctx context.Context, src types.ImageSource) ([]types.Signature, error) {
+	if withFormat, ok := src.(types.SignaturesWithFormatSource); ok {
+		return withFormat.GetSignaturesWithFormat(ctx)
+	}
+	simpleSigning, err := src.GetSignatures(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	sigstore := []types.SigstoreSignature{}
+	if sigstoreSrc, ok := src.(types.SigstoreSignaturesSource); ok {
+		sigstore, err = sigstoreSrc.GetSigstoreSignatures(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return SignaturesWithFormat(simpleSigning, sigstore), nil
+}
+
+// SignaturesWithFormat returns simpleSigning and sigstore signatures as a single list of types.Signature values,
+// in that order.
+func SignaturesWithFormat(simpleSigning [][]byte, sigstore []types.SigstoreSignature) []types.Signature {
+	res := make([]types.Signature, 0, len(simpleSigning)+len(sigstore))
+	for _, sig := range simpleSigning {
+		res = append(res, types.Signature{Format: types.SimpleSigningFormat, SimpleSigning: sig})
+	}
+	for _, sig := range sigstore {
+		res = append(res, types.Signature{Format: types.SigstoreFormat, Sigstore: sig})
+	}
+	return res
+}
@@ -0,0 +1,76 @@
+package image
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// simpleSigningImageSource is a mock of types.ImageSource returning simple signing signatures.
+type simpleSigningImageSource struct {
+	unusedImageSource
+	signatures [][]byte
+}
+
+func (s simpleSigningImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	return s.signatures, nil
+}
+
+// sigstoreImageSource is a mock of types.ImageSource which also implements types.SigstoreSignaturesSource.
+type sigstoreImageSource struct {
+	simpleSigningImageSource
+	sigstoreSignatures []types.SigstoreSignature
+}
+
+func (s sigstoreImageSource) GetSigstoreSignatures(ctx context.Context) ([]types.SigstoreSignature, error) {
+	return s.sigstoreSignatures, nil
+}
+
+// withFormatImageSource is a mock of types.ImageSource which implements types.SignaturesWithFormatSource.
+type withFormatImageSource struct {
+	unusedImageSource
+	signatures []types.Signature
+}
+
+func (s withFormatImageSource) GetSignaturesWithFormat(ctx context.Context) ([]types.Signature, error) {
+	return s.signatures, nil
+}
+
+func TestGetSignaturesWithFormat(t *testing.T) {
+	simpleSigning := [][]byte{[]byte("sig1"), []byte("sig2")}
+	sigstore := []types.SigstoreSignature{{Payload: []byte("payload"), Signature: []byte("sig3")}}
+	expected := []types.Signature{
+		{Format: types.SimpleSigningFormat, SimpleSigning: []byte("sig1")},
+		{Format: types.SimpleSigningFormat, SimpleSigning: []byte("sig2")},
+		{Format: types.SigstoreFormat, Sigstore: types.SigstoreSignature{Payload: []byte("payload"), Signature: []byte("sig3")}},
+	}
+
+	// Sources without sigstore support
+	sigs, err := GetSignaturesWithFormat(context.Background(), simpleSigningImageSource{signatures: simpleSigning})
+	require.NoError(t, err)
+	assert.Equal(t, expected[:2], sigs)
+	sigs, err = GetSignaturesWithFormat(context.Background(), simpleSigningImageSource{signatures: [][]byte{}})
+	require.NoError(t, err)
+	assert.Equal(t, []types.Signature{}, sigs)
+
+	// Sources with sigstore support
+	src := sigstoreImageSource{simpleSigningImageSource: simpleSigningImageSource{signatures: simpleSigning}, sigstoreSignatures: sigstore}
+	sigs, err = GetSignaturesWithFormat(context.Background(), src)
+	require.NoError(t, err)
+	assert.Equal(t, expected, sigs)
+
+	// Sources implementing types.SignaturesWithFormatSource
+	sigs, err = GetSignaturesWithFormat(context.Background(), withFormatImageSource{signatures: expected[2:]})
+	require.NoError(t, err)
+	assert.Equal(t, expected[2:], sigs)
+
+	// UnparsedImage
+	unparsed := UnparsedFromSource(src)
+	sigs, err = unparsed.SignaturesWithFormat(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, expected, sigs)
+}
@@ -4,22 +4,61 @@
 package image
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 )
 
-// FromSource returns a types.Image implementation for source.
-// The caller must call .Close() on the returned Image.
+// imageCloser implements types.ImageCloser, perhaps allowing simple users
+// to use a single object without having keep a reference to a types.ImageSource
+// only to call types.ImageSource.Close().
+type imageCloser struct {
+	types.Image
+	src types.ImageSource
+}
+
+// FromSource returns a types.ImageCloser implementation for the default instance of source.
+// The caller must call .Close() on the returned ImageCloser.
 //
 // FromSource “takes ownership” of the input ImageSource and will call src.Close()
-// when the image is closed.  (This does not prevent callers from using both the
-// Image and ImageSource objects simultaneously, but it means that they only need to
-// the Image.)
+// when the image is closed, or immediately if FromSource fails.  (This does not prevent
+// callers from using both the Image and ImageSource objects simultaneously, but it means
+// that they only need to keep a reference to the ImageCloser.)
 //
 // NOTE: If any kind of signature verification should happen, build an UnparsedImage from the value returned by NewImageSource,
 // verify that UnparsedImage, and convert it into a real Image via image.FromUnparsedImage instead of calling this function.
-func FromSource(src types.ImageSource) (types.Image, error) {
-	return FromUnparsedImage(UnparsedFromSource(src))
+func FromSource(ctx context.Context, sys *types.SystemContext, src types.ImageSource) (types.ImageCloser, error) {
+	return fromSourceUnparsed(ctx, sys, UnparsedFromSource(src))
+}
+
+// FromSourceWithManifest is like FromSource, but uses manifestBlob with manifestMIMEType
+// (or a MIME type guessed from manifestBlob, if manifestMIMEType is "") instead of calling src.GetManifest.
+// See UnparsedFromSourceWithManifest for details.
+// The caller must call .Close() on the returned ImageCloser.
+func FromSourceWithManifest(ctx context.Context, sys *types.SystemContext, src types.ImageSource, manifestBlob []byte, manifestMIMEType string) (types.ImageCloser, error) {
+	return fromSourceUnparsed(ctx, sys, UnparsedFromSourceWithManifest(src, manifestBlob, manifestMIMEType))
+}
+
+// fromSourceUnparsed implements FromSource and FromSourceWithManifest for unparsed, which owns its source.
+func fromSourceUnparsed(ctx context.Context, sys *types.SystemContext, unparsed *UnparsedImage) (types.ImageCloser, error) {
+	img, err := FromUnparsedImage(ctx, sys, unparsed)
+	if err != nil {
+		unparsed.Close()
+		return nil, err
+	}
+	return &imageCloser{
+		Image: img,
+		src:   unparsed.src,
+	}, nil
+}
+
+// Close removes resources associated with an initialized ImageCloser.
+func (ic *imageCloser) Close() {
+	ic.src.Close()
 }
 
 // sourcedImage is a general set of utilities for working with container images,
@@ -38,27 +77,22 @@ type sourcedImage struct {
 }
 
 // FromUnparsedImage returns a types.Image implementation for unparsed.
-// The caller must call .Close() on the returned Image.
+// The returned Image does not own unparsed; the caller remains responsible for calling unparsed.Close()
+// (typically after it is done with the returned Image, which uses unparsed's ImageSource).
 //
-// FromSource “takes ownership” of the input UnparsedImage and will call uparsed.Close()
-// when the image is closed.  (This does not prevent callers from using both the
-// UnparsedImage and ImageSource objects simultaneously, but it means that they only need to
-// keep a reference to the Image.)
-func FromUnparsedImage(unparsed *UnparsedImage) (types.Image, error) {
+// If unparsed refers to a manifest list, sys (which may be nil) determines the platform of the image chosen from the list.
+func FromUnparsedImage(ctx context.Context, sys *types.SystemContext, unparsed *UnparsedImage) (types.Image, error) {
 	// Note that the input parameter above is specifically *image.UnparsedImage, not types.UnparsedImage:
 	// we want to be able to use unparsed.src.  We could make that an explicit interface, but, well,
 	// this is the only UnparsedImage implementation around, anyway.
 
-	// Also, we do not explicitly implement types.Image.Close; we let the implementation fall through to
-	// unparsed.Close.
-
 	// NOTE: It is essential for signature verification that all parsing done in this object happens on the same manifest which is returned by unparsed.Manifest().
-	manifestBlob, manifestMIMEType, err := unparsed.Manifest()
+	manifestBlob, manifestMIMEType, err := unparsed.Manifest(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	parsedManifest, err := manifestInstanceFromBlob(unparsed.src, manifestBlob, manifestMIMEType)
+	parsedManifest, err := manifestInstanceFromBlob(ctx, sys, unparsed.src, manifestBlob, manifestMIMEType)
 	if err != nil {
 		return nil, err
 	}
@@ -72,14 +106,37 @@ func FromUnparsedImage(unparsed *UnparsedImage) (types.Image, error) {
 }
 
 // Manifest overrides the UnparsedImage.Manifest to always use the fields which we have already fetched.
-func (i *sourcedImage) Manifest() ([]byte, string, error) {
+func (i *sourcedImage) Manifest(ctx context.Context) ([]byte, string, error) {
 	return i.manifestBlob, i.manifestMIMEType, nil
 }
 
-func (i *sourcedImage) Inspect() (*types.ImageInspectInfo, error) {
-	return inspectManifest(i.genericManifest)
+func (i *sourcedImage) Inspect(ctx context.Context) (*types.ImageInspectInfo, error) {
+	return inspectManifest(ctx, i.genericManifest)
 }
 
 func (i *sourcedImage) IsMultiImage() bool {
-	return i.manifestMIMEType == manifest.DockerV2ListMediaType
+	return manifest.MIMETypeIsMultiImage(i.manifestMIMEType)
+}
+
+// UpdatedImage returns a types.Image modified according to options.
+// If options.ComputeMissingLayerDiffIDs and the update needs InformationOnly.LayerDiffIDs which were not provided,
+// they are computed by reading the layers of this image.
+// This does not change the state of the original Image object.
+func (i *sourcedImage) UpdatedImage(ctx context.Context, options types.ManifestUpdateOptions) (types.Image, error) {
+	if options.ComputeMissingLayerDiffIDs && options.InformationOnly.LayerDiffIDs == nil && i.genericManifest.UpdatedImageNeedsLayerDiffIDs(options) {
+		// The uncompressed contents of the uploaded layers are the same as those of the original layers, even if the
+		// layers were compressed or decompressed while copying, so the original layers can be used.
+		cache := blobinfocache.NewMemoryCache() // Avoids reading duplicate layers (e.g. the empty layers in schema1 images) more than once.
+		layers := i.genericManifest.LayerInfos()
+		diffIDs := make([]digest.Digest, len(layers))
+		for j, layer := range layers {
+			diffID, err := LayerDiffID(ctx, i.src, layer, cache)
+			if err != nil {
+				return nil, fmt.Errorf("Error computing DiffIDs of image layers: %v", err)
+			}
+			diffIDs[j] = diffID
+		}
+		options.InformationOnly.LayerDiffIDs = diffIDs
+	}
+	return i.genericManifest.UpdatedImage(ctx, options)
 }
@@ -0,0 +1,89 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closeCountingImageSource is a types.ImageSource which counts calls to Close().
+type closeCountingImageSource struct {
+	types.ImageSource
+	closed int
+}
+
+func (s *closeCountingImageSource) Close() {
+	s.closed++
+}
+
+func TestSourcedImageUpdatedImageComputesDiffIDs(t *testing.T) {
+	layer := []byte("This is an uncompressed layer")
+	layerDigest := digest.FromBytes(layer)
+	src := &blobsImageSource{blobs: map[digest.Digest][]byte{
+		layerDigest:             layer,
+		gzippedEmptyLayerDigest: gzippedEmptyLayer,
+	}}
+	baseID, topID := strings.Repeat("1", 64), strings.Repeat("2", 64)
+	m := manifestSchema1FromComponents(nil,
+		[]fsLayersSchema1{{BlobSum: gzippedEmptyLayerDigest}, {BlobSum: layerDigest}},
+		[]historySchema1{
+			{V1Compatibility: `{"id":"` + topID + `","parent":"` + baseID + `","created":"2016-01-01T00:00:00Z"}`},
+			{V1Compatibility: `{"id":"` + baseID + `","created":"2016-01-01T00:00:00Z"}`},
+		}, "amd64")
+	img := &sourcedImage{UnparsedImage: UnparsedFromSource(src), genericManifest: m}
+	options := types.ManifestUpdateOptions{
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+		InformationOnly:  types.ManifestUpdateInformation{LayerInfos: img.LayerInfos()},
+	}
+
+	// Without ComputeMissingLayerDiffIDs, the conversion fails and no layers are read.
+	_, err := img.UpdatedImage(context.Background(), options)
+	assert.Error(t, err)
+	assert.Equal(t, 0, src.getBlobCalls)
+
+	options.ComputeMissingLayerDiffIDs = true
+	res, err := img.UpdatedImage(context.Background(), options)
+	require.NoError(t, err)
+	configBlob, err := res.ConfigBlob(context.Background())
+	require.NoError(t, err)
+	var config image
+	require.NoError(t, json.Unmarshal(configBlob, &config))
+	require.NotNil(t, config.RootFS)
+	assert.Equal(t, []digest.Digest{layerDigest, digest.FromBytes(make([]byte, 1024))}, config.RootFS.DiffIDs)
+	assert.Equal(t, 2, src.getBlobCalls)
+
+	// Provided LayerDiffIDs are used as is.
+	src.getBlobCalls = 0
+	options.InformationOnly.LayerDiffIDs = []digest.Digest{layerDigest, layerDigest}
+	_, err = img.UpdatedImage(context.Background(), options)
+	require.NoError(t, err)
+	assert.Equal(t, 0, src.getBlobCalls)
+}
+
+func TestFromSourceWithManifestClose(t *testing.T) {
+	manifestBlob, err := ioutil.ReadFile("fixtures/schema2.json")
+	require.NoError(t, err)
+
+	// The source is closed only when the returned ImageCloser is closed.
+	src := &closeCountingImageSource{ImageSource: newSchema2ImageSource(t, "busybox:latest")}
+	img, err := FromSourceWithManifest(context.Background(), nil, src, manifestBlob, "")
+	require.NoError(t, err)
+	assert.Equal(t, 0, src.closed)
+	img.Close()
+	assert.Equal(t, 1, src.closed)
+
+	// The source is closed immediately if the image can not be created.
+	src = &closeCountingImageSource{ImageSource: newSchema2ImageSource(t, "busybox:latest")}
+	_, err = FromSourceWithManifest(context.Background(), nil, src, manifestBlob, "application/x-unknown")
+	assert.True(t, errors.Is(err, ErrUnsupportedManifestMIMEType))
+	assert.Equal(t, 1, src.closed)
+}
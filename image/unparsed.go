@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/containers/image/docker/reference"
@@ -15,7 +16,12 @@ type UnparsedImage struct {
 	// A private cache for Manifest(), may be the empty string if guessing failed.
 	// Valid iff cachedManifest is not nil.
 	cachedManifestMIMEType string
+	manifestVerified       bool     // cachedManifest has been verified against the digest in the reference, if any.
 	cachedSignatures       [][]byte // A private cache for Signatures(); nil if not yet known.
+	// A private cache for SigstoreSignatures(); nil if not yet known.
+	cachedSigstoreSignatures []types.SigstoreSignature
+	// A private cache for Attestations(); nil if not yet known.
+	cachedAttestations []types.Attestation
 }
 
 // UnparsedFromSource returns a types.UnparsedImage implementation for source.
@@ -29,6 +35,24 @@ func UnparsedFromSource(src types.ImageSource) *UnparsedImage {
 	return &UnparsedImage{src: src}
 }
 
+// UnparsedFromSourceWithManifest returns a types.UnparsedImage implementation for source, using manifestBlob
+// instead of calling src.GetManifest.  If manifestMIMEType is "", the MIME type is guessed from manifestBlob;
+// otherwise manifestMIMEType is used as is, even if it does not match the contents.
+// The manifest is still verified against a digest in src.Reference(), if any.
+// The caller must call .Close() on the returned UnparsedImage.
+//
+// Like UnparsedFromSource, UnparsedFromSourceWithManifest “takes ownership” of the input ImageSource.
+func UnparsedFromSourceWithManifest(src types.ImageSource, manifestBlob []byte, manifestMIMEType string) *UnparsedImage {
+	if manifestMIMEType == "" {
+		manifestMIMEType = manifest.GuessMIMEType(manifestBlob)
+	}
+	return &UnparsedImage{
+		src:                    src,
+		cachedManifest:         manifestBlob,
+		cachedManifestMIMEType: manifestMIMEType,
+	}
+}
+
 // Reference returns the reference used to set up this source, _as specified by the user_
 // (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
 func (i *UnparsedImage) Reference() types.ImageReference {
@@ -41,39 +65,41 @@ func (i *UnparsedImage) Close() {
 }
 
 // Manifest is like ImageSource.GetManifest, but the result is cached; it is OK to call this however often you need.
-func (i *UnparsedImage) Manifest() ([]byte, string, error) {
+func (i *UnparsedImage) Manifest(ctx context.Context) ([]byte, string, error) {
 	if i.cachedManifest == nil {
-		m, mt, err := i.src.GetManifest()
+		m, mt, err := i.src.GetManifest(ctx, nil)
 		if err != nil {
 			return nil, "", err
 		}
+		i.cachedManifest = m
+		i.cachedManifestMIMEType = mt
+	}
 
+	if !i.manifestVerified {
 		// ImageSource.GetManifest does not do digest verification, but we do;
 		// this immediately protects also any user of types.Image.
 		ref := i.Reference().DockerReference()
 		if ref != nil {
 			if canonical, ok := ref.(reference.Canonical); ok {
-				digest := canonical.Digest().String()
-				matches, err := manifest.MatchesDigest(m, digest)
+				expectedDigest := canonical.Digest()
+				matches, err := manifest.MatchesDigest(i.cachedManifest, expectedDigest)
 				if err != nil {
 					return nil, "", fmt.Errorf("Error computing manifest digest: %v", err)
 				}
 				if !matches {
-					return nil, "", fmt.Errorf("Manifest does not match provided manifest digest %s", digest)
+					return nil, "", fmt.Errorf("Manifest does not match provided manifest digest %s", expectedDigest)
 				}
 			}
 		}
-
-		i.cachedManifest = m
-		i.cachedManifestMIMEType = mt
+		i.manifestVerified = true
 	}
 	return i.cachedManifest, i.cachedManifestMIMEType, nil
 }
 
 // Signatures is like ImageSource.GetSignatures, but the result is cached; it is OK to call this however often you need.
-func (i *UnparsedImage) Signatures() ([][]byte, error) {
+func (i *UnparsedImage) Signatures(ctx context.Context) ([][]byte, error) {
 	if i.cachedSignatures == nil {
-		sigs, err := i.src.GetSignatures()
+		sigs, err := i.src.GetSignatures(ctx, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -81,3 +107,59 @@ func (i *UnparsedImage) Signatures() ([][]byte, error) {
 	}
 	return i.cachedSignatures, nil
 }
+
+// Compile-time check that UnparsedImage implements types.SigstoreSignaturesImage
+var _ types.SigstoreSignaturesImage = (*UnparsedImage)(nil)
+
+// SigstoreSignatures is like types.SigstoreSignaturesSource.GetSigstoreSignatures, but the result is cached; it is OK to call this however often you need.
+// It returns an empty list if the underlying ImageSource does not support sigstore signatures.
+func (i *UnparsedImage) SigstoreSignatures(ctx context.Context) ([]types.SigstoreSignature, error) {
+	if i.cachedSigstoreSignatures == nil {
+		src, ok := i.src.(types.SigstoreSignaturesSource)
+		if !ok {
+			return []types.SigstoreSignature{}, nil
+		}
+		sigs, err := src.GetSigstoreSignatures(ctx)
+		if err != nil {
+			return nil, err
+		}
+		i.cachedSigstoreSignatures = sigs
+	}
+	return i.cachedSigstoreSignatures, nil
+}
+
+// Compile-time check that UnparsedImage implements types.AttestationsImage
+var _ types.AttestationsImage = (*UnparsedImage)(nil)
+
+// Attestations is like types.AttestationsSource.GetAttestations, but the result is cached; it is OK to call this however often you need.
+// It returns an empty list if the underlying ImageSource does not support attestations.
+func (i *UnparsedImage) Attestations(ctx context.Context) ([]types.Attestation, error) {
+	if i.cachedAttestations == nil {
+		src, ok := i.src.(types.AttestationsSource)
+		if !ok {
+			return []types.Attestation{}, nil
+		}
+		atts, err := src.GetAttestations(ctx)
+		if err != nil {
+			return nil, err
+		}
+		i.cachedAttestations = atts
+	}
+	return i.cachedAttestations, nil
+}
+
+// Compile-time check that UnparsedImage implements types.SignaturesWithFormatImage
+var _ types.SignaturesWithFormatImage = (*UnparsedImage)(nil)
+
+// SignaturesWithFormat is like GetSignaturesWithFormat, but the result is cached; it is OK to call this however often you need.
+func (i *UnparsedImage) SignaturesWithFormat(ctx context.Context) ([]types.Signature, error) {
+	simpleSigning, err := i.Signatures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sigstore, err := i.SigstoreSignatures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return SignaturesWithFormat(simpleSigning, sigstore), nil
+}
@@ -0,0 +1,70 @@
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnparsedFromSourceWithManifest(t *testing.T) {
+	manifestBlob, err := ioutil.ReadFile("fixtures/schema2.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+
+	// The MIME type is guessed if not provided, and GetManifest is not called.
+	unparsed := UnparsedFromSourceWithManifest(newSchema2ImageSource(t, "busybox:latest"), manifestBlob, "")
+	m, mt, err := unparsed.Manifest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, manifestBlob, m)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, mt)
+
+	// An explicit MIME type is used as is.
+	unparsed = UnparsedFromSourceWithManifest(newSchema2ImageSource(t, "busybox:latest"), manifestBlob, "application/x-forced")
+	_, mt, err = unparsed.Manifest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-forced", mt)
+
+	// The manifest is verified against a digest in the reference.
+	unparsed = UnparsedFromSourceWithManifest(newSchema2ImageSource(t, "busybox@"+manifestDigest.String()), manifestBlob, "")
+	_, _, err = unparsed.Manifest(context.Background())
+	assert.NoError(t, err)
+	unparsed = UnparsedFromSourceWithManifest(newSchema2ImageSource(t, "busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000"), manifestBlob, "")
+	_, _, err = unparsed.Manifest(context.Background())
+	assert.Error(t, err)
+}
+
+// attestationsImageSource is a mock of types.ImageSource which also implements types.AttestationsSource.
+type attestationsImageSource struct {
+	unusedImageSource
+	attestations []types.Attestation
+	calls        int
+}
+
+func (s *attestationsImageSource) GetAttestations(ctx context.Context) ([]types.Attestation, error) {
+	s.calls++
+	return s.attestations, nil
+}
+
+func TestUnparsedImageAttestations(t *testing.T) {
+	// Sources without attestation support
+	atts, err := UnparsedFromSource(unusedImageSource{}).Attestations(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []types.Attestation{}, atts)
+
+	// Sources with attestation support; the result is cached.
+	expected := []types.Attestation{{Envelope: []byte("envelope")}}
+	src := &attestationsImageSource{attestations: expected}
+	unparsed := UnparsedFromSource(src)
+	for i := 0; i < 2; i++ {
+		atts, err = unparsed.Attestations(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, expected, atts)
+	}
+	assert.Equal(t, 1, src.calls)
+}
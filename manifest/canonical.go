@@ -0,0 +1,70 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// CanonicalJSON returns the JSON document blob in a canonical form: without insignificant whitespace, with object keys sorted,
+// and with strings and numbers encoded consistently, so that semantically identical documents (e.g. manifests built from the same
+// components by different tools, or by different versions of this package) always result in identical blobs, and identical digests.
+// It can be used on the output of Serialize() of any of the manifest types in this package, or on config blobs.
+// NOTE: The result is usually not identical to the output of Serialize(); canonicalizing a manifest changes its digest.
+func CanonicalJSON(blob []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(blob))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("Error parsing JSON: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Error parsing JSON: unexpected data after the top-level value")
+	}
+	value, err := canonicalJSONNumbers(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var res bytes.Buffer
+	encoder := json.NewEncoder(&res)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil { // encoding/json sorts the keys of maps.
+		return nil, err
+	}
+	return bytes.TrimSuffix(res.Bytes(), []byte("\n")), nil
+}
+
+// canonicalJSONNumbers returns value, as decoded using json.Decoder.UseNumber, with all numbers converted to a canonical form.
+func canonicalJSONNumbers(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			canonical, err := canonicalJSONNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = canonical
+		}
+	case []interface{}:
+		for i, item := range v {
+			canonical, err := canonicalJSONNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = canonical
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(i, 10)), nil
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing JSON number %q: %v", v, err)
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	}
+	return value, nil
+}
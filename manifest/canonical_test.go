@@ -0,0 +1,50 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	for _, c := range []struct{ input, expected string }{
+		{`{"b": 1, "a": [true, null, "x"]}`, `{"a":[true,null,"x"],"b":1}`},
+		{"\n{\n  \"z\": {\"y\": 2, \"x\": 1}\n}\n", `{"z":{"x":1,"y":2}}`},
+		{`{"n": [1.0, 1e3, -0, 0.5, 12345678901234567890]}`, `{"n":[1,1000,0,0.5,1.2345678901234567e+19]}`},
+		{`{"s": "<a&b>é"}`, `{"s":"<a&b>é"}`},
+		{`"string"`, `"string"`},
+	} {
+		res, err := CanonicalJSON([]byte(c.input))
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, string(res), c.input)
+		// Canonicalization is idempotent.
+		res2, err := CanonicalJSON(res)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, res, res2, c.input)
+	}
+
+	// Differently formatted but equivalent manifests result in the same blob.
+	blob, err := ioutil.ReadFile(filepath.Join("fixtures", "v2s2.manifest.json"))
+	require.NoError(t, err)
+	m, err := Schema2FromManifest(blob)
+	require.NoError(t, err)
+	serialized, err := m.Serialize()
+	require.NoError(t, err)
+	assert.NotEqual(t, blob, serialized)
+	canonical1, err := CanonicalJSON(blob)
+	require.NoError(t, err)
+	canonical2, err := CanonicalJSON(serialized)
+	require.NoError(t, err)
+	assert.Equal(t, canonical1, canonical2)
+	assert.Equal(t, digest.FromBytes(canonical1), digest.FromBytes(canonical2))
+
+	// Invalid input
+	for _, input := range []string{"", "this is not JSON", `{"a":1}{"b":2}`, `{"a":`} {
+		_, err := CanonicalJSON([]byte(input))
+		assert.Error(t, err, input)
+	}
+}
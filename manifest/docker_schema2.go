@@ -0,0 +1,97 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Schema2Descriptor is a “descriptor” in docker/distribution schema 2.
+type Schema2Descriptor struct {
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+	Digest    digest.Digest `json:"digest"`
+	URLs      []string      `json:"urls,omitempty"`
+}
+
+// Schema2 is a manifest in docker/distribution schema 2.
+// Based on github.com/docker/distribution/manifest/schema2/manifest.go
+type Schema2 struct {
+	SchemaVersion     int                 `json:"schemaVersion"`
+	MediaType         string              `json:"mediaType"`
+	ConfigDescriptor  Schema2Descriptor   `json:"config"`
+	LayersDescriptors []Schema2Descriptor `json:"layers"`
+}
+
+// Schema2FromManifest creates a Schema2 manifest instance from a manifest blob.
+func Schema2FromManifest(manifest []byte) (*Schema2, error) {
+	s2 := Schema2{}
+	if err := json.Unmarshal(manifest, &s2); err != nil {
+		return nil, err
+	}
+	if err := s2.ConfigDescriptor.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid config digest %q: %v", s2.ConfigDescriptor.Digest, err)
+	}
+	for _, layer := range s2.LayersDescriptors {
+		if err := layer.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid layer digest %q: %v", layer.Digest, err)
+		}
+	}
+	return &s2, nil
+}
+
+// Schema2FromComponents creates a Schema2 manifest instance from the supplied data.
+func Schema2FromComponents(config Schema2Descriptor, layers []Schema2Descriptor) *Schema2 {
+	return &Schema2{
+		SchemaVersion:     2,
+		MediaType:         DockerV2Schema2MediaType,
+		ConfigDescriptor:  config,
+		LayersDescriptors: layers,
+	}
+}
+
+// Serialize returns the manifest in a blob format.
+// NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
+func (m *Schema2) Serialize() ([]byte, error) {
+	return json.Marshal(*m)
+}
+
+// ConfigInfo returns a complete BlobInfo for the separate config object.
+func (m *Schema2) ConfigInfo() types.BlobInfo {
+	return types.BlobInfo{Digest: m.ConfigDescriptor.Digest, Size: m.ConfigDescriptor.Size, MediaType: m.ConfigDescriptor.MediaType}
+}
+
+// LayerInfos returns a list of BlobInfos of layers referenced by this image, in order (the root layer first, and then successive layered layers).
+// The Digest field is guaranteed to be provided; Size may be -1.
+// WARNING: The list may contain duplicates, and they are semantically relevant.
+func (m *Schema2) LayerInfos() []types.BlobInfo {
+	blobs := []types.BlobInfo{}
+	for _, layer := range m.LayersDescriptors {
+		blobs = append(blobs, types.BlobInfo{Digest: layer.Digest, Size: layer.Size, MediaType: layer.MediaType, URLs: layer.URLs})
+	}
+	return blobs
+}
+
+// UpdateLayerInfos replaces the original layers with the specified BlobInfos (size+digest+urls), in order (the root layer first, and then successive layered layers).
+// Schema 2 uses the same MIME type for compressed and uncompressed layers, so the MIME types are not modified;
+// layers compressed using an algorithm other than gzip are rejected.
+func (m *Schema2) UpdateLayerInfos(layerInfos []types.BlobInfo) error {
+	if len(m.LayersDescriptors) != len(layerInfos) {
+		return fmt.Errorf("Error preparing updated manifest: layer count changed from %d to %d", len(m.LayersDescriptors), len(layerInfos))
+	}
+	layers := make([]Schema2Descriptor, len(layerInfos))
+	for i, info := range layerInfos {
+		mediaType, err := Schema2LayerMediaType(m.LayersDescriptors[i].MediaType, info)
+		if err != nil {
+			return fmt.Errorf("Error preparing updated manifest: %v", err)
+		}
+		layers[i].MediaType = mediaType
+		layers[i].Digest = info.Digest
+		layers[i].Size = info.Size
+		layers[i].URLs = info.URLs
+	}
+	m.LayersDescriptors = layers
+	return nil
+}
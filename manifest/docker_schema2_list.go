@@ -0,0 +1,105 @@
+package manifest
+
+import (
+	"encoding/json"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Schema2PlatformSpec describes the platform which a particular manifest in a Schema2List is specialized for.
+type Schema2PlatformSpec struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	Features     []string `json:"features,omitempty"`
+}
+
+// Schema2ManifestDescriptor references a platform-specific manifest in a Schema2List.
+type Schema2ManifestDescriptor struct {
+	Schema2Descriptor
+	Platform Schema2PlatformSpec `json:"platform"`
+}
+
+// Schema2List is a list of platform-specific manifests in docker/distribution schema 2.
+type Schema2List struct {
+	SchemaVersion int                         `json:"schemaVersion"`
+	MediaType     string                      `json:"mediaType"`
+	Manifests     []Schema2ManifestDescriptor `json:"manifests"`
+}
+
+// Schema2ListFromManifest creates a Schema2List instance from a manifest list blob.
+func Schema2ListFromManifest(manifest []byte) (*Schema2List, error) {
+	list := Schema2List{}
+	if err := json.Unmarshal(manifest, &list); err != nil {
+		return nil, err
+	}
+	if err := validateListInstances(list.Instances()); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Schema2ListFromComponents creates a Schema2List instance from the supplied data.
+func Schema2ListFromComponents(manifests []Schema2ManifestDescriptor) *Schema2List {
+	return &Schema2List{
+		SchemaVersion: 2,
+		MediaType:     DockerV2ListMediaType,
+		Manifests:     manifests,
+	}
+}
+
+// MIMEType returns the MIME type of this particular manifest list.
+func (list *Schema2List) MIMEType() string {
+	return DockerV2ListMediaType
+}
+
+// Instances returns the manifests referenced by this list, in order.
+// WARNING: The list may contain duplicate digests, e.g. if the same image is listed for several platforms.
+func (list *Schema2List) Instances() []ListInstance {
+	res := make([]ListInstance, len(list.Manifests))
+	for i, m := range list.Manifests {
+		res[i] = ListInstance{
+			Digest:       m.Digest,
+			Size:         m.Size,
+			MediaType:    m.MediaType,
+			Architecture: m.Platform.Architecture,
+			OS:           m.Platform.OS,
+			Variant:      m.Platform.Variant,
+		}
+	}
+	return res
+}
+
+// Instance returns information about the first manifest in the list with instanceDigest.
+func (list *Schema2List) Instance(instanceDigest digest.Digest) (ListInstance, error) {
+	return findListInstance(list.Instances(), instanceDigest)
+}
+
+// UpdateInstances replaces the digests, sizes and MIME types of the manifests referenced by this list,
+// in the order returned by Instances().
+func (list *Schema2List) UpdateInstances(updates []ListUpdate) error {
+	if err := checkListUpdates(len(list.Manifests), updates); err != nil {
+		return err
+	}
+	for i, update := range updates {
+		list.Manifests[i].Digest = update.Digest
+		list.Manifests[i].Size = update.Size
+		list.Manifests[i].MediaType = update.MediaType
+	}
+	return nil
+}
+
+// ChooseInstance returns the digest of the manifest matching the platform specified by sys
+// (or the platform we are running on, for values not set in sys, which may be nil).
+func (list *Schema2List) ChooseInstance(sys *types.SystemContext) (digest.Digest, error) {
+	return chooseListInstance(sys, list.Instances())
+}
+
+// Serialize returns the list in a blob format.
+// NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
+func (list *Schema2List) Serialize() ([]byte, error) {
+	return json.Marshal(*list)
+}
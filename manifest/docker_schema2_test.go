@@ -0,0 +1,80 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema2FromManifest(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("fixtures", "v2s2.manifest.json"))
+	require.NoError(t, err)
+	m, err := Schema2FromManifest(blob)
+	require.NoError(t, err)
+	assert.Equal(t, DockerV2Schema2MediaType, m.MediaType)
+	assert.Equal(t, types.BlobInfo{
+		Digest:    "sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7",
+		Size:      7023,
+		MediaType: DockerV2Schema2ConfigMediaType,
+	}, m.ConfigInfo())
+	require.Len(t, m.LayerInfos(), 3)
+	assert.Equal(t, types.BlobInfo{
+		Digest:    "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+		Size:      32654,
+		MediaType: DockerV2Schema2LayerMediaType,
+	}, m.LayerInfos()[0])
+
+	// Invalid input
+	_, err = Schema2FromManifest([]byte("this is not JSON"))
+	assert.Error(t, err)
+	_, err = Schema2FromManifest([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:invalid"},"layers":[]}`))
+	assert.Error(t, err)
+	_, err = Schema2FromManifest([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7"},` +
+		`"layers":[{"digest":"sha256:invalid"}]}`))
+	assert.Error(t, err)
+}
+
+func TestSchema2FromComponents(t *testing.T) {
+	config := Schema2Descriptor{MediaType: DockerV2Schema2ConfigMediaType, Size: 2, Digest: digest.FromBytes([]byte("{}"))}
+	layer := Schema2Descriptor{MediaType: DockerV2Schema2LayerMediaType, Size: 5, Digest: digest.FromBytes([]byte("layer"))}
+	m := Schema2FromComponents(config, []Schema2Descriptor{layer})
+
+	blob, err := m.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, DockerV2Schema2MediaType, GuessMIMEType(blob))
+	m2, err := Schema2FromManifest(blob)
+	require.NoError(t, err)
+	assert.Equal(t, m, m2)
+}
+
+func TestSchema2UpdateLayerInfos(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("fixtures", "v2s2.manifest.json"))
+	require.NoError(t, err)
+	m, err := Schema2FromManifest(blob)
+	require.NoError(t, err)
+	original := m.LayerInfos()
+
+	updated := append(original[1:], original[0])
+	updated[0].URLs = []string{"https://example.com/layer"}
+	err = m.UpdateLayerInfos(updated)
+	require.NoError(t, err)
+	assert.Equal(t, updated, m.LayerInfos())
+
+	// Layer count changes are rejected.
+	err = m.UpdateLayerInfos(updated[1:])
+	assert.Error(t, err)
+
+	// Compression other than gzip can not be represented; the manifest is not modified.
+	zstdInfos := m.LayerInfos()
+	zstdInfos[0].Digest = digest.FromBytes([]byte("zstd"))
+	zstdInfos[0].CompressionOperation = types.Compress
+	zstdInfos[0].CompressionAlgorithm = types.ZstdCompression
+	err = m.UpdateLayerInfos(zstdInfos)
+	assert.Error(t, err)
+	assert.Equal(t, updated, m.LayerInfos())
+}
@@ -0,0 +1,97 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// MIMETypeSupportsCompressionAlgorithm returns true if manifests with mimeType can refer to layers compressed using algorithm.
+// An empty algorithm means the default, gzip.
+func MIMETypeSupportsCompressionAlgorithm(mimeType, algorithm string) bool {
+	switch algorithm {
+	case "", types.GzipCompression:
+		return true
+	case types.ZstdCompression, types.ZstdChunkedCompression:
+		return mimeType == imgspecv1.MediaTypeImageManifest
+	default:
+		return false
+	}
+}
+
+// ociMediaTypePrefix is the common prefix of all MIME types defined by the OCI image specification.
+const ociMediaTypePrefix = "application/vnd.oci."
+
+// isOCI1CompressibleLayerMediaType returns true if mediaType is an OCI layer MIME type which may be changed
+// to reflect the layer’s compression.
+func isOCI1CompressibleLayerMediaType(mediaType string) bool {
+	return mediaType == imgspecv1.MediaTypeImageLayer || mediaType == OCI1LayerZstdMediaType || mediaType == OCI1LayerUncompressedMediaType
+}
+
+// OCI1LayerMediaType returns the MIME type to use in an OCI manifest for a layer with mediaType,
+// after applying info.CompressionOperation using info.CompressionAlgorithm.
+// mediaType may be an OCI or a Docker schema2 layer MIME type.
+// If the layer is not modified, but info.CompressionAlgorithm shows that mediaType does not match the layer’s actual compression
+// (e.g. because the layer comes from a Docker schema2 image, which uses a gzip MIME type for all layers), the MIME type is corrected.
+func OCI1LayerMediaType(mediaType string, info types.BlobInfo) (string, error) {
+	if mediaType == DockerV2Schema2LayerMediaType {
+		mediaType = imgspecv1.MediaTypeImageLayer
+	}
+	switch {
+	case info.CompressionOperation == types.Compress:
+		// Only uncompressed layers are compressed, even if mediaType does not say so (e.g. after a conversion from schema2).
+		switch info.CompressionAlgorithm {
+		case "", types.GzipCompression: // "" is accepted for compatibility with callers which predate CompressionAlgorithm, and only ever used gzip.
+			return imgspecv1.MediaTypeImageLayer, nil
+		case types.ZstdCompression, types.ZstdChunkedCompression:
+			return OCI1LayerZstdMediaType, nil
+		default:
+			return "", fmt.Errorf("%s compression is not supported for OCI layers", info.CompressionAlgorithm)
+		}
+	case !isOCI1CompressibleLayerMediaType(mediaType): // E.g. non-distributable layers; we don’t know what the compressed or uncompressed variants are.
+		return mediaType, nil
+	case info.CompressionOperation == types.Decompress:
+		return OCI1LayerUncompressedMediaType, nil
+	case info.CompressionOperation == types.PreserveOriginal && mediaType != OCI1LayerUncompressedMediaType:
+		// An empty info.CompressionAlgorithm may just mean that the algorithm is unknown, so uncompressed layers are not recognized.
+		switch info.CompressionAlgorithm {
+		case types.GzipCompression:
+			return imgspecv1.MediaTypeImageLayer, nil
+		case types.ZstdCompression:
+			return OCI1LayerZstdMediaType, nil
+		}
+		return mediaType, nil
+	default:
+		return mediaType, nil
+	}
+}
+
+// Schema2LayerMediaType returns the MIME type to use in a Docker schema2 manifest for a layer with mediaType,
+// after applying info.CompressionOperation using info.CompressionAlgorithm.
+// mediaType may be an OCI or a Docker schema2 layer MIME type; non-OCI MIME types are not modified, because schema2
+// uses the same MIME type for compressed and uncompressed layers.
+// A ManifestLayerCompressionIncompatibilityError is returned if an OCI layer, as compressed, can not be represented in schema2.
+func Schema2LayerMediaType(mediaType string, info types.BlobInfo) (string, error) {
+	if info.CompressionOperation == types.Compress && !MIMETypeSupportsCompressionAlgorithm(DockerV2Schema2MediaType, info.CompressionAlgorithm) {
+		return "", fmt.Errorf("%s compression is not supported for schema2 layers", info.CompressionAlgorithm)
+	}
+	if !strings.HasPrefix(mediaType, ociMediaTypePrefix) {
+		return mediaType, nil
+	}
+	ociMediaType, err := OCI1LayerMediaType(mediaType, info)
+	if err != nil {
+		return "", err
+	}
+	switch ociMediaType {
+	// Schema 2 layers are always gzip-compressed.
+	case OCI1LayerUncompressedMediaType:
+		return "", NewManifestLayerCompressionIncompatibilityError(fmt.Sprintf("Layer %s is not compressed, which is not supported in %s manifests",
+			info.Digest, DockerV2Schema2MediaType))
+	case OCI1LayerZstdMediaType:
+		return "", NewManifestLayerCompressionIncompatibilityError(fmt.Sprintf("Layer %s is compressed using zstd, which is not supported in %s manifests",
+			info.Digest, DockerV2Schema2MediaType))
+	}
+	return DockerV2Schema2LayerMediaType, nil
+}
@@ -0,0 +1,107 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/containers/image/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMIMETypeSupportsCompressionAlgorithm(t *testing.T) {
+	for _, c := range []struct {
+		mimeType, algorithm string
+		expected            bool
+	}{
+		{DockerV2Schema2MediaType, "", true},
+		{DockerV2Schema2MediaType, types.GzipCompression, true},
+		{DockerV2Schema2MediaType, types.ZstdCompression, false},
+		{DockerV2Schema2MediaType, types.ZstdChunkedCompression, false},
+		{DockerV2Schema1SignedMediaType, types.GzipCompression, true},
+		{DockerV2Schema1SignedMediaType, types.ZstdCompression, false},
+		{imgspecv1.MediaTypeImageManifest, types.GzipCompression, true},
+		{imgspecv1.MediaTypeImageManifest, types.ZstdCompression, true},
+		{imgspecv1.MediaTypeImageManifest, types.ZstdChunkedCompression, true},
+		{imgspecv1.MediaTypeImageManifest, types.Bzip2Compression, false},
+	} {
+		res := MIMETypeSupportsCompressionAlgorithm(c.mimeType, c.algorithm)
+		assert.Equal(t, c.expected, res, "%s %s", c.mimeType, c.algorithm)
+	}
+}
+
+func TestOCI1LayerMediaType(t *testing.T) {
+	for _, c := range []struct {
+		mediaType string
+		operation types.LayerCompression
+		algorithm string
+		expected  string // "" if an error is expected
+	}{
+		// Compression
+		{OCI1LayerUncompressedMediaType, types.Compress, "", imgspecv1.MediaTypeImageLayer},
+		{OCI1LayerUncompressedMediaType, types.Compress, types.GzipCompression, imgspecv1.MediaTypeImageLayer},
+		{OCI1LayerUncompressedMediaType, types.Compress, types.ZstdCompression, OCI1LayerZstdMediaType},
+		{OCI1LayerUncompressedMediaType, types.Compress, types.ZstdChunkedCompression, OCI1LayerZstdMediaType},
+		{imgspecv1.MediaTypeImageLayer, types.Compress, types.ZstdCompression, OCI1LayerZstdMediaType},
+		{DockerV2Schema2LayerMediaType, types.Compress, types.ZstdCompression, OCI1LayerZstdMediaType},
+		{OCI1LayerUncompressedMediaType, types.Compress, types.Bzip2Compression, ""},
+		// Decompression
+		{imgspecv1.MediaTypeImageLayer, types.Decompress, "", OCI1LayerUncompressedMediaType},
+		{OCI1LayerZstdMediaType, types.Decompress, "", OCI1LayerUncompressedMediaType},
+		{DockerV2Schema2LayerMediaType, types.Decompress, "", OCI1LayerUncompressedMediaType},
+		{imgspecv1.MediaTypeImageLayerNonDistributable, types.Decompress, "", imgspecv1.MediaTypeImageLayerNonDistributable},
+		// Preserving the original, correcting the MIME type if the compression is known
+		{imgspecv1.MediaTypeImageLayer, types.PreserveOriginal, "", imgspecv1.MediaTypeImageLayer},
+		{imgspecv1.MediaTypeImageLayer, types.PreserveOriginal, types.ZstdCompression, OCI1LayerZstdMediaType},
+		{OCI1LayerZstdMediaType, types.PreserveOriginal, types.GzipCompression, imgspecv1.MediaTypeImageLayer},
+		{DockerV2Schema2LayerMediaType, types.PreserveOriginal, "", imgspecv1.MediaTypeImageLayer},
+		{DockerV2Schema2LayerMediaType, types.PreserveOriginal, types.ZstdCompression, OCI1LayerZstdMediaType},
+		{OCI1LayerUncompressedMediaType, types.PreserveOriginal, types.GzipCompression, OCI1LayerUncompressedMediaType},
+		{imgspecv1.MediaTypeImageLayerNonDistributable, types.PreserveOriginal, types.ZstdCompression, imgspecv1.MediaTypeImageLayerNonDistributable},
+	} {
+		res, err := OCI1LayerMediaType(c.mediaType, types.BlobInfo{CompressionOperation: c.operation, CompressionAlgorithm: c.algorithm})
+		if c.expected == "" {
+			assert.Error(t, err, "%#v", c)
+		} else {
+			assert.NoError(t, err, "%#v", c)
+			assert.Equal(t, c.expected, res, "%#v", c)
+		}
+	}
+}
+
+func TestSchema2LayerMediaType(t *testing.T) {
+	const foreignLayerMediaType = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	for _, c := range []struct {
+		mediaType    string
+		operation    types.LayerCompression
+		algorithm    string
+		expected     string // "" if an error is expected
+		incompatible bool   // If an error is expected, whether it is a ManifestLayerCompressionIncompatibilityError
+	}{
+		// Schema2 MIME types are not modified
+		{DockerV2Schema2LayerMediaType, types.PreserveOriginal, "", DockerV2Schema2LayerMediaType, false},
+		{DockerV2Schema2LayerMediaType, types.Decompress, "", DockerV2Schema2LayerMediaType, false},
+		{DockerV2Schema2LayerMediaType, types.Compress, types.GzipCompression, DockerV2Schema2LayerMediaType, false},
+		{foreignLayerMediaType, types.PreserveOriginal, "", foreignLayerMediaType, false},
+		// OCI MIME types are converted
+		{imgspecv1.MediaTypeImageLayer, types.PreserveOriginal, "", DockerV2Schema2LayerMediaType, false},
+		{imgspecv1.MediaTypeImageLayerNonDistributable, types.PreserveOriginal, "", DockerV2Schema2LayerMediaType, false},
+		{OCI1LayerUncompressedMediaType, types.Compress, "", DockerV2Schema2LayerMediaType, false},
+		{OCI1LayerZstdMediaType, types.PreserveOriginal, "", "", true},
+		{OCI1LayerUncompressedMediaType, types.PreserveOriginal, "", "", true},
+		{imgspecv1.MediaTypeImageLayer, types.Decompress, "", "", true},
+		{imgspecv1.MediaTypeImageLayer, types.PreserveOriginal, types.ZstdCompression, "", true},
+		// Compression other than gzip is rejected
+		{DockerV2Schema2LayerMediaType, types.Compress, types.ZstdCompression, "", false},
+		{OCI1LayerUncompressedMediaType, types.Compress, types.ZstdChunkedCompression, "", false},
+	} {
+		res, err := Schema2LayerMediaType(c.mediaType, types.BlobInfo{CompressionOperation: c.operation, CompressionAlgorithm: c.algorithm})
+		if c.expected == "" {
+			assert.Error(t, err, "%#v", c)
+			_, incompatible := err.(ManifestLayerCompressionIncompatibilityError)
+			assert.Equal(t, c.incompatible, incompatible, "%#v", c)
+		} else {
+			assert.NoError(t, err, "%#v", c)
+			assert.Equal(t, c.expected, res, "%#v", c)
+		}
+	}
+}
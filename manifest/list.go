@@ -0,0 +1,133 @@
+package manifest
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// List is an interface for parsing and modifying lists of image manifests (Docker manifest lists and OCI image indexes).
+// Callers can either use this abstract interface without understanding the details of the formats,
+// or instantiate a specific implementation (e.g. Schema2List) and access the public members directly.
+type List interface {
+	// MIMEType returns the MIME type of this particular manifest list.
+	MIMEType() string
+	// Instances returns the manifests referenced by this list, in order.
+	// WARNING: The list may contain duplicate digests, e.g. if the same image is listed for several platforms.
+	Instances() []ListInstance
+	// Instance returns information about the first manifest in the list with instanceDigest.
+	Instance(instanceDigest digest.Digest) (ListInstance, error)
+	// UpdateInstances replaces the digests, sizes and MIME types of the manifests referenced by this list,
+	// in the order returned by Instances().
+	UpdateInstances(updates []ListUpdate) error
+	// ChooseInstance returns the digest of the manifest matching the platform specified by sys
+	// (or the platform we are running on, for values not set in sys, which may be nil).
+	ChooseInstance(sys *types.SystemContext) (digest.Digest, error)
+	// Serialize returns the list in a blob format.
+	// NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
+	Serialize() ([]byte, error)
+}
+
+// ListInstance describes a single manifest referenced by a List.
+type ListInstance struct {
+	Digest       digest.Digest
+	Size         int64
+	MediaType    string
+	Architecture string
+	OS           string
+	Variant      string
+}
+
+// ListUpdate contains the fields of a manifest referenced by a List which can be modified using List.UpdateInstances.
+type ListUpdate struct {
+	Digest    digest.Digest
+	Size      int64
+	MediaType string
+}
+
+// MIMETypeIsMultiImage returns true if mimeType is a MIME type of a list of manifests.
+func MIMETypeIsMultiImage(mimeType string) bool {
+	return mimeType == DockerV2ListMediaType || mimeType == imgspecv1.MediaTypeImageManifestList
+}
+
+// ListFromBlob parses manifest as a List with manifestMIMEType; if manifestMIMEType is "", it is guessed from the contents.
+func ListFromBlob(manifest []byte, manifestMIMEType string) (List, error) {
+	if manifestMIMEType == "" {
+		manifestMIMEType = GuessMIMEType(manifest)
+	}
+	switch manifestMIMEType {
+	case DockerV2ListMediaType:
+		return Schema2ListFromManifest(manifest)
+	case imgspecv1.MediaTypeImageManifestList:
+		return OCI1IndexFromManifest(manifest)
+	default:
+		return nil, fmt.Errorf("Unsupported manifest list MIME type %q", manifestMIMEType)
+	}
+}
+
+// validateListInstances returns an error if any of the instances has an invalid digest.
+func validateListInstances(instances []ListInstance) error {
+	for _, instance := range instances {
+		if err := instance.Digest.Validate(); err != nil {
+			return fmt.Errorf("Invalid manifest digest %q in manifest list: %v", instance.Digest, err)
+		}
+	}
+	return nil
+}
+
+// findListInstance returns the first item of instances with instanceDigest.
+func findListInstance(instances []ListInstance, instanceDigest digest.Digest) (ListInstance, error) {
+	for _, instance := range instances {
+		if instance.Digest == instanceDigest {
+			return instance, nil
+		}
+	}
+	return ListInstance{}, fmt.Errorf("manifest %s not found in manifest list", instanceDigest)
+}
+
+// chooseListInstance returns the digest of the first item of instances matching the platform specified by sys
+// (or the platform we are running on, for values not set in sys, which may be nil).
+func chooseListInstance(sys *types.SystemContext, instances []ListInstance) (digest.Digest, error) {
+	wantedArch := runtime.GOARCH
+	if sys != nil && sys.ArchitectureChoice != "" {
+		wantedArch = sys.ArchitectureChoice
+	}
+	wantedOS := runtime.GOOS
+	if sys != nil && sys.OSChoice != "" {
+		wantedOS = sys.OSChoice
+	}
+	wantedVariant := ""
+	if sys != nil {
+		wantedVariant = sys.VariantChoice
+	}
+
+	for _, instance := range instances {
+		if instance.Architecture == wantedArch && instance.OS == wantedOS &&
+			(wantedVariant == "" || instance.Variant == wantedVariant) {
+			return instance.Digest, nil
+		}
+	}
+	if wantedVariant != "" {
+		return "", fmt.Errorf("no image found in manifest list for architecture %s, variant %s, OS %s", wantedArch, wantedVariant, wantedOS)
+	}
+	return "", fmt.Errorf("no image found in manifest list for architecture %s, OS %s", wantedArch, wantedOS)
+}
+
+// checkListUpdates returns an error if updates can not be applied to a list with instanceCount manifests.
+func checkListUpdates(instanceCount int, updates []ListUpdate) error {
+	if len(updates) != instanceCount {
+		return fmt.Errorf("Error preparing updated manifest list: instance count changed from %d to %d", instanceCount, len(updates))
+	}
+	for _, update := range updates {
+		if err := update.Digest.Validate(); err != nil {
+			return fmt.Errorf("Invalid manifest digest %q in manifest list update: %v", update.Digest, err)
+		}
+		if update.MediaType == "" {
+			return fmt.Errorf("No MIME type set for manifest %s in manifest list update", update.Digest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListFromBlob(t *testing.T) {
+	for _, c := range []struct {
+		fixture  string
+		mimeType string
+	}{
+		{"v2list.manifest.json", DockerV2ListMediaType},
+		{"ociv1list.manifest.json", imgspecv1.MediaTypeImageManifestList},
+	} {
+		blob, err := ioutil.ReadFile(filepath.Join("fixtures", c.fixture))
+		require.NoError(t, err)
+		for _, mt := range []string{c.mimeType, ""} {
+			list, err := ListFromBlob(blob, mt)
+			require.NoError(t, err, c.fixture)
+			assert.Equal(t, c.mimeType, list.MIMEType(), c.fixture)
+			assert.True(t, MIMETypeIsMultiImage(list.MIMEType()))
+
+			instances := list.Instances()
+			require.Len(t, instances, 5, c.fixture)
+			assert.Equal(t, digest.Digest("sha256:07ebe243465ef4a667b78154ae6c3ea46fdb1582936aac3ac899ea311a701b40"), instances[3].Digest)
+			assert.Equal(t, int64(2084), instances[3].Size)
+			assert.Equal(t, "arm", instances[3].Architecture)
+			assert.Equal(t, "linux", instances[3].OS)
+			assert.Equal(t, "armv7", instances[3].Variant)
+
+			instance, err := list.Instance(instances[1].Digest)
+			require.NoError(t, err)
+			assert.Equal(t, instances[1], instance)
+			_, err = list.Instance(digest.FromBytes([]byte("not in the list")))
+			assert.Error(t, err)
+
+			serialized, err := list.Serialize()
+			require.NoError(t, err)
+			list2, err := ListFromBlob(serialized, "")
+			require.NoError(t, err)
+			assert.Equal(t, list, list2)
+		}
+	}
+
+	// Invalid input
+	for _, c := range []struct {
+		blob     string
+		mimeType string
+	}{
+		{"this is not JSON", DockerV2ListMediaType},
+		{"this is not JSON", imgspecv1.MediaTypeImageManifestList},
+		{`{"schemaVersion":2,"manifests":[{"digest":"sha256:invalid"}]}`, DockerV2ListMediaType},
+		{`{"schemaVersion":2,"manifests":[{"digest":"sha256:invalid"}]}`, imgspecv1.MediaTypeImageManifestList},
+		{`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`, ""},
+	} {
+		_, err := ListFromBlob([]byte(c.blob), c.mimeType)
+		assert.Error(t, err, c.blob)
+	}
+}
+
+func TestListChooseInstance(t *testing.T) {
+	const (
+		amd64Digest = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+		armv6Digest = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+		armv7Digest = digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+		hostDigest  = digest.Digest("sha256:4444444444444444444444444444444444444444444444444444444444444444")
+	)
+	platforms := []struct {
+		digest                    digest.Digest
+		architecture, os, variant string
+	}{
+		{amd64Digest, "amd64", "linux", ""},
+		{armv6Digest, "arm", "linux", "v6"},
+		{armv7Digest, "arm", "linux", "v7"},
+		{hostDigest, runtime.GOARCH, runtime.GOOS, ""},
+	}
+	schema2Manifests := []Schema2ManifestDescriptor{}
+	ociManifests := []OCI1ManifestDescriptor{
+		{OCI1Descriptor: OCI1Descriptor{Digest: digest.FromBytes([]byte("no platform"))}},
+	}
+	for _, p := range platforms {
+		schema2Manifests = append(schema2Manifests, Schema2ManifestDescriptor{
+			Schema2Descriptor: Schema2Descriptor{Digest: p.digest},
+			Platform:          Schema2PlatformSpec{Architecture: p.architecture, OS: p.os, Variant: p.variant},
+		})
+		ociManifests = append(ociManifests, OCI1ManifestDescriptor{
+			OCI1Descriptor: OCI1Descriptor{Digest: p.digest},
+			Platform:       &OCI1Platform{Architecture: p.architecture, OS: p.os, Variant: p.variant},
+		})
+	}
+
+	for _, list := range []List{Schema2ListFromComponents(schema2Manifests), OCI1IndexFromComponents(ociManifests, nil)} {
+		for _, c := range []struct {
+			sys      *types.SystemContext
+			expected digest.Digest // "" if no match is expected
+		}{
+			{&types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "linux"}, amd64Digest},
+			{&types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux"}, armv6Digest},
+			{&types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "v7"}, armv7Digest},
+			{&types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux", VariantChoice: "v8"}, ""},
+			{&types.SystemContext{ArchitectureChoice: "s390x", OSChoice: "linux"}, ""},
+			{&types.SystemContext{ArchitectureChoice: "amd64", OSChoice: "windows"}, ""},
+		} {
+			res, err := list.ChooseInstance(c.sys)
+			if c.expected == "" {
+				assert.Error(t, err, "%s %#v", list.MIMEType(), c.sys)
+			} else {
+				assert.NoError(t, err, "%s %#v", list.MIMEType(), c.sys)
+				assert.Equal(t, c.expected, res, "%s %#v", list.MIMEType(), c.sys)
+			}
+		}
+	}
+
+	// Without overrides, the platform we are running on is used.
+	for _, list := range []List{Schema2ListFromComponents(schema2Manifests[3:]), OCI1IndexFromComponents(ociManifests[4:], nil)} {
+		for _, sys := range []*types.SystemContext{nil, {}} {
+			res, err := list.ChooseInstance(sys)
+			assert.NoError(t, err, list.MIMEType())
+			assert.Equal(t, hostDigest, res, list.MIMEType())
+		}
+	}
+}
+
+func TestListUpdateInstances(t *testing.T) {
+	for _, fixture := range []string{"v2list.manifest.json", "ociv1list.manifest.json"} {
+		blob, err := ioutil.ReadFile(filepath.Join("fixtures", fixture))
+		require.NoError(t, err)
+		list, err := ListFromBlob(blob, "")
+		require.NoError(t, err)
+		original := list.Instances()
+
+		updates := make([]ListUpdate, len(original))
+		for i, instance := range original {
+			updates[i] = ListUpdate{
+				Digest:    digest.FromBytes([]byte(instance.Digest)),
+				Size:      instance.Size + 1,
+				MediaType: imgspecv1.MediaTypeImageManifest,
+			}
+		}
+		err = list.UpdateInstances(updates)
+		require.NoError(t, err, fixture)
+		for i, instance := range list.Instances() {
+			assert.Equal(t, ListInstance{
+				Digest:       updates[i].Digest,
+				Size:         updates[i].Size,
+				MediaType:    updates[i].MediaType,
+				Architecture: original[i].Architecture,
+				OS:           original[i].OS,
+				Variant:      original[i].Variant,
+			}, instance, fixture)
+		}
+
+		// Invalid updates are rejected; the list is not modified.
+		updated := list.Instances()
+		for _, invalid := range [][]ListUpdate{
+			updates[1:],
+			append(updates[:len(updates)-1:len(updates)-1], ListUpdate{Digest: "sha256:invalid", MediaType: imgspecv1.MediaTypeImageManifest}),
+			append(updates[:len(updates)-1:len(updates)-1], ListUpdate{Digest: updates[0].Digest}),
+		} {
+			err = list.UpdateInstances(invalid)
+			assert.Error(t, err, fixture)
+			assert.Equal(t, updated, list.Instances(), fixture)
+		}
+	}
+}
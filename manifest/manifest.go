@@ -1,11 +1,13 @@
 package manifest
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	_ "crypto/sha256" // Make digest.SHA256 available
+	_ "crypto/sha512" // Make digest.SHA384 and digest.SHA512 available
 	"encoding/json"
+	"fmt"
 
 	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -70,7 +72,13 @@ func GuessMIMEType(manifest []byte) string {
 }
 
 // Digest returns the a digest of a docker manifest, with any necessary implied transformations like stripping v1s1 signatures.
-func Digest(manifest []byte) (string, error) {
+func Digest(manifest []byte) (digest.Digest, error) {
+	return digestWithAlgorithm(manifest, digest.Canonical)
+}
+
+// digestWithAlgorithm returns the a digest of a docker manifest computed using algorithm,
+// with any necessary implied transformations like stripping v1s1 signatures.
+func digestWithAlgorithm(manifest []byte, algorithm digest.Algorithm) (digest.Digest, error) {
 	if GuessMIMEType(manifest) == DockerV2Schema1SignedMediaType {
 		sig, err := libtrust.ParsePrettySignature(manifest, "signatures")
 		if err != nil {
@@ -84,17 +92,19 @@ func Digest(manifest []byte) (string, error) {
 		}
 	}
 
-	hash := sha256.Sum256(manifest)
-	return "sha256:" + hex.EncodeToString(hash[:]), nil
+	return algorithm.FromBytes(manifest), nil
 }
 
 // MatchesDigest returns true iff the manifest matches expectedDigest.
+// The digest is computed using the algorithm of expectedDigest; malformed digests and unsupported algorithms never match.
 // Error may be set if this returns false.
 // Note that this is not doing ConstantTimeCompare; by the time we get here, the cryptographic signature must already have been verified,
 // or we are not using a cryptographic channel and the attacker can modify the digest along with the manifest blob.
-func MatchesDigest(manifest []byte, expectedDigest string) (bool, error) {
-	// This should eventually support various digest types.
-	actualDigest, err := Digest(manifest)
+func MatchesDigest(manifest []byte, expectedDigest digest.Digest) (bool, error) {
+	if err := expectedDigest.Validate(); err != nil {
+		return false, nil
+	}
+	actualDigest, err := digestWithAlgorithm(manifest, expectedDigest.Algorithm())
 	if err != nil {
 		return false, err
 	}
@@ -118,3 +128,67 @@ func AddDummyV2S1Signature(manifest []byte) ([]byte, error) {
 	}
 	return js.PrettySignature("signatures")
 }
+
+// ManifestLayerCompressionIncompatibilityError indicates that a layer, as currently compressed, can not be represented
+// in the requested manifest format.  A caller receiving this error can retry after compressing the layers differently,
+// or try a different manifest format.
+type ManifestLayerCompressionIncompatibilityError struct {
+	text string
+}
+
+func (m ManifestLayerCompressionIncompatibilityError) Error() string {
+	return m.text
+}
+
+// NewManifestLayerCompressionIncompatibilityError returns a ManifestLayerCompressionIncompatibilityError with the specified text.
+func NewManifestLayerCompressionIncompatibilityError(text string) ManifestLayerCompressionIncompatibilityError {
+	return ManifestLayerCompressionIncompatibilityError{text: text}
+}
+
+// referenceDescriptor is the subset of a descriptor used by References.
+type referenceDescriptor struct {
+	Digest digest.Digest `json:"digest"`
+}
+
+// References returns the digests of the blobs referenced by manifest (e.g. the config and the layers of an image),
+// and of the manifests it references (e.g. the instances of a manifest list).
+// All supported manifest formats are parsed the same way, regardless of their MIME type, so that callers which must not miss
+// any reference (e.g. when removing unreferenced blobs) are not affected by a missing or incorrectly guessed MIME type.
+func References(manifest []byte) (blobs []digest.Digest, manifests []digest.Digest, err error) {
+	m := struct {
+		Config   *referenceDescriptor  `json:"config"`
+		Layers   []referenceDescriptor `json:"layers"`
+		Blobs    []referenceDescriptor `json:"blobs"` // OCI artifact manifests
+		FSLayers []struct {
+			BlobSum digest.Digest `json:"blobSum"`
+		} `json:"fsLayers"` // Docker schema1
+		Manifests []referenceDescriptor `json:"manifests"`
+		Subject   *referenceDescriptor  `json:"subject"`
+	}{}
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, nil, err
+	}
+	blobs = []digest.Digest{}
+	if m.Config != nil {
+		blobs = append(blobs, m.Config.Digest)
+	}
+	for _, d := range append(m.Layers, m.Blobs...) {
+		blobs = append(blobs, d.Digest)
+	}
+	for _, l := range m.FSLayers {
+		blobs = append(blobs, l.BlobSum)
+	}
+	manifests = []digest.Digest{}
+	for _, d := range m.Manifests {
+		manifests = append(manifests, d.Digest)
+	}
+	if m.Subject != nil {
+		manifests = append(manifests, m.Subject.Digest)
+	}
+	for _, d := range append(append([]digest.Digest{}, blobs...), manifests...) {
+		if err := d.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("Invalid digest %q referenced by manifest: %v", d, err)
+		}
+	}
+	return blobs, manifests, nil
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -41,7 +42,7 @@ func TestGuessMIMEType(t *testing.T) {
 func TestDigest(t *testing.T) {
 	cases := []struct {
 		path   string
-		digest string
+		digest digest.Digest
 	}{
 		{"v2s2.manifest.json", TestDockerV2S2ManifestDigest},
 		{"v2s1.manifest.json", TestDockerV2S1ManifestDigest},
@@ -50,30 +51,32 @@ func TestDigest(t *testing.T) {
 	for _, c := range cases {
 		manifest, err := ioutil.ReadFile(filepath.Join("fixtures", c.path))
 		require.NoError(t, err)
-		digest, err := Digest(manifest)
+		actualDigest, err := Digest(manifest)
 		require.NoError(t, err)
-		assert.Equal(t, c.digest, digest)
+		assert.Equal(t, c.digest, actualDigest)
 	}
 
 	manifest, err := ioutil.ReadFile("fixtures/v2s1-invalid-signatures.manifest.json")
 	require.NoError(t, err)
-	digest, err := Digest(manifest)
+	actualDigest, err := Digest(manifest)
 	assert.Error(t, err)
 
-	digest, err = Digest([]byte{})
+	actualDigest, err = Digest([]byte{})
 	require.NoError(t, err)
-	assert.Equal(t, "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", digest)
+	assert.Equal(t, digest.Digest("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"), actualDigest)
 }
 
 func TestMatchesDigest(t *testing.T) {
 	cases := []struct {
 		path   string
-		digest string
+		digest digest.Digest
 		result bool
 	}{
 		// Success
 		{"v2s2.manifest.json", TestDockerV2S2ManifestDigest, true},
 		{"v2s1.manifest.json", TestDockerV2S1ManifestDigest, true},
+		// Other algorithms are supported as well
+		{"v2s2.manifest.json", "sha512:50763a72163eef344fc0b58ec5a2676ceeddfa46b547475013778f3de5c0c1a75e18c947db36483e4622c1d46a908aa26649e6b0ac22514b8100889f74ed2b8c", true},
 		// No match (switched s1/s2)
 		{"v2s2.manifest.json", TestDockerV2S1ManifestDigest, false},
 		{"v2s1.manifest.json", TestDockerV2S2ManifestDigest, false},
@@ -96,7 +99,7 @@ func TestMatchesDigest(t *testing.T) {
 	require.NoError(t, err)
 	// Even a correct SHA256 hash is rejected if we can't strip the JSON signature.
 	hash := sha256.Sum256(manifest)
-	res, err := MatchesDigest(manifest, "sha256:"+hex.EncodeToString(hash[:]))
+	res, err := MatchesDigest(manifest, digest.Digest("sha256:"+hex.EncodeToString(hash[:])))
 	assert.False(t, res)
 	assert.Error(t, err)
 
@@ -121,3 +124,43 @@ func TestAddDummyV2S1Signature(t *testing.T) {
 	_, err = AddDummyV2S1Signature([]byte("}this is invalid JSON"))
 	assert.Error(t, err)
 }
+
+func TestReferences(t *testing.T) {
+	for _, c := range []struct {
+		path              string
+		blobs, manifests  int
+		firstBlob, firstM digest.Digest
+	}{
+		{"ociv1.manifest.json", 4, 0, "sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7", ""},
+		{"v2s1.manifest.json", 3, 0, "sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef", ""},
+		{"v2list.manifest.json", 0, 5, "", "sha256:7820f9a86d4ad15a2c4f0c0e5479298df2aa7c2f6871288e2ef8546f3e7b6783"},
+	} {
+		manifest, err := ioutil.ReadFile(filepath.Join("fixtures", c.path))
+		require.NoError(t, err)
+		blobs, manifests, err := References(manifest)
+		require.NoError(t, err, c.path)
+		require.Len(t, blobs, c.blobs, c.path)
+		require.Len(t, manifests, c.manifests, c.path)
+		if c.blobs > 0 {
+			assert.Equal(t, c.firstBlob, blobs[0], c.path)
+		}
+		if c.manifests > 0 {
+			assert.Equal(t, c.firstM, manifests[0], c.path)
+		}
+	}
+
+	// An index without a MIME type, which would be guessed to be a schema2 manifest
+	blobs, manifests, err := References([]byte(`{"schemaVersion":2,"manifests":[{"digest":"sha256:7820f9a86d4ad15a2c4f0c0e5479298df2aa7c2f6871288e2ef8546f3e7b6783"}]}`))
+	require.NoError(t, err)
+	assert.Empty(t, blobs)
+	assert.Equal(t, []digest.Digest{"sha256:7820f9a86d4ad15a2c4f0c0e5479298df2aa7c2f6871288e2ef8546f3e7b6783"}, manifests)
+
+	for _, manifest := range []string{
+		"not JSON",
+		`{"layers":[{"digest":"invalid"}]}`,
+		`{"manifests":[{"digest":"sha256:../../etc"}]}`,
+	} {
+		_, _, err := References([]byte(manifest))
+		assert.Error(t, err, manifest)
+	}
+}
@@ -0,0 +1,127 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// OCI1LayerUncompressedMediaType is the MIME type used for uncompressed OCI layers.
+	// FIXME: Use the image-spec constant once the vendored version provides it.
+	OCI1LayerUncompressedMediaType = "application/vnd.oci.image.layer.v1.tar"
+	// OCI1LayerZstdMediaType is the MIME type used for zstd-compressed OCI layers.
+	// FIXME: Use the image-spec constant once the vendored version provides it.
+	OCI1LayerZstdMediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+)
+
+// OCI1Descriptor is a descriptor in the OCI image specification.
+// It is defined here instead of using imgspecv1.Descriptor, because the vendored version of that type does not support annotations.
+type OCI1Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      digest.Digest     `json:"digest"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// OCI1 is a manifest in the OCI image specification.
+type OCI1 struct {
+	SchemaVersion     int               `json:"schemaVersion"`
+	MediaType         string            `json:"mediaType"`
+	ConfigDescriptor  OCI1Descriptor    `json:"config"`
+	LayersDescriptors []OCI1Descriptor  `json:"layers"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+}
+
+// OCI1FromManifest creates an OCI1 manifest instance from a manifest blob.
+func OCI1FromManifest(manifest []byte) (*OCI1, error) {
+	oci1 := OCI1{}
+	if err := json.Unmarshal(manifest, &oci1); err != nil {
+		return nil, err
+	}
+	if err := oci1.ConfigDescriptor.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid config digest %q: %v", oci1.ConfigDescriptor.Digest, err)
+	}
+	for _, layer := range oci1.LayersDescriptors {
+		if err := layer.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid layer digest %q: %v", layer.Digest, err)
+		}
+	}
+	return &oci1, nil
+}
+
+// OCI1FromComponents creates an OCI1 manifest instance from the supplied data.
+func OCI1FromComponents(config OCI1Descriptor, layers []OCI1Descriptor) *OCI1 {
+	return &OCI1{
+		SchemaVersion:     2,
+		MediaType:         imgspecv1.MediaTypeImageManifest,
+		ConfigDescriptor:  config,
+		LayersDescriptors: layers,
+	}
+}
+
+// Serialize returns the manifest in a blob format.
+// NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
+func (m *OCI1) Serialize() ([]byte, error) {
+	return json.Marshal(*m)
+}
+
+// ConfigInfo returns a complete BlobInfo for the separate config object.
+func (m *OCI1) ConfigInfo() types.BlobInfo {
+	return types.BlobInfo{Digest: m.ConfigDescriptor.Digest, Size: m.ConfigDescriptor.Size, MediaType: m.ConfigDescriptor.MediaType,
+		Annotations: m.ConfigDescriptor.Annotations}
+}
+
+// ConfigUpdate replaces the config object with configBlob, keeping the config MIME type.
+// The caller is responsible for storing configBlob.
+func (m *OCI1) ConfigUpdate(configBlob []byte) {
+	m.ConfigDescriptor.Digest = digest.FromBytes(configBlob)
+	m.ConfigDescriptor.Size = int64(len(configBlob))
+}
+
+// LayerInfos returns a list of BlobInfos of layers referenced by this image, in order (the root layer first, and then successive layered layers).
+// The Digest field is guaranteed to be provided; Size may be -1.
+// WARNING: The list may contain duplicates, and they are semantically relevant.
+func (m *OCI1) LayerInfos() []types.BlobInfo {
+	blobs := []types.BlobInfo{}
+	for _, layer := range m.LayersDescriptors {
+		blobs = append(blobs, types.BlobInfo{Digest: layer.Digest, Size: layer.Size, MediaType: layer.MediaType, URLs: layer.URLs,
+			Annotations: layer.Annotations})
+	}
+	return blobs
+}
+
+// UpdateLayerInfos replaces the original layers with the specified BlobInfos (size+digest+urls+annotations), in order (the root layer first, and then successive layered layers).
+// The MIME types of the layers are updated to reflect CompressionOperation and CompressionAlgorithm of the BlobInfos.
+// Annotations of the original layers are kept only if the layer is not modified and the BlobInfo does not specify other annotations.
+func (m *OCI1) UpdateLayerInfos(layerInfos []types.BlobInfo) error {
+	if len(m.LayersDescriptors) != len(layerInfos) {
+		return fmt.Errorf("Error preparing updated manifest: layer count changed from %d to %d", len(m.LayersDescriptors), len(layerInfos))
+	}
+	layers := make([]OCI1Descriptor, len(layerInfos))
+	for i, info := range layerInfos {
+		original := m.LayersDescriptors[i]
+		mediaType, err := OCI1LayerMediaType(original.MediaType, info)
+		if err != nil {
+			return fmt.Errorf("Error preparing updated manifest: %v", err)
+		}
+		layers[i].MediaType = mediaType
+		layers[i].Digest = info.Digest
+		layers[i].Size = info.Size
+		layers[i].URLs = info.URLs
+		switch {
+		case info.Annotations != nil:
+			layers[i].Annotations = info.Annotations
+		case info.CompressionOperation == types.PreserveOriginal:
+			layers[i].Annotations = original.Annotations
+		default: // Annotations of the original blob may not apply to the recompressed one.
+			layers[i].Annotations = nil
+		}
+	}
+	m.LayersDescriptors = layers
+	return nil
+}
@@ -0,0 +1,113 @@
+package manifest
+
+import (
+	"encoding/json"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// OCI1Platform describes the platform which a particular manifest in an OCI1Index is specialized for.
+type OCI1Platform struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+	Features     []string `json:"features,omitempty"`
+}
+
+// OCI1ManifestDescriptor references a manifest in an OCI1Index.
+type OCI1ManifestDescriptor struct {
+	OCI1Descriptor
+	Platform *OCI1Platform `json:"platform,omitempty"` // nil if the manifest is not platform-specific
+}
+
+// OCI1Index is a list of manifests in the OCI image specification (an “image index”, called a “manifest list” in
+// the vendored version of the specification).
+type OCI1Index struct {
+	SchemaVersion int                      `json:"schemaVersion"`
+	MediaType     string                   `json:"mediaType,omitempty"`
+	Manifests     []OCI1ManifestDescriptor `json:"manifests"`
+	Annotations   map[string]string        `json:"annotations,omitempty"`
+}
+
+// OCI1IndexFromManifest creates an OCI1Index instance from a manifest list blob.
+func OCI1IndexFromManifest(manifest []byte) (*OCI1Index, error) {
+	index := OCI1Index{}
+	if err := json.Unmarshal(manifest, &index); err != nil {
+		return nil, err
+	}
+	if err := validateListInstances(index.Instances()); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// OCI1IndexFromComponents creates an OCI1Index instance from the supplied data.
+func OCI1IndexFromComponents(manifests []OCI1ManifestDescriptor, annotations map[string]string) *OCI1Index {
+	return &OCI1Index{
+		SchemaVersion: 2,
+		MediaType:     imgspecv1.MediaTypeImageManifestList,
+		Manifests:     manifests,
+		Annotations:   annotations,
+	}
+}
+
+// MIMEType returns the MIME type of this particular manifest list.
+func (index *OCI1Index) MIMEType() string {
+	return imgspecv1.MediaTypeImageManifestList
+}
+
+// Instances returns the manifests referenced by this list, in order.
+// WARNING: The list may contain duplicate digests, e.g. if the same image is listed for several platforms.
+func (index *OCI1Index) Instances() []ListInstance {
+	res := make([]ListInstance, len(index.Manifests))
+	for i, m := range index.Manifests {
+		res[i] = ListInstance{
+			Digest:    m.Digest,
+			Size:      m.Size,
+			MediaType: m.MediaType,
+		}
+		if m.Platform != nil {
+			res[i].Architecture = m.Platform.Architecture
+			res[i].OS = m.Platform.OS
+			res[i].Variant = m.Platform.Variant
+		}
+	}
+	return res
+}
+
+// Instance returns information about the first manifest in the list with instanceDigest.
+func (index *OCI1Index) Instance(instanceDigest digest.Digest) (ListInstance, error) {
+	return findListInstance(index.Instances(), instanceDigest)
+}
+
+// UpdateInstances replaces the digests, sizes and MIME types of the manifests referenced by this list,
+// in the order returned by Instances().
+// Annotations of the manifests are not modified.
+func (index *OCI1Index) UpdateInstances(updates []ListUpdate) error {
+	if err := checkListUpdates(len(index.Manifests), updates); err != nil {
+		return err
+	}
+	for i, update := range updates {
+		index.Manifests[i].Digest = update.Digest
+		index.Manifests[i].Size = update.Size
+		index.Manifests[i].MediaType = update.MediaType
+	}
+	return nil
+}
+
+// ChooseInstance returns the digest of the manifest matching the platform specified by sys
+// (or the platform we are running on, for values not set in sys, which may be nil).
+// Manifests without a platform are never chosen.
+func (index *OCI1Index) ChooseInstance(sys *types.SystemContext) (digest.Digest, error) {
+	return chooseListInstance(sys, index.Instances())
+}
+
+// Serialize returns the list in a blob format.
+// NOTE: Serialize() does not in general reproduce the original blob if this object was loaded from one, even if no modifications were made!
+func (index *OCI1Index) Serialize() ([]byte, error) {
+	return json.Marshal(*index)
+}
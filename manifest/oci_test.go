@@ -0,0 +1,108 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOCI1FromManifest(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("fixtures", "ociv1.manifest.json"))
+	require.NoError(t, err)
+	m, err := OCI1FromManifest(blob)
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, m.MediaType)
+	assert.Equal(t, types.BlobInfo{
+		Digest:    "sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7",
+		Size:      7023,
+		MediaType: "application/vnd.oci.image.serialization.config.v1+json",
+	}, m.ConfigInfo())
+	require.Len(t, m.LayerInfos(), 3)
+	assert.Equal(t, types.BlobInfo{
+		Digest:    "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+		Size:      32654,
+		MediaType: "application/vnd.oci.image.serialization.rootfs.tar.gzip",
+	}, m.LayerInfos()[0])
+
+	// Invalid input
+	_, err = OCI1FromManifest([]byte("this is not JSON"))
+	assert.Error(t, err)
+	_, err = OCI1FromManifest([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:invalid"},"layers":[]}`))
+	assert.Error(t, err)
+	_, err = OCI1FromManifest([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7"},` +
+		`"layers":[{"digest":"sha256:invalid"}]}`))
+	assert.Error(t, err)
+}
+
+func TestOCI1FromComponents(t *testing.T) {
+	config := OCI1Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Size: 2, Digest: digest.FromBytes([]byte("{}"))}
+	layer := OCI1Descriptor{MediaType: imgspecv1.MediaTypeImageLayer, Size: 5, Digest: digest.FromBytes([]byte("layer")),
+		Annotations: map[string]string{"key": "value"}}
+	m := OCI1FromComponents(config, []OCI1Descriptor{layer})
+
+	blob, err := m.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, GuessMIMEType(blob))
+	m2, err := OCI1FromManifest(blob)
+	require.NoError(t, err)
+	assert.Equal(t, m, m2)
+}
+
+func TestOCI1ConfigUpdate(t *testing.T) {
+	config := OCI1Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Size: 2, Digest: digest.FromBytes([]byte("{}"))}
+	m := OCI1FromComponents(config, []OCI1Descriptor{})
+
+	configBlob := []byte(`{"architecture":"amd64"}`)
+	m.ConfigUpdate(configBlob)
+	assert.Equal(t, types.BlobInfo{
+		Digest:    digest.FromBytes(configBlob),
+		Size:      int64(len(configBlob)),
+		MediaType: imgspecv1.MediaTypeImageConfig,
+	}, m.ConfigInfo())
+}
+
+func TestOCI1UpdateLayerInfos(t *testing.T) {
+	annotations := map[string]string{"key": "value"}
+	m := OCI1FromComponents(OCI1Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Size: 2, Digest: digest.FromBytes([]byte("{}"))},
+		[]OCI1Descriptor{
+			{MediaType: OCI1LayerUncompressedMediaType, Size: 1, Digest: digest.FromBytes([]byte("1")), Annotations: annotations},
+			{MediaType: imgspecv1.MediaTypeImageLayer, Size: 2, Digest: digest.FromBytes([]byte("2")), Annotations: annotations},
+			{MediaType: imgspecv1.MediaTypeImageLayer, Size: 3, Digest: digest.FromBytes([]byte("3")), Annotations: annotations},
+			{MediaType: imgspecv1.MediaTypeImageLayer, Size: 4, Digest: digest.FromBytes([]byte("4")), Annotations: annotations},
+		})
+
+	chunkedAnnotations := map[string]string{"chunked": "toc"}
+	err := m.UpdateLayerInfos([]types.BlobInfo{
+		{Digest: digest.FromBytes([]byte("1z")), Size: 10, CompressionOperation: types.Compress, CompressionAlgorithm: types.ZstdChunkedCompression,
+			Annotations: chunkedAnnotations},
+		{Digest: digest.FromBytes([]byte("2u")), Size: 20, CompressionOperation: types.Decompress},
+		{Digest: digest.FromBytes([]byte("3")), Size: 3, CompressionOperation: types.PreserveOriginal, CompressionAlgorithm: types.ZstdCompression},
+		{Digest: digest.FromBytes([]byte("4")), Size: 4, CompressionOperation: types.PreserveOriginal},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []OCI1Descriptor{
+		{MediaType: OCI1LayerZstdMediaType, Size: 10, Digest: digest.FromBytes([]byte("1z")), Annotations: chunkedAnnotations},
+		{MediaType: OCI1LayerUncompressedMediaType, Size: 20, Digest: digest.FromBytes([]byte("2u"))},
+		{MediaType: OCI1LayerZstdMediaType, Size: 3, Digest: digest.FromBytes([]byte("3")), Annotations: annotations},
+		{MediaType: imgspecv1.MediaTypeImageLayer, Size: 4, Digest: digest.FromBytes([]byte("4")), Annotations: annotations},
+	}, m.LayersDescriptors)
+
+	// Layer count changes are rejected.
+	err = m.UpdateLayerInfos(m.LayerInfos()[1:])
+	assert.Error(t, err)
+
+	// Unsupported compression algorithms are rejected; the manifest is not modified.
+	original := m.LayerInfos()
+	bzip2Infos := m.LayerInfos()
+	bzip2Infos[1].CompressionOperation = types.Compress
+	bzip2Infos[1].CompressionAlgorithm = types.Bzip2Compression
+	err = m.UpdateLayerInfos(bzip2Infos)
+	assert.Error(t, err)
+	assert.Equal(t, original, m.LayerInfos())
+}
@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/containers/image/pkg/putblobdigest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+type memoryImageDestination struct {
+	ref        memoryReference
+	manifest   []byte
+	signatures [][]byte
+	blobs      map[digest.Digest][]byte
+}
+
+// newImageDestination returns an ImageDestination for writing an image to the memory transport.
+// Nothing is visible to readers until Commit is called.
+func newImageDestination(ref memoryReference) types.ImageDestination {
+	return &memoryImageDestination{
+		ref:   ref,
+		blobs: map[digest.Digest][]byte{},
+	}
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (d *memoryImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+func (d *memoryImageDestination) Close() {
+}
+
+func (d *memoryImageDestination) SupportedManifestMIMETypes() []string {
+	return nil
+}
+
+// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
+// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
+func (d *memoryImageDestination) SupportsSignatures(ctx context.Context) error {
+	return nil
+}
+
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d *memoryImageDestination) DesiredLayerCompression() types.LayerCompression {
+	return types.PreserveOriginal
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *memoryImageDestination) HasThreadSafePutBlob() bool {
+	return false // d.blobs is not protected by a lock
+}
+
+// PutBlob writes contents of stream and returns data representing the result (with all data filled in).
+// inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
+// inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (d *memoryImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	contents, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	size := int64(len(contents))
+	if inputInfo.Size != -1 && size != inputInfo.Size {
+		return types.BlobInfo{}, fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", inputInfo.Digest, inputInfo.Size, size)
+	}
+	computedDigest := digester.Digest()
+	if inputInfo.Digest != "" && inputInfo.Digest != computedDigest {
+		return types.BlobInfo{}, fmt.Errorf("Digest mismatch when copying blob, expected %s, got %s", inputInfo.Digest, computedDigest)
+	}
+	d.blobs[computedDigest] = contents
+	return types.BlobInfo{Digest: computedDigest, Size: size}, nil
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *memoryImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	if info.Digest == "" {
+		return false, types.BlobInfo{}, errors.New("Can not check for a blob with unknown digest")
+	}
+	contents, ok := d.blobs[info.Digest]
+	if !ok {
+		return false, types.BlobInfo{}, nil
+	}
+	return true, types.BlobInfo{Digest: info.Digest, Size: int64(len(contents))}, nil
+}
+
+func (d *memoryImageDestination) PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the memory: transport")
+	}
+	d.manifest = copyBytes(manifest)
+	return nil
+}
+
+func (d *memoryImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the memory: transport")
+	}
+	d.signatures = make([][]byte, len(signatures))
+	for i, sig := range signatures {
+		d.signatures[i] = copyBytes(sig)
+	}
+	return nil
+}
+
+// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+// WARNING: This does not have any transactional semantics:
+// - Uploaded data MAY be visible to others before Commit() is called
+// - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
+func (d *memoryImageDestination) Commit(ctx context.Context) error {
+	if d.manifest == nil {
+		return errors.New("Internal error: memoryImageDestination.Commit() called without PutManifest()")
+	}
+	blobs := make(map[digest.Digest][]byte, len(d.blobs))
+	for digest, blob := range d.blobs {
+		blobs[digest] = blob
+	}
+	Transport.put(d.ref.name, &storedImage{
+		manifest:   d.manifest,
+		signatures: d.signatures,
+		blobs:      blobs,
+	})
+	return nil
+}
@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+type memoryImageSource struct {
+	ref memoryReference
+	img *storedImage
+}
+
+// newImageSource returns an ImageSource for reading an image committed to the memory transport.
+// The source is a snapshot; later commits to the same name do not affect it.
+func newImageSource(ref memoryReference) (*memoryImageSource, error) {
+	img := Transport.get(ref.name)
+	if img == nil {
+		return nil, fmt.Errorf("Image %s not found in memory", ref.name)
+	}
+	return &memoryImageSource{ref: ref, img: img}, nil
+}
+
+// Reference returns the reference used to set up this source, _as specified by the user_
+// (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
+func (s *memoryImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+// Close removes resources associated with an initialized ImageSource, if any.
+func (s *memoryImageSource) Close() {
+}
+
+// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
+// It may use a remote (= slow) service.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *memoryImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return nil, "", fmt.Errorf("Getting target manifest not supported by memory:")
+	}
+	return copyBytes(s.img.manifest), manifest.GuessMIMEType(s.img.manifest), nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *memoryImageSource) HasThreadSafeGetBlob() bool {
+	return true
+}
+
+// GetBlob returns a stream for the specified blob, and the blob's size (or -1 if unknown).
+func (s *memoryImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blob, ok := s.img.blobs[info.Digest]
+	if !ok {
+		return nil, -1, fmt.Errorf("Blob %s not found in image %s", info.Digest, s.ref.name)
+	}
+	return ioutil.NopCloser(bytes.NewReader(blob)), int64(len(blob)), nil
+}
+
+// GetSignatures returns the image's signatures.  It may use a remote (= slow) service.
+func (s *memoryImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	if instanceDigest != nil {
+		return nil, errors.New("Manifest lists are not supported by the memory: transport")
+	}
+	signatures := [][]byte{}
+	for _, sig := range s.img.signatures {
+		signatures = append(signatures, copyBytes(sig))
+	}
+	return signatures, nil
+}
+
+// copyBytes returns a copy of data, so that callers can't modify the stored image.
+func copyBytes(data []byte) []byte {
+	res := make([]byte, len(data))
+	copy(res, data)
+	return res
+}
@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "memory", Transport.Name())
+}
+
+func TestTransportParseReference(t *testing.T) {
+	ref, err := Transport.ParseReference("some/image:tag")
+	require.NoError(t, err)
+	assert.Equal(t, Transport, ref.Transport())
+	assert.Equal(t, "some/image:tag", ref.StringWithinTransport())
+	assert.Equal(t, "some/image:tag", ref.PolicyConfigurationIdentity())
+	assert.Empty(t, ref.PolicyConfigurationNamespaces())
+	assert.Nil(t, ref.DockerReference())
+
+	_, err = Transport.ParseReference("")
+	assert.Error(t, err)
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	err := Transport.ValidatePolicyConfigurationScope("some/image:tag")
+	assert.NoError(t, err)
+}
+
+// putImage writes an image with the specified blob, manifest and signatures to ref.
+func putImage(t *testing.T, ref types.ImageReference, blob, manifest []byte, signatures [][]byte) types.BlobInfo {
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: "", Size: -1}, false)
+	require.NoError(t, err)
+	err = dest.PutManifest(context.Background(), manifest, nil)
+	require.NoError(t, err)
+	err = dest.PutSignatures(context.Background(), signatures, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+	return info
+}
+
+func TestRoundTrip(t *testing.T) {
+	ref, err := NewReference("TestRoundTrip")
+	require.NoError(t, err)
+	defer ref.DeleteImage(context.Background(), nil)
+
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+
+	blob := []byte("This is a test blob.")
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	signatures := [][]byte{[]byte("sig1"), []byte("sig2")}
+	info := putImage(t, ref, blob, manifest, signatures)
+	assert.Equal(t, digest.Digest("sha256:a2b2aef9b151ae76f026978cd8a8fb9c5e984a8c0578204d62cb0f3e609265d1"), info.Digest)
+	assert.Equal(t, int64(len(blob)), info.Size)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	m, mimeType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, m)
+	assert.Equal(t, "application/vnd.docker.distribution.manifest.v2+json", mimeType)
+	stream, size, err := src.GetBlob(context.Background(), info, blobinfocache.NoCache)
+	require.NoError(t, err)
+	contents, err := ioutil.ReadAll(stream)
+	stream.Close()
+	require.NoError(t, err)
+	assert.Equal(t, blob, contents)
+	assert.Equal(t, int64(len(blob)), size)
+	_, _, err = src.GetBlob(context.Background(), types.BlobInfo{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000", Size: -1}, blobinfocache.NoCache)
+	assert.Error(t, err)
+	sigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, signatures, sigs)
+
+	// An existing source is a snapshot, unaffected by further commits.
+	putImage(t, ref, []byte("Another blob."), []byte(`{"schemaVersion":1}`), nil)
+	m, _, err = src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, m)
+
+	err = ref.DeleteImage(context.Background(), nil)
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+	err = ref.DeleteImage(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestPutBlobDigestMismatch(t *testing.T) {
+	ref, err := NewReference("TestPutBlobDigestMismatch")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader([]byte("contents")), types.BlobInfo{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000", Size: -1}, false)
+	assert.Error(t, err)
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader([]byte("contents")), types.BlobInfo{Digest: "", Size: 1}, false)
+	assert.Error(t, err)
+
+	err = dest.Commit(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,161 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Transport is an ImageTransport for images stored in the memory of the current process.
+// This is primarily useful for tests, and for building images programmatically before copying them elsewhere.
+var Transport = &memoryTransport{images: map[string]*storedImage{}}
+
+type memoryTransport struct {
+	mutex  sync.Mutex
+	images map[string]*storedImage // Committed images, indexed by name.
+}
+
+// storedImage is an image committed to the memory transport.
+// Its contents are never modified after the image is committed.
+type storedImage struct {
+	manifest   []byte
+	signatures [][]byte
+	blobs      map[digest.Digest][]byte
+}
+
+func (t *memoryTransport) Name() string {
+	return "memory"
+}
+
+// ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
+func (t *memoryTransport) ParseReference(reference string) (types.ImageReference, error) {
+	return NewReference(reference)
+}
+
+// ValidatePolicyConfigurationScope checks that scope is a valid name for a signature.PolicyTransportScopes keys
+// (i.e. a valid PolicyConfigurationIdentity() or PolicyConfigurationNamespaces() return value).
+// It is acceptable to allow an invalid value which will never be matched, it can "only" cause user confusion.
+// scope passed to this function will not be "", that value is always allowed.
+func (t *memoryTransport) ValidatePolicyConfigurationScope(scope string) error {
+	// Any name is a valid image name, and we don't have any namespaces.
+	return nil
+}
+
+// get returns the image committed under name, or nil if there is no such image.
+func (t *memoryTransport) get(name string) *storedImage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.images[name]
+}
+
+// put stores img under name, replacing any previously committed image.
+func (t *memoryTransport) put(name string, img *storedImage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.images[name] = img
+}
+
+// delete removes the image committed under name, if any, and returns true iff there was such an image.
+func (t *memoryTransport) delete(name string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, ok := t.images[name]
+	delete(t.images, name)
+	return ok
+}
+
+// memoryReference is an ImageReference for an image stored in memory.
+type memoryReference struct {
+	name string
+}
+
+// NewReference returns a memory reference for an image with the specified name.
+// The name is an arbitrary non-empty string.
+func NewReference(name string) (types.ImageReference, error) {
+	if name == "" {
+		return nil, errors.New("Invalid memory: reference: the image name must not be empty")
+	}
+	return memoryReference{name: name}, nil
+}
+
+func (ref memoryReference) Transport() types.ImageTransport {
+	return Transport
+}
+
+// StringWithinTransport returns a string representation of the reference, which MUST be such that
+// reference.Transport().ParseReference(reference.StringWithinTransport()) returns an equivalent reference.
+// NOTE: The returned string is not promised to be equal to the original input to ParseReference;
+// e.g. default attribute values omitted by the user may be filled in in the return value, or vice versa.
+// WARNING: Do not use the return value in the UI to describe an image, it does not contain the Transport().Name() prefix.
+func (ref memoryReference) StringWithinTransport() string {
+	return ref.name
+}
+
+// DockerReference returns a Docker reference associated with this reference
+// (fully explicit, i.e. !reference.IsNameOnly, but reflecting user intent,
+// not e.g. after redirect or alias processing), or nil if unknown/not applicable.
+func (ref memoryReference) DockerReference() reference.Named {
+	return nil
+}
+
+// PolicyConfigurationIdentity returns a string representation of the reference, suitable for policy lookup.
+// This MUST reflect user intent, not e.g. after processing of third-party redirects or aliases;
+// The value SHOULD be fully explicit about its semantics, with no hidden defaults, AND canonical
+// (i.e. various references with exactly the same semantics should return the same configuration identity)
+// It is fine for the return value to be equal to StringWithinTransport(), and it is desirable but
+// not required/guaranteed that it will be a valid input to Transport().ParseReference().
+// Returns "" if configuration identities for these references are not supported.
+func (ref memoryReference) PolicyConfigurationIdentity() string {
+	return ref.name
+}
+
+// PolicyConfigurationNamespaces returns a list of other policy configuration namespaces to search
+// for if explicit configuration for PolicyConfigurationIdentity() is not set.  The list will be processed
+// in order, terminating on first match, and an implicit "" is always checked at the end.
+// It is STRONGLY recommended for the first element, if any, to be a prefix of PolicyConfigurationIdentity(),
+// and each following element to be a prefix of the element preceding it.
+func (ref memoryReference) PolicyConfigurationNamespaces() []string {
+	return []string{}
+}
+
+// NewImage returns a types.ImageCloser for this reference, possibly specialized for this ImageTransport.
+// The caller must call .Close() on the returned ImageCloser.
+// NOTE: If any kind of signature verification should happen, build an UnparsedImage from the value returned by NewImageSource,
+// verify that UnparsedImage, and convert it into a real Image via image.FromUnparsedImage.
+func (ref memoryReference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	src, err := newImageSource(ref)
+	if err != nil {
+		return nil, err
+	}
+	return image.FromSource(ctx, sys, src)
+}
+
+// NewImageSource returns a types.ImageSource for this reference.
+// The caller must call .Close() on the returned ImageSource.
+func (ref memoryReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	src, err := newImageSource(ref)
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// NewImageDestination returns a types.ImageDestination for this reference.
+// The caller must call .Close() on the returned ImageDestination.
+func (ref memoryReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return newImageDestination(ref), nil
+}
+
+// DeleteImage deletes the named image from the registry, if supported.
+func (ref memoryReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	if !Transport.delete(ref.name) {
+		return fmt.Errorf("Image %s not found in memory", ref.name)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/pkg/putblobdigest"
+	"github.com/containers/image/pkg/tmpdir"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+type ociArchiveImageDestination struct {
+	ref ociArchiveReference
+	// unpackedDest is an OCI layout in tempDirRef, which stores the manifest and index.json; they are added to the archive by Commit.
+	unpackedDest         types.ImageDestination
+	tempDirRef           tempDirOCIRef
+	bigFilesTemporaryDir string
+	// tarFile is the archive being written, in a temporary file next to ref.resolvedFile; it replaces the archive on Commit.
+	tarFile *os.File
+	archive *archiveWriter
+	blobs   map[digest.Digest]int64 // Sizes of the blobs added to the archive
+}
+
+// newImageDestination returns an ImageDestination for writing to an archive.
+// Blobs are added to a new archive as they are received, the manifest and index.json are added by Commit, which then replaces
+// the archive at ref; so, at most one blob (one with unknown digest or size) is staged in a temporary file at any time.
+func newImageDestination(ctx context.Context, sys *types.SystemContext, ref ociArchiveReference) (types.ImageDestination, error) {
+	tempDirRef, err := createOCIRef(sys, ref.tag)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating oci reference: %v", err)
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			if err := tempDirRef.deleteTempDir(); err != nil {
+				logrus.Debugf("Error deleting temporary directory %s: %v", tempDirRef.tempDirectory, err)
+			}
+		}
+	}()
+	unpackedDest, err := tempDirRef.ociRefExtracted.NewImageDestination(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	tarFile, err := ioutil.TempFile(filepath.Dir(ref.resolvedFile), "oci-archive")
+	if err != nil {
+		unpackedDest.Close()
+		return nil, err
+	}
+	succeeded = true
+	return &ociArchiveImageDestination{
+		ref:                  ref,
+		unpackedDest:         unpackedDest,
+		tempDirRef:           tempDirRef,
+		bigFilesTemporaryDir: tmpdir.TemporaryDirectoryForBigFiles(sys),
+		tarFile:              tarFile,
+		archive:              newArchiveWriter(tarFile),
+		blobs:                map[digest.Digest]int64{},
+	}, nil
+}
+
+// Reference returns the reference used to set up this destination.
+func (d *ociArchiveImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+// The staging directory is deleted; if Commit has not succeeded, the archive is not created.
+func (d *ociArchiveImageDestination) Close() {
+	d.unpackedDest.Close()
+	if err := d.tempDirRef.deleteTempDir(); err != nil {
+		logrus.Debugf("Error deleting temporary directory %s: %v", d.tempDirRef.tempDirectory, err)
+	}
+	if d.tarFile != nil { // Set to nil by a successful Commit
+		d.tarFile.Close()
+		os.Remove(d.tarFile.Name())
+	}
+}
+
+func (d *ociArchiveImageDestination) SupportedManifestMIMETypes() []string {
+	return d.unpackedDest.SupportedManifestMIMETypes()
+}
+
+// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
+// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
+func (d *ociArchiveImageDestination) SupportsSignatures(ctx context.Context) error {
+	return d.unpackedDest.SupportsSignatures(ctx)
+}
+
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d *ociArchiveImageDestination) DesiredLayerCompression() types.LayerCompression {
+	return d.unpackedDest.DesiredLayerCompression()
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *ociArchiveImageDestination) HasThreadSafePutBlob() bool {
+	return false // Blobs are written to a single tar stream.
+}
+
+// PutBlob writes contents of stream and returns data representing the result (with all data filled in).
+// inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
+// inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (d *ociArchiveImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	if inputInfo.Digest == "" || inputInfo.Size == -1 {
+		// The tar header, which contains the path and size of the blob, is written before the contents.
+		return d.putStagedBlob(stream, inputInfo)
+	}
+	if size, ok := d.blobs[inputInfo.Digest]; ok {
+		return types.BlobInfo{Digest: inputInfo.Digest, Size: size}, nil
+	}
+	if err := inputInfo.Digest.Validate(); err != nil {
+		return types.BlobInfo{}, fmt.Errorf("Invalid digest %q: %v", inputInfo.Digest, err)
+	}
+
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	if err := d.archive.addFile(blobPath(inputInfo.Digest), inputInfo.Size, stream, func() error {
+		if computedDigest := digester.Digest(); computedDigest != inputInfo.Digest {
+			return fmt.Errorf("Digest mismatch when copying blob, expected %s, got %s", inputInfo.Digest, computedDigest)
+		}
+		return nil
+	}); err != nil {
+		return types.BlobInfo{}, err
+	}
+	d.blobs[inputInfo.Digest] = inputInfo.Size
+	return types.BlobInfo{Digest: inputInfo.Digest, Size: inputInfo.Size}, nil
+}
+
+// putStagedBlob implements PutBlob for blobs with an unknown digest or size, by writing the blob to a temporary file first.
+func (d *ociArchiveImageDestination) putStagedBlob(stream io.Reader, inputInfo types.BlobInfo) (types.BlobInfo, error) {
+	blobFile, err := ioutil.TempFile(d.bigFilesTemporaryDir, "oci-archive-blob")
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	defer func() {
+		blobFile.Close()
+		os.Remove(blobFile.Name())
+	}()
+
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	size, err := io.Copy(blobFile, stream)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	computedDigest := digester.Digest()
+	if inputInfo.Size != -1 && size != inputInfo.Size {
+		return types.BlobInfo{}, fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", computedDigest, inputInfo.Size, size)
+	}
+	if existingSize, ok := d.blobs[computedDigest]; ok {
+		return types.BlobInfo{Digest: computedDigest, Size: existingSize}, nil
+	}
+	if _, err := blobFile.Seek(0, io.SeekStart); err != nil {
+		return types.BlobInfo{}, err
+	}
+	if err := d.archive.addFile(blobPath(computedDigest), size, blobFile, nil); err != nil {
+		return types.BlobInfo{}, err
+	}
+	d.blobs[computedDigest] = size
+	return types.BlobInfo{Digest: computedDigest, Size: size}, nil
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *ociArchiveImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	if info.Digest == "" {
+		return false, types.BlobInfo{}, errors.New("Can not check for a blob with unknown digest")
+	}
+	if size, ok := d.blobs[info.Digest]; ok {
+		return true, types.BlobInfo{Digest: info.Digest, Size: size}, nil
+	}
+	return false, types.BlobInfo{}, nil
+}
+
+func (d *ociArchiveImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	return d.unpackedDest.PutManifest(ctx, m, instanceDigest)
+}
+
+func (d *ociArchiveImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	return d.unpackedDest.PutSignatures(ctx, signatures, instanceDigest)
+}
+
+// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+// The staged manifest and index.json are added to the archive file, which then atomically replaces the file at the
+// destination, so a failed Commit does not leave a partially written archive behind.
+func (d *ociArchiveImageDestination) Commit(ctx context.Context) error {
+	if err := d.unpackedDest.Commit(ctx); err != nil {
+		return fmt.Errorf("Error storing image %q: %v", d.ref.tag, err)
+	}
+
+	if err := d.archive.addDirectory(d.tempDirRef.tempDirectory); err != nil {
+		return fmt.Errorf("Error creating archive %s: %v", d.ref.file, err)
+	}
+	if err := d.archive.finish(); err != nil {
+		return fmt.Errorf("Error creating archive %s: %v", d.ref.file, err)
+	}
+	if err := d.tarFile.Sync(); err != nil {
+		return err
+	}
+	if err := d.tarFile.Chmod(0644); err != nil {
+		return err
+	}
+	if err := os.Rename(d.tarFile.Name(), d.ref.resolvedFile); err != nil {
+		return err
+	}
+	d.tarFile.Close()
+	d.tarFile = nil
+	return nil
+}
@@ -0,0 +1,49 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAndReadArchive(t *testing.T) {
+	const blobDigest = digest.Digest("sha256:a2b2aef9b151ae76f026978cd8a8fb9c5e984a8c0578204d62cb0f3e609265d1")
+	blob := []byte("This is a test blob.")
+	m := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":20,"digest":"` + blobDigest + `"},"layers":[]}`)
+
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: "", Size: int64(len(blob))}, false)
+	require.NoError(t, err)
+	assert.Equal(t, blobDigest, info.Digest)
+	err = dest.PutManifest(context.Background(), m, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	m2, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, m, m2)
+	rc, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache)
+	require.NoError(t, err)
+	defer rc.Close()
+	blob2, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, blob, blob2)
+	assert.Equal(t, int64(len(blob)), size)
+}
@@ -0,0 +1,181 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/tarindex"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// annotationRefName is the index.json annotation which records the tag of a manifest.
+const annotationRefName = "org.opencontainers.image.ref.name"
+
+// ociIndex is the subset of index.json in an OCI image layout used by this package.
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociDescriptor is the subset of an OCI descriptor used by this package.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociArchiveImageSource struct {
+	ref ociArchiveReference
+	// archive is ref.resolvedFile, opened for reading; files within it are located using index.
+	archive            *os.File
+	index              *tarindex.Index
+	manifestDescriptor ociDescriptor
+}
+
+// newImageSource returns an ImageSource for reading from an existing archive.
+// The archive is read once to locate the files within it, which are then read directly from the archive, without extracting it.
+// The caller must call .Close() on the returned ImageSource.
+func newImageSource(ctx context.Context, sys *types.SystemContext, ref ociArchiveReference) (types.ImageSource, error) {
+	archive, err := os.Open(ref.resolvedFile)
+	if err != nil {
+		return nil, err
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			archive.Close()
+		}
+	}()
+
+	index, err := tarindex.New(archive)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", ref.file, err)
+	}
+	s := &ociArchiveImageSource{
+		ref:     ref,
+		archive: archive,
+		index:   index,
+	}
+	desc, err := s.findManifestDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	s.manifestDescriptor = desc
+	succeeded = true
+	return s, nil
+}
+
+// readFile returns the contents of name in the archive.
+func (s *ociArchiveImageSource) readFile(name string) ([]byte, error) {
+	r, _, err := s.index.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// findManifestDescriptor returns the descriptor of the manifest tagged s.ref.tag,
+// looking it up in index.json, or in refs/ for layouts which predate index.json.
+func (s *ociArchiveImageSource) findManifestDescriptor() (ociDescriptor, error) {
+	data, err := s.readFile("index.json")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return ociDescriptor{}, err
+		}
+		data, err := s.readFile("refs/" + s.ref.tag)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return ociDescriptor{}, fmt.Errorf("No manifest tagged %q found in %s", s.ref.tag, s.ref.file)
+			}
+			return ociDescriptor{}, err
+		}
+		desc := ociDescriptor{}
+		if err := json.Unmarshal(data, &desc); err != nil {
+			return ociDescriptor{}, fmt.Errorf("Error parsing refs/%s in %s: %v", s.ref.tag, s.ref.file, err)
+		}
+		return desc, nil
+	}
+
+	index := ociIndex{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return ociDescriptor{}, fmt.Errorf("Error parsing index.json in %s: %v", s.ref.file, err)
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[annotationRefName] == s.ref.tag {
+			return desc, nil
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("No manifest tagged %q found in %s", s.ref.tag, s.ref.file)
+}
+
+// openBlob returns a reader for the blob with digest in the archive, and its size.
+func (s *ociArchiveImageSource) openBlob(digest digest.Digest) (*io.SectionReader, int64, error) {
+	if err := digest.Validate(); err != nil {
+		return nil, -1, fmt.Errorf("unexpected digest reference %s: %v", digest, err)
+	}
+	r, hdr, err := s.index.Open(blobPath(digest))
+	if err != nil {
+		return nil, -1, err
+	}
+	return r, hdr.Size, nil
+}
+
+// Reference returns the reference used to set up this source.
+func (s *ociArchiveImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+// Close removes resources associated with an initialized ImageSource, if any.
+func (s *ociArchiveImageSource) Close() {
+	s.archive.Close()
+}
+
+// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
+// It may use a remote (= slow) service.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *ociArchiveImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	dig := s.manifestDescriptor.Digest
+	mt := s.manifestDescriptor.MediaType
+	if instanceDigest != nil {
+		dig = *instanceDigest
+		mt = ""
+	}
+	r, _, err := s.openBlob(dig)
+	if err != nil {
+		return nil, "", err
+	}
+	m, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if mt == "" {
+		mt = manifest.GuessMIMEType(m)
+	}
+	return m, mt, nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *ociArchiveImageSource) HasThreadSafeGetBlob() bool {
+	return true
+}
+
+// GetBlob returns a stream for the specified blob, and the blob's size.
+func (s *ociArchiveImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	r, size, err := s.openBlob(info.Digest)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ioutil.NopCloser(r), size, nil
+}
+
+// GetSignatures returns the image's signatures.  OCI archives do not store signatures, so this is always empty.
+func (s *ociArchiveImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	return [][]byte{}, nil
+}
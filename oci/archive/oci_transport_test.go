@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "oci-archive", Transport.Name())
+}
+
+func TestTransportParseReference(t *testing.T) {
+	testParseReference(t, Transport.ParseReference)
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	for _, scope := range []string{
+		"/etc",
+		"/etc/archive.tar",
+		"/this/does/not/exist.tar:notlatest",
+		"/:strangecornercase",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.NoError(t, err, scope)
+	}
+
+	for _, scope := range []string{
+		"relative/path",
+		"/",
+		"/double//slashes",
+		"/has/./dot",
+		"/trailing/slash/",
+		"/etc:invalid'tag!value@",
+		"/path:with/colons",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.Error(t, err, scope)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	testParseReference(t, ParseReference)
+}
+
+// testParseReference is a test shared for Transport.ParseReference and ParseReference.
+func testParseReference(t *testing.T, fn func(string) (types.ImageReference, error)) {
+	tmpDir, err := ioutil.TempDir("", "oci-archive-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for _, path := range []string{
+		"/",
+		"/etc",
+		tmpDir,
+		"relativepath",
+		tmpDir + "/thisdoesnotexist.tar",
+	} {
+		for _, tag := range []struct{ suffix, tag string }{
+			{":notlatest", "notlatest"},
+			{"", "latest"},
+		} {
+			input := path + tag.suffix
+			ref, err := fn(input)
+			require.NoError(t, err, input)
+			archiveRef, ok := ref.(ociArchiveReference)
+			require.True(t, ok)
+			assert.Equal(t, path, archiveRef.file, input)
+			assert.Equal(t, tag.tag, archiveRef.tag, input)
+		}
+	}
+
+	_, err = fn(tmpDir + "/with:multiple:colons:and:tag")
+	assert.Error(t, err)
+
+	_, err = fn(tmpDir + ":invalid'tag!value@")
+	assert.Error(t, err)
+}
+
+func TestNewReference(t *testing.T) {
+	const tagValue = "tagValue"
+
+	tmpDir, err := ioutil.TempDir("", "oci-archive-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	ref, err := NewReference(tmpDir+"/archive.tar", tagValue)
+	require.NoError(t, err)
+	archiveRef, ok := ref.(ociArchiveReference)
+	require.True(t, ok)
+	assert.Equal(t, tmpDir+"/archive.tar", archiveRef.file)
+	assert.Equal(t, tagValue, archiveRef.tag)
+
+	_, err = NewReference(tmpDir+"/thisparentdoesnotexist/something.tar", tagValue)
+	assert.Error(t, err)
+
+	_, err = NewReference(tmpDir+"/has:colon.tar", tagValue)
+	assert.Error(t, err)
+
+	_, err = NewReference(tmpDir+"/archive.tar", "invalid'tag!value@")
+	assert.Error(t, err)
+}
+
+// refToTempOCIArchive creates a temporary directory and returns a reference to an archive file inside it.
+// The caller should defer os.RemoveAll(tmpDir).
+func refToTempOCIArchive(t *testing.T) (ref types.ImageReference, tmpDir string) {
+	tmpDir, err := ioutil.TempDir("", "oci-archive-transport-test")
+	require.NoError(t, err)
+	ref, err = NewReference(tmpDir+"/archive.tar", "tagValue")
+	require.NoError(t, err)
+	return ref, tmpDir
+}
+
+func TestReferenceTransport(t *testing.T) {
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+	assert.Equal(t, Transport, ref.Transport())
+}
+
+func TestReferenceStringWithinTransport(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-archive-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for _, c := range []struct{ input, result string }{
+		{"/archive1.tar:notlatest", "/archive1.tar:notlatest"}, // Explicit tag
+		{"/archive2.tar", "/archive2.tar:latest"},              // Default tag
+	} {
+		ref, err := ParseReference(tmpDir + c.input)
+		require.NoError(t, err, c.input)
+		stringRef := ref.StringWithinTransport()
+		assert.Equal(t, tmpDir+c.result, stringRef, c.input)
+		// Do one more round to verify that the output can be parsed, to an equal value.
+		ref2, err := Transport.ParseReference(stringRef)
+		require.NoError(t, err, c.input)
+		stringRef2 := ref2.StringWithinTransport()
+		assert.Equal(t, stringRef, stringRef2, c.input)
+	}
+}
+
+func TestReferenceDockerReference(t *testing.T) {
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+	assert.Nil(t, ref.DockerReference())
+}
+
+func TestReferencePolicyConfigurationIdentity(t *testing.T) {
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+
+	assert.Equal(t, tmpDir+"/archive.tar:tagValue", ref.PolicyConfigurationIdentity())
+	// A non-canonical path.  Test just one, the various other cases are
+	// tested in explicitfilepath.ResolvePathToFullyExplicit.
+	ref, err := NewReference(tmpDir+"/./archive.tar", "tag2")
+	require.NoError(t, err)
+	assert.Equal(t, tmpDir+"/archive.tar:tag2", ref.PolicyConfigurationIdentity())
+}
+
+func TestReferencePolicyConfigurationNamespaces(t *testing.T) {
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+	// We don't really know enough to make a full equality test here.
+	ns := ref.PolicyConfigurationNamespaces()
+	require.NotNil(t, ns)
+	assert.True(t, len(ns) >= 3)
+	assert.Equal(t, tmpDir+"/archive.tar", ns[0])
+	assert.Equal(t, tmpDir, ns[1])
+	assert.Equal(t, filepath.Dir(tmpDir), ns[2])
+}
+
+func TestReferenceNewImage(t *testing.T) {
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+	_, err := ref.NewImage(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestReferenceNewImageSource(t *testing.T) {
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+	_, err := ref.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestReferenceNewImageDestination(t *testing.T) {
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	assert.NoError(t, err)
+	defer dest.Close()
+}
+
+func TestReferenceDeleteImage(t *testing.T) {
+	ref, tmpDir := refToTempOCIArchive(t)
+	defer os.RemoveAll(tmpDir)
+	err := ref.DeleteImage(context.Background(), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// archiveWriter writes a tar archive to a file incrementally, one entry at a time, so that blobs can be added
+// to the archive as they are received instead of being staged elsewhere first.
+// An entry which can not be completely written is removed from the file, so that a failure to write one blob
+// does not corrupt the rest of the archive.
+type archiveWriter struct {
+	file   *os.File
+	offset int64               // The end of the last completely written entry
+	dirs   map[string]struct{} // Directories which have already been added to the archive
+	err    error               // If not nil, the file is in an unknown state and can not be written to any more
+}
+
+// newArchiveWriter returns an archiveWriter writing to the empty file.
+func newArchiveWriter(file *os.File) *archiveWriter {
+	return &archiveWriter{file: file, dirs: map[string]struct{}{}}
+}
+
+// addFile adds a regular file with name and size, with contents read from r, to the archive.
+// If validate is not nil, it is called after all of r has been written, and the file is only kept in the archive if it succeeds.
+func (w *archiveWriter) addFile(name string, size int64, r io.Reader, validate func() error) error {
+	if err := w.addParentDirectories(name); err != nil {
+		return err
+	}
+	return w.writeEntry(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	}, r, validate)
+}
+
+// addParentDirectories adds entries for the parent directories of name which are not yet in the archive.
+func (w *archiveWriter) addParentDirectories(name string) error {
+	dir := path.Dir(name)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	if _, ok := w.dirs[dir]; ok {
+		return nil
+	}
+	if err := w.addParentDirectories(dir); err != nil {
+		return err
+	}
+	if err := w.writeEntry(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dir + "/",
+		Mode:     0755,
+		ModTime:  time.Now(),
+	}, nil, nil); err != nil {
+		return err
+	}
+	w.dirs[dir] = struct{}{}
+	return nil
+}
+
+// addDirectory adds the contents of dir (but not dir itself) to the archive.
+// Only directories and regular files are supported.
+func (w *archiveWriter) addDirectory(dir string) error {
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		name = filepath.ToSlash(name)
+
+		switch {
+		case info.Mode().IsDir():
+			return w.addParentDirectories(name + "/")
+		case info.Mode().IsRegular():
+			f, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return w.addFile(name, info.Size(), f, nil)
+		default:
+			return fmt.Errorf("Unsupported file type of %s", filePath)
+		}
+	})
+}
+
+// writeEntry writes hdr, and contents read from r if not nil, to the archive, and calls validate if not nil.
+// If any of that fails, the entry is removed from the archive.
+func (w *archiveWriter) writeEntry(hdr *tar.Header, r io.Reader, validate func() error) (retErr error) {
+	if w.err != nil {
+		return w.err
+	}
+	defer func() {
+		if retErr != nil {
+			if err := w.file.Truncate(w.offset); err != nil {
+				w.err = fmt.Errorf("Error removing incomplete archive entry %s: %v", hdr.Name, err)
+			} else if _, err := w.file.Seek(w.offset, io.SeekStart); err != nil {
+				w.err = fmt.Errorf("Error removing incomplete archive entry %s: %v", hdr.Name, err)
+			}
+		}
+	}()
+
+	// Every entry uses a new tar.Writer; tar.Writer has no state other than the current entry, and the end-of-archive marker
+	// is only written by finish.
+	tw := tar.NewWriter(w.file)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r != nil {
+		size, err := io.Copy(tw, r)
+		if err != nil {
+			return err
+		}
+		if size != hdr.Size {
+			return fmt.Errorf("Size mismatch when writing %s, expected %d, got %d", hdr.Name, hdr.Size, size)
+		}
+	}
+	if validate != nil {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	offset, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	w.offset = offset
+	return nil
+}
+
+// finish writes the end-of-archive marker; no entries can be added afterwards.
+func (w *archiveWriter) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = fmt.Errorf("Internal error: archive already finished")
+	return tar.NewWriter(w.file).Close()
+}
@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/pkg/tarindex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveWriter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-archive-tar-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	file, err := os.Create(filepath.Join(tmpDir, "archive.tar"))
+	require.NoError(t, err)
+	defer file.Close()
+	w := newArchiveWriter(file)
+
+	err = w.addFile("blobs/sha256/good", 4, bytes.NewReader([]byte("good")), nil)
+	require.NoError(t, err)
+	// Entries which fail validation, or have an unexpected size, are removed.
+	err = w.addFile("blobs/sha256/invalid", 7, bytes.NewReader([]byte("invalid")), func() error {
+		return errors.New("validation failed")
+	})
+	assert.Error(t, err)
+	err = w.addFile("blobs/sha256/short", 100, bytes.NewReader([]byte("short")), nil)
+	assert.Error(t, err)
+	err = w.addFile("blobs/other/file", 5, bytes.NewReader([]byte("other")), nil)
+	require.NoError(t, err)
+
+	dir := filepath.Join(tmpDir, "dir")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.json"), []byte("{}"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "blobs", "sha256", "manifest"), []byte("manifest"), 0644))
+	err = w.addDirectory(dir)
+	require.NoError(t, err)
+	err = w.finish()
+	require.NoError(t, err)
+	err = w.addFile("late", 0, bytes.NewReader(nil), nil)
+	assert.Error(t, err)
+
+	// Each directory is added only once.
+	_, err = file.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	names := []string{}
+	tr := tar.NewReader(file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"blobs/", "blobs/sha256/", "blobs/sha256/good", "blobs/other/", "blobs/other/file", "blobs/sha256/manifest", "index.json"}, names)
+
+	index, err := tarindex.New(file)
+	require.NoError(t, err)
+	for name, contents := range map[string]string{
+		"blobs/sha256/good":     "good",
+		"blobs/other/file":      "other",
+		"index.json":            "{}",
+		"blobs/sha256/manifest": "manifest",
+	} {
+		r, _, err := index.Open(name)
+		require.NoError(t, err, name)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err, name)
+		assert.Equal(t, contents, string(data), name)
+	}
+}
@@ -1,8 +1,8 @@
 package layout
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,17 +12,25 @@ import (
 	"path/filepath"
 
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/pkg/putblobdigest"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type ociImageDestination struct {
-	ref ociReference
+	ref           ociReference
+	sharedBlobDir string // If not "", a directory of blobs (organized like the layout's blobs directory) to link blobs from
 }
 
 // newImageDestination returns an ImageDestination for writing to an existing directory.
-func newImageDestination(ref ociReference) types.ImageDestination {
-	return &ociImageDestination{ref: ref}
+func newImageDestination(sys *types.SystemContext, ref ociReference) types.ImageDestination {
+	d := &ociImageDestination{ref: ref}
+	if sys != nil {
+		d.sharedBlobDir = sys.OCISharedBlobDirPath
+	}
+	return d
 }
 
 // Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
@@ -44,22 +52,32 @@ func (d *ociImageDestination) SupportedManifestMIMETypes() []string {
 
 // SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
 // Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
-func (d *ociImageDestination) SupportsSignatures() error {
+func (d *ociImageDestination) SupportsSignatures(ctx context.Context) error {
 	return fmt.Errorf("Pushing signatures for OCI images is not supported")
 }
 
-// ShouldCompressLayers returns true iff it is desirable to compress layer blobs written to this destination.
-func (d *ociImageDestination) ShouldCompressLayers() bool {
-	return false
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d *ociImageDestination) DesiredLayerCompression() types.LayerCompression {
+	return types.PreserveOriginal
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *ociImageDestination) HasThreadSafePutBlob() bool {
+	return true
 }
 
 // PutBlob writes contents of stream and returns data representing the result (with all data filled in).
 // inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
 // inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
 // WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
 // to any other readers for download using the supplied digest.
 // If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
-func (d *ociImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobInfo) (types.BlobInfo, error) {
+func (d *ociImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
 	if err := ensureDirectoryExists(d.ref.dir); err != nil {
 		return types.BlobInfo{}, err
 	}
@@ -75,14 +93,12 @@ func (d *ociImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobInfo
 		}
 	}()
 
-	h := sha256.New()
-	tee := io.TeeReader(stream, h)
-
-	size, err := io.Copy(blobFile, tee)
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	size, err := io.Copy(blobFile, stream)
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
-	computedDigest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	computedDigest := digester.Digest()
 	if inputInfo.Size != -1 && size != inputInfo.Size {
 		return types.BlobInfo{}, fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", computedDigest, inputInfo.Size, size)
 	}
@@ -107,6 +123,100 @@ func (d *ociImageDestination) PutBlob(stream io.Reader, inputInfo types.BlobInfo
 	return types.BlobInfo{Digest: computedDigest, Size: size}, nil
 }
 
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *ociImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	if info.Digest == "" {
+		return false, types.BlobInfo{}, errors.New("Can not check for a blob with unknown digest")
+	}
+	scope := types.BICTransportScope{Opaque: d.ref.resolvedDir}
+
+	size, err := d.blobSize(info.Digest)
+	if err != nil {
+		return false, types.BlobInfo{}, err
+	}
+	if size != -1 {
+		cache.RecordKnownLocation(d.ref.Transport(), scope, info.Digest, types.BICLocationReference{})
+		return true, types.BlobInfo{Digest: info.Digest, Size: size}, nil
+	}
+
+	// Then try the equivalent blobs, e.g. the uncompressed version of the blob, or a blob with the same uncompressed contents
+	// which is known to have been present in this layout.
+	if canSubstitute {
+		candidates := []digest.Digest{}
+		if uncompressed := cache.UncompressedDigest(info.Digest); uncompressed != "" && uncompressed != info.Digest {
+			candidates = append(candidates, uncompressed)
+		}
+		for _, candidate := range cache.CandidateLocations(d.ref.Transport(), scope, info.Digest, true) {
+			if candidate.Digest != info.Digest {
+				candidates = append(candidates, candidate.Digest)
+			}
+		}
+		for _, candidate := range candidates {
+			if candidate.Validate() != nil {
+				continue
+			}
+			size, err := d.blobSize(candidate)
+			if err != nil {
+				return false, types.BlobInfo{}, err
+			}
+			if size == -1 {
+				continue
+			}
+			algorithm, err := d.blobCompression(candidate)
+			if err != nil {
+				return false, types.BlobInfo{}, err
+			}
+			cache.RecordKnownLocation(d.ref.Transport(), scope, candidate, types.BICLocationReference{})
+			// The compression describes the stored blob, so that the manifest can be updated accordingly.
+			substitute := types.BlobInfo{Digest: candidate, Size: size, CompressionOperation: types.Decompress}
+			if algorithm.IsCompressed() {
+				substitute.CompressionOperation = types.Compress
+				substitute.CompressionAlgorithm = algorithm.Name()
+			}
+			return true, substitute, nil
+		}
+	}
+	return false, types.BlobInfo{}, nil
+}
+
+// blobSize returns the size of the blob with digest in the layout, linking it from d.sharedBlobDir if necessary,
+// or -1 if the blob is not available.
+func (d *ociImageDestination) blobSize(digest digest.Digest) (int64, error) {
+	blobPath, err := d.ref.blobPath(digest)
+	if err != nil {
+		return -1, err
+	}
+	fi, err := os.Stat(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return d.linkSharedBlob(digest, blobPath)
+		}
+		return -1, err
+	}
+	return fi.Size(), nil
+}
+
+// blobCompression returns the compression algorithm of the blob with digest, which must exist in the layout.
+func (d *ociImageDestination) blobCompression(digest digest.Digest) (compression.Algorithm, error) {
+	blobPath, err := d.ref.blobPath(digest)
+	if err != nil {
+		return compression.Algorithm{}, err
+	}
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return compression.Algorithm{}, err
+	}
+	defer f.Close()
+	algorithm, _, _, err := compression.DetectCompressionFormat(f)
+	return algorithm, err
+}
+
 func createManifest(m []byte) ([]byte, string, error) {
 	om := imgspecv1.Manifest{}
 	mt := manifest.GuessMIMEType(m)
@@ -134,50 +244,119 @@ func createManifest(m []byte) ([]byte, string, error) {
 	case manifest.DockerV2ListMediaType:
 		return nil, "", errors.New("can't create an OCI manifest from Docker V2 schema 2 manifest list")
 	case imgspecv1.MediaTypeImageManifestList:
-		return nil, "", errors.New("can't create an OCI manifest from OCI manifest list")
+		return m, mt, nil
 	case imgspecv1.MediaTypeImageManifest:
 		return m, mt, nil
 	}
 	return nil, "", fmt.Errorf("unrecognized manifest media type %q", mt)
 }
 
-func (d *ociImageDestination) PutManifest(m []byte) error {
+// PutManifest writes manifest to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for (when the primary manifest is a manifest list);
+// the instance is only stored as a blob, to be referenced by the list, and index.json is updated only for the primary manifest.
+func (d *ociImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
 	// TODO(mitr, runcom): this breaks signatures entirely since at this point we're creating a new manifest
 	// and signatures don't apply anymore. Will fix.
 	ociMan, mt, err := createManifest(m)
 	if err != nil {
 		return err
 	}
-	digest, err := manifest.Digest(ociMan)
+	if instanceDigest != nil {
+		// The manifest list refers to the instance by digest, so it can't be converted.
+		matches, err := manifest.MatchesDigest(ociMan, *instanceDigest)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			return fmt.Errorf("Manifest of instance %s would be modified by conversion to OCI, which would break the manifest list", *instanceDigest)
+		}
+	}
+	manifestDigest, err := manifest.Digest(ociMan)
+	if err != nil {
+		return err
+	}
+	// Reuse PutBlob so that the manifest, like any other blob, is only visible once completely written.
+	info, err := d.PutBlob(ctx, bytes.NewReader(ociMan), types.BlobInfo{Digest: manifestDigest, Size: int64(len(ociMan))}, false)
 	if err != nil {
 		return err
 	}
-	desc := imgspecv1.Descriptor{}
-	desc.Digest = digest
-	// TODO(runcom): beaware and add support for OCI manifest list
-	desc.MediaType = mt
-	desc.Size = int64(len(ociMan))
-	data, err := json.Marshal(desc)
+	if info.Digest != manifestDigest {
+		return fmt.Errorf("Internal error: manifest stored with digest %s instead of %s", info.Digest, manifestDigest)
+	}
+
+	if instanceDigest != nil {
+		return nil
+	}
+
+	if err := writeFileAtomic(d.ref.ociLayoutPath(), []byte(`{"imageLayoutVersion": "1.0.0"}`)); err != nil {
+		return err
+	}
+	return d.updateIndex(indexDescriptor{
+		MediaType:   mt,
+		Digest:      manifestDigest,
+		Size:        int64(len(ociMan)),
+		Annotations: map[string]string{annotationRefName: d.ref.tag},
+	})
+}
+
+// updateIndex records desc in index.json, replacing any manifest previously recorded under the same tag.
+// Tags recorded in refs/ by older layouts are preserved by converting them into index.json entries.
+func (d *ociImageDestination) updateIndex(desc indexDescriptor) error {
+	index, err := d.ref.loadIndex()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		index = &ociIndex{SchemaVersion: 2}
+	}
+
+	manifests := []indexDescriptor{}
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationRefName] != d.ref.tag {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, desc)
+
+	data, err := json.Marshal(index)
 	if err != nil {
 		return err
 	}
+	return writeFileAtomic(d.ref.indexPath(), data)
+}
 
-	blobPath, err := d.ref.blobPath(digest)
+// writeFileAtomic writes data to path, so that readers see either the previous contents of path, or the complete data.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := ensureDirectoryExists(dir); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "oci-put-file")
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(blobPath, ociMan, 0644); err != nil {
+	succeeded := false
+	defer func() {
+		f.Close()
+		if !succeeded {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
 		return err
 	}
-	// TODO(runcom): ugly here?
-	if err := ioutil.WriteFile(d.ref.ociLayoutPath(), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644); err != nil {
+	if err := f.Sync(); err != nil {
 		return err
 	}
-	descriptorPath := d.ref.descriptorPath(d.ref.tag)
-	if err := ensureParentDirectoryExists(descriptorPath); err != nil {
+	if err := f.Chmod(0644); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(descriptorPath, data, 0644)
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	succeeded = true
+	return nil
 }
 
 func ensureDirectoryExists(path string) error {
@@ -194,7 +373,7 @@ func ensureParentDirectoryExists(path string) error {
 	return ensureDirectoryExists(filepath.Dir(path))
 }
 
-func (d *ociImageDestination) PutSignatures(signatures [][]byte) error {
+func (d *ociImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
 	if len(signatures) != 0 {
 		return fmt.Errorf("Pushing signatures for OCI images is not supported")
 	}
@@ -205,6 +384,6 @@ func (d *ociImageDestination) PutSignatures(signatures [][]byte) error {
 // WARNING: This does not have any transactional semantics:
 // - Uploaded data MAY be visible to others before Commit() is called
 // - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
-func (d *ociImageDestination) Commit() error {
+func (d *ociImageDestination) Commit(ctx context.Context) error {
 	return nil
 }
@@ -1,11 +1,19 @@
 package layout
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/containers/image/pkg/blobinfocache"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -46,13 +54,13 @@ func TestPutBlobDigestFailure(t *testing.T) {
 		return 0, fmt.Errorf(digestErrorString)
 	})
 
-	dest, err := ref.NewImageDestination(nil)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 	defer dest.Close()
-	_, err = dest.PutBlob(reader, types.BlobInfo{Digest: blobDigest, Size: -1})
+	_, err = dest.PutBlob(context.Background(), reader, types.BlobInfo{Digest: blobDigest, Size: -1}, false)
 	assert.Error(t, err)
 	assert.Contains(t, digestErrorString, err.Error())
-	err = dest.Commit()
+	err = dest.Commit(context.Background())
 	assert.NoError(t, err)
 
 	_, err = os.Lstat(blobPath)
@@ -66,12 +74,12 @@ func TestPutManifestUnrecognizedMediaType(t *testing.T) {
 	dirRef, ok := ref.(ociReference)
 	require.True(t, ok)
 
-	ociDest, err := dirRef.NewImageDestination(nil)
+	ociDest, err := dirRef.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 
 	m := `{"name":"puerapuliae/busybox","tag":"latest","architecture":"amd64","fsLayers":[{"blobSum":"sha256:04f18047a28f8dea4a3b3872a2ad345cbb6f0eae28d99a60d3df844d6eaae571"},{"blobSum":"sha256:04f18047a28f8dea4a3b3872a2ad345cbb6f0eae28d99a60d3df844d6eaae571"}],"history":[{"v1Compatibility":"{\"id\":\"b46e47334e74d687019107dbec32559dd598db58fe90d2a0c5473bda8b59829d\",\"comment\":\"Imported from -\",\"created\":\"2015-07-03T07:56:02.57018886Z\",\"container_config\":{\"Hostname\":\"\",\"Domainname\":\"\",\"User\":\"\",\"Memory\":0,\"MemorySwap\":0,\"CpuShares\":0,\"Cpuset\":\"\",\"AttachStdin\":false,\"AttachStdout\":false,\"AttachStderr\":false,\"PortSpecs\":null,\"ExposedPorts\":null,\"Tty\":false,\"OpenStdin\":false,\"StdinOnce\":false,\"Env\":null,\"Cmd\":null,\"Image\":\"\",\"Volumes\":null,\"WorkingDir\":\"\",\"Entrypoint\":null,\"NetworkDisabled\":false,\"MacAddress\":\"\",\"OnBuild\":null,\"Labels\":null},\"docker_version\":\"1.6.2\",\"config\":{\"Hostname\":\"\",\"Domainname\":\"\",\"User\":\"\",\"Memory\":0,\"MemorySwap\":0,\"CpuShares\":0,\"Cpuset\":\"\",\"AttachStdin\":false,\"AttachStdout\":false,\"AttachStderr\":false,\"PortSpecs\":null,\"ExposedPorts\":null,\"Tty\":false,\"OpenStdin\":false,\"StdinOnce\":false,\"Env\":null,\"Cmd\":null,\"Image\":\"\",\"Volumes\":null,\"WorkingDir\":\"\",\"Entrypoint\":null,\"NetworkDisabled\":false,\"MacAddress\":\"\",\"OnBuild\":null,\"Labels\":null},\"architecture\":\"amd64\",\"os\":\"linux\",\"Size\":9356886}\n"},{"v1Compatibility":"{\"id\":\"b46e47334e74d687019107dbec32559dd598db58fe90d2a0c5473bda8b59829d\",\"comment\":\"Imported from -\",\"created\":\"2015-07-03T07:56:02.57018886Z\",\"container_config\":{\"Hostname\":\"\",\"Domainname\":\"\",\"User\":\"\",\"Memory\":0,\"MemorySwap\":0,\"CpuShares\":0,\"Cpuset\":\"\",\"AttachStdin\":false,\"AttachStdout\":false,\"AttachStderr\":false,\"PortSpecs\":null,\"ExposedPorts\":null,\"Tty\":false,\"OpenStdin\":false,\"StdinOnce\":false,\"Env\":null,\"Cmd\":null,\"Image\":\"\",\"Volumes\":null,\"WorkingDir\":\"\",\"Entrypoint\":null,\"NetworkDisabled\":false,\"MacAddress\":\"\",\"OnBuild\":null,\"Labels\":null},\"docker_version\":\"1.6.2\",\"config\":{\"Hostname\":\"\",\"Domainname\":\"\",\"User\":\"\",\"Memory\":0,\"MemorySwap\":0,\"CpuShares\":0,\"Cpuset\":\"\",\"AttachStdin\":false,\"AttachStdout\":false,\"AttachStderr\":false,\"PortSpecs\":null,\"ExposedPorts\":null,\"Tty\":false,\"OpenStdin\":false,\"StdinOnce\":false,\"Env\":null,\"Cmd\":null,\"Image\":\"\",\"Volumes\":null,\"WorkingDir\":\"\",\"Entrypoint\":null,\"NetworkDisabled\":false,\"MacAddress\":\"\",\"OnBuild\":null,\"Labels\":null},\"architecture\":\"amd64\",\"os\":\"linux\",\"Size\":9356886}\n"}],"signatures":[{"header":{"jwk":{"crv":"P-256","kid":"SVJ4:Q6G3:SXTN:H6LT:7PXH:DHUZ:SGTB:5TMV:YPIV:UPHY:MRHO:PN6V","kty":"EC","x":"qrSsA2UAKEFlDhLk12zoWpnHgYcTNfEOWGZU46pzhfk","y":"RtD_vGFtagPlheiunLvZL02LOssnu7DqShuBwc6Ml44"},"alg":"ES256"},"signature":"YzfU_rKQLWqG74uilltTiV3O92lfEjaG5wJkVt_dCtjH_C5AeghfQttnbtceJOyiaU7xP2yEnjdultutsxkQKQ","protected":"eyJmb3JtYXRMZW5ndGgiOjI4NDgsImZvcm1hdFRhaWwiOiJDbjAiLCJ0aW1lIjoiMjAxNi0wOS0xMFQwODoyMDowOFoifQ"}]}`
 
-	err = ociDest.PutManifest([]byte(m))
+	err = ociDest.PutManifest(context.Background(), []byte(m), nil)
 	require.Error(t, err)
 	assert.Equal(t, `unrecognized manifest media type ""`, err.Error())
 }
@@ -83,12 +91,232 @@ func TestPutManifestDockerV2Schema1Signed(t *testing.T) {
 	dirRef, ok := ref.(ociReference)
 	require.True(t, ok)
 
-	ociDest, err := dirRef.NewImageDestination(nil)
+	ociDest, err := dirRef.NewImageDestination(context.Background(), nil)
 	require.NoError(t, err)
 
 	m := `{"name":"puerapuliae/busybox","tag":"latest","architecture":"amd64","fsLayers":[{"blobSum":"sha256:04f18047a28f8dea4a3b3872a2ad345cbb6f0eae28d99a60d3df844d6eaae571"},{"blobSum":"sha256:04f18047a28f8dea4a3b3872a2ad345cbb6f0eae28d99a60d3df844d6eaae571"}],"history":[{"v1Compatibility":"{\"id\":\"b46e47334e74d687019107dbec32559dd598db58fe90d2a0c5473bda8b59829d\",\"comment\":\"Imported from -\",\"created\":\"2015-07-03T07:56:02.57018886Z\",\"container_config\":{\"Hostname\":\"\",\"Domainname\":\"\",\"User\":\"\",\"Memory\":0,\"MemorySwap\":0,\"CpuShares\":0,\"Cpuset\":\"\",\"AttachStdin\":false,\"AttachStdout\":false,\"AttachStderr\":false,\"PortSpecs\":null,\"ExposedPorts\":null,\"Tty\":false,\"OpenStdin\":false,\"StdinOnce\":false,\"Env\":null,\"Cmd\":null,\"Image\":\"\",\"Volumes\":null,\"WorkingDir\":\"\",\"Entrypoint\":null,\"NetworkDisabled\":false,\"MacAddress\":\"\",\"OnBuild\":null,\"Labels\":null},\"docker_version\":\"1.6.2\",\"config\":{\"Hostname\":\"\",\"Domainname\":\"\",\"User\":\"\",\"Memory\":0,\"MemorySwap\":0,\"CpuShares\":0,\"Cpuset\":\"\",\"AttachStdin\":false,\"AttachStdout\":false,\"AttachStderr\":false,\"PortSpecs\":null,\"ExposedPorts\":null,\"Tty\":false,\"OpenStdin\":false,\"StdinOnce\":false,\"Env\":null,\"Cmd\":null,\"Image\":\"\",\"Volumes\":null,\"WorkingDir\":\"\",\"Entrypoint\":null,\"NetworkDisabled\":false,\"MacAddress\":\"\",\"OnBuild\":null,\"Labels\":null},\"architecture\":\"amd64\",\"os\":\"linux\",\"Size\":9356886}\n"},{"v1Compatibility":"{\"id\":\"b46e47334e74d687019107dbec32559dd598db58fe90d2a0c5473bda8b59829d\",\"comment\":\"Imported from -\",\"created\":\"2015-07-03T07:56:02.57018886Z\",\"container_config\":{\"Hostname\":\"\",\"Domainname\":\"\",\"User\":\"\",\"Memory\":0,\"MemorySwap\":0,\"CpuShares\":0,\"Cpuset\":\"\",\"AttachStdin\":false,\"AttachStdout\":false,\"AttachStderr\":false,\"PortSpecs\":null,\"ExposedPorts\":null,\"Tty\":false,\"OpenStdin\":false,\"StdinOnce\":false,\"Env\":null,\"Cmd\":null,\"Image\":\"\",\"Volumes\":null,\"WorkingDir\":\"\",\"Entrypoint\":null,\"NetworkDisabled\":false,\"MacAddress\":\"\",\"OnBuild\":null,\"Labels\":null},\"docker_version\":\"1.6.2\",\"config\":{\"Hostname\":\"\",\"Domainname\":\"\",\"User\":\"\",\"Memory\":0,\"MemorySwap\":0,\"CpuShares\":0,\"Cpuset\":\"\",\"AttachStdin\":false,\"AttachStdout\":false,\"AttachStderr\":false,\"PortSpecs\":null,\"ExposedPorts\":null,\"Tty\":false,\"OpenStdin\":false,\"StdinOnce\":false,\"Env\":null,\"Cmd\":null,\"Image\":\"\",\"Volumes\":null,\"WorkingDir\":\"\",\"Entrypoint\":null,\"NetworkDisabled\":false,\"MacAddress\":\"\",\"OnBuild\":null,\"Labels\":null},\"architecture\":\"amd64\",\"os\":\"linux\",\"Size\":9356886}\n"}],"schemaVersion":1,"signatures":[{"header":{"jwk":{"crv":"P-256","kid":"SVJ4:Q6G3:SXTN:H6LT:7PXH:DHUZ:SGTB:5TMV:YPIV:UPHY:MRHO:PN6V","kty":"EC","x":"qrSsA2UAKEFlDhLk12zoWpnHgYcTNfEOWGZU46pzhfk","y":"RtD_vGFtagPlheiunLvZL02LOssnu7DqShuBwc6Ml44"},"alg":"ES256"},"signature":"YzfU_rKQLWqG74uilltTiV3O92lfEjaG5wJkVt_dCtjH_C5AeghfQttnbtceJOyiaU7xP2yEnjdultutsxkQKQ","protected":"eyJmb3JtYXRMZW5ndGgiOjI4NDgsImZvcm1hdFRhaWwiOiJDbjAiLCJ0aW1lIjoiMjAxNi0wOS0xMFQwODoyMDowOFoifQ"}]}`
 
-	err = ociDest.PutManifest([]byte(m))
+	err = ociDest.PutManifest(context.Background(), []byte(m), nil)
 	require.Error(t, err)
 	assert.Equal(t, `can't create an OCI manifest from Docker V2 schema 1 manifest`, err.Error())
 }
+
+func TestPutManifestUpdatesIndex(t *testing.T) {
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+
+	putManifest := func(tag string, layerSize int) []byte {
+		ref, err := NewReference(tmpDir, tag)
+		require.NoError(t, err)
+		dest, err := ref.NewImageDestination(context.Background(), nil)
+		require.NoError(t, err)
+		defer dest.Close()
+		m := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":1,"digest":"sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","size":%d,"digest":"sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"}]}`, layerSize))
+		err = dest.PutManifest(context.Background(), m, nil)
+		require.NoError(t, err)
+		err = dest.Commit(context.Background())
+		require.NoError(t, err)
+		return m
+	}
+	getManifest := func(tag string) []byte {
+		ref, err := NewReference(tmpDir, tag)
+		require.NoError(t, err)
+		src, err := ref.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		defer src.Close()
+		m, mt, err := src.GetManifest(context.Background(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "application/vnd.oci.image.manifest.v1+json", mt)
+		return m
+	}
+
+	m1 := putManifest("tag1", 1)
+	m2 := putManifest("tag2", 2)
+	assert.Equal(t, m1, getManifest("tag1"))
+	assert.Equal(t, m2, getManifest("tag2"))
+
+	// Overwriting a tag replaces only that index entry.
+	m3 := putManifest("tag1", 3)
+	assert.Equal(t, m3, getManifest("tag1"))
+	assert.Equal(t, m2, getManifest("tag2"))
+	index, err := ref.(ociReference).readIndex()
+	require.NoError(t, err)
+	assert.Len(t, index.Manifests, 2)
+
+	// No temporary files are left behind.
+	_, err = os.Lstat(tmpDir + "/oci-layout")
+	assert.NoError(t, err)
+	f, err := os.Open(tmpDir)
+	require.NoError(t, err)
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	require.NoError(t, err)
+	assert.Len(t, names, 3) // blobs, index.json, oci-layout
+
+	// Tags not present in the index are reported as errors.
+	missingRef, err := NewReference(tmpDir, "missing")
+	require.NoError(t, err)
+	src, err := missingRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	_, _, err = src.GetManifest(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+// TestPutManifestPreservesExistingEntries verifies that adding a tag does not drop tags recorded in refs/ by older layouts,
+// nor data in index.json which this package does not use.
+func TestPutManifestPreservesExistingEntries(t *testing.T) {
+	const legacyDigest = digest.Digest("sha256:a2b2aef9b151ae76f026978cd8a8fb9c5e984a8c0578204d62cb0f3e609265d1")
+	m := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":1,"digest":"sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"},"layers":[]}`)
+
+	putManifest := func(dir, tag string) {
+		ref, err := NewReference(dir, tag)
+		require.NoError(t, err)
+		dest, err := ref.NewImageDestination(context.Background(), nil)
+		require.NoError(t, err)
+		defer dest.Close()
+		err = dest.PutManifest(context.Background(), m, nil)
+		require.NoError(t, err)
+		err = dest.Commit(context.Background())
+		require.NoError(t, err)
+	}
+
+	// A layout using refs/
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+	descriptorPath := ref.(ociReference).descriptorPath("legacy")
+	err := ensureParentDirectoryExists(descriptorPath)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(descriptorPath, []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"`+legacyDigest+`","size":20}`), 0644)
+	require.NoError(t, err)
+
+	putManifest(tmpDir, "new")
+	tags, err := ListTags(tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"legacy", "new"}, tags)
+	legacyRef, err := NewReference(tmpDir, "legacy")
+	require.NoError(t, err)
+	desc, err := legacyRef.(ociReference).getManifestDescriptor()
+	require.NoError(t, err)
+	assert.Equal(t, legacyDigest, desc.Digest)
+
+	// An index.json with fields not used by this package
+	tmpDir2, err := ioutil.TempDir("", "oci-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir2)
+	err = ioutil.WriteFile(filepath.Join(tmpDir2, "index.json"), []byte(`{"schemaVersion":2,"manifests":[`+
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"`+legacyDigest+`","size":20,"platform":{"architecture":"arm64","os":"linux"},"urls":["https://example.com/manifest"]}`+
+		`],"annotations":{"com.example.key":"value"}}`), 0644)
+	require.NoError(t, err)
+	putManifest(tmpDir2, "new")
+	index, err := ociReference{dir: tmpDir2}.readIndex()
+	require.NoError(t, err)
+	require.Len(t, index.Manifests, 2)
+	assert.Equal(t, map[string]string{"com.example.key": "value"}, index.Annotations)
+	assert.Equal(t, legacyDigest, index.Manifests[0].Digest)
+	assert.JSONEq(t, `{"architecture":"arm64","os":"linux"}`, string(index.Manifests[0].Platform))
+	assert.Equal(t, []string{"https://example.com/manifest"}, index.Manifests[0].URLs)
+	assert.Equal(t, "new", index.Manifests[1].Annotations[annotationRefName])
+	tags, err = ListTags(tmpDir2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, tags) // The untagged manifest is not listed.
+}
+
+func TestPutManifestList(t *testing.T) {
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+
+	instance := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":1,"digest":"sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"},"layers":[]}`)
+	instanceDigest := digest.FromBytes(instance)
+	list := []byte(`{"schemaVersion":2,"mediaType":"` + imgspecv1.MediaTypeImageManifestList + `","manifests":[` +
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","size":` + fmt.Sprint(len(instance)) + `,"digest":"` + instanceDigest.String() + `","platform":{"architecture":"amd64","os":"linux"}}]}`)
+
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	err = dest.PutManifest(context.Background(), instance, &instanceDigest)
+	require.NoError(t, err)
+	// Instances are not tagged.
+	_, err = os.Lstat(filepath.Join(tmpDir, "index.json"))
+	assert.True(t, os.IsNotExist(err))
+	// Instances which would have to be converted are rejected.
+	schema2Instance := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":1,"digest":"sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"},"layers":[]}`)
+	schema2Digest := digest.FromBytes(schema2Instance)
+	err = dest.PutManifest(context.Background(), schema2Instance, &schema2Digest)
+	assert.Error(t, err)
+	err = dest.PutManifest(context.Background(), list, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	m, mt, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, list, m)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifestList, mt)
+	m, _, err = src.GetManifest(context.Background(), &instanceDigest)
+	require.NoError(t, err)
+	assert.Equal(t, instance, m)
+}
+
+func TestTryReusingBlobSubstitution(t *testing.T) {
+	uncompressed := []byte("uncompressed layer")
+	uncompressedDigest := digest.FromBytes(uncompressed)
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(uncompressed)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	compressed := buf.Bytes()
+	compressedDigest := digest.FromBytes(compressed)
+	otherCompressedDigest := digest.FromString("other compressed version")
+
+	// Blobs available through the shared blob directory can be substituted as well.
+	sharedRef, sharedDir := refToTempOCI(t)
+	defer os.RemoveAll(sharedDir)
+	sharedDest, err := sharedRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer sharedDest.Close()
+	_, err = sharedDest.PutBlob(context.Background(), bytes.NewReader(compressed), types.BlobInfo{Digest: compressedDigest, Size: int64(len(compressed))}, false)
+	require.NoError(t, err)
+
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{OCISharedBlobDirPath: filepath.Join(sharedDir, "blobs")})
+	require.NoError(t, err)
+	defer dest.Close()
+	cache := blobinfocache.NewMemoryCache()
+	cache.RecordDigestUncompressedPair(compressedDigest, uncompressedDigest)
+	cache.RecordDigestUncompressedPair(otherCompressedDigest, uncompressedDigest)
+
+	// The uncompressed version, found using cache.UncompressedDigest.
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(uncompressed), types.BlobInfo{Digest: uncompressedDigest, Size: int64(len(uncompressed))}, false)
+	require.NoError(t, err)
+	reused, _, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: otherCompressedDigest, Size: -1}, cache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+	reused, info, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: otherCompressedDigest, Size: -1}, cache, true)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, types.BlobInfo{Digest: uncompressedDigest, Size: int64(len(uncompressed)), CompressionOperation: types.Decompress}, info)
+	uncompressedPath, err := ociReference{dir: tmpDir}.blobPath(uncompressedDigest)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(uncompressedPath))
+
+	// A different compressed version, known to be present using cache.CandidateLocations.
+	reused, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: compressedDigest, Size: -1}, cache, false)
+	require.NoError(t, err)
+	require.True(t, reused)
+	reused, info, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: otherCompressedDigest, Size: -1}, cache, true)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, types.BlobInfo{Digest: compressedDigest, Size: int64(len(compressed)), CompressionOperation: types.Compress,
+		CompressionAlgorithm: types.GzipCompression}, info)
+
+	// Nothing to substitute.
+	reused, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: digest.FromString("unknown"), Size: -1}, cache, true)
+	require.NoError(t, err)
+	assert.False(t, reused)
+}
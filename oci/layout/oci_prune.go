@@ -0,0 +1,122 @@
+package layout
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Prune removes the blobs of the OCI layout at dir which are not referenced by any of its images, i.e. by the manifests listed
+// in index.json (or in refs/, for layouts which predate index.json), directly or through other manifests; such blobs accumulate
+// e.g. when a tag is moved to a new image.  If dryRun, nothing is removed, and the result only reports what would be removed.
+// Prune must not be used while images are being written to the layout: the blobs of an image are written before it is
+// recorded in index.json, so they would be considered unreferenced.
+func Prune(dir string, dryRun bool) (*types.PruneResult, error) {
+	ref := ociReference{dir: dir}
+	reachable, err := ref.reachableBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &types.PruneResult{Blobs: []digest.Digest{}}
+	blobsDir := filepath.Join(dir, "blobs")
+	algorithms, err := ioutil.ReadDir(blobsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		blobs, err := ioutil.ReadDir(filepath.Join(blobsDir, algorithm.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range blobs {
+			d := digest.NewDigestFromEncoded(digest.Algorithm(algorithm.Name()), blob.Name())
+			if !blob.Mode().IsRegular() || d.Validate() != nil {
+				continue // Not a blob written by this transport; leave it alone.
+			}
+			if _, ok := reachable[d]; ok {
+				continue
+			}
+			res.Blobs = append(res.Blobs, d)
+			res.Size += blob.Size()
+			if !dryRun {
+				if err := os.Remove(filepath.Join(blobsDir, algorithm.Name(), blob.Name())); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	sort.Slice(res.Blobs, func(i, j int) bool { return res.Blobs[i] < res.Blobs[j] })
+	return res, nil
+}
+
+// reachableBlobs returns the digests of all blobs referenced, directly or indirectly, by the manifests listed in the layout,
+// including the manifests themselves.
+func (ref ociReference) reachableBlobs() (map[digest.Digest]struct{}, error) {
+	// Use both index.json and refs/, if present, so that no tagged image is missed.
+	pending := []digest.Digest{}
+	foundIndex := false
+	for _, load := range []func() (*ociIndex, error){ref.readIndex, ref.readLegacyIndex} {
+		index, err := load()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		foundIndex = true
+		for _, desc := range index.Manifests {
+			pending = append(pending, desc.Digest)
+		}
+	}
+	if !foundIndex {
+		return nil, fmt.Errorf("%s is not an OCI layout: it contains neither index.json nor refs/", ref.dir)
+	}
+
+	reachable := map[digest.Digest]struct{}{}
+	visited := map[digest.Digest]struct{}{} // Manifests already processed
+	for len(pending) > 0 {
+		d := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := visited[d]; ok {
+			continue
+		}
+		visited[d] = struct{}{}
+		reachable[d] = struct{}{}
+
+		path, err := ref.blobPath(d)
+		if err != nil {
+			return nil, err
+		}
+		blob, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) { // Nothing to keep; the blobs it would refer to are not reachable through it.
+				logrus.Debugf("Manifest %s referenced in %s does not exist", d, ref.dir)
+				continue
+			}
+			return nil, err
+		}
+		blobs, manifests, err := manifest.References(blob)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing manifest %s: %v", d, err)
+		}
+		for _, b := range blobs {
+			reachable[b] = struct{}{}
+		}
+		pending = append(pending, manifests...)
+	}
+	return reachable, nil
+}
@@ -0,0 +1,144 @@
+package layout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putPruneTestImage writes an image with config and layers to dir, tagged tag, and returns the digest of its manifest.
+func putPruneTestImage(t *testing.T, dir, tag, config string, layers ...string) digest.Digest {
+	ref, err := NewReference(dir, tag)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+
+	put := func(blob string, isConfig bool) map[string]interface{} {
+		info, err := dest.PutBlob(context.Background(), bytes.NewReader([]byte(blob)), types.BlobInfo{Size: int64(len(blob))}, isConfig)
+		require.NoError(t, err)
+		return map[string]interface{}{"digest": info.Digest, "size": info.Size, "mediaType": imgspecv1.MediaTypeImageLayer}
+	}
+	layerDescriptors := []map[string]interface{}{}
+	for _, l := range layers {
+		layerDescriptors = append(layerDescriptors, put(l, false))
+	}
+	m, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     imgspecv1.MediaTypeImageManifest,
+		"config":        put(config, true),
+		"layers":        layerDescriptors,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), m, nil))
+	require.NoError(t, dest.Commit(context.Background()))
+	return digest.FromBytes(m)
+}
+
+func TestPrune(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-prune")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	putPruneTestImage(t, tmpDir, "a", "config-a", "shared", "layer-a")
+	putPruneTestImage(t, tmpDir, "b", "config-b", "shared", "layer-b")
+	oldManifest := putPruneTestImage(t, tmpDir, "c", "config-c", "shared", "layer-c")
+	// Moving the tag leaves the previous image unreferenced.
+	putPruneTestImage(t, tmpDir, "c", "config-c2", "shared", "layer-b")
+	// Files which are not blobs are ignored.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "blobs", "sha256", "unrelated"), []byte("unrelated"), 0644))
+
+	unreferenced := []digest.Digest{oldManifest, digest.FromString("config-c"), digest.FromString("layer-c")}
+	expected := &types.PruneResult{Blobs: unreferenced}
+	manifestBlob, err := ioutil.ReadFile(filepath.Join(tmpDir, "blobs", "sha256", oldManifest.Hex()))
+	require.NoError(t, err)
+	expected.Size = int64(len(manifestBlob) + len("config-c") + len("layer-c"))
+	sort.Slice(expected.Blobs, func(i, j int) bool { return expected.Blobs[i] < expected.Blobs[j] })
+
+	res, err := Prune(tmpDir, true)
+	require.NoError(t, err)
+	assert.Equal(t, expected, res)
+	for _, d := range unreferenced {
+		_, err := os.Stat(filepath.Join(tmpDir, "blobs", "sha256", d.Hex()))
+		assert.NoError(t, err, d.String())
+	}
+
+	res, err = Prune(tmpDir, false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, res)
+	for _, d := range unreferenced {
+		_, err := os.Stat(filepath.Join(tmpDir, "blobs", "sha256", d.Hex()))
+		assert.True(t, os.IsNotExist(err), d.String())
+	}
+	for _, tag := range []string{"a", "b", "c"} {
+		ref, err := NewReference(tmpDir, tag)
+		require.NoError(t, err)
+		img, err := ref.NewImage(context.Background(), nil)
+		require.NoError(t, err, tag)
+		for _, info := range append(img.LayerInfos(), img.ConfigInfo()) {
+			_, err := os.Stat(filepath.Join(tmpDir, "blobs", "sha256", info.Digest.Hex()))
+			assert.NoError(t, err, tag)
+		}
+		img.Close()
+	}
+	_, err = os.Stat(filepath.Join(tmpDir, "blobs", "sha256", "unrelated"))
+	assert.NoError(t, err)
+
+	// Nothing more to remove
+	res, err = Prune(tmpDir, false)
+	require.NoError(t, err)
+	assert.Equal(t, &types.PruneResult{Blobs: []digest.Digest{}}, res)
+
+	// A directory which is not an OCI layout
+	emptyDir, err := ioutil.TempDir("", "oci-prune")
+	require.NoError(t, err)
+	defer os.RemoveAll(emptyDir)
+	_, err = Prune(emptyDir, false)
+	assert.Error(t, err)
+}
+
+func TestPruneManifestList(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-prune")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	instance := putPruneTestImage(t, tmpDir, "instance", "config", "layer")
+	instanceBlob, err := ioutil.ReadFile(filepath.Join(tmpDir, "blobs", "sha256", instance.Hex()))
+	require.NoError(t, err)
+	list, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     imgspecv1.MediaTypeImageManifestList,
+		"manifests": []map[string]interface{}{
+			{"digest": instance, "size": len(instanceBlob), "mediaType": imgspecv1.MediaTypeImageManifest},
+		},
+	})
+	require.NoError(t, err)
+	ref, err := NewReference(tmpDir, "list")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), list, nil))
+	dest.Close()
+	// Remove the tag of the instance; it remains referenced by the list.
+	index, err := ociReference{dir: tmpDir}.readIndex()
+	require.NoError(t, err)
+	index.Manifests = index.Manifests[1:]
+	indexBlob, err := json.Marshal(index)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "index.json"), indexBlob, 0644))
+
+	res, err := Prune(tmpDir, false)
+	require.NoError(t, err)
+	assert.Empty(t, res.Blobs)
+}
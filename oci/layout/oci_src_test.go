@@ -0,0 +1,72 @@
+package layout
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetManifestLegacyRefs verifies that layouts using refs/ instead of index.json can still be read.
+func TestGetManifestLegacyRefs(t *testing.T) {
+	const manifestDigest = "sha256:a2b2aef9b151ae76f026978cd8a8fb9c5e984a8c0578204d62cb0f3e609265d1"
+	m := []byte("This is a test blob.")
+
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+	ociRef, ok := ref.(ociReference)
+	require.True(t, ok)
+
+	blobPath, err := ociRef.blobPath(manifestDigest)
+	require.NoError(t, err)
+	err = ensureParentDirectoryExists(blobPath)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(blobPath, m, 0644)
+	require.NoError(t, err)
+	descriptorPath := ociRef.descriptorPath(ociRef.tag)
+	err = ensureParentDirectoryExists(descriptorPath)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(descriptorPath, []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"`+manifestDigest+`","size":20}`), 0644)
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	m2, mt, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, m, m2)
+	assert.Equal(t, "application/vnd.oci.image.manifest.v1+json", mt)
+}
+
+func TestGetManifestInstance(t *testing.T) {
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+	ociRef, ok := ref.(ociReference)
+	require.True(t, ok)
+
+	instance := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	instanceDigest := digest.FromBytes(instance)
+	blobPath, err := ociRef.blobPath(instanceDigest)
+	require.NoError(t, err)
+	err = ensureParentDirectoryExists(blobPath)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(blobPath, instance, 0644)
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	m, mt, err := src.GetManifest(context.Background(), &instanceDigest)
+	require.NoError(t, err)
+	assert.Equal(t, instance, m)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mt)
+
+	missingDigest := digest.FromBytes([]byte("missing"))
+	_, _, err = src.GetManifest(context.Background(), &missingDigest)
+	assert.Error(t, err)
+}
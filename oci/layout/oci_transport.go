@@ -1,15 +1,22 @@
 package layout
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/containers/image/directory/explicitfilepath"
 	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // Transport is an ImageTransport for OCI directories.
@@ -164,30 +171,29 @@ func (ref ociReference) PolicyConfigurationNamespaces() []string {
 	return res
 }
 
-// NewImage returns a types.Image for this reference, possibly specialized for this ImageTransport.
-// The caller must call .Close() on the returned Image.
+// NewImage returns a types.ImageCloser for this reference, possibly specialized for this ImageTransport.
+// The caller must call .Close() on the returned ImageCloser.
 // NOTE: If any kind of signature verification should happen, build an UnparsedImage from the value returned by NewImageSource,
 // verify that UnparsedImage, and convert it into a real Image via image.FromUnparsedImage.
-func (ref ociReference) NewImage(ctx *types.SystemContext) (types.Image, error) {
-	return nil, errors.New("Full Image support not implemented for oci: image names")
+func (ref ociReference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	src := newImageSource(ref)
+	return image.FromSource(ctx, sys, src)
 }
 
-// NewImageSource returns a types.ImageSource for this reference,
-// asking the backend to use a manifest from requestedManifestMIMETypes if possible.
-// nil requestedManifestMIMETypes means manifest.DefaultRequestedManifestMIMETypes.
+// NewImageSource returns a types.ImageSource for this reference.
 // The caller must call .Close() on the returned ImageSource.
-func (ref ociReference) NewImageSource(ctx *types.SystemContext, requestedManifestMIMETypes []string) (types.ImageSource, error) {
-	return nil, errors.New("Reading images not implemented for oci: image names")
+func (ref ociReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	return newImageSource(ref), nil
 }
 
 // NewImageDestination returns a types.ImageDestination for this reference.
 // The caller must call .Close() on the returned ImageDestination.
-func (ref ociReference) NewImageDestination(ctx *types.SystemContext) (types.ImageDestination, error) {
-	return newImageDestination(ref), nil
+func (ref ociReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	return newImageDestination(sys, ref), nil
 }
 
 // DeleteImage deletes the named image from the registry, if supported.
-func (ref ociReference) DeleteImage(ctx *types.SystemContext) error {
+func (ref ociReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	return fmt.Errorf("Deleting images not implemented for oci: images")
 }
 
@@ -197,15 +203,131 @@ func (ref ociReference) ociLayoutPath() string {
 }
 
 // blobPath returns a path for a blob within a directory using OCI image-layout conventions.
-func (ref ociReference) blobPath(digest string) (string, error) {
-	pts := strings.SplitN(digest, ":", 2)
-	if len(pts) != 2 {
-		return "", fmt.Errorf("unexpected digest reference %s", digest)
+func (ref ociReference) blobPath(digest digest.Digest) (string, error) {
+	if err := digest.Validate(); err != nil {
+		return "", fmt.Errorf("unexpected digest reference %s: %v", digest, err)
 	}
-	return filepath.Join(ref.dir, "blobs", pts[0], pts[1]), nil
+	return filepath.Join(ref.dir, "blobs", digest.Algorithm().String(), digest.Hex()), nil
 }
 
-// descriptorPath returns a path for the manifest within a directory using OCI conventions.
+// descriptorPath returns a path for the manifest within a directory using the conventions of
+// older OCI layouts, which stored a descriptor per tag in refs/ instead of using index.json.
 func (ref ociReference) descriptorPath(digest string) string {
 	return filepath.Join(ref.dir, "refs", digest)
 }
+
+// indexPath returns a path for the index.json within a directory using OCI conventions.
+func (ref ociReference) indexPath() string {
+	return filepath.Join(ref.dir, "index.json")
+}
+
+// annotationRefName is the index.json annotation which records the tag of a manifest.
+const annotationRefName = "org.opencontainers.image.ref.name"
+
+// ociIndex is the contents of index.json in an OCI image layout.
+// FIXME: Use the image-spec type once the vendored version provides it.
+type ociIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Manifests     []indexDescriptor `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// indexDescriptor is a descriptor of a manifest in ociIndex.
+type indexDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Platform is not used by this package, only preserved when rewriting index.json.
+	Platform json.RawMessage `json:"platform,omitempty"`
+}
+
+// readIndex returns the parsed index.json of the layout.
+// If the layout does not contain an index.json, the returned error satisfies os.IsNotExist.
+func (ref ociReference) readIndex() (*ociIndex, error) {
+	data, err := ioutil.ReadFile(ref.indexPath())
+	if err != nil {
+		return nil, err
+	}
+	index := ociIndex{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", ref.indexPath(), err)
+	}
+	return &index, nil
+}
+
+// loadIndex returns the index of the layout: the parsed index.json, or, for layouts which predate index.json,
+// an equivalent index built from the descriptors in refs/.
+// If the layout contains neither, the returned error satisfies os.IsNotExist.
+func (ref ociReference) loadIndex() (*ociIndex, error) {
+	index, err := ref.readIndex()
+	if err == nil || !os.IsNotExist(err) {
+		return index, err
+	}
+	return ref.readLegacyIndex()
+}
+
+// readLegacyIndex returns an index containing the manifests tagged in refs/.
+func (ref ociReference) readLegacyIndex() (*ociIndex, error) {
+	refsDir := filepath.Join(ref.dir, "refs")
+	fis, err := ioutil.ReadDir(refsDir)
+	if err != nil {
+		return nil, err
+	}
+	index := &ociIndex{SchemaVersion: 2, Manifests: []indexDescriptor{}}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(refsDir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		desc := imgspecv1.Descriptor{}
+		if err := json.Unmarshal(data, &desc); err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %v", filepath.Join(refsDir, fi.Name()), err)
+		}
+		index.Manifests = append(index.Manifests, indexDescriptor{
+			MediaType:   desc.MediaType,
+			Digest:      digest.Digest(desc.Digest),
+			Size:        desc.Size,
+			Annotations: map[string]string{annotationRefName: fi.Name()},
+		})
+	}
+	return index, nil
+}
+
+// getManifestDescriptor returns the descriptor of the manifest tagged ref.tag,
+// looking it up in index.json, or in refs/ for layouts which predate index.json.
+func (ref ociReference) getManifestDescriptor() (indexDescriptor, error) {
+	index, err := ref.loadIndex()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return indexDescriptor{}, fmt.Errorf("No manifest tagged %q found in %s: the directory is not an OCI layout", ref.tag, ref.dir)
+		}
+		return indexDescriptor{}, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[annotationRefName] == ref.tag {
+			return desc, nil
+		}
+	}
+	return indexDescriptor{}, fmt.Errorf("No manifest tagged %q found in %s", ref.tag, ref.dir)
+}
+
+// ListTags returns the tags of the images in the OCI layout at dir, in the order they are recorded in the layout.
+// Manifests without a tag are not included.
+func ListTags(dir string) ([]string, error) {
+	index, err := ociReference{dir: dir}.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{}
+	for _, desc := range index.Manifests {
+		if tag, ok := desc.Annotations[annotationRefName]; ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
@@ -1,6 +1,7 @@
 package layout
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -111,7 +112,8 @@ func TestNewReference(t *testing.T) {
 
 // refToTempOCI creates a temporary directory and returns an reference to it.
 // The caller should
-//   defer os.RemoveAll(tmpDir)
+//
+//	defer os.RemoveAll(tmpDir)
 func refToTempOCI(t *testing.T) (ref types.ImageReference, tmpDir string) {
 	tmpDir, err := ioutil.TempDir("", "oci-transport-test")
 	require.NoError(t, err)
@@ -205,21 +207,22 @@ func TestReferencePolicyConfigurationNamespaces(t *testing.T) {
 func TestReferenceNewImage(t *testing.T) {
 	ref, tmpDir := refToTempOCI(t)
 	defer os.RemoveAll(tmpDir)
-	_, err := ref.NewImage(nil)
+	_, err := ref.NewImage(context.Background(), nil)
 	assert.Error(t, err)
 }
 
 func TestReferenceNewImageSource(t *testing.T) {
 	ref, tmpDir := refToTempOCI(t)
 	defer os.RemoveAll(tmpDir)
-	_, err := ref.NewImageSource(nil, nil)
-	assert.Error(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	assert.NoError(t, err)
+	defer src.Close()
 }
 
 func TestReferenceNewImageDestination(t *testing.T) {
 	ref, tmpDir := refToTempOCI(t)
 	defer os.RemoveAll(tmpDir)
-	dest, err := ref.NewImageDestination(nil)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
 	assert.NoError(t, err)
 	defer dest.Close()
 }
@@ -227,7 +230,7 @@ func TestReferenceNewImageDestination(t *testing.T) {
 func TestReferenceDeleteImage(t *testing.T) {
 	ref, tmpDir := refToTempOCI(t)
 	defer os.RemoveAll(tmpDir)
-	err := ref.DeleteImage(nil)
+	err := ref.DeleteImage(context.Background(), nil)
 	assert.Error(t, err)
 }
 
@@ -270,3 +273,29 @@ func TestReferenceDescriptorPath(t *testing.T) {
 	require.True(t, ok)
 	assert.Equal(t, tmpDir+"/refs/notlatest", ociRef.descriptorPath("notlatest"))
 }
+
+func TestReferenceIndexPath(t *testing.T) {
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+	ociRef, ok := ref.(ociReference)
+	require.True(t, ok)
+	assert.Equal(t, tmpDir+"/index.json", ociRef.indexPath())
+}
+
+func TestListTags(t *testing.T) {
+	_, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+
+	// Not an OCI layout
+	_, err := ListTags(tmpDir)
+	assert.Error(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "index.json"), []byte(`{"schemaVersion":2,"manifests":[`+
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1,"annotations":{"org.opencontainers.image.ref.name":"b"}},`+
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1,"annotations":{"org.opencontainers.image.ref.name":"a"}}`+
+		`]}`), 0644)
+	require.NoError(t, err)
+	tags, err := ListTags(tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, tags)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package layout
+
+import (
+	"errors"
+	"os"
+)
+
+// reflink makes dest share the contents of src, without copying the data.
+func reflink(dest, src *os.File) error {
+	return errors.New("reflinks are not supported on this platform")
+}
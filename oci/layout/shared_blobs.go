@@ -0,0 +1,80 @@
+package layout
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/go-digest"
+)
+
+// linkSharedBlob makes the blob with digest available at blobPath by linking it from d.sharedBlobDir, using a hardlink,
+// or a reflink if hardlinking is not possible (e.g. because the directories are on different filesystems).
+// It returns the size of the blob, or -1 if the shared directory is not configured or the blob could not be linked;
+// the caller is then expected to copy the blob as usual.
+func (d *ociImageDestination) linkSharedBlob(digest digest.Digest, blobPath string) (int64, error) {
+	if d.sharedBlobDir == "" {
+		return -1, nil
+	}
+	// digest has been validated by d.ref.blobPath.
+	sharedPath := filepath.Join(d.sharedBlobDir, digest.Algorithm().String(), digest.Hex())
+	fi, err := os.Stat(sharedPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Debugf("Error looking up shared blob %s: %v", sharedPath, err)
+		}
+		return -1, nil
+	}
+	if !fi.Mode().IsRegular() {
+		logrus.Debugf("Shared blob %s is not a regular file, ignoring it", sharedPath)
+		return -1, nil
+	}
+
+	if err := ensureParentDirectoryExists(blobPath); err != nil {
+		return -1, err
+	}
+	// The shared blob is complete, so linking it directly to blobPath never exposes partial data.
+	err = os.Link(sharedPath, blobPath)
+	if err == nil || os.IsExist(err) {
+		return fi.Size(), nil
+	}
+	logrus.Debugf("Error hardlinking shared blob %s to %s, trying a reflink: %v", sharedPath, blobPath, err)
+	if err := d.reflinkBlob(sharedPath, blobPath); err != nil {
+		logrus.Debugf("Error reflinking shared blob %s to %s: %v", sharedPath, blobPath, err)
+		return -1, nil
+	}
+	return fi.Size(), nil
+}
+
+// reflinkBlob creates blobPath as a reflink of sharedPath, going through a temporary file so that blobPath is never visible incomplete.
+func (d *ociImageDestination) reflinkBlob(sharedPath, blobPath string) error {
+	src, err := os.Open(sharedPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dest, err := ioutil.TempFile(d.ref.dir, "oci-link-blob")
+	if err != nil {
+		return err
+	}
+	succeeded := false
+	defer func() {
+		dest.Close()
+		if !succeeded {
+			os.Remove(dest.Name())
+		}
+	}()
+
+	if err := reflink(dest, src); err != nil {
+		return err
+	}
+	if err := dest.Chmod(0644); err != nil {
+		return err
+	}
+	if err := os.Rename(dest.Name(), blobPath); err != nil {
+		return err
+	}
+	succeeded = true
+	return nil
+}
@@ -0,0 +1,69 @@
+package layout
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTryReusingBlobSharedBlobDir(t *testing.T) {
+	blob := []byte("shared blob contents")
+	blobDigest := digest.FromBytes(blob)
+	missingDigest := digest.FromString("missing")
+
+	// Use another layout as the source of shared blobs.
+	sharedRef, sharedDir := refToTempOCI(t)
+	defer os.RemoveAll(sharedDir)
+	sharedDest, err := sharedRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer sharedDest.Close()
+	_, err = sharedDest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, false)
+	require.NoError(t, err)
+	sharedPath, err := sharedRef.(ociReference).blobPath(blobDigest)
+	require.NoError(t, err)
+
+	ref, tmpDir := refToTempOCI(t)
+	defer os.RemoveAll(tmpDir)
+	blobPath, err := ref.(ociReference).blobPath(blobDigest)
+	require.NoError(t, err)
+
+	// Without a shared directory, the blob is not available.
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	reused, _, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+
+	dest, err = ref.NewImageDestination(context.Background(), &types.SystemContext{OCISharedBlobDirPath: filepath.Join(sharedDir, "blobs")})
+	require.NoError(t, err)
+	defer dest.Close()
+	// Blobs missing in the shared directory are not available.
+	reused, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: missingDigest, Size: -1}, blobinfocache.NoCache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+	// Blobs present in the shared directory are linked into the layout.
+	for i := 0; i < 2; i++ { // The second attempt finds the blob already in the layout.
+		reused, info, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, blobinfocache.NoCache, false)
+		require.NoError(t, err)
+		assert.True(t, reused)
+		assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, info)
+	}
+	contents, err := ioutil.ReadFile(blobPath)
+	require.NoError(t, err)
+	assert.Equal(t, blob, contents)
+	fi, err := os.Stat(blobPath)
+	require.NoError(t, err)
+	sharedFI, err := os.Stat(sharedPath)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(fi, sharedFI))
+}
@@ -0,0 +1,325 @@
+package ostree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/putblobdigest"
+	"github.com/containers/image/pkg/tmpdir"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+type blobToImport struct {
+	Size     int64
+	Digest   digest.Digest
+	BlobPath string
+}
+
+type ostreeImageDestination struct {
+	ref        ostreeReference
+	tmpDirPath string
+	blobs      map[digest.Digest]*blobToImport
+	manifest   []byte
+	signatures [][]byte
+}
+
+// newImageDestination returns an ImageDestination for writing to an ostree repository.
+// Blobs are staged in a temporary directory, and only committed to the repository by Commit.
+func newImageDestination(sys *types.SystemContext, ref ostreeReference) (types.ImageDestination, error) {
+	tmpDirPath, err := ioutil.TempDir(tmpdir.TemporaryDirectoryForBigFiles(sys), "ostree")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating a temporary directory: %v", err)
+	}
+	return &ostreeImageDestination{
+		ref:        ref,
+		tmpDirPath: tmpDirPath,
+		blobs:      map[digest.Digest]*blobToImport{},
+	}, nil
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (d *ostreeImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+func (d *ostreeImageDestination) Close() {
+	os.RemoveAll(d.tmpDirPath)
+}
+
+func (d *ostreeImageDestination) SupportedManifestMIMETypes() []string {
+	return nil
+}
+
+// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
+// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
+func (d *ostreeImageDestination) SupportsSignatures(ctx context.Context) error {
+	return nil
+}
+
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d *ostreeImageDestination) DesiredLayerCompression() types.LayerCompression {
+	// The layers are unpacked into the repository anyway.
+	return types.PreserveOriginal
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *ostreeImageDestination) HasThreadSafePutBlob() bool {
+	return false // d.blobs is not protected by a lock
+}
+
+// PutBlob writes contents of stream and returns data representing the result (with all data filled in).
+// inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
+// inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (d *ostreeImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	blobFile, err := ioutil.TempFile(d.tmpDirPath, "blob")
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	blobPath := blobFile.Name()
+	succeeded := false
+	defer func() {
+		blobFile.Close()
+		if !succeeded {
+			os.Remove(blobPath)
+		}
+	}()
+
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	size, err := io.Copy(blobFile, stream)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	computedDigest := digester.Digest()
+	if inputInfo.Size != -1 && size != inputInfo.Size {
+		return types.BlobInfo{}, fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", computedDigest, inputInfo.Size, size)
+	}
+	if err := blobFile.Sync(); err != nil {
+		return types.BlobInfo{}, err
+	}
+
+	succeeded = true
+	d.blobs[computedDigest] = &blobToImport{Size: size, Digest: computedDigest, BlobPath: blobPath}
+	return types.BlobInfo{Digest: computedDigest, Size: size}, nil
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *ostreeImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	// Blobs are only staged in a temporary directory until Commit(), so there is nothing to reuse.
+	return false, types.BlobInfo{}, nil
+}
+
+func (d *ostreeImageDestination) PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the ostree: transport")
+	}
+	d.manifest = make([]byte, len(manifest))
+	copy(d.manifest, manifest)
+	return nil
+}
+
+func (d *ostreeImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the ostree: transport")
+	}
+	d.signatures = make([][]byte, len(signatures))
+	for i, sig := range signatures {
+		d.signatures[i] = make([]byte, len(sig))
+		copy(d.signatures[i], sig)
+	}
+	return nil
+}
+
+// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+// Each layer is committed to its own ociimage/<digest> branch, so that layers shared between images
+// are stored only once, and the repository deduplicates identical files across all layers;
+// the original layer blob is committed to an ociimage/<digest>_blob branch, so that the image can be copied elsewhere unmodified.
+// The manifest and signatures are committed to a branch named after the image.
+// WARNING: This does not have any transactional semantics:
+// - Layers committed before a failure are not removed.
+func (d *ostreeImageDestination) Commit(ctx context.Context) error {
+	if d.manifest == nil {
+		return errors.New("Internal error: ostreeImageDestination.Commit() called without PutManifest()")
+	}
+	img, err := image.FromSource(ctx, nil, &stagedImageSource{d})
+	if err != nil {
+		return fmt.Errorf("Error parsing the manifest: %v", err)
+	}
+	defer img.Close()
+
+	if err := ensureRepoExists(d.ref.repo); err != nil {
+		return err
+	}
+
+	for _, layer := range img.LayerInfos() {
+		blob, ok := d.blobs[layer.Digest]
+		if !ok {
+			return fmt.Errorf("Layer %s was not stored", layer.Digest)
+		}
+		if !branchExists(d.ref.repo, blobBranch(blob.Digest)) {
+			if err := d.importLayer(blob); err != nil {
+				return err
+			}
+		}
+		if !branchExists(d.ref.repo, layerBlobBranch(blob.Digest)) {
+			if err := d.importContent(blob, layerBlobBranch(blob.Digest)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if config := img.ConfigInfo(); config.Digest != "" {
+		blob, ok := d.blobs[config.Digest]
+		if !ok {
+			return fmt.Errorf("Config %s was not stored", config.Digest)
+		}
+		if err := d.importContent(blob, blobBranch(blob.Digest)); err != nil {
+			return err
+		}
+	}
+
+	manifestDir := filepath.Join(d.tmpDirPath, "manifest")
+	if err := os.Mkdir(manifestDir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(manifestDir, "manifest.json"), d.manifest, 0644); err != nil {
+		return err
+	}
+	for i, sig := range d.signatures {
+		if err := ioutil.WriteFile(filepath.Join(manifestDir, signatureFileName(i)), sig, 0644); err != nil {
+			return err
+		}
+	}
+	manifestDigest, err := manifest.Digest(d.manifest)
+	if err != nil {
+		return err
+	}
+	return ostreeCommit(d.ref.repo, d.ref.manifestBranch(), "tree=dir="+manifestDir,
+		"docker.digest="+manifestDigest.String())
+}
+
+// importLayer commits the contents of a layer tarball to the layer's branch.
+func (d *ostreeImageDestination) importLayer(blob *blobToImport) error {
+	return ostreeCommit(d.ref.repo, blobBranch(blob.Digest), "tree=tar="+blob.BlobPath,
+		"docker.layer="+blob.Digest.String(), fmt.Sprintf("docker.size=%d", blob.Size))
+}
+
+// importContent commits a blob, unmodified, as a file named "content", to branch.
+func (d *ostreeImageDestination) importContent(blob *blobToImport, branch string) error {
+	contentDir := filepath.Join(d.tmpDirPath, blob.Digest.Hex())
+	if err := os.MkdirAll(contentDir, 0755); err != nil {
+		return err
+	}
+	contentPath := filepath.Join(contentDir, "content")
+	if blob.BlobPath != contentPath {
+		if err := os.Rename(blob.BlobPath, contentPath); err != nil {
+			return err
+		}
+		blob.BlobPath = contentPath
+	}
+	return ostreeCommit(d.ref.repo, branch, "tree=dir="+contentDir,
+		fmt.Sprintf("docker.size=%d", blob.Size))
+}
+
+// ostreeCommit commits tree (an ostree commit --tree argument, without the "--tree=" prefix) to branch in repo,
+// adding each of metadata (in the "key=value" format) as a metadata string.
+func ostreeCommit(repo, branch, tree string, metadata ...string) error {
+	args := []string{"commit", "--repo=" + repo, "--branch=" + branch, "--" + tree, "--no-xattrs", "--subject=" + branch}
+	for _, m := range metadata {
+		args = append(args, "--add-metadata-string="+m)
+	}
+	_, err := runOSTree(args...)
+	return err
+}
+
+// ensureRepoExists initializes an ostree repository at repo, unless one already exists.
+func ensureRepoExists(repo string) error {
+	if _, err := os.Stat(filepath.Join(repo, "config")); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		return err
+	}
+	_, err := runOSTree("init", "--repo="+repo, "--mode=bare-user")
+	return err
+}
+
+// branchExists returns true iff branch exists in repo.
+func branchExists(repo, branch string) bool {
+	_, err := runOSTree("rev-parse", "--repo="+repo, branch)
+	return err == nil
+}
+
+// signatureFileName returns the name of the file holding the signature with the specified index within the manifest branch.
+func signatureFileName(index int) string {
+	return fmt.Sprintf("signature-%d", index+1)
+}
+
+// stagedImageSource is an ImageSource for the data staged in an ostreeImageDestination,
+// used to parse the manifest at Commit() time.
+type stagedImageSource struct {
+	dest *ostreeImageDestination
+}
+
+func (s *stagedImageSource) Reference() types.ImageReference {
+	return s.dest.ref
+}
+
+func (s *stagedImageSource) Close() {
+}
+
+func (s *stagedImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return nil, "", fmt.Errorf("Getting target manifest not supported by ostree:")
+	}
+	return s.dest.manifest, manifest.GuessMIMEType(s.dest.manifest), nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *stagedImageSource) HasThreadSafeGetBlob() bool {
+	return false
+}
+
+func (s *stagedImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blob, ok := s.dest.blobs[info.Digest]
+	if !ok {
+		return nil, -1, fmt.Errorf("Blob %s was not stored", info.Digest)
+	}
+	f, err := os.Open(blob.BlobPath)
+	if err != nil {
+		return nil, -1, err
+	}
+	return f, blob.Size, nil
+}
+
+func (s *stagedImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	if instanceDigest != nil {
+		return nil, errors.New("Manifest lists are not supported by the ostree: transport")
+	}
+	return s.dest.signatures, nil
+}
@@ -0,0 +1,126 @@
+package ostree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+type ostreeImageSource struct {
+	ref ostreeReference
+}
+
+// newImageSource returns an ImageSource for reading from an ostree repository.
+func newImageSource(ref ostreeReference) (types.ImageSource, error) {
+	if !branchExists(ref.repo, ref.manifestBranch()) {
+		return nil, fmt.Errorf("Image %s not found in ostree repository %s", ref.image, ref.repo)
+	}
+	return &ostreeImageSource{ref: ref}, nil
+}
+
+// Reference returns the reference used to set up this source, _as specified by the user_
+// (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
+func (s *ostreeImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+// Close removes resources associated with an initialized ImageSource, if any.
+func (s *ostreeImageSource) Close() {
+}
+
+// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
+// It may use a remote (= slow) service.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *ostreeImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return nil, "", fmt.Errorf("Getting target manifest not supported by ostree:")
+	}
+	m, err := runOSTree("cat", "--repo="+s.ref.repo, s.ref.manifestBranch(), "/manifest.json")
+	if err != nil {
+		return nil, "", err
+	}
+	return m, manifest.GuessMIMEType(m), nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *ostreeImageSource) HasThreadSafeGetBlob() bool {
+	return false
+}
+
+// GetBlob returns a stream for the specified blob, and the blob's size (or -1 if unknown).
+func (s *ostreeImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if branch := layerBlobBranch(info.Digest); branchExists(s.ref.repo, branch) {
+		// The original layer blob; layers can be large, so stream it instead of reading it into memory.
+		return catContent(s.ref.repo, branch)
+	}
+	// Anything else, most importantly the config, was stored as a file named "content".
+	b, err := runOSTree("cat", "--repo="+s.ref.repo, blobBranch(info.Digest), "/content")
+	if err != nil {
+		return nil, -1, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+}
+
+// catContent returns a stream containing the file named "content" in branch.
+func catContent(repo, branch string) (io.ReadCloser, int64, error) {
+	cmd := exec.Command("ostree", "cat", "--repo="+repo, branch, "/content")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, -1, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, -1, fmt.Errorf("Error running ostree cat: %v", err)
+	}
+	return &commandReadCloser{Reader: stdout, cmd: cmd}, -1, nil
+}
+
+// commandReadCloser is an io.ReadCloser reading the standard output of cmd, which is reaped on Close.
+type commandReadCloser struct {
+	io.Reader
+	cmd *exec.Cmd
+}
+
+func (r *commandReadCloser) Close() error {
+	// Drain the output so that the process is not blocked writing to a pipe nobody reads.
+	io.Copy(ioutil.Discard, r.Reader)
+	return r.cmd.Wait()
+}
+
+// GetSignatures returns the image's signatures.  It may use a remote (= slow) service.
+func (s *ostreeImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	if instanceDigest != nil {
+		return nil, errors.New("Manifest lists are not supported by the ostree: transport")
+	}
+	out, err := runOSTree("ls", "--repo="+s.ref.repo, s.ref.manifestBranch(), "/")
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		files[strings.TrimPrefix(fields[len(fields)-1], "/")] = true
+	}
+
+	signatures := [][]byte{}
+	for i := 0; files[signatureFileName(i)]; i++ {
+		signature, err := runOSTree("cat", "--repo="+s.ref.repo, s.ref.manifestBranch(), "/"+signatureFileName(i))
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
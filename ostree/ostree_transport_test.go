@@ -0,0 +1,156 @@
+package ostree
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sha256digestHex = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	sha256digest    = "@sha256:" + sha256digestHex
+)
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "ostree", Transport.Name())
+}
+
+func TestTransportParseReference(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostreeParseReference")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for _, c := range []struct{ input, expectedImage, expectedRepo string }{
+		{"busybox", "busybox:latest", defaultOSTreeRepo},                        // Default tag and repo
+		{"busybox:notlatest", "busybox:notlatest", defaultOSTreeRepo},           // Explicit tag
+		{"example.com/ns/foo:bar", "example.com/ns/foo:bar", defaultOSTreeRepo}, // Host name and namespace
+		{"localhost:5000/busybox", "localhost:5000/busybox:latest", defaultOSTreeRepo},
+		{"busybox@" + tmpDir, "busybox:latest", tmpDir},                // Explicit repo
+		{"busybox@" + tmpDir + "/.", "busybox:latest", tmpDir},         // Repo path is cleaned
+		{"UPPERCASEISINVALID", "", ""},                                 // Invalid name
+		{"busybox" + sha256digest, "", ""},                             // Digests are not supported
+		{"busybox@" + tmpDir + "/thisparentdoesnotexist/repo", "", ""}, // Missing parent of the repo
+	} {
+		ref, err := Transport.ParseReference(c.input)
+		if c.expectedImage == "" {
+			assert.Error(t, err, c.input)
+			continue
+		}
+		require.NoError(t, err, c.input)
+		ostreeRef, ok := ref.(ostreeReference)
+		require.True(t, ok, c.input)
+		assert.Equal(t, c.expectedImage, ostreeRef.image, c.input)
+		assert.Equal(t, c.expectedRepo, ostreeRef.repo, c.input)
+	}
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	for _, scope := range []string{
+		"/etc:docker.io/library/busybox:notlatest", // This also demonstrates that two colons are interpreted as repo:name:tag.
+		"/etc:docker.io/library/busybox",
+		"/etc:docker.io/library",
+		"/etc:docker.io",
+		"/etc:repo",
+		"/this/does/not/exist:notlatest",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.NoError(t, err, scope)
+	}
+
+	for _, scope := range []string{
+		"/colon missing as a path-reference delimiter",
+		"relative/path:busybox",
+		"/double//slashes:busybox",
+		"/has/./dot:busybox",
+		"/has/dot/../dot:busybox",
+		"/trailing/slash/:busybox",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.Error(t, err, scope)
+	}
+}
+
+// refToTempOSTree creates a temporary directory and returns a reference to busybox in it.
+// The caller should defer os.RemoveAll(tmpDir).
+func refToTempOSTree(t *testing.T) (ref types.ImageReference, tmpDir string) {
+	tmpDir, err := ioutil.TempDir("", "ostree-transport-test")
+	require.NoError(t, err)
+	ref, err = NewReference("busybox", tmpDir)
+	require.NoError(t, err)
+	return ref, tmpDir
+}
+
+func TestReferenceTransport(t *testing.T) {
+	ref, tmpDir := refToTempOSTree(t)
+	defer os.RemoveAll(tmpDir)
+	assert.Equal(t, Transport, ref.Transport())
+}
+
+func TestReferenceStringWithinTransport(t *testing.T) {
+	ref, tmpDir := refToTempOSTree(t)
+	defer os.RemoveAll(tmpDir)
+	assert.Equal(t, "busybox:latest@"+tmpDir, ref.StringWithinTransport())
+
+	ref2, err := Transport.ParseReference(ref.StringWithinTransport())
+	require.NoError(t, err)
+	assert.Equal(t, ref, ref2)
+}
+
+func TestReferenceDockerReference(t *testing.T) {
+	ref, tmpDir := refToTempOSTree(t)
+	defer os.RemoveAll(tmpDir)
+	assert.Nil(t, ref.DockerReference())
+}
+
+func TestReferencePolicyConfigurationIdentity(t *testing.T) {
+	ref, tmpDir := refToTempOSTree(t)
+	defer os.RemoveAll(tmpDir)
+	assert.Equal(t, tmpDir+":busybox:latest", ref.PolicyConfigurationIdentity())
+}
+
+func TestReferencePolicyConfigurationNamespaces(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ostree-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	ref, err := NewReference("example.com/ns/repo:tag", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		tmpDir + ":example.com/ns/repo",
+		tmpDir + ":example.com/ns",
+		tmpDir + ":example.com",
+	}, ref.PolicyConfigurationNamespaces())
+	for _, ns := range ref.PolicyConfigurationNamespaces() {
+		err := Transport.ValidatePolicyConfigurationScope(ns)
+		assert.NoError(t, err, ns)
+	}
+}
+
+func TestReferenceDeleteImage(t *testing.T) {
+	ref, tmpDir := refToTempOSTree(t)
+	defer os.RemoveAll(tmpDir)
+	err := ref.DeleteImage(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestEncodeOSTreeRef(t *testing.T) {
+	// Just a smoke test
+	assert.Equal(t, "busybox_3Alatest", encodeOStreeRef("busybox:latest"))
+	assert.Equal(t, "example.com_2Fns_2Frepo_3Atag", encodeOStreeRef("example.com/ns/repo:tag"))
+}
+
+func TestReferenceBranches(t *testing.T) {
+	ref, tmpDir := refToTempOSTree(t)
+	defer os.RemoveAll(tmpDir)
+	ostreeRef, ok := ref.(ostreeReference)
+	require.True(t, ok)
+	assert.Equal(t, "ociimage/busybox_3Alatest", ostreeRef.manifestBranch())
+	assert.Equal(t, fmt.Sprintf("ociimage/%s", sha256digestHex), blobBranch("sha256:"+sha256digestHex))
+	assert.Equal(t, fmt.Sprintf("ociimage/%s_blob", sha256digestHex), layerBlobBranch("sha256:"+sha256digestHex))
+}
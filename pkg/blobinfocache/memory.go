@@ -0,0 +1,127 @@
+package blobinfocache
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// locationKey only exists to make lookup in knownLocations easier.
+type locationKey struct {
+	transport string
+	scope     types.BICTransportScope
+	digest    digest.Digest
+}
+
+// memoryCache implements an in-memory-only BlobInfoCache.
+type memoryCache struct {
+	mutex                 sync.Mutex                                   // Protects all of the fields below
+	uncompressedDigests   map[digest.Digest]digest.Digest              // Any digest -> its uncompressed digest
+	digestsByUncompressed map[digest.Digest]map[digest.Digest]struct{} // Uncompressed digest -> set of digests with those contents
+	knownLocations        map[locationKey][]types.BICLocationReference // Most recently recorded first
+}
+
+// NewMemoryCache returns a BlobInfoCache implementation which is in-memory only.
+// This is primarily intended for tests and for a single copy operation; the data is lost when the process exits.
+func NewMemoryCache() types.BlobInfoCache {
+	return &memoryCache{
+		uncompressedDigests:   map[digest.Digest]digest.Digest{},
+		digestsByUncompressed: map[digest.Digest]map[digest.Digest]struct{}{},
+		knownLocations:        map[locationKey][]types.BICLocationReference{},
+	}
+}
+
+// UncompressedDigest returns an uncompressed digest corresponding to anyDigest.
+// May return anyDigest if it is known to be uncompressed.
+// Returns "" if nothing is known about the digest (it may be compressed or uncompressed).
+func (mem *memoryCache) UncompressedDigest(anyDigest digest.Digest) digest.Digest {
+	mem.mutex.Lock()
+	defer mem.mutex.Unlock()
+	return mem.uncompressedDigestLocked(anyDigest)
+}
+
+// uncompressedDigestLocked implements UncompressedDigest; the caller must hold mem.mutex.
+func (mem *memoryCache) uncompressedDigestLocked(anyDigest digest.Digest) digest.Digest {
+	if d, ok := mem.uncompressedDigests[anyDigest]; ok {
+		return d
+	}
+	// Presence in digestsByUncompressed implies that anyDigest must already refer to an uncompressed digest.
+	if _, ok := mem.digestsByUncompressed[anyDigest]; ok {
+		return anyDigest
+	}
+	return ""
+}
+
+// RecordDigestUncompressedPair records that the uncompressed version of anyDigest is uncompressed.
+// It’s allowed for anyDigest == uncompressed.
+func (mem *memoryCache) RecordDigestUncompressedPair(anyDigest digest.Digest, uncompressed digest.Digest) {
+	mem.mutex.Lock()
+	defer mem.mutex.Unlock()
+	if previous, ok := mem.uncompressedDigests[anyDigest]; ok && previous != uncompressed {
+		delete(mem.digestsByUncompressed[previous], anyDigest)
+	}
+	mem.uncompressedDigests[anyDigest] = uncompressed
+	anyDigestSet, ok := mem.digestsByUncompressed[uncompressed]
+	if !ok {
+		anyDigestSet = map[digest.Digest]struct{}{}
+		mem.digestsByUncompressed[uncompressed] = anyDigestSet
+	}
+	anyDigestSet[anyDigest] = struct{}{}
+}
+
+// RecordKnownLocation records that a blob with the specified digest exists within the specified (transport, scope),
+// and can be found using the specified location reference.
+func (mem *memoryCache) RecordKnownLocation(transport types.ImageTransport, scope types.BICTransportScope, digest digest.Digest, location types.BICLocationReference) {
+	mem.mutex.Lock()
+	defer mem.mutex.Unlock()
+	key := locationKey{transport: transport.Name(), scope: scope, digest: digest}
+	locations := []types.BICLocationReference{location}
+	for _, l := range mem.knownLocations[key] {
+		if l != location {
+			locations = append(locations, l)
+		}
+	}
+	mem.knownLocations[key] = locations
+}
+
+// appendCandidatesLocked appends the locations of the blob with digest within (transport, scope) to candidates;
+// the caller must hold mem.mutex.
+func (mem *memoryCache) appendCandidatesLocked(candidates []types.BICReplacementCandidate, transport types.ImageTransport, scope types.BICTransportScope, digest digest.Digest) []types.BICReplacementCandidate {
+	for _, l := range mem.knownLocations[locationKey{transport: transport.Name(), scope: scope, digest: digest}] {
+		candidates = append(candidates, types.BICReplacementCandidate{Digest: digest, Location: l})
+	}
+	return candidates
+}
+
+// CandidateLocations returns the locations recorded for the blob with the specified digest within (transport, scope),
+// most recently recorded first.
+// If !canSubstitute, the returned candidates all match the submitted digest exactly; if canSubstitute,
+// data from previous RecordDigestUncompressedPair calls is used to also return locations of other blobs
+// with the same uncompressed contents, after the exact matches.
+func (mem *memoryCache) CandidateLocations(transport types.ImageTransport, scope types.BICTransportScope, primaryDigest digest.Digest, canSubstitute bool) []types.BICReplacementCandidate {
+	mem.mutex.Lock()
+	defer mem.mutex.Unlock()
+	res := mem.appendCandidatesLocked([]types.BICReplacementCandidate{}, transport, scope, primaryDigest)
+	if canSubstitute {
+		if uncompressedDigest := mem.uncompressedDigestLocked(primaryDigest); uncompressedDigest != "" {
+			others := []digest.Digest{}
+			for d := range mem.digestsByUncompressed[uncompressedDigest] {
+				if d != primaryDigest {
+					others = append(others, d)
+				}
+			}
+			if uncompressedDigest != primaryDigest {
+				if _, ok := mem.digestsByUncompressed[uncompressedDigest][uncompressedDigest]; !ok {
+					others = append(others, uncompressedDigest)
+				}
+			}
+			sort.Slice(others, func(i, j int) bool { return others[i] < others[j] }) // Only to make the result deterministic.
+			for _, d := range others {
+				res = mem.appendCandidatesLocked(res, transport, scope, d)
+			}
+		}
+	}
+	return res
+}
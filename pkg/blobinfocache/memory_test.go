@@ -0,0 +1,129 @@
+package blobinfocache
+
+import (
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockTransport is a fake types.ImageTransport, only providing Name().
+type mockTransport struct {
+	name string
+}
+
+func (t mockTransport) Name() string {
+	return t.name
+}
+func (t mockTransport) ParseReference(reference string) (types.ImageReference, error) {
+	panic("unexpected call to a mock function")
+}
+func (t mockTransport) ValidatePolicyConfigurationScope(scope string) error {
+	panic("unexpected call to a mock function")
+}
+
+func TestMemoryCacheKnownLocations(t *testing.T) {
+	const (
+		digest1 = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+		digest2 = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+	)
+	transport := mockTransport{"==BlobInfocache transport mock"}
+	otherTransport := mockTransport{"==BlobInfocache other transport mock"}
+	scope := types.BICTransportScope{Opaque: "scope"}
+	otherScope := types.BICTransportScope{Opaque: "other scope"}
+	loc1 := types.BICLocationReference{Opaque: "loc1"}
+	loc2 := types.BICLocationReference{Opaque: "loc2"}
+
+	cache := NewMemoryCache()
+	assert.Empty(t, cache.CandidateLocations(transport, scope, digest1, false))
+
+	cache.RecordKnownLocation(transport, scope, digest1, loc1)
+	cache.RecordKnownLocation(transport, scope, digest1, loc2)
+	assert.Equal(t, []types.BICReplacementCandidate{{Digest: digest1, Location: loc2}, {Digest: digest1, Location: loc1}},
+		cache.CandidateLocations(transport, scope, digest1, false))
+	// Recording a location again moves it to the front, without creating a duplicate.
+	cache.RecordKnownLocation(transport, scope, digest1, loc1)
+	assert.Equal(t, []types.BICReplacementCandidate{{Digest: digest1, Location: loc1}, {Digest: digest1, Location: loc2}},
+		cache.CandidateLocations(transport, scope, digest1, false))
+
+	// Locations are specific to (transport, scope, digest).
+	assert.Empty(t, cache.CandidateLocations(otherTransport, scope, digest1, false))
+	assert.Empty(t, cache.CandidateLocations(transport, otherScope, digest1, false))
+	assert.Empty(t, cache.CandidateLocations(transport, scope, digest2, false))
+
+	// The returned slice is a copy.
+	res := cache.CandidateLocations(transport, scope, digest1, false)
+	res[0] = types.BICReplacementCandidate{Digest: digest2, Location: types.BICLocationReference{Opaque: "modified"}}
+	assert.Equal(t, []types.BICReplacementCandidate{{Digest: digest1, Location: loc1}, {Digest: digest1, Location: loc2}},
+		cache.CandidateLocations(transport, scope, digest1, false))
+}
+
+func TestMemoryCacheUncompressedDigest(t *testing.T) {
+	const (
+		compressed1  = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+		compressed2  = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+		uncompressed = digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+		unknown      = digest.Digest("sha256:4444444444444444444444444444444444444444444444444444444444444444")
+	)
+	cache := NewMemoryCache()
+	assert.Equal(t, digest.Digest(""), cache.UncompressedDigest(compressed1))
+
+	cache.RecordDigestUncompressedPair(compressed1, uncompressed)
+	cache.RecordDigestUncompressedPair(compressed2, uncompressed)
+	assert.Equal(t, uncompressed, cache.UncompressedDigest(compressed1))
+	assert.Equal(t, uncompressed, cache.UncompressedDigest(compressed2))
+	// An uncompressed digest which was only recorded as the target of a pair is known to be uncompressed.
+	assert.Equal(t, uncompressed, cache.UncompressedDigest(uncompressed))
+	assert.Equal(t, digest.Digest(""), cache.UncompressedDigest(unknown))
+
+	// Recording a different pair replaces the previous one.
+	cache.RecordDigestUncompressedPair(compressed2, unknown)
+	assert.Equal(t, unknown, cache.UncompressedDigest(compressed2))
+}
+
+func TestMemoryCacheCandidateLocationsSubstitution(t *testing.T) {
+	const (
+		compressed1  = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+		compressed2  = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+		uncompressed = digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+	)
+	transport := mockTransport{"==BlobInfocache transport mock"}
+	scope := types.BICTransportScope{Opaque: "scope"}
+	loc1 := types.BICLocationReference{Opaque: "loc1"}
+	loc2 := types.BICLocationReference{Opaque: "loc2"}
+	loc3 := types.BICLocationReference{Opaque: "loc3"}
+
+	cache := NewMemoryCache()
+	cache.RecordDigestUncompressedPair(compressed1, uncompressed)
+	cache.RecordDigestUncompressedPair(compressed2, uncompressed)
+	cache.RecordKnownLocation(transport, scope, compressed1, loc1)
+	cache.RecordKnownLocation(transport, scope, compressed2, loc2)
+	cache.RecordKnownLocation(transport, scope, uncompressed, loc3)
+
+	// Without substitution, only exact matches are returned.
+	assert.Equal(t, []types.BICReplacementCandidate{{Digest: compressed1, Location: loc1}},
+		cache.CandidateLocations(transport, scope, compressed1, false))
+	// With substitution, exact matches are returned first, followed by blobs with the same uncompressed contents.
+	assert.Equal(t, []types.BICReplacementCandidate{
+		{Digest: compressed1, Location: loc1},
+		{Digest: compressed2, Location: loc2},
+		{Digest: uncompressed, Location: loc3},
+	}, cache.CandidateLocations(transport, scope, compressed1, true))
+	assert.Equal(t, []types.BICReplacementCandidate{
+		{Digest: uncompressed, Location: loc3},
+		{Digest: compressed1, Location: loc1},
+		{Digest: compressed2, Location: loc2},
+	}, cache.CandidateLocations(transport, scope, uncompressed, true))
+}
+
+func TestNoCache(t *testing.T) {
+	const digest1 = digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	transport := mockTransport{"==BlobInfocache transport mock"}
+	scope := types.BICTransportScope{Opaque: "scope"}
+
+	NoCache.RecordDigestUncompressedPair(digest1, digest1)
+	assert.Equal(t, digest.Digest(""), NoCache.UncompressedDigest(digest1))
+	NoCache.RecordKnownLocation(transport, scope, digest1, types.BICLocationReference{Opaque: "loc"})
+	assert.Empty(t, NoCache.CandidateLocations(transport, scope, digest1, true))
+}
@@ -0,0 +1,38 @@
+// Package blobinfocache provides implementations of types.BlobInfoCache.
+package blobinfocache
+
+import (
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+type noCache struct {
+}
+
+// NoCache implements BlobInfoCache by not recording any data.
+// This is primarily useful for reading config blobs, which are read once and never reused from another location;
+// copying layers should usually use at least a short-lived cache, e.g. one created by NewMemoryCache.
+var NoCache types.BlobInfoCache = noCache{}
+
+// UncompressedDigest returns an uncompressed digest corresponding to anyDigest.
+// May return anyDigest if it is known to be uncompressed.
+// Returns "" if nothing is known about the digest (it may be compressed or uncompressed).
+func (noCache) UncompressedDigest(anyDigest digest.Digest) digest.Digest {
+	return ""
+}
+
+// RecordDigestUncompressedPair records that the uncompressed version of anyDigest is uncompressed.
+// It’s allowed for anyDigest == uncompressed.
+func (noCache) RecordDigestUncompressedPair(anyDigest digest.Digest, uncompressed digest.Digest) {
+}
+
+// RecordKnownLocation records that a blob with the specified digest exists within the specified (transport, scope),
+// and can be found using the specified location reference.
+func (noCache) RecordKnownLocation(transport types.ImageTransport, scope types.BICTransportScope, digest digest.Digest, location types.BICLocationReference) {
+}
+
+// CandidateLocations returns the locations recorded for the blob with the specified digest within (transport, scope),
+// most recently recorded first.
+func (noCache) CandidateLocations(transport types.ImageTransport, scope types.BICTransportScope, digest digest.Digest, canSubstitute bool) []types.BICReplacementCandidate {
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package compression detects the compression format of blobs, and decompresses them, so that callers
+// need not assume that compressed layers always use gzip.
+package compression
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// DecompressorFunc returns the decompressed stream, given a compressed stream.
+// The caller must call Close() on the decompressed stream (even if the compressed input stream does not need closing!).
+type DecompressorFunc func(io.Reader) (io.ReadCloser, error)
+
+// GzipDecompressor is a DecompressorFunc for the gzip compression algorithm.
+func GzipDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// Bzip2Decompressor is a DecompressorFunc for the bzip2 compression algorithm.
+func Bzip2Decompressor(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bzip2.NewReader(r)), nil
+}
+
+// XzDecompressor is a DecompressorFunc for the xz compression algorithm.
+func XzDecompressor(r io.Reader) (io.ReadCloser, error) {
+	xzReader, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(xzReader), nil
+}
+
+// ZstdDecompressor is a DecompressorFunc for the zstd compression algorithm.
+func ZstdDecompressor(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zstdReadCloser{decoder}, nil
+}
+
+// zstdReadCloser adapts a *zstd.Decoder, whose Close does not return an error, to io.ReadCloser.
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+// Algorithm is a compression algorithm recognized by DetectCompressionFormat.
+// The zero value represents uncompressed data.
+type Algorithm struct {
+	name         string
+	prefix       []byte // Initial bytes of a stream compressed using this algorithm
+	decompressor DecompressorFunc
+}
+
+var (
+	// Gzip compression (RFC 1952).
+	Gzip = Algorithm{types.GzipCompression, []byte{0x1F, 0x8B, 0x08}, GzipDecompressor}
+	// Bzip2 compression (decompress.c:BZ2_decompress).
+	Bzip2 = Algorithm{types.Bzip2Compression, []byte{0x42, 0x5A, 0x68}, Bzip2Decompressor}
+	// Xz compression (/usr/share/doc/xz/xz-file-format.txt).
+	Xz = Algorithm{types.XzCompression, []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}, XzDecompressor}
+	// Zstd compression (RFC 8878).
+	Zstd = Algorithm{types.ZstdCompression, []byte{0x28, 0xB5, 0x2F, 0xFD}, ZstdDecompressor}
+)
+
+// compressionAlgos is an internal implementation detail of DetectCompressionFormat
+var compressionAlgos = []Algorithm{Gzip, Bzip2, Xz, Zstd}
+
+// Name returns the name of the algorithm, as used in types.BlobInfo.CompressionAlgorithm; "" for uncompressed data.
+func (c Algorithm) Name() string {
+	return c.name
+}
+
+// IsCompressed returns true if c is a compression algorithm, false if it represents uncompressed data.
+func (c Algorithm) IsCompressed() bool {
+	return c.decompressor != nil
+}
+
+// Decompressor returns a DecompressorFunc for c, or nil if c represents uncompressed data.
+func (c Algorithm) Decompressor() DecompressorFunc {
+	return c.decompressor
+}
+
+// DetectCompressionFormat returns the compression algorithm and a DecompressorFunc if the input is recognized as a compressed format,
+// the zero Algorithm and nil otherwise.
+// Because it consumes the start of input, other consumers must use the returned io.Reader instead to also read from the beginning.
+func DetectCompressionFormat(input io.Reader) (Algorithm, DecompressorFunc, io.Reader, error) {
+	buffer := [8]byte{}
+
+	n, err := io.ReadAtLeast(input, buffer[:], len(buffer))
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		// This is a “real” error. We could just ignore it this time, process the data we have, and hope that the source will report the same error again.
+		// Instead, fail immediately with the original error cause instead of a possibly secondary/misleading error returned later.
+		return Algorithm{}, nil, nil, err
+	}
+
+	var algorithm Algorithm
+	for _, algo := range compressionAlgos {
+		if bytes.HasPrefix(buffer[:n], algo.prefix) {
+			logrus.Debugf("Detected compression format %s", algo.name)
+			algorithm = algo
+			break
+		}
+	}
+	if !algorithm.IsCompressed() {
+		logrus.Debugf("No compression detected")
+	}
+
+	return algorithm, algorithm.decompressor, io.MultiReader(bytes.NewReader(buffer[:n]), input), nil
+}
+
+// DetectCompression returns a stream which contains the decompressed contents of input, and the compression algorithm
+// if input is recognized as a compressed format, or the zero Algorithm if input is not compressed, in which case the
+// returned stream contains input unmodified.
+// The caller must call Close() on the returned stream; that does not close input.
+func DetectCompression(input io.Reader) (io.ReadCloser, Algorithm, error) {
+	algorithm, decompressor, stream, err := DetectCompressionFormat(input)
+	if err != nil {
+		return nil, Algorithm{}, err
+	}
+	if decompressor == nil {
+		return ioutil.NopCloser(stream), algorithm, nil
+	}
+	res, err := decompressor(stream)
+	if err != nil {
+		return nil, Algorithm{}, fmt.Errorf("Error initializing %s decompression: %v", algorithm.name, err)
+	}
+	return res, algorithm, nil
+}
@@ -0,0 +1,106 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectCompressionFormat(t *testing.T) {
+	for _, c := range []struct {
+		filename  string
+		algorithm string
+	}{
+		{"fixtures/Hello.uncompressed", ""},
+		{"fixtures/Hello.gz", types.GzipCompression},
+		{"fixtures/Hello.bz2", types.Bzip2Compression},
+		{"fixtures/Hello.xz", types.XzCompression},
+	} {
+		originalContents, err := ioutil.ReadFile(c.filename)
+		require.NoError(t, err, c.filename)
+
+		stream, err := os.Open(c.filename)
+		require.NoError(t, err, c.filename)
+		defer stream.Close()
+
+		algorithm, decompressor, updatedStream, err := DetectCompressionFormat(stream)
+		require.NoError(t, err, c.filename)
+		assert.Equal(t, c.algorithm, algorithm.Name(), c.filename)
+		assert.Equal(t, c.algorithm != "", algorithm.IsCompressed(), c.filename)
+		assert.Equal(t, c.algorithm != "", decompressor != nil, c.filename)
+		assert.Equal(t, algorithm.Decompressor() != nil, decompressor != nil, c.filename)
+
+		// The original stream is preserved.
+		updatedContents, err := ioutil.ReadAll(updatedStream)
+		require.NoError(t, err, c.filename)
+		assert.Equal(t, originalContents, updatedContents, c.filename)
+	}
+
+	// Error reading input
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	writer.CloseWithError(errors.New("Expected error reading input in DetectCompressionFormat"))
+	_, _, _, err := DetectCompressionFormat(reader)
+	assert.Error(t, err)
+}
+
+func TestDetectCompression(t *testing.T) {
+	var zstdBuf bytes.Buffer
+	encoder, err := zstd.NewWriter(&zstdBuf)
+	require.NoError(t, err)
+	_, err = encoder.Write([]byte("Hello"))
+	require.NoError(t, err)
+	require.NoError(t, encoder.Close())
+
+	inputs := map[string][]byte{}
+	for _, filename := range []string{"fixtures/Hello.uncompressed", "fixtures/Hello.gz", "fixtures/Hello.bz2", "fixtures/Hello.xz"} {
+		contents, err := ioutil.ReadFile(filename)
+		require.NoError(t, err, filename)
+		inputs[filename] = contents
+	}
+	inputs["zstd"] = zstdBuf.Bytes()
+
+	// The input is decompressed using the right algorithm.
+	for name, c := range map[string]string{
+		"fixtures/Hello.uncompressed": "",
+		"fixtures/Hello.gz":           types.GzipCompression,
+		"fixtures/Hello.bz2":          types.Bzip2Compression,
+		"fixtures/Hello.xz":           types.XzCompression,
+		"zstd":                        types.ZstdCompression,
+	} {
+		stream, algorithm, err := DetectCompression(bytes.NewReader(inputs[name]))
+		require.NoError(t, err, name)
+		assert.Equal(t, c, algorithm.Name(), name)
+		contents, err := ioutil.ReadAll(stream)
+		require.NoError(t, err, name)
+		assert.Equal(t, []byte("Hello"), contents, name)
+		assert.NoError(t, stream.Close(), name)
+	}
+
+	// Empty input is handled reasonably.
+	stream, algorithm, err := DetectCompression(bytes.NewReader([]byte{}))
+	require.NoError(t, err)
+	assert.False(t, algorithm.IsCompressed())
+	contents, err := ioutil.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{}, contents)
+
+	// Error initializing decompression
+	_, _, err = DetectCompression(bytes.NewReader([]byte{0x1F, 0x8B, 0x08}))
+	assert.Error(t, err)
+
+	// Error reading input
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	writer.CloseWithError(errors.New("Expected error reading input in DetectCompression"))
+	_, _, err = DetectCompression(reader)
+	assert.Error(t, err)
+}
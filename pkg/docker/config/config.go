@@ -0,0 +1,379 @@
+// Package config reads and writes the registry credentials used by the docker transport,
+// stored in Docker-compatible authentication files (~/.docker/config.json or auth.json), credential helpers, or the kernel keyring.
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/types"
+	"github.com/docker/docker/pkg/homedir"
+)
+
+const (
+	dockerAuthRegistry = "https://index.docker.io/v1/"
+
+	dockerCfg         = ".docker"
+	dockerCfgFileName = "config.json"
+	dockerCfgObsolete = ".dockercfg"
+
+	// xdgRuntimeDirAuthFile is the path, relative to $XDG_RUNTIME_DIR, of the authentication file used by rootless tools, e.g. podman login.
+	xdgRuntimeDirAuthFile = "containers/auth.json"
+
+	// IdentityTokenUsername is the user name returned by GetAuthentication, and accepted by SetAuthentication,
+	// if the password is an identity token (an OAuth2 refresh token) instead of a real password.
+	IdentityTokenUsername = "<token>"
+)
+
+// ErrNotLoggedIn is returned when removing credentials for a registry which has none stored.
+var ErrNotLoggedIn = errors.New("not logged in")
+
+type dockerAuthConfig struct {
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	// Username and Password are only used if Auth is not set; they are written e.g. by kubectl in Kubernetes secrets.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type dockerConfigFile struct {
+	AuthConfigs map[string]dockerAuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore,omitempty"`  // Name of the credential helper used for all registries
+	CredHelpers map[string]string           `json:"credHelpers,omitempty"` // Registry -> name of the credential helper used for it
+}
+
+// GetAuthentication returns the username and password to use for registry (host[:port]) with ctx.
+// If there are no credentials for registry, it returns empty strings and no error.
+func GetAuthentication(ctx *types.SystemContext, registry string) (string, string, error) {
+	if ctx != nil && ctx.DockerAuthConfig != nil {
+		return ctx.DockerAuthConfig.Username, ctx.DockerAuthConfig.Password, nil
+	}
+	if ctx != nil && ctx.DockerUseKernelKeyring && ctx.DockerAuthSecret == nil {
+		username, password, err := GetCredentials(registry)
+		if err != nil {
+			return "", "", fmt.Errorf("Error reading credentials for %s from the kernel keyring: %v", registry, err)
+		}
+		if username != "" || password != "" {
+			return username, password, nil
+		}
+	}
+	if ctx != nil && ctx.DockerAuthSecret != nil {
+		dockerAuth, err := parseKubernetesSecret(ctx.DockerAuthSecret)
+		if err != nil {
+			return "", "", err
+		}
+		return findAuthentication(dockerAuth, registry)
+	}
+
+	for _, path := range authFilePaths(ctx) {
+		dockerAuth, err := readAuthFile(path.path, path.legacyFallback)
+		if err != nil {
+			return "", "", err
+		}
+		username, password, err := findAuthentication(dockerAuth, registry)
+		if err != nil {
+			return "", "", err
+		}
+		if username != "" || password != "" {
+			return username, password, nil
+		}
+	}
+	return "", "", nil
+}
+
+// findAuthentication returns the username and password for registry configured in dockerAuth, either directly
+// or using a credential helper.  If there are no credentials for registry, it returns empty strings and no error.
+func findAuthentication(dockerAuth *dockerConfigFile, registry string) (string, string, error) {
+	if helper := credentialHelperForRegistry(dockerAuth, registry); helper != "" {
+		return getAuthFromCredentialHelper(helper, registry)
+	}
+
+	// I'm feeling lucky
+	if c, exists := dockerAuth.AuthConfigs[registry]; exists {
+		return authFromConfigEntry(c)
+	}
+
+	// bad luck; let's normalize the entries first
+	registry = normalizeRegistry(registry)
+	normalizedAuths := map[string]dockerAuthConfig{}
+	for k, v := range dockerAuth.AuthConfigs {
+		normalizedAuths[normalizeRegistry(k)] = v
+	}
+	if c, exists := normalizedAuths[registry]; exists {
+		return authFromConfigEntry(c)
+	}
+	return "", "", nil
+}
+
+// SetAuthentication stores username and password for registry (host[:port]), replacing any credentials stored previously.
+// The credentials are stored in the kernel keyring if ctx.DockerUseKernelKeyring, otherwise by the credential helper
+// configured for registry in the authentication file, or in the authentication file itself.
+func SetAuthentication(ctx *types.SystemContext, registry, username, password string) error {
+	if ctx != nil && ctx.DockerUseKernelKeyring {
+		return SetCredentials(registry, username, password)
+	}
+	return modifyAuthFile(ctx, func(dockerAuth *dockerConfigFile) (bool, error) {
+		if helper := credentialHelperForRegistry(dockerAuth, registry); helper != "" {
+			return false, storeAuthInCredentialHelper(helper, registry, username, password)
+		}
+		removeAuthFileEntries(dockerAuth, registry)
+		entry := dockerAuthConfig{}
+		if username == IdentityTokenUsername {
+			entry.IdentityToken = password
+		} else {
+			entry.Auth = base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		}
+		dockerAuth.AuthConfigs[authFileKey(registry)] = entry
+		return true, nil
+	})
+}
+
+// RemoveAuthentication removes the credentials stored for registry (host[:port]) by SetAuthentication.
+// It returns ErrNotLoggedIn if there are no such credentials.
+func RemoveAuthentication(ctx *types.SystemContext, registry string) error {
+	if ctx != nil && ctx.DockerUseKernelKeyring {
+		err := DeleteCredentials(registry)
+		if err != ErrNotLoggedIn {
+			return err
+		}
+		// Otherwise try removing credentials stored without using the keyring.
+	}
+	return modifyAuthFile(ctx, func(dockerAuth *dockerConfigFile) (bool, error) {
+		if helper := credentialHelperForRegistry(dockerAuth, registry); helper != "" {
+			return false, eraseAuthFromCredentialHelper(helper, registry)
+		}
+		if !removeAuthFileEntries(dockerAuth, registry) {
+			return false, ErrNotLoggedIn
+		}
+		return true, nil
+	})
+}
+
+// authFilePath returns the path to the authentication file written with ctx: ctx.AuthFilePath if set, otherwise
+// $XDG_RUNTIME_DIR/containers/auth.json if XDG_RUNTIME_DIR is set (as for rootless users), otherwise ~/.docker/config.json.
+func authFilePath(ctx *types.SystemContext) string {
+	return authFilePaths(ctx)[0].path
+}
+
+// authPath is an authentication file which may be read.
+type authPath struct {
+	path           string
+	legacyFallback bool // If the file does not exist, read the legacy ~/.dockercfg file instead.
+}
+
+// authFilePaths returns the authentication files read with ctx, in order of precedence.
+// If ctx.AuthFilePath is set, only that file is used; otherwise both the file written by rootless tools
+// in $XDG_RUNTIME_DIR and the Docker configuration file are used, so that credentials stored by either podman login or docker login are found.
+func authFilePaths(ctx *types.SystemContext) []authPath {
+	if ctx != nil && ctx.AuthFilePath != "" {
+		return []authPath{{path: ctx.AuthFilePath}}
+	}
+	paths := []authPath{}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		paths = append(paths, authPath{path: filepath.Join(runtimeDir, xdgRuntimeDirAuthFile)})
+	}
+	paths = append(paths, authPath{path: filepath.Join(getDefaultConfigDir(dockerCfg), dockerCfgFileName), legacyFallback: true})
+	return paths
+}
+
+// readAuthFile returns the contents of the authentication file at dockerCfgPath.
+// A missing file is not an error; if legacyFallback, the legacy ~/.dockercfg file is used instead, if it exists.
+func readAuthFile(dockerCfgPath string, legacyFallback bool) (*dockerConfigFile, error) {
+	var dockerAuth dockerConfigFile
+	if _, err := os.Stat(dockerCfgPath); err == nil {
+		j, err := ioutil.ReadFile(dockerCfgPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(j, &dockerAuth); err != nil {
+			return nil, err
+		}
+
+	} else if os.IsNotExist(err) {
+		if !legacyFallback {
+			return &dockerAuth, nil
+		}
+		// try old config path
+		oldDockerCfgPath := filepath.Join(getDefaultConfigDir(dockerCfgObsolete))
+		if _, err := os.Stat(oldDockerCfgPath); err != nil {
+			if os.IsNotExist(err) {
+				return &dockerAuth, nil
+			}
+			return nil, fmt.Errorf("%s - %v", oldDockerCfgPath, err)
+		}
+
+		j, err := ioutil.ReadFile(oldDockerCfgPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(j, &dockerAuth.AuthConfigs); err != nil {
+			return nil, err
+		}
+
+	} else if err != nil {
+		return nil, fmt.Errorf("%s - %v", dockerCfgPath, err)
+	}
+	return &dockerAuth, nil
+}
+
+// modifyAuthFile calls editor on the contents of the authentication file used with ctx, while holding a lock on the file,
+// and writes the file back if editor returns true.  Fields of the file which are not relevant for authentication are preserved.
+func modifyAuthFile(ctx *types.SystemContext, editor func(dockerAuth *dockerConfigFile) (bool, error)) error {
+	path := authFilePath(ctx)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return fmt.Errorf("Error locking %s: %v", path, err)
+	}
+	defer unlockFile(lock)
+
+	contents := map[string]json.RawMessage{}
+	dockerAuth := dockerConfigFile{}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &contents); err != nil {
+			return fmt.Errorf("Error parsing %s: %v", path, err)
+		}
+		if err := json.Unmarshal(data, &dockerAuth); err != nil {
+			return fmt.Errorf("Error parsing %s: %v", path, err)
+		}
+	case os.IsNotExist(err):
+		break
+	default:
+		return err
+	}
+	if dockerAuth.AuthConfigs == nil {
+		dockerAuth.AuthConfigs = map[string]dockerAuthConfig{}
+	}
+
+	updated, err := editor(&dockerAuth)
+	if err != nil || !updated {
+		return err
+	}
+
+	auths, err := json.Marshal(dockerAuth.AuthConfigs)
+	if err != nil {
+		return err
+	}
+	contents["auths"] = auths
+	data, err = json.MarshalIndent(contents, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			os.Remove(tmpFile.Name())
+		}
+	}()
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return err
+	}
+	succeeded = true
+	return nil
+}
+
+// removeAuthFileEntries removes all entries for registry from dockerAuth.AuthConfigs, and returns true if there were any.
+func removeAuthFileEntries(dockerAuth *dockerConfigFile, registry string) bool {
+	normalized := normalizeRegistry(registry)
+	removed := false
+	for k := range dockerAuth.AuthConfigs {
+		if normalizeRegistry(k) == normalized {
+			delete(dockerAuth.AuthConfigs, k)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// authFileKey returns the key used to store credentials for registry in authentication files and credential helpers.
+func authFileKey(registry string) string {
+	if normalizeRegistry(registry) == "index.docker.io" {
+		return dockerAuthRegistry
+	}
+	return registry
+}
+
+func getDefaultConfigDir(confPath string) string {
+	return filepath.Join(homedir.Get(), confPath)
+}
+
+// authFromConfigEntry returns the username and password in an entry of the auths map of a Docker configuration file.
+// If the entry contains an identity token, IdentityTokenUsername and the token are returned instead.
+func authFromConfigEntry(c dockerAuthConfig) (string, string, error) {
+	if c.IdentityToken != "" {
+		return IdentityTokenUsername, c.IdentityToken, nil
+	}
+	if c.Auth == "" && c.Username != "" {
+		return c.Username, c.Password, nil
+	}
+	return decodeDockerAuth(c.Auth)
+}
+
+func decodeDockerAuth(s string) (string, string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		// if it's invalid just skip, as docker does
+		return "", "", nil
+	}
+	user := parts[0]
+	password := strings.Trim(parts[1], "\x00")
+	return user, password, nil
+}
+
+// convertToHostname converts a registry url which has http|https prepended
+// to just an hostname.
+// Copied from github.com/docker/docker/registry/auth.go
+func convertToHostname(url string) string {
+	stripped := url
+	if strings.HasPrefix(url, "http://") {
+		stripped = strings.TrimPrefix(url, "http://")
+	} else if strings.HasPrefix(url, "https://") {
+		stripped = strings.TrimPrefix(url, "https://")
+	}
+
+	nameParts := strings.SplitN(stripped, "/", 2)
+
+	return nameParts[0]
+}
+
+func normalizeRegistry(registry string) string {
+	normalized := convertToHostname(registry)
+	switch normalized {
+	case "registry-1.docker.io", "docker.io":
+		return "index.docker.io"
+	}
+	return normalized
+}
@@ -0,0 +1,615 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/docker/docker/pkg/homedir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAuth(t *testing.T) {
+	origHomeDir := homedir.Get()
+	tmpDir, err := ioutil.TempDir("", "test_docker_client_get_auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("using temporary home directory: %q", tmpDir)
+	// override homedir
+	os.Setenv(homedir.Key(), tmpDir)
+	defer func() {
+		err := os.RemoveAll(tmpDir)
+		if err != nil {
+			t.Logf("failed to cleanup temporary home directory %q: %v", tmpDir, err)
+		}
+		os.Setenv(homedir.Key(), origHomeDir)
+	}()
+
+	configDir := filepath.Join(tmpDir, ".docker")
+	if err := os.Mkdir(configDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(configDir, "config.json")
+
+	for _, tc := range []struct {
+		name             string
+		hostname         string
+		authConfig       testAuthConfig
+		expectedUsername string
+		expectedPassword string
+		expectedError    error
+		ctx              *types.SystemContext
+	}{
+		{
+			name:       "empty hostname",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{"localhost:5000": testAuthConfigData{"bob", "password"}}),
+		},
+		{
+			name:     "no auth config",
+			hostname: "index.docker.io",
+		},
+		{
+			name:             "match one",
+			hostname:         "example.org",
+			authConfig:       makeTestAuthConfig(testAuthConfigDataMap{"example.org": testAuthConfigData{"joe", "mypass"}}),
+			expectedUsername: "joe",
+			expectedPassword: "mypass",
+		},
+		{
+			name:       "match none",
+			hostname:   "registry.example.org",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{"example.org": testAuthConfigData{"joe", "mypass"}}),
+		},
+		{
+			name:     "match docker.io",
+			hostname: "docker.io",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"example.org":     testAuthConfigData{"example", "org"},
+				"index.docker.io": testAuthConfigData{"index", "docker.io"},
+				"docker.io":       testAuthConfigData{"docker", "io"},
+			}),
+			expectedUsername: "docker",
+			expectedPassword: "io",
+		},
+		{
+			name:     "match docker.io normalized",
+			hostname: "docker.io",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"example.org":                testAuthConfigData{"bob", "pw"},
+				"https://index.docker.io/v1": testAuthConfigData{"alice", "wp"},
+			}),
+			expectedUsername: "alice",
+			expectedPassword: "wp",
+		},
+		{
+			name:     "normalize registry",
+			hostname: "https://docker.io/v1",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"docker.io":      testAuthConfigData{"user", "pw"},
+				"localhost:5000": testAuthConfigData{"joe", "pass"},
+			}),
+			expectedUsername: "user",
+			expectedPassword: "pw",
+		},
+		{
+			name:     "match localhost",
+			hostname: "http://localhost",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"docker.io":   testAuthConfigData{"user", "pw"},
+				"localhost":   testAuthConfigData{"joe", "pass"},
+				"example.com": testAuthConfigData{"alice", "pwd"},
+			}),
+			expectedUsername: "joe",
+			expectedPassword: "pass",
+		},
+		{
+			name:     "match ip",
+			hostname: "10.10.3.56:5000",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"10.10.30.45":     testAuthConfigData{"user", "pw"},
+				"localhost":       testAuthConfigData{"joe", "pass"},
+				"10.10.3.56":      testAuthConfigData{"alice", "pwd"},
+				"10.10.3.56:5000": testAuthConfigData{"me", "mine"},
+			}),
+			expectedUsername: "me",
+			expectedPassword: "mine",
+		},
+		{
+			name:     "match port",
+			hostname: "https://localhost:5000",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"https://127.0.0.1:5000": testAuthConfigData{"user", "pw"},
+				"http://localhost":       testAuthConfigData{"joe", "pass"},
+				"https://localhost:5001": testAuthConfigData{"alice", "pwd"},
+				"localhost:5000":         testAuthConfigData{"me", "mine"},
+			}),
+			expectedUsername: "me",
+			expectedPassword: "mine",
+		},
+		{
+			name:     "use system context",
+			hostname: "example.org",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"example.org": testAuthConfigData{"user", "pw"},
+			}),
+			expectedUsername: "foo",
+			expectedPassword: "bar",
+			ctx: &types.SystemContext{
+				DockerAuthConfig: &types.DockerAuthConfig{
+					Username: "foo",
+					Password: "bar",
+				},
+			},
+		},
+	} {
+		contents, err := json.MarshalIndent(&tc.authConfig, "", "  ")
+		if err != nil {
+			t.Errorf("[%s] failed to marshal authConfig: %v", tc.name, err)
+			continue
+		}
+		if err := ioutil.WriteFile(configPath, contents, 0640); err != nil {
+			t.Errorf("[%s] failed to write file %q: %v", tc.name, configPath, err)
+			continue
+		}
+
+		var ctx *types.SystemContext
+		if tc.ctx != nil {
+			ctx = tc.ctx
+		}
+		username, password, err := GetAuthentication(ctx, tc.hostname)
+		if err == nil && tc.expectedError != nil {
+			t.Errorf("[%s] got unexpected non error and username=%q, password=%q", tc.name, username, password)
+			continue
+		}
+		if err != nil && tc.expectedError == nil {
+			t.Errorf("[%s] got unexpected error: %#+v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(err, tc.expectedError) {
+			t.Errorf("[%s] got unexpected error: %#+v != %#+v", tc.name, err, tc.expectedError)
+			continue
+		}
+
+		if username != tc.expectedUsername {
+			t.Errorf("[%s] got unexpected user name: %q != %q", tc.name, username, tc.expectedUsername)
+		}
+		if password != tc.expectedPassword {
+			t.Errorf("[%s] got unexpected user name: %q != %q", tc.name, password, tc.expectedPassword)
+		}
+	}
+}
+
+func TestGetAuthFromLegacyFile(t *testing.T) {
+	origHomeDir := homedir.Get()
+	tmpDir, err := ioutil.TempDir("", "test_docker_client_get_auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("using temporary home directory: %q", tmpDir)
+	// override homedir
+	os.Setenv(homedir.Key(), tmpDir)
+	defer func() {
+		err := os.RemoveAll(tmpDir)
+		if err != nil {
+			t.Logf("failed to cleanup temporary home directory %q: %v", tmpDir, err)
+		}
+		os.Setenv(homedir.Key(), origHomeDir)
+	}()
+
+	configPath := filepath.Join(tmpDir, ".dockercfg")
+
+	for _, tc := range []struct {
+		name             string
+		hostname         string
+		authConfig       testAuthConfig
+		expectedUsername string
+		expectedPassword string
+		expectedError    error
+	}{
+		{
+			name:     "normalize registry",
+			hostname: "https://docker.io/v1",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"docker.io":      testAuthConfigData{"user", "pw"},
+				"localhost:5000": testAuthConfigData{"joe", "pass"},
+			}),
+			expectedUsername: "user",
+			expectedPassword: "pw",
+		},
+		{
+			name:     "ignore schema and path",
+			hostname: "http://index.docker.io/v1",
+			authConfig: makeTestAuthConfig(testAuthConfigDataMap{
+				"docker.io/v2":         testAuthConfigData{"user", "pw"},
+				"https://localhost/v1": testAuthConfigData{"joe", "pwd"},
+			}),
+			expectedUsername: "user",
+			expectedPassword: "pw",
+		},
+	} {
+		contents, err := json.MarshalIndent(&tc.authConfig.Auths, "", "  ")
+		if err != nil {
+			t.Errorf("[%s] failed to marshal authConfig: %v", tc.name, err)
+			continue
+		}
+		if err := ioutil.WriteFile(configPath, contents, 0640); err != nil {
+			t.Errorf("[%s] failed to write file %q: %v", tc.name, configPath, err)
+			continue
+		}
+
+		username, password, err := GetAuthentication(nil, tc.hostname)
+		if err == nil && tc.expectedError != nil {
+			t.Errorf("[%s] got unexpected non error and username=%q, password=%q", tc.name, username, password)
+			continue
+		}
+		if err != nil && tc.expectedError == nil {
+			t.Errorf("[%s] got unexpected error: %#+v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(err, tc.expectedError) {
+			t.Errorf("[%s] got unexpected error: %#+v != %#+v", tc.name, err, tc.expectedError)
+			continue
+		}
+
+		if username != tc.expectedUsername {
+			t.Errorf("[%s] got unexpected user name: %q != %q", tc.name, username, tc.expectedUsername)
+		}
+		if password != tc.expectedPassword {
+			t.Errorf("[%s] got unexpected user name: %q != %q", tc.name, password, tc.expectedPassword)
+		}
+	}
+}
+
+func TestGetAuthPreferNewConfig(t *testing.T) {
+	origHomeDir := homedir.Get()
+	tmpDir, err := ioutil.TempDir("", "test_docker_client_get_auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("using temporary home directory: %q", tmpDir)
+	// override homedir
+	os.Setenv(homedir.Key(), tmpDir)
+	defer func() {
+		err := os.RemoveAll(tmpDir)
+		if err != nil {
+			t.Logf("failed to cleanup temporary home directory %q: %v", tmpDir, err)
+		}
+		os.Setenv(homedir.Key(), origHomeDir)
+	}()
+
+	configDir := filepath.Join(tmpDir, ".docker")
+	if err := os.Mkdir(configDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []struct {
+		path string
+		ac   interface{}
+	}{
+		{
+			filepath.Join(configDir, "config.json"),
+			makeTestAuthConfig(testAuthConfigDataMap{
+				"https://index.docker.io/v1/": testAuthConfigData{"alice", "pass"},
+			}),
+		},
+		{
+			filepath.Join(tmpDir, ".dockercfg"),
+			makeTestAuthConfig(testAuthConfigDataMap{
+				"https://index.docker.io/v1/": testAuthConfigData{"bob", "pw"},
+			}).Auths,
+		},
+	} {
+		contents, err := json.MarshalIndent(&data.ac, "", "  ")
+		if err != nil {
+			t.Fatalf("failed to marshal authConfig: %v", err)
+		}
+		if err := ioutil.WriteFile(data.path, contents, 0640); err != nil {
+			t.Fatalf("failed to write file %q: %v", data.path, err)
+		}
+	}
+
+	username, password, err := GetAuthentication(nil, "index.docker.io")
+	if err != nil {
+		t.Fatalf("got unexpected error: %#+v", err)
+	}
+
+	if username != "alice" {
+		t.Fatalf("got unexpected user name: %q != %q", username, "alice")
+	}
+	if password != "pass" {
+		t.Fatalf("got unexpected user name: %q != %q", password, "pass")
+	}
+}
+
+func TestGetAuthFailsOnBadInput(t *testing.T) {
+	origHomeDir := homedir.Get()
+	tmpDir, err := ioutil.TempDir("", "test_docker_client_get_auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("using temporary home directory: %q", tmpDir)
+	// override homedir
+	os.Setenv(homedir.Key(), tmpDir)
+	defer func() {
+		err := os.RemoveAll(tmpDir)
+		if err != nil {
+			t.Logf("failed to cleanup temporary home directory %q: %v", tmpDir, err)
+		}
+		os.Setenv(homedir.Key(), origHomeDir)
+	}()
+
+	configDir := filepath.Join(tmpDir, ".docker")
+	if err := os.Mkdir(configDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(configDir, "config.json")
+
+	// no config file present
+	username, password, err := GetAuthentication(nil, "index.docker.io")
+	if err != nil {
+		t.Fatalf("got unexpected error: %#+v", err)
+	}
+	if len(username) > 0 || len(password) > 0 {
+		t.Fatalf("got unexpected not empty username/password: %q/%q", username, password)
+	}
+
+	if err := ioutil.WriteFile(configPath, []byte("Json rocks! Unless it doesn't."), 0640); err != nil {
+		t.Fatalf("failed to write file %q: %v", configPath, err)
+	}
+	username, password, err = GetAuthentication(nil, "index.docker.io")
+	if err == nil {
+		t.Fatalf("got unexpected non-error: username=%q, password=%q", username, password)
+	}
+	if _, ok := err.(*json.SyntaxError); !ok {
+		t.Fatalf("expected os.PathError, not: %#+v", err)
+	}
+
+	// remove the invalid config file
+	os.RemoveAll(configPath)
+	// no config file present
+	username, password, err = GetAuthentication(nil, "index.docker.io")
+	if err != nil {
+		t.Fatalf("got unexpected error: %#+v", err)
+	}
+	if len(username) > 0 || len(password) > 0 {
+		t.Fatalf("got unexpected not empty username/password: %q/%q", username, password)
+	}
+
+	configPath = filepath.Join(tmpDir, ".dockercfg")
+	if err := ioutil.WriteFile(configPath, []byte("I'm certainly not a json string."), 0640); err != nil {
+		t.Fatalf("failed to write file %q: %v", configPath, err)
+	}
+	username, password, err = GetAuthentication(nil, "index.docker.io")
+	if err == nil {
+		t.Fatalf("got unexpected non-error: username=%q, password=%q", username, password)
+	}
+	if _, ok := err.(*json.SyntaxError); !ok {
+		t.Fatalf("expected os.PathError, not: %#+v", err)
+	}
+}
+
+type testAuthConfigData struct {
+	username string
+	password string
+}
+
+type testAuthConfigDataMap map[string]testAuthConfigData
+
+type testAuthConfigEntry struct {
+	Auth string `json:"auth,omitempty"`
+}
+
+type testAuthConfig struct {
+	Auths map[string]testAuthConfigEntry `json:"auths"`
+}
+
+// encodeAuth creates an auth value from given authConfig data to be stored in auth config file.
+// Inspired by github.com/docker/docker/cliconfig/config.go v1.10.3.
+func encodeAuth(authConfig *testAuthConfigData) string {
+	authStr := authConfig.username + ":" + authConfig.password
+	msg := []byte(authStr)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(msg)))
+	base64.StdEncoding.Encode(encoded, msg)
+	return string(encoded)
+}
+
+func makeTestAuthConfig(authConfigData map[string]testAuthConfigData) testAuthConfig {
+	ac := testAuthConfig{
+		Auths: make(map[string]testAuthConfigEntry),
+	}
+	for host, data := range authConfigData {
+		ac.Auths[host] = testAuthConfigEntry{
+			Auth: encodeAuth(&data),
+		}
+	}
+	return ac
+}
+
+func TestAuthFromConfigEntry(t *testing.T) {
+	username, password, err := authFromConfigEntry(dockerAuthConfig{Auth: base64.StdEncoding.EncodeToString([]byte("user:pass"))})
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+
+	username, password, err = authFromConfigEntry(dockerAuthConfig{Auth: base64.StdEncoding.EncodeToString([]byte("user:")), IdentityToken: "identity"})
+	require.NoError(t, err)
+	assert.Equal(t, IdentityTokenUsername, username)
+	assert.Equal(t, "identity", password)
+}
+
+func TestGetAuthFromKernelKeyring(t *testing.T) {
+	registry := fmt.Sprintf("keyring-test-%d.example.com", os.Getpid())
+	if err := SetCredentials(registry, "user", "pass"); err != nil {
+		t.Skipf("Kernel keyring not available: %v", err)
+	}
+	defer DeleteCredentials(registry)
+
+	username, password, err := GetAuthentication(&types.SystemContext{DockerUseKernelKeyring: true}, registry)
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+
+	// The keyring is only used if requested, and DockerAuthConfig takes precedence.
+	username, _, err = GetAuthentication(&types.SystemContext{}, registry)
+	require.NoError(t, err)
+	assert.Equal(t, "", username)
+	username, _, err = GetAuthentication(&types.SystemContext{
+		DockerUseKernelKeyring: true,
+		DockerAuthConfig:       &types.DockerAuthConfig{Username: "explicit", Password: "pass"},
+	}, registry)
+	require.NoError(t, err)
+	assert.Equal(t, "explicit", username)
+}
+
+func TestSetAndRemoveAuthentication(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "auth-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	authFile := filepath.Join(tmpDir, "subdir", "auth.json")
+	ctx := &types.SystemContext{AuthFilePath: authFile}
+
+	// A missing file is not an error.
+	username, password, err := GetAuthentication(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "", username)
+	assert.Equal(t, "", password)
+	err = RemoveAuthentication(ctx, "example.com")
+	assert.Equal(t, ErrNotLoggedIn, err)
+
+	for _, c := range []struct{ registry, username, password string }{
+		{"example.com", "user", "pass"},
+		{"docker.io", "hub-user", "hub:pass"},
+		{"identity.example.com", IdentityTokenUsername, "identity"},
+		{"example.com", "other", "secret"}, // Replaces the first entry
+	} {
+		err := SetAuthentication(ctx, c.registry, c.username, c.password)
+		require.NoError(t, err, c.registry)
+		username, password, err := GetAuthentication(ctx, c.registry)
+		require.NoError(t, err, c.registry)
+		assert.Equal(t, c.username, username, c.registry)
+		assert.Equal(t, c.password, password, c.registry)
+	}
+	fi, err := os.Stat(authFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	contents := struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}{}
+	data, err := ioutil.ReadFile(authFile)
+	require.NoError(t, err)
+	err = json.Unmarshal(data, &contents)
+	require.NoError(t, err)
+	keys := []string{}
+	for k := range contents.Auths {
+		keys = append(keys, k)
+	}
+	assert.Len(t, keys, 3)
+	assert.Contains(t, keys, "https://index.docker.io/v1/")
+
+	// Entries are removed, matching normalized registry names.
+	err = RemoveAuthentication(ctx, "registry-1.docker.io")
+	require.NoError(t, err)
+	username, _, err = GetAuthentication(ctx, "docker.io")
+	require.NoError(t, err)
+	assert.Equal(t, "", username)
+	err = RemoveAuthentication(ctx, "docker.io")
+	assert.Equal(t, ErrNotLoggedIn, err)
+	username, _, err = GetAuthentication(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "other", username)
+}
+
+func TestSetAuthenticationPreservesOtherFields(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "auth-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	authFile := filepath.Join(tmpDir, "config.json")
+	err = ioutil.WriteFile(authFile, []byte(`{"auths":{"other.example.com":{"auth":"dXNlcjpwYXNz"}},"HttpHeaders":{"X-Test":"value"}}`), 0600)
+	require.NoError(t, err)
+	ctx := &types.SystemContext{AuthFilePath: authFile}
+
+	err = SetAuthentication(ctx, "example.com", "user", "pass")
+	require.NoError(t, err)
+
+	contents := map[string]json.RawMessage{}
+	data, err := ioutil.ReadFile(authFile)
+	require.NoError(t, err)
+	err = json.Unmarshal(data, &contents)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"X-Test":"value"}`, string(contents["HttpHeaders"]))
+	username, password, err := GetAuthentication(ctx, "other.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+
+	err = ioutil.WriteFile(authFile, []byte("invalid"), 0600)
+	require.NoError(t, err)
+	err = SetAuthentication(ctx, "example.com", "user", "pass")
+	assert.Error(t, err)
+}
+
+func TestGetAuthFromXDGRuntimeDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "auth-xdg")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	homeDir := filepath.Join(tmpDir, "home")
+	runtimeDir := filepath.Join(tmpDir, "run")
+	origHomeDir := homedir.Get()
+	os.Setenv(homedir.Key(), homeDir)
+	defer os.Setenv(homedir.Key(), origHomeDir)
+	origRuntimeDir, hadRuntimeDir := os.LookupEnv("XDG_RUNTIME_DIR")
+	os.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	defer func() {
+		if hadRuntimeDir {
+			os.Setenv("XDG_RUNTIME_DIR", origRuntimeDir)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	}()
+
+	dockerConfigPath := filepath.Join(homeDir, ".docker", "config.json")
+	runtimeAuthPath := filepath.Join(runtimeDir, "containers", "auth.json")
+	// Credentials written by docker login
+	err = SetAuthentication(&types.SystemContext{AuthFilePath: dockerConfigPath}, "docker.example.com", "docker-user", "docker-pass")
+	require.NoError(t, err)
+	err = SetAuthentication(&types.SystemContext{AuthFilePath: dockerConfigPath}, "both.example.com", "docker-user", "docker-pass")
+	require.NoError(t, err)
+	// Credentials written by podman login, which uses the default path
+	assert.Equal(t, runtimeAuthPath, authFilePath(nil))
+	err = SetAuthentication(nil, "both.example.com", "podman-user", "podman-pass")
+	require.NoError(t, err)
+	_, err = os.Stat(runtimeAuthPath)
+	require.NoError(t, err)
+
+	for _, c := range []struct{ registry, username, password string }{
+		{"docker.example.com", "docker-user", "docker-pass"},
+		{"both.example.com", "podman-user", "podman-pass"}, // The rootless file takes precedence
+		{"unknown.example.com", "", ""},
+	} {
+		username, password, err := GetAuthentication(nil, c.registry)
+		require.NoError(t, err, c.registry)
+		assert.Equal(t, c.username, username, c.registry)
+		assert.Equal(t, c.password, password, c.registry)
+	}
+
+	// An explicit AuthFilePath is the only file used.
+	username, _, err := GetAuthentication(&types.SystemContext{AuthFilePath: filepath.Join(tmpDir, "missing.json")}, "docker.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "", username)
+
+	// Without XDG_RUNTIME_DIR, ~/.docker/config.json is the default.
+	os.Unsetenv("XDG_RUNTIME_DIR")
+	assert.Equal(t, dockerConfigPath, authFilePath(nil))
+	username, _, err = GetAuthentication(nil, "both.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "docker-user", username)
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+const (
+	// credentialHelperPrefix is the prefix of names of credential helper executables, e.g. docker-credential-osxkeychain.
+	credentialHelperPrefix = "docker-credential-"
+	// credentialsNotFoundMessage is the output of a credential helper which does not know any credentials for the requested server.
+	credentialsNotFoundMessage = "credentials not found in native keychain"
+)
+
+// credentialHelperCredentials is the output of the "get" action, and the input of the "store" action, of a credential helper.
+type credentialHelperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// errCredentialsNotFound is returned by runCredentialHelper if the helper does not know any credentials for the server.
+var errCredentialsNotFound = errors.New(credentialsNotFoundMessage)
+
+// credentialHelperForRegistry returns the name of the credential helper configured in dockerAuth for registry, or "" if none.
+// Helpers configured for the specific registry in credHelpers take precedence over the default credsStore, as in the Docker CLI;
+// the registry is looked up as given, then using the key the Docker CLI uses (e.g. https://index.docker.io/v1/ for docker.io),
+// and finally among the other keys referring to the same registry, in sorted order so that the choice does not depend on map iteration.
+func credentialHelperForRegistry(dockerAuth *dockerConfigFile, registry string) string {
+	for _, key := range []string{registry, authFileKey(registry)} {
+		if helper, ok := dockerAuth.CredHelpers[key]; ok {
+			return helper
+		}
+	}
+	normalized := normalizeRegistry(registry)
+	keys := make([]string, 0, len(dockerAuth.CredHelpers))
+	for k := range dockerAuth.CredHelpers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if normalizeRegistry(k) == normalized {
+			return dockerAuth.CredHelpers[k]
+		}
+	}
+	return dockerAuth.CredsStore
+}
+
+// runCredentialHelper runs the docker-credential-$helper executable to perform action, with input on its standard input, and returns its output.
+func runCredentialHelper(helper, action string, input []byte) ([]byte, error) {
+	helperName := credentialHelperPrefix + helper
+	cmd := exec.Command(helperName, action)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String())
+		if output == credentialsNotFoundMessage {
+			return nil, errCredentialsNotFound
+		}
+		if output != "" {
+			return nil, fmt.Errorf("Error running %s %s: %s", helperName, action, output)
+		}
+		return nil, fmt.Errorf("Error running %s %s: %v", helperName, action, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// getAuthFromCredentialHelper returns the username and password for registry stored by the docker-credential-$helper executable.
+// If the helper has no credentials for registry, it returns empty strings and no error.
+func getAuthFromCredentialHelper(helper, registry string) (string, string, error) {
+	output, err := runCredentialHelper(helper, "get", []byte(authFileKey(registry)))
+	if err != nil {
+		if err == errCredentialsNotFound {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("Error getting credentials for %s: %v", registry, err)
+	}
+	var res credentialHelperCredentials
+	if err := json.Unmarshal(output, &res); err != nil {
+		return "", "", fmt.Errorf("Error parsing credentials for %s from %s%s: %v", registry, credentialHelperPrefix, helper, err)
+	}
+	return res.Username, res.Secret, nil
+}
+
+// storeAuthInCredentialHelper stores username and password for registry using the docker-credential-$helper executable.
+func storeAuthInCredentialHelper(helper, registry, username, password string) error {
+	input, err := json.Marshal(credentialHelperCredentials{
+		ServerURL: authFileKey(registry),
+		Username:  username,
+		Secret:    password,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := runCredentialHelper(helper, "store", input); err != nil {
+		return fmt.Errorf("Error storing credentials for %s: %v", registry, err)
+	}
+	return nil
+}
+
+// eraseAuthFromCredentialHelper removes the credentials for registry stored by the docker-credential-$helper executable.
+// It returns ErrNotLoggedIn if there are no such credentials.
+func eraseAuthFromCredentialHelper(helper, registry string) error {
+	if _, err := runCredentialHelper(helper, "erase", []byte(authFileKey(registry))); err != nil {
+		if err == errCredentialsNotFound {
+			return ErrNotLoggedIn
+		}
+		return fmt.Errorf("Error removing credentials for %s: %v", registry, err)
+	}
+	return nil
+}
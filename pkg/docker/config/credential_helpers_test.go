@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/docker/docker/pkg/homedir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCredentialHelper = `#!/bin/sh
+read server
+case "$server" in
+registry.example.com) echo '{"ServerURL":"registry.example.com","Username":"helper-user","Secret":"helper-secret"}' ;;
+https://index.docker.io/v1/) echo '{"ServerURL":"https://index.docker.io/v1/","Username":"hub-user","Secret":"hub-secret"}' ;;
+*) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+
+func TestGetAuthFromCredentialHelpers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "credential-helpers")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	binDir := filepath.Join(tmpDir, "bin")
+	err = os.Mkdir(binDir, 0755)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(binDir, "docker-credential-test"), []byte(testCredentialHelper), 0755)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(binDir, "docker-credential-broken"), []byte("#!/bin/sh\necho failed >&2\nexit 1\n"), 0755)
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+origPath)
+	defer os.Setenv("PATH", origPath)
+
+	origHomeDir := homedir.Get()
+	os.Setenv(homedir.Key(), tmpDir)
+	defer os.Setenv(homedir.Key(), origHomeDir)
+	err = os.Mkdir(filepath.Join(tmpDir, ".docker"), 0755)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(tmpDir, ".docker", "config.json"), []byte(`{
+	"auths": {"other.example.com": {"auth": "dXNlcjpwYXNz"}},
+	"credsStore": "test",
+	"credHelpers": {"broken.example.com": "broken", "missing.example.com": "does-not-exist"}
+}`), 0644)
+	require.NoError(t, err)
+
+	for _, c := range []struct{ registry, username, password string }{
+		{"registry.example.com", "helper-user", "helper-secret"},
+		{"docker.io", "hub-user", "hub-secret"},
+		{"other.example.com", "", ""}, // The credsStore takes precedence over auths
+	} {
+		username, password, err := GetAuthentication(nil, c.registry)
+		require.NoError(t, err, c.registry)
+		assert.Equal(t, c.username, username, c.registry)
+		assert.Equal(t, c.password, password, c.registry)
+	}
+
+	for _, registry := range []string{"broken.example.com", "missing.example.com"} {
+		_, _, err := GetAuthentication(nil, registry)
+		assert.Error(t, err, registry)
+	}
+}
+
+func TestCredentialHelperForRegistry(t *testing.T) {
+	dockerAuth := dockerConfigFile{
+		CredsStore:  "default",
+		CredHelpers: map[string]string{"gcr.io": "gcr", "https://index.docker.io/v1/": "hub"},
+	}
+	for _, c := range []struct{ registry, expected string }{
+		{"gcr.io", "gcr"},
+		{"docker.io", "hub"},
+		{"example.com", "default"},
+	} {
+		assert.Equal(t, c.expected, credentialHelperForRegistry(&dockerAuth, c.registry), c.registry)
+	}
+	assert.Equal(t, "", credentialHelperForRegistry(&dockerConfigFile{}, "example.com"))
+
+	// The key used by the Docker CLI wins over other keys referring to the same registry, and the choice among those is deterministic.
+	dockerAuth = dockerConfigFile{
+		CredsStore: "default",
+		CredHelpers: map[string]string{
+			"https://index.docker.io/v1/": "hub",
+			"registry-1.docker.io":        "registry-1",
+			"index.docker.io":             "index",
+			"https://quay.io":             "quay-url",
+			"quay.io:443":                 "quay-port",
+			"http://quay.io/v1/":          "quay-http",
+		},
+	}
+	for _, c := range []struct{ registry, expected string }{
+		{"docker.io", "hub"},
+		{"registry-1.docker.io", "registry-1"},
+		{"quay.io", "quay-http"},
+		{"quay.io:443", "quay-port"},
+	} {
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, c.expected, credentialHelperForRegistry(&dockerAuth, c.registry), c.registry)
+		}
+	}
+}
+
+func TestSetAndRemoveAuthenticationWithCredentialHelper(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "credential-helpers")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	stored := filepath.Join(tmpDir, "stored")
+	helper := fmt.Sprintf(`#!/bin/sh
+case "$1" in
+get) if [ -f %[1]s ]; then cat %[1]s; else echo "credentials not found in native keychain"; exit 1; fi ;;
+store) cat > %[1]s ;;
+erase) if [ -f %[1]s ]; then rm %[1]s; else echo "credentials not found in native keychain"; exit 1; fi ;;
+esac
+`, stored)
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "docker-credential-store"), []byte(helper), 0755)
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", tmpDir+string(os.PathListSeparator)+origPath)
+	defer os.Setenv("PATH", origPath)
+
+	authFile := filepath.Join(tmpDir, "config.json")
+	err = ioutil.WriteFile(authFile, []byte(`{"credsStore":"store"}`), 0600)
+	require.NoError(t, err)
+	ctx := &types.SystemContext{AuthFilePath: authFile}
+
+	err = SetAuthentication(ctx, "docker.io", "user", "pass")
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(stored)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ServerURL":"https://index.docker.io/v1/","Username":"user","Secret":"pass"}`, string(data))
+	username, password, err := GetAuthentication(ctx, "docker.io")
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+	data, err = ioutil.ReadFile(authFile)
+	require.NoError(t, err)
+	assert.Equal(t, `{"credsStore":"store"}`, string(data)) // The file is not modified
+
+	err = RemoveAuthentication(ctx, "docker.io")
+	require.NoError(t, err)
+	err = RemoveAuthentication(ctx, "docker.io")
+	assert.Equal(t, ErrNotLoggedIn, err)
+}
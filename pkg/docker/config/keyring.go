@@ -0,0 +1,29 @@
+package config
+
+import (
+	"errors"
+)
+
+// ErrKeyringNotSupported is returned when the kernel keyring is not available on this platform.
+var ErrKeyringNotSupported = errors.New("Kernel keyring is not supported on this platform")
+
+// keyringKeyPrefix is prepended to the registry name to form the name of the kernel keyring key containing its credentials.
+const keyringKeyPrefix = "container-registry-login:"
+
+// GetCredentials returns the username and password stored for registry (host[:port]) in the kernel keyring.
+// If there are no credentials for registry, it returns empty strings and no error.
+func GetCredentials(registry string) (string, string, error) {
+	return getCredentialsFromKeyring(registry)
+}
+
+// SetCredentials stores username and password for registry (host[:port]) in the kernel keyring,
+// replacing any credentials stored previously.
+func SetCredentials(registry, username, password string) error {
+	return setCredentialsInKeyring(registry, username, password)
+}
+
+// DeleteCredentials removes the credentials stored for registry (host[:port]) from the kernel keyring.
+// It returns ErrNotLoggedIn if there are no such credentials.
+func DeleteCredentials(registry string) error {
+	return deleteCredentialsFromKeyring(registry)
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/containers/image/pkg/keyctl"
+)
+
+// getCredentialsFromKeyring implements GetCredentials.
+func getCredentialsFromKeyring(registry string) (string, string, error) {
+	keyring, err := keyctl.UserKeyring()
+	if err != nil {
+		return "", "", err
+	}
+	key, err := keyring.Search(keyringKeyPrefix + registry)
+	if err != nil {
+		if err == syscall.ENOKEY {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	data, err := key.Get()
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("Invalid credentials for %s in the kernel keyring", registry)
+	}
+	return parts[0], parts[1], nil
+}
+
+// setCredentialsInKeyring implements SetCredentials.
+func setCredentialsInKeyring(registry, username, password string) error {
+	if strings.Contains(username, ":") {
+		return fmt.Errorf("Invalid username %q: must not contain a colon", username)
+	}
+	keyring, err := keyctl.UserKeyring()
+	if err != nil {
+		return err
+	}
+	_, err = keyring.Add(keyringKeyPrefix+registry, []byte(username+":"+password))
+	return err
+}
+
+// deleteCredentialsFromKeyring implements DeleteCredentials.
+func deleteCredentialsFromKeyring(registry string) error {
+	keyring, err := keyctl.UserKeyring()
+	if err != nil {
+		return err
+	}
+	key, err := keyring.Search(keyringKeyPrefix + registry)
+	if err != nil {
+		if err == syscall.ENOKEY {
+			return ErrNotLoggedIn
+		}
+		return err
+	}
+	return key.Unlink()
+}
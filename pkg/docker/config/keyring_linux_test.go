@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/containers/image/pkg/keyctl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyringCredentials(t *testing.T) {
+	if _, err := keyctl.UserKeyring(); err != nil {
+		t.Skipf("Kernel keyring not available: %v", err)
+	}
+	registry := fmt.Sprintf("keyring-test-%d.example.com", os.Getpid())
+
+	username, password, err := GetCredentials(registry)
+	require.NoError(t, err)
+	assert.Equal(t, "", username)
+	assert.Equal(t, "", password)
+
+	err = SetCredentials(registry, "user", "pass:with:colons")
+	require.NoError(t, err)
+	username, password, err = GetCredentials(registry)
+	require.NoError(t, err)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass:with:colons", password)
+
+	err = SetCredentials(registry, "other", "secret")
+	require.NoError(t, err)
+	username, password, err = GetCredentials(registry)
+	require.NoError(t, err)
+	assert.Equal(t, "other", username)
+	assert.Equal(t, "secret", password)
+
+	err = SetCredentials(registry, "invalid:user", "secret")
+	assert.Error(t, err)
+
+	err = DeleteCredentials(registry)
+	require.NoError(t, err)
+	username, password, err = GetCredentials(registry)
+	require.NoError(t, err)
+	assert.Equal(t, "", username)
+	assert.Equal(t, "", password)
+	err = DeleteCredentials(registry)
+	assert.Equal(t, ErrNotLoggedIn, err)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package config
+
+// getCredentialsFromKeyring implements GetCredentials.
+func getCredentialsFromKeyring(registry string) (string, string, error) {
+	return "", "", ErrKeyringNotSupported
+}
+
+// setCredentialsInKeyring implements SetCredentials.
+func setCredentialsInKeyring(registry, username, password string) error {
+	return ErrKeyringNotSupported
+}
+
+// deleteCredentialsFromKeyring implements DeleteCredentials.
+func deleteCredentialsFromKeyring(registry string) error {
+	return ErrKeyringNotSupported
+}
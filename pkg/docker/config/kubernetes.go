@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// kubernetesSecretKind is the kind of Kubernetes objects containing secrets.
+	kubernetesSecretKind = "Secret"
+	// kubernetesDockerConfigJSONKey is the data key of kubernetes.io/dockerconfigjson secrets, in the ~/.docker/config.json format.
+	kubernetesDockerConfigJSONKey = ".dockerconfigjson"
+	// kubernetesDockerCfgKey is the data key of kubernetes.io/dockercfg secrets, in the legacy ~/.dockercfg format.
+	kubernetesDockerCfgKey = ".dockercfg"
+)
+
+// kubernetesSecret is the subset of a Kubernetes Secret object relevant for registry credentials.
+type kubernetesSecret struct {
+	Kind string            `json:"kind"`
+	Type string            `json:"type"`
+	Data map[string][]byte `json:"data"` // Values are base64-encoded in JSON, which encoding/json decodes for []byte
+}
+
+// parseKubernetesSecret parses registry credentials in data, which is either the contents of a
+// kubernetes.io/dockerconfigjson (.dockerconfigjson) or kubernetes.io/dockercfg (.dockercfg) secret,
+// or a Kubernetes Secret object of one of these types.
+func parseKubernetesSecret(data []byte) (*dockerConfigFile, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("Error parsing Kubernetes secret: %v", err)
+	}
+
+	if _, ok := fields["kind"]; ok {
+		secret := kubernetesSecret{}
+		if err := json.Unmarshal(data, &secret); err != nil {
+			return nil, fmt.Errorf("Error parsing Kubernetes secret: %v", err)
+		}
+		if secret.Kind != kubernetesSecretKind {
+			return nil, fmt.Errorf("Error parsing Kubernetes secret: unexpected object kind %q", secret.Kind)
+		}
+		if contents, ok := secret.Data[kubernetesDockerConfigJSONKey]; ok {
+			return parseKubernetesSecret(contents)
+		}
+		if contents, ok := secret.Data[kubernetesDockerCfgKey]; ok {
+			return parseKubernetesSecret(contents)
+		}
+		return nil, fmt.Errorf("Error parsing Kubernetes secret: secret of type %q does not contain %s or %s", secret.Type, kubernetesDockerConfigJSONKey, kubernetesDockerCfgKey)
+	}
+
+	dockerAuth := dockerConfigFile{}
+	if _, ok := fields["auths"]; ok { // .dockerconfigjson
+		if err := json.Unmarshal(data, &dockerAuth); err != nil {
+			return nil, fmt.Errorf("Error parsing Kubernetes secret: %v", err)
+		}
+	} else { // .dockercfg
+		if err := json.Unmarshal(data, &dockerAuth.AuthConfigs); err != nil {
+			return nil, fmt.Errorf("Error parsing Kubernetes secret: %v", err)
+		}
+	}
+	// Credentials in secrets are always stored inline.
+	dockerAuth.CredsStore = ""
+	dockerAuth.CredHelpers = nil
+	return &dockerAuth, nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAuthenticationFromKubernetesSecret(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	dockerConfigJSON := fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":%q},"https://index.docker.io/v1/":{"username":"hub-user","password":"hub-pass","email":"user@example.com"}},"credsStore":"ignored"}`, auth)
+	dockerCfg := fmt.Sprintf(`{"registry.example.com":{"auth":%q,"email":"user@example.com"},"docker.io":{"username":"hub-user","password":"hub-pass"}}`, auth)
+
+	for _, secret := range []string{
+		dockerConfigJSON,
+		dockerCfg,
+		fmt.Sprintf(`{"apiVersion":"v1","kind":"Secret","type":"kubernetes.io/dockerconfigjson","data":{".dockerconfigjson":%q}}`,
+			base64.StdEncoding.EncodeToString([]byte(dockerConfigJSON))),
+		fmt.Sprintf(`{"apiVersion":"v1","kind":"Secret","type":"kubernetes.io/dockercfg","data":{".dockercfg":%q}}`,
+			base64.StdEncoding.EncodeToString([]byte(dockerCfg))),
+	} {
+		ctx := &types.SystemContext{DockerAuthSecret: []byte(secret)}
+		for _, c := range []struct{ registry, username, password string }{
+			{"registry.example.com", "user", "pass"},
+			{"docker.io", "hub-user", "hub-pass"},
+			{"other.example.com", "", ""},
+		} {
+			username, password, err := GetAuthentication(ctx, c.registry)
+			require.NoError(t, err, secret)
+			assert.Equal(t, c.username, username, "%s %s", secret, c.registry)
+			assert.Equal(t, c.password, password, "%s %s", secret, c.registry)
+		}
+	}
+
+	for _, secret := range []string{
+		"",
+		"invalid",
+		`{"kind":"ConfigMap","data":{}}`,
+		`{"kind":"Secret","type":"Opaque","data":{"password":"cGFzcw=="}}`,
+		`{"kind":"Secret","data":{".dockerconfigjson":"not base64"}}`,
+		`{"auths":"invalid"}`,
+	} {
+		_, _, err := GetAuthentication(&types.SystemContext{DockerAuthSecret: []byte(secret)}, "registry.example.com")
+		assert.Error(t, err, secret)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile acquires an exclusive lock on f, waiting until it is available.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFile releases a lock acquired by lockFile.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+// lockfileExclusiveLock is LOCKFILE_EXCLUSIVE_LOCK from the Windows API.
+const lockfileExclusiveLock = 0x2
+
+// lockFile acquires an exclusive lock on f, waiting until it is available.
+func lockFile(f *os.File) error {
+	ol := syscall.Overlapped{}
+	// Lock the largest possible range, i.e. the whole file.
+	r1, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, ^uintptr(0)&0xffffffff, ^uintptr(0)&0xffffffff, uintptr(unsafe.Pointer(&ol)))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+// unlockFile releases a lock acquired by lockFile.
+func unlockFile(f *os.File) error {
+	ol := syscall.Overlapped{}
+	r1, _, err := procUnlockFileEx.Call(f.Fd(), 0, ^uintptr(0)&0xffffffff, ^uintptr(0)&0xffffffff, uintptr(unsafe.Pointer(&ol)))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
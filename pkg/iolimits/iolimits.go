@@ -0,0 +1,51 @@
+// Package iolimits limits the amount of data read into memory from untrusted sources, e.g. manifests and configs
+// returned by a registry, so that a malicious source can not make us run out of memory.
+package iolimits
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/containers/image/types"
+)
+
+const (
+	// defaultMaxManifestSize is used unless overridden by types.SystemContext.MaxManifestSize.
+	defaultMaxManifestSize = 4 * 1024 * 1024
+	// defaultMaxConfigBlobSize is used unless overridden by types.SystemContext.MaxConfigBlobSize.
+	defaultMaxConfigBlobSize = 4 * 1024 * 1024
+)
+
+// ErrTooLarge is returned (possibly wrapped) when the data read from a source exceeds the applicable limit.
+var ErrTooLarge = errors.New("data too large")
+
+// MaxManifestSize returns the maximum size of a manifest read into memory, as configured by sys (which may be nil).
+func MaxManifestSize(sys *types.SystemContext) int64 {
+	if sys != nil && sys.MaxManifestSize != 0 {
+		return sys.MaxManifestSize
+	}
+	return defaultMaxManifestSize
+}
+
+// MaxConfigBlobSize returns the maximum size of a config blob read into memory, as configured by sys (which may be nil).
+func MaxConfigBlobSize(sys *types.SystemContext) int64 {
+	if sys != nil && sys.MaxConfigBlobSize != 0 {
+		return sys.MaxConfigBlobSize
+	}
+	return defaultMaxConfigBlobSize
+}
+
+// ReadAtMost reads all of reader, and returns the data, or an error wrapping ErrTooLarge if reader contains more than limit bytes.
+// It never reads more than limit+1 bytes from reader.
+func ReadAtMost(reader io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w (more than %d bytes)", ErrTooLarge, limit)
+	}
+	return data, nil
+}
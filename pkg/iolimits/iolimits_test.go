@@ -0,0 +1,39 @@
+package iolimits
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxManifestSize(t *testing.T) {
+	assert.Equal(t, int64(defaultMaxManifestSize), MaxManifestSize(nil))
+	assert.Equal(t, int64(defaultMaxManifestSize), MaxManifestSize(&types.SystemContext{}))
+	assert.Equal(t, int64(1234), MaxManifestSize(&types.SystemContext{MaxManifestSize: 1234}))
+}
+
+func TestMaxConfigBlobSize(t *testing.T) {
+	assert.Equal(t, int64(defaultMaxConfigBlobSize), MaxConfigBlobSize(nil))
+	assert.Equal(t, int64(defaultMaxConfigBlobSize), MaxConfigBlobSize(&types.SystemContext{}))
+	assert.Equal(t, int64(1234), MaxConfigBlobSize(&types.SystemContext{MaxConfigBlobSize: 1234}))
+}
+
+func TestReadAtMost(t *testing.T) {
+	data := []byte("0123456789")
+	for _, limit := range []int64{10, 11, 1000} {
+		res, err := ReadAtMost(bytes.NewReader(data), limit)
+		require.NoError(t, err, limit)
+		assert.Equal(t, data, res, limit)
+	}
+
+	for _, limit := range []int64{0, 9} {
+		reader := bytes.NewReader(data)
+		_, err := ReadAtMost(reader, limit)
+		assert.True(t, errors.Is(err, ErrTooLarge), "%d: %v", limit, err)
+		assert.Equal(t, int64(len(data))-(limit+1), int64(reader.Len()), limit) // Only limit+1 bytes are consumed
+	}
+}
@@ -0,0 +1,130 @@
+// Package keyctl provides access to the Linux kernel key retention service (the kernel keyring).
+package keyctl
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// keyType is the kernel key type used for all keys handled by this package: a blob of arbitrary user data.
+const keyType = "user"
+
+// Special keyring IDs, see keyctl(2).
+const (
+	keySpecSessionKeyring keyID = -3
+	keySpecUserKeyring    keyID = -4
+)
+
+// keyctl(2) operations.
+const (
+	keyctlGetKeyringID = 0
+	keyctlUnlink       = 9
+	keyctlSearch       = 10
+	keyctlRead         = 11
+)
+
+// keyID is a kernel key or keyring serial number.
+type keyID int32
+
+// Keyring is a kernel keyring.
+type Keyring struct {
+	id keyID
+}
+
+// Key is a key stored in a kernel keyring.
+type Key struct {
+	Name string
+	id   keyID
+	ring keyID
+}
+
+// SessionKeyring returns the session keyring of the current process, creating it if necessary.
+func SessionKeyring() (*Keyring, error) {
+	return getKeyring(keySpecSessionKeyring)
+}
+
+// UserKeyring returns the keyring of the current user, shared by all of the user's processes.
+func UserKeyring() (*Keyring, error) {
+	return getKeyring(keySpecUserKeyring)
+}
+
+// getKeyring returns the keyring identified by the special keyring ID id.
+func getKeyring(id keyID) (*Keyring, error) {
+	realID, err := keyctl(keyctlGetKeyringID, uintptr(id), 1)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{id: keyID(realID)}, nil
+}
+
+// Add stores data in the keyring as a key named name, replacing the contents of an existing key with the same name.
+func (kr *Keyring) Add(name string, data []byte) (*Key, error) {
+	typ, err := syscall.BytePtrFromString(keyType)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	var payload unsafe.Pointer
+	if len(data) > 0 {
+		payload = unsafe.Pointer(&data[0])
+	}
+	id, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY, uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(desc)),
+		uintptr(payload), uintptr(len(data)), uintptr(kr.id), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	return &Key{Name: name, id: keyID(id), ring: kr.id}, nil
+}
+
+// Search returns the key named name in the keyring, or an error (syscall.ENOKEY if the key does not exist).
+func (kr *Keyring) Search(name string) (*Key, error) {
+	typ, err := syscall.BytePtrFromString(keyType)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := keyctl(keyctlSearch, uintptr(kr.id), uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(desc)), 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{Name: name, id: keyID(id), ring: kr.id}, nil
+}
+
+// Get returns the contents of the key.
+func (k *Key) Get() ([]byte, error) {
+	size := 512
+	for {
+		buf := make([]byte, size)
+		n, err := keyctl(keyctlRead, uintptr(k.id), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+		if err != nil {
+			return nil, err
+		}
+		if int(n) <= len(buf) {
+			return buf[:n], nil
+		}
+		size = int(n) // The key is larger than the buffer, try again with the size reported by the kernel.
+	}
+}
+
+// Unlink removes the key from the keyring it was found in or added to.
+func (k *Key) Unlink() error {
+	_, err := keyctl(keyctlUnlink, uintptr(k.id), uintptr(k.ring))
+	return err
+}
+
+// keyctl calls the keyctl(2) system call with operation op and up to four arguments.
+func keyctl(op int, args ...uintptr) (uintptr, error) {
+	a := [4]uintptr{}
+	copy(a[:], args)
+	res, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, uintptr(op), a[0], a[1], a[2], a[3], 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return res, nil
+}
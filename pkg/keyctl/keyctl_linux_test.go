@@ -0,0 +1,41 @@
+package keyctl
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	keyring, err := SessionKeyring()
+	if err == syscall.ENOSYS || err == syscall.EPERM || err == syscall.EACCES {
+		t.Skipf("Kernel keyring not available: %v", err)
+	}
+	require.NoError(t, err)
+
+	name := fmt.Sprintf("containers-image-keyctl-test-%d", os.Getpid())
+	_, err = keyring.Search(name)
+	assert.Equal(t, syscall.ENOKEY, err)
+
+	for _, data := range [][]byte{[]byte("first"), make([]byte, 2000)} {
+		_, err = keyring.Add(name, data)
+		require.NoError(t, err)
+		key, err := keyring.Search(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, key.Name)
+		contents, err := key.Get()
+		require.NoError(t, err)
+		assert.Equal(t, data, contents)
+	}
+
+	key, err := keyring.Search(name)
+	require.NoError(t, err)
+	err = key.Unlink()
+	require.NoError(t, err)
+	_, err = keyring.Search(name)
+	assert.Equal(t, syscall.ENOKEY, err)
+}
@@ -0,0 +1,31 @@
+// Package logging determines where the library's diagnostic messages are sent.
+package logging
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/types"
+)
+
+// logrusLogger is a types.Logger using the global logrus logger.
+type logrusLogger struct{}
+
+// Debugf implements types.Logger.Debugf.
+func (logrusLogger) Debugf(format string, args ...interface{}) {
+	logrus.Debugf(format, args...)
+}
+
+// Warnf implements types.Logger.Warnf.
+func (logrusLogger) Warnf(format string, args ...interface{}) {
+	logrus.Warnf(format, args...)
+}
+
+// Default is the types.Logger used unless overridden by types.SystemContext.Logger; it uses the global logrus logger.
+var Default types.Logger = logrusLogger{}
+
+// ForSystemContext returns the types.Logger to use, as configured by sys (which may be nil).
+func ForSystemContext(sys *types.SystemContext) types.Logger {
+	if sys != nil && sys.Logger != nil {
+		return sys.Logger
+	}
+	return Default
+}
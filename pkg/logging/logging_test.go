@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingLogger is a types.Logger recording all messages.
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "debug: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "warning: "+fmt.Sprintf(format, args...))
+}
+
+func TestForSystemContext(t *testing.T) {
+	assert.Equal(t, Default, ForSystemContext(nil))
+	assert.Equal(t, Default, ForSystemContext(&types.SystemContext{}))
+
+	logger := &recordingLogger{}
+	l := ForSystemContext(&types.SystemContext{Logger: logger})
+	assert.Equal(t, logger, l)
+	l.Debugf("a %d", 1)
+	l.Warnf("b %s", "c")
+	assert.Equal(t, []string{"debug: a 1", "warning: b c"}, logger.messages)
+}
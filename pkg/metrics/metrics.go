@@ -0,0 +1,35 @@
+// Package metrics determines where the library's transfer statistics are reported.
+package metrics
+
+import (
+	"time"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// noopMetrics is a types.Metrics which discards all statistics.
+type noopMetrics struct{}
+
+// RegistryRequest implements types.Metrics.RegistryRequest.
+func (noopMetrics) RegistryRequest(registry, method string, statusCode int, duration time.Duration) {}
+
+// RegistryRetry implements types.Metrics.RegistryRetry.
+func (noopMetrics) RegistryRetry(registry string) {}
+
+// BlobCopied implements types.Metrics.BlobCopied.
+func (noopMetrics) BlobCopied(blobDigest digest.Digest, downloaded, uploaded int64) {}
+
+// BlobReused implements types.Metrics.BlobReused.
+func (noopMetrics) BlobReused(blobDigest digest.Digest) {}
+
+// Default is the types.Metrics used unless overridden by types.SystemContext.Metrics; it discards all statistics.
+var Default types.Metrics = noopMetrics{}
+
+// ForSystemContext returns the types.Metrics to use, as configured by sys (which may be nil).
+func ForSystemContext(sys *types.SystemContext) types.Metrics {
+	if sys != nil && sys.Metrics != nil {
+		return sys.Metrics
+	}
+	return Default
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingMetrics is a types.Metrics counting the reported events.
+type countingMetrics struct {
+	events int
+}
+
+func (m *countingMetrics) RegistryRequest(registry, method string, statusCode int, duration time.Duration) {
+	m.events++
+}
+func (m *countingMetrics) RegistryRetry(registry string) { m.events++ }
+func (m *countingMetrics) BlobCopied(blobDigest digest.Digest, downloaded, uploaded int64) {
+	m.events++
+}
+func (m *countingMetrics) BlobReused(blobDigest digest.Digest) { m.events++ }
+
+func TestForSystemContext(t *testing.T) {
+	assert.Equal(t, Default, ForSystemContext(nil))
+	assert.Equal(t, Default, ForSystemContext(&types.SystemContext{}))
+
+	metrics := &countingMetrics{}
+	m := ForSystemContext(&types.SystemContext{Metrics: metrics})
+	assert.Equal(t, metrics, m)
+	m.RegistryRequest("example.com", "GET", 200, time.Second)
+	m.BlobReused(digest.FromString("blob"))
+	assert.Equal(t, 2, metrics.events)
+}
@@ -0,0 +1,36 @@
+// Package putblobdigest helps ImageDestination.PutBlob implementations determine the digest of the blob being written,
+// without hashing the data again if the caller has already verified it.
+package putblobdigest
+
+import (
+	"io"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Digester determines the digest of a blob being written by ImageDestination.PutBlob.
+type Digester struct {
+	knownDigest digest.Digest   // Set if the digest does not need to be computed
+	digester    digest.Digester // Set if knownDigest is empty
+}
+
+// DigestIfUnverified returns a Digester for a PutBlob call with inputInfo, and a stream to be read instead of stream.
+// If inputInfo.DigestVerified and inputInfo.Digest uses digest.Canonical, the digest is not computed again, and stream is returned unchanged;
+// otherwise the returned stream computes the canonical digest of the data read through it.
+func DigestIfUnverified(stream io.Reader, inputInfo types.BlobInfo) (Digester, io.Reader) {
+	if inputInfo.DigestVerified && inputInfo.Digest != "" && inputInfo.Digest.Algorithm() == digest.Canonical {
+		return Digester{knownDigest: inputInfo.Digest}, stream
+	}
+	digester := digest.Canonical.Digester()
+	return Digester{digester: digester}, io.TeeReader(stream, digester.Hash())
+}
+
+// Digest returns the digest of the blob.
+// It must only be called after the stream returned by DigestIfUnverified has been read until io.EOF.
+func (d Digester) Digest() digest.Digest {
+	if d.knownDigest != "" {
+		return d.knownDigest
+	}
+	return d.digester.Digest()
+}
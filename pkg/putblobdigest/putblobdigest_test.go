@@ -0,0 +1,42 @@
+package putblobdigest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestIfUnverified(t *testing.T) {
+	data := []byte("test data")
+	dataDigest := digest.FromBytes(data)
+	sha512Digest := digest.SHA512.FromBytes(data)
+	// A digest which does not match the data, so that we can tell whether the data was hashed.
+	otherDigest := digest.FromBytes([]byte("other data"))
+
+	for _, c := range []struct {
+		inputInfo types.BlobInfo
+		expected  digest.Digest
+		computed  bool
+	}{
+		{types.BlobInfo{Digest: "", Size: -1}, dataDigest, true},
+		{types.BlobInfo{Digest: otherDigest, Size: -1}, dataDigest, true},
+		{types.BlobInfo{Digest: "", Size: -1, DigestVerified: true}, dataDigest, true},
+		{types.BlobInfo{Digest: sha512Digest, Size: -1, DigestVerified: true}, dataDigest, true},
+		{types.BlobInfo{Digest: otherDigest, Size: -1, DigestVerified: true}, otherDigest, false},
+	} {
+		stream := bytes.NewReader(data)
+		digester, res := DigestIfUnverified(stream, c.inputInfo)
+		if !c.computed {
+			assert.Equal(t, stream, res, "%#v", c.inputInfo)
+		}
+		contents, err := ioutil.ReadAll(res)
+		require.NoError(t, err)
+		assert.Equal(t, data, contents, "%#v", c.inputInfo)
+		assert.Equal(t, c.expected, digester.Digest(), "%#v", c.inputInfo)
+	}
+}
@@ -0,0 +1,223 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/opencontainers/go-digest"
+)
+
+// parentEntry describes an entry of the parent root filesystem, as compared by WriteDiff.
+type parentEntry struct {
+	hdr    *tar.Header
+	digest digest.Digest // The digest of the contents of regular files
+}
+
+// WriteDiff writes to dest an uncompressed layer tarball containing the changes in the filesystem in root, e.g. created by a Builder
+// and modified since, relative to parent, an uncompressed tar archive of the original root filesystem (e.g. as created by
+// image.FlattenedRootfs): the entries which were added or modified, and whiteouts for the entries which were removed.
+// Files are compared by their type, permissions, owner, link target and contents.  As with NewBuilder, if uidMappings or gidMappings
+// are empty, the files in root are owned by the current user, and the original owners are kept (new files are owned by root);
+// otherwise the owners in root are host IDs, mapped back to container IDs using the mappings.
+// Hard links in root are written as separate copies of the file; device nodes, FIFOs and sockets, which a Builder does
+// not create, are ignored.
+func WriteDiff(root string, parent io.Reader, uidMappings, gidMappings []IDMapping, dest io.Writer) error {
+	parentEntries, err := readParentEntries(parent)
+	if err != nil {
+		return fmt.Errorf("Error reading the parent root filesystem: %v", err)
+	}
+
+	tw := tar.NewWriter(dest)
+	seen := map[string]bool{} // Entries present in root; the value is true for directories.
+	err = filepath.Walk(root, func(hostPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, hostPath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		seen[name] = fi.IsDir()
+		if !fi.Mode().IsDir() && !fi.Mode().IsRegular() && fi.Mode()&os.ModeSymlink == 0 {
+			logrus.Debugf("Skipping %q of unsupported type %s", name, fi.Mode().Type())
+			return nil
+		}
+
+		linkname := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if linkname, err = os.Readlink(hostPath); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, linkname)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		original, inParent := parentEntries[name]
+		var originalUID, originalGID int // The owner if the IDs are not mapped: the original one, or root for new files.
+		if inParent {
+			originalUID, originalGID = original.hdr.Uid, original.hdr.Gid
+		}
+		if hdr.Uid, err = unmapID(uidMappings, hdr.Uid, originalUID); err != nil {
+			return fmt.Errorf("Error mapping UID of %q: %v", name, err)
+		}
+		if hdr.Gid, err = unmapID(gidMappings, hdr.Gid, originalGID); err != nil {
+			return fmt.Errorf("Error mapping GID of %q: %v", name, err)
+		}
+
+		if inParent {
+			unchanged, err := entryUnchanged(hostPath, hdr, original)
+			if err != nil {
+				return err
+			}
+			if unchanged {
+				return nil
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			f, err := os.Open(hostPath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(tw, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Only record a whiteout for the top-most removed entry; if its parent directory is missing or replaced by a non-directory,
+	// the removal of the parent already hides it.
+	whiteouts := []string{}
+	for name := range parentEntries {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		if dir != "" && !seen[dir] {
+			continue
+		}
+		whiteouts = append(whiteouts, path.Join(dir, WhiteoutPrefix+base))
+	}
+	sort.Strings(whiteouts)
+	for _, name := range whiteouts {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600}); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readParentEntries returns the entries of parent, an uncompressed tar archive of a root filesystem, indexed by clean relative paths.
+// Hard links are replaced by their targets, and entries of types not created by a Builder are ignored.
+func readParentEntries(parent io.Reader) (map[string]parentEntry, error) {
+	entries := map[string]parentEntry{}
+	links := map[string]string{}
+	tr := tar.NewReader(parent)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeSymlink:
+			entries[name] = parentEntry{hdr: hdr}
+		case tar.TypeReg, tar.TypeRegA:
+			digester := digest.Canonical.Digester()
+			if _, err := io.Copy(digester.Hash(), tr); err != nil {
+				return nil, err
+			}
+			entries[name] = parentEntry{hdr: hdr, digest: digester.Digest()}
+		case tar.TypeLink:
+			links[name] = strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/")
+		}
+	}
+	for name, target := range links {
+		if e, ok := entries[target]; ok {
+			entries[name] = e
+		}
+	}
+	return entries, nil
+}
+
+// entryUnchanged returns true if the file at hostPath, described by hdr, matches original.
+func entryUnchanged(hostPath string, hdr *tar.Header, original parentEntry) (bool, error) {
+	typeflag := original.hdr.Typeflag
+	if typeflag == tar.TypeRegA {
+		typeflag = tar.TypeReg
+	}
+	if hdr.Typeflag != typeflag {
+		return false, nil
+	}
+	if hdr.Uid != original.hdr.Uid || hdr.Gid != original.hdr.Gid {
+		return false, nil
+	}
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		return hdr.Linkname == original.hdr.Linkname, nil
+	case tar.TypeDir:
+		return hdr.Mode&int64(os.ModePerm) == original.hdr.Mode&int64(os.ModePerm), nil
+	case tar.TypeReg:
+		if hdr.Mode&int64(os.ModePerm) != original.hdr.Mode&int64(os.ModePerm) || hdr.Size != original.hdr.Size {
+			return false, nil
+		}
+		f, err := os.Open(hostPath)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		digester := digest.Canonical.Digester()
+		if _, err := io.Copy(digester.Hash(), f); err != nil {
+			return false, err
+		}
+		return digester.Digest() == original.digest, nil
+	}
+	return false, nil
+}
+
+// unmapID returns the ID in a container using mappings corresponding to hostID, the inverse of mapID,
+// or unmappedID if mappings is empty.
+func unmapID(mappings []IDMapping, hostID, unmappedID int) (int, error) {
+	if len(mappings) == 0 {
+		return unmappedID, nil
+	}
+	for _, m := range mappings {
+		if hostID >= m.HostID && hostID < m.HostID+m.Size {
+			return m.ContainerID + hostID - m.HostID, nil
+		}
+	}
+	return -1, fmt.Errorf("Host ID %d is not mapped", hostID)
+}
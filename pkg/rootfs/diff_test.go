@@ -0,0 +1,135 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// diffEntries returns the entries of a layer tarball, mapping names to contents, link targets or "dir".
+func diffEntries(t *testing.T, layer []byte) map[string]string {
+	res := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(layer))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			res[hdr.Name] = "dir"
+		case tar.TypeSymlink:
+			res[hdr.Name] = "-> " + hdr.Linkname
+		default:
+			contents, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+			res[hdr.Name] = string(contents)
+		}
+	}
+	return res
+}
+
+func TestWriteDiff(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	parent := makeLayer(t, false, []tarEntry{
+		{name: "etc/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "etc/hostname", typeflag: tar.TypeReg, contents: "base"},
+		{name: "etc/unchanged", typeflag: tar.TypeReg, contents: "unchanged"},
+		{name: "etc/chmod", typeflag: tar.TypeReg, contents: "chmod"},
+		{name: "etc/removed", typeflag: tar.TypeReg, contents: "removed"},
+		{name: "etc/link", typeflag: tar.TypeLink, linkname: "etc/unchanged"},
+		{name: "lib", typeflag: tar.TypeSymlink, linkname: "/usr/lib"},
+		{name: "removed-dir/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "removed-dir/file", typeflag: tar.TypeReg, contents: "file"},
+		{name: "replaced/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "replaced/file", typeflag: tar.TypeReg, contents: "file"},
+		{name: "dev/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "dev/null", typeflag: tar.TypeChar},
+	})
+	b := NewBuilder(root, nil, nil)
+	require.NoError(t, b.ApplyLayer(bytes.NewReader(parent)))
+	require.NoError(t, b.Finish())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "etc/hostname"), []byte("updated"), 0644))
+	require.NoError(t, os.Chmod(filepath.Join(root, "etc/chmod"), 0600))
+	require.NoError(t, os.Remove(filepath.Join(root, "etc/removed")))
+	require.NoError(t, os.Remove(filepath.Join(root, "lib")))
+	require.NoError(t, os.Symlink("/usr/lib64", filepath.Join(root, "lib")))
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "removed-dir")))
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "replaced")))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "replaced"), []byte("now a file"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "new"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "new/file"), []byte("new"), 0644))
+
+	var buf bytes.Buffer
+	err = WriteDiff(root, bytes.NewReader(parent), nil, nil, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"etc/hostname":    "updated",
+		"etc/chmod":       "chmod",
+		"etc/.wh.removed": "",
+		"lib":             "-> /usr/lib64",
+		".wh.removed-dir": "", // But not removed-dir/.wh.file
+		"replaced":        "now a file",
+		"new/":            "dir",
+		"new/file":        "new",
+	}, diffEntries(t, buf.Bytes()))
+
+	// Applying the diff to the parent recreates the modified filesystem.
+	applied, err := ioutil.TempDir("", "rootfs-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(applied)
+	b = NewBuilder(applied, nil, nil)
+	require.NoError(t, b.ApplyLayer(bytes.NewReader(parent)))
+	require.NoError(t, b.ApplyLayer(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, b.Finish())
+	var buf2 bytes.Buffer
+	err = WriteDiff(applied, bytes.NewReader(parent), nil, nil, &buf2)
+	require.NoError(t, err)
+	assert.Equal(t, diffEntries(t, buf.Bytes()), diffEntries(t, buf2.Bytes()))
+}
+
+func TestWriteDiffIDMappings(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "file"), []byte("file"), 0644))
+	parent := makeLayer(t, false, []tarEntry{})
+
+	var buf bytes.Buffer
+	uidMappings := []IDMapping{{ContainerID: 1000, HostID: os.Getuid(), Size: 1}}
+	gidMappings := []IDMapping{{ContainerID: 2000, HostID: os.Getgid(), Size: 1}}
+	err = WriteDiff(root, bytes.NewReader(parent), uidMappings, gidMappings, &buf)
+	require.NoError(t, err)
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "file", hdr.Name)
+	assert.Equal(t, 1000, hdr.Uid)
+	assert.Equal(t, 2000, hdr.Gid)
+
+	// Without mappings, new files are owned by root.
+	buf.Reset()
+	err = WriteDiff(root, bytes.NewReader(parent), nil, nil, &buf)
+	require.NoError(t, err)
+	tr = tar.NewReader(&buf)
+	hdr, err = tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, 0, hdr.Uid)
+	assert.Equal(t, 0, hdr.Gid)
+
+	// Files owned by unmapped IDs are rejected.
+	err = WriteDiff(root, bytes.NewReader(parent), []IDMapping{{ContainerID: 0, HostID: os.Getuid() + 1, Size: 1}}, nil, ioutil.Discard)
+	assert.Error(t, err)
+}
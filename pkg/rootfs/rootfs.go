@@ -0,0 +1,271 @@
+// Package rootfs creates the root filesystem of an image in a directory, by applying the image layers in order,
+// and computes new layers from changes made to such a directory.
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/pkg/compression"
+)
+
+const (
+	// WhiteoutPrefix marks a file or directory deleted by a layer.
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaqueDir marks a directory whose contents in lower layers are hidden.
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+	// maxSymlinks is the maximum number of symbolic links followed when resolving a path within the root.
+	maxSymlinks = 255
+)
+
+// IDMapping maps a range of user or group IDs in a container to IDs on the host, like a line of /proc/self/uid_map.
+type IDMapping struct {
+	ContainerID int `json:"containerID"`
+	HostID      int `json:"hostID"`
+	Size        int `json:"size"`
+}
+
+// Builder applies image layers to a directory, to create the root filesystem of the image.
+type Builder struct {
+	root        string
+	dirModes    map[string]os.FileMode // Permissions of extracted directories, applied by Finish().
+	uidMappings []IDMapping
+	gidMappings []IDMapping
+}
+
+// NewBuilder returns a Builder creating the root filesystem in root, which must exist.
+// If uidMappings and gidMappings are empty, the extracted files are owned by the current user; otherwise the owners
+// recorded in the layers are mapped to host IDs using uidMappings and gidMappings, and layers containing files
+// owned by unmapped IDs are rejected.
+func NewBuilder(root string, uidMappings, gidMappings []IDMapping) *Builder {
+	return &Builder{root: root, dirModes: map[string]os.FileMode{}, uidMappings: uidMappings, gidMappings: gidMappings}
+}
+
+// ApplyLayer extracts a possibly compressed layer tarball from r on top of the previously applied layers,
+// processing whiteouts.
+func (b *Builder) ApplyLayer(r io.Reader) error {
+	layer, _, err := compression.DetectCompression(r)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+
+	tr := tar.NewReader(layer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := b.applyEntry(tr, hdr); err != nil {
+			return fmt.Errorf("Error extracting %q: %v", hdr.Name, err)
+		}
+	}
+}
+
+// applyEntry applies a single tar entry, with contents in r.
+func (b *Builder) applyEntry(r io.Reader, hdr *tar.Header) error {
+	name := filepath.Clean("/" + hdr.Name) // Relative to the root; this also prevents escaping the root via "..".
+	if name == "/" {
+		return nil
+	}
+	dir, err := b.resolveInRoot(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	base := filepath.Base(name)
+
+	if base == WhiteoutOpaqueDir {
+		b.forgetDirModes(dir, false)
+		return removeDirContents(dir)
+	}
+	if strings.HasPrefix(base, WhiteoutPrefix) {
+		path := filepath.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix))
+		b.forgetDirModes(path, true)
+		return os.RemoveAll(path)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, base)
+	mode := os.FileMode(hdr.Mode) & os.ModePerm
+	// Replace any existing non-directory, or any existing directory if the entry is not a directory.
+	if fi, err := os.Lstat(path); err == nil && (!fi.IsDir() || hdr.Typeflag != tar.TypeDir) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		b.forgetDirModes(path, true)
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		// Keep the directory writable until all layers are applied.
+		if err := os.Chmod(path, mode|0700); err != nil {
+			return err
+		}
+		b.dirModes[path] = mode
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := b.resolveInRoot(filepath.Clean("/" + hdr.Linkname))
+		if err != nil {
+			return err
+		}
+		return os.Link(target, path) // The link shares the owner of its target.
+	default:
+		// Device nodes and FIFOs can not be created without privileges; the container runtime provides them anyway.
+		logrus.Debugf("Skipping %q of unsupported type %q", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+	return b.chown(path, hdr)
+}
+
+// chown sets the owner of path, extracted from the entry described by hdr, if b maps IDs.
+func (b *Builder) chown(path string, hdr *tar.Header) error {
+	if len(b.uidMappings) == 0 && len(b.gidMappings) == 0 {
+		return nil
+	}
+	uid, err := mapID(b.uidMappings, hdr.Uid)
+	if err != nil {
+		return fmt.Errorf("Error mapping UID: %v", err)
+	}
+	gid, err := mapID(b.gidMappings, hdr.Gid)
+	if err != nil {
+		return fmt.Errorf("Error mapping GID: %v", err)
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+// mapID returns the host ID corresponding to id in a container using mappings,
+// or -1 (which os.Lchown leaves unchanged) if mappings is empty.
+func mapID(mappings []IDMapping, id int) (int, error) {
+	if len(mappings) == 0 {
+		return -1, nil
+	}
+	for _, m := range mappings {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, nil
+		}
+	}
+	return -1, fmt.Errorf("ID %d is not mapped", id)
+}
+
+// forgetDirModes stops tracking the permissions of directories within path, which is being removed,
+// and of path itself if includingPath.
+func (b *Builder) forgetDirModes(path string, includingPath bool) {
+	if includingPath {
+		delete(b.dirModes, path)
+	}
+	prefix := path + string(filepath.Separator)
+	for p := range b.dirModes {
+		if strings.HasPrefix(p, prefix) {
+			delete(b.dirModes, p)
+		}
+	}
+}
+
+// Finish applies the recorded directory permissions; it must be called after all layers are applied.
+func (b *Builder) Finish() error {
+	for path, mode := range b.dirModes {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveInRoot returns the host path corresponding to name, an absolute path within the root filesystem,
+// following symbolic links as if the root were the root of the filesystem, so that the result is always within the root.
+func (b *Builder) resolveInRoot(name string) (string, error) {
+	resolved := ""                                                 // Relative to root, always clean and without symlinks
+	remaining := strings.Split(strings.TrimPrefix(name, "/"), "/") // Components yet to be processed
+	followed := 0
+	for len(remaining) > 0 {
+		component := remaining[0]
+		remaining = remaining[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			if resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+		candidate := filepath.Join(resolved, component)
+		fi, err := os.Lstat(filepath.Join(b.root, candidate))
+		if err != nil {
+			if os.IsNotExist(err) {
+				resolved = candidate
+				continue
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = candidate
+			continue
+		}
+		followed++
+		if followed > maxSymlinks {
+			return "", fmt.Errorf("Too many levels of symbolic links resolving %q", name)
+		}
+		target, err := os.Readlink(filepath.Join(b.root, candidate))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return filepath.Join(b.root, resolved), nil
+}
+
+// removeDirContents removes everything inside dir, but not dir itself.
+func removeDirContents(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
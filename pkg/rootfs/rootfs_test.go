@@ -0,0 +1,156 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	contents string
+	linkname string
+	mode     int64
+}
+
+// makeLayer returns a tarball containing entries, compressed using gzip if compress.
+func makeLayer(t *testing.T, compress bool, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	for _, e := range entries {
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Size:     int64(len(e.contents)),
+			Linkname: e.linkname,
+			Mode:     mode,
+		})
+		require.NoError(t, err)
+		_, err = tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	if gz != nil {
+		require.NoError(t, gz.Close())
+	}
+	return buf.Bytes()
+}
+
+func TestRootfsBuilder(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	b := NewBuilder(root, nil, nil)
+	err = b.ApplyLayer(bytes.NewReader(makeLayer(t, true, []tarEntry{
+		{name: "etc/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "etc/hostname", typeflag: tar.TypeReg, contents: "base"},
+		{name: "etc/removed", typeflag: tar.TypeReg, contents: "removed"},
+		{name: "opaque/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "opaque/old", typeflag: tar.TypeReg, contents: "old"},
+		{name: "usr/lib/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "lib", typeflag: tar.TypeSymlink, linkname: "/usr/lib"},
+		{name: "readonly/", typeflag: tar.TypeDir, mode: 0555},
+		{name: "readonly/file", typeflag: tar.TypeReg, contents: "ro"},
+	})))
+	require.NoError(t, err)
+	err = b.ApplyLayer(bytes.NewReader(makeLayer(t, false, []tarEntry{
+		{name: "etc/hostname", typeflag: tar.TypeReg, contents: "updated"},
+		{name: "etc/.wh.removed", typeflag: tar.TypeReg},
+		{name: "opaque/.wh..wh..opq", typeflag: tar.TypeReg},
+		{name: "opaque/new", typeflag: tar.TypeReg, contents: "new"},
+		{name: "lib/libc.so", typeflag: tar.TypeReg, contents: "libc"},
+		{name: "etc/hardlink", typeflag: tar.TypeLink, linkname: "etc/hostname"},
+		{name: "../../escape", typeflag: tar.TypeReg, contents: "escape"},
+		{name: "dev/null", typeflag: tar.TypeChar},
+	})))
+	require.NoError(t, err)
+	require.NoError(t, b.Finish())
+
+	for path, contents := range map[string]string{
+		"etc/hostname":    "updated",
+		"etc/hardlink":    "updated",
+		"opaque/new":      "new",
+		"usr/lib/libc.so": "libc",
+		"readonly/file":   "ro",
+		"escape":          "escape",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		require.NoError(t, err, path)
+		assert.Equal(t, contents, string(data), path)
+	}
+	for _, path := range []string{"etc/removed", "opaque/old", "dev/null"} {
+		_, err := os.Lstat(filepath.Join(root, path))
+		assert.True(t, os.IsNotExist(err), path)
+	}
+	fi, err := os.Stat(filepath.Join(root, "readonly"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0555), fi.Mode().Perm())
+	os.Chmod(filepath.Join(root, "readonly"), 0755) // So that os.RemoveAll works
+	_, err = os.Lstat(filepath.Join(filepath.Dir(root), "escape"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "usr/lib"), 0755))
+	require.NoError(t, os.Symlink("/usr/lib", filepath.Join(root, "abs")))
+	require.NoError(t, os.Symlink("../../../../..", filepath.Join(root, "usr/up")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "loop")))
+
+	b := NewBuilder(root, nil, nil)
+	for _, c := range []struct{ input, expected string }{
+		{"/", ""},
+		{"/usr/lib", "usr/lib"},
+		{"/abs", "usr/lib"},
+		{"/abs/x", "usr/lib/x"},
+		{"/usr/up", ""},
+		{"/usr/up/etc", "etc"},
+		{"/missing/dir", "missing/dir"},
+	} {
+		res, err := b.resolveInRoot(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, filepath.Join(root, c.expected), res, c.input)
+	}
+	_, err = b.resolveInRoot("/loop/x")
+	assert.Error(t, err)
+}
+
+func TestMapID(t *testing.T) {
+	mappings := []IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}}
+	for _, c := range []struct{ input, expected int }{
+		{0, 1000},
+		{1, 100000},
+		{1000, 100999},
+		{65536, 165535},
+	} {
+		res, err := mapID(mappings, c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, res, c.input)
+	}
+	_, err := mapID(mappings, 65537)
+	assert.Error(t, err)
+
+	res, err := mapID(nil, 5)
+	require.NoError(t, err)
+	assert.Equal(t, -1, res)
+}
@@ -0,0 +1,121 @@
+package sysregistries
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/types"
+)
+
+// defaultSearchRegistry is the registry used for short names if no unqualified-search-registries are configured.
+const defaultSearchRegistry = "docker.io"
+
+// IsShortName returns true if name, in the usual Docker reference format, does not explicitly specify a registry.
+// This uses the same rules as docker/reference: the first component must contain a '.' or a ':', or be "localhost".
+func IsShortName(name string) bool {
+	i := strings.IndexRune(name, '/')
+	if i == -1 {
+		return true
+	}
+	first := name[:i]
+	return !strings.ContainsAny(first, ".:") && first != "localhost"
+}
+
+// ResolveShortName returns the names to try, in order, when looking for an image named name (in the usual Docker reference format,
+// possibly with a tag or digest), using the aliases, unqualified-search-registries and short-name-mode configured in registries.conf.
+// If name specifies a registry, it is the only returned name.  Otherwise, in the "permissive" and "enforcing" modes, a configured
+// alias for the name is used if there is one; if not, name is prefixed with each of the unqualified-search-registries
+// (or just docker.io if there are none).  In the "enforcing" mode, such a name is only accepted if it is unambiguous,
+// i.e. if there is a single search registry.
+func ResolveShortName(ctx *types.SystemContext, name string) ([]string, error) {
+	if !IsShortName(name) {
+		return []string{name}, nil
+	}
+	config, err := LoadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := parseShortNameMode(config.ShortNameMode)
+	if err != nil {
+		return nil, err
+	}
+	if ctx != nil && ctx.ShortNameMode != types.ShortNameModeUndefined {
+		mode = ctx.ShortNameMode
+	}
+
+	if mode != types.ShortNameModeDisabled {
+		repo, suffix := splitTagOrDigest(name)
+		if alias, ok := config.Aliases[repo]; ok {
+			return []string{alias + suffix}, nil
+		}
+	}
+	registries := config.UnqualifiedSearchRegistries
+	if len(registries) == 0 {
+		registries = []string{defaultSearchRegistry}
+	}
+	if mode == types.ShortNameModeEnforcing && len(registries) > 1 {
+		return nil, fmt.Errorf("Short name %q is ambiguous: it may refer to an image in any of %s; use a fully-qualified name, or configure an alias in %s",
+			name, strings.Join(registries, ", "), ConfigPath(ctx))
+	}
+	res := []string{}
+	for _, registry := range registries {
+		res = append(res, registry+"/"+name)
+	}
+	return res, nil
+}
+
+// splitTagOrDigest splits name, a short name, into the repository name and the tag or digest suffix (including the ':' or '@'), if any.
+func splitTagOrDigest(name string) (string, string) {
+	if i := strings.IndexRune(name, '@'); i != -1 {
+		return name[:i], name[i:]
+	}
+	// A short name does not contain a host:port, so any ':' separates the tag.
+	if i := strings.IndexRune(name, ':'); i != -1 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// parseShortNameMode parses the short-name-mode value of registries.conf.
+func parseShortNameMode(mode string) (types.ShortNameMode, error) {
+	switch mode {
+	case "", "permissive":
+		return types.ShortNameModePermissive, nil
+	case "enforcing":
+		return types.ShortNameModeEnforcing, nil
+	case "disabled":
+		return types.ShortNameModeDisabled, nil
+	default:
+		return types.ShortNameModeUndefined, fmt.Errorf("Invalid short-name-mode %q", mode)
+	}
+}
+
+// validateAlias returns an error if the alias of name to value is invalid.
+func validateAlias(name, value string) error {
+	if !IsShortName(name) {
+		return fmt.Errorf("Invalid short-name alias %q: the name must not specify a registry", name)
+	}
+	if err := validateRepositoryName(name); err != nil {
+		return fmt.Errorf("Invalid short-name alias %q: %v", name, err)
+	}
+	if IsShortName(value) {
+		return fmt.Errorf("Invalid short-name alias %q = %q: the value must be a fully-qualified name", name, value)
+	}
+	if err := validateRepositoryName(value); err != nil {
+		return fmt.Errorf("Invalid short-name alias %q = %q: %v", name, value, err)
+	}
+	return nil
+}
+
+// validateRepositoryName returns an error if name is not a valid repository name without a tag or digest.
+func validateRepositoryName(name string) error {
+	named, err := reference.ParseNamed(name)
+	if err != nil {
+		return err
+	}
+	if !reference.IsNameOnly(named) {
+		return fmt.Errorf("%q must not contain a tag or digest", name)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package sysregistries
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsShortName(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		expected bool
+	}{
+		{"busybox", true},
+		{"library/busybox", true},
+		{"docker.io/library/busybox", false},
+		{"example.com:5000/busybox", false},
+		{"localhost/busybox", false},
+		{"localhost:5000/busybox", false},
+		{"localhost", true},
+		{"example.com", true},
+	} {
+		assert.Equal(t, c.expected, IsShortName(c.name), c.name)
+	}
+}
+
+func TestResolveShortName(t *testing.T) {
+	const digestSuffix = "@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tmpDir, err := ioutil.TempDir("", "registries-conf")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	writeConf := func(name, contents string) *types.SystemContext {
+		path := filepath.Join(tmpDir, name)
+		err := ioutil.WriteFile(path, []byte(contents), 0644)
+		require.NoError(t, err)
+		return &types.SystemContext{SystemRegistriesConfPath: path}
+	}
+	const aliases = `
+[aliases]
+"ubuntu" = "docker.io/library/ubuntu"
+"ns/app" = "registry.example.com/team/app"
+`
+	noSearch := writeConf("no-search.conf", aliases)
+	oneRegistry := writeConf("one.conf", `unqualified-search-registries = ["registry.example.com"]`+"\n"+`short-name-mode = "enforcing"`+"\n"+aliases)
+	permissive := writeConf("permissive.conf", `unqualified-search-registries = ["registry.example.com", "docker.io"]`+"\n"+aliases)
+	enforcing := writeConf("enforcing.conf", `unqualified-search-registries = ["registry.example.com", "docker.io"]`+"\n"+`short-name-mode = "enforcing"`+"\n"+aliases)
+	disabled := writeConf("disabled.conf", `unqualified-search-registries = ["registry.example.com", "docker.io"]`+"\n"+`short-name-mode = "disabled"`+"\n"+aliases)
+
+	for _, c := range []struct {
+		ctx      *types.SystemContext
+		name     string
+		expected []string // nil if an error is expected
+	}{
+		// Fully-qualified names are not modified.
+		{enforcing, "example.com/ns/busybox:notlatest", []string{"example.com/ns/busybox:notlatest"}},
+		{enforcing, "localhost/busybox", []string{"localhost/busybox"}},
+		// Aliases
+		{permissive, "ubuntu", []string{"docker.io/library/ubuntu"}},
+		{enforcing, "ubuntu:20.04", []string{"docker.io/library/ubuntu:20.04"}},
+		{enforcing, "ns/app" + digestSuffix, []string{"registry.example.com/team/app" + digestSuffix}},
+		{disabled, "ubuntu", []string{"registry.example.com/ubuntu", "docker.io/ubuntu"}},
+		// Search registries
+		{noSearch, "busybox", []string{"docker.io/busybox"}},
+		{permissive, "ns/busybox:notlatest", []string{"registry.example.com/ns/busybox:notlatest", "docker.io/ns/busybox:notlatest"}},
+		{oneRegistry, "busybox", []string{"registry.example.com/busybox"}},
+		{enforcing, "busybox", nil},
+		// Overriding the mode
+		{&types.SystemContext{SystemRegistriesConfPath: permissive.SystemRegistriesConfPath, ShortNameMode: types.ShortNameModeEnforcing}, "busybox", nil},
+		{&types.SystemContext{SystemRegistriesConfPath: enforcing.SystemRegistriesConfPath, ShortNameMode: types.ShortNameModePermissive}, "busybox",
+			[]string{"registry.example.com/busybox", "docker.io/busybox"}},
+	} {
+		res, err := ResolveShortName(c.ctx, c.name)
+		if c.expected == nil {
+			assert.Error(t, err, c.name)
+		} else {
+			require.NoError(t, err, c.name)
+			assert.Equal(t, c.expected, res, c.name)
+		}
+	}
+}
+
+func TestParseConfigShortNames(t *testing.T) {
+	config, err := parseConfig([]byte(`
+short-name-mode = "enforcing"
+
+[aliases]
+"busybox" = "docker.io/library/busybox"
+
+[registries.search]
+registries = ["registry.example.com"]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &Config{
+		UnqualifiedSearchRegistries: []string{"registry.example.com"},
+		ShortNameMode:               "enforcing",
+		Aliases:                     map[string]string{"busybox": "docker.io/library/busybox"},
+	}, config)
+
+	for _, data := range []string{
+		`short-name-mode = "unknown"`,
+		"[aliases]\n\"example.com/busybox\" = \"docker.io/library/busybox\"\n", // Name with a registry
+		"[aliases]\n\"busybox:latest\" = \"docker.io/library/busybox\"\n",      // Name with a tag
+		"[aliases]\n\"UPPERCASE\" = \"docker.io/library/busybox\"\n",           // Invalid name
+		"[aliases]\n\"busybox\" = \"library/busybox\"\n",                       // Value without a registry
+		"[aliases]\n\"busybox\" = \"docker.io/library/busybox:latest\"\n",      // Value with a tag
+	} {
+		_, err := parseConfig([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
@@ -0,0 +1,228 @@
+// Package sysregistries reads the system-wide registries configuration file (registries.conf),
+// which configures how Docker registries are accessed: which registries are insecure or blocked,
+// which mirrors to use, and how image names which do not specify a registry are resolved.
+package sysregistries
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/types"
+)
+
+// systemRegistriesConfPath is the path to the system-wide registry configuration file.
+// You can override this at build time with
+// -ldflags '-X github.com/containers/image/pkg/sysregistries.systemRegistriesConfPath=$your_path'
+var systemRegistriesConfPath = builtinRegistriesConfPath
+
+// builtinRegistriesConfPath is the path to registries.conf.
+// DO NOT change this, instead see systemRegistriesConfPath above.
+const builtinRegistriesConfPath = "/etc/containers/registries.conf"
+
+// Endpoint describes a remote location of a registry.
+type Endpoint struct {
+	// The location of the registry, host[:port].
+	Location string `toml:"location"`
+	// If true, the registry may be contacted over HTTP, or HTTPS with failed TLS verification.
+	Insecure bool `toml:"insecure"`
+}
+
+// Registry represents a registry, or a namespace within a registry.
+type Registry struct {
+	// Location is host[:port], optionally followed by a /-separated namespace; it matches all images in that registry or namespace.
+	// The Insecure field applies to the host.
+	Endpoint
+	// If true, pulling from and pushing to the registry is refused.
+	Blocked bool `toml:"blocked"`
+	// Mirrors to try, in order, before the registry itself when pulling images.
+	Mirrors []Endpoint `toml:"mirror"`
+}
+
+// Config is the contents of a registries.conf file.
+// NOTE: Keep this in sync with docs/registries.conf.md!
+type Config struct {
+	Registries []Registry `toml:"registry"`
+	// Registries to search, in order, for image names which do not specify a registry.
+	UnqualifiedSearchRegistries []string `toml:"unqualified-search-registries"`
+	// ShortNameMode is the short-name resolution mode: "enforcing", "permissive" or "disabled"; "" means "permissive".
+	ShortNameMode string `toml:"short-name-mode"`
+	// Aliases maps short names (image names without a registry, tag or digest) to fully-qualified repository names.
+	Aliases map[string]string `toml:"aliases"`
+}
+
+// legacyConfig is the older format of registries.conf, which only lists registry host names.
+type legacyConfig struct {
+	Registries struct {
+		Search   legacyRegistryList `toml:"search"`
+		Insecure legacyRegistryList `toml:"insecure"`
+		Block    legacyRegistryList `toml:"block"`
+	} `toml:"registries"`
+}
+
+type legacyRegistryList struct {
+	Registries []string `toml:"registries"`
+}
+
+// ConfigPath returns the path to the registries.conf file used with ctx.
+func ConfigPath(ctx *types.SystemContext) string {
+	if ctx != nil {
+		if ctx.SystemRegistriesConfPath != "" {
+			return ctx.SystemRegistriesConfPath
+		}
+		if ctx.RootForImplicitAbsolutePaths != "" {
+			return filepath.Join(ctx.RootForImplicitAbsolutePaths, systemRegistriesConfPath)
+		}
+	}
+	return systemRegistriesConfPath
+}
+
+// LoadConfig returns the registries configuration used with ctx.
+// A missing configuration file is not an error; an empty configuration is returned instead.
+func LoadConfig(ctx *types.SystemContext) (*Config, error) {
+	path := ConfigPath(ctx)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// parseConfig parses the contents of a registries.conf file, in either the current or the legacy format.
+func parseConfig(data []byte) (*Config, error) {
+	config := Config{}
+	md, err := toml.Decode(string(data), &config)
+	if err != nil {
+		return nil, err
+	}
+	if md.IsDefined("registries") {
+		if len(config.Registries) != 0 || len(config.UnqualifiedSearchRegistries) != 0 {
+			return nil, fmt.Errorf("Mixing the legacy [registries] tables with the current format is not supported")
+		}
+		legacy := legacyConfig{}
+		if _, err := toml.Decode(string(data), &legacy); err != nil {
+			return nil, err
+		}
+		converted := convertLegacyConfig(&legacy)
+		converted.ShortNameMode = config.ShortNameMode
+		converted.Aliases = config.Aliases
+		config = converted
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// convertLegacyConfig returns a Config equivalent to legacy.
+func convertLegacyConfig(legacy *legacyConfig) Config {
+	config := Config{
+		UnqualifiedSearchRegistries: legacy.Registries.Search.Registries,
+	}
+	indexes := map[string]int{} // Location -> index into config.Registries
+	get := func(location string) *Registry {
+		i, ok := indexes[location]
+		if !ok {
+			i = len(config.Registries)
+			indexes[location] = i
+			config.Registries = append(config.Registries, Registry{Endpoint: Endpoint{Location: location}})
+		}
+		return &config.Registries[i]
+	}
+	for _, location := range legacy.Registries.Insecure.Registries {
+		get(location).Insecure = true
+	}
+	for _, location := range legacy.Registries.Block.Registries {
+		get(location).Blocked = true
+	}
+	return config
+}
+
+// validate returns an error if config is invalid.
+func (config *Config) validate() error {
+	seen := map[string]bool{}
+	for _, reg := range config.Registries {
+		if err := validateLocation(reg.Location, true); err != nil {
+			return err
+		}
+		if seen[reg.Location] {
+			return fmt.Errorf("Registry %s is configured more than once", reg.Location)
+		}
+		seen[reg.Location] = true
+		for _, mirror := range reg.Mirrors {
+			if err := validateLocation(mirror.Location, false); err != nil {
+				return fmt.Errorf("Invalid mirror of %s: %v", reg.Location, err)
+			}
+		}
+	}
+	for _, location := range config.UnqualifiedSearchRegistries {
+		if err := validateLocation(location, false); err != nil {
+			return fmt.Errorf("Invalid unqualified search registry: %v", err)
+		}
+	}
+	if _, err := parseShortNameMode(config.ShortNameMode); err != nil {
+		return err
+	}
+	for name, value := range config.Aliases {
+		if err := validateAlias(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateLocation returns an error if location is not a valid host[:port] value (followed by a namespace if allowNamespace).
+func validateLocation(location string, allowNamespace bool) error {
+	if location == "" {
+		return fmt.Errorf("Invalid registry location: empty location")
+	}
+	if strings.Contains(location, "://") {
+		return fmt.Errorf("Invalid registry location %s: must not contain a URL scheme", location)
+	}
+	if strings.HasSuffix(location, "/") || strings.Contains(location, "//") {
+		return fmt.Errorf("Invalid registry location %s: must be in a canonical format", location)
+	}
+	if !allowNamespace && strings.Contains(location, "/") {
+		return fmt.Errorf("Invalid registry location %s: must not contain a namespace", location)
+	}
+	return nil
+}
+
+// FindRegistry returns the configuration of the registry or namespace with the longest location matching name,
+// a fully-qualified repository name (e.g. docker.io/library/busybox), or nil if there is no such registry.
+func (config *Config) FindRegistry(name string) *Registry {
+	var res *Registry
+	for i := range config.Registries {
+		reg := &config.Registries[i]
+		if (name == reg.Location || strings.HasPrefix(name, reg.Location+"/")) &&
+			(res == nil || len(reg.Location) > len(res.Location)) {
+			res = reg
+		}
+	}
+	return res
+}
+
+// IsInsecure returns true if the registry at host (host[:port]) may be contacted over HTTP, or HTTPS with failed TLS verification.
+func (config *Config) IsInsecure(host string) bool {
+	for _, reg := range config.Registries {
+		if reg.Location == host && reg.Insecure {
+			return true
+		}
+		for _, mirror := range reg.Mirrors {
+			if mirror.Location == host && mirror.Insecure {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package sysregistries
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigPath(t *testing.T) {
+	const nondefaultPath = "/this/is/not/the/default/registries.conf"
+	const variableReference = "$HOME"
+	const rootPrefix = "/root/prefix"
+
+	for _, c := range []struct {
+		ctx      *types.SystemContext
+		expected string
+	}{
+		// The common case
+		{nil, systemRegistriesConfPath},
+		// There is a context, but it does not override the path.
+		{&types.SystemContext{}, systemRegistriesConfPath},
+		// Path overridden
+		{&types.SystemContext{SystemRegistriesConfPath: nondefaultPath}, nondefaultPath},
+		// Root overridden
+		{
+			&types.SystemContext{RootForImplicitAbsolutePaths: rootPrefix},
+			filepath.Join(rootPrefix, systemRegistriesConfPath),
+		},
+		// Root and path overrides present simultaneously,
+		{
+			&types.SystemContext{
+				RootForImplicitAbsolutePaths: rootPrefix,
+				SystemRegistriesConfPath:     nondefaultPath,
+			},
+			nondefaultPath,
+		},
+		// No environment expansion happens in the overridden paths
+		{&types.SystemContext{SystemRegistriesConfPath: variableReference}, variableReference},
+	} {
+		path := ConfigPath(c.ctx)
+		assert.Equal(t, c.expected, path)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "registries-conf")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// A missing file is not an error.
+	config, err := LoadConfig(&types.SystemContext{SystemRegistriesConfPath: filepath.Join(tmpDir, "missing.conf")})
+	require.NoError(t, err)
+	assert.Equal(t, &Config{}, config)
+
+	path := filepath.Join(tmpDir, "registries.conf")
+	err = ioutil.WriteFile(path, []byte(`
+unqualified-search-registries = ["registry.example.com", "docker.io"]
+
+[[registry]]
+location = "docker.io"
+
+[[registry.mirror]]
+location = "mirror.example.com:5000"
+
+[[registry.mirror]]
+location = "internal-mirror.example.com"
+insecure = true
+
+[[registry]]
+location = "registry.example.com:5000"
+insecure = true
+
+[[registry]]
+location = "docker.io/blocked"
+blocked = true
+`), 0644)
+	require.NoError(t, err)
+	config, err = LoadConfig(&types.SystemContext{SystemRegistriesConfPath: path})
+	require.NoError(t, err)
+	assert.Equal(t, &Config{
+		UnqualifiedSearchRegistries: []string{"registry.example.com", "docker.io"},
+		Registries: []Registry{
+			{
+				Endpoint: Endpoint{Location: "docker.io"},
+				Mirrors: []Endpoint{
+					{Location: "mirror.example.com:5000"},
+					{Location: "internal-mirror.example.com", Insecure: true},
+				},
+			},
+			{Endpoint: Endpoint{Location: "registry.example.com:5000", Insecure: true}},
+			{Endpoint: Endpoint{Location: "docker.io/blocked"}, Blocked: true},
+		},
+	}, config)
+
+	// Invalid syntax
+	err = ioutil.WriteFile(path, []byte(`[[registry`), 0644)
+	require.NoError(t, err)
+	_, err = LoadConfig(&types.SystemContext{SystemRegistriesConfPath: path})
+	assert.Error(t, err)
+}
+
+func TestParseConfigLegacy(t *testing.T) {
+	config, err := parseConfig([]byte(`
+[registries.search]
+registries = ["registry.example.com", "docker.io"]
+
+[registries.insecure]
+registries = ["registry.example.com:5000", "both.example.com"]
+
+[registries.block]
+registries = ["blocked.example.com", "both.example.com"]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &Config{
+		UnqualifiedSearchRegistries: []string{"registry.example.com", "docker.io"},
+		Registries: []Registry{
+			{Endpoint: Endpoint{Location: "registry.example.com:5000", Insecure: true}},
+			{Endpoint: Endpoint{Location: "both.example.com", Insecure: true}, Blocked: true},
+			{Endpoint: Endpoint{Location: "blocked.example.com"}, Blocked: true},
+		},
+	}, config)
+
+	// Mixing the formats is not allowed.
+	_, err = parseConfig([]byte(`
+unqualified-search-registries = ["docker.io"]
+
+[registries.search]
+registries = ["registry.example.com"]
+`))
+	assert.Error(t, err)
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, data := range []string{
+		"[[registry]]\nlocation = \"\"\n",
+		"[[registry]]\nlocation = \"https://registry.example.com\"\n",
+		"[[registry]]\nlocation = \"registry.example.com/\"\n",
+		"[[registry]]\nlocation = \"registry.example.com//ns\"\n",
+		"[[registry]]\nlocation = \"a.example.com\"\n[[registry]]\nlocation = \"a.example.com\"\n",
+		"[[registry]]\nlocation = \"a.example.com\"\n[[registry.mirror]]\nlocation = \"mirror.example.com/ns\"\n",
+		"unqualified-search-registries = [\"registry.example.com/ns\"]\n",
+	} {
+		_, err := parseConfig([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestFindRegistry(t *testing.T) {
+	config := &Config{
+		Registries: []Registry{
+			{Endpoint: Endpoint{Location: "docker.io"}},
+			{Endpoint: Endpoint{Location: "docker.io/library"}},
+			{Endpoint: Endpoint{Location: "docker.io/library/busybox"}},
+			{Endpoint: Endpoint{Location: "registry.example.com:5000"}},
+		},
+	}
+	for _, c := range []struct{ name, expected string }{
+		{"docker.io/library/busybox", "docker.io/library/busybox"},
+		{"docker.io/library/busybox2", "docker.io/library"},
+		{"docker.io/library/alpine", "docker.io/library"},
+		{"docker.io/other/image", "docker.io"},
+		{"registry.example.com:5000/ns/image", "registry.example.com:5000"},
+		{"registry.example.com/ns/image", ""},
+		{"docker.io2/image", ""},
+	} {
+		reg := config.FindRegistry(c.name)
+		if c.expected == "" {
+			assert.Nil(t, reg, c.name)
+		} else {
+			require.NotNil(t, reg, c.name)
+			assert.Equal(t, c.expected, reg.Location, c.name)
+		}
+	}
+}
+
+func TestIsInsecure(t *testing.T) {
+	config := &Config{
+		Registries: []Registry{
+			{
+				Endpoint: Endpoint{Location: "docker.io"},
+				Mirrors:  []Endpoint{{Location: "mirror.example.com", Insecure: true}, {Location: "secure.example.com"}},
+			},
+			{Endpoint: Endpoint{Location: "registry.example.com:5000", Insecure: true}},
+		},
+	}
+	for _, c := range []struct {
+		host     string
+		expected bool
+	}{
+		{"docker.io", false},
+		{"mirror.example.com", true},
+		{"secure.example.com", false},
+		{"registry.example.com:5000", true},
+		{"registry.example.com", false},
+	} {
+		assert.Equal(t, c.expected, config.IsInsecure(c.host), c.host)
+	}
+}
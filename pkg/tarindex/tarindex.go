@@ -0,0 +1,115 @@
+// Package tarindex provides random access to the files in an uncompressed tar archive.
+// The archive is read only once, to record where each file is stored; the file contents are skipped,
+// and later read directly from the archive, without extracting it.
+package tarindex
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxLinkHops is the maximum number of symbolic or hard links followed by Open.
+const maxLinkHops = 16
+
+// Archive is the interface required to index and read an archive; *os.File implements it.
+type Archive interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// entry describes a single entry of an indexed archive.
+type entry struct {
+	header *tar.Header
+	offset int64 // The offset of the entry contents within the archive
+	sparse bool  // The contents are not stored contiguously in the archive
+}
+
+// Index allows random access to the files in a tar archive.
+// It is safe to use from multiple goroutines.
+type Index struct {
+	archive Archive
+	entries map[string]*entry // Indexed by normalized name
+}
+
+// New reads the uncompressed tar archive from the start of archive, and returns an Index of its entries.
+// The returned Index reads file contents from archive, which must not be closed while the Index is in use.
+// If an archive contains more than one entry with the same name, the last one is used, as it would be if the archive were extracted.
+func New(archive Archive) (*Index, error) {
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	index := &Index{archive: archive, entries: map[string]*entry{}}
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading tar archive: %v", err)
+		}
+		// tar.Reader does not buffer any data, so the archive is positioned at the start of the entry contents.
+		offset, err := archive.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		e := &entry{header: hdr, offset: offset}
+		// Old GNU sparse files use tar.TypeGNUSparse, which Open rejects; PAX sparse files look like regular files.
+		for k := range hdr.PAXRecords {
+			if strings.HasPrefix(k, "GNU.sparse.") {
+				e.sparse = true
+			}
+		}
+		index.entries[normalizeName(hdr.Name)] = e
+	}
+	return index, nil
+}
+
+// normalizeName returns name in a canonical form, so that e.g. "./blobs/sha256/…" and "blobs/sha256/…" refer to the same file.
+func normalizeName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Names returns the normalized names of all entries in the archive, in no particular order.
+func (i *Index) Names() []string {
+	res := make([]string, 0, len(i.entries))
+	for name := range i.entries {
+		res = append(res, name)
+	}
+	return res
+}
+
+// Open returns a reader for the contents of the regular file name in the archive, and its header.
+// Symbolic and hard links are followed; symbolic links are resolved relative to the directory containing them, within the archive.
+// If the file does not exist, the returned error satisfies os.IsNotExist.
+func (i *Index) Open(name string) (*io.SectionReader, *tar.Header, error) {
+	current := normalizeName(name)
+	for hops := 0; ; hops++ {
+		e, ok := i.entries[current]
+		if !ok {
+			return nil, nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		switch e.header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			if e.sparse {
+				return nil, nil, fmt.Errorf("Error reading tar archive component %s: sparse files are not supported", name)
+			}
+			return io.NewSectionReader(i.archive, e.offset, e.header.Size), e.header, nil
+		case tar.TypeSymlink, tar.TypeLink:
+			if hops >= maxLinkHops {
+				return nil, nil, fmt.Errorf("Error reading tar archive component %s: too many levels of links", name)
+			}
+			if e.header.Typeflag == tar.TypeSymlink && !path.IsAbs(e.header.Linkname) {
+				current = normalizeName(path.Join(path.Dir(current), e.header.Linkname))
+			} else {
+				current = normalizeName(e.header.Linkname)
+			}
+		default:
+			return nil, nil, fmt.Errorf("Error reading tar archive component %s: not a regular file", name)
+		}
+	}
+}
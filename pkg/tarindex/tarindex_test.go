@@ -0,0 +1,82 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		hdr      tar.Header
+		contents string
+	}{
+		{tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "./dir/file", Mode: 0644}, "file contents"},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "replaced", Mode: 0644}, "old contents"},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "big", Mode: 0644}, string(bytes.Repeat([]byte("0123456789"), 1000))},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "replaced", Mode: 0644}, "new contents"},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/symlink", Linkname: "file"}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/up", Linkname: "../big"}, ""},
+		{tar.Header{Typeflag: tar.TypeLink, Name: "hardlink", Linkname: "dir/file"}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "loop", Linkname: "loop"}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "dangling", Linkname: "missing"}, ""},
+		{tar.Header{Typeflag: tar.TypeFifo, Name: "fifo"}, ""},
+		// A long name, which requires an extended header.
+		{tar.Header{Typeflag: tar.TypeReg, Name: string(bytes.Repeat([]byte("long/"), 50)) + "file", Mode: 0644}, "long name"},
+	} {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.contents))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	index, err := New(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	names := index.Names()
+	assert.Contains(t, names, "dir/file")
+	assert.Contains(t, names, "dir")
+
+	for name, expected := range map[string]string{
+		"dir/file":    "file contents",
+		"./dir/file":  "file contents",
+		"/dir/file":   "file contents",
+		"replaced":    "new contents",
+		"big":         string(bytes.Repeat([]byte("0123456789"), 1000)),
+		"dir/symlink": "file contents",
+		"dir/up":      string(bytes.Repeat([]byte("0123456789"), 1000)),
+		"hardlink":    "file contents",
+		string(bytes.Repeat([]byte("long/"), 50)) + "file": "long name",
+	} {
+		r, hdr, err := index.Open(name)
+		require.NoError(t, err, name)
+		contents, err := ioutil.ReadAll(r)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, string(contents), name)
+		assert.Equal(t, int64(len(expected)), hdr.Size, name)
+	}
+
+	for _, name := range []string{"missing", "dangling"} {
+		_, _, err := index.Open(name)
+		assert.True(t, os.IsNotExist(err), name)
+	}
+	for _, name := range []string{"dir", "loop", "fifo"} {
+		_, _, err := index.Open(name)
+		assert.Error(t, err, name)
+		assert.False(t, os.IsNotExist(err), name)
+	}
+
+	// Invalid archives are rejected.
+	_, err = New(bytes.NewReader(bytes.Repeat([]byte{1}, 1024)))
+	assert.Error(t, err)
+}
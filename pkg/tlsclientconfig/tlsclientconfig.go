@@ -0,0 +1,81 @@
+// Package tlsclientconfig configures TLS clients using certs.d-style directories,
+// as used by Docker: a directory per registry, containing CA certificates and client certificate/key pairs.
+package tlsclientconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// SetupCertificates adds the certificates found in dir to tlsc:
+// - *.crt files are CA certificates, trusted in addition to the system's default CAs.
+// - *.cert files are client certificates, each of which must be accompanied by a *.key file with the same base name.
+// A missing dir is not an error.
+func SetupCertificates(dir string, tlsc *tls.Config) error {
+	logrus.Debugf("Looking for TLS certificates and private keys in %s", dir)
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, f := range fs {
+		fullPath := filepath.Join(dir, f.Name())
+		switch {
+		case strings.HasSuffix(f.Name(), ".crt"):
+			if tlsc.RootCAs == nil {
+				systemPool, err := x509.SystemCertPool()
+				if err != nil {
+					return fmt.Errorf("Error reading the system certificate pool: %v", err)
+				}
+				tlsc.RootCAs = systemPool
+			}
+			logrus.Debugf("Using CA certificate %s", fullPath)
+			data, err := ioutil.ReadFile(fullPath)
+			if err != nil {
+				return err
+			}
+			if !tlsc.RootCAs.AppendCertsFromPEM(data) {
+				return fmt.Errorf("Error adding CA certificate %s: no valid certificates found", fullPath)
+			}
+		case strings.HasSuffix(f.Name(), ".cert"):
+			certName := f.Name()
+			keyName := certName[:len(certName)-len(".cert")] + ".key"
+			if !hasFile(fs, keyName) {
+				return fmt.Errorf("Missing key %s for client certificate %s. Note that CA certificates should use the extension .crt", keyName, certName)
+			}
+			logrus.Debugf("Using client certificate %s", fullPath)
+			cert, err := tls.LoadX509KeyPair(fullPath, filepath.Join(dir, keyName))
+			if err != nil {
+				return fmt.Errorf("Error loading x509 key pair %s: %v", fullPath, err)
+			}
+			tlsc.Certificates = append(tlsc.Certificates, cert)
+		case strings.HasSuffix(f.Name(), ".key"):
+			keyName := f.Name()
+			certName := keyName[:len(keyName)-len(".key")] + ".cert"
+			if !hasFile(fs, certName) {
+				return fmt.Errorf("Missing client certificate %s for key %s", certName, keyName)
+			}
+		}
+	}
+	return nil
+}
+
+// hasFile returns true if fs contains a file named name.
+func hasFile(fs []os.FileInfo, name string) bool {
+	for _, f := range fs {
+		if f.Name() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package tlsclientconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// generateCertificate returns a PEM-encoded self-signed certificate and the corresponding PEM-encoded private key.
+func generateCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "registry.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestSetupCertificates(t *testing.T) {
+	certPEM, keyPEM := generateCertificate(t)
+
+	// A missing directory is not an error.
+	tlsc := &tls.Config{}
+	err := SetupCertificates("/this/does/not/exist", tlsc)
+	require.NoError(t, err)
+	assert.Nil(t, tlsc.RootCAs)
+	assert.Empty(t, tlsc.Certificates)
+
+	for _, c := range []struct {
+		files         map[string][]byte
+		success       bool
+		hasCA         bool
+		numClientCert int
+	}{
+		{map[string][]byte{}, true, false, 0},
+		{map[string][]byte{"ca.crt": certPEM}, true, true, 0},
+		{map[string][]byte{"client.cert": certPEM, "client.key": keyPEM}, true, false, 1},
+		{map[string][]byte{"ca.crt": certPEM, "client.cert": certPEM, "client.key": keyPEM, "README": []byte("ignored")}, true, true, 1},
+		{map[string][]byte{"ca.crt": []byte("not a certificate")}, false, false, 0},
+		{map[string][]byte{"client.cert": certPEM}, false, false, 0},
+		{map[string][]byte{"client.key": keyPEM}, false, false, 0},
+		{map[string][]byte{"client.cert": certPEM, "client.key": []byte("not a key")}, false, false, 0},
+	} {
+		dir, err := ioutil.TempDir("", "tlsclientconfig")
+		require.NoError(t, err)
+		for name, contents := range c.files {
+			err := ioutil.WriteFile(filepath.Join(dir, name), contents, 0600)
+			require.NoError(t, err)
+		}
+
+		tlsc := &tls.Config{}
+		err = SetupCertificates(dir, tlsc)
+		os.RemoveAll(dir)
+		if !c.success {
+			assert.Error(t, err, "%v", c.files)
+			continue
+		}
+		require.NoError(t, err, "%v", c.files)
+		if c.hasCA {
+			assert.NotNil(t, tlsc.RootCAs, "%v", c.files)
+		} else {
+			assert.Nil(t, tlsc.RootCAs, "%v", c.files)
+		}
+		assert.Len(t, tlsc.Certificates, c.numClientCert, "%v", c.files)
+	}
+}
@@ -0,0 +1,15 @@
+// Package tmpdir determines where large temporary files, e.g. staged blobs, are created.
+package tmpdir
+
+import "github.com/containers/image/types"
+
+// defaultTemporaryDirectoryForBigFiles is used unless overridden by types.SystemContext.BigFilesTemporaryDir.
+const defaultTemporaryDirectoryForBigFiles = "/var/tmp" // Do not use the system default of os.TempDir(), usually /tmp, because with systemd it could be a tmpfs.
+
+// TemporaryDirectoryForBigFiles returns the directory to use for large temporary files, as configured by sys (which may be nil).
+func TemporaryDirectoryForBigFiles(sys *types.SystemContext) string {
+	if sys != nil && sys.BigFilesTemporaryDir != "" {
+		return sys.BigFilesTemporaryDir
+	}
+	return defaultTemporaryDirectoryForBigFiles
+}
@@ -0,0 +1,14 @@
+package tmpdir
+
+import (
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemporaryDirectoryForBigFiles(t *testing.T) {
+	assert.Equal(t, "/var/tmp", TemporaryDirectoryForBigFiles(nil))
+	assert.Equal(t, "/var/tmp", TemporaryDirectoryForBigFiles(&types.SystemContext{}))
+	assert.Equal(t, "/srv/scratch", TemporaryDirectoryForBigFiles(&types.SystemContext{BigFilesTemporaryDir: "/srv/scratch"}))
+}
@@ -0,0 +1,38 @@
+// Package tracing determines where the library's tracing spans are recorded.
+//
+// The library does not depend on any tracing implementation; to use e.g. OpenTelemetry, set types.SystemContext.Tracer
+// (or copy.Options.Tracer) to an adapter which implements types.Tracer.Start by calling the OpenTelemetry tracer's Start
+// with the attributes converted to OpenTelemetry attributes, and types.Span.End by recording the error (if any) and ending the span.
+// Because spans are propagated through context.Context values, spans created by the library become children of the spans of the caller.
+package tracing
+
+import (
+	"context"
+
+	"github.com/containers/image/types"
+)
+
+// noopTracer is a types.Tracer which does not record anything.
+type noopTracer struct{}
+
+// Start implements types.Tracer.Start.
+func (noopTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, types.Span) {
+	return ctx, noopSpan{}
+}
+
+// noopSpan is a types.Span returned by noopTracer.
+type noopSpan struct{}
+
+// End implements types.Span.End.
+func (noopSpan) End(err error) {}
+
+// Default is the types.Tracer used unless overridden by types.SystemContext.Tracer; it does not record anything.
+var Default types.Tracer = noopTracer{}
+
+// ForSystemContext returns the types.Tracer to use, as configured by sys (which may be nil).
+func ForSystemContext(sys *types.SystemContext) types.Tracer {
+	if sys != nil && sys.Tracer != nil {
+		return sys.Tracer
+	}
+	return Default
+}
@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingTracer is a types.Tracer recording the names of all started spans.
+type recordingTracer struct {
+	started []string
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, types.Span) {
+	t.started = append(t.started, name)
+	return ctx, noopSpan{}
+}
+
+func TestDefault(t *testing.T) {
+	ctx := context.Background()
+	spanCtx, span := Default.Start(ctx, "test", map[string]string{"a": "b"})
+	assert.Equal(t, ctx, spanCtx)
+	span.End(nil)
+	span.End(errors.New("failed"))
+}
+
+func TestForSystemContext(t *testing.T) {
+	assert.Equal(t, Default, ForSystemContext(nil))
+	assert.Equal(t, Default, ForSystemContext(&types.SystemContext{}))
+
+	tracer := &recordingTracer{}
+	tr := ForSystemContext(&types.SystemContext{Tracer: tracer})
+	assert.Equal(t, tracer, tr)
+	_, span := tr.Start(context.Background(), "test", nil)
+	span.End(nil)
+	assert.Equal(t, []string{"test"}, tracer.started)
+}
@@ -0,0 +1,245 @@
+// Package zstdchunked creates layers in the zstd:chunked format: a zstd-compressed tar stream in which the contents
+// of each regular file are stored in separate zstd frames, followed by a table of contents (TOC) listing the files
+// and the positions of their frames.  The TOC and a footer pointing to it are stored in zstd skippable frames,
+// so the layer can be decompressed by any zstd decoder, while consumers aware of the format can use the TOC
+// (located using the annotations returned by Compress) to fetch only the files they need.
+package zstdchunked
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// ManifestChecksumAnnotation is the layer annotation containing the digest of the compressed TOC.
+	ManifestChecksumAnnotation = "io.github.containers.zstd-chunked.manifest-checksum"
+	// ManifestPositionAnnotation is the layer annotation describing the position of the TOC in the layer,
+	// as "offset:compressedLength:uncompressedLength:manifestType".
+	ManifestPositionAnnotation = "io.github.containers.zstd-chunked.manifest-position"
+
+	// manifestTypeCRFS is the manifestType value for the TOC format written by this package.
+	manifestTypeCRFS = 1
+	// skippableFrameMagic is the magic number of the zstd skippable frames used for the TOC and the footer.
+	skippableFrameMagic = 0x184D2A50
+)
+
+// footerMagic identifies the footer at the end of a zstd:chunked layer.
+var footerMagic = []byte{0x47, 0x4e, 0x55, 0x6c, 0x49, 0x6e, 0x55, 0x78} // "GNUlInUx"
+
+// TOC is the table of contents of a zstd:chunked layer.
+type TOC struct {
+	Version int        `json:"version"`
+	Entries []TOCEntry `json:"entries"`
+}
+
+// TOCEntry describes a single entry of the tar stream in a zstd:chunked layer.
+type TOCEntry struct {
+	Type      string    `json:"type"` // "reg", "dir", "symlink", "hardlink", "char", "block" or "fifo"
+	Name      string    `json:"name"`
+	LinkName  string    `json:"linkName,omitempty"`
+	Mode      int64     `json:"mode,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	UID       int       `json:"uid,omitempty"`
+	GID       int       `json:"gid,omitempty"`
+	UserName  string    `json:"userName,omitempty"`
+	GroupName string    `json:"groupName,omitempty"`
+	ModTime   time.Time `json:"modtime"`
+	Devmajor  int64     `json:"devMajor,omitempty"`
+	Devminor  int64     `json:"devMinor,omitempty"`
+	// Digest is the digest of the file contents; only set for regular files with contents.
+	Digest digest.Digest `json:"digest,omitempty"`
+	// Offset and EndOffset delimit, in the compressed layer, the zstd frame containing the file contents;
+	// only set for regular files with contents.
+	Offset    int64 `json:"offset,omitempty"`
+	EndOffset int64 `json:"endOffset,omitempty"`
+}
+
+// countingWriter is an io.Writer which counts the bytes written to the underlying writer.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// frameWriter writes its input to dest as a sequence of zstd frames, starting a new frame on request.
+type frameWriter struct {
+	dest    *countingWriter
+	encoder *zstd.Encoder
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	return w.encoder.Write(p)
+}
+
+// endFrame finishes the current frame, if any, and starts a new one.
+func (w *frameWriter) endFrame() error {
+	if err := w.encoder.Close(); err != nil {
+		return err
+	}
+	w.encoder.Reset(w.dest)
+	return nil
+}
+
+// Compress reads an uncompressed tar stream from src, writes it to dest in the zstd:chunked format,
+// and returns the annotations to be recorded in the layer’s descriptor.
+func Compress(dest io.Writer, src io.Reader) (map[string]string, error) {
+	counter := &countingWriter{writer: dest}
+	encoder, err := zstd.NewWriter(counter)
+	if err != nil {
+		return nil, err
+	}
+	fw := &frameWriter{dest: counter, encoder: encoder}
+	// All of the input, including tar headers and padding, is written to the zstd frames, so that
+	// decompressing the layer results in exactly the original tar stream.
+	input := io.TeeReader(src, fw)
+	tr := tar.NewReader(input)
+
+	toc := TOC{Version: 1, Entries: []TOCEntry{}}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading tar stream: %v", err)
+		}
+		entry, err := newTOCEntry(hdr)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Type == "reg" && hdr.Size > 0 {
+			// Store the file contents in a separate frame.
+			if err := fw.endFrame(); err != nil {
+				return nil, err
+			}
+			entry.Offset = counter.count
+			digester := digest.Canonical.Digester()
+			if _, err := io.Copy(digester.Hash(), tr); err != nil {
+				return nil, fmt.Errorf("Error reading %s from tar stream: %v", hdr.Name, err)
+			}
+			if err := fw.endFrame(); err != nil {
+				return nil, err
+			}
+			entry.EndOffset = counter.count
+			entry.Digest = digester.Digest()
+		}
+		toc.Entries = append(toc.Entries, entry)
+	}
+	// Include the end-of-archive marker and any trailing padding.
+	if _, err := io.Copy(ioutil.Discard, input); err != nil {
+		return nil, fmt.Errorf("Error reading tar stream: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return writeTOC(counter, toc)
+}
+
+// newTOCEntry returns a TOCEntry for hdr, without contents information.
+func newTOCEntry(hdr *tar.Header) (TOCEntry, error) {
+	var entryType string
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		entryType = "reg"
+	case tar.TypeDir:
+		entryType = "dir"
+	case tar.TypeSymlink:
+		entryType = "symlink"
+	case tar.TypeLink:
+		entryType = "hardlink"
+	case tar.TypeChar:
+		entryType = "char"
+	case tar.TypeBlock:
+		entryType = "block"
+	case tar.TypeFifo:
+		entryType = "fifo"
+	default:
+		return TOCEntry{}, fmt.Errorf("Unsupported tar entry type %q for %s", hdr.Typeflag, hdr.Name)
+	}
+	return TOCEntry{
+		Type:      entryType,
+		Name:      hdr.Name,
+		LinkName:  hdr.Linkname,
+		Mode:      hdr.Mode,
+		Size:      hdr.Size,
+		UID:       hdr.Uid,
+		GID:       hdr.Gid,
+		UserName:  hdr.Uname,
+		GroupName: hdr.Gname,
+		ModTime:   hdr.ModTime,
+		Devmajor:  hdr.Devmajor,
+		Devminor:  hdr.Devminor,
+	}, nil
+}
+
+// writeTOC writes the compressed toc, and a footer pointing to it, to dest, as skippable frames,
+// and returns the corresponding annotations.
+func writeTOC(dest *countingWriter, toc TOC) (map[string]string, error) {
+	tocJSON, err := json.Marshal(toc)
+	if err != nil {
+		return nil, err
+	}
+	var compressedTOC bytes.Buffer
+	encoder, err := zstd.NewWriter(&compressedTOC)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := encoder.Write(tocJSON); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	tocOffset := dest.count + 8 // After the skippable frame header
+	if err := writeSkippableFrame(dest, compressedTOC.Bytes()); err != nil {
+		return nil, err
+	}
+	footer := make([]byte, 0, 40)
+	for _, v := range []uint64{uint64(tocOffset), uint64(compressedTOC.Len()), uint64(len(tocJSON)), manifestTypeCRFS} {
+		footer = appendUint64(footer, v)
+	}
+	footer = append(footer, footerMagic...)
+	if err := writeSkippableFrame(dest, footer); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		ManifestChecksumAnnotation: digest.FromBytes(compressedTOC.Bytes()).String(),
+		ManifestPositionAnnotation: fmt.Sprintf("%d:%d:%d:%d", tocOffset, compressedTOC.Len(), len(tocJSON), manifestTypeCRFS),
+	}, nil
+}
+
+// writeSkippableFrame writes data to dest as a zstd skippable frame.
+func writeSkippableFrame(dest io.Writer, data []byte) error {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header[0:4], skippableFrameMagic)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(data)))
+	if _, err := dest.Write(header); err != nil {
+		return err
+	}
+	_, err := dest.Write(data)
+	return err
+}
+
+// appendUint64 appends v to b in little-endian byte order.
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
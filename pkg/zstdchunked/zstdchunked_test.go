@@ -0,0 +1,91 @@
+package zstdchunked
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zstdDecompress returns the decompressed contents of data.
+func zstdDecompress(t *testing.T, data []byte) []byte {
+	decoder, err := zstd.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer decoder.Close()
+	res, err := ioutil.ReadAll(decoder)
+	require.NoError(t, err)
+	return res
+}
+
+func TestCompress(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := map[string]string{
+		"etc/hostname": "example\n",
+		"etc/empty":    "",
+		"usr/big":      string(bytes.Repeat([]byte("0123456789"), 10000)),
+	}
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755, ModTime: modTime}))
+	for _, name := range []string{"etc/hostname", "etc/empty", "usr/big"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: modTime}))
+		_, err := tw.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/link", Linkname: "hostname", ModTime: modTime}))
+	require.NoError(t, tw.Close())
+
+	var compressed bytes.Buffer
+	annotations, err := Compress(&compressed, bytes.NewReader(layer.Bytes()))
+	require.NoError(t, err)
+	blob := compressed.Bytes()
+
+	// Decompressing the whole layer returns the original tar stream.
+	assert.Equal(t, layer.Bytes(), zstdDecompress(t, blob))
+
+	// The footer points to the TOC, which matches the annotations.
+	require.True(t, len(blob) > 48)
+	footer := blob[len(blob)-40:]
+	assert.Equal(t, footerMagic, footer[32:])
+	tocOffset := binary.LittleEndian.Uint64(footer[0:8])
+	tocLength := binary.LittleEndian.Uint64(footer[8:16])
+	tocUncompressedLength := binary.LittleEndian.Uint64(footer[16:24])
+	assert.Equal(t, uint64(manifestTypeCRFS), binary.LittleEndian.Uint64(footer[24:32]))
+	assert.Equal(t, fmt.Sprintf("%d:%d:%d:%d", tocOffset, tocLength, tocUncompressedLength, manifestTypeCRFS), annotations[ManifestPositionAnnotation])
+	compressedTOC := blob[tocOffset : tocOffset+tocLength]
+	assert.Equal(t, digest.FromBytes(compressedTOC).String(), annotations[ManifestChecksumAnnotation])
+	tocJSON := zstdDecompress(t, compressedTOC)
+	assert.Len(t, tocJSON, int(tocUncompressedLength))
+	var toc TOC
+	require.NoError(t, json.Unmarshal(tocJSON, &toc))
+
+	// The TOC describes all entries, and the contents of each regular file can be read from its own frame.
+	assert.Equal(t, 1, toc.Version)
+	require.Len(t, toc.Entries, 5)
+	assert.Equal(t, TOCEntry{Type: "dir", Name: "etc/", Mode: 0755, ModTime: modTime}, toc.Entries[0])
+	assert.Equal(t, TOCEntry{Type: "symlink", Name: "etc/link", LinkName: "hostname", ModTime: modTime}, toc.Entries[4])
+	for _, e := range toc.Entries[1:4] {
+		assert.Equal(t, "reg", e.Type)
+		contents := files[e.Name]
+		assert.Equal(t, int64(len(contents)), e.Size, e.Name)
+		if contents == "" {
+			assert.Equal(t, digest.Digest(""), e.Digest, e.Name)
+			continue
+		}
+		assert.Equal(t, digest.FromString(contents), e.Digest, e.Name)
+		assert.Equal(t, contents, string(zstdDecompress(t, blob[e.Offset:e.EndOffset])), e.Name)
+	}
+
+	// Invalid input is rejected.
+	_, err = Compress(ioutil.Discard, bytes.NewReader([]byte("this is not a tar file")))
+	assert.Error(t, err)
+}
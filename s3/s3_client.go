@@ -0,0 +1,286 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containers/image/types"
+)
+
+const (
+	defaultEndpoint = "https://s3.amazonaws.com"
+	defaultRegion   = "us-east-1"
+
+	// unsignedPayload is used as the payload hash of requests with a body, which we stream without hashing it twice;
+	// the blob contents are verified using their digests anyway.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// emptyPayloadHash is the SHA-256 hash of an empty payload.
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Client is a minimal client for the S3 REST API, using path-style requests signed with AWS Signature Version 4.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time // Only for tests.
+}
+
+// newS3Client returns a client configured using ctx, falling back to the environment and defaults.
+func newS3Client(ctx *types.SystemContext) (*s3Client, error) {
+	endpoint, region, accessKey, secretKey := "", "", "", ""
+	insecureSkipTLSVerify := false
+	if ctx != nil {
+		endpoint, region, accessKey, secretKey = ctx.S3Endpoint, ctx.S3Region, ctx.S3AccessKeyID, ctx.S3SecretAccessKey
+		insecureSkipTLSVerify = ctx.S3InsecureSkipTLSVerify
+	}
+	if endpoint == "" {
+		endpoint = os.Getenv("S3_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = defaultRegion
+	}
+	if accessKey == "" && secretKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing S3 endpoint %q: %v", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q: the scheme must be http or https", endpoint)
+	}
+	tr := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipTLSVerify},
+	}
+	return &s3Client{
+		endpoint:  u,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Transport: tr},
+		now:       time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of key in bucket.
+func (c *s3Client) objectURL(bucket, key string) *url.URL {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	u.RawPath = ""
+	return &u
+}
+
+// makeRequest sends a signed request for key in bucket, with the specified body (which may be nil) of the specified size.
+// The caller must close the body of the returned response.
+func (c *s3Client) makeRequest(method, bucket, key string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.objectURL(bucket, key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		c.sign(req, unsignedPayload)
+	} else {
+		c.sign(req, emptyPayloadHash)
+	}
+	return c.client.Do(req)
+}
+
+// getObject returns a stream for the contents of key in bucket, and its size (or -1 if unknown).
+// If the object does not exist, the returned error satisfies os.IsNotExist.
+func (c *s3Client) getObject(bucket, key string) (io.ReadCloser, int64, error) {
+	res, err := c.makeRequest("GET", bucket, key, nil, 0)
+	if err != nil {
+		return nil, -1, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, -1, responseError("GET", bucket, key, res)
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// readObject returns the contents of key in bucket.
+// If the object does not exist, the returned error satisfies os.IsNotExist.
+func (c *s3Client) readObject(bucket, key string) ([]byte, error) {
+	stream, _, err := c.getObject(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return ioutil.ReadAll(stream)
+}
+
+// objectExists returns true iff key exists in bucket.
+func (c *s3Client) objectExists(bucket, key string) (bool, error) {
+	res, err := c.makeRequest("HEAD", bucket, key, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError("HEAD", bucket, key, res)
+	}
+}
+
+// putObject stores size bytes from body as key in bucket.
+func (c *s3Client) putObject(bucket, key string, body io.Reader, size int64) error {
+	res, err := c.makeRequest("PUT", bucket, key, body, size)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return responseError("PUT", bucket, key, res)
+	}
+	return nil
+}
+
+// deleteObject removes key from bucket.
+func (c *s3Client) deleteObject(bucket, key string) error {
+	res, err := c.makeRequest("DELETE", bucket, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return responseError("DELETE", bucket, key, res)
+	}
+	return nil
+}
+
+// responseError returns an error describing an unexpected response to a request for key in bucket.
+func responseError(method, bucket, key string, res *http.Response) error {
+	if res.StatusCode == http.StatusNotFound {
+		return &os.PathError{Op: method, Path: bucket + "/" + key, Err: os.ErrNotExist}
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	return fmt.Errorf("Error %s %s/%s: status %d (%s): %s", method, bucket, key, res.StatusCode, http.StatusText(res.StatusCode), strings.TrimSpace(string(body)))
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req, covering the host, all headers already set
+// and the x-amz-* headers added here.  If no credentials are configured, the request is left unsigned.
+func (c *s3Client) sign(req *http.Request, payloadHash string) {
+	if c.accessKey == "" {
+		return
+	}
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	headerNames := []string{}
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQueryString(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQueryString returns the query parameters in the form used by Signature Version 4.
+func canonicalQueryString(query url.Values) string {
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes s as required by Signature Version 4: everything except unreserved characters is percent-encoded,
+// and slashes are encoded only if encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	res := ""
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~':
+			res += string(c)
+		case c == '/' && !encodeSlash:
+			res += "/"
+		default:
+			res += fmt.Sprintf("%%%02X", c)
+		}
+	}
+	return res
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
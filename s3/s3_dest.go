@@ -0,0 +1,226 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/putblobdigest"
+	"github.com/containers/image/pkg/tmpdir"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type s3ImageDestination struct {
+	ref                  s3Reference
+	client               *s3Client
+	bigFilesTemporaryDir string // Where blobs are staged before being uploaded
+	manifest             []byte
+	signatures           [][]byte
+}
+
+// newImageDestination returns an ImageDestination for writing to an OCI layout in an S3 bucket.
+func newImageDestination(ctx *types.SystemContext, ref s3Reference) (*s3ImageDestination, error) {
+	client, err := newS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &s3ImageDestination{ref: ref, client: client, bigFilesTemporaryDir: tmpdir.TemporaryDirectoryForBigFiles(ctx)}, nil
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (d *s3ImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+func (d *s3ImageDestination) Close() {
+}
+
+func (d *s3ImageDestination) SupportedManifestMIMETypes() []string {
+	return []string{
+		imgspecv1.MediaTypeImageManifest,
+		manifest.DockerV2Schema2MediaType,
+	}
+}
+
+// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
+// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
+func (d *s3ImageDestination) SupportsSignatures(ctx context.Context) error {
+	return nil
+}
+
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d *s3ImageDestination) DesiredLayerCompression() types.LayerCompression {
+	return types.Compress
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *s3ImageDestination) HasThreadSafePutBlob() bool {
+	return true
+}
+
+// PutBlob writes contents of stream and returns data representing the result (with all data filled in).
+// inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
+// inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (d *s3ImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	// S3 needs to know the size of an object before it is uploaded, and we want to verify the digest
+	// before the blob becomes visible; so, stage the blob in a temporary file first.
+	blobFile, err := ioutil.TempFile(d.bigFilesTemporaryDir, "s3-put-blob")
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	defer func() {
+		blobFile.Close()
+		os.Remove(blobFile.Name())
+	}()
+
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	size, err := io.Copy(blobFile, stream)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	computedDigest := digester.Digest()
+	if inputInfo.Size != -1 && size != inputInfo.Size {
+		return types.BlobInfo{}, fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", computedDigest, inputInfo.Size, size)
+	}
+	if inputInfo.Digest != "" && inputInfo.Digest != computedDigest {
+		return types.BlobInfo{}, fmt.Errorf("Digest mismatch when copying blob, expected %s, got %s", inputInfo.Digest, computedDigest)
+	}
+	if _, err := blobFile.Seek(0, os.SEEK_SET); err != nil {
+		return types.BlobInfo{}, err
+	}
+
+	key, err := d.ref.blobKey(computedDigest)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	if exists, err := d.client.objectExists(d.ref.bucket, key); err == nil && exists {
+		return types.BlobInfo{Digest: computedDigest, Size: size}, nil
+	}
+	if err := d.client.putObject(d.ref.bucket, key, blobFile, size); err != nil {
+		return types.BlobInfo{}, err
+	}
+	return types.BlobInfo{Digest: computedDigest, Size: size}, nil
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *s3ImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	// Reusing blobs would require listing the bucket; this transport always uploads them again.
+	return false, types.BlobInfo{}, nil
+}
+
+func (d *s3ImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the s3: transport")
+	}
+	d.manifest = make([]byte, len(m))
+	copy(d.manifest, m)
+	return nil
+}
+
+func (d *s3ImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the s3: transport")
+	}
+	d.signatures = make([][]byte, len(signatures))
+	for i, sig := range signatures {
+		d.signatures[i] = make([]byte, len(sig))
+		copy(d.signatures[i], sig)
+	}
+	return nil
+}
+
+// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+// The manifest and signatures are uploaded first, and the image only becomes visible when index.json is updated.
+// WARNING: This does not have any transactional semantics:
+// - Uploaded data MAY be visible to others before Commit() is called
+// - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
+func (d *s3ImageDestination) Commit(ctx context.Context) error {
+	if d.manifest == nil {
+		return errors.New("Internal error: s3ImageDestination.Commit() called without PutManifest()")
+	}
+	mimeType := manifest.GuessMIMEType(d.manifest)
+	if mimeType != imgspecv1.MediaTypeImageManifest && mimeType != manifest.DockerV2Schema2MediaType {
+		return fmt.Errorf("Unsupported manifest type %q for s3:", mimeType)
+	}
+	manifestDigest, err := manifest.Digest(d.manifest)
+	if err != nil {
+		return err
+	}
+	if _, err := d.PutBlob(ctx, bytes.NewReader(d.manifest), types.BlobInfo{Digest: manifestDigest, Size: int64(len(d.manifest))}, false); err != nil {
+		return err
+	}
+	for i, sig := range d.signatures {
+		key, err := d.ref.signatureKey(manifestDigest, i)
+		if err != nil {
+			return err
+		}
+		if err := d.client.putObject(d.ref.bucket, key, bytes.NewReader(sig), int64(len(sig))); err != nil {
+			return err
+		}
+	}
+	// Remove any stale signatures left from a previous upload of the same manifest.
+	for i := len(d.signatures); ; i++ {
+		key, err := d.ref.signatureKey(manifestDigest, i)
+		if err != nil {
+			return err
+		}
+		exists, err := d.client.objectExists(d.ref.bucket, key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			break
+		}
+		if err := d.client.deleteObject(d.ref.bucket, key); err != nil {
+			return err
+		}
+	}
+
+	layout := []byte(`{"imageLayoutVersion": "1.0.0"}`)
+	if err := d.client.putObject(d.ref.bucket, d.ref.ociLayoutKey(), bytes.NewReader(layout), int64(len(layout))); err != nil {
+		return err
+	}
+	index, err := d.ref.readIndex(d.client)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		index = &ociIndex{SchemaVersion: 2}
+	}
+	manifests := []indexDescriptor{}
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationRefName] != d.ref.tag {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, indexDescriptor{
+		MediaType:   mimeType,
+		Digest:      manifestDigest,
+		Size:        int64(len(d.manifest)),
+		Annotations: map[string]string{annotationRefName: d.ref.tag},
+	})
+	return d.ref.writeIndex(d.client, index)
+}
@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is a minimal in-memory implementation of the S3 object API.
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	case "PUT":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.objects[r.URL.Path] = data
+	case "DELETE":
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	sys := &types.SystemContext{
+		S3Endpoint:        server.URL,
+		S3AccessKeyID:     "AKID",
+		S3SecretAccessKey: "secret",
+	}
+
+	ref, err := Transport.ParseReference("bucket/layout:tag")
+	require.NoError(t, err)
+
+	_, err = ref.NewImageSource(context.Background(), sys)
+	assert.Error(t, err)
+
+	dest, err := ref.NewImageDestination(context.Background(), sys)
+	require.NoError(t, err)
+	defer dest.Close()
+	blob := []byte("This is a test blob.")
+	info, err := dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: "", Size: -1}, false)
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest("sha256:a2b2aef9b151ae76f026978cd8a8fb9c5e984a8c0578204d62cb0f3e609265d1"), info.Digest)
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000", Size: -1}, false)
+	assert.Error(t, err)
+	m := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	err = dest.PutManifest(context.Background(), m, nil)
+	require.NoError(t, err)
+	err = dest.PutSignatures(context.Background(), [][]byte{[]byte("sig1"), []byte("sig2")}, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, fake.objects, "/bucket/layout/oci-layout")
+	assert.Contains(t, fake.objects, "/bucket/layout/index.json")
+
+	src, err := ref.NewImageSource(context.Background(), sys)
+	require.NoError(t, err)
+	defer src.Close()
+	m2, mimeType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, m, m2)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, mimeType)
+	stream, _, err := src.GetBlob(context.Background(), info, blobinfocache.NoCache)
+	require.NoError(t, err)
+	contents, err := ioutil.ReadAll(stream)
+	stream.Close()
+	require.NoError(t, err)
+	assert.Equal(t, blob, contents)
+	sigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig1"), []byte("sig2")}, sigs)
+
+	// Rewriting the image with fewer signatures removes the stale ones.
+	dest2, err := ref.NewImageDestination(context.Background(), sys)
+	require.NoError(t, err)
+	defer dest2.Close()
+	err = dest2.PutManifest(context.Background(), m, nil)
+	require.NoError(t, err)
+	err = dest2.PutSignatures(context.Background(), [][]byte{[]byte("sig3")}, nil)
+	require.NoError(t, err)
+	err = dest2.Commit(context.Background())
+	require.NoError(t, err)
+	sigs, err = src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig3")}, sigs)
+
+	err = ref.DeleteImage(context.Background(), sys)
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), sys)
+	assert.Error(t, err)
+	err = ref.DeleteImage(context.Background(), sys)
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+type s3ImageSource struct {
+	ref        s3Reference
+	client     *s3Client
+	descriptor indexDescriptor
+}
+
+// newImageSource returns an ImageSource for reading from an OCI layout in an S3 bucket.
+func newImageSource(ctx *types.SystemContext, ref s3Reference) (*s3ImageSource, error) {
+	client, err := newS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err := ref.getManifestDescriptor(client)
+	if err != nil {
+		return nil, err
+	}
+	return &s3ImageSource{ref: ref, client: client, descriptor: descriptor}, nil
+}
+
+// Reference returns the reference used to set up this source, _as specified by the user_
+// (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
+func (s *s3ImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+// Close removes resources associated with an initialized ImageSource, if any.
+func (s *s3ImageSource) Close() {
+}
+
+// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
+// It may use a remote (= slow) service.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *s3ImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return nil, "", fmt.Errorf("Getting target manifest not supported by s3:")
+	}
+	key, err := s.ref.blobKey(s.descriptor.Digest)
+	if err != nil {
+		return nil, "", err
+	}
+	m, err := s.client.readObject(s.ref.bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return m, s.descriptor.MediaType, nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *s3ImageSource) HasThreadSafeGetBlob() bool {
+	return true
+}
+
+// GetBlob returns a stream for the specified blob, and the blob's size (or -1 if unknown).
+func (s *s3ImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	key, err := s.ref.blobKey(info.Digest)
+	if err != nil {
+		return nil, -1, err
+	}
+	return s.client.getObject(s.ref.bucket, key)
+}
+
+// GetSignatures returns the image's signatures.  It may use a remote (= slow) service.
+func (s *s3ImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	if instanceDigest != nil {
+		return nil, errors.New("Manifest lists are not supported by the s3: transport")
+	}
+	signatures := [][]byte{}
+	for i := 0; ; i++ {
+		key, err := s.ref.signatureKey(s.descriptor.Digest, i)
+		if err != nil {
+			return nil, err
+		}
+		signature, err := s.client.readObject(s.ref.bucket, key)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return nil, err
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
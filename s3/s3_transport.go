@@ -0,0 +1,299 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// Transport is an ImageTransport for OCI image layouts stored in S3-compatible object storage.
+var Transport = s3Transport{}
+
+type s3Transport struct{}
+
+func (t s3Transport) Name() string {
+	return "s3"
+}
+
+// ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
+// The expected format is bucket[/path/to/layout][:tag].
+func (t s3Transport) ParseReference(reference string) (types.ImageReference, error) {
+	location, tag := reference, "latest"
+	if sep := strings.Index(reference, ":"); sep != -1 {
+		location, tag = reference[:sep], reference[sep+1:]
+	}
+	bucket, prefix := location, ""
+	if sep := strings.Index(location, "/"); sep != -1 {
+		bucket, prefix = location[:sep], location[sep+1:]
+		if prefix == "" {
+			return nil, fmt.Errorf("Invalid S3 reference %q: empty path within the bucket", reference)
+		}
+	}
+	return NewReference(bucket, prefix, tag)
+}
+
+// bucketRegexp matches valid S3 bucket names.
+var bucketRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// refRegexp matches valid tags; this is the same as docker/reference.tagRegexp.
+var refRegexp = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// ValidatePolicyConfigurationScope checks that scope is a valid name for a signature.PolicyTransportScopes keys
+// (i.e. a valid PolicyConfigurationIdentity() or PolicyConfigurationNamespaces() return value).
+// It is acceptable to allow an invalid value which will never be matched, it can "only" cause user confusion.
+// scope passed to this function will not be "", that value is always allowed.
+func (t s3Transport) ValidatePolicyConfigurationScope(scope string) error {
+	location := scope
+	if sep := strings.Index(scope, ":"); sep != -1 {
+		location = scope[:sep]
+	}
+	bucket, prefix := location, ""
+	if sep := strings.Index(location, "/"); sep != -1 {
+		bucket, prefix = location[:sep], location[sep+1:]
+		if prefix == "" {
+			return fmt.Errorf("Invalid scope %s: Uses non-canonical format, perhaps try %s", scope, bucket)
+		}
+	}
+	if !bucketRegexp.MatchString(bucket) {
+		return fmt.Errorf("Invalid scope %s: %q is not a valid bucket name", scope, bucket)
+	}
+	if prefix != "" && path.Clean(prefix) != prefix {
+		return fmt.Errorf("Invalid scope %s: Uses non-canonical format, perhaps try %s/%s", scope, bucket, path.Clean(prefix))
+	}
+	return nil
+}
+
+// s3Reference is an ImageReference for an OCI image layout stored in an S3 bucket.
+type s3Reference struct {
+	bucket string
+	prefix string // Path of the layout within the bucket, without leading or trailing slashes; may be "".
+	tag    string
+}
+
+// NewReference returns an S3 reference for the image tagged tag in the OCI layout at prefix within bucket.
+func NewReference(bucket, prefix, tag string) (types.ImageReference, error) {
+	if !bucketRegexp.MatchString(bucket) {
+		return nil, fmt.Errorf("Invalid S3 bucket name %q", bucket)
+	}
+	if prefix != "" {
+		if strings.HasPrefix(prefix, "/") || path.Clean(prefix) != prefix || strings.HasPrefix(prefix, "../") || prefix == ".." {
+			return nil, fmt.Errorf("Invalid path %q within S3 bucket %s", prefix, bucket)
+		}
+	}
+	if strings.Contains(prefix, ":") {
+		return nil, fmt.Errorf("Invalid path %q within S3 bucket %s: contains a colon", prefix, bucket)
+	}
+	if !refRegexp.MatchString(tag) {
+		return nil, fmt.Errorf("Invalid tag %q", tag)
+	}
+	return s3Reference{bucket: bucket, prefix: prefix, tag: tag}, nil
+}
+
+func (ref s3Reference) Transport() types.ImageTransport {
+	return Transport
+}
+
+// location returns the bucket and path of the layout, in the bucket[/path] format.
+func (ref s3Reference) location() string {
+	if ref.prefix == "" {
+		return ref.bucket
+	}
+	return ref.bucket + "/" + ref.prefix
+}
+
+// StringWithinTransport returns a string representation of the reference, which MUST be such that
+// reference.Transport().ParseReference(reference.StringWithinTransport()) returns an equivalent reference.
+// NOTE: The returned string is not promised to be equal to the original input to ParseReference;
+// e.g. default attribute values omitted by the user may be filled in in the return value, or vice versa.
+// WARNING: Do not use the return value in the UI to describe an image, it does not contain the Transport().Name() prefix.
+func (ref s3Reference) StringWithinTransport() string {
+	return fmt.Sprintf("%s:%s", ref.location(), ref.tag)
+}
+
+// DockerReference returns a Docker reference associated with this reference
+// (fully explicit, i.e. !reference.IsNameOnly, but reflecting user intent,
+// not e.g. after redirect or alias processing), or nil if unknown/not applicable.
+func (ref s3Reference) DockerReference() reference.Named {
+	return nil
+}
+
+// PolicyConfigurationIdentity returns a string representation of the reference, suitable for policy lookup.
+// This MUST reflect user intent, not e.g. after processing of third-party redirects or aliases;
+// The value SHOULD be fully explicit about its semantics, with no hidden defaults, AND canonical
+// (i.e. various references with exactly the same semantics should return the same configuration identity)
+// It is fine for the return value to be equal to StringWithinTransport(), and it is desirable but
+// not required/guaranteed that it will be a valid input to Transport().ParseReference().
+// Returns "" if configuration identities for these references are not supported.
+func (ref s3Reference) PolicyConfigurationIdentity() string {
+	return ref.StringWithinTransport()
+}
+
+// PolicyConfigurationNamespaces returns a list of other policy configuration namespaces to search
+// for if explicit configuration for PolicyConfigurationIdentity() is not set.  The list will be processed
+// in order, terminating on first match, and an implicit "" is always checked at the end.
+// It is STRONGLY recommended for the first element, if any, to be a prefix of PolicyConfigurationIdentity(),
+// and each following element to be a prefix of the element preceding it.
+func (ref s3Reference) PolicyConfigurationNamespaces() []string {
+	res := []string{}
+	location := ref.location()
+	for {
+		res = append(res, location)
+		lastSlash := strings.LastIndex(location, "/")
+		if lastSlash == -1 {
+			break
+		}
+		location = location[:lastSlash]
+	}
+	return res
+}
+
+// NewImage returns a types.ImageCloser for this reference, possibly specialized for this ImageTransport.
+// The caller must call .Close() on the returned ImageCloser.
+// NOTE: If any kind of signature verification should happen, build an UnparsedImage from the value returned by NewImageSource,
+// verify that UnparsedImage, and convert it into a real Image via image.FromUnparsedImage.
+func (ref s3Reference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	src, err := newImageSource(sys, ref)
+	if err != nil {
+		return nil, err
+	}
+	return image.FromSource(ctx, sys, src)
+}
+
+// NewImageSource returns a types.ImageSource for this reference.
+// The caller must call .Close() on the returned ImageSource.
+func (ref s3Reference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	src, err := newImageSource(sys, ref)
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// NewImageDestination returns a types.ImageDestination for this reference.
+// The caller must call .Close() on the returned ImageDestination.
+func (ref s3Reference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := newImageDestination(sys, ref)
+	if err != nil {
+		return nil, err
+	}
+	return dest, nil
+}
+
+// DeleteImage deletes the named image from the registry, if supported.
+// Only the tag is removed from index.json; blobs are left in place because other images may share them.
+func (ref s3Reference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	client, err := newS3Client(sys)
+	if err != nil {
+		return err
+	}
+	index, err := ref.readIndex(client)
+	if err != nil {
+		return err
+	}
+	manifests := []indexDescriptor{}
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationRefName] != ref.tag {
+			manifests = append(manifests, m)
+		}
+	}
+	if len(manifests) == len(index.Manifests) {
+		return fmt.Errorf("No manifest tagged %q found in s3:%s", ref.tag, ref.location())
+	}
+	index.Manifests = manifests
+	return ref.writeIndex(client, index)
+}
+
+// key returns the key of the object with the specified path relative to the layout.
+func (ref s3Reference) key(relativePath string) string {
+	return path.Join(ref.prefix, relativePath)
+}
+
+// ociLayoutKey returns the key of the oci-layout file.
+func (ref s3Reference) ociLayoutKey() string {
+	return ref.key("oci-layout")
+}
+
+// indexKey returns the key of index.json.
+func (ref s3Reference) indexKey() string {
+	return ref.key("index.json")
+}
+
+// blobKey returns the key of a blob with the specified digest.
+func (ref s3Reference) blobKey(digest digest.Digest) (string, error) {
+	if err := digest.Validate(); err != nil {
+		return "", fmt.Errorf("Invalid digest %q: %v", digest, err)
+	}
+	return ref.key(path.Join("blobs", digest.Algorithm().String(), digest.Hex())), nil
+}
+
+// signatureKey returns the key of the signature with the specified index, for the manifest with the specified digest.
+// OCI layouts have no place for signatures, so we store them next to the blobs, in a separate directory.
+func (ref s3Reference) signatureKey(manifestDigest digest.Digest, index int) (string, error) {
+	if err := manifestDigest.Validate(); err != nil {
+		return "", fmt.Errorf("Invalid digest %q: %v", manifestDigest, err)
+	}
+	return ref.key(path.Join("signatures", manifestDigest.Algorithm().String(), manifestDigest.Hex(), fmt.Sprintf("signature-%d", index+1))), nil
+}
+
+// annotationRefName is the index.json annotation which records the tag of a manifest.
+const annotationRefName = "org.opencontainers.image.ref.name"
+
+// ociIndex is the contents of index.json in an OCI image layout.
+// FIXME: Use the image-spec type once the vendored version provides it.
+type ociIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Manifests     []indexDescriptor `json:"manifests"`
+}
+
+// indexDescriptor is a descriptor of a manifest in ociIndex.
+type indexDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// readIndex returns the parsed index.json of the layout.
+// If the layout does not contain an index.json, the returned error satisfies os.IsNotExist.
+func (ref s3Reference) readIndex(client *s3Client) (*ociIndex, error) {
+	data, err := client.readObject(ref.bucket, ref.indexKey())
+	if err != nil {
+		return nil, err
+	}
+	index := ociIndex{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("Error parsing s3:%s/%s: %v", ref.bucket, ref.indexKey(), err)
+	}
+	return &index, nil
+}
+
+// writeIndex replaces index.json of the layout with index.
+func (ref s3Reference) writeIndex(client *s3Client, index *ociIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return client.putObject(ref.bucket, ref.indexKey(), strings.NewReader(string(data)), int64(len(data)))
+}
+
+// getManifestDescriptor returns the descriptor of the manifest tagged ref.tag.
+func (ref s3Reference) getManifestDescriptor(client *s3Client) (indexDescriptor, error) {
+	index, err := ref.readIndex(client)
+	if err != nil {
+		return indexDescriptor{}, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[annotationRefName] == ref.tag {
+			return desc, nil
+		}
+	}
+	return indexDescriptor{}, fmt.Errorf("No manifest tagged %q found in s3:%s", ref.tag, ref.location())
+}
@@ -0,0 +1,114 @@
+package s3
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "s3", Transport.Name())
+}
+
+func TestTransportParseReference(t *testing.T) {
+	for _, c := range []struct{ input, bucket, prefix, tag string }{
+		{"bucket", "bucket", "", "latest"},
+		{"bucket:tag", "bucket", "", "tag"},
+		{"bucket/path/to/layout", "bucket", "path/to/layout", "latest"},
+		{"bucket/path/to/layout:tag", "bucket", "path/to/layout", "tag"},
+		{"Bucket", "", "", ""},                  // Invalid bucket name
+		{"bucket/", "", "", ""},                 // Non-canonical path
+		{"bucket//path", "", "", ""},            // Non-canonical path
+		{"bucket/../path", "", "", ""},          // Path escaping the bucket
+		{"bucket/path:", "", "", ""},            // Empty tag
+		{"bucket/path:invalid:tag", "", "", ""}, // Invalid tag
+	} {
+		ref, err := Transport.ParseReference(c.input)
+		if c.bucket == "" {
+			assert.Error(t, err, c.input)
+			continue
+		}
+		require.NoError(t, err, c.input)
+		s3Ref, ok := ref.(s3Reference)
+		require.True(t, ok, c.input)
+		assert.Equal(t, c.bucket, s3Ref.bucket, c.input)
+		assert.Equal(t, c.prefix, s3Ref.prefix, c.input)
+		assert.Equal(t, c.tag, s3Ref.tag, c.input)
+	}
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	for _, scope := range []string{
+		"bucket",
+		"bucket/path",
+		"bucket/path/to/layout:tag",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.NoError(t, err, scope)
+	}
+
+	for _, scope := range []string{
+		"Bucket",
+		"bucket/",
+		"bucket/double//slashes",
+		"bucket/has/./dot",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.Error(t, err, scope)
+	}
+}
+
+func TestReferenceStringWithinTransport(t *testing.T) {
+	for _, c := range []struct{ input, expected string }{
+		{"bucket", "bucket:latest"},
+		{"bucket/path/to/layout:tag", "bucket/path/to/layout:tag"},
+	} {
+		ref, err := Transport.ParseReference(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, ref.StringWithinTransport(), c.input)
+		assert.Equal(t, c.expected, ref.PolicyConfigurationIdentity(), c.input)
+		ref2, err := Transport.ParseReference(ref.StringWithinTransport())
+		require.NoError(t, err, c.input)
+		assert.Equal(t, ref, ref2, c.input)
+	}
+}
+
+func TestReferencePolicyConfigurationNamespaces(t *testing.T) {
+	ref, err := Transport.ParseReference("bucket/path/to/layout:tag")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bucket/path/to/layout", "bucket/path/to", "bucket/path", "bucket"}, ref.PolicyConfigurationNamespaces())
+	for _, ns := range ref.PolicyConfigurationNamespaces() {
+		err := Transport.ValidatePolicyConfigurationScope(ns)
+		assert.NoError(t, err, ns)
+	}
+}
+
+func TestReferenceDockerReference(t *testing.T) {
+	ref, err := Transport.ParseReference("bucket:tag")
+	require.NoError(t, err)
+	assert.Nil(t, ref.DockerReference())
+}
+
+func TestReferenceKeys(t *testing.T) {
+	ref, err := NewReference("bucket", "path", "tag")
+	require.NoError(t, err)
+	s3Ref := ref.(s3Reference)
+	assert.Equal(t, "path/index.json", s3Ref.indexKey())
+	assert.Equal(t, "path/oci-layout", s3Ref.ociLayoutKey())
+	const hex = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	key, err := s3Ref.blobKey("sha256:" + hex)
+	require.NoError(t, err)
+	assert.Equal(t, "path/blobs/sha256/"+hex, key)
+	_, err = s3Ref.blobKey("sha256:../../x/y")
+	assert.Error(t, err)
+	key, err = s3Ref.signatureKey("sha256:"+hex, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "path/signatures/sha256/"+hex+"/signature-1", key)
+	_, err = s3Ref.signatureKey("sha256:../../x/y", 0)
+	assert.Error(t, err)
+
+	ref, err = NewReference("bucket", "", "tag")
+	require.NoError(t, err)
+	assert.Equal(t, "index.json", ref.(s3Reference).indexKey())
+}
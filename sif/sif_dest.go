@@ -0,0 +1,331 @@
+package sif
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/putblobdigest"
+	"github.com/containers/image/pkg/rootfs"
+	"github.com/containers/image/pkg/tmpdir"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// configObjectName is the name of the SIF object containing the image configuration.
+const configObjectName = "oci-config.json"
+
+type sifImageDestination struct {
+	ref        sifReference
+	tmpDirPath string
+	blobs      map[digest.Digest]string // Digest -> path of the staged blob
+	manifest   []byte
+}
+
+// newImageDestination returns an ImageDestination for writing a SIF file.
+// Blobs are staged in a temporary directory, and the root filesystem is assembled there, before the SIF file is created by Commit.
+func newImageDestination(sys *types.SystemContext, ref sifReference) (*sifImageDestination, error) {
+	tmpDirPath, err := ioutil.TempDir(tmpdir.TemporaryDirectoryForBigFiles(sys), "sif")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating a temporary directory: %v", err)
+	}
+	return &sifImageDestination{
+		ref:        ref,
+		tmpDirPath: tmpDirPath,
+		blobs:      map[digest.Digest]string{},
+	}, nil
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (d *sifImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+func (d *sifImageDestination) Close() {
+	os.RemoveAll(d.tmpDirPath)
+}
+
+func (d *sifImageDestination) SupportedManifestMIMETypes() []string {
+	// We need a separate config object to record in the SIF file.
+	return []string{
+		imgspecv1.MediaTypeImageManifest,
+		manifest.DockerV2Schema2MediaType,
+	}
+}
+
+// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
+// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
+func (d *sifImageDestination) SupportsSignatures(ctx context.Context) error {
+	return errors.New("Storing signatures for sif: destinations is not supported")
+}
+
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (d *sifImageDestination) DesiredLayerCompression() types.LayerCompression {
+	// The layers are extracted into a squashfs image anyway.
+	return types.PreserveOriginal
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (d *sifImageDestination) HasThreadSafePutBlob() bool {
+	return false // d.blobs is not protected by a lock
+}
+
+// PutBlob writes contents of stream and returns data representing the result (with all data filled in).
+// inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
+// inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (d *sifImageDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	blobFile, err := ioutil.TempFile(d.tmpDirPath, "blob")
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	blobPath := blobFile.Name()
+	succeeded := false
+	defer func() {
+		blobFile.Close()
+		if !succeeded {
+			os.Remove(blobPath)
+		}
+	}()
+
+	digester, stream := putblobdigest.DigestIfUnverified(stream, inputInfo)
+	size, err := io.Copy(blobFile, stream)
+	if err != nil {
+		return types.BlobInfo{}, err
+	}
+	computedDigest := digester.Digest()
+	if inputInfo.Size != -1 && size != inputInfo.Size {
+		return types.BlobInfo{}, fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", computedDigest, inputInfo.Size, size)
+	}
+
+	succeeded = true
+	d.blobs[computedDigest] = blobPath
+	return types.BlobInfo{Digest: computedDigest, Size: size}, nil
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+func (d *sifImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	// Blobs are only staged in a temporary directory until Commit(), so there is nothing to reuse.
+	return false, types.BlobInfo{}, nil
+}
+
+func (d *sifImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the sif: transport")
+	}
+	d.manifest = make([]byte, len(m))
+	copy(d.manifest, m)
+	return nil
+}
+
+func (d *sifImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	if len(signatures) != 0 {
+		return errors.New("Storing signatures for sif: destinations is not supported")
+	}
+	return nil
+}
+
+// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+// The layers are extracted into a root filesystem, which is converted into a squashfs image using mksquashfs(1),
+// and stored in the SIF file as the primary system partition, together with the image configuration.
+// The file is created under a temporary name and renamed into place, so an existing file is only replaced on success.
+func (d *sifImageDestination) Commit(ctx context.Context) error {
+	if d.manifest == nil {
+		return errors.New("Internal error: sifImageDestination.Commit() called without PutManifest()")
+	}
+	img, err := image.FromSource(ctx, nil, &stagedImageSource{d})
+	if err != nil {
+		return fmt.Errorf("Error parsing the manifest: %v", err)
+	}
+	defer img.Close()
+
+	configBlob, err := img.ConfigBlob(ctx)
+	if err != nil {
+		return err
+	}
+	config := struct {
+		Architecture string `json:"architecture"`
+	}{}
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return fmt.Errorf("Error parsing the image configuration: %v", err)
+	}
+	arch, err := sifArch(config.Architecture)
+	if err != nil {
+		return err
+	}
+
+	rootfsDir := filepath.Join(d.tmpDirPath, "rootfs")
+	if err := os.Mkdir(rootfsDir, 0755); err != nil {
+		return err
+	}
+	builder := rootfs.NewBuilder(rootfsDir, nil, nil)
+	for _, layer := range img.LayerInfos() {
+		if err := d.applyLayer(builder, layer.Digest); err != nil {
+			return err
+		}
+	}
+	if err := builder.Finish(); err != nil {
+		return err
+	}
+
+	squashfsPath := filepath.Join(d.tmpDirPath, "rootfs.squashfs")
+	logrus.Debugf("Creating squashfs image %s", squashfsPath)
+	if out, err := exec.Command("mksquashfs", rootfsDir, squashfsPath, "-noappend", "-all-root").CombinedOutput(); err != nil {
+		return fmt.Errorf("Error creating a squashfs image: %v: %s", err, string(out))
+	}
+	// The root filesystem is not needed any more; do not keep two copies of the image around.
+	if err := os.RemoveAll(rootfsDir); err != nil {
+		return err
+	}
+	return d.writeSIFFile(arch, squashfsPath, configBlob)
+}
+
+// applyLayer applies the staged layer with the specified digest using builder.
+func (d *sifImageDestination) applyLayer(builder *rootfs.Builder, layerDigest digest.Digest) error {
+	blobPath, ok := d.blobs[layerDigest]
+	if !ok {
+		return fmt.Errorf("Layer %s was not stored", layerDigest)
+	}
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := builder.ApplyLayer(f); err != nil {
+		return fmt.Errorf("Error applying layer %s: %v", layerDigest, err)
+	}
+	return nil
+}
+
+// writeSIFFile creates the SIF file for d.ref, containing the squashfs image at squashfsPath and configBlob.
+func (d *sifImageDestination) writeSIFFile(arch [3]byte, squashfsPath string, configBlob []byte) error {
+	squashfs, err := os.Open(squashfsPath)
+	if err != nil {
+		return err
+	}
+	defer squashfs.Close()
+	fi, err := squashfs.Stat()
+	if err != nil {
+		return err
+	}
+	id, err := newSIFID()
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(d.ref.resolvedFile), ".sif")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	succeeded := false
+	defer func() {
+		f.Close()
+		if !succeeded {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	objects := []sifObject{
+		{
+			datatype: sifDataGenericJSON,
+			name:     configObjectName,
+			size:     int64(len(configBlob)),
+			data:     bytes.NewReader(configBlob),
+		},
+		{
+			datatype: sifDataPartition,
+			name:     "rootfs",
+			extra:    sifPartition{Fstype: sifFsSquash, Parttype: sifPartPrimSys, Arch: arch},
+			size:     fi.Size(),
+			data:     squashfs,
+		},
+	}
+	if err := writeSIF(f, arch, id, time.Now(), objects); err != nil {
+		return fmt.Errorf("Error writing %s: %v", d.ref.file, err)
+	}
+	if err := f.Chmod(0755); err != nil { // SIF files are directly executable, using the launch script in the header.
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, d.ref.resolvedFile); err != nil {
+		return err
+	}
+	succeeded = true
+	return nil
+}
+
+// stagedImageSource is an ImageSource for the data staged in a sifImageDestination,
+// used to parse the manifest at Commit() time.
+type stagedImageSource struct {
+	dest *sifImageDestination
+}
+
+func (s *stagedImageSource) Reference() types.ImageReference {
+	return s.dest.ref
+}
+
+func (s *stagedImageSource) Close() {
+}
+
+func (s *stagedImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return nil, "", fmt.Errorf("Getting target manifest not supported by sif:")
+	}
+	return s.dest.manifest, manifest.GuessMIMEType(s.dest.manifest), nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *stagedImageSource) HasThreadSafeGetBlob() bool {
+	return false
+}
+
+func (s *stagedImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blobPath, ok := s.dest.blobs[info.Digest]
+	if !ok {
+		return nil, -1, fmt.Errorf("Blob %s was not stored", info.Digest)
+	}
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return nil, -1, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, -1, err
+	}
+	return f, fi.Size(), nil
+}
+
+func (s *stagedImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	return [][]byte{}, nil
+}
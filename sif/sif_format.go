@@ -0,0 +1,237 @@
+package sif
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// This file implements writing the SIF (Singularity Image Format) container, version 1,
+// as defined by github.com/sylabs/sif.  All integers are little-endian.
+
+const (
+	sifLaunchScript = "#!/usr/bin/env run-singularity\n"
+	sifMagic        = "SIF_MAGIC"
+	sifVersion      = "01"
+
+	sifDescriptorEntries = 48   // The number of descriptors preallocated in the descriptor table.
+	sifDataAlignment     = 4096 // Alignment of data objects within the file.
+
+	sifDataPartition   = 0x4004 // A filesystem image
+	sifDataGenericJSON = 0x4006 // Generic JSON metadata
+
+	sifGroupMask    = 0xf0000000 // Marks a descriptor group ID
+	sifDefaultGroup = sifGroupMask | 1
+
+	sifFsSquash      = 1 // Partition filesystem type: squashfs
+	sifPartPrimSys   = 2 // Partition type: the primary system partition
+	sifDescrNameLen  = 128
+	sifDescrExtraLen = 384
+)
+
+// sifHeader is the global header at the start of a SIF file.
+type sifHeader struct {
+	Launch  [32]byte // #! shell execution line
+	Magic   [10]byte // "SIF_MAGIC"
+	Version [3]byte  // SIF version
+	Arch    [3]byte  // Architecture of the primary partition
+	ID      [16]byte // Image UUID
+
+	Ctime int64 // Creation time
+	Mtime int64 // Last modification time
+
+	Dfree    int64 // Number of free descriptors
+	Dtotal   int64 // Total number of descriptors
+	Descroff int64 // Offset of the descriptor table
+	Descrlen int64 // Length of the descriptor table
+	Dataoff  int64 // Offset of the data area
+	Datalen  int64 // Length of the data area
+}
+
+// sifDescriptor describes a data object in a SIF file.
+type sifDescriptor struct {
+	Datatype int32  // Type of the data object
+	Used     bool   // True if this descriptor is in use
+	ID       uint32 // Unique ID of the descriptor
+	Groupid  uint32 // Group ID of the object, or'ed with sifGroupMask
+	Link     uint32 // ID of a related object, if any
+
+	Fileoff  int64 // Offset of the object from the start of the file
+	Filelen  int64 // Length of the object
+	Storelen int64 // Length of the object including alignment padding
+
+	Ctime int64 // Creation time
+	Mtime int64 // Last modification time
+	UID   int64 // Creator UID
+	GID   int64 // Creator GID
+
+	Name  [sifDescrNameLen]byte  // Name of the object
+	Extra [sifDescrExtraLen]byte // Type-specific data
+}
+
+// sifPartition is stored in sifDescriptor.Extra of sifDataPartition objects.
+type sifPartition struct {
+	Fstype   int32
+	Parttype int32
+	Arch     [3]byte
+}
+
+// sifArchCodes maps Go architecture names to SIF architecture codes.
+var sifArchCodes = map[string]string{
+	"386":      "01",
+	"amd64":    "02",
+	"arm":      "03",
+	"arm64":    "04",
+	"ppc64":    "05",
+	"ppc64le":  "06",
+	"mips":     "07",
+	"mipsle":   "08",
+	"mips64":   "09",
+	"mips64le": "10",
+	"s390x":    "11",
+}
+
+// sifArch returns the SIF architecture code for the Go architecture name goarch.
+func sifArch(goarch string) ([3]byte, error) {
+	res := [3]byte{}
+	code, ok := sifArchCodes[goarch]
+	if !ok {
+		return res, fmt.Errorf("Architecture %q is not supported by SIF", goarch)
+	}
+	copy(res[:], code)
+	return res, nil
+}
+
+// sifObject is a data object to be written into a SIF file.
+type sifObject struct {
+	datatype int32
+	name     string
+	extra    interface{} // Written into sifDescriptor.Extra using encoding/binary, if not nil
+	size     int64
+	data     io.Reader // Must provide exactly size bytes
+}
+
+// newSIFID returns a new random (version 4) UUID.
+func newSIFID() ([16]byte, error) {
+	id := [16]byte{}
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return id, err
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id, nil
+}
+
+// alignUp returns offset rounded up to a multiple of sifDataAlignment.
+func alignUp(offset int64) int64 {
+	return (offset + sifDataAlignment - 1) / sifDataAlignment * sifDataAlignment
+}
+
+// writeSIF writes a SIF file containing objects, in order, to w.
+func writeSIF(w io.Writer, arch [3]byte, id [16]byte, created time.Time, objects []sifObject) error {
+	if len(objects) > sifDescriptorEntries {
+		return fmt.Errorf("Too many SIF objects (%d, maximum %d)", len(objects), sifDescriptorEntries)
+	}
+	timestamp := created.Unix()
+	descrLen := int64(sifDescriptorEntries * binary.Size(sifDescriptor{}))
+	header := sifHeader{
+		Arch:     arch,
+		ID:       id,
+		Ctime:    timestamp,
+		Mtime:    timestamp,
+		Dfree:    int64(sifDescriptorEntries - len(objects)),
+		Dtotal:   sifDescriptorEntries,
+		Descroff: int64(binary.Size(sifHeader{})),
+		Descrlen: descrLen,
+	}
+	copy(header.Launch[:], sifLaunchScript)
+	copy(header.Magic[:], sifMagic)
+	copy(header.Version[:], sifVersion)
+	header.Dataoff = header.Descroff + header.Descrlen
+
+	descriptors := make([]sifDescriptor, sifDescriptorEntries)
+	offset := header.Dataoff
+	for i, object := range objects {
+		if len(object.name) > sifDescrNameLen {
+			return fmt.Errorf("SIF object name %q is too long", object.name)
+		}
+		fileoff := alignUp(offset)
+		d := &descriptors[i]
+		d.Datatype = object.datatype
+		d.Used = true
+		d.ID = uint32(i + 1)
+		d.Groupid = sifDefaultGroup
+		d.Fileoff = fileoff
+		d.Filelen = object.size
+		d.Storelen = fileoff - offset + object.size
+		d.Ctime = timestamp
+		d.Mtime = timestamp
+		copy(d.Name[:], object.name)
+		if object.extra != nil {
+			extra := newFixedBuffer(d.Extra[:])
+			if err := binary.Write(extra, binary.LittleEndian, object.extra); err != nil {
+				return fmt.Errorf("Error encoding SIF object %q: %v", object.name, err)
+			}
+		}
+		offset = fileoff + object.size
+	}
+	header.Datalen = offset - header.Dataoff
+
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, descriptors); err != nil {
+		return err
+	}
+	offset = header.Dataoff
+	for i, object := range objects {
+		if err := writeZeros(w, descriptors[i].Fileoff-offset); err != nil {
+			return err
+		}
+		n, err := io.Copy(w, object.data)
+		if err != nil {
+			return fmt.Errorf("Error writing SIF object %q: %v", object.name, err)
+		}
+		if n != object.size {
+			return fmt.Errorf("Size mismatch writing SIF object %q, expected %d, got %d", object.name, object.size, n)
+		}
+		offset = descriptors[i].Fileoff + n
+	}
+	return nil
+}
+
+// writeZeros writes n zero bytes to w.
+func writeZeros(w io.Writer, n int64) error {
+	_, err := io.CopyN(w, zeroReader{}, n)
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// fixedBuffer is an io.Writer which fills a fixed-size byte slice, failing if it would overflow.
+type fixedBuffer struct {
+	buf []byte
+	off int
+}
+
+func newFixedBuffer(buf []byte) *fixedBuffer {
+	return &fixedBuffer{buf: buf}
+}
+
+func (b *fixedBuffer) Write(p []byte) (int, error) {
+	if len(p) > len(b.buf)-b.off {
+		return 0, io.ErrShortBuffer
+	}
+	n := copy(b.buf[b.off:], p)
+	b.off += n
+	return n, nil
+}
@@ -0,0 +1,111 @@
+package sif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSIFArch(t *testing.T) {
+	arch, err := sifArch("amd64")
+	require.NoError(t, err)
+	assert.Equal(t, [3]byte{'0', '2', 0}, arch)
+	arch, err = sifArch("s390x")
+	require.NoError(t, err)
+	assert.Equal(t, [3]byte{'1', '1', 0}, arch)
+	_, err = sifArch("unknown")
+	assert.Error(t, err)
+	_, err = sifArch("")
+	assert.Error(t, err)
+}
+
+func TestNewSIFID(t *testing.T) {
+	id1, err := newSIFID()
+	require.NoError(t, err)
+	id2, err := newSIFID()
+	require.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+	assert.Equal(t, byte(0x40), id1[6]&0xf0)
+	assert.Equal(t, byte(0x80), id1[8]&0xc0)
+}
+
+func TestWriteSIF(t *testing.T) {
+	arch, err := sifArch("arm64")
+	require.NoError(t, err)
+	id := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	created := time.Unix(1480000000, 0)
+	config := []byte(`{"architecture":"arm64"}`)
+	partition := bytes.Repeat([]byte{0xAB}, 5000)
+
+	var buf bytes.Buffer
+	err = writeSIF(&buf, arch, id, created, []sifObject{
+		{datatype: sifDataGenericJSON, name: configObjectName, size: int64(len(config)), data: bytes.NewReader(config)},
+		{
+			datatype: sifDataPartition,
+			name:     "rootfs",
+			extra:    sifPartition{Fstype: sifFsSquash, Parttype: sifPartPrimSys, Arch: arch},
+			size:     int64(len(partition)),
+			data:     bytes.NewReader(partition),
+		},
+	})
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	r := bytes.NewReader(data)
+	header := sifHeader{}
+	require.NoError(t, binary.Read(r, binary.LittleEndian, &header))
+	assert.Equal(t, 128, binary.Size(header))
+	assert.Equal(t, sifLaunchScript, string(bytes.TrimRight(header.Launch[:], "\x00")))
+	assert.Equal(t, sifMagic, string(bytes.TrimRight(header.Magic[:], "\x00")))
+	assert.Equal(t, sifVersion, string(bytes.TrimRight(header.Version[:], "\x00")))
+	assert.Equal(t, arch, header.Arch)
+	assert.Equal(t, id, header.ID)
+	assert.Equal(t, created.Unix(), header.Ctime)
+	assert.Equal(t, int64(sifDescriptorEntries), header.Dtotal)
+	assert.Equal(t, int64(sifDescriptorEntries-2), header.Dfree)
+	assert.Equal(t, int64(128), header.Descroff)
+	assert.Equal(t, header.Descroff+header.Descrlen, header.Dataoff)
+	assert.Equal(t, int64(len(data)), header.Dataoff+header.Datalen)
+
+	descriptors := make([]sifDescriptor, sifDescriptorEntries)
+	require.NoError(t, binary.Read(r, binary.LittleEndian, descriptors))
+	for i, c := range []struct {
+		datatype int32
+		name     string
+		contents []byte
+	}{
+		{sifDataGenericJSON, configObjectName, config},
+		{sifDataPartition, "rootfs", partition},
+	} {
+		d := descriptors[i]
+		assert.True(t, d.Used)
+		assert.Equal(t, c.datatype, d.Datatype)
+		assert.Equal(t, uint32(i+1), d.ID)
+		assert.Equal(t, uint32(sifDefaultGroup), d.Groupid)
+		assert.Equal(t, c.name, string(bytes.TrimRight(d.Name[:], "\x00")))
+		assert.Equal(t, int64(0), d.Fileoff%sifDataAlignment)
+		assert.Equal(t, int64(len(c.contents)), d.Filelen)
+		assert.Equal(t, c.contents, data[d.Fileoff:d.Fileoff+d.Filelen])
+	}
+	part := sifPartition{}
+	require.NoError(t, binary.Read(bytes.NewReader(descriptors[1].Extra[:]), binary.LittleEndian, &part))
+	assert.Equal(t, sifPartition{Fstype: sifFsSquash, Parttype: sifPartPrimSys, Arch: arch}, part)
+	for _, d := range descriptors[2:] {
+		assert.False(t, d.Used)
+	}
+
+	// Size mismatch
+	err = writeSIF(&bytes.Buffer{}, arch, id, created, []sifObject{
+		{datatype: sifDataGenericJSON, name: configObjectName, size: int64(len(config)) + 1, data: bytes.NewReader(config)},
+	})
+	assert.Error(t, err)
+	// Name too long
+	err = writeSIF(&bytes.Buffer{}, arch, id, created, []sifObject{
+		{datatype: sifDataGenericJSON, name: string(bytes.Repeat([]byte("a"), sifDescrNameLen+1)), size: 0, data: bytes.NewReader(nil)},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package sif
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "sif", Transport.Name())
+}
+
+func TestTransportParseReference(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sif-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for _, path := range []string{
+		"/etc",
+		tmpDir,
+		"relativepath.sif",
+		tmpDir + "/thisdoesnotexist.sif",
+		tmpDir + "/with:colon.sif",
+	} {
+		ref, err := Transport.ParseReference(path)
+		require.NoError(t, err, path)
+		sifRef, ok := ref.(sifReference)
+		require.True(t, ok)
+		assert.Equal(t, path, sifRef.file, path)
+		assert.Equal(t, path, ref.StringWithinTransport(), path)
+	}
+
+	for _, path := range []string{
+		"",
+		tmpDir + "/thisdoesnotexist/image.sif",
+	} {
+		_, err := Transport.ParseReference(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	for _, scope := range []string{
+		"/etc",
+		"/this/does/not/exist.sif",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.NoError(t, err, scope)
+	}
+
+	for _, scope := range []string{
+		"relative/path",
+		"/",
+		"/double//slashes",
+		"/has/./dot",
+		"/trailing/slash/",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.Error(t, err, scope)
+	}
+}
+
+func TestReferencePolicyConfiguration(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sif-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	resolvedTmpDir, err := filepath.EvalSymlinks(tmpDir)
+	require.NoError(t, err)
+
+	ref, err := NewReference(filepath.Join(tmpDir, "image.sif"))
+	require.NoError(t, err)
+	assert.Equal(t, resolvedTmpDir+"/image.sif", ref.PolicyConfigurationIdentity())
+	namespaces := ref.PolicyConfigurationNamespaces()
+	require.NotEmpty(t, namespaces)
+	assert.Equal(t, resolvedTmpDir, namespaces[0])
+	for _, ns := range namespaces {
+		assert.NoError(t, Transport.ValidatePolicyConfigurationScope(ns), ns)
+	}
+	assert.Nil(t, ref.DockerReference())
+}
+
+func TestReferenceNewImageSource(t *testing.T) {
+	ref, err := NewReference("/tmp/image.sif")
+	require.NoError(t, err)
+	_, err = ref.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+	_, err = ref.NewImage(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestReferenceNewImageDestination(t *testing.T) {
+	ref, err := NewReference("/tmp/image.sif")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	assert.Equal(t, ref, dest.Reference())
+	assert.Error(t, dest.SupportsSignatures(context.Background()))
+	assert.Equal(t, types.PreserveOriginal, dest.DesiredLayerCompression())
+	assert.NoError(t, dest.PutSignatures(context.Background(), [][]byte{}, nil))
+	assert.Error(t, dest.PutSignatures(context.Background(), [][]byte{[]byte("sig")}, nil))
+	assert.Error(t, dest.Commit(context.Background())) // No manifest
+}
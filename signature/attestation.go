@@ -0,0 +1,101 @@
+package signature
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/types"
+)
+
+const (
+	// dsseInTotoPayloadType is the DSSE payload type of in-toto statements.
+	dsseInTotoPayloadType = "application/vnd.in-toto+json"
+	// inTotoStatementTypePrefix is the prefix of the "_type" value of all versions of in-toto statements.
+	inTotoStatementTypePrefix = "https://in-toto.io/Statement/"
+)
+
+// dsseEnvelope is a DSSE (Dead Simple Signing Envelope), as used by cosign to store attestations.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"` // base64-encoded in JSON
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+// dsseSignature is a single signature in a dsseEnvelope.
+type dsseSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   []byte `json:"sig"` // base64-encoded in JSON
+}
+
+// inTotoSubject is an artifact an in-toto statement is about.
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"` // Algorithm name → hex-encoded digest value
+}
+
+// inTotoStatement is an in-toto attestation statement, e.g. carrying SLSA build provenance.
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// dssePreAuthEncoding returns the data actually signed by DSSE signatures of payload with payloadType.
+func dssePreAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// parseInTotoStatement parses the payload of an attestation into an inTotoStatement.
+func parseInTotoStatement(payload []byte) (*inTotoStatement, error) {
+	var s inTotoStatement
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return nil, InvalidSignatureError{msg: err.Error()}
+	}
+	if !strings.HasPrefix(s.Type, inTotoStatementTypePrefix) {
+		return nil, InvalidSignatureError{msg: fmt.Sprintf("Unrecognized in-toto statement type %s", s.Type)}
+	}
+	if s.PredicateType == "" {
+		return nil, InvalidSignatureError{msg: "Missing predicateType in in-toto statement"}
+	}
+	if len(s.Subject) == 0 {
+		return nil, InvalidSignatureError{msg: "Missing subject in in-toto statement"}
+	}
+	return &s, nil
+}
+
+// verifyAndExtractAttestation verifies that unverifiedAttestation has been signed by one of publicKeys,
+// and returns the in-toto statement it contains.
+// The caller is responsible for validating that the statement is about the expected image.
+func verifyAndExtractAttestation(publicKeys []crypto.PublicKey, unverifiedAttestation types.Attestation) (*inTotoStatement, error) {
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(unverifiedAttestation.Envelope, &envelope); err != nil {
+		return nil, InvalidSignatureError{msg: err.Error()}
+	}
+	if envelope.PayloadType != dsseInTotoPayloadType {
+		return nil, InvalidSignatureError{msg: fmt.Sprintf("Unsupported attestation payload type %s", envelope.PayloadType)}
+	}
+
+	pae := dssePreAuthEncoding(envelope.PayloadType, envelope.Payload)
+	verifyErr := errors.New("no signatures")
+	verified := false
+	for _, sig := range envelope.Signatures {
+		for _, key := range publicKeys {
+			if verifyErr = verifySigstoreSignatureWithKey(key, pae, sig.Sig); verifyErr == nil {
+				verified = true
+				break
+			}
+		}
+		if verified {
+			break
+		}
+	}
+	if !verified {
+		return nil, PolicyRequirementError(fmt.Sprintf("Attestation is not signed by a trusted key: %v", verifyErr))
+	}
+
+	return parseInTotoStatement(envelope.Payload)
+}
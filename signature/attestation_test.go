@@ -0,0 +1,121 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+
+	"github.com/containers/image/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// attestationTestStatement returns an in-toto statement of predicateType about subjectDigest (a sha256 hex value).
+func attestationTestStatement(predicateType, subjectDigest string) inTotoStatement {
+	return inTotoStatement{
+		Type:          inTotoStatementTypePrefix + "v0.1",
+		Subject:       []inTotoSubject{{Name: "docker.io/testing/manifest", Digest: map[string]string{"sha256": subjectDigest}}},
+		PredicateType: predicateType,
+		Predicate:     json.RawMessage(`{"builder":{"id":"https://example.com/builder"}}`),
+	}
+}
+
+// attestationTestEnvelope returns an attestation of statement, signed by key.
+func attestationTestEnvelope(t *testing.T, key *ecdsa.PrivateKey, statement inTotoStatement) types.Attestation {
+	payload, err := json.Marshal(statement)
+	require.NoError(t, err)
+	digest := sha256.Sum256(dssePreAuthEncoding(dsseInTotoPayloadType, payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	envelope, err := json.Marshal(dsseEnvelope{
+		PayloadType: dsseInTotoPayloadType,
+		Payload:     payload,
+		Signatures:  []dsseSignature{{Sig: sig}},
+	})
+	require.NoError(t, err)
+	return types.Attestation{Envelope: envelope}
+}
+
+func TestDSSEPreAuthEncoding(t *testing.T) {
+	// The example from the DSSE specification
+	assert.Equal(t, []byte("DSSEv1 29 http://example.com/HelloWorld 11 hello world"),
+		dssePreAuthEncoding("http://example.com/HelloWorld", []byte("hello world")))
+}
+
+func TestParseInTotoStatement(t *testing.T) {
+	// Success
+	valid := attestationTestStatement("https://slsa.dev/provenance/v0.2", "0123")
+	validJSON, err := json.Marshal(valid)
+	require.NoError(t, err)
+	s, err := parseInTotoStatement(validJSON)
+	require.NoError(t, err)
+	assert.Equal(t, &valid, s)
+
+	// Failures
+	for _, c := range []func(s *inTotoStatement){
+		func(s *inTotoStatement) { s.Type = "https://example.com/not-in-toto" },
+		func(s *inTotoStatement) { s.PredicateType = "" },
+		func(s *inTotoStatement) { s.Subject = nil },
+	} {
+		invalid := attestationTestStatement("https://slsa.dev/provenance/v0.2", "0123")
+		c(&invalid)
+		invalidJSON, err := json.Marshal(invalid)
+		require.NoError(t, err)
+		_, err = parseInTotoStatement(invalidJSON)
+		assert.IsType(t, InvalidSignatureError{}, err)
+	}
+	_, err = parseInTotoStatement([]byte("not JSON"))
+	assert.IsType(t, InvalidSignatureError{}, err)
+}
+
+func TestVerifyAndExtractAttestation(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	statement := attestationTestStatement("https://slsa.dev/provenance/v0.2", "0123")
+	att := attestationTestEnvelope(t, key, statement)
+
+	// Success, using any of the trusted keys
+	s, err := verifyAndExtractAttestation([]crypto.PublicKey{otherKey.Public(), key.Public()}, att)
+	require.NoError(t, err)
+	assert.Equal(t, &statement, s)
+
+	// Not signed by a trusted key
+	_, err = verifyAndExtractAttestation([]crypto.PublicKey{otherKey.Public()}, att)
+	assert.IsType(t, PolicyRequirementError(""), err)
+
+	// Modified payload
+	var envelope dsseEnvelope
+	err = json.Unmarshal(att.Envelope, &envelope)
+	require.NoError(t, err)
+	envelope.Payload = append(envelope.Payload, ' ')
+	modified, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	_, err = verifyAndExtractAttestation([]crypto.PublicKey{key.Public()}, types.Attestation{Envelope: modified})
+	assert.IsType(t, PolicyRequirementError(""), err)
+
+	// No signatures
+	envelope.Signatures = nil
+	unsigned, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	_, err = verifyAndExtractAttestation([]crypto.PublicKey{key.Public()}, types.Attestation{Envelope: unsigned})
+	assert.IsType(t, PolicyRequirementError(""), err)
+
+	// Unsupported payload type
+	err = json.Unmarshal(att.Envelope, &envelope)
+	require.NoError(t, err)
+	envelope.PayloadType = "application/json"
+	wrongType, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	_, err = verifyAndExtractAttestation([]crypto.PublicKey{key.Public()}, types.Attestation{Envelope: wrongType})
+	assert.IsType(t, InvalidSignatureError{}, err)
+
+	// Invalid envelope
+	_, err = verifyAndExtractAttestation([]crypto.PublicKey{key.Public()}, types.Attestation{Envelope: []byte("not JSON")})
+	assert.IsType(t, InvalidSignatureError{}, err)
+}
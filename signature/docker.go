@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/containers/image/manifest"
+	"github.com/opencontainers/go-digest"
 )
 
 // SignDockerManifest returns a signature for manifest as the specified dockerReference,
@@ -15,6 +16,12 @@ func SignDockerManifest(m []byte, dockerReference string, mech SigningMechanism,
 	if err != nil {
 		return nil, err
 	}
+	return signDockerManifestDigest(manifestDigest, dockerReference, mech, keyIdentity)
+}
+
+// signDockerManifestDigest returns a signature for a manifest with manifestDigest as the specified dockerReference,
+// using mech and keyIdentity.
+func signDockerManifestDigest(manifestDigest digest.Digest, dockerReference string, mech SigningMechanism, keyIdentity string) ([]byte, error) {
 	sig := privateSignature{
 		Signature{
 			DockerManifestDigest: manifestDigest,
@@ -24,6 +31,46 @@ func SignDockerManifest(m []byte, dockerReference string, mech SigningMechanism,
 	return sig.sign(mech, keyIdentity)
 }
 
+// ManifestListSignatures contains the signatures created by SignDockerManifestList.
+type ManifestListSignatures struct {
+	// List is the signature of the manifest list itself.
+	List []byte
+	// Instances contains a signature of each platform-specific manifest referenced by the list, indexed by the manifest digest.
+	Instances map[digest.Digest][]byte
+}
+
+// SignDockerManifestList returns signatures for the manifest list m with MIME type mimeType (guessed if empty),
+// and for each of the platform-specific manifests referenced by it, as the specified dockerReference, using mech and keyIdentity.
+// The per-instance signatures allow verifying an image pulled for a single platform, without needing the list.
+func SignDockerManifestList(m []byte, mimeType string, dockerReference string, mech SigningMechanism, keyIdentity string) (*ManifestListSignatures, error) {
+	list, err := manifest.ListFromBlob(m, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	listSig, err := SignDockerManifest(m, dockerReference, mech, keyIdentity)
+	if err != nil {
+		return nil, err
+	}
+	res := ManifestListSignatures{
+		List:      listSig,
+		Instances: map[digest.Digest][]byte{},
+	}
+	for _, instance := range list.Instances() {
+		if _, ok := res.Instances[instance.Digest]; ok {
+			continue // The same image may be listed for several platforms.
+		}
+		if err := instance.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid manifest digest %q in manifest list: %v", instance.Digest, err)
+		}
+		sig, err := signDockerManifestDigest(instance.Digest, dockerReference, mech, keyIdentity)
+		if err != nil {
+			return nil, fmt.Errorf("Error signing manifest %s: %v", instance.Digest, err)
+		}
+		res.Instances[instance.Digest] = sig
+	}
+	return &res, nil
+}
+
 // VerifyDockerManifestSignature checks that unverifiedSignature uses expectedKeyIdentity to sign unverifiedManifest as expectedDockerReference,
 // using mech.
 func VerifyDockerManifestSignature(unverifiedSignature, unverifiedManifest []byte,
@@ -42,7 +89,7 @@ func VerifyDockerManifestSignature(unverifiedSignature, unverifiedManifest []byt
 			}
 			return nil
 		},
-		validateSignedDockerManifestDigest: func(signedDockerManifestDigest string) error {
+		validateSignedDockerManifestDigest: func(signedDockerManifestDigest digest.Digest) error {
 			matches, err := manifest.MatchesDigest(unverifiedManifest, signedDockerManifestDigest)
 			if err != nil {
 				return err
@@ -1,9 +1,12 @@
 package signature
 
 import (
+	"fmt"
 	"io/ioutil"
 	"testing"
 
+	"github.com/containers/image/manifest"
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -38,6 +41,64 @@ func TestSignDockerManifest(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestSignDockerManifestList(t *testing.T) {
+	mech, err := newGPGSigningMechanismInDirectory(testGPGHomeDirectory)
+	require.NoError(t, err)
+	listBlob, err := ioutil.ReadFile("../manifest/fixtures/v2list.manifest.json")
+	require.NoError(t, err)
+	list, err := manifest.ListFromBlob(listBlob, manifest.DockerV2ListMediaType)
+	require.NoError(t, err)
+
+	// Successful signing
+	sigs, err := SignDockerManifestList(listBlob, "", TestImageSignatureReference, mech, TestKeyFingerprint)
+	require.NoError(t, err)
+	verified, err := VerifyDockerManifestSignature(sigs.List, listBlob, TestImageSignatureReference, mech, TestKeyFingerprint)
+	require.NoError(t, err)
+	assert.Equal(t, TestImageSignatureReference, verified.DockerReference)
+	instances := list.Instances()
+	require.Len(t, sigs.Instances, len(instances))
+	for _, instance := range instances {
+		sig, ok := sigs.Instances[instance.Digest]
+		require.True(t, ok, instance.Digest.String())
+		verified, err := verifyAndExtractSignature(mech, sig, signatureAcceptanceRules{
+			validateKeyIdentity: func(keyIdentity string) error {
+				if keyIdentity != TestKeyFingerprint {
+					return fmt.Errorf("Unexpected keyIdentity")
+				}
+				return nil
+			},
+			validateSignedDockerReference: func(signedDockerReference string) error {
+				if signedDockerReference != TestImageSignatureReference {
+					return fmt.Errorf("Unexpected signedDockerReference")
+				}
+				return nil
+			},
+			validateSignedDockerManifestDigest: func(signedDockerManifestDigest digest.Digest) error {
+				if signedDockerManifestDigest != instance.Digest {
+					return fmt.Errorf("Unexpected signedDockerManifestDigest")
+				}
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, instance.Digest, verified.DockerManifestDigest)
+	}
+
+	// Not a manifest list
+	manifestBlob, err := ioutil.ReadFile("fixtures/image.manifest.json")
+	require.NoError(t, err)
+	_, err = SignDockerManifestList(manifestBlob, "", TestImageSignatureReference, mech, TestKeyFingerprint)
+	assert.Error(t, err)
+
+	// Error creating blob to sign
+	_, err = SignDockerManifestList(listBlob, "", "", mech, TestKeyFingerprint)
+	assert.Error(t, err)
+
+	// Error signing
+	_, err = SignDockerManifestList(listBlob, "", TestImageSignatureReference, mech, "this fingerprint doesn't exist")
+	assert.Error(t, err)
+}
+
 func TestVerifyDockerManifestSignature(t *testing.T) {
 	mech, err := newGPGSigningMechanismInDirectory(testGPGHomeDirectory)
 	require.NoError(t, err)
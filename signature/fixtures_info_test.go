@@ -1,10 +1,18 @@
 package signature
 
+import "github.com/opencontainers/go-digest"
+
 const (
 	// TestImageManifestDigest is the Docker manifest digest of "image.manifest.json"
-	TestImageManifestDigest = "sha256:20bf21ed457b390829cdbeec8795a7bea1626991fda603e0d01b4e7f60427e55"
+	TestImageManifestDigest = digest.Digest("sha256:20bf21ed457b390829cdbeec8795a7bea1626991fda603e0d01b4e7f60427e55")
 	// TestImageSignatureReference is the Docker image reference signed in "image.signature"
 	TestImageSignatureReference = "testing/manifest"
+	// TestImageSignatureTimestamp is the Unix time recorded in "image.signature"
+	TestImageSignatureTimestamp = 1464398954
 	// TestKeyFingerprint is the fingerprint of the private key in this directory.
 	TestKeyFingerprint = "1D8230F6CDB6A06716E414C1DB72F2188BB46CC8"
+	// TestPassphraseKeyFingerprint is the fingerprint of the passphrase-protected private key in "passphrase".
+	TestPassphraseKeyFingerprint = "9012FFB47847E3BDA127CA2334FA23B8BA4FC806"
+	// TestPassphraseKeyPassphrase is the passphrase of the private key in "passphrase".
+	TestPassphraseKeyPassphrase = "test passphrase"
 )
@@ -0,0 +1,141 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// fulcioOIDCIssuerV1OID is the Fulcio certificate extension containing the OIDC issuer, as a raw string.
+	fulcioOIDCIssuerV1OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	// fulcioOIDCIssuerV2OID is the Fulcio certificate extension containing the OIDC issuer, as a DER-encoded UTF8String.
+	fulcioOIDCIssuerV2OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// fulcioTrustRoot contains the trusted Fulcio CA certificates, and the identity required in accepted certificates.
+type fulcioTrustRoot struct {
+	caCertificates *x509.CertPool
+	oidcIssuer     string
+	subjectEmail   string
+}
+
+// parsePEMCertificates parses all PEM-encoded certificates in data.
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// newFulcioTrustRoot returns a fulcioTrustRoot trusting the PEM-encoded CA certificates in caData.
+func newFulcioTrustRoot(caData []byte, oidcIssuer, subjectEmail string) (*fulcioTrustRoot, error) {
+	certs, err := parsePEMCertificates(caData)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No PEM-encoded Fulcio CA certificates found")
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return &fulcioTrustRoot{
+		caCertificates: pool,
+		oidcIssuer:     oidcIssuer,
+		subjectEmail:   subjectEmail,
+	}, nil
+}
+
+// fulcioIssuerInCertificate returns the OIDC issuer recorded in a Fulcio certificate.
+func fulcioIssuerInCertificate(cert *x509.Certificate) (string, error) {
+	for _, e := range cert.Extensions {
+		switch {
+		case e.Id.Equal(fulcioOIDCIssuerV2OID):
+			var issuer string
+			rest, err := asn1.UnmarshalWithParams(e.Value, &issuer, "utf8")
+			if err != nil {
+				return "", fmt.Errorf("Error parsing OIDC issuer in Fulcio certificate: %v", err)
+			}
+			if len(rest) != 0 {
+				return "", errors.New("Unexpected trailing data after OIDC issuer in Fulcio certificate")
+			}
+			return issuer, nil
+		case e.Id.Equal(fulcioOIDCIssuerV1OID):
+			return string(e.Value), nil
+		}
+	}
+	return "", errors.New("Fulcio certificate does not contain an OIDC issuer")
+}
+
+// verifyFulcioCertificate verifies that the PEM-encoded certificate in certPEM, with PEM-encoded intermediate certificates in chainPEM,
+// was issued by f for the expected identity, and was valid at signingTime.  It returns the public key of the certificate.
+func (f *fulcioTrustRoot) verifyFulcioCertificate(signingTime time.Time, certPEM, chainPEM []byte) (crypto.PublicKey, error) {
+	certs, err := parsePEMCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) != 1 {
+		return nil, fmt.Errorf("Expected exactly one signing certificate, found %d", len(certs))
+	}
+	cert := certs[0]
+
+	intermediates := x509.NewCertPool()
+	chain, err := parsePEMCertificates(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+
+	// Fulcio certificates are short-lived, so they are verified at the time the signature was created (as recorded by Rekor),
+	// not at the current time.
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         f.caCertificates,
+		Intermediates: intermediates,
+		CurrentTime:   signingTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, PolicyRequirementError(fmt.Sprintf("Fulcio certificate is not trusted: %v", err))
+	}
+
+	issuer, err := fulcioIssuerInCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	if issuer != f.oidcIssuer {
+		return nil, PolicyRequirementError(fmt.Sprintf("Fulcio certificate was issued for OIDC issuer %s, expected %s", issuer, f.oidcIssuer))
+	}
+
+	emailMatches := false
+	for _, email := range cert.EmailAddresses {
+		if email == f.subjectEmail {
+			emailMatches = true
+			break
+		}
+	}
+	if !emailMatches {
+		return nil, PolicyRequirementError(fmt.Sprintf("Fulcio certificate was issued for %v, expected %s", cert.EmailAddresses, f.subjectEmail))
+	}
+
+	return cert.PublicKey, nil
+}
@@ -0,0 +1,130 @@
+package signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fulcioTestCA is a CA used for testing Fulcio certificate verification.
+type fulcioTestCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newFulcioTestCA returns a new self-signed fulcioTestCA.
+func newFulcioTestCA(t *testing.T) *fulcioTestCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Fulcio CA"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &fulcioTestCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM-encoded short-lived code signing certificate for key, valid from notBefore for 10 minutes,
+// and with the specified email and OIDC issuer (stored in issuerOID).
+func (ca *fulcioTestCA) issue(t *testing.T, key *ecdsa.PrivateKey, notBefore time.Time, email string, issuerOID asn1.ObjectIdentifier, issuer string) []byte {
+	var issuerValue []byte
+	if issuerOID.Equal(fulcioOIDCIssuerV2OID) {
+		v, err := asn1.MarshalWithParams(issuer, "utf8")
+		require.NoError(t, err)
+		issuerValue = v
+	} else {
+		issuerValue = []byte(issuer)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{email},
+		ExtraExtensions: []pkix.Extension{{Id: issuerOID, Value: issuerValue}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestNewFulcioTrustRoot(t *testing.T) {
+	ca := newFulcioTestCA(t)
+
+	tr, err := newFulcioTrustRoot(ca.pem, "https://example.com", "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", tr.oidcIssuer)
+	assert.Equal(t, "user@example.com", tr.subjectEmail)
+
+	for _, data := range [][]byte{
+		nil,
+		[]byte("this is not PEM"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("this is not a certificate")}),
+	} {
+		_, err := newFulcioTrustRoot(data, "https://example.com", "user@example.com")
+		assert.Error(t, err, string(data))
+	}
+}
+
+func TestVerifyFulcioCertificate(t *testing.T) {
+	ca := newFulcioTestCA(t)
+	otherCA := newFulcioTestCA(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signingTime := time.Now()
+	notBefore := signingTime.Add(-time.Minute)
+
+	tr, err := newFulcioTrustRoot(ca.pem, "https://example.com", "user@example.com")
+	require.NoError(t, err)
+
+	// Success, with both kinds of OIDC issuer extensions
+	for _, oid := range []asn1.ObjectIdentifier{fulcioOIDCIssuerV1OID, fulcioOIDCIssuerV2OID} {
+		cert := ca.issue(t, key, notBefore, "user@example.com", oid, "https://example.com")
+		pk, err := tr.verifyFulcioCertificate(signingTime, cert, nil)
+		require.NoError(t, err, oid.String())
+		assert.Equal(t, key.Public(), pk)
+	}
+
+	validCert := ca.issue(t, key, notBefore, "user@example.com", fulcioOIDCIssuerV2OID, "https://example.com")
+	for _, c := range []struct {
+		name        string
+		signingTime time.Time
+		cert        []byte
+	}{
+		{"no certificate", signingTime, nil},
+		{"two certificates", signingTime, append(append([]byte{}, validCert...), validCert...)},
+		{"signed before validity", notBefore.Add(-time.Minute), validCert},
+		{"signed after validity", notBefore.Add(time.Hour), validCert},
+		{"untrusted CA", signingTime, otherCA.issue(t, key, notBefore, "user@example.com", fulcioOIDCIssuerV2OID, "https://example.com")},
+		{"different issuer", signingTime, ca.issue(t, key, notBefore, "user@example.com", fulcioOIDCIssuerV2OID, "https://example.org")},
+		{"different email", signingTime, ca.issue(t, key, notBefore, "other@example.com", fulcioOIDCIssuerV2OID, "https://example.com")},
+		{"no issuer", signingTime, ca.issue(t, key, notBefore, "user@example.com", asn1.ObjectIdentifier{1, 2, 3}, "https://example.com")},
+	} {
+		_, err := tr.verifyFulcioCertificate(c.signingTime, c.cert, nil)
+		assert.Error(t, err, c.name)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 )
 
 // jsonFormatError is returned when JSON does not match expected format.
@@ -55,6 +56,19 @@ func stringField(m map[string]interface{}, fieldName string) (string, error) {
 	return v, nil
 }
 
+// int64Field returns a member fieldName of m, if it is a JSON number representing an integer, or an error.
+func int64Field(m map[string]interface{}, fieldName string) (int64, error) {
+	untyped, ok := m[fieldName]
+	if !ok {
+		return 0, jsonFormatError(fmt.Sprintf("Field %s missing", fieldName))
+	}
+	f, ok := untyped.(float64)
+	if !ok || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, jsonFormatError(fmt.Sprintf("Field %s is not an integer", fieldName))
+	}
+	return int64(f), nil
+}
+
 // paranoidUnmarshalJSONObject unmarshals data as a JSON object, but failing on the slightest unexpected aspect
 // (including duplicated keys, unrecognized keys, and non-matching types). Uses fieldResolver to
 // determine the destination for a field value, which should return a pointer to the destination if valid, or nil if the key is rejected.
@@ -2,6 +2,7 @@ package signature
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -76,6 +77,23 @@ func TestStringField(t *testing.T) {
 	assert.Equal(t, "x", s)
 }
 
+func TestInt64Field(t *testing.T) {
+	// Field not found
+	_, err := int64Field(mSI{"a": 1.0}, "b")
+	assert.Error(t, err)
+
+	// Field has a wrong type
+	for _, v := range []interface{}{"1", 1.5, nil, 1e20} {
+		_, err = int64Field(mSI{"a": v}, "a")
+		assert.Error(t, err, fmt.Sprintf("%#v", v))
+	}
+
+	// Success
+	i, err := int64Field(mSI{"a": 1464398954.0, "b": nil}, "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1464398954), i)
+}
+
 // implementsUnmarshalJSON is a minimalistic type used to detect that
 // paranoidUnmarshalJSONObject uses the json.Unmarshaler interface of resolved
 // pointers.
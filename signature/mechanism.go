@@ -3,13 +3,15 @@
 package signature
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-
-	"github.com/mtrmac/gpgme"
+	"sync"
 )
 
 // SigningMechanism abstracts a way to sign binary blobs and verify their signatures.
+// Two implementations exist: by default, GPGME (using the system's GPG installation and keyrings) is used;
+// building with the containers_image_openpgp tag selects a pure-Go OpenPGP implementation instead,
+// which does not require gpg to be installed.
 // FIXME: Eventually expand on keyIdentity (namespace them between mechanisms to
 // eliminate ambiguities, support CA signatures and perhaps other key properties)
 type SigningMechanism interface {
@@ -23,9 +25,28 @@ type SigningMechanism interface {
 	Verify(unverifiedSignature []byte) (contents []byte, keyIdentity string, err error)
 }
 
-// A GPG/OpenPGP signing mechanism.
-type gpgSigningMechanism struct {
-	ctx *gpgme.Context
+// PassphraseCallback returns the passphrase of the protected private key identified by keyIdentity.
+// prevWasBad is true if the passphrase returned by the previous call, for the same signing operation, was rejected.
+type PassphraseCallback func(keyIdentity string, prevWasBad bool) (string, error)
+
+// FixedPassphrase returns a PassphraseCallback which always returns passphrase, and fails if it was rejected.
+func FixedPassphrase(passphrase string) PassphraseCallback {
+	return func(keyIdentity string, prevWasBad bool) (string, error) {
+		if prevWasBad {
+			return "", fmt.Errorf("Invalid passphrase for key %s", keyIdentity)
+		}
+		return passphrase, nil
+	}
+}
+
+// SigningMechanismWithPassphrase is a SigningMechanism which can sign using private keys protected by a passphrase,
+// without interacting with the user (e.g. using a GPG pinentry program).
+// The mechanisms returned by NewGPGSigningMechanism implement this interface.
+type SigningMechanismWithPassphrase interface {
+	SigningMechanism
+	// SignWithPassphrase creates a (non-detached) signature of input using keyIdentity,
+	// obtaining the passphrase of the private key from passphraseCallback if necessary.
+	SignWithPassphrase(input []byte, keyIdentity string, passphraseCallback PassphraseCallback) ([]byte, error)
 }
 
 // NewGPGSigningMechanism returns a new GPG/OpenPGP signing mechanism.
@@ -33,89 +54,55 @@ func NewGPGSigningMechanism() (SigningMechanism, error) {
 	return newGPGSigningMechanismInDirectory("")
 }
 
-// newGPGSigningMechanismInDirectory returns a new GPG/OpenPGP signing mechanism, using optionalDir if not empty.
-func newGPGSigningMechanismInDirectory(optionalDir string) (SigningMechanism, error) {
-	ctx, err := gpgme.New()
-	if err != nil {
-		return nil, err
-	}
-	if err = ctx.SetProtocol(gpgme.ProtocolOpenPGP); err != nil {
-		return nil, err
+// NewSigningMechanismWithPassphrase returns a SigningMechanism which uses mech, and obtains passphrases
+// of protected private keys from passphraseCallback when signing.
+// This allows using the passphrase with any function accepting a SigningMechanism, e.g. SignDockerManifest.
+func NewSigningMechanismWithPassphrase(mech SigningMechanism, passphraseCallback PassphraseCallback) (SigningMechanism, error) {
+	if passphraseCallback == nil {
+		return nil, errors.New("No passphrase callback specified")
 	}
-	if optionalDir != "" {
-		err := ctx.SetEngineInfo(gpgme.ProtocolOpenPGP, "", optionalDir)
-		if err != nil {
-			return nil, err
-		}
+	m, ok := mech.(SigningMechanismWithPassphrase)
+	if !ok {
+		return nil, fmt.Errorf("Signing mechanism %T does not support passphrases", mech)
 	}
-	ctx.SetArmor(false)
-	ctx.SetTextMode(false)
-	return gpgSigningMechanism{ctx: ctx}, nil
+	return passphraseSigningMechanism{SigningMechanismWithPassphrase: m, passphraseCallback: passphraseCallback}, nil
+}
+
+// passphraseSigningMechanism is a SigningMechanism which signs using passphrases obtained from passphraseCallback.
+type passphraseSigningMechanism struct {
+	SigningMechanismWithPassphrase
+	passphraseCallback PassphraseCallback
+}
+
+// Sign implements SigningMechanism.Sign
+func (m passphraseSigningMechanism) Sign(input []byte, keyIdentity string) ([]byte, error) {
+	return m.SignWithPassphrase(input, keyIdentity, m.passphraseCallback)
+}
+
+// serializedSigningMechanism is a SigningMechanism which serializes all calls to mech, so that it can be used concurrently
+// even if mech (e.g. a GPGME context) can not.
+type serializedSigningMechanism struct {
+	mutex sync.Mutex
+	mech  SigningMechanism
 }
 
 // ImportKeysFromBytes implements SigningMechanism.ImportKeysFromBytes
-func (m gpgSigningMechanism) ImportKeysFromBytes(blob []byte) ([]string, error) {
-	inputData, err := gpgme.NewDataBytes(blob)
-	if err != nil {
-		return nil, err
-	}
-	res, err := m.ctx.Import(inputData)
-	if err != nil {
-		return nil, err
-	}
-	keyIdentities := []string{}
-	for _, i := range res.Imports {
-		if i.Result == nil {
-			keyIdentities = append(keyIdentities, i.Fingerprint)
-		}
-	}
-	return keyIdentities, nil
+func (m *serializedSigningMechanism) ImportKeysFromBytes(blob []byte) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.mech.ImportKeysFromBytes(blob)
 }
 
 // Sign implements SigningMechanism.Sign
-func (m gpgSigningMechanism) Sign(input []byte, keyIdentity string) ([]byte, error) {
-	key, err := m.ctx.GetKey(keyIdentity, true)
-	if err != nil {
-		return nil, err
-	}
-	inputData, err := gpgme.NewDataBytes(input)
-	if err != nil {
-		return nil, err
-	}
-	var sigBuffer bytes.Buffer
-	sigData, err := gpgme.NewDataWriter(&sigBuffer)
-	if err != nil {
-		return nil, err
-	}
-	if err = m.ctx.Sign([]*gpgme.Key{key}, inputData, sigData, gpgme.SigModeNormal); err != nil {
-		return nil, err
-	}
-	return sigBuffer.Bytes(), nil
+func (m *serializedSigningMechanism) Sign(input []byte, keyIdentity string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.mech.Sign(input, keyIdentity)
 }
 
 // Verify implements SigningMechanism.Verify
-func (m gpgSigningMechanism) Verify(unverifiedSignature []byte) (contents []byte, keyIdentity string, err error) {
-	signedBuffer := bytes.Buffer{}
-	signedData, err := gpgme.NewDataWriter(&signedBuffer)
-	if err != nil {
-		return nil, "", err
-	}
-	unverifiedSignatureData, err := gpgme.NewDataBytes(unverifiedSignature)
-	if err != nil {
-		return nil, "", err
-	}
-	_, sigs, err := m.ctx.Verify(unverifiedSignatureData, nil, signedData)
-	if err != nil {
-		return nil, "", err
-	}
-	if len(sigs) != 1 {
-		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Unexpected GPG signature count %d", len(sigs))}
-	}
-	sig := sigs[0]
-	// This is sig.Summary == gpgme.SigSumValid except for key trust, which we handle ourselves
-	if sig.Status != nil || sig.Validity == gpgme.ValidityNever || sig.ValidityReason != nil || sig.WrongKeyUsage {
-		// FIXME: Better error reporting eventually
-		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Invalid GPG signature: %#v", sig)}
-	}
-	return signedBuffer.Bytes(), sig.Fingerprint, nil
+func (m *serializedSigningMechanism) Verify(unverifiedSignature []byte) (contents []byte, keyIdentity string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.mech.Verify(unverifiedSignature)
 }
@@ -0,0 +1,236 @@
+//go:build containers_image_openpgp
+// +build containers_image_openpgp
+
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/homedir"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// A GPG/OpenPGP signing mechanism, implemented in pure Go.
+// Keys are read from the pubring.gpg and secring.gpg files of the GPG home directory;
+// the keybox format used by GPG 2.1 and later is not supported.
+type openpgpSigningMechanism struct {
+	keyring       openpgp.EntityList // Public keys, used for verification
+	secretKeyring openpgp.EntityList // Private keys, used for signing
+}
+
+// newGPGSigningMechanismInDirectory returns a new GPG/OpenPGP signing mechanism, using optionalDir if not empty.
+func newGPGSigningMechanismInDirectory(optionalDir string) (SigningMechanism, error) {
+	m := &openpgpSigningMechanism{}
+	gpgHome := optionalDir
+	if gpgHome == "" {
+		gpgHome = os.Getenv("GNUPGHOME")
+		if gpgHome == "" {
+			gpgHome = filepath.Join(homedir.Get(), ".gnupg")
+		}
+	}
+	var err error
+	if m.keyring, err = readKeyRingFile(filepath.Join(gpgHome, "pubring.gpg")); err != nil {
+		return nil, err
+	}
+	if m.secretKeyring, err = readKeyRingFile(filepath.Join(gpgHome, "secring.gpg")); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readKeyRingFile returns the keys in the keyring at path; a missing file is treated as an empty keyring.
+func readKeyRingFile(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return openpgp.EntityList{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	keyring, err := openpgp.ReadKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", path, err)
+	}
+	return keyring, nil
+}
+
+// ImportKeysFromBytes implements SigningMechanism.ImportKeysFromBytes
+func (m *openpgpSigningMechanism) ImportKeysFromBytes(blob []byte) ([]string, error) {
+	keyring, err := openpgp.ReadKeyRing(bytes.NewReader(blob))
+	if err != nil {
+		// Like GPG, accept invalid input, just return no keys.
+		keyring = readArmoredKeyRings(blob)
+	}
+	keyIdentities := []string{}
+	for _, entity := range keyring {
+		if entity.PrimaryKey == nil {
+			continue
+		}
+		keyIdentities = append(keyIdentities, fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint))
+		m.keyring = append(m.keyring, entity)
+	}
+	return keyIdentities, nil
+}
+
+// readArmoredKeyRings returns the keys in all ASCII-armored keyrings in blob.
+func readArmoredKeyRings(blob []byte) openpgp.EntityList {
+	res := openpgp.EntityList{}
+	r := bufio.NewReader(bytes.NewReader(blob)) // armor.Decode reuses a bufio.Reader, so that consecutive calls continue where the previous one stopped.
+	for {
+		block, err := armor.Decode(r)
+		if err != nil || block.Type != openpgp.PublicKeyType {
+			return res
+		}
+		keyring, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return res
+		}
+		res = append(res, keyring...)
+	}
+}
+
+// maxPassphraseAttempts is the number of times SignWithPassphrase asks for a passphrase before failing, like gpg-agent does.
+const maxPassphraseAttempts = 3
+
+// Sign implements SigningMechanism.Sign
+func (m *openpgpSigningMechanism) Sign(input []byte, keyIdentity string) ([]byte, error) {
+	return m.SignWithPassphrase(input, keyIdentity, nil)
+}
+
+// SignWithPassphrase implements SigningMechanismWithPassphrase.SignWithPassphrase
+// passphraseCallback may be nil, in which case protected private keys can not be used.
+func (m *openpgpSigningMechanism) SignWithPassphrase(input []byte, keyIdentity string, passphraseCallback PassphraseCallback) ([]byte, error) {
+	entity := findEntity(m.secretKeyring, keyIdentity)
+	if entity == nil || entity.PrivateKey == nil {
+		return nil, fmt.Errorf("Private key %s not found", keyIdentity)
+	}
+	if entity.PrivateKey.Encrypted {
+		if passphraseCallback == nil {
+			return nil, fmt.Errorf("Private key %s is protected by a passphrase, which was not provided", keyIdentity)
+		}
+		decrypted, err := decryptEntity(entity, keyIdentity, passphraseCallback)
+		if err != nil {
+			return nil, err
+		}
+		entity = decrypted
+	}
+	var sigBuffer bytes.Buffer
+	w, err := openpgp.Sign(&sigBuffer, entity, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(input); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return sigBuffer.Bytes(), nil
+}
+
+// decryptEntity returns a copy of entity with all private keys decrypted using a passphrase from passphraseCallback.
+// entity itself is not modified, so that the decrypted keys are not kept in the keyring.
+func decryptEntity(entity *openpgp.Entity, keyIdentity string, passphraseCallback PassphraseCallback) (*openpgp.Entity, error) {
+	prevWasBad := false
+	for attempt := 0; attempt < maxPassphraseAttempts; attempt++ {
+		passphrase, err := passphraseCallback(keyIdentity, prevWasBad)
+		if err != nil {
+			return nil, err
+		}
+		res := *entity
+		res.PrivateKey, err = decryptPrivateKey(entity.PrivateKey, passphrase)
+		if err != nil {
+			prevWasBad = true
+			continue
+		}
+		res.Subkeys = make([]openpgp.Subkey, len(entity.Subkeys))
+		for i, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil {
+				if subkey.PrivateKey, err = decryptPrivateKey(subkey.PrivateKey, passphrase); err != nil {
+					return nil, fmt.Errorf("Error decrypting a subkey of %s: %v", keyIdentity, err)
+				}
+			}
+			res.Subkeys[i] = subkey
+		}
+		return &res, nil
+	}
+	return nil, fmt.Errorf("Invalid passphrase for private key %s", keyIdentity)
+}
+
+// decryptPrivateKey returns a decrypted copy of key, using passphrase if it is encrypted.
+func decryptPrivateKey(key *packet.PrivateKey, passphrase string) (*packet.PrivateKey, error) {
+	if !key.Encrypted {
+		return key, nil
+	}
+	res := *key
+	if err := res.Decrypt([]byte(passphrase)); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// findEntity returns the entity in keyring identified by keyIdentity, a fingerprint or a (long or short) key ID, or nil if not found.
+func findEntity(keyring openpgp.EntityList, keyIdentity string) *openpgp.Entity {
+	keyIdentity = strings.ToUpper(keyIdentity)
+	switch len(keyIdentity) {
+	case 8, 16, 40: // Short key ID, long key ID, fingerprint
+	default:
+		return nil
+	}
+	for _, entity := range keyring {
+		if entity.PrimaryKey != nil && strings.HasSuffix(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), keyIdentity) {
+			return entity
+		}
+	}
+	return nil
+}
+
+// Verify implements SigningMechanism.Verify
+func (m *openpgpSigningMechanism) Verify(unverifiedSignature []byte) (contents []byte, keyIdentity string, err error) {
+	md, err := openpgp.ReadMessage(bytes.NewReader(unverifiedSignature), m.keyring, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if !md.IsSigned {
+		return nil, "", InvalidSignatureError{msg: "not signed"}
+	}
+	content, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		// Coverage: md.UnverifiedBody.Read only fails if the body is encrypted
+		// (and possibly also signed, but it _must_ be encrypted) and the signing
+		// “modification detection code” detects a mismatch. But in that case,
+		// we would expect the signature verification to fail as well, and that is handled
+		// below before we return.
+		return nil, "", err
+	}
+	if md.SignatureError != nil {
+		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Invalid GPG signature: %v", md.SignatureError)}
+	}
+	if md.SignedBy == nil {
+		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Invalid GPG signature: unknown key %X", md.SignedByKeyId)}
+	}
+	if md.Signature == nil {
+		return nil, "", InvalidSignatureError{msg: "Unsupported GPG signature version"}
+	}
+	now := time.Now()
+	if md.Signature.SigLifetimeSecs != nil && *md.Signature.SigLifetimeSecs != 0 {
+		expiry := md.Signature.CreationTime.Add(time.Duration(*md.Signature.SigLifetimeSecs) * time.Second)
+		if now.After(expiry) {
+			return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Signature expired on %s", expiry)}
+		}
+	}
+	if md.SignedBy.SelfSignature != nil && md.SignedBy.SelfSignature.KeyExpired(now) {
+		return nil, "", InvalidSignatureError{msg: fmt.Sprintf("Key %X has expired", md.SignedBy.PublicKey.Fingerprint)}
+	}
+	return content, fmt.Sprintf("%X", md.SignedBy.PublicKey.Fingerprint), nil
+}
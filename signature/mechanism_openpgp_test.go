@@ -0,0 +1,60 @@
+//go:build containers_image_openpgp
+// +build containers_image_openpgp
+
+package signature
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindEntity(t *testing.T) {
+	mech, err := newGPGSigningMechanismInDirectory(testGPGHomeDirectory)
+	require.NoError(t, err)
+	secretKeyring := mech.(*openpgpSigningMechanism).secretKeyring
+
+	for _, id := range []string{
+		TestKeyFingerprint,
+		TestKeyFingerprint[len(TestKeyFingerprint)-16:],
+		TestKeyFingerprint[len(TestKeyFingerprint)-8:],
+		"1d8230f6cdb6a06716e414c1db72f2188bb46cc8",
+	} {
+		entity := findEntity(secretKeyring, id)
+		require.NotNil(t, entity, id)
+		assert.NotNil(t, entity.PrivateKey, id)
+	}
+
+	for _, id := range []string{"", "8BB46CC", "0000000000000000", "this fingerprint doesn't exist"} {
+		assert.Nil(t, findEntity(secretKeyring, id), id)
+	}
+}
+
+func TestOpenpgpSigningMechanismProtectedKey(t *testing.T) {
+	mech, err := newGPGSigningMechanismInDirectory("./fixtures/passphrase")
+	require.NoError(t, err)
+
+	// Signing with a protected key requires a passphrase.
+	_, err = mech.Sign([]byte("content"), TestPassphraseKeyFingerprint)
+	assert.Error(t, err)
+
+	// The keyring is not modified by a successful signing operation.
+	_, err = mech.(SigningMechanismWithPassphrase).SignWithPassphrase([]byte("content"), TestPassphraseKeyFingerprint, FixedPassphrase(TestPassphraseKeyPassphrase))
+	require.NoError(t, err)
+	_, err = mech.Sign([]byte("content"), TestPassphraseKeyFingerprint)
+	assert.Error(t, err)
+
+	// The callback is consulted again after an invalid passphrase.
+	calls := []bool{}
+	_, err = mech.(SigningMechanismWithPassphrase).SignWithPassphrase([]byte("content"), TestPassphraseKeyFingerprint, func(keyIdentity string, prevWasBad bool) (string, error) {
+		assert.Equal(t, TestPassphraseKeyFingerprint, keyIdentity)
+		calls = append(calls, prevWasBad)
+		if len(calls) == 1 {
+			return "this is not the passphrase", nil
+		}
+		return TestPassphraseKeyPassphrase, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, calls)
+}
@@ -2,6 +2,7 @@ package signature
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -86,6 +87,74 @@ func TestGPGSigningMechanismSign(t *testing.T) {
 	// The various GPG/GPGME failures cases are not obviously easy to reach.
 }
 
+func TestFixedPassphrase(t *testing.T) {
+	cb := FixedPassphrase("secret")
+	p, err := cb(TestKeyFingerprint, false)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", p)
+	_, err = cb(TestKeyFingerprint, true)
+	assert.Error(t, err)
+}
+
+func TestGPGSigningMechanismSignWithPassphrase(t *testing.T) {
+	mech, err := newGPGSigningMechanismInDirectory("./fixtures/passphrase")
+	require.NoError(t, err)
+	pmech, ok := mech.(SigningMechanismWithPassphrase)
+	require.True(t, ok)
+
+	// Successful signing
+	content := []byte("content")
+	signature, err := pmech.SignWithPassphrase(content, TestPassphraseKeyFingerprint, FixedPassphrase(TestPassphraseKeyPassphrase))
+	require.NoError(t, err)
+	signedContent, signingFingerprint, err := mech.Verify(signature)
+	require.NoError(t, err)
+	assert.EqualValues(t, content, signedContent)
+	assert.Equal(t, TestPassphraseKeyFingerprint, signingFingerprint)
+
+	// Invalid passphrase
+	_, err = pmech.SignWithPassphrase(content, TestPassphraseKeyFingerprint, FixedPassphrase("this is not the passphrase"))
+	assert.Error(t, err)
+
+	// Callback failure
+	_, err = pmech.SignWithPassphrase(content, TestPassphraseKeyFingerprint, func(keyIdentity string, prevWasBad bool) (string, error) {
+		return "", errors.New("callback failed")
+	})
+	assert.Error(t, err)
+
+	// Unknown key
+	_, err = pmech.SignWithPassphrase(content, "this fingerprint doesn't exist", FixedPassphrase(TestPassphraseKeyPassphrase))
+	assert.Error(t, err)
+}
+
+func TestNewSigningMechanismWithPassphrase(t *testing.T) {
+	mech, err := newGPGSigningMechanismInDirectory("./fixtures/passphrase")
+	require.NoError(t, err)
+
+	// Success
+	pmech, err := NewSigningMechanismWithPassphrase(mech, FixedPassphrase(TestPassphraseKeyPassphrase))
+	require.NoError(t, err)
+	content := []byte("content")
+	signature, err := pmech.Sign(content, TestPassphraseKeyFingerprint)
+	require.NoError(t, err)
+	signedContent, signingFingerprint, err := pmech.Verify(signature)
+	require.NoError(t, err)
+	assert.EqualValues(t, content, signedContent)
+	assert.Equal(t, TestPassphraseKeyFingerprint, signingFingerprint)
+
+	// No callback
+	_, err = NewSigningMechanismWithPassphrase(mech, nil)
+	assert.Error(t, err)
+
+	// A mechanism which does not support passphrases
+	_, err = NewSigningMechanismWithPassphrase(noPassphraseSigningMechanism{mech}, FixedPassphrase(TestPassphraseKeyPassphrase))
+	assert.Error(t, err)
+}
+
+// noPassphraseSigningMechanism hides the SignWithPassphrase method of the wrapped mechanism.
+type noPassphraseSigningMechanism struct {
+	SigningMechanism
+}
+
 func assertSigningError(t *testing.T, content []byte, fingerprint string, err error) {
 	assert.Error(t, err)
 	assert.Nil(t, content)
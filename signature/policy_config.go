@@ -19,9 +19,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/transports"
+	// Policies may refer to any of the built-in transports, make sure they are registered.
+	_ "github.com/containers/image/transports/alltransports"
 	"github.com/containers/image/types"
 )
 
@@ -124,8 +129,8 @@ func (m *policyTransportsMap) UnmarshalJSON(data []byte) error {
 	// So, use a temporary map of pointers-to-slices and convert.
 	tmpMap := map[string]*PolicyTransportScopes{}
 	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
-		transport, ok := transports.KnownTransports[key]
-		if !ok {
+		transport := transports.Get(key)
+		if transport == nil {
 			return nil
 		}
 		// paranoidUnmarshalJSONObject detects key duplication for us, check just to be safe.
@@ -233,6 +238,10 @@ func newPolicyRequirementFromJSON(data []byte) (PolicyRequirement, error) {
 		res = &prSignedBy{}
 	case prTypeSignedBaseLayer:
 		res = &prSignedBaseLayer{}
+	case prTypeSigstoreSigned:
+		res = &prSigstoreSigned{}
+	case prTypeSigstoreAttested:
+		res = &prSigstoreAttested{}
 	default:
 		return nil, InvalidPolicyFormatError(fmt.Sprintf("Unknown policy requirement type \"%s\"", typeField.Type))
 	}
@@ -313,12 +322,36 @@ func (pr *prReject) UnmarshalJSON(data []byte) error {
 }
 
 // newPRSignedBy returns a new prSignedBy if parameters are valid.
-func newPRSignedBy(keyType sbKeyType, keyPath string, keyData []byte, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
+func newPRSignedBy(keyType sbKeyType, keyPath string, keyPaths []string, keyData []byte, keyDatas [][]byte, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
 	if !keyType.IsValid() {
 		return nil, InvalidPolicyFormatError(fmt.Sprintf("invalid keyType \"%s\"", keyType))
 	}
-	if len(keyPath) > 0 && len(keyData) > 0 {
-		return nil, InvalidPolicyFormatError("keyType and keyData cannot be used simultaneously")
+	keySources := 0
+	if len(keyPath) > 0 {
+		keySources++
+	}
+	if keyPaths != nil {
+		keySources++
+	}
+	if len(keyData) > 0 {
+		keySources++
+	}
+	if keyDatas != nil {
+		keySources++
+	}
+	if keySources > 1 {
+		return nil, InvalidPolicyFormatError("at most one of keyPath, keyPaths, keyData and keyDatas can be used")
+	}
+	if keyPaths != nil && len(keyPaths) == 0 {
+		return nil, InvalidPolicyFormatError("keyPaths must not be empty")
+	}
+	for _, p := range keyPaths {
+		if p == "" {
+			return nil, InvalidPolicyFormatError("keyPaths must not contain empty paths")
+		}
+	}
+	if keyDatas != nil && len(keyDatas) == 0 {
+		return nil, InvalidPolicyFormatError("keyDatas must not be empty")
 	}
 	if signedIdentity == nil {
 		return nil, InvalidPolicyFormatError("signedIdentity not specified")
@@ -327,14 +360,16 @@ func newPRSignedBy(keyType sbKeyType, keyPath string, keyData []byte, signedIden
 		prCommon:       prCommon{Type: prTypeSignedBy},
 		KeyType:        keyType,
 		KeyPath:        keyPath,
+		KeyPaths:       keyPaths,
 		KeyData:        keyData,
+		KeyDatas:       keyDatas,
 		SignedIdentity: signedIdentity,
 	}, nil
 }
 
 // newPRSignedByKeyPath is NewPRSignedByKeyPath, except it returns the private type.
 func newPRSignedByKeyPath(keyType sbKeyType, keyPath string, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
-	return newPRSignedBy(keyType, keyPath, nil, signedIdentity)
+	return newPRSignedBy(keyType, keyPath, nil, nil, nil, signedIdentity)
 }
 
 // NewPRSignedByKeyPath returns a new "signedBy" PolicyRequirement using a KeyPath
@@ -342,9 +377,19 @@ func NewPRSignedByKeyPath(keyType sbKeyType, keyPath string, signedIdentity Poli
 	return newPRSignedByKeyPath(keyType, keyPath, signedIdentity)
 }
 
+// newPRSignedByKeyPaths is NewPRSignedByKeyPaths, except it returns the private type.
+func newPRSignedByKeyPaths(keyType sbKeyType, keyPaths []string, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
+	return newPRSignedBy(keyType, "", keyPaths, nil, nil, signedIdentity)
+}
+
+// NewPRSignedByKeyPaths returns a new "signedBy" PolicyRequirement using KeyPaths
+func NewPRSignedByKeyPaths(keyType sbKeyType, keyPaths []string, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSignedByKeyPaths(keyType, keyPaths, signedIdentity)
+}
+
 // newPRSignedByKeyData is NewPRSignedByKeyData, except it returns the private type.
 func newPRSignedByKeyData(keyType sbKeyType, keyData []byte, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
-	return newPRSignedBy(keyType, "", keyData, signedIdentity)
+	return newPRSignedBy(keyType, "", nil, keyData, nil, signedIdentity)
 }
 
 // NewPRSignedByKeyData returns a new "signedBy" PolicyRequirement using a KeyData
@@ -352,6 +397,16 @@ func NewPRSignedByKeyData(keyType sbKeyType, keyData []byte, signedIdentity Poli
 	return newPRSignedByKeyData(keyType, keyData, signedIdentity)
 }
 
+// newPRSignedByKeyDatas is NewPRSignedByKeyDatas, except it returns the private type.
+func newPRSignedByKeyDatas(keyType sbKeyType, keyDatas [][]byte, signedIdentity PolicyReferenceMatch) (*prSignedBy, error) {
+	return newPRSignedBy(keyType, "", nil, nil, keyDatas, signedIdentity)
+}
+
+// NewPRSignedByKeyDatas returns a new "signedBy" PolicyRequirement using KeyDatas
+func NewPRSignedByKeyDatas(keyType sbKeyType, keyDatas [][]byte, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSignedByKeyDatas(keyType, keyDatas, signedIdentity)
+}
+
 // Compile-time check that prSignedBy implements json.Unmarshaler.
 var _ json.Unmarshaler = (*prSignedBy)(nil)
 
@@ -359,8 +414,10 @@ var _ json.Unmarshaler = (*prSignedBy)(nil)
 func (pr *prSignedBy) UnmarshalJSON(data []byte) error {
 	*pr = prSignedBy{}
 	var tmp prSignedBy
-	var gotKeyPath, gotKeyData = false, false
+	var gotKeyPath, gotKeyPaths, gotKeyData, gotKeyDatas = false, false, false, false
 	var signedIdentity json.RawMessage
+	var signatureTime prSignatureTime
+	var gotSignatureTime = false
 	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
 		switch key {
 		case "type":
@@ -370,11 +427,20 @@ func (pr *prSignedBy) UnmarshalJSON(data []byte) error {
 		case "keyPath":
 			gotKeyPath = true
 			return &tmp.KeyPath
+		case "keyPaths":
+			gotKeyPaths = true
+			return &tmp.KeyPaths
 		case "keyData":
 			gotKeyData = true
 			return &tmp.KeyData
+		case "keyDatas":
+			gotKeyDatas = true
+			return &tmp.KeyDatas
 		case "signedIdentity":
 			return &signedIdentity
+		case "signatureTime":
+			gotSignatureTime = true
+			return &signatureTime
 		default:
 			return nil
 		}
@@ -397,21 +463,38 @@ func (pr *prSignedBy) UnmarshalJSON(data []byte) error {
 
 	var res *prSignedBy
 	var err error
+	keySources := 0
+	for _, got := range []bool{gotKeyPath, gotKeyPaths, gotKeyData, gotKeyDatas} {
+		if got {
+			keySources++
+		}
+	}
 	switch {
-	case gotKeyPath && gotKeyData:
-		return InvalidPolicyFormatError("keyPath and keyData cannot be used simultaneously")
-	case gotKeyPath && !gotKeyData:
+	case keySources > 1:
+		return InvalidPolicyFormatError("keyPath, keyPaths, keyData and keyDatas cannot be used simultaneously")
+	case gotKeyPath:
 		res, err = newPRSignedByKeyPath(tmp.KeyType, tmp.KeyPath, tmp.SignedIdentity)
-	case !gotKeyPath && gotKeyData:
+	case gotKeyPaths:
+		if tmp.KeyPaths == nil { // An explicit null
+			return InvalidPolicyFormatError("keyPaths must be an array")
+		}
+		res, err = newPRSignedByKeyPaths(tmp.KeyType, tmp.KeyPaths, tmp.SignedIdentity)
+	case gotKeyData:
 		res, err = newPRSignedByKeyData(tmp.KeyType, tmp.KeyData, tmp.SignedIdentity)
-	case !gotKeyPath && !gotKeyData:
-		return InvalidPolicyFormatError("At least one of keyPath and keyData mus be specified")
-	default: // Coverage: This should never happen
-		return fmt.Errorf("Impossible keyPath/keyData presence combination!?")
+	case gotKeyDatas:
+		if tmp.KeyDatas == nil { // An explicit null
+			return InvalidPolicyFormatError("keyDatas must be an array")
+		}
+		res, err = newPRSignedByKeyDatas(tmp.KeyType, tmp.KeyDatas, tmp.SignedIdentity)
+	default:
+		return InvalidPolicyFormatError("At least one of keyPath, keyPaths, keyData and keyDatas must be specified")
 	}
 	if err != nil {
 		return err
 	}
+	if gotSignatureTime {
+		res.SignatureTime = &signatureTime
+	}
 	*pr = *res
 
 	return nil
@@ -501,6 +584,434 @@ func (pr *prSignedBaseLayer) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// newPRSigstoreSigned returns a new prSigstoreSigned if parameters are valid.
+func newPRSigstoreSigned(keyPath string, keyPaths []string, keyData []byte, keyDatas [][]byte, fulcio PRSigstoreSignedFulcio, rekorPublicKeyPath string, rekorPublicKeyData []byte, signedIdentity PolicyReferenceMatch) (*prSigstoreSigned, error) {
+	keySources := 0
+	if keyPath != "" {
+		keySources++
+	}
+	if keyPaths != nil {
+		keySources++
+	}
+	if keyData != nil {
+		keySources++
+	}
+	if keyDatas != nil {
+		keySources++
+	}
+	if fulcio != nil {
+		keySources++
+	}
+	if keySources != 1 {
+		return nil, InvalidPolicyFormatError("exactly one of keyPath, keyPaths, keyData, keyDatas and fulcio must be specified")
+	}
+	if keyPaths != nil && len(keyPaths) == 0 {
+		return nil, InvalidPolicyFormatError("keyPaths must not be empty")
+	}
+	for _, p := range keyPaths {
+		if p == "" {
+			return nil, InvalidPolicyFormatError("keyPaths must not contain empty paths")
+		}
+	}
+	if keyDatas != nil && len(keyDatas) == 0 {
+		return nil, InvalidPolicyFormatError("keyDatas must not be empty")
+	}
+	for _, d := range keyDatas {
+		if len(d) == 0 {
+			return nil, InvalidPolicyFormatError("keyDatas must not contain empty values")
+		}
+	}
+	if rekorPublicKeyPath != "" && rekorPublicKeyData != nil {
+		return nil, InvalidPolicyFormatError("rekorPublicKeyPath and rekorPublicKeyData cannot be used simultaneously")
+	}
+	if fulcio != nil && rekorPublicKeyPath == "" && rekorPublicKeyData == nil {
+		return nil, InvalidPolicyFormatError("At least one of rekorPublicKeyPath and rekorPublicKeyData must be specified if fulcio is used")
+	}
+	if signedIdentity == nil {
+		return nil, InvalidPolicyFormatError("signedIdentity not specified")
+	}
+	return &prSigstoreSigned{
+		prCommon:           prCommon{Type: prTypeSigstoreSigned},
+		KeyPath:            keyPath,
+		KeyPaths:           keyPaths,
+		KeyData:            keyData,
+		KeyDatas:           keyDatas,
+		Fulcio:             fulcio,
+		RekorPublicKeyPath: rekorPublicKeyPath,
+		RekorPublicKeyData: rekorPublicKeyData,
+		SignedIdentity:     signedIdentity,
+	}, nil
+}
+
+// NewPRSigstoreSignedKeyPath returns a new "sigstoreSigned" PolicyRequirement using a KeyPath
+func NewPRSigstoreSignedKeyPath(keyPath string, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSigstoreSigned(keyPath, nil, nil, nil, nil, "", nil, signedIdentity)
+}
+
+// NewPRSigstoreSignedKeyPaths returns a new "sigstoreSigned" PolicyRequirement accepting signatures by a key in any of keyPaths
+func NewPRSigstoreSignedKeyPaths(keyPaths []string, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSigstoreSigned("", keyPaths, nil, nil, nil, "", nil, signedIdentity)
+}
+
+// NewPRSigstoreSignedKeyData returns a new "sigstoreSigned" PolicyRequirement using a KeyData
+func NewPRSigstoreSignedKeyData(keyData []byte, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSigstoreSigned("", nil, keyData, nil, nil, "", nil, signedIdentity)
+}
+
+// NewPRSigstoreSignedKeyDatas returns a new "sigstoreSigned" PolicyRequirement accepting signatures by a key in any of keyDatas
+func NewPRSigstoreSignedKeyDatas(keyDatas [][]byte, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	return newPRSigstoreSigned("", nil, nil, keyDatas, nil, "", nil, signedIdentity)
+}
+
+// NewPRSigstoreSignedFulcio returns a new "sigstoreSigned" PolicyRequirement accepting Fulcio-issued certificates,
+// recorded in a Rekor log signed by the key in rekorPublicKeyPath or rekorPublicKeyData (exactly one of which must be specified).
+func NewPRSigstoreSignedFulcio(fulcio PRSigstoreSignedFulcio, rekorPublicKeyPath string, rekorPublicKeyData []byte, signedIdentity PolicyReferenceMatch) (PolicyRequirement, error) {
+	if fulcio == nil {
+		return nil, InvalidPolicyFormatError("fulcio not specified")
+	}
+	return newPRSigstoreSigned("", nil, nil, nil, fulcio, rekorPublicKeyPath, rekorPublicKeyData, signedIdentity)
+}
+
+// Compile-time check that prSigstoreSigned implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prSigstoreSigned)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (pr *prSigstoreSigned) UnmarshalJSON(data []byte) error {
+	*pr = prSigstoreSigned{}
+	var tmp prSigstoreSigned
+	var gotKeyPath, gotKeyPaths, gotKeyData, gotKeyDatas = false, false, false, false
+	var gotRekorPublicKeyPath, gotRekorPublicKeyData = false, false
+	var fulcio prSigstoreSignedFulcio
+	var gotFulcio = false
+	var signedIdentity json.RawMessage
+	var signatureTime prSignatureTime
+	var gotSignatureTime = false
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "type":
+			return &tmp.Type
+		case "keyPath":
+			gotKeyPath = true
+			return &tmp.KeyPath
+		case "keyPaths":
+			gotKeyPaths = true
+			return &tmp.KeyPaths
+		case "keyData":
+			gotKeyData = true
+			return &tmp.KeyData
+		case "keyDatas":
+			gotKeyDatas = true
+			return &tmp.KeyDatas
+		case "fulcio":
+			gotFulcio = true
+			return &fulcio
+		case "rekorPublicKeyPath":
+			gotRekorPublicKeyPath = true
+			return &tmp.RekorPublicKeyPath
+		case "rekorPublicKeyData":
+			gotRekorPublicKeyData = true
+			return &tmp.RekorPublicKeyData
+		case "signedIdentity":
+			return &signedIdentity
+		case "signatureTime":
+			gotSignatureTime = true
+			return &signatureTime
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if tmp.Type != prTypeSigstoreSigned {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unexpected policy requirement type \"%s\"", tmp.Type))
+	}
+	if signedIdentity == nil {
+		tmp.SignedIdentity = NewPRMMatchExact()
+	} else {
+		si, err := newPolicyReferenceMatchFromJSON(signedIdentity)
+		if err != nil {
+			return err
+		}
+		tmp.SignedIdentity = si
+	}
+	if gotFulcio {
+		tmp.Fulcio = &fulcio
+	}
+	// Distinguish an explicitly specified empty value from a missing one, so that it is rejected by newPRSigstoreSigned.
+	if gotKeyPath && tmp.KeyPath == "" || gotKeyData && tmp.KeyData == nil ||
+		gotRekorPublicKeyPath && tmp.RekorPublicKeyPath == "" || gotRekorPublicKeyData && tmp.RekorPublicKeyData == nil {
+		return InvalidPolicyFormatError("Empty key values are not allowed")
+	}
+	if gotKeyPaths && tmp.KeyPaths == nil { // An explicit null
+		return InvalidPolicyFormatError("keyPaths must be an array")
+	}
+	if gotKeyDatas && tmp.KeyDatas == nil { // An explicit null
+		return InvalidPolicyFormatError("keyDatas must be an array")
+	}
+
+	res, err := newPRSigstoreSigned(tmp.KeyPath, tmp.KeyPaths, tmp.KeyData, tmp.KeyDatas, tmp.Fulcio, tmp.RekorPublicKeyPath, tmp.RekorPublicKeyData, tmp.SignedIdentity)
+	if err != nil {
+		return err
+	}
+	if gotSignatureTime {
+		res.SignatureTime = &signatureTime
+	}
+	*pr = *res
+
+	return nil
+}
+
+// newPRSigstoreAttested returns a new prSigstoreAttested if parameters are valid.
+func newPRSigstoreAttested(keyPath string, keyData []byte, predicateType string) (*prSigstoreAttested, error) {
+	if (keyPath != "") == (keyData != nil) {
+		return nil, InvalidPolicyFormatError("exactly one of keyPath and keyData must be specified")
+	}
+	if predicateType == "" {
+		return nil, InvalidPolicyFormatError("predicateType not specified")
+	}
+	return &prSigstoreAttested{
+		prCommon:      prCommon{Type: prTypeSigstoreAttested},
+		KeyPath:       keyPath,
+		KeyData:       keyData,
+		PredicateType: predicateType,
+	}, nil
+}
+
+// NewPRSigstoreAttestedKeyPath returns a new "sigstoreAttested" PolicyRequirement using a KeyPath
+func NewPRSigstoreAttestedKeyPath(keyPath string, predicateType string) (PolicyRequirement, error) {
+	return newPRSigstoreAttested(keyPath, nil, predicateType)
+}
+
+// NewPRSigstoreAttestedKeyData returns a new "sigstoreAttested" PolicyRequirement using a KeyData
+func NewPRSigstoreAttestedKeyData(keyData []byte, predicateType string) (PolicyRequirement, error) {
+	return newPRSigstoreAttested("", keyData, predicateType)
+}
+
+// Compile-time check that prSigstoreAttested implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prSigstoreAttested)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (pr *prSigstoreAttested) UnmarshalJSON(data []byte) error {
+	*pr = prSigstoreAttested{}
+	var tmp prSigstoreAttested
+	var gotKeyPath, gotKeyData = false, false
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "type":
+			return &tmp.Type
+		case "keyPath":
+			gotKeyPath = true
+			return &tmp.KeyPath
+		case "keyData":
+			gotKeyData = true
+			return &tmp.KeyData
+		case "predicateType":
+			return &tmp.PredicateType
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if tmp.Type != prTypeSigstoreAttested {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unexpected policy requirement type \"%s\"", tmp.Type))
+	}
+	// Distinguish an explicitly specified empty value from a missing one, so that it is rejected by newPRSigstoreAttested.
+	if gotKeyPath && tmp.KeyPath == "" || gotKeyData && tmp.KeyData == nil {
+		return InvalidPolicyFormatError("Empty key values are not allowed")
+	}
+
+	res, err := newPRSigstoreAttested(tmp.KeyPath, tmp.KeyData, tmp.PredicateType)
+	if err != nil {
+		return err
+	}
+	*pr = *res
+
+	return nil
+}
+
+// newPRSigstoreSignedFulcio returns a new prSigstoreSignedFulcio if parameters are valid.
+func newPRSigstoreSignedFulcio(caPath string, caData []byte, oidcIssuer, subjectEmail string) (*prSigstoreSignedFulcio, error) {
+	if caPath != "" && caData != nil {
+		return nil, InvalidPolicyFormatError("caPath and caData cannot be used simultaneously")
+	}
+	if caPath == "" && caData == nil {
+		return nil, InvalidPolicyFormatError("At least one of caPath and caData must be specified")
+	}
+	if oidcIssuer == "" {
+		return nil, InvalidPolicyFormatError("oidcIssuer not specified")
+	}
+	if subjectEmail == "" {
+		return nil, InvalidPolicyFormatError("subjectEmail not specified")
+	}
+	return &prSigstoreSignedFulcio{
+		CAPath:       caPath,
+		CAData:       caData,
+		OIDCIssuer:   oidcIssuer,
+		SubjectEmail: subjectEmail,
+	}, nil
+}
+
+// NewPRSigstoreSignedFulcioCAPath returns a PRSigstoreSignedFulcio trusting the CA certificates in caPath,
+// and accepting certificates for subjectEmail authenticated by oidcIssuer.
+func NewPRSigstoreSignedFulcioCAPath(caPath, oidcIssuer, subjectEmail string) (PRSigstoreSignedFulcio, error) {
+	return newPRSigstoreSignedFulcio(caPath, nil, oidcIssuer, subjectEmail)
+}
+
+// NewPRSigstoreSignedFulcioCAData returns a PRSigstoreSignedFulcio trusting the CA certificates in caData,
+// and accepting certificates for subjectEmail authenticated by oidcIssuer.
+func NewPRSigstoreSignedFulcioCAData(caData []byte, oidcIssuer, subjectEmail string) (PRSigstoreSignedFulcio, error) {
+	return newPRSigstoreSignedFulcio("", caData, oidcIssuer, subjectEmail)
+}
+
+// Compile-time check that prSigstoreSignedFulcio implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prSigstoreSignedFulcio)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (f *prSigstoreSignedFulcio) UnmarshalJSON(data []byte) error {
+	*f = prSigstoreSignedFulcio{}
+	var tmp prSigstoreSignedFulcio
+	var gotCAPath, gotCAData, gotOIDCIssuer, gotSubjectEmail = false, false, false, false
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "caPath":
+			gotCAPath = true
+			return &tmp.CAPath
+		case "caData":
+			gotCAData = true
+			return &tmp.CAData
+		case "oidcIssuer":
+			gotOIDCIssuer = true
+			return &tmp.OIDCIssuer
+		case "subjectEmail":
+			gotSubjectEmail = true
+			return &tmp.SubjectEmail
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if gotCAPath && tmp.CAPath == "" || gotCAData && tmp.CAData == nil {
+		return InvalidPolicyFormatError("Empty CA values are not allowed")
+	}
+	if !gotOIDCIssuer {
+		return InvalidPolicyFormatError("oidcIssuer not specified")
+	}
+	if !gotSubjectEmail {
+		return InvalidPolicyFormatError("subjectEmail not specified")
+	}
+
+	res, err := newPRSigstoreSignedFulcio(tmp.CAPath, tmp.CAData, tmp.OIDCIssuer, tmp.SubjectEmail)
+	if err != nil {
+		return err
+	}
+	*f = *res
+	return nil
+}
+
+// newPRSignatureTime returns a new prSignatureTime if parameters are valid.
+func newPRSignatureTime(maxAge string, notBefore, notAfter *time.Time) (*prSignatureTime, error) {
+	if maxAge == "" && notBefore == nil && notAfter == nil {
+		return nil, InvalidPolicyFormatError("At least one of maxAge, notBefore and notAfter must be specified")
+	}
+	if maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return nil, InvalidPolicyFormatError(fmt.Sprintf("Invalid maxAge \"%s\": %v", maxAge, err))
+		}
+		if d <= 0 {
+			return nil, InvalidPolicyFormatError(fmt.Sprintf("maxAge \"%s\" is not positive", maxAge))
+		}
+	}
+	if notBefore != nil && notAfter != nil && notAfter.Before(*notBefore) {
+		return nil, InvalidPolicyFormatError("notAfter is before notBefore")
+	}
+	return &prSignatureTime{
+		MaxAge:    maxAge,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	}, nil
+}
+
+// NewPRSignatureTime returns a PRSignatureTime accepting signatures created at most maxAge ago (unless maxAge is 0),
+// not before notBefore (unless it is the zero time), and not after notAfter (unless it is the zero time).
+func NewPRSignatureTime(maxAge time.Duration, notBefore, notAfter time.Time) (PRSignatureTime, error) {
+	var maxAgeString string
+	if maxAge != 0 {
+		maxAgeString = maxAge.String()
+	}
+	var notBeforePtr, notAfterPtr *time.Time
+	if !notBefore.IsZero() {
+		notBeforePtr = &notBefore
+	}
+	if !notAfter.IsZero() {
+		notAfterPtr = &notAfter
+	}
+	return newPRSignatureTime(maxAgeString, notBeforePtr, notAfterPtr)
+}
+
+// Compile-time check that prSignatureTime implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prSignatureTime)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (st *prSignatureTime) UnmarshalJSON(data []byte) error {
+	*st = prSignatureTime{}
+	var tmp prSignatureTime
+	var gotMaxAge, gotNotBefore, gotNotAfter = false, false, false
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "maxAge":
+			gotMaxAge = true
+			return &tmp.MaxAge
+		case "notBefore":
+			gotNotBefore = true
+			return &tmp.NotBefore
+		case "notAfter":
+			gotNotAfter = true
+			return &tmp.NotAfter
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if gotMaxAge && tmp.MaxAge == "" || gotNotBefore && tmp.NotBefore == nil || gotNotAfter && tmp.NotAfter == nil {
+		return InvalidPolicyFormatError("Empty signature time values are not allowed")
+	}
+
+	res, err := newPRSignatureTime(tmp.MaxAge, tmp.NotBefore, tmp.NotAfter)
+	if err != nil {
+		return err
+	}
+	*st = *res
+	return nil
+}
+
+// WithSignatureTime returns a copy of pr, which must be a "signedBy" or "sigstoreSigned" PolicyRequirement,
+// which only accepts signatures with a creation time accepted by signatureTime.
+func WithSignatureTime(pr PolicyRequirement, signatureTime PRSignatureTime) (PolicyRequirement, error) {
+	if signatureTime == nil {
+		return nil, InvalidPolicyFormatError("signatureTime not specified")
+	}
+	switch pr := pr.(type) {
+	case *prSignedBy:
+		res := *pr
+		res.SignatureTime = signatureTime
+		return &res, nil
+	case *prSigstoreSigned:
+		res := *pr
+		res.SignatureTime = signatureTime
+		return &res, nil
+	default:
+		return nil, InvalidPolicyFormatError("signatureTime can only be used with signedBy and sigstoreSigned requirements")
+	}
+}
+
 // newPolicyRequirementFromJSON parses JSON data into a PolicyReferenceMatch implementation.
 func newPolicyReferenceMatchFromJSON(data []byte) (PolicyReferenceMatch, error) {
 	var typeField prmCommon
@@ -517,6 +1028,8 @@ func newPolicyReferenceMatchFromJSON(data []byte) (PolicyReferenceMatch, error)
 		res = &prmExactReference{}
 	case prmTypeExactRepository:
 		res = &prmExactRepository{}
+	case prmTypeRemapIdentity:
+		res = &prmRemapIdentity{}
 	default:
 		return nil, InvalidPolicyFormatError(fmt.Sprintf("Unknown policy reference match type \"%s\"", typeField.Type))
 	}
@@ -695,3 +1208,80 @@ func (prm *prmExactRepository) UnmarshalJSON(data []byte) error {
 	*prm = *res
 	return nil
 }
+
+// hostnameRegexp matches a valid host name, optionally with a port, as used in the first component of a Docker reference.
+var hostnameRegexp = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+
+// pathComponentRegexp matches a valid path component (namespace or repository name) of a Docker reference.
+var pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+
+// validateIdentityRemappingPrefix returns an InvalidPolicyFormatError if s is not a valid value for prmRemapIdentity.Prefix or SignedPrefix:
+// a host[:port], optionally followed by /namespace[/namespace…]/repo, without a tag or digest.
+func validateIdentityRemappingPrefix(s string) error {
+	components := strings.Split(s, "/")
+	host := components[0]
+	// As in reference.ParseNamed, the first component is a host name only if it contains a '.' or a ':', or is "localhost".
+	if !hostnameRegexp.MatchString(host) || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return InvalidPolicyFormatError(fmt.Sprintf("prefix %q does not start with a host[:port]", s))
+	}
+	for _, c := range components[1:] {
+		if !pathComponentRegexp.MatchString(c) {
+			return InvalidPolicyFormatError(fmt.Sprintf("prefix %q contains an invalid path component %q", s, c))
+		}
+	}
+	return nil
+}
+
+// newPRMRemapIdentity is NewPRMRemapIdentity, except it returns the private type.
+func newPRMRemapIdentity(prefix, signedPrefix string) (*prmRemapIdentity, error) {
+	if err := validateIdentityRemappingPrefix(prefix); err != nil {
+		return nil, err
+	}
+	if err := validateIdentityRemappingPrefix(signedPrefix); err != nil {
+		return nil, err
+	}
+	return &prmRemapIdentity{
+		prmCommon:    prmCommon{Type: prmTypeRemapIdentity},
+		Prefix:       prefix,
+		SignedPrefix: signedPrefix,
+	}, nil
+}
+
+// NewPRMRemapIdentity returns a new "remapIdentity" PolicyRepositoryMatch.
+func NewPRMRemapIdentity(prefix, signedPrefix string) (PolicyReferenceMatch, error) {
+	return newPRMRemapIdentity(prefix, signedPrefix)
+}
+
+// Compile-time check that prmRemapIdentity implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prmRemapIdentity)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (prm *prmRemapIdentity) UnmarshalJSON(data []byte) error {
+	*prm = prmRemapIdentity{}
+	var tmp prmRemapIdentity
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "type":
+			return &tmp.Type
+		case "prefix":
+			return &tmp.Prefix
+		case "signedPrefix":
+			return &tmp.SignedPrefix
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if tmp.Type != prmTypeRemapIdentity {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unexpected policy requirement type \"%s\"", tmp.Type))
+	}
+
+	res, err := newPRMRemapIdentity(tmp.Prefix, tmp.SignedPrefix)
+	if err != nil {
+		return err
+	}
+	*prm = *res
+	return nil
+}
@@ -3,9 +3,11 @@ package signature
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/containers/image/directory"
 	"github.com/containers/image/docker"
@@ -39,6 +41,14 @@ var policyFixtureContents = &Policy{
 					NewPRMMatchExact()),
 				xNewPRSignedBaseLayer(xNewPRMExactRepository("registry.access.redhat.com/rhel7/rhel")),
 			},
+			"example.com/sigstore": {
+				xNewPRSigstoreSignedKeyPath("/keys/cosign.pub",
+					NewPRMMatchRepository()),
+			},
+			"example.com/provenance": {
+				xNewPRSigstoreAttestedKeyPath("/keys/cosign.pub",
+					"https://slsa.dev/provenance/v0.2"),
+			},
 			"example.com/hardened-x509": {
 				xNewPRSignedByKeyPath(SBKeyTypeX509Certificates,
 					"/keys/employee-cert-file",
@@ -211,6 +221,24 @@ func xNewPRSignedByKeyPath(keyType sbKeyType, keyPath string, signedIdentity Pol
 	return pr
 }
 
+// xNewPRSignedByKeyPaths is like NewPRSignedByKeyPaths, except it must not fail.
+func xNewPRSignedByKeyPaths(keyType sbKeyType, keyPaths []string, signedIdentity PolicyReferenceMatch) PolicyRequirement {
+	pr, err := NewPRSignedByKeyPaths(keyType, keyPaths, signedIdentity)
+	if err != nil {
+		panic("xNewPRSignedByKeyPaths failed")
+	}
+	return pr
+}
+
+// xNewPRSignedByKeyDatas is like NewPRSignedByKeyDatas, except it must not fail.
+func xNewPRSignedByKeyDatas(keyType sbKeyType, keyDatas [][]byte, signedIdentity PolicyReferenceMatch) PolicyRequirement {
+	pr, err := NewPRSignedByKeyDatas(keyType, keyDatas, signedIdentity)
+	if err != nil {
+		panic("xNewPRSignedByKeyDatas failed")
+	}
+	return pr
+}
+
 // xNewPRSignedByKeyData is like NewPRSignedByKeyData, except it must not fail.
 func xNewPRSignedByKeyData(keyType sbKeyType, keyData []byte, signedIdentity PolicyReferenceMatch) PolicyRequirement {
 	pr, err := NewPRSignedByKeyData(keyType, keyData, signedIdentity)
@@ -632,10 +660,12 @@ func TestPRRejectUnmarshalJSON(t *testing.T) {
 func TestNewPRSignedBy(t *testing.T) {
 	const testPath = "/foo/bar"
 	testData := []byte("abc")
+	testPaths := []string{"/foo/bar", "/foo/baz"}
+	testDatas := [][]byte{[]byte("abc"), []byte("def")}
 	testIdentity := NewPRMMatchExact()
 
 	// Success
-	pr, err := newPRSignedBy(SBKeyTypeGPGKeys, testPath, nil, testIdentity)
+	pr, err := newPRSignedBy(SBKeyTypeGPGKeys, testPath, nil, nil, nil, testIdentity)
 	require.NoError(t, err)
 	assert.Equal(t, &prSignedBy{
 		prCommon:       prCommon{prTypeSignedBy},
@@ -644,7 +674,7 @@ func TestNewPRSignedBy(t *testing.T) {
 		KeyData:        nil,
 		SignedIdentity: testIdentity,
 	}, pr)
-	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, "", testData, testIdentity)
+	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, "", nil, testData, nil, testIdentity)
 	require.NoError(t, err)
 	assert.Equal(t, &prSignedBy{
 		prCommon:       prCommon{prTypeSignedBy},
@@ -653,19 +683,60 @@ func TestNewPRSignedBy(t *testing.T) {
 		KeyData:        testData,
 		SignedIdentity: testIdentity,
 	}, pr)
+	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, "", testPaths, nil, nil, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSignedBy{
+		prCommon:       prCommon{prTypeSignedBy},
+		KeyType:        SBKeyTypeGPGKeys,
+		KeyPaths:       testPaths,
+		SignedIdentity: testIdentity,
+	}, pr)
+	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, "", nil, nil, testDatas, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSignedBy{
+		prCommon:       prCommon{prTypeSignedBy},
+		KeyType:        SBKeyTypeGPGKeys,
+		KeyDatas:       testDatas,
+		SignedIdentity: testIdentity,
+	}, pr)
 
 	// Invalid keyType
-	pr, err = newPRSignedBy(sbKeyType(""), testPath, nil, testIdentity)
+	pr, err = newPRSignedBy(sbKeyType(""), testPath, nil, nil, nil, testIdentity)
 	assert.Error(t, err)
-	pr, err = newPRSignedBy(sbKeyType("this is invalid"), testPath, nil, testIdentity)
+	pr, err = newPRSignedBy(sbKeyType("this is invalid"), testPath, nil, nil, nil, testIdentity)
 	assert.Error(t, err)
 
 	// Both keyPath and keyData specified
-	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, testPath, testData, testIdentity)
+	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, testPath, nil, testData, nil, testIdentity)
+	assert.Error(t, err)
+
+	// More than one of keyPath, keyPaths, keyData and keyDatas specified
+	for _, c := range []struct {
+		keyPath  string
+		keyPaths []string
+		keyData  []byte
+		keyDatas [][]byte
+	}{
+		{testPath, testPaths, nil, nil},
+		{"", testPaths, testData, nil},
+		{"", testPaths, nil, testDatas},
+		{"", nil, testData, testDatas},
+		{testPath, nil, nil, testDatas},
+	} {
+		_, err = newPRSignedBy(SBKeyTypeGPGKeys, c.keyPath, c.keyPaths, c.keyData, c.keyDatas, testIdentity)
+		assert.Error(t, err, "%#v", c)
+	}
+
+	// Empty keyPaths and keyDatas, or an empty path
+	_, err = newPRSignedBy(SBKeyTypeGPGKeys, "", []string{}, nil, nil, testIdentity)
+	assert.Error(t, err)
+	_, err = newPRSignedBy(SBKeyTypeGPGKeys, "", []string{"/foo/bar", ""}, nil, nil, testIdentity)
+	assert.Error(t, err)
+	_, err = newPRSignedBy(SBKeyTypeGPGKeys, "", nil, nil, [][]byte{}, testIdentity)
 	assert.Error(t, err)
 
 	// Invalid signedIdentity
-	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, testPath, nil, nil)
+	pr, err = newPRSignedBy(SBKeyTypeGPGKeys, testPath, nil, nil, nil, nil)
 	assert.Error(t, err)
 }
 
@@ -679,6 +750,26 @@ func TestNewPRSignedByKeyPath(t *testing.T) {
 	// Failure cases tested in TestNewPRSignedBy.
 }
 
+func TestNewPRSignedByKeyPaths(t *testing.T) {
+	testPaths := []string{"/foo/bar", "/foo/baz"}
+	_pr, err := NewPRSignedByKeyPaths(SBKeyTypeGPGKeys, testPaths, NewPRMMatchExact())
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSignedBy)
+	require.True(t, ok)
+	assert.Equal(t, testPaths, pr.KeyPaths)
+	// Failure cases tested in TestNewPRSignedBy.
+}
+
+func TestNewPRSignedByKeyDatas(t *testing.T) {
+	testDatas := [][]byte{[]byte("abc"), []byte("def")}
+	_pr, err := NewPRSignedByKeyDatas(SBKeyTypeGPGKeys, testDatas, NewPRMMatchExact())
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSignedBy)
+	require.True(t, ok)
+	assert.Equal(t, testDatas, pr.KeyDatas)
+	// Failure cases tested in TestNewPRSignedBy.
+}
+
 func TestNewPRSignedByKeyData(t *testing.T) {
 	testData := []byte("abc")
 	_pr, err := NewPRSignedByKeyData(SBKeyTypeGPGKeys, testData, NewPRMMatchExact())
@@ -731,6 +822,29 @@ func TestPRSignedByUnmarshalJSON(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, kpPR, &pr)
 
+	// Success with KeyPaths and KeyDatas
+	for _, multiPR := range []PolicyRequirement{
+		xNewPRSignedByKeyPaths(SBKeyTypeGPGKeys, []string{"/foo/bar", "/foo/baz"}, NewPRMMatchExact()),
+		xNewPRSignedByKeyDatas(SBKeyTypeGPGKeys, [][]byte{[]byte("abc"), []byte("def")}, NewPRMMatchExact()),
+	} {
+		testJSON, err := json.Marshal(multiPR)
+		require.NoError(t, err)
+		pr = prSignedBy{}
+		err = json.Unmarshal(testJSON, &pr)
+		require.NoError(t, err)
+		assert.Equal(t, multiPR, &pr)
+	}
+
+	// Success with SignatureTime
+	stPR, err := WithSignatureTime(validPR, xNewPRSignatureTime(0, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}))
+	require.NoError(t, err)
+	testJSON, err = json.Marshal(stPR)
+	require.NoError(t, err)
+	pr = prSignedBy{}
+	err = json.Unmarshal(testJSON, &pr)
+	require.NoError(t, err)
+	assert.Equal(t, stPR, &pr)
+
 	// newPolicyRequirementFromJSON recognizes this type
 	_pr, err := newPolicyRequirementFromJSON(validJSON)
 	require.NoError(t, err)
@@ -759,10 +873,27 @@ func TestPRSignedByUnmarshalJSON(t *testing.T) {
 		// Invalid "keyData" field
 		func(v mSI) { v["keyData"] = 1 },
 		func(v mSI) { v["keyData"] = "this is invalid base64" },
+		// Both "keyData" and "keyPaths" or "keyDatas" is present
+		func(v mSI) { v["keyPaths"] = []string{"/foo/bar"} },
+		func(v mSI) { v["keyDatas"] = []string{"YWJj"} },
+		// Invalid "keyPaths" field
+		func(v mSI) { delete(v, "keyData"); v["keyPaths"] = "/foo/bar" },
+		func(v mSI) { delete(v, "keyData"); v["keyPaths"] = []string{} },
+		func(v mSI) { delete(v, "keyData"); v["keyPaths"] = nil },
+		func(v mSI) { delete(v, "keyData"); v["keyPaths"] = []interface{}{1} },
+		// Invalid "keyDatas" field
+		func(v mSI) { delete(v, "keyData"); v["keyDatas"] = "YWJj" },
+		func(v mSI) { delete(v, "keyData"); v["keyDatas"] = []string{} },
+		func(v mSI) { delete(v, "keyData"); v["keyDatas"] = nil },
+		func(v mSI) { delete(v, "keyData"); v["keyDatas"] = []string{"this is invalid base64"} },
 		// Invalid "signedIdentity" field
 		func(v mSI) { v["signedIdentity"] = "this is invalid" },
 		// "signedIdentity" an explicit nil
 		func(v mSI) { v["signedIdentity"] = nil },
+		// Invalid "signatureTime" field
+		func(v mSI) { v["signatureTime"] = 1 },
+		func(v mSI) { v["signatureTime"] = nil },
+		func(v mSI) { v["signatureTime"] = mSI{"notBefore": "this is invalid"} },
 	}
 	for _, fn := range breakFns {
 		err = tryUnmarshalModifiedSignedBy(t, &pr, validJSON, fn)
@@ -803,6 +934,621 @@ func TestPRSignedByUnmarshalJSON(t *testing.T) {
 
 }
 
+// xNewPRSigstoreSignedKeyPath is like NewPRSigstoreSignedKeyPath, except it must not fail.
+func xNewPRSigstoreSignedKeyPath(keyPath string, signedIdentity PolicyReferenceMatch) PolicyRequirement {
+	pr, err := NewPRSigstoreSignedKeyPath(keyPath, signedIdentity)
+	if err != nil {
+		panic("xNewPRSigstoreSignedKeyPath failed")
+	}
+	return pr
+}
+
+// xNewPRSigstoreSignedKeyPaths is like NewPRSigstoreSignedKeyPaths, except it must not fail.
+func xNewPRSigstoreSignedKeyPaths(keyPaths []string, signedIdentity PolicyReferenceMatch) PolicyRequirement {
+	pr, err := NewPRSigstoreSignedKeyPaths(keyPaths, signedIdentity)
+	if err != nil {
+		panic("xNewPRSigstoreSignedKeyPaths failed")
+	}
+	return pr
+}
+
+// xNewPRSigstoreSignedKeyDatas is like NewPRSigstoreSignedKeyDatas, except it must not fail.
+func xNewPRSigstoreSignedKeyDatas(keyDatas [][]byte, signedIdentity PolicyReferenceMatch) PolicyRequirement {
+	pr, err := NewPRSigstoreSignedKeyDatas(keyDatas, signedIdentity)
+	if err != nil {
+		panic("xNewPRSigstoreSignedKeyDatas failed")
+	}
+	return pr
+}
+
+func TestNewPRSigstoreSigned(t *testing.T) {
+	const testPath = "/foo/bar"
+	testPaths := []string{"/foo/bar", "/foo/baz"}
+	testData := []byte("abc")
+	testDatas := [][]byte{[]byte("abc"), []byte("def")}
+	testFulcio, err := NewPRSigstoreSignedFulcioCAPath("/foo/ca", "https://example.com", "user@example.com")
+	require.NoError(t, err)
+	const testRekorPath = "/foo/rekor"
+	testRekorData := []byte("rekor")
+	testIdentity := NewPRMMatchExact()
+
+	// Success
+	pr, err := newPRSigstoreSigned(testPath, nil, nil, nil, nil, "", nil, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:       prCommon{prTypeSigstoreSigned},
+		KeyPath:        testPath,
+		KeyData:        nil,
+		SignedIdentity: testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned("", nil, testData, nil, nil, testRekorPath, nil, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:           prCommon{prTypeSigstoreSigned},
+		KeyPath:            "",
+		KeyData:            testData,
+		RekorPublicKeyPath: testRekorPath,
+		SignedIdentity:     testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned("", nil, nil, nil, testFulcio, "", testRekorData, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:           prCommon{prTypeSigstoreSigned},
+		Fulcio:             testFulcio,
+		RekorPublicKeyData: testRekorData,
+		SignedIdentity:     testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned("", testPaths, nil, nil, nil, "", nil, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:       prCommon{prTypeSigstoreSigned},
+		KeyPaths:       testPaths,
+		SignedIdentity: testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned("", nil, nil, testDatas, nil, "", nil, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:       prCommon{prTypeSigstoreSigned},
+		KeyDatas:       testDatas,
+		SignedIdentity: testIdentity,
+	}, pr)
+
+	for _, c := range []struct {
+		keyPath   string
+		keyPaths  []string
+		keyData   []byte
+		keyDatas  [][]byte
+		fulcio    PRSigstoreSignedFulcio
+		rekorPath string
+		rekorData []byte
+	}{
+		{testPath, nil, testData, nil, nil, "", nil},                 // Both keyPath and keyData specified
+		{testPath, nil, nil, nil, testFulcio, testRekorPath, nil},    // Both keyPath and fulcio specified
+		{"", nil, testData, nil, testFulcio, testRekorPath, nil},     // Both keyData and fulcio specified
+		{testPath, testPaths, nil, nil, nil, "", nil},                // Both keyPath and keyPaths specified
+		{"", nil, testData, testDatas, nil, "", nil},                 // Both keyData and keyDatas specified
+		{"", testPaths, nil, testDatas, nil, "", nil},                // Both keyPaths and keyDatas specified
+		{"", []string{}, nil, nil, nil, "", nil},                     // Empty keyPaths
+		{"", []string{testPath, ""}, nil, nil, nil, "", nil},         // An empty path in keyPaths
+		{"", nil, nil, [][]byte{}, nil, "", nil},                     // Empty keyDatas
+		{"", nil, nil, [][]byte{testData, {}}, nil, "", nil},         // An empty value in keyDatas
+		{"", nil, nil, nil, nil, "", nil},                            // None of keyPath, keyPaths, keyData, keyDatas and fulcio specified
+		{"", nil, nil, nil, testFulcio, "", nil},                     // fulcio without Rekor
+		{testPath, nil, nil, nil, nil, testRekorPath, testRekorData}, // Both rekorPublicKeyPath and rekorPublicKeyData specified
+	} {
+		_, err = newPRSigstoreSigned(c.keyPath, c.keyPaths, c.keyData, c.keyDatas, c.fulcio, c.rekorPath, c.rekorData, testIdentity)
+		assert.Error(t, err, fmt.Sprintf("%#v", c))
+	}
+	// Invalid signedIdentity
+	_, err = newPRSigstoreSigned(testPath, nil, nil, nil, nil, "", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestNewPRSigstoreSignedFulcio(t *testing.T) {
+	fulcio, err := NewPRSigstoreSignedFulcioCAData([]byte("ca"), "https://example.com", "user@example.com")
+	require.NoError(t, err)
+	_pr, err := NewPRSigstoreSignedFulcio(fulcio, "/foo/rekor", nil, NewPRMMatchExact())
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSigstoreSigned)
+	require.True(t, ok)
+	assert.Equal(t, fulcio, pr.Fulcio)
+	assert.Equal(t, "/foo/rekor", pr.RekorPublicKeyPath)
+
+	_, err = NewPRSigstoreSignedFulcio(nil, "/foo/rekor", nil, NewPRMMatchExact())
+	assert.Error(t, err)
+	// Other failure cases tested in TestNewPRSigstoreSigned.
+}
+
+func TestNewPRSigstoreSignedFulcioConfig(t *testing.T) {
+	// Success
+	f, err := newPRSigstoreSignedFulcio("/foo/ca", nil, "https://example.com", "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSignedFulcio{CAPath: "/foo/ca", OIDCIssuer: "https://example.com", SubjectEmail: "user@example.com"}, f)
+	f, err = newPRSigstoreSignedFulcio("", []byte("ca"), "https://example.com", "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSignedFulcio{CAData: []byte("ca"), OIDCIssuer: "https://example.com", SubjectEmail: "user@example.com"}, f)
+
+	for _, c := range []struct {
+		caPath                   string
+		caData                   []byte
+		oidcIssuer, subjectEmail string
+	}{
+		{"/foo/ca", []byte("ca"), "https://example.com", "user@example.com"}, // Both caPath and caData specified
+		{"", nil, "https://example.com", "user@example.com"},                 // Neither caPath nor caData specified
+		{"/foo/ca", nil, "", "user@example.com"},                             // Missing oidcIssuer
+		{"/foo/ca", nil, "https://example.com", ""},                          // Missing subjectEmail
+	} {
+		_, err := newPRSigstoreSignedFulcio(c.caPath, c.caData, c.oidcIssuer, c.subjectEmail)
+		assert.Error(t, err, fmt.Sprintf("%#v", c))
+	}
+}
+
+func TestPRSigstoreSignedUnmarshalJSON(t *testing.T) {
+	var pr prSigstoreSigned
+
+	testInvalidJSONInput(t, &pr)
+
+	// Start with a valid JSON.
+	validPR, err := NewPRSigstoreSignedKeyData([]byte("abc"), NewPRMMatchExact())
+	require.NoError(t, err)
+	validJSON, err := json.Marshal(validPR)
+	require.NoError(t, err)
+
+	// Success with KeyData
+	pr = prSigstoreSigned{}
+	err = json.Unmarshal(validJSON, &pr)
+	require.NoError(t, err)
+	assert.Equal(t, validPR, &pr)
+
+	// Success with KeyPath
+	kpPR, err := NewPRSigstoreSignedKeyPath("/foo/bar", NewPRMMatchExact())
+	require.NoError(t, err)
+	testJSON, err := json.Marshal(kpPR)
+	require.NoError(t, err)
+	pr = prSigstoreSigned{}
+	err = json.Unmarshal(testJSON, &pr)
+	require.NoError(t, err)
+	assert.Equal(t, kpPR, &pr)
+
+	// Success with KeyPaths and KeyDatas
+	for _, multiPR := range []PolicyRequirement{
+		xNewPRSigstoreSignedKeyPaths([]string{"/foo/bar", "/foo/baz"}, NewPRMMatchExact()),
+		xNewPRSigstoreSignedKeyDatas([][]byte{[]byte("abc"), []byte("def")}, NewPRMMatchExact()),
+	} {
+		testJSON, err := json.Marshal(multiPR)
+		require.NoError(t, err)
+		pr = prSigstoreSigned{}
+		err = json.Unmarshal(testJSON, &pr)
+		require.NoError(t, err)
+		assert.Equal(t, multiPR, &pr)
+	}
+
+	// newPolicyRequirementFromJSON recognizes this type
+	_pr, err := newPolicyRequirementFromJSON(validJSON)
+	require.NoError(t, err)
+	assert.Equal(t, validPR, _pr)
+
+	// Various ways to corrupt the JSON
+	breakFns := []func(mSI){
+		// The "type" field is missing
+		func(v mSI) { delete(v, "type") },
+		// Wrong "type" field
+		func(v mSI) { v["type"] = 1 },
+		func(v mSI) { v["type"] = "this is invalid" },
+		// Extra top-level sub-object
+		func(v mSI) { v["unexpected"] = 1 },
+		// Both "keyPath" and "keyData" is missing
+		func(v mSI) { delete(v, "keyData") },
+		// Both "keyPath" and "keyData" is present
+		func(v mSI) { v["keyPath"] = "/foo/bar" },
+		// Invalid "keyPath" field
+		func(v mSI) { delete(v, "keyData"); v["keyPath"] = 1 },
+		// Invalid "keyData" field
+		func(v mSI) { v["keyData"] = 1 },
+		func(v mSI) { v["keyData"] = "this is invalid base64" },
+		// Both "keyData" and "keyPaths" or "keyDatas" is present
+		func(v mSI) { v["keyPaths"] = []string{"/foo/bar"} },
+		func(v mSI) { v["keyDatas"] = [][]byte{[]byte("def")} },
+		// Invalid or empty "keyPaths" field
+		func(v mSI) { delete(v, "keyData"); v["keyPaths"] = 1 },
+		func(v mSI) { delete(v, "keyData"); v["keyPaths"] = nil },
+		func(v mSI) { delete(v, "keyData"); v["keyPaths"] = []string{} },
+		func(v mSI) { delete(v, "keyData"); v["keyPaths"] = []string{""} },
+		// Invalid or empty "keyDatas" field
+		func(v mSI) { delete(v, "keyData"); v["keyDatas"] = 1 },
+		func(v mSI) { delete(v, "keyData"); v["keyDatas"] = nil },
+		func(v mSI) { delete(v, "keyData"); v["keyDatas"] = []string{} },
+		func(v mSI) { delete(v, "keyData"); v["keyDatas"] = []string{"this is invalid base64"} },
+		// Invalid "signedIdentity" field
+		func(v mSI) { v["signedIdentity"] = "this is invalid" },
+		// "signedIdentity" an explicit nil
+		func(v mSI) { v["signedIdentity"] = nil },
+		// Invalid "signatureTime" field
+		func(v mSI) { v["signatureTime"] = 1 },
+		func(v mSI) { v["signatureTime"] = nil },
+		func(v mSI) { v["signatureTime"] = mSI{} },
+		func(v mSI) { v["signatureTime"] = mSI{"maxAge": "this is invalid"} },
+	}
+	for _, fn := range breakFns {
+		var tmp mSI
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+		fn(tmp)
+		testJSON, err := json.Marshal(tmp)
+		require.NoError(t, err)
+
+		pr = prSigstoreSigned{}
+		err = json.Unmarshal(testJSON, &pr)
+		assert.Error(t, err, string(testJSON))
+	}
+
+	// Duplicated fields
+	for _, field := range []string{"type", "keyData", "signedIdentity"} {
+		var tmp mSI
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+
+		testJSON := addExtraJSONMember(t, validJSON, field, tmp[field])
+
+		pr = prSigstoreSigned{}
+		err = json.Unmarshal(testJSON, &pr)
+		assert.Error(t, err)
+	}
+
+	// Success with SignatureTime
+	stPR, err := WithSignatureTime(validPR, xNewPRSignatureTime(720*time.Hour, time.Time{}, time.Time{}))
+	require.NoError(t, err)
+	testJSON, err = json.Marshal(stPR)
+	require.NoError(t, err)
+	pr = prSigstoreSigned{}
+	err = json.Unmarshal(testJSON, &pr)
+	require.NoError(t, err)
+	assert.Equal(t, stPR, &pr)
+
+	// Success with Fulcio and Rekor
+	fulcio, err := NewPRSigstoreSignedFulcioCAPath("/foo/ca", "https://example.com", "user@example.com")
+	require.NoError(t, err)
+	fulcioPR, err := NewPRSigstoreSignedFulcio(fulcio, "", []byte("rekor"), NewPRMMatchRepository())
+	require.NoError(t, err)
+	fulcioJSON, err := json.Marshal(fulcioPR)
+	require.NoError(t, err)
+	pr = prSigstoreSigned{}
+	err = json.Unmarshal(fulcioJSON, &pr)
+	require.NoError(t, err)
+	assert.Equal(t, fulcioPR, &pr)
+
+	// Various ways to corrupt the Fulcio JSON
+	fulcioBreakFns := []func(mSI){
+		// Fulcio without Rekor
+		func(v mSI) { delete(v, "rekorPublicKeyData") },
+		// Both "rekorPublicKeyPath" and "rekorPublicKeyData" is present
+		func(v mSI) { v["rekorPublicKeyPath"] = "/foo/rekor" },
+		// Both "fulcio" and "keyPath" is present
+		func(v mSI) { v["keyPath"] = "/foo/bar" },
+		// Invalid "fulcio" field
+		func(v mSI) { v["fulcio"] = 1 },
+		func(v mSI) { v["fulcio"] = mSI{"caPath": "/foo/ca"} },
+		func(v mSI) {
+			v["fulcio"] = mSI{"caPath": "/foo/ca", "oidcIssuer": "https://example.com", "subjectEmail": "user@example.com", "unknown": 1}
+		},
+		func(v mSI) {
+			v["fulcio"] = mSI{"oidcIssuer": "https://example.com", "subjectEmail": "user@example.com"}
+		},
+		func(v mSI) {
+			v["fulcio"] = mSI{"caPath": "", "oidcIssuer": "https://example.com", "subjectEmail": "user@example.com"}
+		},
+	}
+	for _, fn := range fulcioBreakFns {
+		var tmp mSI
+		err := json.Unmarshal(fulcioJSON, &tmp)
+		require.NoError(t, err)
+		fn(tmp)
+		testJSON, err := json.Marshal(tmp)
+		require.NoError(t, err)
+
+		pr = prSigstoreSigned{}
+		err = json.Unmarshal(testJSON, &pr)
+		assert.Error(t, err, string(testJSON))
+	}
+
+	// The signedIdentity field may be omitted
+	var tmp mSI
+	err = json.Unmarshal(validJSON, &tmp)
+	require.NoError(t, err)
+	delete(tmp, "signedIdentity")
+	testJSON, err = json.Marshal(tmp)
+	require.NoError(t, err)
+	pr = prSigstoreSigned{}
+	err = json.Unmarshal(testJSON, &pr)
+	require.NoError(t, err)
+	assert.Equal(t, validPR, &pr)
+}
+
+// xNewPRSigstoreAttestedKeyPath is like NewPRSigstoreAttestedKeyPath, except it must not fail.
+func xNewPRSigstoreAttestedKeyPath(keyPath string, predicateType string) PolicyRequirement {
+	pr, err := NewPRSigstoreAttestedKeyPath(keyPath, predicateType)
+	if err != nil {
+		panic("xNewPRSigstoreAttestedKeyPath failed")
+	}
+	return pr
+}
+
+func TestNewPRSigstoreAttested(t *testing.T) {
+	const testPath = "/foo/bar"
+	testData := []byte("abc")
+	const testPredicateType = "https://slsa.dev/provenance/v0.2"
+
+	// Success
+	pr, err := newPRSigstoreAttested(testPath, nil, testPredicateType)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreAttested{
+		prCommon:      prCommon{prTypeSigstoreAttested},
+		KeyPath:       testPath,
+		PredicateType: testPredicateType,
+	}, pr)
+	pr, err = newPRSigstoreAttested("", testData, testPredicateType)
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreAttested{
+		prCommon:      prCommon{prTypeSigstoreAttested},
+		KeyData:       testData,
+		PredicateType: testPredicateType,
+	}, pr)
+
+	// Invalid keyPath/keyData combinations
+	_, err = newPRSigstoreAttested("", nil, testPredicateType)
+	assert.Error(t, err)
+	_, err = newPRSigstoreAttested(testPath, testData, testPredicateType)
+	assert.Error(t, err)
+
+	// Missing predicateType
+	_, err = newPRSigstoreAttested(testPath, nil, "")
+	assert.Error(t, err)
+}
+
+func TestNewPRSigstoreAttestedKeyPath(t *testing.T) {
+	_pr, err := NewPRSigstoreAttestedKeyPath("/foo/bar", "https://slsa.dev/provenance/v0.2")
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSigstoreAttested)
+	require.True(t, ok)
+	assert.Equal(t, "/foo/bar", pr.KeyPath)
+	// Failure cases tested in TestNewPRSigstoreAttested.
+}
+
+func TestNewPRSigstoreAttestedKeyData(t *testing.T) {
+	_pr, err := NewPRSigstoreAttestedKeyData([]byte("abc"), "https://slsa.dev/provenance/v0.2")
+	require.NoError(t, err)
+	pr, ok := _pr.(*prSigstoreAttested)
+	require.True(t, ok)
+	assert.Equal(t, []byte("abc"), pr.KeyData)
+	// Failure cases tested in TestNewPRSigstoreAttested.
+}
+
+func TestPRSigstoreAttestedUnmarshalJSON(t *testing.T) {
+	var pr prSigstoreAttested
+
+	testInvalidJSONInput(t, &pr)
+
+	// Start with a valid JSON.
+	validPR, err := NewPRSigstoreAttestedKeyData([]byte("abc"), "https://slsa.dev/provenance/v0.2")
+	require.NoError(t, err)
+	validJSON, err := json.Marshal(validPR)
+	require.NoError(t, err)
+
+	// Success with KeyData
+	pr = prSigstoreAttested{}
+	err = json.Unmarshal(validJSON, &pr)
+	require.NoError(t, err)
+	assert.Equal(t, validPR, &pr)
+
+	// Success with KeyPath
+	kpPR, err := NewPRSigstoreAttestedKeyPath("/foo/bar", "https://slsa.dev/provenance/v0.2")
+	require.NoError(t, err)
+	testJSON, err := json.Marshal(kpPR)
+	require.NoError(t, err)
+	pr = prSigstoreAttested{}
+	err = json.Unmarshal(testJSON, &pr)
+	require.NoError(t, err)
+	assert.Equal(t, kpPR, &pr)
+
+	// newPolicyRequirementFromJSON recognizes this type
+	_pr, err := newPolicyRequirementFromJSON(validJSON)
+	require.NoError(t, err)
+	assert.Equal(t, validPR, _pr)
+
+	// Various ways to corrupt the JSON
+	breakFns := []func(mSI){
+		// The "type" field is missing
+		func(v mSI) { delete(v, "type") },
+		// Wrong "type" field
+		func(v mSI) { v["type"] = 1 },
+		func(v mSI) { v["type"] = "this is invalid" },
+		func(v mSI) { v["type"] = prTypeSigstoreSigned },
+		// Extra top-level sub-object
+		func(v mSI) { v["unexpected"] = 1 },
+		// Both "keyPath" and "keyData" is missing
+		func(v mSI) { delete(v, "keyData") },
+		// Both "keyPath" and "keyData" is present
+		func(v mSI) { v["keyPath"] = "/foo/bar" },
+		// Invalid or empty "keyPath" field
+		func(v mSI) { delete(v, "keyData"); v["keyPath"] = 1 },
+		func(v mSI) { delete(v, "keyData"); v["keyPath"] = "" },
+		// Invalid or empty "keyData" field
+		func(v mSI) { v["keyData"] = 1 },
+		func(v mSI) { v["keyData"] = "this is invalid base64" },
+		func(v mSI) { v["keyData"] = nil },
+		// Invalid or missing "predicateType" field
+		func(v mSI) { v["predicateType"] = 1 },
+		func(v mSI) { v["predicateType"] = "" },
+		func(v mSI) { delete(v, "predicateType") },
+	}
+	for _, fn := range breakFns {
+		var tmp mSI
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+		fn(tmp)
+		testJSON, err := json.Marshal(tmp)
+		require.NoError(t, err)
+
+		pr = prSigstoreAttested{}
+		err = json.Unmarshal(testJSON, &pr)
+		assert.Error(t, err, string(testJSON))
+	}
+
+	// Duplicated fields
+	for _, field := range []string{"type", "keyData", "predicateType"} {
+		var tmp mSI
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+
+		testJSON := addExtraJSONMember(t, validJSON, field, tmp[field])
+
+		pr = prSigstoreAttested{}
+		err = json.Unmarshal(testJSON, &pr)
+		assert.Error(t, err)
+	}
+}
+
+// xNewPRSignatureTime is like NewPRSignatureTime, except it must not fail.
+func xNewPRSignatureTime(maxAge time.Duration, notBefore, notAfter time.Time) PRSignatureTime {
+	st, err := NewPRSignatureTime(maxAge, notBefore, notAfter)
+	if err != nil {
+		panic("xNewPRSignatureTime failed")
+	}
+	return st
+}
+
+func TestNewPRSignatureTime(t *testing.T) {
+	notBefore := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Success
+	st, err := newPRSignatureTime("720h", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &prSignatureTime{MaxAge: "720h"}, st)
+	st, err = newPRSignatureTime("", &notBefore, &notAfter)
+	require.NoError(t, err)
+	assert.Equal(t, &prSignatureTime{NotBefore: &notBefore, NotAfter: &notAfter}, st)
+	st, err = newPRSignatureTime("1h30m", &notBefore, &notBefore)
+	require.NoError(t, err)
+	assert.Equal(t, &prSignatureTime{MaxAge: "1h30m", NotBefore: &notBefore, NotAfter: &notBefore}, st)
+
+	for _, c := range []struct {
+		maxAge              string
+		notBefore, notAfter *time.Time
+	}{
+		{"", nil, nil},                // Nothing specified
+		{"this is invalid", nil, nil}, // Invalid maxAge
+		{"1d", &notBefore, &notAfter}, // Invalid maxAge, with a valid window
+		{"1000000000h", nil, nil},     // maxAge out of range
+		{"0s", nil, nil},              // Zero maxAge
+		{"-1h", nil, nil},             // Negative maxAge
+		{"", &notAfter, &notBefore},   // notAfter before notBefore
+		{"1h", &notAfter, &notBefore}, // notAfter before notBefore, with maxAge
+	} {
+		_, err := newPRSignatureTime(c.maxAge, c.notBefore, c.notAfter)
+		assert.Error(t, err, fmt.Sprintf("%#v", c))
+	}
+
+	// NewPRSignatureTime converts zero values to unspecified constraints
+	_st, err := NewPRSignatureTime(30*time.Minute, notBefore, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, &prSignatureTime{MaxAge: "30m0s", NotBefore: &notBefore}, _st)
+	_, err = NewPRSignatureTime(0, time.Time{}, time.Time{})
+	assert.Error(t, err)
+	_, err = NewPRSignatureTime(-time.Hour, time.Time{}, time.Time{})
+	assert.Error(t, err)
+}
+
+func TestPRSignatureTimeUnmarshalJSON(t *testing.T) {
+	var st prSignatureTime
+
+	testInvalidJSONInput(t, &st)
+
+	// Start with a valid JSON.
+	validST := xNewPRSignatureTime(720*time.Hour, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	validJSON, err := json.Marshal(validST)
+	require.NoError(t, err)
+
+	// Success
+	st = prSignatureTime{}
+	err = json.Unmarshal(validJSON, &st)
+	require.NoError(t, err)
+	assert.Equal(t, validST, &st)
+
+	// Various ways to corrupt the JSON
+	breakFns := []func(mSI){
+		// All constraints are missing
+		func(v mSI) { delete(v, "maxAge"); delete(v, "notBefore"); delete(v, "notAfter") },
+		// Extra top-level sub-object
+		func(v mSI) { v["unexpected"] = 1 },
+		// Invalid "maxAge" field
+		func(v mSI) { v["maxAge"] = 1 },
+		func(v mSI) { v["maxAge"] = "" },
+		func(v mSI) { v["maxAge"] = "this is invalid" },
+		// Invalid "notBefore" field
+		func(v mSI) { v["notBefore"] = 1 },
+		func(v mSI) { v["notBefore"] = nil },
+		func(v mSI) { v["notBefore"] = "this is invalid" },
+		// Invalid "notAfter" field
+		func(v mSI) { v["notAfter"] = nil },
+		func(v mSI) { v["notAfter"] = "2015-01-01T00:00:00Z" },
+	}
+	for _, fn := range breakFns {
+		var tmp mSI
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+		fn(tmp)
+		testJSON, err := json.Marshal(tmp)
+		require.NoError(t, err)
+
+		st = prSignatureTime{}
+		err = json.Unmarshal(testJSON, &st)
+		assert.Error(t, err, string(testJSON))
+	}
+
+	// Duplicated fields
+	for _, field := range []string{"maxAge", "notBefore", "notAfter"} {
+		var tmp mSI
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+
+		testJSON := addExtraJSONMember(t, validJSON, field, tmp[field])
+
+		st = prSignatureTime{}
+		err = json.Unmarshal(testJSON, &st)
+		assert.Error(t, err)
+	}
+}
+
+func TestWithSignatureTime(t *testing.T) {
+	st := xNewPRSignatureTime(time.Hour, time.Time{}, time.Time{})
+
+	for _, pr := range []PolicyRequirement{
+		xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "/foo/bar", NewPRMMatchExact()),
+		xNewPRSigstoreSignedKeyPath("/foo/bar", NewPRMMatchExact()),
+	} {
+		res, err := WithSignatureTime(pr, st)
+		require.NoError(t, err)
+		switch res := res.(type) {
+		case *prSignedBy:
+			assert.Equal(t, st, res.SignatureTime)
+			assert.Nil(t, pr.(*prSignedBy).SignatureTime) // The original is not modified
+		case *prSigstoreSigned:
+			assert.Equal(t, st, res.SignatureTime)
+			assert.Nil(t, pr.(*prSigstoreSigned).SignatureTime) // The original is not modified
+		default:
+			t.Fatalf("Unexpected type %T", res)
+		}
+
+		_, err = WithSignatureTime(pr, nil)
+		assert.Error(t, err)
+	}
+
+	// Other requirement types are rejected
+	_, err := WithSignatureTime(NewPRInsecureAcceptAnything(), st)
+	assert.Error(t, err)
+}
+
 func TestSBKeyTypeIsValid(t *testing.T) {
 	// Valid values
 	for _, s := range []sbKeyType{
@@ -1290,3 +2036,121 @@ func TestPRMExactRepositoryUnmarshalJSON(t *testing.T) {
 		assert.Error(t, err)
 	}
 }
+
+func TestNewPRMRemapIdentity(t *testing.T) {
+	const testPrefix = "mirror.example.com/upstream"
+	const testSignedPrefix = "docker.io"
+
+	// Success
+	_prm, err := NewPRMRemapIdentity(testPrefix, testSignedPrefix)
+	require.NoError(t, err)
+	prm, ok := _prm.(*prmRemapIdentity)
+	require.True(t, ok)
+	assert.Equal(t, &prmRemapIdentity{
+		prmCommon:    prmCommon{prmTypeRemapIdentity},
+		Prefix:       testPrefix,
+		SignedPrefix: testSignedPrefix,
+	}, prm)
+	for _, prefix := range []string{
+		"localhost",
+		"localhost:5000",
+		"example.com:5000",
+		"docker.io/library",
+		"docker.io/library/busybox",
+		"example.com/ns/repo",
+	} {
+		_, err := NewPRMRemapIdentity(prefix, testSignedPrefix)
+		assert.NoError(t, err, prefix)
+		_, err = NewPRMRemapIdentity(testPrefix, prefix)
+		assert.NoError(t, err, prefix)
+	}
+
+	// Invalid prefix or signedPrefix
+	for _, prefix := range []string{
+		"",
+		"busybox",                 // Not a host name
+		"library/busybox",         // Not a host name
+		"example.com/ns/repo:tag", // Contains a tag
+		"example.com/ns/repo@" + TestImageManifestDigest.String(), // Contains a digest
+		"example.com/UPPERCASE",                                   // Invalid path component
+		"example.com/",                                            // Empty path component
+		"example.com//repo",                                       // Empty path component
+		"https://example.com/repo",                                // Not a reference
+	} {
+		_, err := NewPRMRemapIdentity(prefix, testSignedPrefix)
+		assert.Error(t, err, prefix)
+		_, err = NewPRMRemapIdentity(testPrefix, prefix)
+		assert.Error(t, err, prefix)
+	}
+}
+
+func TestPRMRemapIdentityUnmarshalJSON(t *testing.T) {
+	var prm prmRemapIdentity
+
+	testInvalidJSONInput(t, &prm)
+
+	// Start with a valid JSON.
+	validPRM, err := NewPRMRemapIdentity("mirror.example.com/upstream", "docker.io")
+	require.NoError(t, err)
+	validJSON, err := json.Marshal(validPRM)
+	require.NoError(t, err)
+
+	// Success
+	prm = prmRemapIdentity{}
+	err = json.Unmarshal(validJSON, &prm)
+	require.NoError(t, err)
+	assert.Equal(t, validPRM, &prm)
+
+	// newPolicyReferenceMatchFromJSON recognizes this type
+	_prm, err := newPolicyReferenceMatchFromJSON(validJSON)
+	require.NoError(t, err)
+	assert.Equal(t, validPRM, _prm)
+
+	// Various ways to corrupt the JSON
+	breakFns := []func(mSI){
+		// The "type" field is missing
+		func(v mSI) { delete(v, "type") },
+		// Wrong "type" field
+		func(v mSI) { v["type"] = 1 },
+		func(v mSI) { v["type"] = "this is invalid" },
+		// Extra top-level sub-object
+		func(v mSI) { v["unexpected"] = 1 },
+		// The "prefix" field is missing
+		func(v mSI) { delete(v, "prefix") },
+		// Invalid "prefix" field
+		func(v mSI) { v["prefix"] = 1 },
+		func(v mSI) { v["prefix"] = "this is invalid" },
+		// The "signedPrefix" field is missing
+		func(v mSI) { delete(v, "signedPrefix") },
+		// Invalid "signedPrefix" field
+		func(v mSI) { v["signedPrefix"] = 1 },
+		func(v mSI) { v["signedPrefix"] = "this is invalid" },
+	}
+	for _, fn := range breakFns {
+		var tmp mSI
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+
+		fn(tmp)
+
+		testJSON, err := json.Marshal(tmp)
+		require.NoError(t, err)
+
+		prm = prmRemapIdentity{}
+		err = json.Unmarshal(testJSON, &prm)
+		assert.Error(t, err)
+	}
+
+	// Duplicated fields
+	for _, field := range []string{"type", "prefix", "signedPrefix"} {
+		var tmp mSI
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+
+		testJSON := addExtraJSONMember(t, validJSON, field, tmp[field])
+
+		prm = prmRemapIdentity{}
+		err = json.Unmarshal(testJSON, &prm)
+		assert.Error(t, err)
+	}
+}
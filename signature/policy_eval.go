@@ -6,7 +6,11 @@
 package signature
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/containers/image/types"
@@ -31,10 +35,8 @@ const (
 // PolicyRequirement is a rule which must be satisfied by at least one of the signatures of an image.
 // The type is public, but its definition is private.
 type PolicyRequirement interface {
-	// FIXME: For speed, we should support creating per-context state (not stored in the PolicyRequirement), to cache
-	// costly initialization like creating temporary GPG home directories and reading files.
-	// Setup() (someState, error)
-	// Then, the operations below would be done on the someState object, not directly on a PolicyRequirement.
+	// Requirements with costly initialization, like creating temporary GPG home directories and reading files,
+	// should also implement preparablePolicyRequirement, so that the initialization is only done once per PolicyContext.
 
 	// isSignatureAuthorAccepted, given an image and a signature blob, returns:
 	// - sarAccepted if the signature has been verified against the appropriate public key
@@ -54,14 +56,36 @@ type PolicyRequirement interface {
 	//   a container based on this image; use IsRunningImageAllowed instead.
 	// - Just because a signature is accepted does not automatically mean the contents of the
 	//   signature are authorized to run code as root, or to affect system or cluster configuration.
-	isSignatureAuthorAccepted(image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error)
+	isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error)
 
 	// isRunningImageAllowed returns true if the requirement allows running an image.
 	// If it returns false, err must be non-nil, and should be an PolicyRequirementError if evaluation
 	// succeeded but the result was rejection.
 	// WARNING: This validates signatures and the manifest, but does not download or validate the
 	// layers. Users must validate that the layers match their expected digests.
-	isRunningImageAllowed(image types.UnparsedImage) (bool, error)
+	isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error)
+}
+
+// keyIdentityReportingRequirement is implemented by PolicyRequirements which can report the key which created a signature.
+type keyIdentityReportingRequirement interface {
+	// isSignatureAuthorAcceptedWithKeyIdentity is isSignatureAuthorAccepted, which also returns
+	// the identity (fingerprint) of the key which created the signature if it was accepted.
+	isSignatureAuthorAcceptedWithKeyIdentity(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, string, error)
+}
+
+// preparablePolicyRequirement is implemented by PolicyRequirements which can do costly initialization once
+// per PolicyContext, instead of for every evaluation.
+type preparablePolicyRequirement interface {
+	// prepare returns a preparedPolicyRequirement equivalent to the receiver.
+	prepare() (preparedPolicyRequirement, error)
+}
+
+// preparedPolicyRequirement is a PolicyRequirement which caches state for evaluating many images.
+// It must be safe for concurrent use.
+type preparedPolicyRequirement interface {
+	PolicyRequirement
+	// destroy releases the resources used by the prepared requirement; it must not be used afterwards.
+	destroy() error
 }
 
 // PolicyReferenceMatch specifies a set of image identities accepted in PolicyRequirement.
@@ -73,11 +97,34 @@ type PolicyReferenceMatch interface {
 	matchesDockerReference(image types.UnparsedImage, signatureDockerReference string) bool
 }
 
+// PRSigstoreSignedFulcio specifies which Fulcio-issued certificates are trusted by a "sigstoreSigned" PolicyRequirement.
+// The type is public, but its implementation is private.
+type PRSigstoreSignedFulcio interface {
+	// prepareTrustRoot creates a fulcioTrustRoot from the input data.
+	// (This also prevents external implementations of this interface :) )
+	prepareTrustRoot() (*fulcioTrustRoot, error)
+}
+
+// PRSignatureTime specifies which signature creation times are accepted by a PolicyRequirement.
+// The type is public, but its implementation is private.
+type PRSignatureTime interface {
+	// validateSignatureTime returns nil if a signature created at timestamp (zero if unknown) is acceptable at time now.
+	// (This also prevents external implementations of this interface :) )
+	validateSignatureTime(timestamp, now time.Time) error
+}
+
 // PolicyContext encapsulates a policy and possible cached state
 // for speeding up its evaluation.
+// A PolicyContext may be long-lived, and used concurrently by several goroutines.
 type PolicyContext struct {
 	Policy *Policy
-	state  policyContextState // Internal consistency checking
+
+	stateMutex sync.Mutex         // Protects state and users
+	state      policyContextState // Internal consistency checking
+	users      int                // The number of concurrent evaluations, if state == pcInUse
+
+	preparedMutex sync.Mutex                                      // Protects prepared
+	prepared      map[PolicyRequirement]preparedPolicyRequirement // Prepared equivalents of requirements in Policy
 }
 
 // policyContextState is used internally to verify the users are not misusing a PolicyContext.
@@ -94,6 +141,8 @@ const (
 
 // changeContextState changes pc.state, or fails if the state is unexpected
 func (pc *PolicyContext) changeState(expected, new policyContextState) error {
+	pc.stateMutex.Lock()
+	defer pc.stateMutex.Unlock()
 	if pc.state != expected {
 		return fmt.Errorf(`"Invalid PolicyContext state, expected "%s", found "%s"`, expected, pc.state)
 	}
@@ -101,12 +150,40 @@ func (pc *PolicyContext) changeState(expected, new policyContextState) error {
 	return nil
 }
 
+// startUsing marks pc as used by one more evaluation, or fails if pc can not be used.
+// Every successful call must be paired with a call to stopUsing.
+func (pc *PolicyContext) startUsing() error {
+	pc.stateMutex.Lock()
+	defer pc.stateMutex.Unlock()
+	if pc.state != pcReady && pc.state != pcInUse {
+		return fmt.Errorf(`"Invalid PolicyContext state, expected "%s" or "%s", found "%s"`, pcReady, pcInUse, pc.state)
+	}
+	pc.state = pcInUse
+	pc.users++
+	return nil
+}
+
+// stopUsing marks the end of an evaluation started by startUsing.
+func (pc *PolicyContext) stopUsing() error {
+	pc.stateMutex.Lock()
+	defer pc.stateMutex.Unlock()
+	if pc.state != pcInUse || pc.users == 0 {
+		return fmt.Errorf(`"Invalid PolicyContext state, expected "%s", found "%s" with %d users`, pcInUse, pc.state, pc.users)
+	}
+	pc.users--
+	if pc.users == 0 {
+		pc.state = pcReady
+	}
+	return nil
+}
+
 // NewPolicyContext sets up and initializes a context for the specified policy.
 // The policy must not be modified while the context exists. FIXME: make a deep copy?
 // If this function succeeds, the caller should call PolicyContext.Destroy() when done.
 func NewPolicyContext(policy *Policy) (*PolicyContext, error) {
-	pc := &PolicyContext{Policy: policy, state: pcInitializing}
-	// FIXME: initialize
+	pc := &PolicyContext{Policy: policy, state: pcInitializing, prepared: map[PolicyRequirement]preparedPolicyRequirement{}}
+	// Requirements are prepared lazily by requirementForEvaluation, so that a policy with many scopes does not
+	// read keys which are never used.
 	if err := pc.changeState(pcInitializing, pcReady); err != nil {
 		// Huh?! This should never fail, we didn't give the pointer to anybody.
 		// Just give up and leave unclean state around.
@@ -120,8 +197,40 @@ func (pc *PolicyContext) Destroy() error {
 	if err := pc.changeState(pcReady, pcDestroying); err != nil {
 		return err
 	}
-	// FIXME: destroy
-	return pc.changeState(pcDestroying, pcDestroyed)
+	pc.preparedMutex.Lock()
+	var destroyErr error
+	for req, prepared := range pc.prepared {
+		if err := prepared.destroy(); err != nil && destroyErr == nil {
+			destroyErr = err
+		}
+		delete(pc.prepared, req)
+	}
+	pc.preparedMutex.Unlock()
+	if err := pc.changeState(pcDestroying, pcDestroyed); err != nil {
+		return err
+	}
+	return destroyErr
+}
+
+// requirementForEvaluation returns req, or, if req implements preparablePolicyRequirement, its prepared equivalent cached in pc.
+func (pc *PolicyContext) requirementForEvaluation(req PolicyRequirement) PolicyRequirement {
+	preparable, ok := req.(preparablePolicyRequirement)
+	if !ok {
+		return req
+	}
+	pc.preparedMutex.Lock()
+	defer pc.preparedMutex.Unlock()
+	if prepared, ok := pc.prepared[req]; ok {
+		return prepared
+	}
+	prepared, err := preparable.prepare()
+	if err != nil {
+		// Do not cache the failure (e.g. a key file which does not exist yet); evaluating req reports it.
+		logrus.Debugf("Error preparing a policy requirement: %v", err)
+		return req
+	}
+	pc.prepared[req] = prepared
+	return prepared
 }
 
 // policyIdentityLogName returns a string description of the image identity for policy purposes.
@@ -132,6 +241,13 @@ func policyIdentityLogName(ref types.ImageReference) string {
 
 // requirementsForImageRef selects the appropriate requirements for ref.
 func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) PolicyRequirements {
+	reqs, _, _ := pc.requirementsAndScopeForImageRef(ref)
+	return reqs
+}
+
+// requirementsAndScopeForImageRef selects the appropriate requirements for ref, and returns them along with
+// the transport name and scope of the policy section they come from (both "" for the default policy section).
+func (pc *PolicyContext) requirementsAndScopeForImageRef(ref types.ImageReference) (PolicyRequirements, string, string) {
 	// Do we have a PolicyTransportScopes for this transport?
 	transportName := ref.Transport().Name()
 	if transportScopes, ok := pc.Policy.Transports[transportName]; ok {
@@ -139,26 +255,60 @@ func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) Polic
 		identity := ref.PolicyConfigurationIdentity()
 		if req, ok := transportScopes[identity]; ok {
 			logrus.Debugf(` Using transport "%s" policy section %s`, transportName, identity)
-			return req
+			return req, transportName, identity
 		}
 
 		// Look for a match of the possible parent namespaces.
 		for _, name := range ref.PolicyConfigurationNamespaces() {
 			if req, ok := transportScopes[name]; ok {
 				logrus.Debugf(` Using transport "%s" specific policy section %s`, transportName, name)
-				return req
+				return req, transportName, name
 			}
 		}
 
 		// Look for a default match for the transport.
 		if req, ok := transportScopes[""]; ok {
 			logrus.Debugf(` Using transport "%s" policy section ""`, transportName)
-			return req
+			return req, transportName, ""
 		}
 	}
 
 	logrus.Debugf(" Using default policy section")
-	return pc.Policy.Default
+	return pc.Policy.Default, "", ""
+}
+
+// SignatureVerificationResult describes the evaluation of a single signature of an image, as returned by VerifySignatures.
+type SignatureVerificationResult struct {
+	// Accepted is true if the policy accepts the author of the signature, and the signature has been successfully verified.
+	Accepted bool
+	// Signature contains the verified contents of an accepted signature: the manifest digest,
+	// the signed identity and the creation time; nil if the signature was not accepted.
+	Signature *Signature
+	// KeyFingerprint is the fingerprint of the key which created an accepted signature, or "" if not known.
+	KeyFingerprint string
+	// AcceptedBy contains the policy requirements which accepted the signature; nil if the signature was not accepted.
+	AcceptedBy []PolicyRequirement
+	// Err is the reason why the signature was not accepted, or nil if it was.
+	Err error
+}
+
+// VerifySignatures returns the result of evaluating each of the signatures of an image, in the same order,
+// e.g. for recording in audit logs.
+// WARNING: The same warnings as for GetSignaturesWithAcceptedAuthor apply: use IsRunningImageAllowed
+// to determine whether to run a container based on this image.
+func (pc *PolicyContext) VerifySignatures(ctx context.Context, image types.UnparsedImage) (results []SignatureVerificationResult, finalErr error) {
+	if err := pc.startUsing(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := pc.stopUsing(); err != nil {
+			results = nil
+			finalErr = err
+		}
+	}()
+
+	logrus.Debugf("VerifySignatures for image %s", policyIdentityLogName(image.Reference()))
+	return pc.verifySignatures(ctx, image)
 }
 
 // GetSignaturesWithAcceptedAuthor returns those signatures from an image
@@ -174,77 +324,178 @@ func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) Polic
 //   a container based on this image; use IsRunningImageAllowed instead.
 // - Just because a signature is accepted does not automatically mean the contents of the
 //   signature are authorized to run code as root, or to affect system or cluster configuration.
-func (pc *PolicyContext) GetSignaturesWithAcceptedAuthor(image types.UnparsedImage) (sigs []*Signature, finalErr error) {
-	if err := pc.changeState(pcReady, pcInUse); err != nil {
+func (pc *PolicyContext) GetSignaturesWithAcceptedAuthor(ctx context.Context, image types.UnparsedImage) (sigs []*Signature, finalErr error) {
+	if err := pc.startUsing(); err != nil {
 		return nil, err
 	}
 	defer func() {
-		if err := pc.changeState(pcInUse, pcReady); err != nil {
+		if err := pc.stopUsing(); err != nil {
 			sigs = nil
 			finalErr = err
 		}
 	}()
 
 	logrus.Debugf("GetSignaturesWithAcceptedAuthor for image %s", policyIdentityLogName(image.Reference()))
+	results, err := pc.verifySignatures(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Signature, 0, len(results))
+	for _, r := range results {
+		if r.Accepted {
+			res = append(res, r.Signature)
+		}
+	}
+	return res, nil
+}
+
+// verifySignatures implements VerifySignatures and GetSignaturesWithAcceptedAuthor; the caller must call pc.startUsing.
+func (pc *PolicyContext) verifySignatures(ctx context.Context, image types.UnparsedImage) ([]SignatureVerificationResult, error) {
 	reqs := pc.requirementsForImageRef(image.Reference())
 
 	// FIXME: rename Signatures to UnverifiedSignatures
-	unverifiedSignatures, err := image.Signatures()
+	unverifiedSignatures, err := image.Signatures(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	res := make([]*Signature, 0, len(unverifiedSignatures))
+	res := make([]SignatureVerificationResult, 0, len(unverifiedSignatures))
 	for sigNumber, sig := range unverifiedSignatures {
-		var acceptedSig *Signature // non-nil if accepted
-		rejected := false
 		// FIXME? Say more about the contents of the signature, i.e. parse it even before verification?!
 		logrus.Debugf("Evaluating signature %d:", sigNumber)
-	interpretingReqs:
-		for reqNumber, req := range reqs {
-			// FIXME: Log the requirement itself? For now, we use just the number.
-			// FIXME: supply state
-			switch res, as, err := req.isSignatureAuthorAccepted(image, sig); res {
-			case sarAccepted:
-				if as == nil { // Coverage: this should never happen
-					logrus.Debugf(" Requirement %d: internal inconsistency: sarAccepted but no parsed contents", reqNumber)
-					rejected = true
-					break interpretingReqs
-				}
-				logrus.Debugf(" Requirement %d: signature accepted", reqNumber)
-				if acceptedSig == nil {
-					acceptedSig = as
-				} else if *as != *acceptedSig { // Coverage: this should never happen
-					// Huh?! Two ways of verifying the same signature blob resulted in two different parses of its already accepted contents?
-					logrus.Debugf(" Requirement %d: internal inconsistency: sarAccepted but different parsed contents", reqNumber)
-					rejected = true
-					acceptedSig = nil
-					break interpretingReqs
-				}
-			case sarRejected:
-				logrus.Debugf(" Requirement %d: signature rejected: %s", reqNumber, err.Error())
-				rejected = true
-				break interpretingReqs
-			case sarUnknown:
-				if err != nil { // Coverage: this should never happen
-					logrus.Debugf(" Requirement %d: internal inconsistency: sarUnknown but an error message %s", reqNumber, err.Error())
-					rejected = true
-					break interpretingReqs
-				}
-				logrus.Debugf(" Requirement %d: signature state unknown, continuing", reqNumber)
-			default: // Coverage: this should never happen
-				logrus.Debugf(" Requirement %d: internal inconsistency: unknown result %#v", reqNumber, string(res))
-				rejected = true
-				break interpretingReqs
-			}
-		}
-		// This also handles the (invalid) case of empty reqs, by rejecting the signature.
-		if acceptedSig != nil && !rejected {
+		r := pc.verifySignature(ctx, reqs, image, sig)
+		if r.Accepted {
 			logrus.Debugf(" Overall: OK, signature accepted")
-			res = append(res, acceptedSig)
 		} else {
 			logrus.Debugf(" Overall: Signature not accepted")
 		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// verifySignature evaluates sig of image against reqs.
+func (pc *PolicyContext) verifySignature(ctx context.Context, reqs PolicyRequirements, image types.UnparsedImage, sig []byte) SignatureVerificationResult {
+	var acceptedSig *Signature // non-nil if accepted
+	keyFingerprint := ""
+	var acceptedBy []PolicyRequirement
+	for reqNumber, req := range reqs {
+		// FIXME: Log the requirement itself? For now, we use just the number.
+		var res signatureAcceptanceResult
+		var as *Signature
+		var keyIdentity string
+		var err error
+		switch r := pc.requirementForEvaluation(req).(type) {
+		case keyIdentityReportingRequirement:
+			res, as, keyIdentity, err = r.isSignatureAuthorAcceptedWithKeyIdentity(ctx, image, sig)
+		default:
+			res, as, err = r.isSignatureAuthorAccepted(ctx, image, sig)
+		}
+		switch res {
+		case sarAccepted:
+			if as == nil { // Coverage: this should never happen
+				logrus.Debugf(" Requirement %d: internal inconsistency: sarAccepted but no parsed contents", reqNumber)
+				return SignatureVerificationResult{Err: errors.New("Internal inconsistency: signature accepted but no parsed contents")}
+			}
+			logrus.Debugf(" Requirement %d: signature accepted", reqNumber)
+			if acceptedSig == nil {
+				acceptedSig = as
+			} else if *as != *acceptedSig { // Coverage: this should never happen
+				// Huh?! Two ways of verifying the same signature blob resulted in two different parses of its already accepted contents?
+				logrus.Debugf(" Requirement %d: internal inconsistency: sarAccepted but different parsed contents", reqNumber)
+				return SignatureVerificationResult{Err: errors.New("Internal inconsistency: signature accepted with different parsed contents")}
+			}
+			if keyFingerprint == "" {
+				keyFingerprint = keyIdentity
+			}
+			acceptedBy = append(acceptedBy, req)
+		case sarRejected:
+			logrus.Debugf(" Requirement %d: signature rejected: %s", reqNumber, err.Error())
+			return SignatureVerificationResult{Err: err}
+		case sarUnknown:
+			if err != nil { // Coverage: this should never happen
+				logrus.Debugf(" Requirement %d: internal inconsistency: sarUnknown but an error message %s", reqNumber, err.Error())
+				return SignatureVerificationResult{Err: fmt.Errorf("Internal inconsistency: signature state unknown but an error message %v", err)}
+			}
+			logrus.Debugf(" Requirement %d: signature state unknown, continuing", reqNumber)
+		default: // Coverage: this should never happen
+			logrus.Debugf(" Requirement %d: internal inconsistency: unknown result %#v", reqNumber, string(res))
+			return SignatureVerificationResult{Err: fmt.Errorf("Internal inconsistency: unknown signature verification result %#v", string(res))}
+		}
+	}
+	// This also handles the (invalid) case of empty reqs, by rejecting the signature.
+	if acceptedSig == nil {
+		return SignatureVerificationResult{Err: PolicyRequirementError("No policy requirement accepted the signature")}
+	}
+	return SignatureVerificationResult{
+		Accepted:       true,
+		Signature:      acceptedSig,
+		KeyFingerprint: keyFingerprint,
+		AcceptedBy:     acceptedBy,
+	}
+}
+
+// RequirementEvaluation is the result of evaluating a single policy requirement for an image.
+type RequirementEvaluation struct {
+	Requirement PolicyRequirement
+	// Allowed is true if the requirement allows running the image.
+	Allowed bool
+	// Err is the reason why the requirement does not allow running the image, or nil if it does.
+	// It is a PolicyRequirementError if evaluation succeeded but the result was rejection.
+	Err error
+}
+
+// PolicyExplanation describes how the policy applies to an image, as returned by ExplainRunningImage.
+type PolicyExplanation struct {
+	// Transport and Scope identify the policy section used for the image: a transport name and a scope within Policy.Transports
+	// (where Scope may be "" for the transport's default section), or both "" if Policy.Default was used.
+	Transport string
+	Scope     string
+	// Allowed is true iff IsRunningImageAllowed would allow running the image, i.e. if all of the requirements
+	// allow running it and the list of requirements is not empty.
+	Allowed bool
+	// Requirements contains the result of evaluating each of the requirements of the policy section, in order.
+	Requirements []RequirementEvaluation
+}
+
+// ExplainRunningImage evaluates whether the policy allows running the image, like IsRunningImageAllowed, but it evaluates
+// all of the applicable requirements instead of stopping at the first rejection, and returns a description of the result
+// for each of them.  This is intended to help users understand why an image is rejected, e.g. before starting to enforce a policy.
+// An error is only returned if the evaluation itself could not be performed; a rejection is reported in the returned PolicyExplanation.
+// WARNING: Do not use this to decide whether to run an image; use IsRunningImageAllowed instead.
+func (pc *PolicyContext) ExplainRunningImage(ctx context.Context, image types.UnparsedImage) (res *PolicyExplanation, finalErr error) {
+	if err := pc.startUsing(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := pc.stopUsing(); err != nil {
+			res = nil
+			finalErr = err
+		}
+	}()
+
+	logrus.Debugf("ExplainRunningImage for image %s", policyIdentityLogName(image.Reference()))
+	reqs, transport, scope := pc.requirementsAndScopeForImageRef(image.Reference())
+
+	res = &PolicyExplanation{
+		Transport:    transport,
+		Scope:        scope,
+		Allowed:      len(reqs) != 0,
+		Requirements: make([]RequirementEvaluation, 0, len(reqs)),
+	}
+	for reqNumber, req := range reqs {
+		allowed, err := pc.requirementForEvaluation(req).isRunningImageAllowed(ctx, image)
+		if allowed {
+			logrus.Debugf(" Requirement %d: allowed", reqNumber)
+			err = nil
+		} else {
+			logrus.Debugf(" Requirement %d: denied", reqNumber)
+			if err == nil { // Coverage: this should never happen
+				err = errors.New("Internal inconsistency: requirement denied without a reason")
+			}
+			res.Allowed = false
+		}
+		res.Requirements = append(res.Requirements, RequirementEvaluation{Requirement: req, Allowed: allowed, Err: err})
 	}
 	return res, nil
 }
@@ -254,12 +505,12 @@ func (pc *PolicyContext) GetSignaturesWithAcceptedAuthor(image types.UnparsedIma
 // succeeded but the result was rejection.
 // WARNING: This validates signatures and the manifest, but does not download or validate the
 // layers. Users must validate that the layers match their expected digests.
-func (pc *PolicyContext) IsRunningImageAllowed(image types.UnparsedImage) (res bool, finalErr error) {
-	if err := pc.changeState(pcReady, pcInUse); err != nil {
+func (pc *PolicyContext) IsRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (res bool, finalErr error) {
+	if err := pc.startUsing(); err != nil {
 		return false, err
 	}
 	defer func() {
-		if err := pc.changeState(pcInUse, pcReady); err != nil {
+		if err := pc.stopUsing(); err != nil {
 			res = false
 			finalErr = err
 		}
@@ -273,8 +524,7 @@ func (pc *PolicyContext) IsRunningImageAllowed(image types.UnparsedImage) (res b
 	}
 
 	for reqNumber, req := range reqs {
-		// FIXME: supply state
-		allowed, err := req.isRunningImageAllowed(image)
+		allowed, err := pc.requirementForEvaluation(req).isRunningImageAllowed(ctx, image)
 		if !allowed {
 			logrus.Debugf("Requirement %d: denied, done", reqNumber)
 			return false, err
@@ -0,0 +1,127 @@
+// Policy evaluation for prSigstoreAttested.
+
+package signature
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+func (pr *prSigstoreAttested) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	// sig is a simple signing signature; attestations are stored separately and evaluated by isRunningImageAllowed.
+	return sarUnknown, nil, nil
+}
+
+// preparedSigstoreAttested is a prSigstoreAttested with its public keys already parsed,
+// so that it can be used to evaluate many images, possibly concurrently.
+type preparedSigstoreAttested struct {
+	pr         *prSigstoreAttested
+	publicKeys []crypto.PublicKey // Never modified, so safe for concurrent use
+}
+
+// prepare implements preparablePolicyRequirement.prepare.
+func (pr *prSigstoreAttested) prepare() (preparedPolicyRequirement, error) {
+	publicKeys, err := pr.preparePublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	return &preparedSigstoreAttested{pr: pr, publicKeys: publicKeys}, nil
+}
+
+// destroy implements preparedPolicyRequirement.destroy.
+func (p *preparedSigstoreAttested) destroy() error {
+	return nil // The keys are only kept in memory.
+}
+
+// preparePublicKeys returns the public keys trusted by pr.
+func (pr *prSigstoreAttested) preparePublicKeys() ([]crypto.PublicKey, error) {
+	keyData, err := loadBytesFromDataOrPath("key", pr.KeyData, pr.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseSigstorePublicKeys(keyData)
+}
+
+// isAttestationAccepted returns nil if att is an attestation of pr.PredicateType about manifestBlob, signed by one of publicKeys.
+func (pr *prSigstoreAttested) isAttestationAccepted(manifestBlob []byte, publicKeys []crypto.PublicKey, att types.Attestation) error {
+	statement, err := verifyAndExtractAttestation(publicKeys, att)
+	if err != nil {
+		return err
+	}
+	if statement.PredicateType != pr.PredicateType {
+		return PolicyRequirementError(fmt.Sprintf("Attestation of type %s is not accepted", statement.PredicateType))
+	}
+	for _, subject := range statement.Subject {
+		for algorithm, value := range subject.Digest {
+			d := digest.NewDigestFromEncoded(digest.Algorithm(algorithm), value)
+			if d.Validate() != nil {
+				continue // An algorithm we don't support, or an invalid value; another entry may still match.
+			}
+			matches, err := manifest.MatchesDigest(manifestBlob, d)
+			if err != nil {
+				return err
+			}
+			if matches {
+				return nil
+			}
+		}
+	}
+	return PolicyRequirementError("Attestation is not about this image")
+}
+
+func (pr *prSigstoreAttested) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	publicKeys, err := pr.preparePublicKeys()
+	if err != nil {
+		return false, err
+	}
+	p := &preparedSigstoreAttested{pr: pr, publicKeys: publicKeys}
+	return p.isRunningImageAllowed(ctx, image)
+}
+
+func (p *preparedSigstoreAttested) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	return p.pr.isSignatureAuthorAccepted(ctx, image, sig)
+}
+
+func (p *preparedSigstoreAttested) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	attestationsImage, ok := image.(types.AttestationsImage)
+	if !ok {
+		return false, PolicyRequirementError("An attestation was required, but the image does not support attestations")
+	}
+	atts, err := attestationsImage.Attestations(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(atts) == 0 {
+		return false, PolicyRequirementError(fmt.Sprintf("An attestation of type %s was required, but no attestation exists", p.pr.PredicateType))
+	}
+
+	manifestBlob, _, err := image.Manifest(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var rejections []error
+	for _, att := range atts {
+		err := p.pr.isAttestationAccepted(manifestBlob, p.publicKeys, att)
+		if err == nil {
+			// One accepted attestation is enough.
+			return true, nil
+		}
+		rejections = append(rejections, err)
+	}
+	if len(rejections) == 1 {
+		return false, rejections[0]
+	}
+	var msgs []string
+	for _, e := range rejections {
+		msgs = append(msgs, e.Error())
+	}
+	return false, PolicyRequirementError(fmt.Sprintf("None of the attestations were accepted, reasons: %s",
+		strings.Join(msgs, "; ")))
+}
@@ -0,0 +1,182 @@
+package signature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// attestationsImageSourceMock inherits dirImageSource, but overrides its Reference method and provides attestations.
+type attestationsImageSourceMock struct {
+	dirImageSourceMock
+	atts []types.Attestation
+}
+
+func (s *attestationsImageSourceMock) GetAttestations(ctx context.Context) ([]types.Attestation, error) {
+	return s.atts, nil
+}
+
+// attestationsImageMock returns an *image.UnparsedImage for a directory, claiming dockerReference and having atts.
+// The caller must call .Close() on the returned UnparsedImage.
+func attestationsImageMock(t *testing.T, dir, dockerReference string, atts []types.Attestation) *image.UnparsedImage {
+	ref, err := reference.ParseNamed(dockerReference)
+	require.NoError(t, err)
+	srcRef, err := directory.NewReference(dir)
+	require.NoError(t, err)
+	src, err := srcRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	return image.UnparsedFromSource(&attestationsImageSourceMock{
+		dirImageSourceMock: dirImageSourceMock{ImageSource: src, ref: refImageReferenceMock{ref}},
+		atts:               atts,
+	})
+}
+
+func TestPRSigstoreAttestedIsSignatureAuthorAccepted(t *testing.T) {
+	pr, err := NewPRSigstoreAttestedKeyData([]byte("abc"), "https://slsa.dev/provenance/v0.2")
+	require.NoError(t, err)
+	img := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	defer img.Close()
+	sig, err := ioutil.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), img, sig)
+	assertSARUnknown(t, sar, parsedSig, err)
+}
+
+func TestPRSigstoreAttestedIsRunningImageAllowed(t *testing.T) {
+	const provenance = "https://slsa.dev/provenance/v0.2"
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyData := sigstoreTestPublicKeyPEM(t, key)
+
+	manifestBlob, err := ioutil.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+	const dir = "fixtures/dir-img-valid"
+	const ref = "testing/manifest:latest"
+
+	validAtt := attestationTestEnvelope(t, key, attestationTestStatement(provenance, manifestDigest.Hex()))
+
+	// A valid attestation, using keyData
+	pr, err := NewPRSigstoreAttestedKeyData(keyData, provenance)
+	require.NoError(t, err)
+	img := attestationsImageMock(t, dir, ref, []types.Attestation{validAtt})
+	defer img.Close()
+	allowed, err := pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// A valid attestation, using keyPath
+	tmpDir, err := ioutil.TempDir("", "attestation-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	keyPath := filepath.Join(tmpDir, "cosign.pub")
+	err = ioutil.WriteFile(keyPath, keyData, 0644)
+	require.NoError(t, err)
+	pr, err = NewPRSigstoreAttestedKeyPath(keyPath, provenance)
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// A valid attestation among invalid ones
+	img = attestationsImageMock(t, dir, ref, []types.Attestation{
+		attestationTestEnvelope(t, otherKey, attestationTestStatement(provenance, manifestDigest.Hex())),
+		validAtt,
+	})
+	defer img.Close()
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// Missing key file
+	pr, err = NewPRSigstoreAttestedKeyPath(filepath.Join(tmpDir, "this/does/not/exist"), provenance)
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningRejected(t, allowed, err)
+
+	// Invalid key data
+	pr, err = NewPRSigstoreAttestedKeyData([]byte("this is not a key"), provenance)
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningRejected(t, allowed, err)
+
+	pr, err = NewPRSigstoreAttestedKeyData(keyData, provenance)
+	require.NoError(t, err)
+	for _, c := range []struct {
+		name string
+		atts []types.Attestation
+	}{
+		{"no attestations", []types.Attestation{}},
+		{"untrusted key", []types.Attestation{attestationTestEnvelope(t, otherKey, attestationTestStatement(provenance, manifestDigest.Hex()))}},
+		{"different predicate type", []types.Attestation{attestationTestEnvelope(t, key, attestationTestStatement("https://spdx.dev/Document", manifestDigest.Hex()))}},
+		{"different image", []types.Attestation{attestationTestEnvelope(t, key, attestationTestStatement(provenance, digest.FromString("another image").Hex()))}},
+		{"invalid digest", []types.Attestation{attestationTestEnvelope(t, key, attestationTestStatement(provenance, "this is not a digest"))}},
+		{"several invalid attestations", []types.Attestation{
+			attestationTestEnvelope(t, otherKey, attestationTestStatement(provenance, manifestDigest.Hex())),
+			attestationTestEnvelope(t, key, attestationTestStatement("https://spdx.dev/Document", manifestDigest.Hex())),
+		}},
+	} {
+		img := attestationsImageMock(t, dir, ref, c.atts)
+		defer img.Close()
+		allowed, err := pr.isRunningImageAllowed(context.Background(), img)
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+
+	// Images which do not support attestations at all
+	allowed, err = pr.isRunningImageAllowed(context.Background(), refImageMock{nil})
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+}
+
+func TestPRSigstoreAttestedPrepare(t *testing.T) {
+	const provenance = "https://slsa.dev/provenance/v0.2"
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	manifestBlob, err := ioutil.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+
+	tmpDir, err := ioutil.TempDir("", "attestation-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	keyPath := filepath.Join(tmpDir, "cosign.pub")
+	err = ioutil.WriteFile(keyPath, sigstoreTestPublicKeyPEM(t, key), 0644)
+	require.NoError(t, err)
+	pr, err := NewPRSigstoreAttestedKeyPath(keyPath, provenance)
+	require.NoError(t, err)
+
+	pc, err := NewPolicyContext(&Policy{Default: PolicyRequirements{pr}})
+	require.NoError(t, err)
+	defer pc.Destroy()
+	prepared, ok := pc.requirementForEvaluation(pr).(*preparedSigstoreAttested)
+	require.True(t, ok)
+	assert.Same(t, prepared, pc.requirementForEvaluation(pr))
+
+	// The prepared requirement does not read the key file again.
+	require.NoError(t, os.Remove(keyPath))
+	img := attestationsImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest",
+		[]types.Attestation{attestationTestEnvelope(t, key, attestationTestStatement(provenance, manifestDigest.Hex()))})
+	defer img.Close()
+	allowed, err := prepared.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+	sar, parsedSig, err := prepared.isSignatureAuthorAccepted(context.Background(), img, []byte("unused"))
+	assertSARUnknown(t, sar, parsedSig, err)
+
+	// Failures to prepare are reported.
+	_, err = pr.(preparablePolicyRequirement).prepare()
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package signature
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -10,7 +11,7 @@ func TestPRSignedBaseLayerIsSignatureAuthorAccepted(t *testing.T) {
 	pr, err := NewPRSignedBaseLayer(NewPRMMatchRepository())
 	require.NoError(t, err)
 	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
-	sar, parsedSig, err := pr.isSignatureAuthorAccepted(nil, nil)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
 	assertSARUnknown(t, sar, parsedSig, err)
 }
 
@@ -19,6 +20,6 @@ func TestPRSignedBaseLayerIsRunningImageAllowed(t *testing.T) {
 	pr, err := NewPRSignedBaseLayer(NewPRMMatchRepository())
 	require.NoError(t, err)
 	// Pass a nil pointer to, kind of, test that the return value does not depend on the image.
-	res, err := pr.isRunningImageAllowed(nil)
+	res, err := pr.isRunningImageAllowed(context.Background(), nil)
 	assertRunningRejectedPolicyRequirement(t, res, err)
 }
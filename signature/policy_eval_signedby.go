@@ -3,65 +3,156 @@
 package signature
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/containers/image/manifest"
 	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
 )
 
-func (pr *prSignedBy) isSignatureAuthorAccepted(image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+// preparedSignedBy is a prSignedBy with its trusted keys already imported into a signing mechanism,
+// so that it can be used to evaluate many images, possibly concurrently.
+type preparedSignedBy struct {
+	pr                *prSignedBy
+	dir               string           // The temporary GPG home directory used by mech
+	mech              SigningMechanism // Safe for concurrent use
+	trustedIdentities []string
+}
+
+// prepare implements preparablePolicyRequirement.prepare.
+func (pr *prSignedBy) prepare() (preparedPolicyRequirement, error) {
+	return pr.prepareSignedBy()
+}
+
+// prepareSignedBy reads and imports the trusted keys of pr.
+// The caller must call destroy on the returned preparedSignedBy when done.
+func (pr *prSignedBy) prepareSignedBy() (*preparedSignedBy, error) {
 	switch pr.KeyType {
 	case SBKeyTypeGPGKeys:
 	case SBKeyTypeSignedByGPGKeys, SBKeyTypeX509Certificates, SBKeyTypeSignedByX509CAs:
 		// FIXME? Reject this at policy parsing time already?
-		return sarRejected, nil, fmt.Errorf(`"Unimplemented "keyType" value "%s"`, string(pr.KeyType))
+		return nil, fmt.Errorf(`"Unimplemented "keyType" value "%s"`, string(pr.KeyType))
 	default:
 		// This should never happen, newPRSignedBy ensures KeyType.IsValid()
-		return sarRejected, nil, fmt.Errorf(`"Unknown "keyType" value "%s"`, string(pr.KeyType))
+		return nil, fmt.Errorf(`"Unknown "keyType" value "%s"`, string(pr.KeyType))
 	}
 
-	if pr.KeyPath != "" && pr.KeyData != nil {
-		return sarRejected, nil, errors.New(`Internal inconsistency: both "keyPath" and "keyData" specified`)
+	keySources := 0
+	var keyPaths []string
+	var keyDatas [][]byte
+	if pr.KeyPath != "" {
+		keySources++
+		keyPaths = []string{pr.KeyPath}
+	}
+	if pr.KeyPaths != nil {
+		keySources++
+		keyPaths = pr.KeyPaths
 	}
-	// FIXME: move this to per-context initialization
-	var data []byte
 	if pr.KeyData != nil {
-		data = pr.KeyData
-	} else {
-		d, err := ioutil.ReadFile(pr.KeyPath)
+		keySources++
+		keyDatas = [][]byte{pr.KeyData}
+	}
+	if pr.KeyDatas != nil {
+		keySources++
+		keyDatas = pr.KeyDatas
+	}
+	if keySources != 1 {
+		return nil, errors.New(`Internal inconsistency: not exactly one of "keyPath", "keyPaths", "keyData" and "keyDatas" specified`)
+	}
+	for _, keyPath := range keyPaths {
+		d, err := ioutil.ReadFile(keyPath)
 		if err != nil {
-			return sarRejected, nil, err
+			return nil, err
 		}
-		data = d
+		keyDatas = append(keyDatas, d)
 	}
 
-	// FIXME: move this to per-context initialization
 	dir, err := ioutil.TempDir("", "skopeo-signedBy-")
 	if err != nil {
-		return sarRejected, nil, err
+		return nil, err
 	}
-	defer os.RemoveAll(dir)
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			os.RemoveAll(dir)
+		}
+	}()
 	mech, err := newGPGSigningMechanismInDirectory(dir)
 	if err != nil {
-		return sarRejected, nil, err
+		return nil, err
 	}
 
-	trustedIdentities, err := mech.ImportKeysFromBytes(data)
+	var trustedIdentities []string
+	for _, data := range keyDatas {
+		identities, err := mech.ImportKeysFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		trustedIdentities = append(trustedIdentities, identities...)
+	}
+	if len(trustedIdentities) == 0 {
+		return nil, PolicyRequirementError("No public keys imported")
+	}
+	succeeded = true
+	return &preparedSignedBy{
+		pr:                pr,
+		dir:               dir,
+		mech:              &serializedSigningMechanism{mech: mech},
+		trustedIdentities: trustedIdentities,
+	}, nil
+}
+
+// destroy implements preparedPolicyRequirement.destroy.
+func (p *preparedSignedBy) destroy() error {
+	return os.RemoveAll(p.dir)
+}
+
+func (pr *prSignedBy) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	p, err := pr.prepareSignedBy()
 	if err != nil {
 		return sarRejected, nil, err
 	}
-	if len(trustedIdentities) == 0 {
-		return sarRejected, nil, PolicyRequirementError("No public keys imported")
+	defer p.destroy()
+	return p.isSignatureAuthorAccepted(ctx, image, sig)
+}
+
+func (pr *prSignedBy) isSignatureAuthorAcceptedWithKeyIdentity(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, string, error) {
+	p, err := pr.prepareSignedBy()
+	if err != nil {
+		return sarRejected, nil, "", err
 	}
+	defer p.destroy()
+	return p.isSignatureAuthorAcceptedWithKeyIdentity(ctx, image, sig)
+}
 
-	signature, err := verifyAndExtractSignature(mech, sig, signatureAcceptanceRules{
+func (pr *prSignedBy) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	p, err := pr.prepareSignedBy()
+	if err != nil {
+		return false, err
+	}
+	defer p.destroy()
+	return p.isRunningImageAllowed(ctx, image)
+}
+
+func (p *preparedSignedBy) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	res, signature, _, err := p.isSignatureAuthorAcceptedWithKeyIdentity(ctx, image, sig)
+	return res, signature, err
+}
+
+// isSignatureAuthorAcceptedWithKeyIdentity implements keyIdentityReportingRequirement.isSignatureAuthorAcceptedWithKeyIdentity.
+func (p *preparedSignedBy) isSignatureAuthorAcceptedWithKeyIdentity(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, string, error) {
+	acceptedKeyIdentity := ""
+	signature, err := verifyAndExtractSignature(p.mech, sig, signatureAcceptanceRules{
 		validateKeyIdentity: func(keyIdentity string) error {
-			for _, trustedIdentity := range trustedIdentities {
+			for _, trustedIdentity := range p.trustedIdentities {
 				if keyIdentity == trustedIdentity {
+					acceptedKeyIdentity = keyIdentity
 					return nil
 				}
 			}
@@ -70,13 +161,13 @@ func (pr *prSignedBy) isSignatureAuthorAccepted(image types.UnparsedImage, sig [
 			return PolicyRequirementError(fmt.Sprintf("Signature by key %s is not accepted", keyIdentity))
 		},
 		validateSignedDockerReference: func(ref string) error {
-			if !pr.SignedIdentity.matchesDockerReference(image, ref) {
+			if !p.pr.SignedIdentity.matchesDockerReference(image, ref) {
 				return PolicyRequirementError(fmt.Sprintf("Signature for identity %s is not accepted", ref))
 			}
 			return nil
 		},
-		validateSignedDockerManifestDigest: func(digest string) error {
-			m, _, err := image.Manifest()
+		validateSignedDockerManifestDigest: func(digest digest.Digest) error {
+			m, _, err := image.Manifest(ctx)
 			if err != nil {
 				return err
 			}
@@ -91,21 +182,51 @@ func (pr *prSignedBy) isSignatureAuthorAccepted(image types.UnparsedImage, sig [
 		},
 	})
 	if err != nil {
-		return sarRejected, nil, err
+		return sarRejected, nil, "", err
+	}
+	if p.pr.SignatureTime != nil {
+		if err := p.pr.SignatureTime.validateSignatureTime(signature.Timestamp, time.Now()); err != nil {
+			return sarRejected, nil, "", err
+		}
 	}
 
-	return sarAccepted, signature, nil
+	return sarAccepted, signature, acceptedKeyIdentity, nil
+}
+
+// validateSignatureTime implements PRSignatureTime.validateSignatureTime.
+func (st *prSignatureTime) validateSignatureTime(timestamp, now time.Time) error {
+	if timestamp.IsZero() {
+		return PolicyRequirementError("Signature does not record its creation time")
+	}
+	if st.MaxAge != "" {
+		maxAge, err := time.ParseDuration(st.MaxAge)
+		if err != nil { // Coverage: newPRSignatureTime rejects this
+			return err
+		}
+		if now.Sub(timestamp) > maxAge {
+			return PolicyRequirementError(fmt.Sprintf("Signature created at %s is older than %s", timestamp.UTC().Format(time.RFC3339), st.MaxAge))
+		}
+	}
+	if st.NotBefore != nil && timestamp.Before(*st.NotBefore) {
+		return PolicyRequirementError(fmt.Sprintf("Signature created at %s, before %s, is not accepted",
+			timestamp.UTC().Format(time.RFC3339), st.NotBefore.UTC().Format(time.RFC3339)))
+	}
+	if st.NotAfter != nil && timestamp.After(*st.NotAfter) {
+		return PolicyRequirementError(fmt.Sprintf("Signature created at %s, after %s, is not accepted",
+			timestamp.UTC().Format(time.RFC3339), st.NotAfter.UTC().Format(time.RFC3339)))
+	}
+	return nil
 }
 
-func (pr *prSignedBy) isRunningImageAllowed(image types.UnparsedImage) (bool, error) {
-	sigs, err := image.Signatures()
+func (p *preparedSignedBy) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	sigs, err := image.Signatures(ctx)
 	if err != nil {
 		return false, err
 	}
 	var rejections []error
 	for _, s := range sigs {
 		var reason error
-		switch res, _, err := pr.isSignatureAuthorAccepted(image, s); res {
+		switch res, _, err := p.isSignatureAuthorAccepted(ctx, image, s); res {
 		case sarAccepted:
 			// One accepted signature is enough.
 			return true, nil
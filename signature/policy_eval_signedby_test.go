@@ -1,10 +1,13 @@
 package signature
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/containers/image/directory"
 	"github.com/containers/image/docker/reference"
@@ -14,20 +17,20 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// dirImageMock returns a types.UnparsedImage for a directory, claiming a specified dockerReference.
+// dirImageMock returns an *image.UnparsedImage for a directory, claiming a specified dockerReference.
 // The caller must call .Close() on the returned UnparsedImage.
-func dirImageMock(t *testing.T, dir, dockerReference string) types.UnparsedImage {
+func dirImageMock(t *testing.T, dir, dockerReference string) *image.UnparsedImage {
 	ref, err := reference.ParseNamed(dockerReference)
 	require.NoError(t, err)
 	return dirImageMockWithRef(t, dir, refImageReferenceMock{ref})
 }
 
-// dirImageMockWithRef returns a types.UnparsedImage for a directory, claiming a specified ref.
+// dirImageMockWithRef returns an *image.UnparsedImage for a directory, claiming a specified ref.
 // The caller must call .Close() on the returned UnparsedImage.
-func dirImageMockWithRef(t *testing.T, dir string, ref types.ImageReference) types.UnparsedImage {
+func dirImageMockWithRef(t *testing.T, dir string, ref types.ImageReference) *image.UnparsedImage {
 	srcRef, err := directory.NewReference(dir)
 	require.NoError(t, err)
-	src, err := srcRef.NewImageSource(nil, nil)
+	src, err := srcRef.NewImageSource(context.Background(), nil)
 	require.NoError(t, err)
 	return image.UnparsedFromSource(&dirImageSourceMock{
 		ImageSource: src,
@@ -56,22 +59,58 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	// Successful validation, with KeyData and KeyPath
 	pr, err := NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	sar, parsedSig, err := pr.isSignatureAuthorAccepted(testImage, testImageSig)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
 	assertSARAccepted(t, sar, parsedSig, err, Signature{
 		DockerManifestDigest: TestImageManifestDigest,
 		DockerReference:      "testing/manifest:latest",
+		Timestamp:            time.Unix(TestImageSignatureTimestamp, 0).UTC(),
 	})
 
 	keyData, err := ioutil.ReadFile("fixtures/public-key.gpg")
 	require.NoError(t, err)
 	pr, err = NewPRSignedByKeyData(ktGPG, keyData, prm)
 	require.NoError(t, err)
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(testImage, testImageSig)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
 	assertSARAccepted(t, sar, parsedSig, err, Signature{
 		DockerManifestDigest: TestImageManifestDigest,
 		DockerReference:      "testing/manifest:latest",
+		Timestamp:            time.Unix(TestImageSignatureTimestamp, 0).UTC(),
 	})
 
+	// Successful validation, with KeyPaths and KeyDatas, when any of the keys is trusted
+	pr, err = NewPRSignedByKeyPaths(ktGPG, []string{"fixtures/pubring.gpg", "fixtures/public-key.gpg"}, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+		Timestamp:            time.Unix(TestImageSignatureTimestamp, 0).UTC(),
+	})
+	pr, err = NewPRSignedByKeyDatas(ktGPG, [][]byte{{}, keyData}, prm)
+	require.NoError(t, err)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+		Timestamp:            time.Unix(TestImageSignatureTimestamp, 0).UTC(),
+	})
+
+	// Signature creation time constraints
+	pr, err = NewPRSignedByKeyData(ktGPG, keyData, prm)
+	require.NoError(t, err)
+	stPR, err := WithSignatureTime(pr, xNewPRSignatureTime(0, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}))
+	require.NoError(t, err)
+	sar, parsedSig, err = stPR.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+		Timestamp:            time.Unix(TestImageSignatureTimestamp, 0).UTC(),
+	})
+	stPR, err = WithSignatureTime(pr, xNewPRSignatureTime(24*time.Hour, time.Time{}, time.Time{}))
+	require.NoError(t, err)
+	sar, parsedSig, err = stPR.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
 	// Unimplemented and invalid KeyType values
 	for _, keyType := range []sbKeyType{SBKeyTypeSignedByGPGKeys,
 		SBKeyTypeX509Certificates,
@@ -85,7 +124,7 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 			SignedIdentity: prm,
 		}
 		// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
-		sar, parsedSig, err := pr.isSignatureAuthorAccepted(nil, nil)
+		sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
 		assertSARRejected(t, sar, parsedSig, err)
 	}
 
@@ -97,14 +136,21 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 		SignedIdentity: prm,
 	}
 	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
-	sar, parsedSig, err = prSB.isSignatureAuthorAccepted(nil, nil)
+	sar, parsedSig, err = prSB.isSignatureAuthorAccepted(context.Background(), nil, nil)
 	assertSARRejected(t, sar, parsedSig, err)
 
 	// Invalid KeyPath
 	pr, err = NewPRSignedByKeyPath(ktGPG, "/this/does/not/exist", prm)
 	require.NoError(t, err)
 	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(nil, nil)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
+	assertSARRejected(t, sar, parsedSig, err)
+
+	// Invalid KeyPaths
+	pr, err = NewPRSignedByKeyPaths(ktGPG, []string{"fixtures/public-key.gpg", "/this/does/not/exist"}, prm)
+	require.NoError(t, err)
+	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
 	assertSARRejected(t, sar, parsedSig, err)
 
 	// Errors initializing the temporary GPG directory and mechanism are not obviously easy to reach.
@@ -113,14 +159,14 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	pr, err = NewPRSignedByKeyData(ktGPG, []byte{}, prm)
 	require.NoError(t, err)
 	// Pass nil pointers to, kind of, test that the return value does not depend on the parameters.
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(nil, nil)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, nil)
 	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
 
 	// A signature which does not GPG verify
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
 	// Pass a nil pointer to, kind of, test that the return value does not depend on the image parmater..
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(nil, []byte("invalid signature"))
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, []byte("invalid signature"))
 	assertSARRejected(t, sar, parsedSig, err)
 
 	// A valid signature using an unknown key.
@@ -131,7 +177,7 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	sig, err := ioutil.ReadFile("fixtures/unknown-key.signature")
 	require.NoError(t, err)
 	// Pass a nil pointer to, kind of, test that the return value does not depend on the image parmater..
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(nil, sig)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, sig)
 	assertSARRejected(t, sar, parsedSig, err)
 
 	// A valid signature of an invalid JSON.
@@ -140,7 +186,7 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	sig, err = ioutil.ReadFile("fixtures/invalid-blob.signature")
 	require.NoError(t, err)
 	// Pass a nil pointer to, kind of, test that the return value does not depend on the image parmater..
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(nil, sig)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), nil, sig)
 	assertSARRejected(t, sar, parsedSig, err)
 	assert.IsType(t, InvalidSignatureError{}, err)
 
@@ -149,7 +195,7 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	require.NoError(t, err)
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", nonmatchingPRM)
 	require.NoError(t, err)
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(testImage, testImageSig)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
 	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
 
 	// Error reading image manifest
@@ -159,7 +205,7 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	require.NoError(t, err)
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(image, sig)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), image, sig)
 	assertSARRejected(t, sar, parsedSig, err)
 
 	// Error computing manifest digest
@@ -169,7 +215,7 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	require.NoError(t, err)
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(image, sig)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), image, sig)
 	assertSARRejected(t, sar, parsedSig, err)
 
 	// A valid signature with a non-matching manifest
@@ -179,11 +225,11 @@ func TestPRSignedByIsSignatureAuthorAccepted(t *testing.T) {
 	require.NoError(t, err)
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	sar, parsedSig, err = pr.isSignatureAuthorAccepted(image, sig)
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(context.Background(), image, sig)
 	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
 }
 
-// createInvalidSigDir creates a directory suitable for dirImageMock, in which image.Signatures()
+// createInvalidSigDir creates a directory suitable for dirImageMock, in which image.Signatures(context.Background())
 // fails.
 // The caller should eventually call os.RemoveAll on the returned path.
 func createInvalidSigDir(t *testing.T) string {
@@ -200,6 +246,63 @@ func createInvalidSigDir(t *testing.T) string {
 	return dir
 }
 
+func TestPRSignedByIsSignatureAuthorAcceptedWithKeyIdentity(t *testing.T) {
+	prm := NewPRMMatchExact()
+	testImage := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	defer testImage.Close()
+	testImageSig, err := ioutil.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+
+	// Successful validation reports the signing key
+	pr, err := NewPRSignedByKeyPaths(SBKeyTypeGPGKeys, []string{"fixtures/pubring.gpg", "fixtures/public-key.gpg"}, prm)
+	require.NoError(t, err)
+	sar, parsedSig, keyIdentity, err := pr.(*prSignedBy).isSignatureAuthorAcceptedWithKeyIdentity(context.Background(), testImage, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+		Timestamp:            time.Unix(TestImageSignatureTimestamp, 0).UTC(),
+	})
+	assert.Equal(t, TestKeyFingerprint, keyIdentity)
+
+	// Rejection does not report a key
+	pr, err = NewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact())
+	require.NoError(t, err)
+	sar, parsedSig, keyIdentity, err = pr.(*prSignedBy).isSignatureAuthorAcceptedWithKeyIdentity(context.Background(), testImage, []byte("invalid signature"))
+	assertSARRejected(t, sar, parsedSig, err)
+	assert.Empty(t, keyIdentity)
+}
+
+func TestPRSignatureTimeValidateSignatureTime(t *testing.T) {
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	notBefore := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		st        *prSignatureTime
+		timestamp time.Time
+		accepted  bool
+	}{
+		{&prSignatureTime{MaxAge: "720h"}, now.Add(-24 * time.Hour), true},
+		{&prSignatureTime{MaxAge: "720h"}, now.Add(-720 * time.Hour), true},
+		{&prSignatureTime{MaxAge: "720h"}, now.Add(-721 * time.Hour), false},
+		{&prSignatureTime{MaxAge: "720h"}, time.Time{}, false}, // No timestamp recorded
+		{&prSignatureTime{NotBefore: &notBefore}, notBefore, true},
+		{&prSignatureTime{NotBefore: &notBefore}, notBefore.Add(-time.Second), false},
+		{&prSignatureTime{NotAfter: &notAfter}, notAfter, true},
+		{&prSignatureTime{NotAfter: &notAfter}, notAfter.Add(time.Second), false},
+		{&prSignatureTime{NotBefore: &notBefore, NotAfter: &notAfter}, notBefore.Add(24 * time.Hour), true},
+		{&prSignatureTime{MaxAge: "2160h", NotBefore: &notBefore, NotAfter: &notAfter}, notBefore.Add(24 * time.Hour), false},
+		{&prSignatureTime{MaxAge: "this is invalid"}, now, false},
+	} {
+		err := c.st.validateSignatureTime(c.timestamp, now)
+		if c.accepted {
+			assert.NoError(t, err, fmt.Sprintf("%#v %v", c.st, c.timestamp))
+		} else {
+			assert.Error(t, err, fmt.Sprintf("%#v %v", c.st, c.timestamp))
+		}
+	}
+}
+
 func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	ktGPG := SBKeyTypeGPGKeys
 	prm := NewPRMMatchExact()
@@ -209,7 +312,7 @@ func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	defer image.Close()
 	pr, err := NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	allowed, err := pr.isRunningImageAllowed(image)
+	allowed, err := pr.isRunningImageAllowed(context.Background(), image)
 	assertRunningAllowed(t, allowed, err)
 
 	// Error reading signatures
@@ -219,7 +322,7 @@ func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	defer image.Close()
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	allowed, err = pr.isRunningImageAllowed(image)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
 	assertRunningRejected(t, allowed, err)
 
 	// No signatures
@@ -227,7 +330,7 @@ func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	defer image.Close()
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	allowed, err = pr.isRunningImageAllowed(image)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
 	assertRunningRejectedPolicyRequirement(t, allowed, err)
 
 	// 1 invalid signature: use dir-img-valid, but a non-matching Docker reference
@@ -235,7 +338,7 @@ func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	defer image.Close()
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	allowed, err = pr.isRunningImageAllowed(image)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
 	assertRunningRejectedPolicyRequirement(t, allowed, err)
 
 	// 2 valid signatures
@@ -243,7 +346,7 @@ func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	defer image.Close()
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	allowed, err = pr.isRunningImageAllowed(image)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
 	assertRunningAllowed(t, allowed, err)
 
 	// One invalid, one valid signature (in this order)
@@ -251,7 +354,7 @@ func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	defer image.Close()
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	allowed, err = pr.isRunningImageAllowed(image)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
 	assertRunningAllowed(t, allowed, err)
 
 	// 2 invalid signatures: use dir-img-valid-2, but a non-matching Docker reference
@@ -259,6 +362,6 @@ func TestPRSignedByIsRunningImageAllowed(t *testing.T) {
 	defer image.Close()
 	pr, err = NewPRSignedByKeyPath(ktGPG, "fixtures/public-key.gpg", prm)
 	require.NoError(t, err)
-	allowed, err = pr.isRunningImageAllowed(image)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), image)
 	assertRunningRejectedPolicyRequirement(t, allowed, err)
 }
@@ -0,0 +1,200 @@
+// Policy evaluation for prSigstoreSigned.
+
+package signature
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+func (pr *prSigstoreSigned) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	// sig is a simple signing signature; sigstore signatures are stored separately and evaluated by isRunningImageAllowed.
+	return sarUnknown, nil, nil
+}
+
+// loadBytesFromDataOrPath returns data if not nil, or the contents of path otherwise.
+// prefix is used in error messages, e.g. "key" for keyPath/keyData.
+func loadBytesFromDataOrPath(prefix string, data []byte, path string) ([]byte, error) {
+	switch {
+	case data != nil && path != "": // Coverage: the policy constructors reject this
+		return nil, fmt.Errorf(`Internal inconsistency: both "%sPath" and "%sData" specified`, prefix, prefix)
+	case data != nil:
+		return data, nil
+	case path != "":
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	default: // Coverage: the policy constructors reject this
+		return nil, fmt.Errorf(`Internal inconsistency: neither "%sPath" nor "%sData" specified`, prefix, prefix)
+	}
+}
+
+// prepareTrustRoot returns a fulcioTrustRoot from f.
+func (f *prSigstoreSignedFulcio) prepareTrustRoot() (*fulcioTrustRoot, error) {
+	caData, err := loadBytesFromDataOrPath("ca", f.CAData, f.CAPath)
+	if err != nil {
+		return nil, err
+	}
+	return newFulcioTrustRoot(caData, f.OIDCIssuer, f.SubjectEmail)
+}
+
+// preparedSigstoreSigned is a prSigstoreSigned with its trust root already loaded,
+// so that it can be used to evaluate many images, possibly concurrently.
+type preparedSigstoreSigned struct {
+	pr        *prSigstoreSigned
+	trustRoot *sigstoreTrustRoot // Never modified, so safe for concurrent use
+}
+
+// prepare implements preparablePolicyRequirement.prepare.
+func (pr *prSigstoreSigned) prepare() (preparedPolicyRequirement, error) {
+	trustRoot, err := pr.prepareTrustRoot()
+	if err != nil {
+		return nil, err
+	}
+	return &preparedSigstoreSigned{pr: pr, trustRoot: trustRoot}, nil
+}
+
+// destroy implements preparedPolicyRequirement.destroy.
+func (p *preparedSigstoreSigned) destroy() error {
+	return nil // The trust root is only kept in memory.
+}
+
+// prepareTrustRoot returns a sigstoreTrustRoot from pr.
+func (pr *prSigstoreSigned) prepareTrustRoot() (*sigstoreTrustRoot, error) {
+	res := sigstoreTrustRoot{}
+	if pr.Fulcio != nil {
+		f, err := pr.Fulcio.prepareTrustRoot()
+		if err != nil {
+			return nil, err
+		}
+		res.fulcio = f
+	} else {
+		var keyDatas [][]byte
+		switch {
+		case pr.KeyPaths != nil:
+			for _, path := range pr.KeyPaths {
+				d, err := ioutil.ReadFile(path)
+				if err != nil {
+					return nil, err
+				}
+				keyDatas = append(keyDatas, d)
+			}
+		case pr.KeyDatas != nil:
+			keyDatas = pr.KeyDatas
+		default:
+			keyData, err := loadBytesFromDataOrPath("key", pr.KeyData, pr.KeyPath)
+			if err != nil {
+				return nil, err
+			}
+			keyDatas = [][]byte{keyData}
+		}
+		// A signature by any one of the keys is accepted.
+		for _, keyData := range keyDatas {
+			keys, err := parseSigstorePublicKeys(keyData)
+			if err != nil {
+				return nil, err
+			}
+			res.publicKeys = append(res.publicKeys, keys...)
+		}
+	}
+	if pr.RekorPublicKeyData != nil || pr.RekorPublicKeyPath != "" {
+		rekorKeyData, err := loadBytesFromDataOrPath("rekorPublicKey", pr.RekorPublicKeyData, pr.RekorPublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseRekorPublicKey(rekorKeyData)
+		if err != nil {
+			return nil, err
+		}
+		res.rekorPublicKey = key
+	}
+	return &res, nil
+}
+
+// isSigstoreSignatureAccepted returns nil if sig is a sigstore signature trusted by trustRoot, matching image and pr.SignedIdentity.
+func (pr *prSigstoreSigned) isSigstoreSignatureAccepted(ctx context.Context, image types.UnparsedImage, trustRoot *sigstoreTrustRoot, sig types.SigstoreSignature) error {
+	signature, err := verifyAndExtractSigstoreSignature(trustRoot, sig, signatureAcceptanceRules{
+		validateSignedDockerReference: func(ref string) error {
+			if !pr.SignedIdentity.matchesDockerReference(image, ref) {
+				return PolicyRequirementError(fmt.Sprintf("Signature for identity %s is not accepted", ref))
+			}
+			return nil
+		},
+		validateSignedDockerManifestDigest: func(digest digest.Digest) error {
+			m, _, err := image.Manifest(ctx)
+			if err != nil {
+				return err
+			}
+			digestMatches, err := manifest.MatchesDigest(m, digest)
+			if err != nil {
+				return err
+			}
+			if !digestMatches {
+				return PolicyRequirementError(fmt.Sprintf("Signature for digest %s does not match", digest))
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if pr.SignatureTime != nil {
+		return pr.SignatureTime.validateSignatureTime(signature.Timestamp, time.Now())
+	}
+	return nil
+}
+
+func (pr *prSigstoreSigned) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	trustRoot, err := pr.prepareTrustRoot()
+	if err != nil {
+		return false, err
+	}
+	p := &preparedSigstoreSigned{pr: pr, trustRoot: trustRoot}
+	return p.isRunningImageAllowed(ctx, image)
+}
+
+func (p *preparedSigstoreSigned) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	return p.pr.isSignatureAuthorAccepted(ctx, image, sig)
+}
+
+func (p *preparedSigstoreSigned) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	sigstoreImage, ok := image.(types.SigstoreSignaturesImage)
+	if !ok {
+		return false, PolicyRequirementError("A sigstore signature was required, but the image does not support sigstore signatures")
+	}
+	sigs, err := sigstoreImage.SigstoreSignatures(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(sigs) == 0 {
+		return false, PolicyRequirementError("A sigstore signature was required, but no sigstore signature exists")
+	}
+
+	var rejections []error
+	for _, s := range sigs {
+		err := p.pr.isSigstoreSignatureAccepted(ctx, image, p.trustRoot, s)
+		if err == nil {
+			// One accepted signature is enough.
+			return true, nil
+		}
+		rejections = append(rejections, err)
+	}
+	if len(rejections) == 1 {
+		return false, rejections[0]
+	}
+	var msgs []string
+	for _, e := range rejections {
+		msgs = append(msgs, e.Error())
+	}
+	return false, PolicyRequirementError(fmt.Sprintf("None of the sigstore signatures were accepted, reasons: %s",
+		strings.Join(msgs, "; ")))
+}
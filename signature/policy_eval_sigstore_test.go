@@ -0,0 +1,396 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sigstoreImageSourceMock inherits dirImageSource, but overrides its Reference method and provides sigstore signatures.
+type sigstoreImageSourceMock struct {
+	dirImageSourceMock
+	sigs []types.SigstoreSignature
+}
+
+func (s *sigstoreImageSourceMock) GetSigstoreSignatures(ctx context.Context) ([]types.SigstoreSignature, error) {
+	return s.sigs, nil
+}
+
+// sigstoreImageMock returns an *image.UnparsedImage for a directory, claiming dockerReference and having sigs.
+// The caller must call .Close() on the returned UnparsedImage.
+func sigstoreImageMock(t *testing.T, dir, dockerReference string, sigs []types.SigstoreSignature) *image.UnparsedImage {
+	ref, err := reference.ParseNamed(dockerReference)
+	require.NoError(t, err)
+	srcRef, err := directory.NewReference(dir)
+	require.NoError(t, err)
+	src, err := srcRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	return image.UnparsedFromSource(&sigstoreImageSourceMock{
+		dirImageSourceMock: dirImageSourceMock{ImageSource: src, ref: refImageReferenceMock{ref}},
+		sigs:               sigs,
+	})
+}
+
+// sigstoreTestSignature returns a sigstore signature of a payload for manifestDigest and dockerReference, made by key.
+func sigstoreTestSignature(t *testing.T, key *ecdsa.PrivateKey, manifestDigest digest.Digest, dockerReference string) types.SigstoreSignature {
+	var p sigstorePayload
+	p.Critical.Identity.DockerReference = dockerReference
+	p.Critical.Image.DockerManifestDigest = manifestDigest
+	p.Critical.Type = sigstoreSignatureType
+	payload, err := json.Marshal(p)
+	require.NoError(t, err)
+	digest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	return types.SigstoreSignature{Payload: payload, Signature: sig}
+}
+
+// sigstoreTestPublicKeyPEM returns a PEM encoding of the public key of key.
+func sigstoreTestPublicKeyPEM(t *testing.T, key crypto.Signer) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestParseSigstorePayload(t *testing.T) {
+	// Success, including unknown "optional" contents
+	sig, err := parseSigstorePayload([]byte(`{"critical":{"identity":{"docker-reference":"example.com/a/b:tag"},` +
+		`"image":{"docker-manifest-digest":"sha256:0123"},"type":"cosign container image signature"},"optional":{"foo":"bar"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, &Signature{DockerManifestDigest: "sha256:0123", DockerReference: "example.com/a/b:tag"}, sig)
+	sig, err = parseSigstorePayload([]byte(`{"critical":{"identity":{"docker-reference":"example.com/a/b:tag"},` +
+		`"image":{"docker-manifest-digest":"sha256:0123"},"type":"cosign container image signature"},"optional":null}`))
+	require.NoError(t, err)
+	assert.Equal(t, &Signature{DockerManifestDigest: "sha256:0123", DockerReference: "example.com/a/b:tag"}, sig)
+	// A timestamp is recorded if it is a Unix time, and ignored otherwise
+	sig, err = parseSigstorePayload([]byte(`{"critical":{"identity":{"docker-reference":"example.com/a/b:tag"},` +
+		`"image":{"docker-manifest-digest":"sha256:0123"},"type":"cosign container image signature"},"optional":{"timestamp":1464398954}}`))
+	require.NoError(t, err)
+	assert.Equal(t, &Signature{DockerManifestDigest: "sha256:0123", DockerReference: "example.com/a/b:tag", Timestamp: time.Unix(1464398954, 0).UTC()}, sig)
+	sig, err = parseSigstorePayload([]byte(`{"critical":{"identity":{"docker-reference":"example.com/a/b:tag"},` +
+		`"image":{"docker-manifest-digest":"sha256:0123"},"type":"cosign container image signature"},"optional":{"timestamp":"yesterday"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, &Signature{DockerManifestDigest: "sha256:0123", DockerReference: "example.com/a/b:tag"}, sig)
+
+	for _, payload := range []string{
+		"",
+		"[]",
+		`{"critical":{"identity":{"docker-reference":"example.com/a/b:tag"},"image":{"docker-manifest-digest":"sha256:0123"},"type":"atomic container signature"}}`,
+		`{"critical":{"identity":{"docker-reference":"example.com/a/b:tag"},"type":"cosign container image signature"}}`,
+		`{"critical":{"image":{"docker-manifest-digest":"sha256:0123"},"type":"cosign container image signature"}}`,
+	} {
+		_, err := parseSigstorePayload([]byte(payload))
+		assert.Error(t, err, payload)
+	}
+}
+
+func TestParseSigstorePublicKeys(t *testing.T) {
+	key1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key2, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	keys, err := parseSigstorePublicKeys(append(sigstoreTestPublicKeyPEM(t, key1), sigstoreTestPublicKeyPEM(t, key2)...))
+	require.NoError(t, err)
+	assert.Equal(t, []crypto.PublicKey{key1.Public(), key2.Public()}, keys)
+
+	for _, data := range [][]byte{
+		nil,
+		[]byte("this is not PEM"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("this is not a key")}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("ignored")}),
+	} {
+		_, err := parseSigstorePublicKeys(data)
+		assert.Error(t, err, string(data))
+	}
+}
+
+func TestPRSigstoreSignedIsSignatureAuthorAccepted(t *testing.T) {
+	pr, err := NewPRSigstoreSignedKeyData([]byte("unused"), NewPRMMatchExact())
+	require.NoError(t, err)
+	img := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	defer img.Close()
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), img, []byte("unused"))
+	assert.Equal(t, sarUnknown, sar)
+	assert.Nil(t, parsedSig)
+	assert.NoError(t, err)
+}
+
+func TestPRSigstoreSignedIsRunningImageAllowed(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyData := sigstoreTestPublicKeyPEM(t, key)
+
+	manifestBlob, err := ioutil.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+	const dir = "fixtures/dir-img-valid"
+	const ref = "testing/manifest:latest"
+
+	validSig := sigstoreTestSignature(t, key, manifestDigest, ref)
+
+	// A valid signature, using keyData
+	pr, err := NewPRSigstoreSignedKeyData(keyData, NewPRMMatchExact())
+	require.NoError(t, err)
+	img := sigstoreImageMock(t, dir, ref, []types.SigstoreSignature{validSig})
+	defer img.Close()
+	allowed, err := pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// A valid signature, using keyPath
+	tmpDir, err := ioutil.TempDir("", "sigstore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	keyPath := filepath.Join(tmpDir, "cosign.pub")
+	err = ioutil.WriteFile(keyPath, keyData, 0644)
+	require.NoError(t, err)
+	pr, err = NewPRSigstoreSignedKeyPath(keyPath, NewPRMMatchExact())
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// A valid signature by any one of several keys, using keyDatas
+	otherKeyData := sigstoreTestPublicKeyPEM(t, otherKey)
+	pr, err = NewPRSigstoreSignedKeyDatas([][]byte{otherKeyData, keyData}, NewPRMMatchExact())
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// A valid signature by any one of several keys, using keyPaths
+	otherKeyPath := filepath.Join(tmpDir, "other-cosign.pub")
+	err = ioutil.WriteFile(otherKeyPath, otherKeyData, 0644)
+	require.NoError(t, err)
+	pr, err = NewPRSigstoreSignedKeyPaths([]string{otherKeyPath, keyPath}, NewPRMMatchExact())
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// None of several keys signed the image
+	pr, err = NewPRSigstoreSignedKeyPaths([]string{otherKeyPath}, NewPRMMatchExact())
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// One of several key files is missing
+	pr, err = NewPRSigstoreSignedKeyPaths([]string{keyPath, filepath.Join(tmpDir, "this/does/not/exist")}, NewPRMMatchExact())
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningRejected(t, allowed, err)
+
+	pr, err = NewPRSigstoreSignedKeyPath(keyPath, NewPRMMatchExact())
+	require.NoError(t, err)
+	// One of several signatures is valid
+	img = sigstoreImageMock(t, dir, ref, []types.SigstoreSignature{
+		sigstoreTestSignature(t, otherKey, manifestDigest, ref),
+		validSig,
+	})
+	defer img.Close()
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// Missing key file
+	pr, err = NewPRSigstoreSignedKeyPath(filepath.Join(tmpDir, "this/does/not/exist"), NewPRMMatchExact())
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningRejected(t, allowed, err)
+
+	pr, err = NewPRSigstoreSignedKeyData(keyData, NewPRMMatchExact())
+	require.NoError(t, err)
+	for _, sigs := range [][]types.SigstoreSignature{
+		// No signatures
+		{},
+		// Signed by an untrusted key
+		{sigstoreTestSignature(t, otherKey, manifestDigest, ref)},
+		// Modified payload
+		{{Payload: append([]byte(" "), validSig.Payload...), Signature: validSig.Signature}},
+		// A different manifest digest
+		{sigstoreTestSignature(t, key, "sha256:0000000000000000000000000000000000000000000000000000000000000000", ref)},
+		// A different identity
+		{sigstoreTestSignature(t, key, manifestDigest, "testing/manifest:notlatest")},
+		// Several invalid signatures
+		{sigstoreTestSignature(t, otherKey, manifestDigest, ref), sigstoreTestSignature(t, key, manifestDigest, "example.com/other:latest")},
+	} {
+		img := sigstoreImageMock(t, dir, ref, sigs)
+		defer img.Close()
+		allowed, err := pr.isRunningImageAllowed(context.Background(), img)
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+
+	// Without Rekor, signature creation time constraints reject signatures which don't record a timestamp
+	stPR, err := WithSignatureTime(pr, xNewPRSignatureTime(24*time.Hour, time.Time{}, time.Time{}))
+	require.NoError(t, err)
+	img = sigstoreImageMock(t, dir, ref, []types.SigstoreSignature{validSig})
+	defer img.Close()
+	allowed, err = stPR.isRunningImageAllowed(context.Background(), img)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Images which don't support sigstore signatures are rejected
+	allowed, err = pr.isRunningImageAllowed(context.Background(), refImageMock{nil})
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+}
+
+func TestPRSigstoreSignedRekorIsRunningImageAllowed(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyPEM := sigstoreTestPublicKeyPEM(t, key)
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rekorKeyPEM := sigstoreTestPublicKeyPEM(t, rekorKey)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	manifestBlob, err := ioutil.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+	const dir = "fixtures/dir-img-valid"
+	const ref = "testing/manifest:latest"
+
+	pr, err := newPRSigstoreSigned("", nil, keyPEM, nil, nil, "", rekorKeyPEM, NewPRMMatchExact())
+	require.NoError(t, err)
+
+	sig := sigstoreTestSignature(t, key, manifestDigest, ref)
+	sig.RekorBundle = rekorTestBundle(t, rekorKey, rekorTestBody(t, sig.Payload, sig.Signature, keyPEM), time.Now())
+	img := sigstoreImageMock(t, dir, ref, []types.SigstoreSignature{sig})
+	defer img.Close()
+	allowed, err := pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	// Signature creation time constraints use the time recorded by Rekor
+	stPR, err := WithSignatureTime(pr, xNewPRSignatureTime(24*time.Hour, time.Time{}, time.Time{}))
+	require.NoError(t, err)
+	allowed, err = stPR.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+	oldSig := sigstoreTestSignature(t, key, manifestDigest, ref)
+	oldSig.RekorBundle = rekorTestBundle(t, rekorKey, rekorTestBody(t, oldSig.Payload, oldSig.Signature, keyPEM), time.Now().Add(-48*time.Hour))
+	oldImg := sigstoreImageMock(t, dir, ref, []types.SigstoreSignature{oldSig})
+	defer oldImg.Close()
+	allowed, err = pr.isRunningImageAllowed(context.Background(), oldImg)
+	assertRunningAllowed(t, allowed, err)
+	allowed, err = stPR.isRunningImageAllowed(context.Background(), oldImg)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// No Rekor bundle
+	noBundle := sigstoreTestSignature(t, key, manifestDigest, ref)
+	// Rekor entry recording a different key
+	otherEntryKey := sigstoreTestSignature(t, key, manifestDigest, ref)
+	otherEntryKey.RekorBundle = rekorTestBundle(t, rekorKey,
+		rekorTestBody(t, otherEntryKey.Payload, otherEntryKey.Signature, sigstoreTestPublicKeyPEM(t, otherKey)), time.Now())
+	for _, s := range []types.SigstoreSignature{noBundle, otherEntryKey} {
+		img := sigstoreImageMock(t, dir, ref, []types.SigstoreSignature{s})
+		defer img.Close()
+		allowed, err := pr.isRunningImageAllowed(context.Background(), img)
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+}
+
+func TestPRSigstoreSignedFulcioIsRunningImageAllowed(t *testing.T) {
+	ca := newFulcioTestCA(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	manifestBlob, err := ioutil.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+	const dir = "fixtures/dir-img-valid"
+	const ref = "testing/manifest:latest"
+
+	fulcio, err := NewPRSigstoreSignedFulcioCAData(ca.pem, "https://example.com", "user@example.com")
+	require.NoError(t, err)
+	pr, err := NewPRSigstoreSignedFulcio(fulcio, "", sigstoreTestPublicKeyPEM(t, rekorKey), NewPRMMatchExact())
+	require.NoError(t, err)
+
+	signingTime := time.Now().Add(-time.Hour) // The certificate has long expired by now.
+	keylessSig := func(email string, integratedTime time.Time) types.SigstoreSignature {
+		sig := sigstoreTestSignature(t, key, manifestDigest, ref)
+		sig.Certificate = ca.issue(t, key, signingTime.Add(-time.Minute), email, fulcioOIDCIssuerV2OID, "https://example.com")
+		sig.RekorBundle = rekorTestBundle(t, rekorKey, rekorTestBody(t, sig.Payload, sig.Signature, sig.Certificate), integratedTime)
+		return sig
+	}
+
+	img := sigstoreImageMock(t, dir, ref, []types.SigstoreSignature{keylessSig("user@example.com", signingTime)})
+	defer img.Close()
+	allowed, err := pr.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+
+	noCertificate := keylessSig("user@example.com", signingTime)
+	noCertificate.Certificate = nil
+	noBundle := keylessSig("user@example.com", signingTime)
+	noBundle.RekorBundle = nil
+	for _, sig := range []types.SigstoreSignature{
+		keylessSig("other@example.com", signingTime), // Different identity
+		keylessSig("user@example.com", time.Now()),   // Recorded in Rekor after the certificate expired
+		noCertificate,
+		noBundle,
+	} {
+		img := sigstoreImageMock(t, dir, ref, []types.SigstoreSignature{sig})
+		defer img.Close()
+		allowed, err := pr.isRunningImageAllowed(context.Background(), img)
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+}
+
+func TestPRSigstoreSignedPrepare(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	manifestBlob, err := ioutil.ReadFile("fixtures/dir-img-valid/manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+	const ref = "testing/manifest:latest"
+
+	tmpDir, err := ioutil.TempDir("", "sigstore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	keyPath := filepath.Join(tmpDir, "cosign.pub")
+	err = ioutil.WriteFile(keyPath, sigstoreTestPublicKeyPEM(t, key), 0644)
+	require.NoError(t, err)
+	pr, err := NewPRSigstoreSignedKeyPath(keyPath, NewPRMMatchExact())
+	require.NoError(t, err)
+
+	pc, err := NewPolicyContext(&Policy{Default: PolicyRequirements{pr}})
+	require.NoError(t, err)
+	defer pc.Destroy()
+	prepared, ok := pc.requirementForEvaluation(pr).(*preparedSigstoreSigned)
+	require.True(t, ok)
+	assert.Same(t, prepared, pc.requirementForEvaluation(pr))
+
+	// The prepared requirement does not read the key file again.
+	require.NoError(t, os.Remove(keyPath))
+	img := sigstoreImageMock(t, "fixtures/dir-img-valid", ref, []types.SigstoreSignature{sigstoreTestSignature(t, key, manifestDigest, ref)})
+	defer img.Close()
+	allowed, err := prepared.isRunningImageAllowed(context.Background(), img)
+	assertRunningAllowed(t, allowed, err)
+	sar, parsedSig, err := prepared.isSignatureAuthorAccepted(context.Background(), img, []byte("unused"))
+	assertSARUnknown(t, sar, parsedSig, err)
+
+	// Failures to prepare are reported.
+	_, err = pr.(preparablePolicyRequirement).prepare()
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package signature
 
 import (
+	"context"
 	"testing"
 
 	"github.com/containers/image/docker/reference"
@@ -34,41 +35,41 @@ func (ref nameOnlyImageReferenceMock) PolicyConfigurationIdentity() string {
 func (ref nameOnlyImageReferenceMock) PolicyConfigurationNamespaces() []string {
 	panic("unexpected call to a mock function")
 }
-func (ref nameOnlyImageReferenceMock) NewImage(ctx *types.SystemContext) (types.Image, error) {
+func (ref nameOnlyImageReferenceMock) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref nameOnlyImageReferenceMock) NewImageSource(ctx *types.SystemContext, requestedManifestMIMETypes []string) (types.ImageSource, error) {
+func (ref nameOnlyImageReferenceMock) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref nameOnlyImageReferenceMock) NewImageDestination(ctx *types.SystemContext) (types.ImageDestination, error) {
+func (ref nameOnlyImageReferenceMock) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref nameOnlyImageReferenceMock) DeleteImage(ctx *types.SystemContext) error {
+func (ref nameOnlyImageReferenceMock) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	panic("unexpected call to a mock function")
 }
 
 func TestPRInsecureAcceptAnythingIsSignatureAuthorAccepted(t *testing.T) {
 	pr := NewPRInsecureAcceptAnything()
 	// Pass nil signature to, kind of, test that the return value does not depend on it.
-	sar, parsedSig, err := pr.isSignatureAuthorAccepted(nameOnlyImageMock{}, nil)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), nameOnlyImageMock{}, nil)
 	assertSARUnknown(t, sar, parsedSig, err)
 }
 
 func TestPRInsecureAcceptAnythingIsRunningImageAllowed(t *testing.T) {
 	pr := NewPRInsecureAcceptAnything()
-	res, err := pr.isRunningImageAllowed(nameOnlyImageMock{})
+	res, err := pr.isRunningImageAllowed(context.Background(), nameOnlyImageMock{})
 	assertRunningAllowed(t, res, err)
 }
 
 func TestPRRejectIsSignatureAuthorAccepted(t *testing.T) {
 	pr := NewPRReject()
 	// Pass nil signature to, kind of, test that the return value does not depend on it.
-	sar, parsedSig, err := pr.isSignatureAuthorAccepted(nameOnlyImageMock{}, nil)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), nameOnlyImageMock{}, nil)
 	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
 }
 
 func TestPRRejectIsRunningImageAllowed(t *testing.T) {
 	pr := NewPRReject()
-	res, err := pr.isRunningImageAllowed(nameOnlyImageMock{})
+	res, err := pr.isRunningImageAllowed(context.Background(), nameOnlyImageMock{})
 	assertRunningRejectedPolicyRequirement(t, res, err)
 }
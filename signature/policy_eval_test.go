@@ -1,12 +1,16 @@
 package signature
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/containers/image/docker/policyconfiguration"
 	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
 	"github.com/containers/image/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -60,6 +64,33 @@ func TestPolicyContextNewDestroy(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPolicyContextStartStopUsing(t *testing.T) {
+	pc, err := NewPolicyContext(&Policy{Default: PolicyRequirements{NewPRReject()}})
+	require.NoError(t, err)
+
+	// Several concurrent users are allowed.
+	err = pc.startUsing()
+	require.NoError(t, err)
+	err = pc.startUsing()
+	require.NoError(t, err)
+	assert.Equal(t, pcInUse, pc.state)
+	err = pc.Destroy()
+	assert.Error(t, err)
+	err = pc.stopUsing()
+	require.NoError(t, err)
+	assert.Equal(t, pcInUse, pc.state)
+	err = pc.stopUsing()
+	require.NoError(t, err)
+	assert.Equal(t, pcReady, pc.state)
+	err = pc.stopUsing()
+	assert.Error(t, err)
+
+	err = pc.Destroy()
+	require.NoError(t, err)
+	err = pc.startUsing()
+	assert.Error(t, err)
+}
+
 // pcImageReferenceMock is a mock of types.ImageReference which returns itself in DockerReference
 // and handles PolicyConfigurationIdentity and PolicyConfigurationReference consistently.
 type pcImageReferenceMock struct {
@@ -90,16 +121,16 @@ func (ref pcImageReferenceMock) PolicyConfigurationNamespaces() []string {
 	}
 	return policyconfiguration.DockerReferenceNamespaces(ref.ref)
 }
-func (ref pcImageReferenceMock) NewImage(ctx *types.SystemContext) (types.Image, error) {
+func (ref pcImageReferenceMock) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref pcImageReferenceMock) NewImageSource(ctx *types.SystemContext, requestedManifestMIMETypes []string) (types.ImageSource, error) {
+func (ref pcImageReferenceMock) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref pcImageReferenceMock) NewImageDestination(ctx *types.SystemContext) (types.ImageDestination, error) {
+func (ref pcImageReferenceMock) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref pcImageReferenceMock) DeleteImage(ctx *types.SystemContext) error {
+func (ref pcImageReferenceMock) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	panic("unexpected call to a mock function")
 }
 
@@ -168,12 +199,17 @@ func TestPolicyContextRequirementsForImageRef(t *testing.T) {
 		// same element and have the same length.
 		assert.True(t, &(reqs[0]) == &(expected[0]), comment)
 		assert.True(t, len(reqs) == len(expected), comment)
+
+		reqs, transport, scope := pc.requirementsAndScopeForImageRef(pcImageReferenceMock{c.inputTransport, ref})
+		assert.True(t, &(reqs[0]) == &(expected[0]), comment)
+		assert.Equal(t, c.matchedTransport, transport, comment)
+		assert.Equal(t, c.matched, scope, comment)
 	}
 }
 
-// pcImageMock returns a types.UnparsedImage for a directory, claiming a specified dockerReference and implementing PolicyConfigurationIdentity/PolicyConfigurationNamespaces.
-// The caller must call .Close() on the returned Image.
-func pcImageMock(t *testing.T, dir, dockerReference string) types.UnparsedImage {
+// pcImageMock returns an *image.UnparsedImage for a directory, claiming a specified dockerReference and implementing PolicyConfigurationIdentity/PolicyConfigurationNamespaces.
+// The caller must call .Close() on the returned UnparsedImage.
+func pcImageMock(t *testing.T, dir, dockerReference string) *image.UnparsedImage {
 	ref, err := reference.ParseNamed(dockerReference)
 	require.NoError(t, err)
 	return dirImageMockWithRef(t, dir, pcImageReferenceMock{"docker", ref})
@@ -183,6 +219,7 @@ func TestPolicyContextGetSignaturesWithAcceptedAuthor(t *testing.T) {
 	expectedSig := &Signature{
 		DockerManifestDigest: TestImageManifestDigest,
 		DockerReference:      "testing/manifest:latest",
+		Timestamp:            time.Unix(TestImageSignatureTimestamp, 0).UTC(),
 	}
 
 	pc, err := NewPolicyContext(&Policy{
@@ -224,7 +261,7 @@ func TestPolicyContextGetSignaturesWithAcceptedAuthor(t *testing.T) {
 	// Success
 	img := pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
 	defer img.Close()
-	sigs, err := pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err := pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Equal(t, []*Signature{expectedSig}, sigs)
 
@@ -232,76 +269,78 @@ func TestPolicyContextGetSignaturesWithAcceptedAuthor(t *testing.T) {
 	// FIXME? Use really different signatures for this?
 	img = pcImageMock(t, "fixtures/dir-img-valid-2", "testing/manifest:latest")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
-	assert.Equal(t, []*Signature{expectedSig, expectedSig}, sigs)
+	expectedSig2 := *expectedSig
+	expectedSig2.Timestamp = time.Unix(1464640051, 0).UTC() // signature-2 was created a bit later
+	assert.Equal(t, []*Signature{expectedSig, &expectedSig2}, sigs)
 
 	// No signatures
 	img = pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Empty(t, sigs)
 
 	// Only invalid signatures
 	img = pcImageMock(t, "fixtures/dir-img-modified-manifest", "testing/manifest:latest")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Empty(t, sigs)
 
 	// 1 invalid, 1 valid signature (in this order)
 	img = pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:latest")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Equal(t, []*Signature{expectedSig}, sigs)
 
 	// Two sarAccepted results for one signature
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:twoAccepts")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Equal(t, []*Signature{expectedSig}, sigs)
 
 	// sarAccepted+sarRejected for a signature
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:acceptReject")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Empty(t, sigs)
 
 	// sarAccepted+sarUnknown for a signature
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:acceptUnknown")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Equal(t, []*Signature{expectedSig}, sigs)
 
 	// sarRejected+sarUnknown for a signature
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:rejectUnknown")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Empty(t, sigs)
 
 	// sarUnknown only
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:unknown")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Empty(t, sigs)
 
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:unknown2")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Empty(t, sigs)
 
 	// Empty list of requirements (invalid)
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:invalidEmptyRequirements")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	require.NoError(t, err)
 	assert.Empty(t, sigs)
 
@@ -314,7 +353,7 @@ func TestPolicyContextGetSignaturesWithAcceptedAuthor(t *testing.T) {
 	require.NoError(t, err)
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
 	defer img.Close()
-	sigs, err = destroyedPC.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = destroyedPC.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	assert.Error(t, err)
 	assert.Nil(t, sigs)
 	// Not testing the pcInUse->pcReady transition, that would require custom PolicyRequirement
@@ -326,11 +365,134 @@ func TestPolicyContextGetSignaturesWithAcceptedAuthor(t *testing.T) {
 	defer os.RemoveAll(invalidSigDir)
 	img = pcImageMock(t, invalidSigDir, "testing/manifest:latest")
 	defer img.Close()
-	sigs, err = pc.GetSignaturesWithAcceptedAuthor(img)
+	sigs, err = pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
 	assert.Error(t, err)
 	assert.Nil(t, sigs)
 }
 
+func TestPolicyContextVerifySignatures(t *testing.T) {
+	expectedSig := &Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+		Timestamp:            time.Unix(TestImageSignatureTimestamp, 0).UTC(),
+	}
+
+	signedBy := xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchRepository())
+	signedBy2 := xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchRepository())
+	baseLayer := xNewPRSignedBaseLayer(NewPRMMatchRepository())
+	pc, err := NewPolicyContext(&Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"docker.io/testing/manifest:latest": {
+					signedBy,
+				},
+				"docker.io/testing/manifest:twoAccepts": {
+					signedBy, signedBy2, baseLayer,
+				},
+				"docker.io/testing/manifest:acceptReject": {
+					signedBy,
+					NewPRReject(),
+				},
+				"docker.io/testing/manifest:unknown": {
+					baseLayer,
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer pc.Destroy()
+
+	// Success
+	img := pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	defer img.Close()
+	results, err := pc.VerifySignatures(context.Background(), img)
+	require.NoError(t, err)
+	assert.Equal(t, []SignatureVerificationResult{{
+		Accepted:       true,
+		Signature:      expectedSig,
+		KeyFingerprint: TestKeyFingerprint,
+		AcceptedBy:     []PolicyRequirement{signedBy},
+	}}, results)
+
+	// Several requirements accepting a signature
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:twoAccepts")
+	defer img.Close()
+	results, err = pc.VerifySignatures(context.Background(), img)
+	require.NoError(t, err)
+	assert.Equal(t, []SignatureVerificationResult{{
+		Accepted:       true,
+		Signature:      expectedSig,
+		KeyFingerprint: TestKeyFingerprint,
+		AcceptedBy:     []PolicyRequirement{signedBy, signedBy2},
+	}}, results)
+
+	// 1 invalid, 1 valid signature (in this order)
+	img = pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:latest")
+	defer img.Close()
+	results, err = pc.VerifySignatures(context.Background(), img)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.False(t, results[0].Accepted)
+	assert.Nil(t, results[0].Signature)
+	assert.Empty(t, results[0].KeyFingerprint)
+	assert.Nil(t, results[0].AcceptedBy)
+	assert.Error(t, results[0].Err)
+	assert.Equal(t, SignatureVerificationResult{
+		Accepted:       true,
+		Signature:      expectedSig,
+		KeyFingerprint: TestKeyFingerprint,
+		AcceptedBy:     []PolicyRequirement{signedBy},
+	}, results[1])
+
+	// sarAccepted+sarRejected for a signature
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:acceptReject")
+	defer img.Close()
+	results, err = pc.VerifySignatures(context.Background(), img)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.False(t, results[0].Accepted)
+	assert.IsType(t, PolicyRequirementError(""), results[0].Err)
+
+	// sarUnknown only
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:unknown")
+	defer img.Close()
+	results, err = pc.VerifySignatures(context.Background(), img)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.False(t, results[0].Accepted)
+	assert.Error(t, results[0].Err)
+
+	// No signatures
+	img = pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")
+	defer img.Close()
+	results, err = pc.VerifySignatures(context.Background(), img)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	// Failures: Make sure we return nil results.
+
+	// Unexpected state (context already destroyed)
+	destroyedPC, err := NewPolicyContext(pc.Policy)
+	require.NoError(t, err)
+	err = destroyedPC.Destroy()
+	require.NoError(t, err)
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	defer img.Close()
+	results, err = destroyedPC.VerifySignatures(context.Background(), img)
+	assert.Error(t, err)
+	assert.Nil(t, results)
+
+	// Error reading signatures.
+	invalidSigDir := createInvalidSigDir(t)
+	defer os.RemoveAll(invalidSigDir)
+	img = pcImageMock(t, invalidSigDir, "testing/manifest:latest")
+	defer img.Close()
+	results, err = pc.VerifySignatures(context.Background(), img)
+	assert.Error(t, err)
+	assert.Nil(t, results)
+}
+
 func TestPolicyContextIsRunningImageAllowed(t *testing.T) {
 	pc, err := NewPolicyContext(&Policy{
 		Default: PolicyRequirements{NewPRReject()},
@@ -363,62 +525,62 @@ func TestPolicyContextIsRunningImageAllowed(t *testing.T) {
 	// Success
 	img := pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
 	defer img.Close()
-	res, err := pc.IsRunningImageAllowed(img)
+	res, err := pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningAllowed(t, res, err)
 
 	// Two signatures
 	// FIXME? Use really different signatures for this?
 	img = pcImageMock(t, "fixtures/dir-img-valid-2", "testing/manifest:latest")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningAllowed(t, res, err)
 
 	// No signatures
 	img = pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningRejectedPolicyRequirement(t, res, err)
 
 	// Only invalid signatures
 	img = pcImageMock(t, "fixtures/dir-img-modified-manifest", "testing/manifest:latest")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningRejectedPolicyRequirement(t, res, err)
 
 	// 1 invalid, 1 valid signature (in this order)
 	img = pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:latest")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningAllowed(t, res, err)
 
 	// Two allowed results
 	img = pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:twoAllows")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningAllowed(t, res, err)
 
 	// Allow + deny results
 	img = pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:allowDeny")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningRejectedPolicyRequirement(t, res, err)
 
 	// prReject works
 	img = pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:reject")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningRejectedPolicyRequirement(t, res, err)
 
 	// prInsecureAcceptAnything works
 	img = pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:acceptAnything")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningAllowed(t, res, err)
 
 	// Empty list of requirements (invalid)
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:invalidEmptyRequirements")
 	defer img.Close()
-	res, err = pc.IsRunningImageAllowed(img)
+	res, err = pc.IsRunningImageAllowed(context.Background(), img)
 	assertRunningRejectedPolicyRequirement(t, res, err)
 
 	// Unexpected state (context already destroyed)
@@ -428,13 +590,98 @@ func TestPolicyContextIsRunningImageAllowed(t *testing.T) {
 	require.NoError(t, err)
 	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
 	defer img.Close()
-	res, err = destroyedPC.IsRunningImageAllowed(img)
+	res, err = destroyedPC.IsRunningImageAllowed(context.Background(), img)
 	assertRunningRejected(t, res, err)
 	// Not testing the pcInUse->pcReady transition, that would require custom PolicyRequirement
 	// implementations meddling with the state, or threads. This is for catching trivial programmer
 	// mistakes only, anyway.
 }
 
+func TestPolicyContextExplainRunningImage(t *testing.T) {
+	signedBy := xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchRepository())
+	reject := NewPRReject()
+	acceptAnything := NewPRInsecureAcceptAnything()
+	policy := &Policy{
+		Default: PolicyRequirements{reject},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"docker.io/testing/manifest:latest":                   {signedBy},
+				"docker.io/testing/manifest:denyAllow":                {reject, signedBy, acceptAnything},
+				"docker.io/testing/manifest:invalidEmptyRequirements": {},
+			},
+		},
+	}
+	pc, err := NewPolicyContext(policy)
+	require.NoError(t, err)
+	defer pc.Destroy()
+
+	// Success
+	img := pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	defer img.Close()
+	res, err := pc.ExplainRunningImage(context.Background(), img)
+	require.NoError(t, err)
+	assert.Equal(t, &PolicyExplanation{
+		Transport:    "docker",
+		Scope:        "docker.io/testing/manifest:latest",
+		Allowed:      true,
+		Requirements: []RequirementEvaluation{{Requirement: signedBy, Allowed: true}},
+	}, res)
+
+	// All requirements are evaluated, even after a rejection
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:denyAllow")
+	defer img.Close()
+	res, err = pc.ExplainRunningImage(context.Background(), img)
+	require.NoError(t, err)
+	assert.Equal(t, "docker.io/testing/manifest:denyAllow", res.Scope)
+	assert.False(t, res.Allowed)
+	require.Len(t, res.Requirements, 3)
+	assert.Equal(t, reject, res.Requirements[0].Requirement)
+	assert.False(t, res.Requirements[0].Allowed)
+	assert.IsType(t, PolicyRequirementError(""), res.Requirements[0].Err)
+	assert.Equal(t, RequirementEvaluation{Requirement: signedBy, Allowed: true}, res.Requirements[1])
+	assert.Equal(t, RequirementEvaluation{Requirement: acceptAnything, Allowed: true}, res.Requirements[2])
+
+	// A rejection reason is reported
+	img = pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")
+	defer img.Close()
+	res, err = pc.ExplainRunningImage(context.Background(), img)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	require.Len(t, res.Requirements, 1)
+	assert.False(t, res.Requirements[0].Allowed)
+	assert.IsType(t, PolicyRequirementError(""), res.Requirements[0].Err)
+
+	// The default policy section
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/other:latest")
+	defer img.Close()
+	res, err = pc.ExplainRunningImage(context.Background(), img)
+	require.NoError(t, err)
+	assert.Equal(t, "", res.Transport)
+	assert.Equal(t, "", res.Scope)
+	assert.False(t, res.Allowed)
+	require.Len(t, res.Requirements, 1)
+	assert.Equal(t, reject, res.Requirements[0].Requirement)
+
+	// Empty list of requirements (invalid)
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:invalidEmptyRequirements")
+	defer img.Close()
+	res, err = pc.ExplainRunningImage(context.Background(), img)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Empty(t, res.Requirements)
+
+	// Unexpected state (context already destroyed)
+	destroyedPC, err := NewPolicyContext(policy)
+	require.NoError(t, err)
+	err = destroyedPC.Destroy()
+	require.NoError(t, err)
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	defer img.Close()
+	res, err = destroyedPC.ExplainRunningImage(context.Background(), img)
+	assert.Error(t, err)
+	assert.Nil(t, res)
+}
+
 // Helpers for validating PolicyRequirement.isSignatureAuthorAccepted results:
 
 // assertSARRejected verifies that isSignatureAuthorAccepted returns a consistent sarRejected result
@@ -486,3 +733,55 @@ func assertRunningRejectedPolicyRequirement(t *testing.T, allowed bool, err erro
 	assertRunningRejected(t, allowed, err)
 	assert.IsType(t, PolicyRequirementError(""), err)
 }
+
+func TestPolicyContextPreparedRequirements(t *testing.T) {
+	signedBy := xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact())
+	missingKey := xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "/this/does/not/exist", NewPRMMatchExact())
+	pc, err := NewPolicyContext(&Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"docker.io/testing/manifest:latest":     {signedBy},
+				"docker.io/testing/manifest:missingKey": {missingKey},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	// The keys are imported once, and the prepared requirement is reused, also by concurrent evaluations.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			img := pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+			defer img.Close()
+			res, err := pc.IsRunningImageAllowed(context.Background(), img)
+			assertRunningAllowed(t, res, err)
+			sigs, err := pc.GetSignaturesWithAcceptedAuthor(context.Background(), img)
+			assert.NoError(t, err)
+			assert.Len(t, sigs, 1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, pcReady, pc.state)
+	require.Len(t, pc.prepared, 1)
+	prepared, ok := pc.prepared[signedBy].(*preparedSignedBy)
+	require.True(t, ok)
+	_, err = os.Stat(prepared.dir)
+	require.NoError(t, err)
+
+	// Failures to prepare a requirement are not cached, and are reported by the evaluation.
+	img := pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:missingKey")
+	defer img.Close()
+	res, err := pc.IsRunningImageAllowed(context.Background(), img)
+	assertRunningRejected(t, res, err)
+	assert.Len(t, pc.prepared, 1)
+
+	// Destroy removes the state of prepared requirements.
+	err = pc.Destroy()
+	require.NoError(t, err)
+	assert.Empty(t, pc.prepared)
+	_, err = os.Stat(prepared.dir)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -4,6 +4,7 @@ package signature
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/containers/image/docker/reference"
 	"github.com/containers/image/transports"
@@ -29,6 +30,11 @@ func (prm *prmMatchExact) matchesDockerReference(image types.UnparsedImage, sign
 	if err != nil {
 		return false
 	}
+	return matchExactReferences(intended, signature)
+}
+
+// matchExactReferences returns true if intended and signature are exactly the same reference, including a tag or digest.
+func matchExactReferences(intended, signature reference.Named) bool {
 	// Do not add default tags: image.Reference().DockerReference() should contain it already, and signatureDockerReference should be exact; so, verify that now.
 	if reference.IsNameOnly(intended) || reference.IsNameOnly(signature) {
 		return false
@@ -76,3 +82,36 @@ func (prm *prmExactRepository) matchesDockerReference(image types.UnparsedImage,
 	}
 	return signature.Name() == intended.Name()
 }
+
+// remapReferencePrefix returns ref with prefix replaced by signedPrefix, if prefix matches whole path components
+// of ref's full name; otherwise it returns ref unchanged.
+func remapReferencePrefix(ref reference.Named, prefix, signedPrefix string) (reference.Named, error) {
+	fullName := ref.FullName()
+	if fullName != prefix && !strings.HasPrefix(fullName, prefix+"/") {
+		return ref, nil
+	}
+	remapped := signedPrefix + fullName[len(prefix):]
+	switch r := ref.(type) {
+	case reference.Canonical:
+		remapped += "@" + r.Digest().String()
+	case reference.NamedTagged:
+		remapped += ":" + r.Tag()
+	}
+	res, err := reference.ParseNamed(remapped)
+	if err != nil {
+		return nil, fmt.Errorf("Error remapping %s to %s: %v", ref.String(), remapped, err)
+	}
+	return res, nil
+}
+
+func (prm *prmRemapIdentity) matchesDockerReference(image types.UnparsedImage, signatureDockerReference string) bool {
+	intended, signature, err := parseImageAndDockerReference(image, signatureDockerReference)
+	if err != nil {
+		return false
+	}
+	intended, err = remapReferencePrefix(intended, prm.Prefix, prm.SignedPrefix)
+	if err != nil {
+		return false
+	}
+	return matchExactReferences(intended, signature)
+}
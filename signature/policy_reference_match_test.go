@@ -1,6 +1,7 @@
 package signature
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -56,13 +57,10 @@ type refImageMock struct{ reference.Named }
 func (ref refImageMock) Reference() types.ImageReference {
 	return refImageReferenceMock{ref.Named}
 }
-func (ref refImageMock) Close() {
+func (ref refImageMock) Manifest(ctx context.Context) ([]byte, string, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageMock) Manifest() ([]byte, string, error) {
-	panic("unexpected call to a mock function")
-}
-func (ref refImageMock) Signatures() ([][]byte, error) {
+func (ref refImageMock) Signatures(ctx context.Context) ([][]byte, error) {
 	panic("unexpected call to a mock function")
 }
 
@@ -92,16 +90,16 @@ func (ref refImageReferenceMock) PolicyConfigurationIdentity() string {
 func (ref refImageReferenceMock) PolicyConfigurationNamespaces() []string {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageReferenceMock) NewImage(ctx *types.SystemContext) (types.Image, error) {
+func (ref refImageReferenceMock) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageReferenceMock) NewImageSource(ctx *types.SystemContext, requestedManifestMIMETypes []string) (types.ImageSource, error) {
+func (ref refImageReferenceMock) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageReferenceMock) NewImageDestination(ctx *types.SystemContext) (types.ImageDestination, error) {
+func (ref refImageReferenceMock) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref refImageReferenceMock) DeleteImage(ctx *types.SystemContext) error {
+func (ref refImageReferenceMock) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	panic("unexpected call to a mock function")
 }
 
@@ -252,13 +250,10 @@ type forbiddenImageMock struct{}
 func (ref forbiddenImageMock) Reference() types.ImageReference {
 	panic("unexpected call to a mock function")
 }
-func (ref forbiddenImageMock) Close() {
-	panic("unexpected call to a mock function")
-}
-func (ref forbiddenImageMock) Manifest() ([]byte, string, error) {
+func (ref forbiddenImageMock) Manifest(ctx context.Context) ([]byte, string, error) {
 	panic("unexpected call to a mock function")
 }
-func (ref forbiddenImageMock) Signatures() ([][]byte, error) {
+func (ref forbiddenImageMock) Signatures(ctx context.Context) ([][]byte, error) {
 	panic("unexpected call to a mock function")
 }
 
@@ -281,3 +276,49 @@ func TestPRMExactRepositoryMatchesDockerReference(t *testing.T) {
 		assert.Equal(t, test.result, res, fmt.Sprintf("%s vs. %s", test.imageRef, test.sigRef))
 	}
 }
+
+func TestPRMRemapIdentityMatchesDockerReference(t *testing.T) {
+	for _, c := range []struct {
+		prefix, signedPrefix string
+		imageRef, sigRef     string
+		result               bool
+	}{
+		// Remapping a namespace to another registry
+		{"mirror.example.com/upstream", "docker.io", "mirror.example.com/upstream/busybox:latest", "busybox:latest", true},
+		{"mirror.example.com/upstream", "docker.io", "mirror.example.com/upstream/busybox:latest", "docker.io/library/busybox:latest", true},
+		{"mirror.example.com/upstream", "docker.io/library", "mirror.example.com/upstream/busybox:latest", "busybox:latest", true},
+		{"mirror.example.com/upstream", "docker.io", "mirror.example.com/upstream/busybox:latest", "busybox:notlatest", false},
+		// Remapping a host name
+		{"mirror.example.com:5000", "registry.example.com", "mirror.example.com:5000/ns/repo:tag", "registry.example.com/ns/repo:tag", true},
+		{"mirror.example.com:5000", "registry.example.com", "mirror.example.com:5000/ns/repo:tag", "mirror.example.com:5000/ns/repo:tag", false},
+		// Remapping a single repository
+		{"mirror.example.com/ns/repo", "registry.example.com/other", "mirror.example.com/ns/repo:tag", "registry.example.com/other:tag", true},
+		{"mirror.example.com/ns/repo", "registry.example.com/other", "mirror.example.com/ns/repo:tag", "registry.example.com/other/repo:tag", false},
+		// Digests are preserved
+		{"mirror.example.com", "registry.example.com", "mirror.example.com/ns/repo@" + TestImageManifestDigest.String(),
+			"registry.example.com/ns/repo@" + TestImageManifestDigest.String(), true},
+		// The prefix must match whole path components
+		{"mirror.example.com/ns", "registry.example.com", "mirror.example.com/nsx/repo:tag", "registry.example.comx/repo:tag", false},
+		{"mirror.example.com/ns", "registry.example.com", "mirror.example.com/nsx/repo:tag", "mirror.example.com/nsx/repo:tag", true},
+		// Identities not matching the prefix are matched exactly
+		{"mirror.example.com/upstream", "docker.io", "busybox:latest", "busybox:latest", true},
+		{"mirror.example.com/upstream", "docker.io", "other.example.com/upstream/busybox:latest", "busybox:latest", false},
+		// Missing tags
+		{"mirror.example.com/upstream", "docker.io", "mirror.example.com/upstream/busybox", "busybox", false},
+		// Invalid signature reference
+		{"mirror.example.com/upstream", "docker.io", "mirror.example.com/upstream/busybox:latest", "UPPERCASE_IS_INVALID_IN_DOCKER_REFERENCES", false},
+	} {
+		prm, err := NewPRMRemapIdentity(c.prefix, c.signedPrefix)
+		require.NoError(t, err)
+		imageRef, err := reference.ParseNamed(c.imageRef)
+		require.NoError(t, err, c.imageRef)
+		res := prm.matchesDockerReference(refImageMock{imageRef}, c.sigRef)
+		assert.Equal(t, c.result, res, fmt.Sprintf("%s→%s: %s vs. %s", c.prefix, c.signedPrefix, c.imageRef, c.sigRef))
+	}
+
+	// Even if they are signed with an empty string as a reference, unidentified images are rejected.
+	prm, err := NewPRMRemapIdentity("mirror.example.com", "docker.io")
+	require.NoError(t, err)
+	res := prm.matchesDockerReference(refImageMock{nil}, "")
+	assert.False(t, res, `unidentified vs. ""`)
+}
@@ -6,6 +6,8 @@
 
 package signature
 
+import "time"
+
 // NOTE: Keep this in sync with docs/policy.json.md!
 
 // Policy defines requirements for considering a signature, or an image, valid.
@@ -46,6 +48,8 @@ const (
 	prTypeReject                 prTypeIdentifier = "reject"
 	prTypeSignedBy               prTypeIdentifier = "signedBy"
 	prTypeSignedBaseLayer        prTypeIdentifier = "signedBaseLayer"
+	prTypeSigstoreSigned         prTypeIdentifier = "sigstoreSigned"
+	prTypeSigstoreAttested       prTypeIdentifier = "sigstoreAttested"
 )
 
 // prInsecureAcceptAnything is a PolicyRequirement with type = prTypeInsecureAcceptAnything:
@@ -71,14 +75,21 @@ type prSignedBy struct {
 	// FIXME: eventually also support GPGTOFU, X.509TOFU, with KeyPath only
 	KeyType sbKeyType `json:"keyType"`
 
-	// KeyPath is a pathname to a local file containing the trusted key(s). Exactly one of KeyPath and KeyData must be specified.
+	// KeyPath is a pathname to a local file containing the trusted key(s). Exactly one of KeyPath, KeyPaths, KeyData and KeyDatas must be specified.
 	KeyPath string `json:"keyPath,omitempty"`
-	// KeyData contains the trusted key(s), base64-encoded. Exactly one of KeyPath and KeyData must be specified.
+	// KeyPaths is a set of pathnames to local files containing the trusted key(s). Exactly one of KeyPath, KeyPaths, KeyData and KeyDatas must be specified.
+	KeyPaths []string `json:"keyPaths,omitempty"`
+	// KeyData contains the trusted key(s), base64-encoded. Exactly one of KeyPath, KeyPaths, KeyData and KeyDatas must be specified.
 	KeyData []byte `json:"keyData,omitempty"`
+	// KeyDatas is a set of trusted key(s), each base64-encoded. Exactly one of KeyPath, KeyPaths, KeyData and KeyDatas must be specified.
+	KeyDatas [][]byte `json:"keyDatas,omitempty"`
 
 	// SignedIdentity specifies what image identity the signature must be claiming about the image.
 	// Defaults to "match-exact" if not specified.
 	SignedIdentity PolicyReferenceMatch `json:"signedIdentity"`
+
+	// SignatureTime, if not nil, restricts the accepted signature creation times.
+	SignatureTime PRSignatureTime `json:"signatureTime,omitempty"`
 }
 
 // sbKeyType are the allowed values for prSignedBy.KeyType
@@ -104,6 +115,87 @@ type prSignedBaseLayer struct {
 	BaseLayerIdentity PolicyReferenceMatch `json:"baseLayerIdentity"`
 }
 
+// prSigstoreSigned is a PolicyRequirement with type = prTypeSigstoreSigned: the image has a sigstore (cosign) signature
+// made by a trusted key, or by a key certified by Fulcio for a trusted identity, for a specified image identity.
+type prSigstoreSigned struct {
+	prCommon
+
+	// KeyPath is a pathname to a local file containing the trusted PEM-encoded public key(s).
+	// Exactly one of KeyPath, KeyPaths, KeyData, KeyDatas and Fulcio must be specified.
+	KeyPath string `json:"keyPath,omitempty"`
+	// KeyPaths is a set of pathnames to local files containing the trusted PEM-encoded public key(s); a signature by any of them is accepted.
+	// Exactly one of KeyPath, KeyPaths, KeyData, KeyDatas and Fulcio must be specified.
+	KeyPaths []string `json:"keyPaths,omitempty"`
+	// KeyData contains the trusted PEM-encoded public key(s), base64-encoded.
+	// Exactly one of KeyPath, KeyPaths, KeyData, KeyDatas and Fulcio must be specified.
+	KeyData []byte `json:"keyData,omitempty"`
+	// KeyDatas is a set of trusted PEM-encoded public key(s), each base64-encoded; a signature by any of them is accepted.
+	// Exactly one of KeyPath, KeyPaths, KeyData, KeyDatas and Fulcio must be specified.
+	KeyDatas [][]byte `json:"keyDatas,omitempty"`
+	// Fulcio specifies which Fulcio-issued certificates are trusted.
+	// Exactly one of KeyPath, KeyPaths, KeyData, KeyDatas and Fulcio must be specified.
+	// If Fulcio is specified, one of RekorPublicKeyPath and RekorPublicKeyData must be specified as well.
+	Fulcio PRSigstoreSignedFulcio `json:"fulcio,omitempty"`
+
+	// RekorPublicKeyPath is a pathname to a local file containing the trusted PEM-encoded Rekor public key.
+	// If specified, signatures must be recorded in the Rekor transparency log. At most one of RekorPublicKeyPath and RekorPublicKeyData may be specified.
+	RekorPublicKeyPath string `json:"rekorPublicKeyPath,omitempty"`
+	// RekorPublicKeyData contains the trusted PEM-encoded Rekor public key, base64-encoded.
+	// If specified, signatures must be recorded in the Rekor transparency log. At most one of RekorPublicKeyPath and RekorPublicKeyData may be specified.
+	RekorPublicKeyData []byte `json:"rekorPublicKeyData,omitempty"`
+
+	// SignedIdentity specifies what image identity the signature must be claiming about the image.
+	// Defaults to "match-exact" if not specified.
+	SignedIdentity PolicyReferenceMatch `json:"signedIdentity"`
+
+	// SignatureTime, if not nil, restricts the accepted signature creation times.
+	// If Rekor is used, the time recorded in the Rekor log is used instead of the time claimed by the signer.
+	SignatureTime PRSignatureTime `json:"signatureTime,omitempty"`
+}
+
+// PRSigstoreSignedFulcio specifies which Fulcio-issued certificates are trusted by a "sigstoreSigned" PolicyRequirement.
+// The type is public, but its implementation is private.
+
+// prSigstoreSignedFulcio contains Fulcio configuration options for a "sigstoreSigned" PolicyRequirement.
+type prSigstoreSignedFulcio struct {
+	// CAPath a path to a file containing the trusted PEM-encoded Fulcio CA certificate(s). Exactly one of CAPath and CAData must be specified.
+	CAPath string `json:"caPath,omitempty"`
+	// CAData contains the trusted PEM-encoded Fulcio CA certificate(s), base64-encoded. Exactly one of CAPath and CAData must be specified.
+	CAData []byte `json:"caData,omitempty"`
+	// OIDCIssuer specifies the expected OIDC issuer, recorded by Fulcio in the certificate.
+	OIDCIssuer string `json:"oidcIssuer"`
+	// SubjectEmail specifies the expected email address of the authenticated OIDC identity, recorded by Fulcio in the certificate.
+	SubjectEmail string `json:"subjectEmail"`
+}
+
+// prSigstoreAttested is a PolicyRequirement with type = prTypeSigstoreAttested: the image has an attached in-toto
+// attestation (e.g. SLSA build provenance) of a specified predicate type about the image, signed by a trusted key.
+type prSigstoreAttested struct {
+	prCommon
+
+	// KeyPath is a pathname to a local file containing the trusted PEM-encoded public key(s). Exactly one of KeyPath and KeyData must be specified.
+	KeyPath string `json:"keyPath,omitempty"`
+	// KeyData contains the trusted PEM-encoded public key(s), base64-encoded. Exactly one of KeyPath and KeyData must be specified.
+	KeyData []byte `json:"keyData,omitempty"`
+
+	// PredicateType is the required predicate type of the attestation, e.g. "https://slsa.dev/provenance/v0.2".
+	PredicateType string `json:"predicateType"`
+}
+
+// PRSignatureTime specifies which signature creation times are accepted by a "signedBy" or "sigstoreSigned" PolicyRequirement.
+// The type is public, but its implementation is private.
+
+// prSignatureTime contains signature creation time constraints for a "signedBy" or "sigstoreSigned" PolicyRequirement.
+// At least one of MaxAge, NotBefore and NotAfter must be specified.
+type prSignatureTime struct {
+	// MaxAge, if not empty, is a duration in the time.ParseDuration format, e.g. "720h"; signatures created longer ago are rejected.
+	MaxAge string `json:"maxAge,omitempty"`
+	// NotBefore, if not nil, causes signatures created before this time to be rejected.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	// NotAfter, if not nil, causes signatures created after this time to be rejected.
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+}
+
 // PolicyReferenceMatch specifies a set of image identities accepted in PolicyRequirement.
 // The type is public, but its implementation is private.
 
@@ -120,6 +212,7 @@ const (
 	prmTypeMatchRepository prmTypeIdentifier = "matchRepository"
 	prmTypeExactReference  prmTypeIdentifier = "exactReference"
 	prmTypeExactRepository prmTypeIdentifier = "exactRepository"
+	prmTypeRemapIdentity   prmTypeIdentifier = "remapIdentity"
 )
 
 // prmMatchExact is a PolicyReferenceMatch with type = prmMatchExact: the two references must match exactly.
@@ -143,3 +236,16 @@ type prmExactRepository struct {
 	prmCommon
 	DockerRepository string `json:"dockerRepository"`
 }
+
+// prmRemapIdentity is a PolicyReferenceMatch with type = prmRemapIdentity: like prmMatchExact,
+// except that a prefix of the image identity is first replaced by another prefix, e.g. so that images pulled from a mirror
+// match signatures created for the original registry.
+type prmRemapIdentity struct {
+	prmCommon
+	// Prefix is a host[:port], or a host[:port]/namespace[/namespace…]/repo, to be replaced in the image identity if it
+	// matches whole path components of the identity.
+	Prefix string `json:"prefix"`
+	// SignedPrefix is the value, of the same format as Prefix, which replaces Prefix before matching the signed identity.
+	SignedPrefix string `json:"signedPrefix"`
+	// Other fields may be added in the future, e.g. a remapped equivalent of matchRepository.
+}
@@ -0,0 +1,136 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	rekorHashedRekordKind       = "hashedrekord"
+	rekorHashedRekordAPIVersion = "0.0.1"
+)
+
+// rekorBundle is the Rekor bundle attached to a sigstore signature, as created by cosign.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte             `json:"SignedEntryTimestamp"`
+	Payload              rekorBundlePayload `json:"Payload"`
+}
+
+// rekorBundlePayload is the part of a Rekor log entry signed by the signed entry timestamp (SET).
+// NOTE: The fields must be listed in the lexicographic order of their JSON names, so that json.Marshal
+// produces the canonical form of the payload which Rekor has signed.
+type rekorBundlePayload struct {
+	Body           string `json:"body"` // Base64-encoded
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"` // Hex-encoded
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// rekorHashedRekord is the body of a Rekor log entry of the "hashedrekord" kind.
+type rekorHashedRekord struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"` // Hex-encoded
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"` // A PEM-encoded public key or certificate
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// parseRekorPublicKey parses a PEM-encoded Rekor public key.
+func parseRekorPublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	keys, err := parseSigstorePublicKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("Expected exactly one Rekor public key, found %d", len(keys))
+	}
+	key, ok := keys[0].(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Rekor public key is not an ECDSA key, but %T", keys[0])
+	}
+	return key, nil
+}
+
+// verifyRekorBundle verifies that bundleJSON contains a valid signed entry timestamp by rekorPublicKey, recording signature of payload.
+// It returns the time the entry was integrated into the log, and the public key recorded in the entry
+// (which the caller must compare with the key used to verify signature).
+func verifyRekorBundle(rekorPublicKey *ecdsa.PublicKey, bundleJSON []byte, payload, signature []byte) (time.Time, crypto.PublicKey, error) {
+	var bundle rekorBundle
+	if err := json.Unmarshal(bundleJSON, &bundle); err != nil {
+		return time.Time{}, nil, InvalidSignatureError{msg: fmt.Sprintf("Error parsing Rekor bundle: %v", err)}
+	}
+
+	canonicalPayload, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	digest := sha256.Sum256(canonicalPayload)
+	if !ecdsa.VerifyASN1(rekorPublicKey, digest[:], bundle.SignedEntryTimestamp) {
+		return time.Time{}, nil, PolicyRequirementError("Rekor signed entry timestamp is not valid")
+	}
+
+	bodyBytes, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, nil, InvalidSignatureError{msg: fmt.Sprintf("Error decoding Rekor entry body: %v", err)}
+	}
+	var body rekorHashedRekord
+	if err := json.Unmarshal(bodyBytes, &body); err != nil {
+		return time.Time{}, nil, InvalidSignatureError{msg: fmt.Sprintf("Error parsing Rekor entry body: %v", err)}
+	}
+	if body.Kind != rekorHashedRekordKind || body.APIVersion != rekorHashedRekordAPIVersion {
+		return time.Time{}, nil, InvalidSignatureError{msg: fmt.Sprintf("Unsupported Rekor entry kind %s, version %s", body.Kind, body.APIVersion)}
+	}
+	if body.Spec.Data.Hash.Algorithm != "sha256" {
+		return time.Time{}, nil, InvalidSignatureError{msg: fmt.Sprintf("Unsupported Rekor entry hash algorithm %s", body.Spec.Data.Hash.Algorithm)}
+	}
+	payloadHash := sha256.Sum256(payload)
+	if body.Spec.Data.Hash.Value != hex.EncodeToString(payloadHash[:]) {
+		return time.Time{}, nil, PolicyRequirementError("Rekor entry does not match the signed payload")
+	}
+	if !bytes.Equal(body.Spec.Signature.Content, signature) {
+		return time.Time{}, nil, PolicyRequirementError("Rekor entry does not match the signature")
+	}
+	entryKey, err := publicKeyFromPEM(body.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return time.Time{}, nil, InvalidSignatureError{msg: fmt.Sprintf("Error parsing public key in Rekor entry: %v", err)}
+	}
+
+	return time.Unix(bundle.Payload.IntegratedTime, 0), entryKey, nil
+}
+
+// publicKeyFromPEM returns the public key contained in a PEM-encoded public key or certificate.
+func publicKeyFromPEM(data []byte) (crypto.PublicKey, error) {
+	certs, err := parsePEMCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 1 {
+		return certs[0].PublicKey, nil
+	}
+	keys, err := parseSigstorePublicKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("Expected exactly one public key, found %d", len(keys))
+	}
+	return keys[0], nil
+}
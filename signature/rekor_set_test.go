@@ -0,0 +1,131 @@
+package signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rekorTestBody returns a hashedrekord entry body recording signature of payload by the PEM-encoded keyOrCertPEM.
+func rekorTestBody(t *testing.T, payload, signature, keyOrCertPEM []byte) rekorHashedRekord {
+	var body rekorHashedRekord
+	body.APIVersion = rekorHashedRekordAPIVersion
+	body.Kind = rekorHashedRekordKind
+	payloadHash := sha256.Sum256(payload)
+	body.Spec.Data.Hash.Algorithm = "sha256"
+	body.Spec.Data.Hash.Value = hex.EncodeToString(payloadHash[:])
+	body.Spec.Signature.Content = signature
+	body.Spec.Signature.PublicKey.Content = keyOrCertPEM
+	return body
+}
+
+// rekorTestBundle returns a JSON-encoded Rekor bundle containing body, integrated at integratedTime, signed by rekorKey.
+func rekorTestBundle(t *testing.T, rekorKey *ecdsa.PrivateKey, body rekorHashedRekord, integratedTime time.Time) []byte {
+	bodyJSON, err := json.Marshal(body)
+	require.NoError(t, err)
+	payload := rekorBundlePayload{
+		Body:           base64.StdEncoding.EncodeToString(bodyJSON),
+		IntegratedTime: integratedTime.Unix(),
+		LogID:          "0123456789abcdef",
+		LogIndex:       42,
+	}
+	canonical, err := json.Marshal(payload)
+	require.NoError(t, err)
+	digest := sha256.Sum256(canonical)
+	set, err := ecdsa.SignASN1(rand.Reader, rekorKey, digest[:])
+	require.NoError(t, err)
+	bundle, err := json.Marshal(rekorBundle{SignedEntryTimestamp: set, Payload: payload})
+	require.NoError(t, err)
+	return bundle
+}
+
+func TestRekorBundlePayloadCanonicalJSON(t *testing.T) {
+	res, err := json.Marshal(rekorBundlePayload{Body: "Ym9keQ==", IntegratedTime: 1, LogID: "abcd", LogIndex: 2})
+	require.NoError(t, err)
+	assert.Equal(t, `{"body":"Ym9keQ==","integratedTime":1,"logID":"abcd","logIndex":2}`, string(res))
+}
+
+func TestParseRekorPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyPEM := sigstoreTestPublicKeyPEM(t, key)
+
+	pk, err := parseRekorPublicKey(keyPEM)
+	require.NoError(t, err)
+	assert.Equal(t, key.Public(), pk)
+
+	for _, data := range [][]byte{
+		nil,
+		[]byte("this is not PEM"),
+		append(append([]byte{}, keyPEM...), keyPEM...),
+	} {
+		_, err := parseRekorPublicKey(data)
+		assert.Error(t, err, string(data))
+	}
+}
+
+func TestVerifyRekorBundle(t *testing.T) {
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherRekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signingKeyPEM := sigstoreTestPublicKeyPEM(t, signingKey)
+	payload := []byte("payload")
+	signature := []byte("signature")
+	integratedTime := time.Unix(time.Now().Unix(), 0)
+
+	validBody := rekorTestBody(t, payload, signature, signingKeyPEM)
+
+	// Success
+	bundle := rekorTestBundle(t, rekorKey, validBody, integratedTime)
+	tm, pk, err := verifyRekorBundle(&rekorKey.PublicKey, bundle, payload, signature)
+	require.NoError(t, err)
+	assert.Equal(t, integratedTime, tm)
+	assert.Equal(t, signingKey.Public(), pk)
+
+	// Invalid JSON
+	_, _, err = verifyRekorBundle(&rekorKey.PublicKey, []byte("{"), payload, signature)
+	assert.Error(t, err)
+	// SET by a different key
+	_, _, err = verifyRekorBundle(&otherRekorKey.PublicKey, bundle, payload, signature)
+	assert.Error(t, err)
+	// Modified integration time
+	var modified rekorBundle
+	err = json.Unmarshal(bundle, &modified)
+	require.NoError(t, err)
+	modified.Payload.IntegratedTime++
+	modifiedJSON, err := json.Marshal(modified)
+	require.NoError(t, err)
+	_, _, err = verifyRekorBundle(&rekorKey.PublicKey, modifiedJSON, payload, signature)
+	assert.Error(t, err)
+	// Different payload or signature
+	_, _, err = verifyRekorBundle(&rekorKey.PublicKey, bundle, []byte("other payload"), signature)
+	assert.Error(t, err)
+	_, _, err = verifyRekorBundle(&rekorKey.PublicKey, bundle, payload, []byte("other signature"))
+	assert.Error(t, err)
+
+	// Various invalid entry bodies
+	for _, fn := range []func(b *rekorHashedRekord){
+		func(b *rekorHashedRekord) { b.Kind = "rekord" },
+		func(b *rekorHashedRekord) { b.APIVersion = "0.0.2" },
+		func(b *rekorHashedRekord) { b.Spec.Data.Hash.Algorithm = "sha512" },
+		func(b *rekorHashedRekord) { b.Spec.Signature.PublicKey.Content = []byte("this is not PEM") },
+	} {
+		body := validBody
+		fn(&body)
+		bundle := rekorTestBundle(t, rekorKey, body, integratedTime)
+		_, _, err = verifyRekorBundle(&rekorKey.PublicKey, bundle, payload, signature)
+		assert.Error(t, err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/containers/image/version"
+	"github.com/opencontainers/go-digest"
 )
 
 const (
@@ -26,8 +27,9 @@ func (err InvalidSignatureError) Error() string {
 
 // Signature is a parsed content of a signature.
 type Signature struct {
-	DockerManifestDigest string // FIXME: more precise type?
-	DockerReference      string // FIXME: more precise type?
+	DockerManifestDigest digest.Digest
+	DockerReference      string    // FIXME: more precise type?
+	Timestamp            time.Time // The creation time of the signature, or zero if it is not recorded
 }
 
 // Wrap signature to add to it some methods which we don't want to make public.
@@ -40,7 +42,11 @@ var _ json.Marshaler = (*privateSignature)(nil)
 
 // MarshalJSON implements the json.Marshaler interface.
 func (s privateSignature) MarshalJSON() ([]byte, error) {
-	return s.marshalJSONWithVariables(time.Now().UTC().Unix(), "atomic "+version.Version)
+	timestamp := s.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	return s.marshalJSONWithVariables(timestamp.UTC().Unix(), "atomic "+version.Version)
 }
 
 // Implementation of MarshalJSON, with a caller-chosen values of the variable items to help testing.
@@ -50,7 +56,7 @@ func (s privateSignature) marshalJSONWithVariables(timestamp int64, creatorID st
 	}
 	critical := map[string]interface{}{
 		"type":     signatureType,
-		"image":    map[string]string{"docker-manifest-digest": s.DockerManifestDigest},
+		"image":    map[string]string{"docker-manifest-digest": s.DockerManifestDigest.String()},
 		"identity": map[string]string{"docker-reference": s.DockerReference},
 	}
 	optional := map[string]interface{}{
@@ -105,7 +111,14 @@ func (s *privateSignature) strictUnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
-	_ = optional // We don't use anything from here for now.
+	var timestamp time.Time
+	if _, ok := optional["timestamp"]; ok {
+		t, err := int64Field(optional, "timestamp")
+		if err != nil {
+			return err
+		}
+		timestamp = time.Unix(t, 0).UTC()
+	}
 
 	t, err := stringField(c, "type")
 	if err != nil {
@@ -122,11 +135,11 @@ func (s *privateSignature) strictUnmarshalJSON(data []byte) error {
 	if err := validateExactMapKeys(image, "docker-manifest-digest"); err != nil {
 		return err
 	}
-	digest, err := stringField(image, "docker-manifest-digest")
+	digestString, err := stringField(image, "docker-manifest-digest")
 	if err != nil {
 		return err
 	}
-	s.DockerManifestDigest = digest
+	s.DockerManifestDigest = digest.Digest(digestString)
 
 	identity, err := mapField(c, "identity")
 	if err != nil {
@@ -140,6 +153,7 @@ func (s *privateSignature) strictUnmarshalJSON(data []byte) error {
 		return err
 	}
 	s.DockerReference = reference
+	s.Timestamp = timestamp
 
 	return nil
 }
@@ -162,7 +176,7 @@ func (s privateSignature) sign(mech SigningMechanism, keyIdentity string) ([]byt
 type signatureAcceptanceRules struct {
 	validateKeyIdentity                func(string) error
 	validateSignedDockerReference      func(string) error
-	validateSignedDockerManifestDigest func(string) error
+	validateSignedDockerManifestDigest func(digest.Digest) error
 }
 
 // verifyAndExtractSignature verifies that unverifiedSignature has been signed, and that its principial components
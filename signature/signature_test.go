@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -37,6 +39,15 @@ func TestMarshalJSON(t *testing.T) {
 	// it doesn't fail. And call it through the JSON package for a good measure.
 	_, err = json.Marshal(s)
 	assert.NoError(t, err)
+
+	// An explicitly set timestamp is recorded
+	s = privateSignature{Signature{DockerManifestDigest: "digest!@#", DockerReference: "reference#@!", Timestamp: time.Unix(1464398954, 0)}}
+	marshaled, err = json.Marshal(s)
+	require.NoError(t, err)
+	var parsed mSI
+	err = json.Unmarshal(marshaled, &parsed)
+	require.NoError(t, err)
+	assert.Equal(t, 1464398954.0, x(parsed, "optional")["timestamp"])
 }
 
 // Return the result of modifying validJSON with fn and unmarshaling it into *sig
@@ -72,6 +83,7 @@ func TestUnmarshalJSON(t *testing.T) {
 		Signature{
 			DockerManifestDigest: "digest!@#",
 			DockerReference:      "reference#@!",
+			Timestamp:            time.Unix(1464398954, 0).UTC(),
 		},
 	}
 	validJSON, err := validSig.MarshalJSON()
@@ -115,6 +127,9 @@ func TestUnmarshalJSON(t *testing.T) {
 		func(v mSI) { x(v, "critical", "identity")["unexpected"] = 1 },
 		// Invalid "docker-reference"
 		func(v mSI) { x(v, "critical", "identity")["docker-reference"] = 1 },
+		// Invalid "timestamp"
+		func(v mSI) { x(v, "optional")["timestamp"] = "1464398954" },
+		func(v mSI) { x(v, "optional")["timestamp"] = 1.5 },
 	}
 	for _, fn := range breakFns {
 		err = tryUnmarshalModifiedSignature(t, &s, validJSON, fn)
@@ -133,6 +148,11 @@ func TestUnmarshalJSON(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, validSig, s)
 	}
+
+	// A missing "timestamp" is allowed, and results in a zero Timestamp
+	err = tryUnmarshalModifiedSignature(t, &s, validJSON, func(v mSI) { delete(x(v, "optional"), "timestamp") })
+	require.NoError(t, err)
+	assert.Equal(t, privateSignature{Signature{DockerManifestDigest: "digest!@#", DockerReference: "reference#@!"}}, s)
 }
 
 func TestSign(t *testing.T) {
@@ -143,6 +163,7 @@ func TestSign(t *testing.T) {
 		Signature{
 			DockerManifestDigest: "digest!@#",
 			DockerReference:      "reference#@!",
+			Timestamp:            time.Unix(1464398954, 0).UTC(),
 		},
 	}
 
@@ -163,7 +184,7 @@ func TestSign(t *testing.T) {
 			}
 			return nil
 		},
-		validateSignedDockerManifestDigest: func(signedDockerManifestDigest string) error {
+		validateSignedDockerManifestDigest: func(signedDockerManifestDigest digest.Digest) error {
 			if signedDockerManifestDigest != sig.DockerManifestDigest {
 				return fmt.Errorf("Unexpected signedDockerManifestDigest")
 			}
@@ -187,7 +208,11 @@ func TestVerifyAndExtractSignature(t *testing.T) {
 	mech, err := newGPGSigningMechanismInDirectory(testGPGHomeDirectory)
 	require.NoError(t, err)
 
-	type triple struct{ keyIdentity, signedDockerReference, signedDockerManifestDigest string }
+	type triple struct {
+		keyIdentity                string
+		signedDockerReference      string
+		signedDockerManifestDigest digest.Digest
+	}
 	var wanted, recorded triple
 	// recordingRules are a plausible signatureAcceptanceRules implementations, but equally
 	// importantly record that we are passing the correct values to the rule callbacks.
@@ -206,7 +231,7 @@ func TestVerifyAndExtractSignature(t *testing.T) {
 			}
 			return nil
 		},
-		validateSignedDockerManifestDigest: func(signedDockerManifestDigest string) error {
+		validateSignedDockerManifestDigest: func(signedDockerManifestDigest digest.Digest) error {
 			recorded.signedDockerManifestDigest = signedDockerManifestDigest
 			if signedDockerManifestDigest != wanted.signedDockerManifestDigest {
 				return fmt.Errorf("signedDockerManifestDigest mismatch")
@@ -0,0 +1,133 @@
+// Note: Consider the API unstable until the code supports at least three different image formats or transports.
+
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+)
+
+// Signer creates sigstore signatures using a single key, without requiring access to the private key material;
+// this allows the key to be held e.g. by AWS KMS, HashiCorp Vault, or a PKCS#11 token.
+// The signatures can be verified using the "sigstoreSigned" policy requirement.
+type Signer interface {
+	// PublicKey returns the public key corresponding to the signing key.
+	PublicKey() (crypto.PublicKey, error)
+	// SignPayload returns a raw signature of payload:
+	// for ECDSA keys, an ASN.1-encoded signature of the SHA-256 digest of payload;
+	// for RSA keys, a PKCS #1 v1.5 signature of the SHA-256 digest of payload;
+	// for Ed25519 keys, a signature of payload itself.
+	SignPayload(payload []byte) ([]byte, error)
+}
+
+// cryptoSigner is a Signer using a crypto.Signer.
+type cryptoSigner struct {
+	signer crypto.Signer
+}
+
+// NewCryptoSigner returns a Signer using signer, which may be a local private key (*ecdsa.PrivateKey, *rsa.PrivateKey, ed25519.PrivateKey),
+// or any of the crypto.Signer implementations provided by client libraries of key management services and hardware tokens.
+func NewCryptoSigner(signer crypto.Signer) (Signer, error) {
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("Unsupported signing key type %T", signer.Public())
+	}
+	return &cryptoSigner{signer: signer}, nil
+}
+
+// NewSignerFromPrivateKeyFile returns a Signer using the unencrypted PEM-encoded private key in path.
+func NewSignerFromPrivateKeyFile(path string) (Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSignerFromPrivateKey(data)
+}
+
+// NewSignerFromPrivateKey returns a Signer using the unencrypted PEM-encoded private key in data,
+// in the PKCS #8, SEC 1 (EC) or PKCS #1 (RSA) format.
+func NewSignerFromPrivateKey(data []byte) (Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM-encoded private key found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Unsupported private key PEM type %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing private key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok { // Coverage: all key types returned by the parsers above implement crypto.Signer
+		return nil, fmt.Errorf("Unsupported private key type %T", key)
+	}
+	return NewCryptoSigner(signer)
+}
+
+// PublicKey returns the public key corresponding to the signing key.
+func (s *cryptoSigner) PublicKey() (crypto.PublicKey, error) {
+	return s.signer.Public(), nil
+}
+
+// SignPayload returns a raw signature of payload.
+func (s *cryptoSigner) SignPayload(payload []byte) ([]byte, error) {
+	if _, ok := s.signer.Public().(ed25519.PublicKey); ok {
+		return s.signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// SignDockerManifestWithSigner returns a sigstore signature for manifest as the specified dockerReference, using signer.
+func SignDockerManifestWithSigner(m []byte, dockerReference string, signer Signer) (types.SigstoreSignature, error) {
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return types.SigstoreSignature{}, err
+	}
+	var p sigstorePayload
+	p.Critical.Identity.DockerReference = dockerReference
+	p.Critical.Image.DockerManifestDigest = manifestDigest
+	p.Critical.Type = sigstoreSignatureType
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return types.SigstoreSignature{}, err
+	}
+
+	sig, err := signer.SignPayload(payload)
+	if err != nil {
+		return types.SigstoreSignature{}, fmt.Errorf("Error signing payload: %v", err)
+	}
+	// Signers backed by remote services may be misconfigured to use a different key or algorithm; don't create signatures
+	// which can never be verified.
+	publicKey, err := signer.PublicKey()
+	if err != nil {
+		return types.SigstoreSignature{}, err
+	}
+	if err := verifySigstoreSignatureWithKey(publicKey, payload, sig); err != nil {
+		return types.SigstoreSignature{}, fmt.Errorf("Error verifying the created signature: %v", err)
+	}
+	return types.SigstoreSignature{Payload: payload, Signature: sig}, nil
+}
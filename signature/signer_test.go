@@ -0,0 +1,158 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingSigner is a Signer which always fails, or returns an unusable signature.
+type failingSigner struct {
+	publicKey crypto.PublicKey
+	signErr   error
+}
+
+func (s failingSigner) PublicKey() (crypto.PublicKey, error) {
+	return s.publicKey, nil
+}
+
+func (s failingSigner) SignPayload(payload []byte) ([]byte, error) {
+	if s.signErr != nil {
+		return nil, s.signErr
+	}
+	return []byte("this is not a signature"), nil
+}
+
+func TestNewCryptoSigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := NewCryptoSigner(ecKey)
+	require.NoError(t, err)
+	pk, err := signer.PublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, ecKey.Public(), pk)
+}
+
+func TestNewSignerFromPrivateKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	for _, c := range []struct {
+		key     crypto.Signer
+		pemType string
+		der     []byte
+	}{
+		{edKey, "PRIVATE KEY", pkcs8},
+		{ecKey, "EC PRIVATE KEY", sec1},
+		{rsaKey, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)},
+	} {
+		signer, err := NewSignerFromPrivateKey(pem.EncodeToMemory(&pem.Block{Type: c.pemType, Bytes: c.der}))
+		require.NoError(t, err, c.pemType)
+		pk, err := signer.PublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, c.key.Public(), pk, c.pemType)
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		[]byte("this is not PEM"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: sec1}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("this is not a key")}),
+	} {
+		_, err := NewSignerFromPrivateKey(data)
+		assert.Error(t, err, string(data))
+	}
+}
+
+func TestNewSignerFromPrivateKeyFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	tmpDir, err := ioutil.TempDir("", "signer-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "key.pem")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+
+	signer, err := NewSignerFromPrivateKeyFile(path)
+	require.NoError(t, err)
+	pk, err := signer.PublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, key.Public(), pk)
+
+	_, err = NewSignerFromPrivateKeyFile(filepath.Join(tmpDir, "this does not exist"))
+	assert.Error(t, err)
+}
+
+func TestSignDockerManifestWithSigner(t *testing.T) {
+	m, err := ioutil.ReadFile("fixtures/image.manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(m)
+	require.NoError(t, err)
+	rules := signatureAcceptanceRules{
+		validateSignedDockerReference: func(signedDockerReference string) error {
+			if signedDockerReference != "example.com/a/b:tag" {
+				return errors.New("Unexpected docker reference")
+			}
+			return nil
+		},
+		validateSignedDockerManifestDigest: func(signedDockerManifestDigest digest.Digest) error {
+			if signedDockerManifestDigest != manifestDigest {
+				return errors.New("Unexpected manifest digest")
+			}
+			return nil
+		},
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	for _, key := range []crypto.Signer{ecKey, rsaKey, edKey} {
+		signer, err := NewCryptoSigner(key)
+		require.NoError(t, err)
+		sig, err := SignDockerManifestWithSigner(m, "example.com/a/b:tag", signer)
+		require.NoError(t, err)
+		verified, err := verifyAndExtractSigstoreSignature(&sigstoreTrustRoot{publicKeys: []crypto.PublicKey{key.Public()}}, sig, rules)
+		require.NoError(t, err)
+		assert.Equal(t, &Signature{DockerManifestDigest: manifestDigest, DockerReference: "example.com/a/b:tag"}, verified)
+	}
+
+	// Signing failures
+	for _, signer := range []Signer{
+		failingSigner{publicKey: ecKey.Public(), signErr: errors.New("signing failed")},
+		failingSigner{publicKey: ecKey.Public()},
+	} {
+		sig, err := SignDockerManifestWithSigner(m, "example.com/a/b:tag", signer)
+		assert.Error(t, err)
+		assert.Equal(t, types.SigstoreSignature{}, sig)
+	}
+}
@@ -0,0 +1,198 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	sigstoreSignatureType = "cosign container image signature"
+)
+
+// sigstorePayload is the payload of a sigstore signature, in the cosign “simple signing” format.
+// Unlike privateSignature, the "optional" section may be arbitrary (or null), and extra fields are tolerated,
+// because various sigstore implementations add their own annotations.
+type sigstorePayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// parseSigstorePayload parses a sigstore signature payload into a Signature.
+func parseSigstorePayload(payload []byte) (*Signature, error) {
+	var p sigstorePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, InvalidSignatureError{msg: err.Error()}
+	}
+	if p.Critical.Type != sigstoreSignatureType {
+		return nil, InvalidSignatureError{msg: fmt.Sprintf("Unrecognized signature type %s", p.Critical.Type)}
+	}
+	if p.Critical.Image.DockerManifestDigest == "" {
+		return nil, InvalidSignatureError{msg: "Missing docker-manifest-digest in signature"}
+	}
+	if p.Critical.Identity.DockerReference == "" {
+		return nil, InvalidSignatureError{msg: "Missing docker-reference in signature"}
+	}
+	var timestamp time.Time
+	// The "optional" section is not standardized; ignore values which are not a plain Unix time.
+	if t, err := int64Field(p.Optional, "timestamp"); err == nil {
+		timestamp = time.Unix(t, 0).UTC()
+	}
+	return &Signature{
+		DockerManifestDigest: p.Critical.Image.DockerManifestDigest,
+		DockerReference:      p.Critical.Identity.DockerReference,
+		Timestamp:            timestamp,
+	}, nil
+}
+
+// parseSigstorePublicKeys parses one or more PEM-encoded public keys, as produced by (cosign generate-key-pair).
+func parseSigstorePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing public key: %v", err)
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("Unsupported public key type %T", key)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("No PEM-encoded public keys found")
+	}
+	return keys, nil
+}
+
+// verifySigstoreSignatureWithKey returns nil if signature is a valid signature of payload by key.
+func verifySigstoreSignatureWithKey(key crypto.PublicKey, payload, signature []byte) error {
+	digest := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], signature) {
+			return errors.New("ECDSA signature verification failed")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, signature) {
+			return errors.New("Ed25519 signature verification failed")
+		}
+		return nil
+	default: // Coverage: parseSigstorePublicKeys only returns the types above
+		return fmt.Errorf("Unsupported public key type %T", key)
+	}
+}
+
+// sigstoreTrustRoot contains the trust anchors used to verify sigstore signatures.
+type sigstoreTrustRoot struct {
+	publicKeys     []crypto.PublicKey // Trusted signing keys; empty if fulcio is set
+	fulcio         *fulcioTrustRoot   // Trusted issuer of signing certificates, or nil if publicKeys are used
+	rekorPublicKey *ecdsa.PublicKey   // If not nil, signatures must be recorded in a Rekor log using this key
+}
+
+// samePublicKey returns true if a and b are the same public key.
+func samePublicKey(a, b crypto.PublicKey) bool {
+	aEq, ok := a.(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	return ok && aEq.Equal(b)
+}
+
+// verifyAndExtractSigstoreSignature verifies that unverifiedSignature has been signed by a key trusted by trustRoot,
+// and that its principial components match expected values, as specified by rules, and returns it.
+// rules.validateKeyIdentity is not used; trustRoot determines which keys are accepted.
+func verifyAndExtractSigstoreSignature(trustRoot *sigstoreTrustRoot, unverifiedSignature types.SigstoreSignature, rules signatureAcceptanceRules) (*Signature, error) {
+	var signingTime time.Time
+	var rekorEntryKey crypto.PublicKey // nil if Rekor is not used
+	if trustRoot.rekorPublicKey != nil {
+		if unverifiedSignature.RekorBundle == nil {
+			return nil, PolicyRequirementError("A Rekor bundle is required, but the signature does not contain one")
+		}
+		t, key, err := verifyRekorBundle(trustRoot.rekorPublicKey, unverifiedSignature.RekorBundle, unverifiedSignature.Payload, unverifiedSignature.Signature)
+		if err != nil {
+			return nil, err
+		}
+		signingTime = t
+		rekorEntryKey = key
+	}
+
+	var candidateKeys []crypto.PublicKey
+	if trustRoot.fulcio != nil {
+		if trustRoot.rekorPublicKey == nil { // Coverage: newPRSigstoreSigned rejects this
+			return nil, errors.New("Internal inconsistency: Fulcio certificates can only be verified using Rekor")
+		}
+		if unverifiedSignature.Certificate == nil {
+			return nil, PolicyRequirementError("A Fulcio certificate is required, but the signature does not contain one")
+		}
+		key, err := trustRoot.fulcio.verifyFulcioCertificate(signingTime, unverifiedSignature.Certificate, unverifiedSignature.CertificateChain)
+		if err != nil {
+			return nil, err
+		}
+		candidateKeys = []crypto.PublicKey{key}
+	} else {
+		candidateKeys = trustRoot.publicKeys
+	}
+
+	verifyErr := errors.New("no trusted keys")
+	verified := false
+	for _, key := range candidateKeys {
+		if rekorEntryKey != nil && !samePublicKey(key, rekorEntryKey) {
+			verifyErr = errors.New("the key does not match the Rekor entry")
+			continue
+		}
+		if verifyErr = verifySigstoreSignatureWithKey(key, unverifiedSignature.Payload, unverifiedSignature.Signature); verifyErr == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, PolicyRequirementError(fmt.Sprintf("Signature is not signed by a trusted key: %v", verifyErr))
+	}
+
+	unmatchedSignature, err := parseSigstorePayload(unverifiedSignature.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := rules.validateSignedDockerManifestDigest(unmatchedSignature.DockerManifestDigest); err != nil {
+		return nil, err
+	}
+	if err := rules.validateSignedDockerReference(unmatchedSignature.DockerReference); err != nil {
+		return nil, err
+	}
+	if !signingTime.IsZero() {
+		// The time recorded by Rekor is authenticated by the log, unlike the time claimed in the payload.
+		unmatchedSignature.Timestamp = signingTime
+	}
+	return unmatchedSignature, nil // Policy OK.
+}
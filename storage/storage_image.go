@@ -0,0 +1,715 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/compression"
+	"github.com/containers/image/pkg/putblobdigest"
+	"github.com/containers/image/pkg/tmpdir"
+	"github.com/containers/image/types"
+	"github.com/containers/storage"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// manifestBigDataKey is the key under which the manifest of an image is stored.
+	manifestBigDataKey = "manifest"
+	// signaturesBigDataKey is the key under which the concatenated signatures of an image are stored.
+	signaturesBigDataKey = "signatures"
+)
+
+var (
+	// ErrBlobDigestMismatch is returned when PutBlob() is given a blob
+	// with a digest-based name that doesn't match its contents.
+	ErrBlobDigestMismatch = errors.New("blob digest mismatch")
+	// ErrBlobSizeMismatch is returned when PutBlob() is given a blob
+	// with an expected size that doesn't match the reader.
+	ErrBlobSizeMismatch = errors.New("blob size mismatch")
+	// ErrNoSuchImage is returned when we attempt to access an image which
+	// doesn't exist in the storage area.
+	ErrNoSuchImage = storage.ErrImageUnknown
+)
+
+// storageImageMetadata is recorded as the metadata of images we create, so that
+// blobs referenced by the manifest can be found again when reading the image.
+type storageImageMetadata struct {
+	// Layers maps digests of layer blobs, as referenced by the manifest, to the IDs of the layers they were applied as.
+	Layers map[digest.Digest]string `json:"layers,omitempty"`
+	// DiffIDs maps digests of layer blobs, as referenced by the manifest, to the digests of their uncompressed contents,
+	// which are what the store returns for the layers.
+	DiffIDs map[digest.Digest]digest.Digest `json:"diff-ids,omitempty"`
+	// SignatureSizes records the sizes of the signatures concatenated in the signaturesBigDataKey data item.
+	SignatureSizes []int `json:"signature-sizes,omitempty"`
+}
+
+type storageImageSource struct {
+	imageRef storageReference
+	ID       string
+	metadata storageImageMetadata
+	// diffIDLayers maps the uncompressed digests of the layers, as returned by LayerInfosForCopy, to the IDs of the layers.
+	diffIDLayers map[digest.Digest]string
+}
+
+// newImageSource returns an ImageSource for reading an image from the store.
+// The caller must call .Close() on the returned ImageSource.
+func newImageSource(imageRef storageReference) (*storageImageSource, error) {
+	img, err := imageRef.resolveImage()
+	if err != nil {
+		return nil, err
+	}
+	image := &storageImageSource{
+		imageRef:     imageRef,
+		ID:           img.ID,
+		diffIDLayers: map[digest.Digest]string{},
+	}
+	if img.Metadata != "" {
+		if err := json.Unmarshal([]byte(img.Metadata), &image.metadata); err != nil {
+			return nil, fmt.Errorf("Error decoding metadata of image %s: %v", img.ID, err)
+		}
+	}
+	for blobDigest, diffID := range image.metadata.DiffIDs {
+		if layerID, ok := image.metadata.Layers[blobDigest]; ok {
+			image.diffIDLayers[diffID] = layerID
+		}
+	}
+	return image, nil
+}
+
+// Reference returns the reference used to set up this source, _as specified by the user_
+// (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
+func (s *storageImageSource) Reference() types.ImageReference {
+	return s.imageRef
+}
+
+// Close removes resources associated with an initialized ImageSource, if any.
+func (s *storageImageSource) Close() {
+}
+
+// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
+// It may use a remote (= slow) service.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+func (s *storageImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return nil, "", fmt.Errorf("Getting target manifest not supported by containers-storage:")
+	}
+	m, err := s.imageRef.transport.store.ImageBigData(s.ID, manifestBigDataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return m, manifest.GuessMIMEType(m), nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *storageImageSource) HasThreadSafeGetBlob() bool {
+	return false
+}
+
+// GetBlob returns a stream for the specified blob, and the blob's size (or -1 if unknown).
+// Layers are returned as uncompressed diffs, which match the blobs returned by LayerInfosForCopy; for layers which were compressed
+// when written to the store, the data does not match the digest used in the manifest.
+func (s *storageImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	layerID, ok := s.diffIDLayers[info.Digest]
+	if !ok {
+		layerID, ok = s.metadata.Layers[info.Digest]
+	}
+	if ok {
+		rc, err := s.imageRef.transport.store.Diff("", layerID)
+		if err != nil {
+			return nil, -1, err
+		}
+		return rc, -1, nil
+	}
+	// Anything else, most importantly the config, was stored as a data item.
+	b, err := s.imageRef.transport.store.ImageBigData(s.ID, info.Digest.String())
+	if err != nil {
+		return nil, -1, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+}
+
+// LayerInfosForCopy returns the layers of the image as returned by GetBlob, i.e. uncompressed, or nil if they match the manifest.
+func (s *storageImageSource) LayerInfosForCopy(ctx context.Context) ([]types.BlobInfo, error) {
+	img, err := image.FromUnparsedImage(ctx, nil, image.UnparsedFromSource(s))
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the manifest of image %q: %v", s.ID, err)
+	}
+
+	manifestInfos := img.LayerInfos()
+	res := make([]types.BlobInfo, len(manifestInfos))
+	differs := false
+	for i, info := range manifestInfos {
+		layerID, ok := s.metadata.Layers[info.Digest]
+		if !ok {
+			return nil, fmt.Errorf("Layer %s of image %q was not found in the store", info.Digest, s.ID)
+		}
+		diffID, ok := s.metadata.DiffIDs[info.Digest]
+		if !ok {
+			return nil, fmt.Errorf("The uncompressed digest of layer %s of image %q is not known", info.Digest, s.ID)
+		}
+		size := int64(-1)
+		if layer, err := s.imageRef.transport.store.Layer(layerID); err == nil && layer.UncompressedSize > 0 {
+			size = layer.UncompressedSize
+		}
+		res[i] = types.BlobInfo{Digest: diffID, Size: size, MediaType: info.MediaType}
+		if diffID != info.Digest {
+			res[i].CompressionOperation = types.Decompress
+			differs = true
+		}
+	}
+	if !differs {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// GetSignatures returns the image's signatures.  It may use a remote (= slow) service.
+func (s *storageImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	if instanceDigest != nil {
+		return nil, errors.New("Manifest lists are not supported by the containers-storage: transport")
+	}
+	sigs := [][]byte{}
+	if len(s.metadata.SignatureSizes) == 0 {
+		return sigs, nil
+	}
+	signatureBlob, err := s.imageRef.transport.store.ImageBigData(s.ID, signaturesBigDataKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up signatures data for image %q: %v", s.ID, err)
+	}
+	offset := 0
+	for _, length := range s.metadata.SignatureSizes {
+		if offset+length > len(signatureBlob) {
+			return nil, fmt.Errorf("Error looking up signatures data for image %q: expected at least %d bytes, only found %d", s.ID, offset+length, len(signatureBlob))
+		}
+		sigs = append(sigs, signatureBlob[offset:offset+length])
+		offset += length
+	}
+	return sigs, nil
+}
+
+type storageImageDestination struct {
+	imageRef   storageReference
+	directory  string   // Temporary directory where we store blobs until Commit() time
+	manifest   []byte   // Manifest contents, temporary
+	signatures [][]byte // Signature contents, temporary
+
+	lock        sync.Mutex                      // Protects the fields below, which are used by concurrent PutBlob and TryReusingBlob calls
+	blobDiffIDs map[digest.Digest]digest.Digest // Mapping from layer blobsums to their corresponding DiffIDs
+	fileSizes   map[digest.Digest]int64         // Mapping from layer blobsums to their sizes
+	filenames   map[digest.Digest]string        // Mapping from layer blobsums to names of files we used to hold them
+	// cache is the cache most recently passed to TryReusingBlob, if any; PutBlob records the DiffIDs it computes in it.
+	cache types.BlobInfoCache
+	// layers are the blobsums of the layers of the image, in the order they are stacked, if known (see NoteLayerOrder).
+	layers []digest.Digest
+	// applying is true while a call to applyReadyLayers is applying layers; layers must be applied one at a time, in order.
+	applying bool
+	// appliedCount is the number of entries of layers already applied to the store; appliedChainID is their chain ID,
+	// and appliedTopLayer is the ID of the last one ("" if none).
+	appliedCount    int
+	appliedChainID  digest.Digest
+	appliedTopLayer string
+	// layerIDs maps blobsums of the applied layers to the IDs of the layers in the store.
+	layerIDs map[digest.Digest]string
+}
+
+// newImageDestination returns an ImageDestination for writing an image into the store.
+// Blobs are staged in a temporary directory; if the order of the layers is known (see NoteLayerOrder), each layer is applied
+// to the store as soon as the layers below it have been, otherwise they are all applied by Commit.
+func newImageDestination(sys *types.SystemContext, imageRef storageReference) (*storageImageDestination, error) {
+	directory, err := ioutil.TempDir(tmpdir.TemporaryDirectoryForBigFiles(sys), "storage")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating a temporary directory: %v", err)
+	}
+	return &storageImageDestination{
+		imageRef:    imageRef,
+		directory:   directory,
+		blobDiffIDs: make(map[digest.Digest]digest.Digest),
+		fileSizes:   make(map[digest.Digest]int64),
+		filenames:   make(map[digest.Digest]string),
+		layerIDs:    make(map[digest.Digest]string),
+	}, nil
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (s *storageImageDestination) Reference() types.ImageReference {
+	return s.imageRef
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+func (s *storageImageDestination) Close() {
+	os.RemoveAll(s.directory)
+}
+
+func (s *storageImageDestination) SupportedManifestMIMETypes() []string {
+	return nil
+}
+
+// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
+// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
+func (s *storageImageDestination) SupportsSignatures(ctx context.Context) error {
+	return nil
+}
+
+// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+func (s *storageImageDestination) DesiredLayerCompression() types.LayerCompression {
+	// We ultimately have to decompress layers to populate trees on disk,
+	// so callers shouldn't bother compressing them before handing them to
+	// us, if they're not already compressed.
+	return types.PreserveOriginal
+}
+
+// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+func (s *storageImageDestination) HasThreadSafePutBlob() bool {
+	return true
+}
+
+// PutBlob writes contents of stream and returns data representing the result (with all data filled in).
+// inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+// so the implementation may use inputInfo.Digest instead of computing the digest again.
+// inputInfo.Size is the expected length of stream, if known.
+// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+// otherwise the caller assumes they match inputInfo.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
+func (s *storageImageDestination) PutBlob(ctx context.Context, stream io.Reader, blobinfo types.BlobInfo, isConfig bool) (types.BlobInfo, error) {
+	// Stores a layer or data blob in our temporary directory, checking that any information
+	// in the blobinfo matches the incoming data.
+	errorBlobInfo := types.BlobInfo{
+		Digest: "",
+		Size:   -1,
+	}
+	// Set up to digest the blob and count its size while saving it to a file.
+	digester, stream := putblobdigest.DigestIfUnverified(stream, blobinfo)
+	file, err := ioutil.TempFile(s.directory, "blob")
+	if err != nil {
+		return errorBlobInfo, err
+	}
+	filename := file.Name()
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			os.Remove(filename)
+		}
+	}()
+	size, err := io.Copy(file, stream)
+	file.Close()
+	if err != nil {
+		return errorBlobInfo, err
+	}
+	computedDigest := digester.Digest()
+	if blobinfo.Digest != "" && computedDigest != blobinfo.Digest {
+		return errorBlobInfo, ErrBlobDigestMismatch
+	}
+	if blobinfo.Size >= 0 && size != blobinfo.Size {
+		return errorBlobInfo, ErrBlobSizeMismatch
+	}
+	if !isConfig {
+		// Compute the digest of the uncompressed contents, which is the layer's DiffID.
+		diffID, err := computeDiffID(filename)
+		if err != nil {
+			return errorBlobInfo, fmt.Errorf("Error computing the uncompressed digest of layer %s: %v", computedDigest, err)
+		}
+		s.lock.Lock()
+		s.blobDiffIDs[computedDigest] = diffID
+		cache := s.cache
+		s.lock.Unlock()
+		if cache != nil {
+			cache.RecordDigestUncompressedPair(computedDigest, diffID)
+		}
+	}
+	// Record information about the blob.
+	s.lock.Lock()
+	s.fileSizes[computedDigest] = size
+	s.filenames[computedDigest] = filename
+	s.lock.Unlock()
+	succeeded = true
+	if !isConfig {
+		if err := s.applyReadyLayers(); err != nil {
+			return errorBlobInfo, err
+		}
+	}
+	return types.BlobInfo{
+		Digest: computedDigest,
+		Size:   size,
+	}, nil
+}
+
+// computeDiffID returns the digest of the uncompressed contents of the file at path.
+func computeDiffID(path string) (digest.Digest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	uncompressed, _, err := compression.DetectCompression(file)
+	if err != nil {
+		return "", err
+	}
+	defer uncompressed.Close()
+
+	return digest.Canonical.FromReader(uncompressed)
+}
+
+// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob; in that case the returned info may not match the input.
+// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+// May use and/or update cache.
+// Layers are only reused if the order of the layers is known (see NoteLayerOrder): a layer is reused if cache knows the DiffIDs
+// of the layers up to it, and the store already contains a layer with the resulting chain ID, so that its blob is not needed.
+func (s *storageImageDestination) TryReusingBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache, canSubstitute bool) (bool, types.BlobInfo, error) {
+	if info.Digest == "" {
+		return false, types.BlobInfo{}, errors.New("Can not check for a blob with unknown digest")
+	}
+	s.lock.Lock()
+	s.cache = cache
+	layers := s.layers
+	s.lock.Unlock()
+	if info.Size == -1 {
+		return false, types.BlobInfo{}, nil // We must report the size of the blob, and we won't have it.
+	}
+	diffID := cache.UncompressedDigest(info.Digest)
+	if diffID == "" {
+		return false, types.BlobInfo{}, nil
+	}
+
+	// The layer may occur several times in the image; each of the resulting layers must already exist.
+	last := -1
+	for i, layer := range layers {
+		if layer == info.Digest {
+			last = i
+		}
+	}
+	if last == -1 {
+		return false, types.BlobInfo{}, nil
+	}
+	chainID := digest.Digest("")
+	for _, layer := range layers[:last+1] {
+		layerDiffID := cache.UncompressedDigest(layer)
+		if layerDiffID == "" {
+			return false, types.BlobInfo{}, nil
+		}
+		chainID = nextChainID(chainID, layerDiffID)
+		if layer != info.Digest {
+			continue
+		}
+		l, err := s.imageRef.transport.store.Layer(chainID.Hex())
+		if err != nil || l == nil {
+			if err != nil && err != storage.ErrLayerUnknown {
+				return false, types.BlobInfo{}, fmt.Errorf("Error looking up layer %s: %v", chainID.Hex(), err)
+			}
+			return false, types.BlobInfo{}, nil
+		}
+	}
+	logrus.Debugf("Reusing existing layers for blob %s", info.Digest)
+	s.lock.Lock()
+	s.blobDiffIDs[info.Digest] = diffID
+	s.lock.Unlock()
+	if err := s.applyReadyLayers(); err != nil {
+		return false, types.BlobInfo{}, err
+	}
+	return true, types.BlobInfo{Digest: info.Digest, Size: info.Size}, nil
+}
+
+// NoteLayerOrder records the order of the layers of the image, so that they can be applied to the store as soon as possible.
+func (s *storageImageDestination) NoteLayerOrder(layers []types.BlobInfo) {
+	digests := make([]digest.Digest, len(layers))
+	for i, layer := range layers {
+		digests[i] = layer.Digest
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setLayerOrderLocked(digests)
+}
+
+// setLayerOrderLocked sets s.layers to layers, forgetting about any layers applied so far if they don't match.
+// The caller must hold s.lock.
+func (s *storageImageDestination) setLayerOrderLocked(layers []digest.Digest) {
+	if s.appliedCount > len(layers) || !digestsEqual(s.layers[:s.appliedCount], layers[:s.appliedCount]) {
+		// Layers already applied to the store are harmless, and will be reused if they match after all.
+		s.appliedCount = 0
+		s.appliedChainID = ""
+		s.appliedTopLayer = ""
+		s.layerIDs = make(map[digest.Digest]string)
+	}
+	s.layers = layers
+}
+
+// digestsEqual returns true iff a and b contain the same digests in the same order.
+func digestsEqual(a, b []digest.Digest) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyReadyLayers applies layers from s.layers to the store, in order, until reaching a layer which is not available yet.
+// If another call is already applying layers, it leaves the work to that call, which will notice any newly available layers.
+func (s *storageImageDestination) applyReadyLayers() error {
+	store := s.imageRef.transport.store
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.applying {
+		return nil
+	}
+	s.applying = true
+	defer func() { s.applying = false }()
+	for s.appliedCount < len(s.layers) {
+		blobDigest := s.layers[s.appliedCount]
+		diffID, ok := s.blobDiffIDs[blobDigest]
+		if !ok {
+			return nil // Not available yet
+		}
+		filename, haveFile := s.filenames[blobDigest]
+		chainID := nextChainID(s.appliedChainID, diffID)
+		parent := s.appliedTopLayer
+		id := chainID.Hex()
+
+		s.lock.Unlock()
+		err := func() error {
+			if layer, err := store.Layer(id); err == nil && layer != nil {
+				logrus.Debugf("Reusing layer %s for blob %s", id, blobDigest)
+				return nil
+			}
+			if !haveFile {
+				return fmt.Errorf("Error applying layer %s: the blob was not stored, and layer %s does not exist", blobDigest, id)
+			}
+			file, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, _, err := store.PutLayer(id, parent, nil, "", false, file); err != nil {
+				return fmt.Errorf("Error applying layer %s: %v", blobDigest, err)
+			}
+			logrus.Debugf("Applied blob %s as layer %s", blobDigest, id)
+			return nil
+		}()
+		s.lock.Lock()
+		if err != nil {
+			return err
+		}
+		s.appliedCount++
+		s.appliedChainID = chainID
+		s.appliedTopLayer = id
+		s.layerIDs[blobDigest] = id
+	}
+	return nil
+}
+
+// nextChainID returns the chain ID of a layer with diffID stacked on top of layers with chain ID parent ("" if none).
+func nextChainID(parent, diffID digest.Digest) digest.Digest {
+	if parent == "" {
+		return diffID
+	}
+	return digest.Canonical.FromBytes([]byte(parent.String() + " " + diffID.String()))
+}
+
+func (s *storageImageDestination) PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the containers-storage: transport")
+	}
+	s.manifest = make([]byte, len(manifest))
+	copy(s.manifest, manifest)
+	return nil
+}
+
+func (s *storageImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New("Manifest lists are not supported by the containers-storage: transport")
+	}
+	s.signatures = make([][]byte, len(signatures))
+	for i, sig := range signatures {
+		s.signatures[i] = make([]byte, len(sig))
+		copy(s.signatures[i], sig)
+	}
+	return nil
+}
+
+// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+// Any layers referenced by the manifest which were not applied yet are applied to the store in order, reusing any layers with the
+// same chain ID which are already present, and the image is created on top of the last layer.
+// WARNING: This does not have any transactional semantics:
+// - Layers applied to the store before a failure are not removed.
+func (s *storageImageDestination) Commit(ctx context.Context) error {
+	if s.manifest == nil {
+		return errors.New("Internal error: storageImageDestination.Commit() called without PutManifest()")
+	}
+	img, err := image.FromSource(ctx, nil, &stagedImageSource{s})
+	if err != nil {
+		return fmt.Errorf("Error parsing the manifest: %v", err)
+	}
+	defer img.Close()
+
+	// Apply any layers not applied yet, using the layers from the manifest in case they differ from the ones we were told to expect.
+	layers := []digest.Digest{}
+	for _, blob := range img.LayerInfos() {
+		layers = append(layers, blob.Digest)
+	}
+	s.lock.Lock()
+	s.setLayerOrderLocked(layers)
+	s.lock.Unlock()
+	if err := s.applyReadyLayers(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	appliedCount, lastLayer := s.appliedCount, s.appliedTopLayer
+	s.lock.Unlock()
+	if appliedCount < len(layers) {
+		return fmt.Errorf("Error determining the uncompressed digest of layer %s", layers[appliedCount])
+	}
+
+	store := s.imageRef.transport.store
+	metadata := storageImageMetadata{Layers: map[digest.Digest]string{}, DiffIDs: map[digest.Digest]digest.Digest{}}
+	for blobDigest, id := range s.layerIDs {
+		metadata.Layers[blobDigest] = id
+		metadata.DiffIDs[blobDigest] = s.blobDiffIDs[blobDigest]
+	}
+	for _, sig := range s.signatures {
+		metadata.SignatureSizes = append(metadata.SignatureSizes, len(sig))
+	}
+	metadataBytes, err := json.Marshal(&metadata)
+	if err != nil {
+		return err
+	}
+
+	// The image ID is the ID of the config, if there is one, following Docker; otherwise use the manifest digest.
+	id := s.imageRef.id
+	if id == "" {
+		if config := img.ConfigInfo(); config.Digest != "" {
+			id = config.Digest.Hex()
+		} else {
+			manifestDigest, err := manifest.Digest(s.manifest)
+			if err != nil {
+				return err
+			}
+			id = manifestDigest.Hex()
+		}
+	}
+	names := []string{}
+	if s.imageRef.reference != "" {
+		names = append(names, s.imageRef.reference)
+	}
+	if _, err := store.CreateImage(id, names, lastLayer, string(metadataBytes), nil); err != nil {
+		if err != storage.ErrDuplicateID {
+			return fmt.Errorf("Error creating image %q: %v", id, err)
+		}
+		// The image already exists; update it to match what we were asked to store.
+		existing, err := store.Image(id)
+		if err != nil {
+			return fmt.Errorf("Error reading image %q: %v", id, err)
+		}
+		if existing.TopLayer != lastLayer {
+			return fmt.Errorf("Image %q already exists with a different top layer", id)
+		}
+		if err := store.SetNames(id, mergeNames(existing.Names, names)); err != nil {
+			return fmt.Errorf("Error setting names of image %q: %v", id, err)
+		}
+		if err := store.SetMetadata(id, string(metadataBytes)); err != nil {
+			return fmt.Errorf("Error setting metadata of image %q: %v", id, err)
+		}
+	}
+	logrus.Debugf("Created image %q with top layer %q", id, lastLayer)
+
+	if config := img.ConfigInfo(); config.Digest != "" {
+		configBlob, err := img.ConfigBlob(ctx)
+		if err != nil {
+			return err
+		}
+		if err := store.SetImageBigData(id, config.Digest.String(), configBlob); err != nil {
+			return fmt.Errorf("Error saving config of image %q: %v", id, err)
+		}
+	}
+	if err := store.SetImageBigData(id, manifestBigDataKey, s.manifest); err != nil {
+		return fmt.Errorf("Error saving manifest of image %q: %v", id, err)
+	}
+	if err := store.SetImageBigData(id, signaturesBigDataKey, bytes.Join(s.signatures, nil)); err != nil {
+		return fmt.Errorf("Error saving signatures of image %q: %v", id, err)
+	}
+	return nil
+}
+
+// mergeNames returns names with any elements of newNames it does not yet contain appended.
+func mergeNames(names, newNames []string) []string {
+	res := append([]string{}, names...)
+	for _, newName := range newNames {
+		found := false
+		for _, name := range names {
+			if name == newName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, newName)
+		}
+	}
+	return res
+}
+
+// stagedImageSource is an ImageSource for the data staged in a storageImageDestination,
+// used to parse the manifest at Commit() time.
+type stagedImageSource struct {
+	dest *storageImageDestination
+}
+
+func (s *stagedImageSource) Reference() types.ImageReference {
+	return s.dest.imageRef
+}
+
+func (s *stagedImageSource) Close() {
+}
+
+func (s *stagedImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		return nil, "", fmt.Errorf("Getting target manifest not supported by containers-storage:")
+	}
+	return s.dest.manifest, manifest.GuessMIMEType(s.dest.manifest), nil
+}
+
+// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+func (s *stagedImageSource) HasThreadSafeGetBlob() bool {
+	return false
+}
+
+func (s *stagedImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	filename, ok := s.dest.filenames[info.Digest]
+	if !ok {
+		return nil, -1, fmt.Errorf("Blob %s was not stored", info.Digest)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, -1, err
+	}
+	return f, s.dest.fileSizes[info.Digest], nil
+}
+
+func (s *stagedImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	if instanceDigest != nil {
+		return nil, errors.New("Manifest lists are not supported by the containers-storage: transport")
+	}
+	return s.dest.signatures, nil
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/containers/storage"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// layerStore is a fakeStore which also contains a set of layers.
+type layerStore struct {
+	fakeStore
+	lock    *sync.Mutex
+	parents map[string]string // Maps layer IDs to the IDs of their parents
+}
+
+func newLayerStore(layers map[string]string) layerStore {
+	return layerStore{lock: &sync.Mutex{}, parents: layers}
+}
+
+func (s layerStore) Layer(id string) (*storage.Layer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	parent, ok := s.parents[id]
+	if !ok {
+		return nil, storage.ErrLayerUnknown
+	}
+	return &storage.Layer{ID: id, Parent: parent}, nil
+}
+
+func (s layerStore) PutLayer(id, parent string, names []string, mountLabel string, writeable bool, diff io.Reader) (*storage.Layer, int64, error) {
+	n, err := io.Copy(ioutil.Discard, diff)
+	if err != nil {
+		return nil, -1, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.parents[id]; ok {
+		return nil, -1, storage.ErrDuplicateID
+	}
+	s.parents[id] = parent
+	return &storage.Layer{ID: id, Parent: parent}, n, nil
+}
+
+// gzipLayer returns a gzip-compressed blob with contents, and its digest.
+func gzipLayer(t *testing.T, contents string) ([]byte, digest.Digest) {
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err := gzipWriter.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+	return compressed.Bytes(), digest.FromBytes(compressed.Bytes())
+}
+
+func newTestImageDestination(t *testing.T, store storage.Store) *storageImageDestination {
+	ref := newReference(storageTransport{store: store}, "docker.io/library/busybox:latest", "", nil)
+	dest, err := newImageDestination(nil, *ref)
+	require.NoError(t, err)
+	return dest
+}
+
+func TestStorageImageDestinationTryReusingBlob(t *testing.T) {
+	diffID1 := digest.FromString("layer 1")
+	diffID2 := digest.FromString("layer 2")
+	blob1 := digest.FromString("compressed layer 1")
+	blob2 := digest.FromString("compressed layer 2")
+	blob3, blob3Digest := gzipLayer(t, "layer 3")
+	chainID2 := nextChainID(diffID1, diffID2)
+	store := newLayerStore(map[string]string{
+		diffID1.Hex():  "",
+		chainID2.Hex(): diffID1.Hex(),
+	})
+	cache := blobinfocache.NewMemoryCache()
+	cache.RecordDigestUncompressedPair(blob1, diffID1)
+	cache.RecordDigestUncompressedPair(blob2, diffID2)
+
+	// Layers are not reused if their order is not known.
+	dest := newTestImageDestination(t, store)
+	defer dest.Close()
+	reused, _, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blob1, Size: 10}, cache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+
+	dest = newTestImageDestination(t, store)
+	defer dest.Close()
+	dest.NoteLayerOrder([]types.BlobInfo{{Digest: blob1}, {Digest: blob2}, {Digest: blob3Digest}})
+	// DiffID not known
+	reused, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blob3Digest, Size: int64(len(blob3))}, cache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+	// Size not known
+	reused, _, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blob1, Size: -1}, cache, false)
+	require.NoError(t, err)
+	assert.False(t, reused)
+	// Layers can be reused in any order.
+	reused, info, err := dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blob2, Size: 20}, cache, false)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, types.BlobInfo{Digest: blob2, Size: 20}, info)
+	reused, info, err = dest.TryReusingBlob(context.Background(), types.BlobInfo{Digest: blob1, Size: 10}, cache, false)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, types.BlobInfo{Digest: blob1, Size: 10}, info)
+	assert.Equal(t, 2, dest.appliedCount)
+
+	// Layers which are not present are stored and applied, and their DiffIDs recorded in the cache.
+	info, err = dest.PutBlob(context.Background(), bytes.NewReader(blob3), types.BlobInfo{Digest: "", Size: -1}, false)
+	require.NoError(t, err)
+	assert.Equal(t, blob3Digest, info.Digest)
+	diffID3 := digest.FromString("layer 3")
+	assert.Equal(t, diffID3, cache.UncompressedDigest(blob3Digest))
+	chainID3 := nextChainID(chainID2, diffID3)
+	assert.Equal(t, 3, dest.appliedCount)
+	assert.Equal(t, chainID3.Hex(), dest.appliedTopLayer)
+	assert.Equal(t, chainID2.Hex(), store.parents[chainID3.Hex()])
+}
+
+func TestStorageImageDestinationApplyReadyLayers(t *testing.T) {
+	blob1, blob1Digest := gzipLayer(t, "layer 1")
+	blob2, blob2Digest := gzipLayer(t, "layer 2")
+	diffID1 := digest.FromString("layer 1")
+	chainID2 := nextChainID(diffID1, digest.FromString("layer 2"))
+	store := newLayerStore(map[string]string{})
+
+	dest := newTestImageDestination(t, store)
+	defer dest.Close()
+	dest.NoteLayerOrder([]types.BlobInfo{{Digest: blob1Digest}, {Digest: blob2Digest}})
+	// A layer is not applied before its parent.
+	_, err := dest.PutBlob(context.Background(), bytes.NewReader(blob2), types.BlobInfo{Digest: blob2Digest, Size: -1}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, dest.appliedCount)
+	assert.Empty(t, store.parents)
+	// Applying the parent also applies the layers waiting for it.
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(blob1), types.BlobInfo{Digest: blob1Digest, Size: -1}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, dest.appliedCount)
+	assert.Equal(t, map[string]string{diffID1.Hex(): "", chainID2.Hex(): diffID1.Hex()}, store.parents)
+	assert.Equal(t, map[digest.Digest]string{blob1Digest: diffID1.Hex(), blob2Digest: chainID2.Hex()}, dest.layerIDs)
+
+	// If the layers turn out to be different, the ones applied so far are not used.
+	dest.lock.Lock()
+	dest.setLayerOrderLocked([]digest.Digest{blob2Digest})
+	dest.lock.Unlock()
+	assert.Equal(t, 0, dest.appliedCount)
+	require.NoError(t, dest.applyReadyLayers())
+	assert.Equal(t, 1, dest.appliedCount)
+	assert.Equal(t, digest.FromString("layer 2").Hex(), dest.appliedTopLayer)
+}
+
+// imageStore is a layerStore which also contains a single image and the uncompressed contents of layers.
+type imageStore struct {
+	layerStore
+	image   storage.Image
+	bigData map[string][]byte
+	diffs   map[string]string // Maps layer IDs to their uncompressed contents
+}
+
+func (s imageStore) Image(id string) (*storage.Image, error) {
+	if id != s.image.ID {
+		return nil, storage.ErrImageUnknown
+	}
+	return &s.image, nil
+}
+
+func (s imageStore) ImageBigData(id, key string) ([]byte, error) {
+	data, ok := s.bigData[key]
+	if id != s.image.ID || !ok {
+		return nil, storage.ErrImageUnknown
+	}
+	return data, nil
+}
+
+func (s imageStore) Diff(from, to string) (io.ReadCloser, error) {
+	diff, ok := s.diffs[to]
+	if !ok {
+		return nil, storage.ErrLayerUnknown
+	}
+	return ioutil.NopCloser(bytes.NewReader([]byte(diff))), nil
+}
+
+func TestStorageImageSourceLayerInfosForCopy(t *testing.T) {
+	_, blob1Digest := gzipLayer(t, "layer 1")
+	diffID1 := digest.FromString("layer 1")
+	diffID2 := digest.FromString("layer 2")
+	chainID2 := nextChainID(diffID1, diffID2)
+	config := []byte("{}")
+	manifestBlob := []byte(`{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+	"config": {"mediaType": "application/vnd.docker.container.image.v1+json", "size": 2, "digest": "` + digest.FromBytes(config).String() + `"},
+	"layers": [
+		{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "size": 10, "digest": "` + blob1Digest.String() + `"},
+		{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "size": 7, "digest": "` + diffID2.String() + `"}
+	]}`)
+	metadata := `{"layers": {"` + blob1Digest.String() + `": "` + diffID1.Hex() + `", "` + diffID2.String() + `": "` + chainID2.Hex() + `"},
+	"diff-ids": {"` + blob1Digest.String() + `": "` + diffID1.String() + `", "` + diffID2.String() + `": "` + diffID2.String() + `"}}`
+	store := imageStore{
+		layerStore: newLayerStore(map[string]string{diffID1.Hex(): "", chainID2.Hex(): diffID1.Hex()}),
+		image:      storage.Image{ID: "image", Metadata: metadata},
+		bigData:    map[string][]byte{manifestBigDataKey: manifestBlob, digest.FromBytes(config).String(): config},
+		diffs:      map[string]string{diffID1.Hex(): "layer 1", chainID2.Hex(): "layer 2"},
+	}
+	src, err := newImageSource(*newReference(storageTransport{store: store}, "", "image", nil))
+	require.NoError(t, err)
+	defer src.Close()
+
+	// The compressed layer is replaced by its uncompressed version; the layer which was stored uncompressed is unchanged.
+	infos, err := src.LayerInfosForCopy(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []types.BlobInfo{
+		{Digest: diffID1, Size: -1, MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", CompressionOperation: types.Decompress},
+		{Digest: diffID2, Size: -1, MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip"},
+	}, infos)
+	// GetBlob returns data matching the layers returned by LayerInfosForCopy.
+	for _, info := range infos {
+		rc, _, err := src.GetBlob(context.Background(), info, blobinfocache.NoCache)
+		require.NoError(t, err)
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		assert.Equal(t, info.Digest, digest.FromBytes(contents))
+	}
+	// Other blobs are returned unmodified.
+	rc, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: digest.FromBytes(config), Size: -1}, blobinfocache.NoCache)
+	require.NoError(t, err)
+	defer rc.Close()
+	assert.Equal(t, int64(len(config)), size)
+
+	// If all layers are stored uncompressed, the manifest is accurate.
+	src.metadata.DiffIDs[blob1Digest] = blob1Digest
+	infos, err = src.LayerInfosForCopy(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, infos)
+
+	// The uncompressed digests of layers must be known.
+	delete(src.metadata.DiffIDs, blob1Digest)
+	_, err = src.LayerInfosForCopy(context.Background())
+	assert.Error(t, err)
+}
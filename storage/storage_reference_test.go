@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferenceStringWithinTransport(t *testing.T) {
+	transport := newTestTransport()
+	store, err := transport.GetStore()
+	require.NoError(t, err)
+	storeSpec := "[vfs@" + testGraphRoot + "+" + testRunRoot + "]"
+
+	for _, c := range []struct{ input, result string }{
+		{"busybox", storeSpec + "docker.io/library/busybox:latest"},
+		{"busybox:notlatest", storeSpec + "docker.io/library/busybox:notlatest"},
+		{"@" + sha256digestHex, storeSpec + "@" + sha256digestHex},
+		{"busybox@" + sha256digestHex, storeSpec + "docker.io/library/busybox:latest@" + sha256digestHex},
+	} {
+		ref, err := transport.ParseStoreReference(store, c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.result, ref.StringWithinTransport(), c.input)
+	}
+
+	// Graph driver options
+	ref, err := transport.ParseStoreReference(fakeStore{graphOptions: []string{"opt1=a", "opt2=b"}}, "busybox")
+	require.NoError(t, err)
+	storeSpec = "[vfs@" + testGraphRoot + "+" + testRunRoot + ":opt1=a,opt2=b]"
+	assert.Equal(t, storeSpec+"docker.io/library/busybox:latest", ref.StringWithinTransport())
+	options, err := parseStoreSpec(storeSpec[1 : len(storeSpec)-1])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"opt1=a", "opt2=b"}, options.GraphDriverOptions)
+}
+
+func TestReferencePolicyConfigurationIdentity(t *testing.T) {
+	transport := newTestTransport()
+	store, err := transport.GetStore()
+	require.NoError(t, err)
+	storeSpec := "[vfs@" + testGraphRoot + "]"
+
+	ref, err := transport.ParseStoreReference(store, "busybox@"+sha256digestHex)
+	require.NoError(t, err)
+	assert.Equal(t, storeSpec+"docker.io/library/busybox:latest@"+sha256digestHex, ref.PolicyConfigurationIdentity())
+	err = transport.ValidatePolicyConfigurationScope(ref.PolicyConfigurationIdentity())
+	assert.NoError(t, err)
+}
+
+func TestReferencePolicyConfigurationNamespaces(t *testing.T) {
+	transport := newTestTransport()
+	store, err := transport.GetStore()
+	require.NoError(t, err)
+	storeSpec := "[vfs@" + testGraphRoot + "]"
+
+	ref, err := transport.ParseStoreReference(store, "busybox@"+sha256digestHex)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		storeSpec + "docker.io/library/busybox:latest",
+		storeSpec + "docker.io/library/busybox",
+		storeSpec + "docker.io/library",
+		storeSpec + "docker.io",
+		storeSpec,
+		"[" + testGraphRoot + "]",
+	}, ref.PolicyConfigurationNamespaces())
+	for _, ns := range ref.PolicyConfigurationNamespaces() {
+		err = transport.ValidatePolicyConfigurationScope(ns)
+		assert.NoError(t, err, ns)
+	}
+}
+
+func TestReferenceDockerReference(t *testing.T) {
+	transport := newTestTransport()
+	store, err := transport.GetStore()
+	require.NoError(t, err)
+
+	ref, err := transport.ParseStoreReference(store, "busybox")
+	require.NoError(t, err)
+	require.NotNil(t, ref.DockerReference())
+	assert.Equal(t, "docker.io/library/busybox", ref.DockerReference().FullName())
+
+	ref, err = transport.ParseStoreReference(store, "@"+sha256digestHex)
+	require.NoError(t, err)
+	assert.Nil(t, ref.DockerReference())
+}
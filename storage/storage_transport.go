@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/types"
+	"github.com/containers/storage"
+)
+
+var (
+	// Transport is an ImageTransport that uses either a default
+	// storage.Store or one that's it's explicitly told to use.
+	Transport StoreTransport = &storageTransport{}
+	// ErrInvalidReference is returned when ParseReference() is passed an
+	// empty reference.
+	ErrInvalidReference = errors.New("invalid reference")
+	// ErrPathNotAbsolute is returned when a graph root is not an absolute
+	// path name.
+	ErrPathNotAbsolute = errors.New("path name is not absolute")
+	idRegexp           = regexp.MustCompile("^([a-f0-9]{64})$")
+)
+
+// StoreTransport is an ImageTransport that uses a storage.Store to parse
+// references, either its own default or one that it's told to use.
+type StoreTransport interface {
+	types.ImageTransport
+	// SetStore sets the default store for this transport.
+	SetStore(storage.Store)
+	// GetStore returns the default store for this transport, initializing it
+	// with storage.DefaultStoreOptions if necessary.
+	GetStore() (storage.Store, error)
+	// ParseStoreReference parses a reference, overriding any store
+	// specification that it may contain.
+	ParseStoreReference(store storage.Store, reference string) (*storageReference, error)
+}
+
+type storageTransport struct {
+	store storage.Store
+}
+
+func (s *storageTransport) Name() string {
+	// Still haven't really settled on a name.
+	return "containers-storage"
+}
+
+// SetStore sets the Store object which the Transport will use for parsing
+// references when information about a Store is not directly specified as
+// part of the reference.  If one is not set, the library will attempt to
+// initialize one with default settings when a reference needs to be parsed.
+// Calling SetStore does not affect previously parsed references.
+func (s *storageTransport) SetStore(store storage.Store) {
+	s.store = store
+}
+
+// GetStore returns the Store object which the Transport uses when a reference
+// does not specify one, initializing it with default settings if necessary.
+func (s *storageTransport) GetStore() (storage.Store, error) {
+	// Return the transport's previously-set store.  If we don't have one
+	// of those, initialize one now.
+	if s.store == nil {
+		store, err := storage.GetStore(storage.DefaultStoreOptions)
+		if err != nil {
+			return nil, err
+		}
+		s.store = store
+	}
+	return s.store, nil
+}
+
+// ParseStoreReference takes a name or an ID, tries to figure out which it is
+// relative to the given store, and returns it in a reference object.
+func (s storageTransport) ParseStoreReference(store storage.Store, ref string) (*storageReference, error) {
+	var name reference.Named
+	var sum string
+	var err error
+	if ref == "" {
+		return nil, ErrInvalidReference
+	}
+	if ref[0] == '[' {
+		// Ignore the store specifier.
+		closeIndex := strings.IndexRune(ref, ']')
+		if closeIndex < 1 {
+			return nil, ErrInvalidReference
+		}
+		ref = ref[closeIndex+1:]
+	}
+	refInfo := strings.SplitN(ref, "@", 2)
+	if len(refInfo) == 1 {
+		// A name.
+		name, err = reference.ParseNamed(refInfo[0])
+		if err != nil {
+			return nil, err
+		}
+	} else if len(refInfo) == 2 {
+		// An ID, possibly preceded by a name.
+		if refInfo[0] != "" {
+			name, err = reference.ParseNamed(refInfo[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+		sum = refInfo[1]
+		if !idRegexp.MatchString(sum) {
+			return nil, ErrInvalidReference
+		}
+	} else { // Coverage: len(refInfo) is always 1 or 2
+		// Anything else: store specified in a form we don't
+		// recognize.
+		return nil, ErrInvalidReference
+	}
+	storeSpec := "[" + store.GraphDriverName() + "@" + store.GraphRoot() + "]"
+	id := ""
+	if sum != "" {
+		id = sum
+	}
+	refname := ""
+	if name != nil {
+		name = reference.WithDefaultTag(name)
+		refname = verboseName(name)
+	}
+	if refname == "" {
+		logrus.Debugf("parsed reference into %q", storeSpec+"@"+id)
+	} else if id == "" {
+		logrus.Debugf("parsed reference into %q", storeSpec+refname)
+	} else {
+		logrus.Debugf("parsed reference into %q", storeSpec+refname+"@"+id)
+	}
+	return newReference(storageTransport{store: store}, refname, id, name), nil
+}
+
+// ParseReference takes a name and/or an ID ("_name_"/"@_id_"/"_name_@_id_"),
+// possibly prefixed with a store specifier in the form "[_graphroot_]" or
+// "[_driver_@_graphroot_]" or "[_driver_@_graphroot_+_runroot_]", optionally
+// followed by comma-separated graph driver options, as in
+// "[_driver_@_graphroot_+_runroot_:_option_,_option_]", tries to
+// figure out which it is, and returns it in a reference object.  If the
+// store specifier does not match the transport's default store, a store with
+// the specified settings is opened.
+func (s *storageTransport) ParseReference(reference string) (types.ImageReference, error) {
+	var store storage.Store
+	if reference == "" {
+		return nil, ErrInvalidReference
+	}
+	// Check if there's a store location prefix.  If there is, then it
+	// needs to match a store that was previously initialized using
+	// storage.GetStore(), or be enough to let the storage library fill out
+	// the rest using knowledge that it has from elsewhere.
+	if reference[0] == '[' {
+		closeIndex := strings.IndexRune(reference, ']')
+		if closeIndex < 1 {
+			return nil, ErrInvalidReference
+		}
+		options, err := parseStoreSpec(reference[1:closeIndex])
+		if err != nil {
+			return nil, err
+		}
+		reference = reference[closeIndex+1:]
+		store2, err := storage.GetStore(options)
+		if err != nil {
+			return nil, err
+		}
+		store = store2
+	} else {
+		// We didn't get a store spec, so use the default.
+		store2, err := s.GetStore()
+		if err != nil {
+			return nil, err
+		}
+		store = store2
+	}
+	ref, err := s.ParseStoreReference(store, reference)
+	if err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// parseStoreSpec parses the store specifier of a reference, without the surrounding brackets:
+// "_graphroot_", "_driver_@_graphroot_" or "_driver_@_graphroot_+_runroot_", the latter two
+// optionally followed by ":" and comma-separated graph driver options.
+func parseStoreSpec(storeSpec string) (storage.StoreOptions, error) {
+	storeInfo := strings.SplitN(storeSpec, "@", 2)
+	if len(storeInfo) == 1 && storeInfo[0] != "" {
+		// One component: the graph root.
+		if !filepath.IsAbs(storeInfo[0]) {
+			return storage.StoreOptions{}, ErrPathNotAbsolute
+		}
+		return storage.StoreOptions{GraphRoot: storeInfo[0]}, nil
+	}
+	if len(storeInfo) == 2 && storeInfo[0] != "" && storeInfo[1] != "" {
+		// Two components: the driver type and the
+		// graph root, possibly with the run root and driver options.
+		options := storage.StoreOptions{
+			GraphDriverName: storeInfo[0],
+		}
+		optionsInfo := strings.SplitN(storeInfo[1], ":", 2)
+		if len(optionsInfo) == 2 && optionsInfo[1] != "" {
+			options.GraphDriverOptions = strings.Split(optionsInfo[1], ",")
+		}
+		rootInfo := strings.SplitN(optionsInfo[0], "+", 2)
+		options.GraphRoot = rootInfo[0]
+		if len(rootInfo) == 2 {
+			options.RunRoot = rootInfo[1]
+		}
+		if !filepath.IsAbs(options.GraphRoot) || (options.RunRoot != "" && !filepath.IsAbs(options.RunRoot)) {
+			return storage.StoreOptions{}, ErrPathNotAbsolute
+		}
+		return options, nil
+	}
+	// Anything else: store specified in a form we don't
+	// recognize.
+	return storage.StoreOptions{}, ErrInvalidReference
+}
+
+// ValidatePolicyConfigurationScope checks that scope is a valid name for a signature.PolicyTransportScopes keys
+// (i.e. a valid PolicyConfigurationIdentity() or PolicyConfigurationNamespaces() return value).
+// It is acceptable to allow an invalid value which will never be matched, it can "only" cause user confusion.
+// scope passed to this function will not be "", that value is always allowed.
+func (s *storageTransport) ValidatePolicyConfigurationScope(scope string) error {
+	// Check that there's a store location prefix.  Values we're passed are
+	// expected to come from PolicyConfigurationIdentity or
+	// PolicyConfigurationNamespaces, so if there's no store location,
+	// something's wrong.
+	if scope[0] != '[' {
+		return ErrInvalidReference
+	}
+	// Parse the store location prefix.
+	closeIndex := strings.IndexRune(scope, ']')
+	if closeIndex < 1 {
+		return ErrInvalidReference
+	}
+	storeSpec := scope[1:closeIndex]
+	scope = scope[closeIndex+1:]
+	storeInfo := strings.SplitN(storeSpec, "@", 2)
+	if len(storeInfo) == 1 && storeInfo[0] != "" {
+		// One component: the graph root.
+		if !filepath.IsAbs(storeInfo[0]) {
+			return ErrPathNotAbsolute
+		}
+	} else if len(storeInfo) == 2 && storeInfo[0] != "" && storeInfo[1] != "" {
+		// Two components: the driver type and the graph root.
+		if !filepath.IsAbs(storeInfo[1]) {
+			return ErrPathNotAbsolute
+		}
+	} else {
+		// Anything else: store specified in a form we don't
+		// recognize.
+		return ErrInvalidReference
+	}
+	// That might be all of it, and that's okay.
+	if scope == "" {
+		return nil
+	}
+	// But if there is anything left, it has to be a name, with or without
+	// a tag, with or without an ID, since we don't return namespace values
+	// that are just bare IDs.
+	scopeInfo := strings.SplitN(scope, "@", 2)
+	if len(scopeInfo) == 1 && scopeInfo[0] != "" {
+		_, err := reference.ParseNamed(scopeInfo[0])
+		if err != nil {
+			return err
+		}
+	} else if len(scopeInfo) == 2 && scopeInfo[0] != "" && scopeInfo[1] != "" {
+		_, err := reference.ParseNamed(scopeInfo[0])
+		if err != nil {
+			return err
+		}
+		if !idRegexp.MatchString(scopeInfo[1]) {
+			return ErrInvalidReference
+		}
+	} else {
+		return ErrInvalidReference
+	}
+	return nil
+}
+
+// verboseName returns the fully-qualified name of a reference, including its tag if it has one.
+func verboseName(name reference.Named) string {
+	tag := ""
+	if tagged, ok := name.(reference.NamedTagged); ok {
+		tag = ":" + tagged.Tag()
+	}
+	return name.FullName() + tag
+}
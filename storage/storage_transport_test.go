@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/containers/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sha256digestHex = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testGraphRoot   = "/var/lib/containers/storage"
+	testRunRoot     = "/var/run/containers/storage"
+)
+
+// fakeStore is a storage.Store which only supports the methods needed to parse and format references.
+type fakeStore struct {
+	storage.Store // We inherit almost all of the methods, which just panic() on the nil interface.
+	graphOptions  []string
+}
+
+func (s fakeStore) GraphDriverName() string {
+	return "vfs"
+}
+
+func (s fakeStore) GraphRoot() string {
+	return testGraphRoot
+}
+
+func (s fakeStore) RunRoot() string {
+	return testRunRoot
+}
+
+func (s fakeStore) GraphOptions() []string {
+	return s.graphOptions
+}
+
+func newTestTransport() StoreTransport {
+	transport := &storageTransport{}
+	transport.SetStore(fakeStore{})
+	return transport
+}
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "containers-storage", Transport.Name())
+}
+
+func TestTransportParseStoreReference(t *testing.T) {
+	transport := newTestTransport()
+	store, err := transport.GetStore()
+	require.NoError(t, err)
+
+	for _, c := range []struct{ input, reference, id string }{
+		{"", "", ""},                                                     // Empty input
+		{"UPPERCASEISINVALID", "", ""},                                   // Invalid name
+		{"busybox@notanid", "", ""},                                      // Invalid ID
+		{"busybox", "docker.io/library/busybox:latest", ""},              // Name-only
+		{"busybox:notlatest", "docker.io/library/busybox:notlatest", ""}, // Name and tag
+		{"@" + sha256digestHex, "", sha256digestHex},                     // ID-only
+		{"busybox@" + sha256digestHex, "docker.io/library/busybox:latest", sha256digestHex}, // Name and ID
+		{"[vfs@/tmp/ignored]busybox", "docker.io/library/busybox:latest", ""},               // Ignored store specifier
+	} {
+		ref, err := transport.ParseStoreReference(store, c.input)
+		if c.reference == "" && c.id == "" {
+			assert.Error(t, err, c.input)
+			continue
+		}
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.reference, ref.reference, c.input)
+		assert.Equal(t, c.id, ref.id, c.input)
+	}
+}
+
+func TestParseStoreSpec(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected *storage.StoreOptions // nil if an error is expected
+	}{
+		{"/graph", &storage.StoreOptions{GraphRoot: "/graph"}},
+		{"vfs@/graph", &storage.StoreOptions{GraphDriverName: "vfs", GraphRoot: "/graph"}},
+		{"overlay@/graph+/run", &storage.StoreOptions{GraphDriverName: "overlay", GraphRoot: "/graph", RunRoot: "/run"}},
+		{"overlay@/graph+/run:overlay.mount_program=/usr/bin/fuse-overlayfs,overlay.mountopt=nodev", &storage.StoreOptions{
+			GraphDriverName:    "overlay",
+			GraphRoot:          "/graph",
+			RunRoot:            "/run",
+			GraphDriverOptions: []string{"overlay.mount_program=/usr/bin/fuse-overlayfs", "overlay.mountopt=nodev"},
+		}},
+		{"overlay@/graph:overlay.mountopt=nodev", &storage.StoreOptions{GraphDriverName: "overlay", GraphRoot: "/graph", GraphDriverOptions: []string{"overlay.mountopt=nodev"}}},
+		{"overlay@/graph+/run:", &storage.StoreOptions{GraphDriverName: "overlay", GraphRoot: "/graph", RunRoot: "/run"}},
+		{"", nil},
+		{"relative", nil},
+		{"vfs@", nil},
+		{"@/graph", nil},
+		{"vfs@relative", nil},
+		{"vfs@/graph+relative", nil},
+	} {
+		options, err := parseStoreSpec(c.input)
+		if c.expected == nil {
+			assert.Error(t, err, c.input)
+		} else {
+			require.NoError(t, err, c.input)
+			assert.Equal(t, *c.expected, options, c.input)
+		}
+	}
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	transport := newTestTransport()
+	for _, scope := range []string{
+		"[" + testGraphRoot + "]",
+		"[vfs@" + testGraphRoot + "]",
+		"[vfs@" + testGraphRoot + "]docker.io/library/busybox",
+		"[vfs@" + testGraphRoot + "]docker.io/library/busybox:latest",
+		"[vfs@" + testGraphRoot + "]docker.io/library/busybox:latest@" + sha256digestHex,
+	} {
+		err := transport.ValidatePolicyConfigurationScope(scope)
+		assert.NoError(t, err, scope)
+	}
+
+	for _, scope := range []string{
+		"docker.io/library/busybox",
+		"[relative/path]",
+		"[vfs@relative/path]",
+		"[]",
+		"[vfs@" + testGraphRoot + "]UPPERCASEISINVALID",
+		"[vfs@" + testGraphRoot + "]docker.io/library/busybox@notanid",
+		"[vfs@" + testGraphRoot + "]@" + sha256digestHex,
+	} {
+		err := transport.ValidatePolicyConfigurationScope(scope)
+		assert.Error(t, err, scope)
+	}
+}
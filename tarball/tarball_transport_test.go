@@ -0,0 +1,172 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/blobinfocache"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeTarball returns the contents of a tarball containing a single file with the specified name and contents.
+func makeTarball(t *testing.T, name, contents string) []byte {
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+	require.NoError(t, err)
+	_, err = tw.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func digestOf(data []byte) string {
+	return digest.FromBytes(data).String()
+}
+
+func TestTransportName(t *testing.T) {
+	assert.Equal(t, "tarball", Transport.Name())
+}
+
+func TestTransportParseReference(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tarball-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	file1 := filepath.Join(tmpDir, "file1.tar")
+	file2 := filepath.Join(tmpDir, "file2.tar")
+	require.NoError(t, ioutil.WriteFile(file1, makeTarball(t, "a", "a"), 0644))
+	require.NoError(t, ioutil.WriteFile(file2, makeTarball(t, "b", "b"), 0644))
+
+	ref, err := Transport.ParseReference(file1 + ":" + file2)
+	require.NoError(t, err)
+	assert.Equal(t, file1+":"+file2, ref.StringWithinTransport())
+	assert.Equal(t, Transport, ref.Transport())
+	assert.Nil(t, ref.DockerReference())
+	assert.Equal(t, "", ref.PolicyConfigurationIdentity())
+	assert.Nil(t, ref.PolicyConfigurationNamespaces())
+
+	_, err = Transport.ParseReference(filepath.Join(tmpDir, "thisdoesnotexist"))
+	assert.Error(t, err)
+}
+
+func TestTransportValidatePolicyConfigurationScope(t *testing.T) {
+	for _, scope := range []string{
+		"/etc",
+		"file.tar",
+	} {
+		err := Transport.ValidatePolicyConfigurationScope(scope)
+		assert.Error(t, err, scope)
+	}
+}
+
+func TestNewReference(t *testing.T) {
+	_, err := NewReference([]string{"has:colon"}, nil)
+	assert.Error(t, err)
+}
+
+func TestImageSource(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tarball-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	uncompressed := makeTarball(t, "a", "contents of a")
+	gzipped := bytes.Buffer{}
+	gz := gzip.NewWriter(&gzipped)
+	_, err = gz.Write(makeTarball(t, "b", "contents of b"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	file1 := filepath.Join(tmpDir, "file1.tar")
+	file2 := filepath.Join(tmpDir, "file2.tar.gz")
+	require.NoError(t, ioutil.WriteFile(file1, uncompressed, 0644))
+	require.NoError(t, ioutil.WriteFile(file2, gzipped.Bytes(), 0644))
+
+	ref, err := NewReference([]string{file1, file2}, nil)
+	require.NoError(t, err)
+	updater, ok := ref.(ConfigUpdater)
+	require.True(t, ok)
+	err = updater.ConfigUpdate(imgspecv1.Image{
+		Config: imgspecv1.ImageConfig{Cmd: []string{"/bin/true"}},
+	}, map[string]string{"key": "value"})
+	require.NoError(t, err)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+
+	manifestBlob, mimeType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mimeType)
+	var m imgspecv1.Manifest
+	require.NoError(t, json.Unmarshal(manifestBlob, &m))
+	assert.Equal(t, map[string]string{"key": "value"}, m.Annotations)
+	require.Len(t, m.Layers, 2)
+	assert.Equal(t, imgspecv1.Descriptor{MediaType: manifest.OCI1LayerUncompressedMediaType, Digest: digestOf(uncompressed), Size: int64(len(uncompressed))}, m.Layers[0])
+	assert.Equal(t, imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayer, Digest: digestOf(gzipped.Bytes()), Size: int64(len(gzipped.Bytes()))}, m.Layers[1])
+
+	for _, layer := range m.Layers {
+		stream, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: digest.Digest(layer.Digest), Size: -1}, blobinfocache.NoCache)
+		require.NoError(t, err)
+		contents, err := ioutil.ReadAll(stream)
+		stream.Close()
+		require.NoError(t, err)
+		assert.Equal(t, layer.Size, size)
+		assert.Equal(t, layer.Digest, digestOf(contents))
+	}
+
+	stream, size, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: digest.Digest(m.Config.Digest), Size: -1}, blobinfocache.NoCache)
+	require.NoError(t, err)
+	configBlob, err := ioutil.ReadAll(stream)
+	stream.Close()
+	require.NoError(t, err)
+	assert.Equal(t, m.Config.Size, size)
+	assert.Equal(t, m.Config.Digest, digestOf(configBlob))
+	var config imgspecv1.Image
+	require.NoError(t, json.Unmarshal(configBlob, &config))
+	assert.Equal(t, []string{"/bin/true"}, config.Config.Cmd)
+	assert.Equal(t, "layers", config.RootFS.Type)
+	assert.Equal(t, []string{digestOf(uncompressed), digestOf(makeTarball(t, "b", "contents of b"))}, config.RootFS.DiffIDs)
+	assert.Len(t, config.History, 2)
+	assert.NotEqual(t, "", config.Architecture)
+	assert.NotEqual(t, "", config.OS)
+
+	_, _, err = src.GetBlob(context.Background(), types.BlobInfo{Digest: digest.FromBytes([]byte("unknown")), Size: -1}, blobinfocache.NoCache)
+	assert.Error(t, err)
+
+	sigs, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, sigs)
+}
+
+func TestReferenceNewImageDestination(t *testing.T) {
+	ref, err := NewReference([]string{"/dev/null"}, nil)
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestReferenceDeleteImage(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tarball-transport-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	file := filepath.Join(tmpDir, "file.tar")
+	require.NoError(t, ioutil.WriteFile(file, makeTarball(t, "a", "a"), 0644))
+
+	ref, err := NewReference([]string{file}, nil)
+	require.NoError(t, err)
+	err = ref.DeleteImage(context.Background(), &types.SystemContext{})
+	require.NoError(t, err)
+	_, err = os.Lstat(file)
+	assert.True(t, os.IsNotExist(err))
+}
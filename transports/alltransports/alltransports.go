@@ -0,0 +1,65 @@
+package alltransports
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/docker"
+	dockerArchive "github.com/containers/image/docker/archive"
+	"github.com/containers/image/docker/daemon"
+	"github.com/containers/image/memory"
+	ociArchive "github.com/containers/image/oci/archive"
+	ociLayout "github.com/containers/image/oci/layout"
+	"github.com/containers/image/openshift"
+	"github.com/containers/image/ostree"
+	"github.com/containers/image/s3"
+	"github.com/containers/image/sif"
+	"github.com/containers/image/storage"
+	"github.com/containers/image/tarball"
+	"github.com/containers/image/transports"
+	"github.com/containers/image/types"
+)
+
+func init() {
+	// NOTE: Make sure docs/policy.json.md is updated when adding or updating
+	// a transport.
+	for _, t := range []types.ImageTransport{
+		directory.Transport,
+		docker.Transport,
+		dockerArchive.Transport,
+		daemon.Transport,
+		memory.Transport,
+		ociArchive.Transport,
+		ociLayout.Transport,
+		openshift.Transport,
+		ostree.Transport,
+		s3.Transport,
+		sif.Transport,
+		storage.Transport,
+		tarball.Transport,
+	} {
+		if err := transports.Register(t.Name(), t); err != nil {
+			panic(err.Error())
+		}
+	}
+}
+
+// ParseImageName converts a URL-like image name to a types.ImageReference,
+// using the transports registered in the transports package (including all transports built into this repository).
+// The name has the form transport:reference, e.g. docker://busybox:latest, dir:/path or oci:/layout:tag.
+func ParseImageName(imgName string) (types.ImageReference, error) {
+	parts := strings.SplitN(imgName, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf(`Invalid image name "%s", expected colon-separated transport:reference`, imgName)
+	}
+	transport := transports.Get(parts[0])
+	if transport == nil {
+		return nil, fmt.Errorf(`Invalid image name "%s", unknown transport "%s"`, imgName, parts[0])
+	}
+	ref, err := transport.ParseReference(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf(`Invalid image name "%s": %v`, imgName, err)
+	}
+	return ref, nil
+}
@@ -0,0 +1,70 @@
+package alltransports
+
+import (
+	"testing"
+
+	"github.com/containers/image/directory"
+	"github.com/containers/image/transports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKnownTransports(t *testing.T) {
+	names := transports.ListNames()
+	assert.True(t, len(names) > 1) // Ensure that the initialization has actually been run
+	for _, name := range names {
+		transport := transports.Get(name)
+		require.NotNil(t, transport, name)
+		assert.Equal(t, name, transport.Name())
+	}
+	for _, name := range []string{"dir", "docker", "docker-archive", "docker-daemon", "oci", "oci-archive"} {
+		assert.Contains(t, names, name)
+	}
+	// Built-in transports can not be registered again.
+	assert.Error(t, transports.Register("docker", directory.Transport))
+}
+
+func TestParseImageName(t *testing.T) {
+	// This primarily tests error handling, TestImageNameHandling is a table-driven
+	// test for the expected values.
+	for _, name := range []string{
+		"",                                      // Empty
+		"busybox",                               // No transport name
+		":busybox",                              // Empty transport name
+		"docker:",                               // Empty transport reference
+		"this-transport-does-not-exist:busybox", // Unknown transport
+	} {
+		_, err := ParseImageName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseImageNameRegisteredTransport(t *testing.T) {
+	const name = "test-transport"
+	require.NoError(t, transports.Register(name, directory.Transport))
+	defer transports.Delete(name)
+	ref, err := ParseImageName(name + ":/etc")
+	require.NoError(t, err)
+	assert.Equal(t, "/etc", ref.StringWithinTransport())
+}
+
+// A table-driven test summarizing the various transports' behavior.
+func TestImageNameHandling(t *testing.T) {
+	for _, c := range []struct{ transport, input, roundtrip string }{
+		{"dir", "/etc", "/etc"},
+		{"docker", "//busybox", "//busybox:latest"},
+		{"docker", "//busybox:notlatest", "//busybox:notlatest"}, // This also tests handling of multiple ":" characters
+		{"docker-archive", "/var/lib/oci/busybox.tar:busybox:latest", "/var/lib/oci/busybox.tar:busybox:latest"},
+		{"docker-archive", "/var/lib/oci/busybox.tar:busybox", "/var/lib/oci/busybox.tar:busybox:latest"},
+		{"docker-archive", "/var/lib/oci/busybox.tar:@1", "/var/lib/oci/busybox.tar:@1"},
+		{"docker-daemon", "FIXME FIXME", "FIXME FIXME"},
+		{"oci", "/etc:sometag", "/etc:sometag"},
+		// "atomic" not tested here because it depends on per-user configuration for the default cluster.
+	} {
+		fullInput := c.transport + ":" + c.input
+		ref, err := ParseImageName(fullInput)
+		require.NoError(t, err, fullInput)
+		s := transports.ImageName(ref)
+		assert.Equal(t, c.transport+":"+c.roundtrip, s, fullInput)
+	}
+}
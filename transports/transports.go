@@ -1,58 +1,78 @@
 package transports
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
-	"github.com/containers/image/directory"
-	"github.com/containers/image/docker"
-	"github.com/containers/image/docker/daemon"
-	ociLayout "github.com/containers/image/oci/layout"
-	"github.com/containers/image/openshift"
 	"github.com/containers/image/types"
 )
 
-// KnownTransports is a registry of known ImageTransport instances.
-var KnownTransports map[string]types.ImageTransport
-
-func init() {
-	KnownTransports = make(map[string]types.ImageTransport)
-	// NOTE: Make sure docs/policy.json.md is updated when adding or updating
-	// a transport.
-	for _, t := range []types.ImageTransport{
-		directory.Transport,
-		docker.Transport,
-		daemon.Transport,
-		ociLayout.Transport,
-		openshift.Transport,
-	} {
-		name := t.Name()
-		if _, ok := KnownTransports[name]; ok {
-			panic(fmt.Sprintf("Duplicate image transport name %s", name))
-		}
-		KnownTransports[name] = t
-	}
+// knownTransports is a registry of known ImageTransport instances.
+type knownTransports struct {
+	transports map[string]types.ImageTransport
+	mu         sync.Mutex
 }
 
-// ParseImageName converts a URL-like image name to a types.ImageReference.
-func ParseImageName(imgName string) (types.ImageReference, error) {
-	parts := strings.SplitN(imgName, ":", 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf(`Invalid image name "%s", expected colon-separated transport:reference`, imgName)
+var kt = knownTransports{transports: map[string]types.ImageTransport{}}
+
+// Register adds transport t to the known transports, so that it can be found by Get and alltransports.ParseImageName using name.
+// name is usually t.Name(); it is an error to register two transports using the same name.
+// This allows transports implemented outside of this repository to be used like the built-in ones,
+// which are registered by the alltransports package.
+func Register(name string, t types.ImageTransport) error {
+	if name == "" {
+		return errors.New("Invalid image transport name: empty name")
+	}
+	if strings.Contains(name, ":") {
+		return fmt.Errorf("Invalid image transport name %s: contains a colon", name)
 	}
-	transport, ok := KnownTransports[parts[0]]
-	if !ok {
-		return nil, fmt.Errorf(`Invalid image name "%s", unknown transport "%s"`, imgName, parts[0])
+	if t == nil {
+		return fmt.Errorf("Invalid image transport %s: nil transport", name)
+	}
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	if _, ok := kt.transports[name]; ok {
+		return fmt.Errorf("Duplicate image transport name %s", name)
+	}
+	kt.transports[name] = t
+	return nil
+}
+
+// Get returns the transport registered using name, or nil if there is no such transport.
+func Get(name string) types.ImageTransport {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	return kt.transports[name]
+}
+
+// Delete removes the transport registered using name, if any.
+func Delete(name string) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	delete(kt.transports, name)
+}
+
+// ListNames returns a sorted list of the names of all registered transports.
+func ListNames() []string {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	names := make([]string, 0, len(kt.transports))
+	for name := range kt.transports {
+		names = append(names, name)
 	}
-	return transport.ParseReference(parts[1])
+	sort.Strings(names)
+	return names
 }
 
 // ImageName converts a types.ImageReference into an URL-like image name, which MUST be such that
-// ParseImageName(ImageName(reference)) returns an equivalent reference.
+// alltransports.ParseImageName(ImageName(reference)) returns an equivalent reference.
 //
 // This is the generally recommended way to refer to images in the UI.
 //
-// NOTE: The returned string is not promised to be equal to the original input to ParseImageName;
+// NOTE: The returned string is not promised to be equal to the original input to alltransports.ParseImageName;
 // e.g. default attribute values omitted by the user may be filled in in the return value, or vice versa.
 func ImageName(ref types.ImageReference) string {
 	return ref.Transport().Name() + ":" + ref.StringWithinTransport()
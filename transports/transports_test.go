@@ -3,43 +3,50 @@ package transports
 import (
 	"testing"
 
+	"github.com/containers/image/directory"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestKnownTransports(t *testing.T) {
-	assert.NotNil(t, KnownTransports) // Ensure that the initialization has actually been run
-	assert.True(t, len(KnownTransports) > 1)
+func TestRegister(t *testing.T) {
+	// An out-of-tree transport; the directory transport stands in for it, registered using a different name.
+	const name = "test-transport"
+	require.NoError(t, Register(name, directory.Transport))
+	defer Delete(name)
+	assert.Equal(t, directory.Transport, Get(name))
+	assert.Contains(t, ListNames(), name)
+
+	// Duplicates are rejected.
+	assert.Error(t, Register(name, directory.Transport))
+	assert.Equal(t, directory.Transport, Get(name))
+
+	// Invalid names and transports are rejected.
+	assert.Error(t, Register("", directory.Transport))
+	assert.Error(t, Register("with:colon", directory.Transport))
+	assert.Error(t, Register("nil-transport", nil))
+	assert.Nil(t, Get("nil-transport"))
+
+	Delete(name)
+	assert.Nil(t, Get(name))
+	assert.NotContains(t, ListNames(), name)
+	Delete(name) // Deleting a non-existent transport is a no-op
 }
 
-func TestParseImageName(t *testing.T) {
-	// This primarily tests error handling, TestImageNameHandling is a table-driven
-	// test for the expected values.
-	for _, name := range []string{
-		"",         // Empty
-		"busybox",  // No transport name
-		":busybox", // Empty transport name
-		"docker:",  // Empty transport reference
-	} {
-		_, err := ParseImageName(name)
-		assert.Error(t, err, name)
+func TestListNames(t *testing.T) {
+	for _, name := range []string{"test-b", "test-a"} {
+		require.NoError(t, Register(name, directory.Transport))
+		defer Delete(name)
+	}
+	names := ListNames()
+	assert.Contains(t, names, "test-a")
+	assert.Contains(t, names, "test-b")
+	for i := 1; i < len(names); i++ {
+		assert.True(t, names[i-1] < names[i], names)
 	}
 }
 
-// A table-driven test summarizing the various transports' behavior.
-func TestImageNameHandling(t *testing.T) {
-	for _, c := range []struct{ transport, input, roundtrip string }{
-		{"dir", "/etc", "/etc"},
-		{"docker", "//busybox", "//busybox:latest"},
-		{"docker", "//busybox:notlatest", "//busybox:notlatest"}, // This also tests handling of multiple ":" characters
-		{"docker-daemon", "FIXME FIXME", "FIXME FIXME"},
-		{"oci", "/etc:sometag", "/etc:sometag"},
-		// "atomic" not tested here because it depends on per-user configuration for the default cluster.
-	} {
-		fullInput := c.transport + ":" + c.input
-		ref, err := ParseImageName(fullInput)
-		require.NoError(t, err, fullInput)
-		s := ImageName(ref)
-		assert.Equal(t, c.transport+":"+c.roundtrip, s, fullInput)
-	}
+func TestImageName(t *testing.T) {
+	ref, err := directory.NewReference("/etc")
+	require.NoError(t, err)
+	assert.Equal(t, "dir:/etc", ImageName(ref))
 }
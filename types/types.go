@@ -1,10 +1,14 @@
 package types
 
 import (
+	"context"
 	"io"
+	"net"
+	"net/url"
 	"time"
 
 	"github.com/containers/image/docker/reference"
+	"github.com/opencontainers/go-digest"
 )
 
 // ImageTransport is a top-level namespace for ways to to store/load an image.
@@ -18,7 +22,7 @@ import (
 // For example, several different ImageTransport implementations may be based on local filesystem paths,
 // but using completely different formats for the contents of that path (a single tar file, a directory containing tarballs, a fully expanded container filesystem, ...)
 //
-// See also transports.KnownTransports.
+// See also transports.Register.
 type ImageTransport interface {
 	// Name returns the name of the transport, which must be unique among other transports.
 	Name() string
@@ -70,31 +74,113 @@ type ImageReference interface {
 	// and each following element to be a prefix of the element preceding it.
 	PolicyConfigurationNamespaces() []string
 
-	// NewImage returns a types.Image for this reference, possibly specialized for this ImageTransport.
-	// The caller must call .Close() on the returned Image.
+	// NewImage returns a types.ImageCloser for this reference, possibly specialized for this ImageTransport.
+	// The caller must call .Close() on the returned ImageCloser.
 	// NOTE: If any kind of signature verification should happen, build an UnparsedImage from the value returned by NewImageSource,
 	// verify that UnparsedImage, and convert it into a real Image via image.FromUnparsedImage.
-	NewImage(ctx *SystemContext) (Image, error)
-	// NewImageSource returns a types.ImageSource for this reference,
-	// asking the backend to use a manifest from requestedManifestMIMETypes if possible.
-	// nil requestedManifestMIMETypes means manifest.DefaultRequestedManifestMIMETypes.
+	NewImage(ctx context.Context, sys *SystemContext) (ImageCloser, error)
+	// NewImageSource returns a types.ImageSource for this reference.
+	// The source provides the manifest in any of the formats the transport understands; users requiring
+	// a specific format are responsible for converting it, e.g. using Image.UpdatedImage.
 	// The caller must call .Close() on the returned ImageSource.
-	NewImageSource(ctx *SystemContext, requestedManifestMIMETypes []string) (ImageSource, error)
+	NewImageSource(ctx context.Context, sys *SystemContext) (ImageSource, error)
 	// NewImageDestination returns a types.ImageDestination for this reference.
 	// The caller must call .Close() on the returned ImageDestination.
-	NewImageDestination(ctx *SystemContext) (ImageDestination, error)
+	NewImageDestination(ctx context.Context, sys *SystemContext) (ImageDestination, error)
 
 	// DeleteImage deletes the named image from the registry, if supported.
-	DeleteImage(ctx *SystemContext) error
+	DeleteImage(ctx context.Context, sys *SystemContext) error
 }
 
 // BlobInfo collects known information about a blob (layer/config).
 // In some situations, some fields may be unknown, in others they may be mandatory; documenting an “unknown” value here does not override that.
 type BlobInfo struct {
-	Digest string // "" if unknown.
-	Size   int64  // -1 if unknown
+	Digest    digest.Digest // "" if unknown.
+	Size      int64         // -1 if unknown
+	MediaType string        // "" if unknown.
+	URLs      []string      // Alternative locations of the blob, e.g. for foreign layers; nil if unknown or none.
+	// CompressionOperation is the compression operation applied to the original blob while copying it.
+	// It is used in ManifestUpdateOptions.LayerInfos, to update the MIME types of layers, and may be set by ImageDestination.PutBlob.
+	CompressionOperation LayerCompression
+	// CompressionAlgorithm is the name of the compression algorithm (e.g. GzipCompression) used to compress the blob
+	// if CompressionOperation == Compress, of the algorithm the original blob was compressed with if CompressionOperation == Decompress,
+	// or of the algorithm the unmodified blob is compressed with if CompressionOperation == PreserveOriginal;
+	// "" if unknown, not applicable, or if the blob is not compressed.  Like CompressionOperation, it is used in ManifestUpdateOptions.LayerInfos
+	// to update the MIME types of layers.
+	CompressionAlgorithm string
+	// Annotations to record in the layer’s descriptor, if the manifest format supports them; nil if none.
+	// Like CompressionOperation, it is used in ManifestUpdateOptions.LayerInfos.
+	Annotations map[string]string
+	// DigestVerified is only used in the inputInfo parameter of ImageDestination.PutBlob; see there.
+	DigestVerified bool
 }
 
+// BICTransportScope encapsulates transport-dependent representation of a “scope” where blobs are or are not present.
+// BlobInfoCache.RecordKnownLocation / BlobInfoCache.CandidateLocations record data about blobs keyed by (scope, digest).
+// The scope will typically be similar to an ImageReference, or a superset of it within which blobs are reusable.
+type BICTransportScope struct {
+	Opaque string
+}
+
+// BICLocationReference encapsulates transport-dependent representation of a blob location within a BICTransportScope.
+// Each transport can store arbitrary data using BlobInfoCache.RecordKnownLocation, and ImageSource/ImageDestination
+// implementations can recover it using BlobInfoCache.CandidateLocations.
+type BICLocationReference struct {
+	Opaque string
+}
+
+// BICReplacementCandidate is an item returned by BlobInfoCache.CandidateLocations.
+type BICReplacementCandidate struct {
+	Digest   digest.Digest
+	Location BICLocationReference
+}
+
+// BlobInfoCache records data useful for locating and reusing blobs.
+// All methods are best-effort: failures are not reported, and the cache may forget any recorded data at any time.
+// Implementations must be safe for concurrent use.
+type BlobInfoCache interface {
+	// UncompressedDigest returns an uncompressed digest corresponding to anyDigest.
+	// May return anyDigest if it is known to be uncompressed.
+	// Returns "" if nothing is known about the digest (it may be compressed or uncompressed).
+	UncompressedDigest(anyDigest digest.Digest) digest.Digest
+	// RecordDigestUncompressedPair records that the uncompressed version of anyDigest is uncompressed.
+	// It’s allowed for anyDigest == uncompressed.
+	RecordDigestUncompressedPair(anyDigest digest.Digest, uncompressed digest.Digest)
+	// RecordKnownLocation records that a blob with the specified digest exists within the specified (transport, scope),
+	// and can be found using the specified location reference.
+	RecordKnownLocation(transport ImageTransport, scope BICTransportScope, digest digest.Digest, location BICLocationReference)
+	// CandidateLocations returns the locations recorded for the blob with the specified digest within (transport, scope),
+	// most recently recorded first.
+	// If !canSubstitute, the returned candidates all match the submitted digest exactly; if canSubstitute,
+	// data from previous RecordDigestUncompressedPair calls is used to also return locations of other blobs
+	// with the same uncompressed contents, after the exact matches.
+	CandidateLocations(transport ImageTransport, scope BICTransportScope, digest digest.Digest, canSubstitute bool) []BICReplacementCandidate
+}
+
+// LayerCompression indicates if layers must be compressed, decompressed or preserved
+type LayerCompression int
+
+const (
+	// PreserveOriginal indicates the layer must be preserved, ie
+	// no compression or decompression.
+	PreserveOriginal LayerCompression = iota
+	// Decompress indicates the layer must be decompressed
+	Decompress
+	// Compress indicates the layer must be compressed
+	Compress
+)
+
+// Names of compression algorithms, as used in BlobInfo.CompressionAlgorithm.
+const (
+	GzipCompression  = "gzip"
+	Bzip2Compression = "bzip2"
+	XzCompression    = "xz"
+	ZstdCompression  = "zstd"
+	// ZstdChunkedCompression is zstd compression with each file stored in separate frames, and a table of contents
+	// recorded in BlobInfo.Annotations, so that consumers can fetch individual files.  It is only used for Compress.
+	ZstdChunkedCompression = "zstd:chunked"
+)
+
 // ImageSource is a service, possibly remote (= slow), to download components of a single image.
 // This is primarily useful for copying images around; for examining their properties, Image (below)
 // is usually more useful.
@@ -110,14 +196,157 @@ type ImageSource interface {
 	Close()
 	// GetManifest returns the image's manifest along with its MIME type (which may be empty when it can't be determined but the manifest is available).
 	// It may use a remote (= slow) service.
-	GetManifest() ([]byte, string, error)
-	// GetTargetManifest returns an image's manifest given a digest. This is mainly used to retrieve a single image's manifest
-	// out of a manifest list.
-	GetTargetManifest(digest string) ([]byte, string, error)
+	// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve (when the primary manifest is a manifest list);
+	// this never happens if the primary manifest is not a manifest list (e.g. if the source never returns manifest lists).
+	GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error)
+	// HasThreadSafeGetBlob indicates whether GetBlob can be executed concurrently.
+	HasThreadSafeGetBlob() bool
 	// GetBlob returns a stream for the specified blob, and the blob’s size (or -1 if unknown).
-	GetBlob(digest string) (io.ReadCloser, int64, error)
+	// The Digest field in BlobInfo is guaranteed to be provided; Size may be -1, and MediaType and URLs may be empty.
+	// cache records and provides known locations of blobs; it MUST NOT be nil, use blobinfocache.NoCache if no caching is desired.
+	GetBlob(ctx context.Context, info BlobInfo, cache BlobInfoCache) (io.ReadCloser, int64, error)
 	// GetSignatures returns the image's signatures.  It may use a remote (= slow) service.
-	GetSignatures() ([][]byte, error)
+	// If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve signatures for
+	// (when the primary manifest is a manifest list); this never happens if the primary manifest is not a manifest list
+	// (e.g. if the source never returns manifest lists).
+	GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error)
+}
+
+// SigstoreSignature is a signature in the sigstore (cosign) format: a signed payload, and a signature of that payload.
+// Unlike the signatures returned by ImageSource.GetSignatures, these are not self-contained and
+// are stored as separate OCI artifacts next to the image.
+type SigstoreSignature struct {
+	Payload   []byte // The signed payload, a JSON document of the "cosign container image signature" type
+	Signature []byte // The raw (not base64-encoded) signature of Payload
+
+	// The fields below are only set for signatures which include them, e.g. “keyless” signatures using Fulcio certificates.
+	Certificate      []byte // A PEM-encoded certificate of the key which created Signature
+	CertificateChain []byte // PEM-encoded intermediate certificates leading from Certificate to a trusted CA
+	RekorBundle      []byte // A JSON-encoded Rekor transparency log bundle, containing a signed entry timestamp (SET)
+}
+
+// SigstoreSignaturesSource is an optional interface of ImageSource, implemented by sources which can read sigstore signatures.
+type SigstoreSignaturesSource interface {
+	// GetSigstoreSignatures returns the image's sigstore signatures.  It may use a remote (= slow) service.
+	GetSigstoreSignatures(ctx context.Context) ([]SigstoreSignature, error)
+}
+
+// SigstoreSignaturesDestination is an optional interface of ImageDestination, implemented by destinations which can store sigstore signatures.
+type SigstoreSignaturesDestination interface {
+	// PutSigstoreSignatures writes sigstore signatures of the manifest written by PutManifest, replacing any previously written
+	// by this destination.  It must be called after PutManifest, and the signatures must be usable by GetSigstoreSignatures
+	// of an ImageSource for the same reference after Commit.
+	PutSigstoreSignatures(ctx context.Context, signatures []SigstoreSignature) error
+}
+
+// Attestation is a signed in-toto attestation about an image, e.g. SLSA build provenance: a DSSE envelope containing
+// an in-toto statement.  Like sigstore signatures, attestations are stored as separate OCI artifacts next to the image.
+type Attestation struct {
+	Envelope []byte // The JSON-encoded DSSE envelope
+}
+
+// AttestationsSource is an optional interface of ImageSource, implemented by sources which can read attestations.
+type AttestationsSource interface {
+	// GetAttestations returns the attestations attached to the image.  It may use a remote (= slow) service.
+	GetAttestations(ctx context.Context) ([]Attestation, error)
+}
+
+// SignatureFormat identifies the format of a Signature.
+type SignatureFormat string
+
+const (
+	// SimpleSigningFormat is the “simple signing” format: a self-contained signature blob, as returned by ImageSource.GetSignatures.
+	SimpleSigningFormat SignatureFormat = "simple-signing"
+	// SigstoreFormat is the sigstore (cosign) format, stored as an OCI artifact next to the image.
+	SigstoreFormat SignatureFormat = "sigstore"
+)
+
+// Signature is an image signature in any of the supported formats.
+type Signature struct {
+	Format        SignatureFormat
+	SimpleSigning []byte            // The signature blob; only valid if Format == SimpleSigningFormat
+	Sigstore      SigstoreSignature // The signature; only valid if Format == SigstoreFormat
+}
+
+// SignaturesWithFormatSource is an optional interface of ImageSource, implemented by sources which can read signatures
+// in several formats.  Use image.GetSignaturesWithFormat to read signatures with their format from any ImageSource.
+type SignaturesWithFormatSource interface {
+	// GetSignaturesWithFormat returns the image's signatures in all formats supported by the source, i.e. the union of
+	// GetSignatures and, if implemented, SigstoreSignaturesSource.GetSigstoreSignatures.  It may use a remote (= slow) service.
+	GetSignaturesWithFormat(ctx context.Context) ([]Signature, error)
+}
+
+// ManifestDigestSource is an optional interface of ImageSource, implemented by sources which can cheaply check whether
+// the image exists, and determine the digest of its manifest, without downloading the manifest.
+type ManifestDigestSource interface {
+	// GetManifestDigest returns the digest of the manifest which would be returned by GetManifest.  It may use a remote (= slow) service.
+	GetManifestDigest(ctx context.Context) (digest.Digest, error)
+	// Exists returns true if the image's manifest exists.  It returns (false, nil) if the manifest does not exist;
+	// it returns a non-nil error only on an unexpected failure.  It may use a remote (= slow) service.
+	Exists(ctx context.Context) (bool, error)
+}
+
+// ImageReferrer describes an artifact (e.g. a signature or an attestation) whose manifest refers to an image's manifest
+// using its "subject" field, as defined by the OCI distribution specification.
+type ImageReferrer struct {
+	MediaType    string            // The MIME type of the artifact manifest
+	Digest       digest.Digest     // The digest of the artifact manifest
+	Size         int64             // The size of the artifact manifest
+	ArtifactType string            // The type of the artifact, e.g. "application/vnd.dev.cosign.artifact.sig.v1+json"
+	Annotations  map[string]string // The annotations of the artifact manifest, if any
+}
+
+// ReferrersSource is an optional interface of ImageSource, implemented by sources which can list artifacts referring to an image.
+type ReferrersSource interface {
+	// GetReferrers returns the artifacts referring to the manifest with manifestDigest; if artifactType is not "",
+	// only artifacts of that type are returned.  It may use a remote (= slow) service.
+	GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]ImageReferrer, error)
+}
+
+// ReferrersDestination is an optional interface of ImageDestination, implemented by destinations which can store
+// artifacts referring to an image.
+type ReferrersDestination interface {
+	// PutReferrer stores artifactManifest, an OCI image manifest with a "subject" field, so that it is returned by
+	// ReferrersSource.GetReferrers for the subject manifest.  The blobs referenced by artifactManifest must already
+	// have been stored using PutBlob.
+	PutReferrer(ctx context.Context, artifactManifest []byte) error
+}
+
+// LayerOrderDestination is an optional interface of ImageDestination, implemented by destinations which can start
+// processing layers before the manifest is available if they know how the layers are stacked (e.g. to apply each
+// layer as soon as the layers below it have been applied).
+type LayerOrderDestination interface {
+	// NoteLayerOrder is called before any layers are passed to TryReusingBlob or PutBlob, with the layers of the image
+	// in the order they are stacked.  The layers eventually stored may still differ (e.g. if they are compressed while
+	// copying); the destination must then fall back to using the manifest in Commit.
+	NoteLayerOrder(layers []BlobInfo)
+}
+
+// ImageSourceChunk is a portion of a blob.
+type ImageSourceChunk struct {
+	Offset uint64
+	Length uint64
+}
+
+// ImageSourceSeekable is an optional interface of ImageSource, implemented by sources which can read portions of blobs,
+// e.g. so that destinations which understand chunked layer formats can fetch only the files they need.
+type ImageSourceSeekable interface {
+	// GetBlobAt returns streams for the specified chunks of the blob, in the order of chunks; each stream is delivered on the first
+	// returned channel only after the previous one has been closed by the caller.  An error encountered after GetBlobAt returns
+	// is delivered on the second channel.  Both channels are closed after all chunks, or an error, have been delivered.
+	// If the source can not read portions of this blob at all, GetBlobAt fails, and the caller should use ImageSource.GetBlob instead.
+	// The Digest field in BlobInfo is guaranteed to be provided, Size may be -1.
+	GetBlobAt(ctx context.Context, info BlobInfo, chunks []ImageSourceChunk) (chan io.ReadCloser, chan error, error)
+}
+
+// LayerInfosForCopySource is an optional interface of ImageSource, implemented by sources which do not store layers in their
+// original form (e.g. only as an uncompressed filesystem diff), so that GetBlob does not return data matching the manifest.
+type LayerInfosForCopySource interface {
+	// LayerInfosForCopy returns the layers of the image, in the order they are applied, as actually returned by GetBlob, or nil
+	// if they match the manifest.  CompressionOperation and CompressionAlgorithm describe the changes relative to the layers
+	// referenced by the manifest, e.g. Decompress if GetBlob returns uncompressed versions of compressed layers.
+	// Copying an image using these layers requires updating the manifest, which invalidates any signatures.
+	LayerInfosForCopy(ctx context.Context) ([]BlobInfo, error)
 }
 
 // ImageDestination is a service, possibly remote (= slow), to store components of a single image.
@@ -140,46 +369,112 @@ type ImageDestination interface {
 	SupportedManifestMIMETypes() []string
 	// SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
 	// Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
-	SupportsSignatures() error
-	// ShouldCompressLayers returns true iff it is desirable to compress layer blobs written to this destination.
-	ShouldCompressLayers() bool
+	SupportsSignatures(ctx context.Context) error
+	// DesiredLayerCompression indicates the kind of compression to apply on layers written to this destination.
+	DesiredLayerCompression() LayerCompression
+	// HasThreadSafePutBlob indicates whether PutBlob can be executed concurrently.
+	// TryReusingBlob is expected to be thread-safe if PutBlob is.
+	HasThreadSafePutBlob() bool
 
 	// PutBlob writes contents of stream and returns data representing the result (with all data filled in).
 	// inputInfo.Digest can be optionally provided if known; it is not mandatory for the implementation to verify it.
+	// If inputInfo.DigestVerified, the caller guarantees that stream.Read() fails at the end of input if the data does not match inputInfo.Digest,
+	// so the implementation may use inputInfo.Digest instead of computing the digest again.
 	// inputInfo.Size is the expected length of stream, if known.
+	// isConfig is true if the blob is the image config (and not a layer); destinations which store the config separately from layers can use it.
+	// The returned BlobInfo always contains Digest and Size; MediaType and CompressionOperation, if set, describe the blob as actually stored,
+	// otherwise the caller assumes they match inputInfo.
 	// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
 	// to any other readers for download using the supplied digest.
 	// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
-	PutBlob(stream io.Reader, inputInfo BlobInfo) (BlobInfo, error)
+	PutBlob(ctx context.Context, stream io.Reader, inputInfo BlobInfo, isConfig bool) (BlobInfo, error)
+	// TryReusingBlob checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+	// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+	// info.Digest must not be empty.
+	// If canSubstitute, TryReusingBlob can use an equivalent of the desired blob (e.g. a compressed or uncompressed version of it);
+	// in that case the returned info may not match the input.
+	// If the blob has been successfully reused, returns (true, info, nil); info must contain at least a digest and size.
+	// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+	// cache MUST NOT be nil, use blobinfocache.NoCache if no caching is desired; the implementation may both use and update it.
+	TryReusingBlob(ctx context.Context, info BlobInfo, cache BlobInfoCache, canSubstitute bool) (bool, BlobInfo, error)
+	// PutManifest writes manifest to the destination.
+	// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for (when the primary manifest is a manifest list);
+	// this should always be nil if the primary manifest is not a manifest list.
+	// It is expected but not enforced that the instanceDigest, when specified, matches the digest of `manifest` as generated by manifest.Digest.
+	// Transports which do not support manifest lists return an error if instanceDigest is not nil.
+	// If the destination is in principle available, refuses this manifest type (e.g. a registry which does not accept schema2 manifests),
+	// but may accept a different manifest type, the returned error must be a ManifestTypeRejectedError.
 	// FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
-	PutManifest([]byte) error
-	PutSignatures(signatures [][]byte) error
+	PutManifest(ctx context.Context, manifest []byte, instanceDigest *digest.Digest) error
+	// PutSignatures writes a set of signatures to the destination.
+	// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the signatures for
+	// (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+	PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error
 	// Commit marks the process of storing the image as successful and asks for the image to be persisted.
+	// When storing a manifest list, the instance manifests should be written using PutManifest with their instanceDigest
+	// before the list itself is written with a nil instanceDigest; Commit then persists the list together with the instances.
 	// WARNING: This does not have any transactional semantics:
 	// - Uploaded data MAY be visible to others before Commit() is called
 	// - Uploaded data MAY be removed or MAY remain around if Close() is called without Commit() (i.e. rollback is allowed but not guaranteed)
-	Commit() error
+	Commit(ctx context.Context) error
+}
+
+// ManifestTypeRejectedError is returned by ImageDestination.PutManifest if the destination is in principle available,
+// refuses specifically this manifest type, but may accept a different manifest type.
+// Use errors.As to check for it; the caller can retry after converting the manifest to a different format.
+type ManifestTypeRejectedError struct { // We only use a struct to allow a type assertion, without limiting the contents of the error otherwise.
+	Err error
+}
+
+func (e ManifestTypeRejectedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e ManifestTypeRejectedError) Unwrap() error {
+	return e.Err
 }
 
 // UnparsedImage is an Image-to-be; until it is verified and accepted, it only caries its identity and caches manifest and signature blobs.
 // Thus, an UnparsedImage can be created from an ImageSource simply by fetching blobs without interpreting them,
 // allowing cryptographic signature verification to happen first, before even fetching the manifest, or parsing anything else.
 // This also makes the UnparsedImage→Image conversion an explicitly visible step.
-// Each UnparsedImage should eventually be closed by calling Close().
+// UnparsedImage does not provide a Close method; whoever created it is responsible for releasing the underlying resources
+// (e.g. by calling image.UnparsedImage.Close).
 type UnparsedImage interface {
 	// Reference returns the reference used to set up this source, _as specified by the user_
 	// (not as the image itself, or its underlying storage, claims).  This can be used e.g. to determine which public keys are trusted for this image.
 	Reference() ImageReference
-	// Close removes resources associated with an initialized UnparsedImage, if any.
-	Close()
 	// Manifest is like ImageSource.GetManifest, but the result is cached; it is OK to call this however often you need.
-	Manifest() ([]byte, string, error)
+	Manifest(ctx context.Context) ([]byte, string, error)
 	// Signatures is like ImageSource.GetSignatures, but the result is cached; it is OK to call this however often you need.
-	Signatures() ([][]byte, error)
+	Signatures(ctx context.Context) ([][]byte, error)
+}
+
+// SigstoreSignaturesImage is an optional interface of UnparsedImage, implemented by images which can provide sigstore signatures.
+type SigstoreSignaturesImage interface {
+	// SigstoreSignatures is like SigstoreSignaturesSource.GetSigstoreSignatures, but the result is cached; it is OK to call this however often you need.
+	// It returns an empty list if the underlying ImageSource does not support sigstore signatures.
+	SigstoreSignatures(ctx context.Context) ([]SigstoreSignature, error)
+}
+
+// AttestationsImage is an optional interface of UnparsedImage, implemented by images which can provide attestations.
+type AttestationsImage interface {
+	// Attestations is like AttestationsSource.GetAttestations, but the result is cached; it is OK to call this however often you need.
+	// It returns an empty list if the underlying ImageSource does not support attestations.
+	Attestations(ctx context.Context) ([]Attestation, error)
+}
+
+// SignaturesWithFormatImage is an optional interface of UnparsedImage, implemented by images which can provide signatures
+// in several formats.
+type SignaturesWithFormatImage interface {
+	// SignaturesWithFormat is like SignaturesWithFormatSource.GetSignaturesWithFormat, but the result is cached;
+	// it is OK to call this however often you need.
+	SignaturesWithFormat(ctx context.Context) ([]Signature, error)
 }
 
 // Image is the primary API for inspecting properties of images.
-// Each Image should eventually be closed by calling Close().
+// Image does not provide a Close method either; see ImageCloser for images which own their resources.
 type Image interface {
 	// Note that Reference may return nil in the return value of UpdatedImage!
 	UnparsedImage
@@ -188,13 +483,13 @@ type Image interface {
 	ConfigInfo() BlobInfo
 	// ConfigBlob returns the blob described by ConfigInfo, iff ConfigInfo().Digest != ""; nil otherwise.
 	// The result is cached; it is OK to call this however often you need.
-	ConfigBlob() ([]byte, error)
+	ConfigBlob(ctx context.Context) ([]byte, error)
 	// LayerInfos returns a list of BlobInfos of layers referenced by this image, in order (the root layer first, and then successive layered layers).
 	// The Digest field is guaranteed to be provided; Size may be -1.
 	// WARNING: The list may contain duplicates, and they are semantically relevant.
 	LayerInfos() []BlobInfo
 	// Inspect returns various information for (skopeo inspect) parsed from the manifest and configuration.
-	Inspect() (*ImageInspectInfo, error)
+	Inspect(ctx context.Context) (*ImageInspectInfo, error)
 	// UpdatedImageNeedsLayerDiffIDs returns true iff UpdatedImage(options) needs InformationOnly.LayerDiffIDs.
 	// This is a horribly specific interface, but computing InformationOnly.LayerDiffIDs can be very expensive to compute
 	// (most importantly it forces us to download the full layers even if they are already present at the destination).
@@ -202,15 +497,28 @@ type Image interface {
 	// UpdatedImage returns a types.Image modified according to options.
 	// Everything in options.InformationOnly should be provided, other fields should be set only if a modification is desired.
 	// This does not change the state of the original Image object.
-	UpdatedImage(options ManifestUpdateOptions) (Image, error)
+	UpdatedImage(ctx context.Context, options ManifestUpdateOptions) (Image, error)
 	// IsMultiImage returns true if the image's manifest is a list of images, false otherwise.
 	IsMultiImage() bool
 }
 
+// ImageCloser is an Image which owns the resources it was created from, notably the ImageSource,
+// and releases them when closed.  It is returned by ImageReference.NewImage and image.FromSource.
+// Each ImageCloser should eventually be closed by calling Close().
+type ImageCloser interface {
+	Image
+	// Close removes resources associated with an initialized ImageCloser, including the underlying ImageSource.
+	Close()
+}
+
 // ManifestUpdateOptions is a way to pass named optional arguments to Image.UpdatedManifest
 type ManifestUpdateOptions struct {
 	LayerInfos       []BlobInfo // Complete BlobInfos (size+digest) which should replace the originals, in order (the root layer first, and then successive layered layers)
 	ManifestMIMEType string
+	// ComputeMissingLayerDiffIDs allows UpdatedImage to compute InformationOnly.LayerDiffIDs, if they are needed and not provided,
+	// by reading all layers of the original image.  This can be very expensive, and is only supported for images which have a
+	// types.ImageSource (e.g. those returned by ImageReference.NewImage).
+	ComputeMissingLayerDiffIDs bool
 	// The values below are NOT requests to modify the image; they provide optional context which may or may not be used.
 	InformationOnly ManifestUpdateInformation
 }
@@ -220,7 +528,15 @@ type ManifestUpdateOptions struct {
 type ManifestUpdateInformation struct {
 	Destination  ImageDestination // and yes, UpdatedManifest may write to Destination (see the schema2 → schema1 conversion logic in image/docker_schema2.go)
 	LayerInfos   []BlobInfo       // Complete BlobInfos (size+digest) which have been uploaded, in order (the root layer first, and then successive layered layers)
-	LayerDiffIDs []string         // Digest values for the _uncompressed_ contents of the blobs which have been uploaded, in the same order.
+	LayerDiffIDs []digest.Digest  // Digest values for the _uncompressed_ contents of the blobs which have been uploaded, in the same order.
+	Logger       Logger           // If not nil, receives diagnostic messages about the conversion, instead of the global logrus logger.
+}
+
+// PruneResult describes the blobs of a storage location which are not referenced by any of the images it contains,
+// as found by the Prune functions of transports which support it (e.g. those of the dir: and oci: transports).
+type PruneResult struct {
+	Blobs []digest.Digest // The unreferenced blobs, sorted
+	Size  int64           // The total size of the files removed: the space reclaimed (or, in a dry run, the space which would be reclaimed)
 }
 
 // ImageInspectInfo is a set of metadata describing Docker images, primarily their manifest and configuration.
@@ -242,6 +558,92 @@ type DockerAuthConfig struct {
 	Password string
 }
 
+// DockerRateLimit describes the rate limit of a registry, as reported in the RateLimit-Limit and RateLimit-Remaining
+// response headers (used e.g. by Docker Hub).
+type DockerRateLimit struct {
+	Limit     int64         // The number of requests allowed in Window
+	Remaining int64         // The number of requests remaining in the current window
+	Window    time.Duration // The length of the window, or 0 if not reported
+}
+
+// OptionalBool is a boolean with an additional undefined value, which is meant
+// to be used in the context of user input to distinguish between a
+// user-specified value and a default value.
+type OptionalBool byte
+
+const (
+	// OptionalBoolUndefined indicates that the OptionalBoolean hasn't been written.
+	OptionalBoolUndefined OptionalBool = iota
+	// OptionalBoolTrue represents the boolean true.
+	OptionalBoolTrue
+	// OptionalBoolFalse represents the boolean false.
+	OptionalBoolFalse
+)
+
+// NewOptionalBool converts the input bool into either OptionalBoolTrue or
+// OptionalBoolFalse.  The function is meant to avoid boilerplate code of users.
+func NewOptionalBool(b bool) OptionalBool {
+	o := OptionalBoolFalse
+	if b {
+		o = OptionalBoolTrue
+	}
+	return o
+}
+
+// ShortNameMode defines how image names which do not specify a registry ("short names") are resolved.
+type ShortNameMode int
+
+const (
+	// ShortNameModeUndefined indicates that the short-name-mode configured in registries.conf should be used.
+	ShortNameModeUndefined ShortNameMode = iota
+	// ShortNameModeDisabled ignores short-name aliases, and tries all unqualified-search registries in order.
+	ShortNameModeDisabled
+	// ShortNameModePermissive uses a short-name alias if one is configured, and otherwise tries all unqualified-search registries in order.
+	ShortNameModePermissive
+	// ShortNameModeEnforcing uses a short-name alias if one is configured, and otherwise requires the short name to resolve
+	// to a single registry.
+	ShortNameModeEnforcing
+)
+
+// Logger receives diagnostic messages from the library, e.g. to include them in the logs of an application embedding it.
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	// Debugf records a message useful for debugging, formatted as by fmt.Sprintf.
+	Debugf(format string, args ...interface{})
+	// Warnf records a message about an unexpected situation which does not cause an operation to fail, formatted as by fmt.Sprintf.
+	Warnf(format string, args ...interface{})
+}
+
+// Tracer records the duration of operations as spans, e.g. by forwarding them to OpenTelemetry, so that users can see where
+// the time of an operation (such as copying an image) goes.  The library does not depend on any tracing implementation.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span named name with attributes, as a child of the span in ctx, if any, and returns a context containing the new span,
+	// which should be used for the operation covered by the span, and the span itself.
+	Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span)
+}
+
+// Span is an operation in progress, started by Tracer.Start.
+type Span interface {
+	// End marks the operation as finished; err is the error which caused it to fail, or nil if it succeeded.
+	End(err error)
+}
+
+// Metrics receives statistics about registry requests and blob transfers, e.g. to export them as Prometheus metrics
+// without wrapping the readers and writers used by the library.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// RegistryRequest records an HTTP request using method to registry (a host[:port]), which received a response with statusCode
+	// (or 0 if no response was received) after duration.  duration does not include reading the response body.
+	RegistryRequest(registry, method string, statusCode int, duration time.Duration)
+	// RegistryRetry records that a request to registry is being retried, e.g. after being rate-limited or to resume an interrupted blob download.
+	RegistryRetry(registry string)
+	// BlobCopied records that the blob with blobDigest was copied, reading downloaded bytes from the source and writing uploaded bytes to the destination.
+	BlobCopied(blobDigest digest.Digest, downloaded, uploaded int64)
+	// BlobReused records that copying the blob with blobDigest was skipped, because the destination already contained it or an equivalent blob.
+	BlobReused(blobDigest digest.Digest)
+}
+
 // SystemContext allows parametrizing access to implicitly-accessed resources,
 // like configuration files in /etc and users' login state in their home directory.
 // Various components can share the same field only if their semantics is exactly
@@ -261,12 +663,123 @@ type SystemContext struct {
 	SignaturePolicyPath string
 	// If not "", overrides the system's default path for registries.d (Docker signature storage configuration)
 	RegistriesDirPath string
+	// If not "", overrides the system's default path for registries.conf (Docker registry access configuration)
+	SystemRegistriesConfPath string
+	// If not ShortNameModeUndefined, overrides the short-name-mode configured in registries.conf.
+	ShortNameMode ShortNameMode
+	// If not "", overrides the default paths of the authentication file (e.g. an auth.json file) containing registry credentials,
+	// read by the docker transport and written by pkg/docker/config.  By default, $XDG_RUNTIME_DIR/containers/auth.json
+	// (if XDG_RUNTIME_DIR is set, as for rootless users) and ~/.docker/config.json are both read, and the former is written.
+	AuthFilePath string
+	// If not "", the directory used for large temporary files, e.g. blobs staged before being committed
+	// or archives copied before being read; the default is /var/tmp.
+	BigFilesTemporaryDir string
+	// If not "", overrides the use of runtime.GOARCH when choosing an image from a manifest list,
+	// and when recording the platform of a synthesized image configuration.
+	ArchitectureChoice string
+	// If not "", overrides the use of runtime.GOOS when choosing an image from a manifest list,
+	// and when recording the platform of a synthesized image configuration.
+	OSChoice string
+	// If not "", only images with this platform variant (e.g. "v7" for arm) are chosen from a manifest list.
+	VariantChoice string
+	// If not 0, the maximum size of an image config blob which will be read into memory; the default is 4 MiB.
+	MaxConfigBlobSize int64
+	// If not 0, the maximum size of a manifest which will be read into memory from a registry; the default is 4 MiB.
+	MaxManifestSize int64
+	// If not nil, receives the diagnostic messages of operations using this SystemContext, instead of the global logrus logger.
+	Logger Logger
+	// If not nil, records spans for operations using this SystemContext, e.g. manifest and blob transfers.
+	Tracer Tracer
+	// If not nil, receives statistics about registry requests and blob transfers of operations using this SystemContext.
+	Metrics Metrics
+
+	// === dir.Transport overrides ===
+	// How layers written to dir: destinations are stored: PreserveOriginal (the default) stores them as provided,
+	// Decompress stores them uncompressed (e.g. for deduplicating tools), Compress stores them gzip-compressed (e.g. for pushing them later).
+	DirLayerCompression LayerCompression
 
 	// === docker.Transport overrides ===
-	DockerCertPath              string // If not "", a directory containing "cert.pem" and "key.pem" used when talking to a Docker Registry
-	DockerInsecureSkipTLSVerify bool   // Allow contacting docker registries over HTTP, or HTTPS with failed TLS verification. Note that this does not affect other TLS connections.
+	DockerCertPath string // If not "", a directory containing "cert.pem" and "key.pem" used when talking to a Docker Registry
+	// Allow contacting docker registries over HTTP, or HTTPS with failed TLS verification. Note that this does not affect other TLS connections.
+	// If OptionalBoolUndefined, this is decided per registry, using the "insecure" settings in registries.conf;
+	// OptionalBoolTrue and OptionalBoolFalse override registries.conf for all registries.
+	DockerInsecureSkipTLSVerify OptionalBool
+	// If not "", overrides the system's default path (/etc/docker/certs.d) for the per-registry TLS configuration directory,
+	// which contains a subdirectory for each registry host[:port], with *.crt CA certificates and *.cert/*.key client certificate pairs.
+	// Not used if DockerCertPath is set.
+	DockerPerHostCertDirPath string
 	// if nil, the library tries to parse ~/.docker/config.json to retrieve credentials
 	DockerAuthConfig *DockerAuthConfig
-	// if not "", an User-Agent header is added to each request when contacting a registry.
+	// If true, and DockerAuthConfig and DockerAuthSecret are nil, credentials stored in the kernel keyring (see pkg/docker/config.SetCredentials)
+	// are used in preference to ~/.docker/config.json.
+	DockerUseKernelKeyring bool
+	// If not nil, and DockerAuthConfig is nil, registry credentials are read from this value instead of ~/.docker/config.json:
+	// the contents of a kubernetes.io/dockerconfigjson (.dockerconfigjson) or kubernetes.io/dockercfg (.dockercfg) secret,
+	// or a Kubernetes Secret object of one of these types, in JSON.
+	DockerAuthSecret []byte
+	// If not nil, called to obtain credentials whenever they are needed to authenticate to a registry, instead of using
+	// DockerAuthConfig or any stored credentials; this allows using short-lived credentials.
+	// registry is the registry host name as used in image references (e.g. "docker.io"), repository is the repository
+	// within the registry (e.g. "library/busybox").  The callback may return empty strings to access the registry anonymously.
+	DockerCredentialsCallback func(registry, repository string) (username, password string, err error)
+	// If not "", the User-Agent header sent with each request when contacting a registry (or its token service);
+	// otherwise a default identifying this library and its version is used.
 	DockerRegistryUserAgent string
+	// Mirrors to try, in order, before the registry itself when pulling images, indexed by the registry host name
+	// as used in image references (e.g. "docker.io"); each mirror is a host name, optionally with a port.
+	DockerRegistryMirrors map[string][]string
+	// If not nil, the proxy used for all connections to registries (and their token services), instead of the proxy
+	// configured by the $HTTP_PROXY, $HTTPS_PROXY and $NO_PROXY environment variables.
+	DockerProxyURL *url.URL
+	// If true, and DockerProxyURL is nil, registries are contacted directly, ignoring the proxy environment variables.
+	DockerDisableProxy bool
+	// If not nil, used to open all network connections to registries (and their token services, or the proxy), instead of
+	// a default net.Dialer; e.g. to connect through a SOCKS proxy or a service mesh sidecar, or to use a custom DNS resolver
+	// by passing the DialContext method of a net.Dialer with Resolver set.  HTTP connections made using it are not shared with other SystemContexts.
+	DockerDialContext func(ctx context.Context, network, address string) (net.Conn, error)
+	// If true, registries are only contacted using HTTP/1.1.  Otherwise HTTP/2 is used with registries which offer it
+	// (falling back to HTTP/1.1 if the registry turns out to misbehave over HTTP/2).
+	DockerDisableHTTP2 bool
+	// The maximum total time to wait before retrying a request which a registry rejected because of rate limiting
+	// (HTTP 429 Too Many Requests, honoring the Retry-After header); 0 means the default of 1 minute, a negative value disables retrying.
+	DockerMaxRateLimitWait time.Duration
+	// If not nil, called (possibly concurrently) with the rate limit reported by a registry, whenever a response includes
+	// the RateLimit-Limit and RateLimit-Remaining headers; registry is the host name of the registry (or mirror) which was contacted.
+	DockerRateLimitCallback func(registry string, limit DockerRateLimit)
+
+	// === docker/archive.Transport overrides ===
+	// If true, docker-archive: destinations also write the legacy "repositories" file and the v1-style per-layer
+	// <id>/{json,VERSION,layer.tar} entries, as required by old Docker daemons and some third-party loaders.
+	DockerArchiveLegacyLayout bool
+
+	// === docker/daemon.Transport overrides ===
+	// If not "", the address of the Docker daemon, e.g. "unix:///var/run/docker.sock", "tcp://192.168.1.1:2376" or
+	// "npipe:////./pipe/docker_engine"; otherwise $DOCKER_HOST, or the platform's default.
+	DockerDaemonHost string
+	// If not "", the Docker engine API version to use, e.g. "1.24"; otherwise $DOCKER_API_VERSION, or "1.22".
+	DockerDaemonAPIVersion string
+	// If not "", a directory containing "ca.pem", "cert.pem" and "key.pem" used when talking to the Docker daemon over TCP;
+	// otherwise $DOCKER_CERT_PATH, if set.
+	DockerDaemonCertPath string
+	// If true, the Docker daemon's TLS certificate is not verified.  This only has an effect if a certificate directory is configured.
+	DockerDaemonInsecureSkipTLSVerify bool
+
+	// === oci.Transport overrides ===
+	// If not "", a directory of blobs shared between OCI layouts, organized like the "blobs" directory of a layout
+	// (<algorithm>/<encoded digest>; the "blobs" directory of another layout can be used directly).  Blobs written to an OCI layout
+	// are hardlinked from this directory if it already contains them (or reflinked, on filesystems which support it,
+	// if a hardlink is not possible), instead of being copied again.  The directory is never modified, and its contents are trusted
+	// to match their digests.
+	OCISharedBlobDirPath string
+
+	// === s3.Transport overrides ===
+	// If not "", the URL of the S3-compatible service (e.g. "https://s3.amazonaws.com"); otherwise $S3_ENDPOINT, or Amazon S3.
+	S3Endpoint string
+	// If not "", the region used for signing requests; otherwise $AWS_REGION, or "us-east-1".
+	S3Region string
+	// If not "", the credentials used for signing requests; otherwise $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY.
+	// If no credentials are available, requests are sent anonymously.
+	S3AccessKeyID           string
+	S3SecretAccessKey       string
+	S3InsecureSkipTLSVerify bool // Allow contacting the S3 endpoint over HTTPS with failed TLS verification.
 }